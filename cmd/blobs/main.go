@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"health-bar/shared/envelope"
+	"health-bar/shared/storage"
+	"log"
+	"os"
+
+	"github.com/joho/godotenv"
+)
+
+const usage = `Usage: blobs <command> [arguments]
+
+Commands:
+  copy -from <url> -to <url> [-prefix <prefix>] [-overwrite] [-dry-run]
+                    copy blobs between stores, such as the upload directory
+                    and an S3 bucket; blobs the destination already has with
+                    the same size are skipped, so a copy can be run again
+  list <url> [prefix]
+                    list the blobs in a store
+  encrypt <url> [prefix]
+                    encrypt blobs stored before encryption was enabled
+  rewrap <url> [prefix]
+                    wrap data keys with the current master key, so older
+                    master keys can be retired; content is not re-encrypted
+  genkey <id>       print a new master key to add to a keyring
+
+Stores are named as in BLOB_STORE_URL: a directory, file:///dir or
+s3://key:secret@host/bucket?region=&insecure=true
+
+encrypt and rewrap read master keys from ENCRYPTION_KEYS or the file
+ENCRYPTION_KEY_FILE names, as the prescription service does. Copies are
+made as stored, encrypted or not.
+`
+
+func main() {
+	godotenv.Load()
+
+	flag.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	command, args := flag.Arg(0), flag.Args()[1:]
+	ctx := context.Background()
+
+	switch command {
+	case "copy":
+		flags := flag.NewFlagSet("copy", flag.ExitOnError)
+		flags.Usage = flag.Usage
+		from := flags.String("from", "", "store to copy from")
+		to := flags.String("to", "", "store to copy to")
+		var options storage.CopyOptions
+		flags.StringVar(&options.Prefix, "prefix", "", "only copy keys starting with this")
+		flags.BoolVar(&options.Overwrite, "overwrite", false, "copy blobs the destination already has")
+		flags.BoolVar(&options.DryRun, "dry-run", false, "only report what would be copied")
+		flags.Parse(args)
+		if *from == "" || *to == "" {
+			log.Fatal("-from and -to are required")
+		}
+
+		source, destination := openStore(*from), openStore(*to)
+		options.Progress = func(info storage.Info, copied bool) {
+			if copied {
+				fmt.Printf("copy %s (%d bytes)\n", info.Key, info.Size)
+			}
+		}
+		stats, err := storage.Copy(ctx, source, destination, options)
+		if err != nil {
+			log.Fatal(err)
+		}
+		verb := "Copied"
+		if options.DryRun {
+			verb = "Would copy"
+		}
+		log.Printf("%s %d blobs (%d bytes) from %s to %s, skipped %d already there",
+			verb, stats.Copied, stats.Bytes, storage.Redact(*from), storage.Redact(*to), stats.Skipped)
+
+	case "list":
+		if len(args) < 1 || len(args) > 2 {
+			log.Fatal("a store URL is required")
+		}
+		prefix := ""
+		if len(args) == 2 {
+			prefix = args[1]
+		}
+		blobs, err := openStore(args[0]).List(ctx, prefix)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, blob := range blobs {
+			fmt.Printf("%-60s %10d  %s\n", blob.Key, blob.Size, blob.ModTime.Format("2006-01-02 15:04:05"))
+		}
+
+	case "encrypt", "rewrap":
+		if len(args) < 1 || len(args) > 2 {
+			log.Fatal("a store URL is required")
+		}
+		prefix := ""
+		if len(args) == 2 {
+			prefix = args[1]
+		}
+		keys, err := envelope.FromEnv()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if keys == nil {
+			log.Fatal("ENCRYPTION_KEYS or ENCRYPTION_KEY_FILE is required")
+		}
+		store := storage.NewEncrypted(openStore(args[0]), keys)
+		migrate := store.EncryptPlaintext
+		if command == "rewrap" {
+			migrate = store.Rewrap
+		}
+
+		blobs, err := store.List(ctx, prefix)
+		if err != nil {
+			log.Fatal(err)
+		}
+		changed, failed := 0, 0
+		for _, blob := range blobs {
+			done, err := migrate(ctx, blob.Key)
+			if err != nil {
+				log.Printf("%s: %v", blob.Key, err)
+				failed++
+				continue
+			}
+			if done {
+				fmt.Printf("%s %s\n", command, blob.Key)
+				changed++
+			}
+		}
+		log.Printf("%d of %d blobs needed %s, %d failed", changed, len(blobs), command, failed)
+		if failed > 0 {
+			os.Exit(1)
+		}
+
+	case "genkey":
+		if len(args) != 1 {
+			log.Fatal("a key ID is required")
+		}
+		key, err := envelope.GenerateKey(args[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(key)
+
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+func openStore(rawURL string) storage.BlobStore {
+	store, err := storage.Open(rawURL)
+	if err != nil {
+		log.Fatalf("Failed to open %s: %v", storage.Redact(rawURL), err)
+	}
+	return store
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"health-bar/shared/database"
+	"health-bar/shared/interactions"
+	"log"
+	"os"
+
+	"github.com/joho/godotenv"
+)
+
+const usage = `Usage: interactions <command> [arguments]
+
+Commands:
+  validate <file>   check a dataset file without importing it
+  import <file>     import a dataset file as a new version; services pick
+                    up the newest version without a restart
+  versions          list imported versions, newest first
+`
+
+func main() {
+	godotenv.Load()
+
+	flag.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	command, args := flag.Arg(0), flag.Args()[1:]
+
+	if command == "validate" {
+		_, d := readDataset(args)
+		fmt.Printf("Dataset %s is valid: %d drugs, %d interactions, %d allergy classes\n",
+			d.Version, len(d.Drugs), len(d.Interactions), len(d.AllergyClasses))
+		return
+	}
+
+	db, err := database.Connect(database.Config{
+		Host:     getEnv("DB_HOST", "localhost"),
+		Port:     getEnv("DB_PORT", "5432"),
+		User:     getEnv("DB_USER", "postgres"),
+		Password: getEnv("DB_PASSWORD", "postgres"),
+		DBName:   getEnv("DB_NAME", "healthbar"),
+		SSLMode:  getEnv("DB_SSLMODE", "disable"),
+	})
+	if err != nil {
+		log.Fatal("Failed to connect to database:", err)
+	}
+	defer db.Close()
+
+	store := interactions.NewPostgresStore(db)
+	ctx := context.Background()
+
+	switch command {
+	case "import":
+		data, _ := readDataset(args)
+		d, imported, err := store.Import(ctx, data)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if imported {
+			log.Printf("Imported dataset %s", d.Version)
+		} else {
+			log.Printf("Dataset %s is already imported", d.Version)
+		}
+
+	case "versions":
+		versions, err := store.Versions(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, v := range versions {
+			fmt.Printf("%-20s %s  %s\n", v.Version, v.ImportedAt.Format("2006-01-02 15:04:05"), v.Checksum[:12])
+		}
+
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+// readDataset reads and validates the dataset file named by args.
+func readDataset(args []string) ([]byte, *interactions.Dataset) {
+	if len(args) != 1 {
+		log.Fatal("a dataset file is required")
+	}
+	data, err := os.ReadFile(args[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+	d, err := interactions.Parse(data)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return data, d
+}
+
+func getEnv(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"health-bar/database/migrations"
+	"health-bar/shared/database"
+	"health-bar/shared/database/migrate"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/joho/godotenv"
+)
+
+const usage = `Usage: migrate <command> [arguments]
+
+Commands:
+  up [version]     apply pending migrations (optionally up to version)
+  down [steps]     roll back the last applied migration(s), default 1
+  status           list migrations and whether they are applied
+  create <name>    create a new empty up/down migration pair
+`
+
+func main() {
+	godotenv.Load()
+
+	dir := flag.String("dir", "database/migrations", "migrations directory used by create")
+	flag.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	command, args := flag.Arg(0), flag.Args()[1:]
+
+	if command == "create" {
+		if len(args) != 1 {
+			log.Fatal("create requires a migration name")
+		}
+		upPath, downPath, err := migrate.Create(*dir, args[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("Created", upPath)
+		fmt.Println("Created", downPath)
+		return
+	}
+
+	all, err := migrate.Load(migrations.FS)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	db, err := database.Connect(database.Config{
+		Host:     getEnv("DB_HOST", "localhost"),
+		Port:     getEnv("DB_PORT", "5432"),
+		User:     getEnv("DB_USER", "postgres"),
+		Password: getEnv("DB_PASSWORD", "postgres"),
+		DBName:   getEnv("DB_NAME", "healthbar"),
+		SSLMode:  getEnv("DB_SSLMODE", "disable"),
+	})
+	if err != nil {
+		log.Fatal("Failed to connect to database:", err)
+	}
+	defer db.Close()
+
+	migrator := migrate.New(db, all)
+	migrator.SetLogger(log.Printf)
+	ctx := context.Background()
+
+	switch command {
+	case "up":
+		target, err := intArg(args, 0)
+		if err != nil {
+			log.Fatal(err)
+		}
+		n, err := migrator.Up(ctx, target)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Applied %d migration(s)", n)
+
+	case "down":
+		steps, err := intArg(args, 1)
+		if err != nil {
+			log.Fatal(err)
+		}
+		n, err := migrator.Down(ctx, int(steps))
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Rolled back %d migration(s)", n)
+
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, s := range statuses {
+			state := "pending"
+			switch {
+			case s.Missing:
+				state = "applied (file missing)"
+			case s.Modified:
+				state = "applied (MODIFIED)"
+			case s.Applied && s.AppliedAt != nil:
+				state = "applied " + s.AppliedAt.Format("2006-01-02 15:04:05")
+			case s.Applied:
+				state = "applied"
+			}
+			fmt.Printf("%03d  %-40s %s\n", s.Version, s.Name, state)
+		}
+
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+func intArg(args []string, defaultValue int64) (int64, error) {
+	if len(args) == 0 {
+		return defaultValue, nil
+	}
+	return strconv.ParseInt(args[0], 10, 64)
+}
+
+func getEnv(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
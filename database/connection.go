@@ -1,36 +0,0 @@
-package database
-import (
-    "fmt"
-    "log"
-    "github.com/jmoiron/sqlx"
-    _ "github.com/jackc/pgx/v5/stdlib"
-)
-
-type Config struct {
-    Host     string
-    Port     string
-    User     string
-    Password string
-    DBName   string
-    SSLMode  string
-}
-
-func Connect(config Config) (*sqlx.DB, error) {
-    dsn := fmt.Sprintf(
-        "host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
-        config.Host, config.Port, config.User, config.Password, config.DBName, config.SSLMode,
-    )
-
-    db, err := sqlx.Connect("pgx", dsn)
-    if err != nil {
-        return nil, fmt.Errorf("failed to connect to database: %w", err)
-    }
-
-    // Test connection
-    if err := db.Ping(); err != nil {
-        return nil, fmt.Errorf("failed to ping database: %w", err)
-    }
-
-    log.Println("Database connected successfully")
-    return db, nil
-}
\ No newline at end of file
@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS doctor_access_permissions;
+DROP TABLE IF EXISTS prescriptions;
+DROP TABLE IF EXISTS hospital_visits;
+DROP TABLE IF EXISTS doctor_profiles;
+DROP TABLE IF EXISTS patient_profiles;
+DROP TABLE IF EXISTS users;
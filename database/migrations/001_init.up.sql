@@ -69,10 +69,10 @@ CREATE TABLE IF NOT EXISTS doctor_access_permissions (
 );
 
 -- Indexes
-CREATE INDEX idx_users_email ON users(email);
-CREATE INDEX idx_patient_user_id ON patient_profiles(user_id);
-CREATE INDEX idx_doctor_user_id ON doctor_profiles(user_id);
-CREATE INDEX idx_hospital_visits_patient ON hospital_visits(patient_id);
-CREATE INDEX idx_prescriptions_patient ON prescriptions(patient_id);
-CREATE INDEX idx_permissions_patient ON doctor_access_permissions(patient_id);
-CREATE INDEX idx_permissions_doctor ON doctor_access_permissions(doctor_id);
\ No newline at end of file
+CREATE INDEX IF NOT EXISTS idx_users_email ON users(email);
+CREATE INDEX IF NOT EXISTS idx_patient_user_id ON patient_profiles(user_id);
+CREATE INDEX IF NOT EXISTS idx_doctor_user_id ON doctor_profiles(user_id);
+CREATE INDEX IF NOT EXISTS idx_hospital_visits_patient ON hospital_visits(patient_id);
+CREATE INDEX IF NOT EXISTS idx_prescriptions_patient ON prescriptions(patient_id);
+CREATE INDEX IF NOT EXISTS idx_permissions_patient ON doctor_access_permissions(patient_id);
+CREATE INDEX IF NOT EXISTS idx_permissions_doctor ON doctor_access_permissions(doctor_id);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS uq_doctor_profiles_user_id;
+DROP INDEX IF EXISTS uq_patient_profiles_user_id;
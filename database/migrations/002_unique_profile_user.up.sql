@@ -0,0 +1,5 @@
+-- A user owns at most one patient or doctor profile. The handlers already
+-- answer 409 "Profile already exists" on a unique violation; this makes the
+-- database actually raise it.
+CREATE UNIQUE INDEX IF NOT EXISTS uq_patient_profiles_user_id ON patient_profiles(user_id);
+CREATE UNIQUE INDEX IF NOT EXISTS uq_doctor_profiles_user_id ON doctor_profiles(user_id);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_permissions_patient_granted;
+DROP INDEX IF EXISTS idx_prescriptions_patient_upload;
+DROP INDEX IF EXISTS idx_hospital_visits_patient_date;
@@ -0,0 +1,5 @@
+-- Keyset pagination walks each list in (sort column, id) order within one
+-- owner. These indexes serve the default orders without a sort step.
+CREATE INDEX IF NOT EXISTS idx_hospital_visits_patient_date ON hospital_visits(patient_id, visit_date DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_prescriptions_patient_upload ON prescriptions(patient_id, upload_date DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_permissions_patient_granted ON doctor_access_permissions(patient_id, granted_at DESC, id DESC);
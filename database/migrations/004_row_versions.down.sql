@@ -0,0 +1,3 @@
+ALTER TABLE hospital_visits DROP COLUMN IF EXISTS version;
+ALTER TABLE doctor_profiles DROP COLUMN IF EXISTS version;
+ALTER TABLE patient_profiles DROP COLUMN IF EXISTS version;
@@ -0,0 +1,5 @@
+-- Row versions back the ETags of editable records. Every update bumps the
+-- version; writes carrying a stale If-Match are rejected with 412.
+ALTER TABLE patient_profiles ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE doctor_profiles ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE hospital_visits ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS patient_biometrics;
+DROP TABLE IF EXISTS emergency_contacts;
+DROP TABLE IF EXISTS patient_medications;
+DROP TABLE IF EXISTS patient_conditions;
+DROP TABLE IF EXISTS patient_allergies;
@@ -0,0 +1,66 @@
+-- Clinical record of a patient: list-shaped facts get a table each, the
+-- single-valued ones share patient_biometrics.
+
+CREATE TABLE IF NOT EXISTS patient_allergies (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    patient_id UUID NOT NULL REFERENCES patient_profiles(id) ON DELETE CASCADE,
+    substance VARCHAR(255) NOT NULL,
+    reaction TEXT NOT NULL DEFAULT '',
+    severity VARCHAR(20) NOT NULL CHECK (severity IN ('mild', 'moderate', 'severe', 'life_threatening')),
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS uq_patient_allergies_substance ON patient_allergies(patient_id, lower(substance));
+
+CREATE TABLE IF NOT EXISTS patient_conditions (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    patient_id UUID NOT NULL REFERENCES patient_profiles(id) ON DELETE CASCADE,
+    name VARCHAR(255) NOT NULL,
+    status VARCHAR(20) NOT NULL CHECK (status IN ('active', 'managed', 'resolved')),
+    diagnosed_on DATE,
+    notes TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_patient_conditions_patient ON patient_conditions(patient_id);
+
+CREATE TABLE IF NOT EXISTS patient_medications (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    patient_id UUID NOT NULL REFERENCES patient_profiles(id) ON DELETE CASCADE,
+    name VARCHAR(255) NOT NULL,
+    dosage VARCHAR(100) NOT NULL DEFAULT '',
+    frequency VARCHAR(100) NOT NULL DEFAULT '',
+    started_on DATE,
+    notes TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_patient_medications_patient ON patient_medications(patient_id);
+
+CREATE TABLE IF NOT EXISTS emergency_contacts (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    patient_id UUID NOT NULL REFERENCES patient_profiles(id) ON DELETE CASCADE,
+    name VARCHAR(255) NOT NULL,
+    relationship VARCHAR(100) NOT NULL DEFAULT '',
+    phone VARCHAR(20) NOT NULL,
+    email VARCHAR(255) NOT NULL DEFAULT '',
+    is_primary BOOLEAN NOT NULL DEFAULT false,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_emergency_contacts_patient ON emergency_contacts(patient_id);
+-- At most one primary contact per patient
+CREATE UNIQUE INDEX IF NOT EXISTS uq_emergency_contacts_primary ON emergency_contacts(patient_id) WHERE is_primary;
+
+CREATE TABLE IF NOT EXISTS patient_biometrics (
+    patient_id UUID PRIMARY KEY REFERENCES patient_profiles(id) ON DELETE CASCADE,
+    blood_type VARCHAR(3) NOT NULL DEFAULT ''
+        CHECK (blood_type IN ('', 'A+', 'A-', 'B+', 'B-', 'AB+', 'AB-', 'O+', 'O-')),
+    height_cm NUMERIC(5, 1) CHECK (height_cm > 0),
+    weight_kg NUMERIC(5, 1) CHECK (weight_kg > 0),
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,23 @@
+-- Vital sign measurements, stored in the canonical unit of their kind
+-- (see shared/vitals). Diastolic is only set for blood pressure.
+
+CREATE TABLE IF NOT EXISTS vital_readings (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    patient_id UUID NOT NULL REFERENCES patient_profiles(id) ON DELETE CASCADE,
+    kind VARCHAR(20) NOT NULL
+        CHECK (kind IN ('blood_pressure', 'heart_rate', 'glucose', 'weight', 'temperature', 'spo2')),
+    value DOUBLE PRECISION NOT NULL,
+    diastolic DOUBLE PRECISION,
+    unit VARCHAR(10) NOT NULL,
+    measured_at TIMESTAMP NOT NULL,
+    source VARCHAR(50) NOT NULL DEFAULT '',
+    notes TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CHECK ((kind = 'blood_pressure') = (diastolic IS NOT NULL))
+);
+
+-- One reading per kind and instant, so a device re-sending a batch does not
+-- duplicate it. Also serves the range and summary queries.
+CREATE UNIQUE INDEX IF NOT EXISTS uq_vital_readings_instant ON vital_readings(patient_id, kind, measured_at);
+-- Listing every kind newest first
+CREATE INDEX IF NOT EXISTS idx_vital_readings_patient_measured ON vital_readings(patient_id, measured_at DESC, id DESC);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS health_scores;
+DROP TABLE IF EXISTS patient_self_assessments;
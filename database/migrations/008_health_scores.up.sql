@@ -0,0 +1,27 @@
+-- Health bar: the patients' self-assessments that feed it and the history
+-- of computed scores (see shared/healthscore).
+
+CREATE TABLE IF NOT EXISTS patient_self_assessments (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    patient_id UUID NOT NULL REFERENCES patient_profiles(id) ON DELETE CASCADE,
+    general_health SMALLINT NOT NULL CHECK (general_health BETWEEN 1 AND 5),
+    energy SMALLINT NOT NULL CHECK (energy BETWEEN 1 AND 5),
+    sleep SMALLINT NOT NULL CHECK (sleep BETWEEN 1 AND 5),
+    mood SMALLINT NOT NULL CHECK (mood BETWEEN 1 AND 5),
+    pain SMALLINT NOT NULL CHECK (pain BETWEEN 1 AND 5),
+    notes TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_self_assessments_patient_created ON patient_self_assessments(patient_id, created_at DESC, id DESC);
+
+CREATE TABLE IF NOT EXISTS health_scores (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    patient_id UUID NOT NULL REFERENCES patient_profiles(id) ON DELETE CASCADE,
+    score SMALLINT NOT NULL CHECK (score BETWEEN 0 AND 100),
+    rules_version VARCHAR(20) NOT NULL,
+    contributions JSONB NOT NULL DEFAULT '[]',
+    computed_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_health_scores_patient_computed ON health_scores(patient_id, computed_at DESC, id DESC);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS lab_results;
+DROP TABLE IF EXISTS lab_panels;
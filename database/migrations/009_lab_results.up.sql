@@ -0,0 +1,39 @@
+-- Lab results: a panel is one collection of samples, its results the
+-- analytes measured. Results repeat patient_id and collected_at so trends
+-- per analyte are read without joining panels.
+
+CREATE TABLE IF NOT EXISTS lab_panels (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    patient_id UUID NOT NULL REFERENCES patient_profiles(id) ON DELETE CASCADE,
+    visit_id UUID REFERENCES hospital_visits(id) ON DELETE SET NULL,
+    document_id UUID REFERENCES prescriptions(id) ON DELETE SET NULL,
+    name VARCHAR(255) NOT NULL,
+    lab_name VARCHAR(255) NOT NULL DEFAULT '',
+    collected_at TIMESTAMP NOT NULL,
+    notes TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_lab_panels_patient_collected ON lab_panels(patient_id, collected_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_lab_panels_visit ON lab_panels(visit_id) WHERE visit_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_lab_panels_document ON lab_panels(document_id) WHERE document_id IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS lab_results (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    panel_id UUID NOT NULL REFERENCES lab_panels(id) ON DELETE CASCADE,
+    patient_id UUID NOT NULL REFERENCES patient_profiles(id) ON DELETE CASCADE,
+    analyte VARCHAR(50) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    value DOUBLE PRECISION NOT NULL,
+    unit VARCHAR(20) NOT NULL,
+    ref_low DOUBLE PRECISION,
+    ref_high DOUBLE PRECISION,
+    flag VARCHAR(15) NOT NULL DEFAULT ''
+        CHECK (flag IN ('', 'normal', 'low', 'high', 'critical_low', 'critical_high')),
+    collected_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (panel_id, analyte)
+);
+
+CREATE INDEX IF NOT EXISTS idx_lab_results_trend ON lab_results(patient_id, analyte, collected_at);
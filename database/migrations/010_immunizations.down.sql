@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS immunization_reminders;
+DROP TABLE IF EXISTS immunizations;
@@ -0,0 +1,29 @@
+-- Vaccine doses a patient received, and the due-dose reminders already sent
+-- so each is sent once (see shared/immunization).
+
+CREATE TABLE IF NOT EXISTS immunizations (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    patient_id UUID NOT NULL REFERENCES patient_profiles(id) ON DELETE CASCADE,
+    vaccine VARCHAR(50) NOT NULL,
+    vaccine_name VARCHAR(255) NOT NULL,
+    dose_number SMALLINT NOT NULL CHECK (dose_number >= 1),
+    lot_number VARCHAR(50) NOT NULL DEFAULT '',
+    administered_on DATE NOT NULL,
+    facility VARCHAR(255) NOT NULL DEFAULT '',
+    notes TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (patient_id, vaccine, dose_number)
+);
+
+CREATE INDEX IF NOT EXISTS idx_immunizations_patient_administered ON immunizations(patient_id, administered_on DESC, id DESC);
+
+-- A dose is reminded about once when it falls due and once more when it
+-- becomes overdue
+CREATE TABLE IF NOT EXISTS immunization_reminders (
+    patient_id UUID NOT NULL REFERENCES patient_profiles(id) ON DELETE CASCADE,
+    vaccine VARCHAR(50) NOT NULL,
+    dose_number SMALLINT NOT NULL,
+    status VARCHAR(10) NOT NULL CHECK (status IN ('due', 'overdue')),
+    sent_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (patient_id, vaccine, dose_number, status)
+);
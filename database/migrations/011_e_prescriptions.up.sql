@@ -0,0 +1,27 @@
+-- Structured prescriptions issued by doctors. doctor_id is cleared when the
+-- doctor's profile goes away; prescriber_name keeps who issued it.
+
+CREATE TABLE IF NOT EXISTS e_prescriptions (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    patient_id UUID NOT NULL REFERENCES patient_profiles(id) ON DELETE CASCADE,
+    doctor_id UUID REFERENCES doctor_profiles(id) ON DELETE SET NULL,
+    prescriber_name VARCHAR(255) NOT NULL,
+    document_id UUID REFERENCES prescriptions(id) ON DELETE SET NULL,
+    drug VARCHAR(255) NOT NULL,
+    strength VARCHAR(100) NOT NULL DEFAULT '',
+    route VARCHAR(20) NOT NULL,
+    frequency VARCHAR(100) NOT NULL,
+    duration_days INTEGER CHECK (duration_days > 0),
+    refills SMALLINT NOT NULL DEFAULT 0 CHECK (refills BETWEEN 0 AND 12),
+    instructions TEXT NOT NULL DEFAULT '',
+    status VARCHAR(10) NOT NULL DEFAULT 'active' CHECK (status IN ('active', 'cancelled')),
+    issued_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    cancelled_at TIMESTAMP,
+    cancel_reason TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_e_prescriptions_patient_issued ON e_prescriptions(patient_id, issued_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_e_prescriptions_doctor_issued ON e_prescriptions(doctor_id, issued_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_e_prescriptions_document ON e_prescriptions(document_id) WHERE document_id IS NOT NULL;
@@ -0,0 +1,3 @@
+ALTER TABLE e_prescriptions DROP COLUMN IF EXISTS override_reason;
+ALTER TABLE e_prescriptions DROP COLUMN IF EXISTS interactions_version;
+DROP TABLE IF EXISTS interaction_datasets;
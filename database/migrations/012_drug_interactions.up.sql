@@ -0,0 +1,12 @@
+-- Versioned drug interaction datasets imported with cmd/interactions (see
+-- shared/interactions), and what an issued prescription was checked against.
+
+CREATE TABLE IF NOT EXISTS interaction_datasets (
+    version VARCHAR(50) PRIMARY KEY,
+    checksum CHAR(64) NOT NULL,
+    data JSONB NOT NULL,
+    imported_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+ALTER TABLE e_prescriptions ADD COLUMN IF NOT EXISTS interactions_version VARCHAR(50) NOT NULL DEFAULT '';
+ALTER TABLE e_prescriptions ADD COLUMN IF NOT EXISTS override_reason TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS dose_events;
+DROP TABLE IF EXISTS medication_schedules;
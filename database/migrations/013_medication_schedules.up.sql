@@ -0,0 +1,36 @@
+-- When patients take their medications and what became of each dose (see
+-- shared/adherence). Dose times are local to the schedule's timezone;
+-- scheduled_for is the dose's instant in UTC.
+
+CREATE TABLE IF NOT EXISTS medication_schedules (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    patient_id UUID NOT NULL REFERENCES patient_profiles(id) ON DELETE CASCADE,
+    prescription_id UUID REFERENCES e_prescriptions(id) ON DELETE SET NULL,
+    medication VARCHAR(255) NOT NULL,
+    dose VARCHAR(100) NOT NULL DEFAULT '',
+    times JSONB NOT NULL CHECK (jsonb_typeof(times) = 'array' AND jsonb_array_length(times) BETWEEN 1 AND 12),
+    timezone VARCHAR(64) NOT NULL,
+    start_date DATE NOT NULL,
+    end_date DATE CHECK (end_date >= start_date),
+    notes TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_medication_schedules_patient ON medication_schedules(patient_id, start_date, id);
+CREATE INDEX IF NOT EXISTS idx_medication_schedules_prescription ON medication_schedules(prescription_id) WHERE prescription_id IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS dose_events (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    schedule_id UUID NOT NULL REFERENCES medication_schedules(id) ON DELETE CASCADE,
+    patient_id UUID NOT NULL REFERENCES patient_profiles(id) ON DELETE CASCADE,
+    scheduled_for TIMESTAMP NOT NULL,
+    status VARCHAR(10) NOT NULL CHECK (status IN ('taken', 'late', 'skipped')),
+    taken_at TIMESTAMP,
+    note TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (schedule_id, scheduled_for)
+);
+
+CREATE INDEX IF NOT EXISTS idx_dose_events_patient_scheduled ON dose_events(patient_id, scheduled_for);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS appointments;
+DROP TABLE IF EXISTS availability_exceptions;
+DROP TABLE IF EXISTS doctor_calendars;
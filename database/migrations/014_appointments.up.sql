@@ -0,0 +1,56 @@
+-- Doctor availability calendars and appointments (see shared/availability).
+-- The exclusion constraints keep a doctor, and a patient, from being booked
+-- twice for overlapping times; btree_gist lets them compare the UUID columns.
+
+CREATE EXTENSION IF NOT EXISTS btree_gist;
+
+CREATE TABLE IF NOT EXISTS doctor_calendars (
+    doctor_id UUID PRIMARY KEY REFERENCES doctor_profiles(id) ON DELETE CASCADE,
+    timezone VARCHAR(64) NOT NULL,
+    slot_minutes INTEGER NOT NULL CHECK (slot_minutes BETWEEN 5 AND 480),
+    location VARCHAR(255) NOT NULL DEFAULT '',
+    create_visits BOOLEAN NOT NULL DEFAULT TRUE,
+    weekly JSONB NOT NULL DEFAULT '[]' CHECK (jsonb_typeof(weekly) = 'array'),
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS availability_exceptions (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    doctor_id UUID NOT NULL REFERENCES doctor_profiles(id) ON DELETE CASCADE,
+    date DATE NOT NULL,
+    start_time VARCHAR(5),
+    end_time VARCHAR(5),
+    available BOOLEAN NOT NULL DEFAULT FALSE,
+    reason TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CHECK ((start_time IS NULL) = (end_time IS NULL))
+);
+
+CREATE INDEX IF NOT EXISTS idx_availability_exceptions_doctor_date ON availability_exceptions(doctor_id, date);
+
+CREATE TABLE IF NOT EXISTS appointments (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    doctor_id UUID NOT NULL REFERENCES doctor_profiles(id) ON DELETE CASCADE,
+    patient_id UUID NOT NULL REFERENCES patient_profiles(id) ON DELETE CASCADE,
+    starts_at TIMESTAMP NOT NULL,
+    ends_at TIMESTAMP NOT NULL,
+    status VARCHAR(10) NOT NULL DEFAULT 'booked' CHECK (status IN ('booked', 'cancelled', 'completed')),
+    reason TEXT NOT NULL DEFAULT '',
+    notes TEXT NOT NULL DEFAULT '',
+    visit_id UUID REFERENCES hospital_visits(id) ON DELETE SET NULL,
+    cancelled_by VARCHAR(10) NOT NULL DEFAULT '',
+    cancel_reason TEXT NOT NULL DEFAULT '',
+    cancelled_at TIMESTAMP,
+    completed_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CHECK (ends_at > starts_at),
+    CONSTRAINT appointments_doctor_no_overlap
+        EXCLUDE USING gist (doctor_id WITH =, tsrange(starts_at, ends_at) WITH &&) WHERE (status <> 'cancelled'),
+    CONSTRAINT appointments_patient_no_overlap
+        EXCLUDE USING gist (patient_id WITH =, tsrange(starts_at, ends_at) WITH &&) WHERE (status <> 'cancelled')
+);
+
+CREATE INDEX IF NOT EXISTS idx_appointments_doctor_starts ON appointments(doctor_id, starts_at, id);
+CREATE INDEX IF NOT EXISTS idx_appointments_patient_starts ON appointments(patient_id, starts_at, id);
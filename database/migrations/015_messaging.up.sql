@@ -0,0 +1,42 @@
+-- Messaging between a patient and a doctor who share an access grant. There
+-- is one thread per pair. Revoking the grant sets frozen_at: nobody can post
+-- after that, and the doctor only sees messages sent before it. Granting
+-- access again clears it.
+
+CREATE TABLE IF NOT EXISTS message_threads (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    patient_id UUID NOT NULL REFERENCES patient_profiles(id) ON DELETE CASCADE,
+    doctor_id UUID NOT NULL REFERENCES doctor_profiles(id) ON DELETE CASCADE,
+    frozen_at TIMESTAMP,
+    last_message_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (patient_id, doctor_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_message_threads_patient_updated ON message_threads(patient_id, updated_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_message_threads_doctor_updated ON message_threads(doctor_id, updated_at DESC, id DESC);
+
+CREATE TABLE IF NOT EXISTS messages (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    thread_id UUID NOT NULL REFERENCES message_threads(id) ON DELETE CASCADE,
+    sender_role VARCHAR(10) NOT NULL CHECK (sender_role IN ('patient', 'doctor')),
+    body TEXT NOT NULL DEFAULT '',
+    read_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_messages_thread_created ON messages(thread_id, created_at DESC, id DESC);
+
+-- Attachment files live in the prescription upload directory
+CREATE TABLE IF NOT EXISTS message_attachments (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    message_id UUID NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    file_name VARCHAR(255) NOT NULL,
+    file_type VARCHAR(50) NOT NULL,
+    file_size BIGINT NOT NULL,
+    file_path TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_message_attachments_message ON message_attachments(message_id);
@@ -0,0 +1,28 @@
+-- Resumable uploads of prescription files. Each chunk is stored as a blob
+-- and recorded in upload_chunks when the upload's offset moves past it; the
+-- last chunk assembles the file into a prescription. Uploads left
+-- unfinished past expires_at are removed with their blobs.
+
+CREATE TABLE IF NOT EXISTS uploads (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    patient_id UUID NOT NULL REFERENCES patient_profiles(id) ON DELETE CASCADE,
+    file_name VARCHAR(255) NOT NULL,
+    file_type VARCHAR(50) NOT NULL,
+    upload_length BIGINT NOT NULL CHECK (upload_length > 0),
+    upload_offset BIGINT NOT NULL DEFAULT 0 CHECK (upload_offset BETWEEN 0 AND upload_length),
+    prescription_id UUID REFERENCES prescriptions(id) ON DELETE CASCADE,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_uploads_expires ON uploads(expires_at);
+
+CREATE TABLE IF NOT EXISTS upload_chunks (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    upload_id UUID NOT NULL REFERENCES uploads(id) ON DELETE CASCADE,
+    chunk_offset BIGINT NOT NULL,
+    size BIGINT NOT NULL,
+    blob_key TEXT NOT NULL,
+    UNIQUE (upload_id, chunk_offset)
+);
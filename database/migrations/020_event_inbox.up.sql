@@ -0,0 +1,28 @@
+-- Per-consumer inboxes. Services register the topics they consume in
+-- event_consumers; the relay moves each outbox event into the inbox of
+-- every consumer of its topic in one statement. A consumer deletes an
+-- event once it has handled it and retries failures with backoff, so an
+-- event reaches a consumer that was down or failed when it was published.
+
+CREATE TABLE IF NOT EXISTS event_consumers (
+    consumer VARCHAR(100) NOT NULL,
+    topic VARCHAR(100) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (consumer, topic)
+);
+
+CREATE INDEX IF NOT EXISTS idx_event_consumers_topic ON event_consumers(topic);
+
+CREATE TABLE IF NOT EXISTS event_inbox (
+    consumer VARCHAR(100) NOT NULL,
+    event_id UUID NOT NULL,
+    topic VARCHAR(100) NOT NULL,
+    payload JSONB NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_error TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (consumer, event_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_event_inbox_due ON event_inbox(consumer, next_attempt_at, created_at);
@@ -0,0 +1,10 @@
+// Package migrations embeds the ordered SQL migration files so that the
+// migrate command and test harnesses can apply them without a source tree.
+//
+// Files are named NNN_description.up.sql and NNN_description.down.sql.
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS
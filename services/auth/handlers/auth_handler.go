@@ -3,6 +3,7 @@ package handlers
 import (
 	"encoding/json"
 	"health-bar/services/auth/repository"
+	"health-bar/shared/apperrors"
 	"health-bar/shared/models"
 	"health-bar/shared/utils"
 	"net/http"
@@ -10,10 +11,10 @@ import (
 )
 
 type AuthHandler struct {
-	repo *repository.AuthRepository
+	repo repository.Store
 }
 
-func NewAuthHandler(repo *repository.AuthRepository) *AuthHandler {
+func NewAuthHandler(repo repository.Store) *AuthHandler {
 	return &AuthHandler{repo: repo}
 }
 
@@ -42,37 +43,37 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 
 	// Validate
 	if req.Email == "" || req.Password == "" {
-		utils.SendError(w, http.StatusBadRequest, "Email and password are required")
+		utils.SendErrorCode(w, apperrors.CodeValidation, "Email and password are required")
 		return
 	}
 
 	if req.Role != models.RolePatient && req.Role != models.RoleDoctor {
-		utils.SendError(w, http.StatusBadRequest, "Role must be 'patient' or 'doctor'")
+		utils.SendErrorCode(w, apperrors.CodeValidation, "Role must be 'patient' or 'doctor'")
 		return
 	}
 
 	// Hash password
 	passwordHash, err := utils.HashPassword(req.Password)
 	if err != nil {
-		utils.SendError(w, http.StatusInternalServerError, "Failed to hash password")
+		utils.SendAppError(w, err, "Failed to hash password")
 		return
 	}
 
 	// Create user
-	user, err := h.repo.CreateUser(req.Email, passwordHash, req.Role)
+	user, err := h.repo.CreateUser(r.Context(), req.Email, passwordHash, req.Role)
 	if err != nil {
-		if strings.Contains(err.Error(), "duplicate") || strings.Contains(err.Error(), "unique") {
-			utils.SendError(w, http.StatusConflict, "Email already exists")
+		if apperrors.IsUniqueViolation(err) {
+			utils.SendErrorCode(w, apperrors.CodeEmailTaken, "Email already exists")
 			return
 		}
-		utils.SendError(w, http.StatusInternalServerError, "Failed to create user")
+		utils.SendAppError(w, err, "Failed to create user")
 		return
 	}
 
 	// Generate token
 	token, err := utils.GenerateToken(user.ID, user.Email, string(user.Role))
 	if err != nil {
-		utils.SendError(w, http.StatusInternalServerError, "Failed to generate token")
+		utils.SendAppError(w, err, "Failed to generate token")
 		return
 	}
 
@@ -90,22 +91,22 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get user
-	user, err := h.repo.GetUserByEmail(req.Email)
+	user, err := h.repo.GetUserByEmail(r.Context(), req.Email)
 	if err != nil {
-		utils.SendError(w, http.StatusUnauthorized, "Invalid credentials")
+		utils.SendErrorCode(w, apperrors.CodeInvalidCredentials, "Invalid credentials")
 		return
 	}
 
 	// Check password
 	if !utils.CheckPasswordHash(req.Password, user.PasswordHash) {
-		utils.SendError(w, http.StatusUnauthorized, "Invalid credentials")
+		utils.SendErrorCode(w, apperrors.CodeInvalidCredentials, "Invalid credentials")
 		return
 	}
 
 	// Generate token
 	token, err := utils.GenerateToken(user.ID, user.Email, string(user.Role))
 	if err != nil {
-		utils.SendError(w, http.StatusInternalServerError, "Failed to generate token")
+		utils.SendAppError(w, err, "Failed to generate token")
 		return
 	}
 
@@ -126,12 +127,12 @@ func (h *AuthHandler) GetCurrentUser(w http.ResponseWriter, r *http.Request) {
 	tokenString := strings.Replace(authHeader, "Bearer ", "", 1)
 	claims, err := utils.ValidateToken(tokenString)
 	if err != nil {
-		utils.SendError(w, http.StatusUnauthorized, "Invalid token")
+		utils.SendErrorCode(w, apperrors.CodeInvalidToken, "Invalid token")
 		return
 	}
 
 	// Get user
-	user, err := h.repo.GetUserByID(claims.UserID)
+	user, err := h.repo.GetUserByID(r.Context(), claims.UserID)
 	if err != nil {
 		utils.SendError(w, http.StatusNotFound, "User not found")
 		return
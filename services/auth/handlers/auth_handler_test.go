@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"health-bar/services/auth/repository"
+	"health-bar/shared/apperrors"
+	"health-bar/shared/memdb"
+	"health-bar/shared/testutil"
+	"health-bar/shared/utils"
+	"net/http"
+	"os"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestMain(m *testing.M) {
+	utils.PasswordHashCost = bcrypt.MinCost
+	os.Exit(m.Run())
+}
+
+func TestRegisterAndLogin(t *testing.T) {
+	h := NewAuthHandler(repository.NewMemoryRepository(memdb.New()))
+
+	register := func(body interface{}) int {
+		req := testutil.NewRequest(t, http.MethodPost, "/api/auth/register", body, "", "")
+		rec, _ := testutil.Serve(t, h.Register, req)
+		return rec.Code
+	}
+
+	if code := register(RegisterRequest{Email: "a@test.com", Password: "pw", Role: "admin"}); code != http.StatusBadRequest {
+		t.Fatalf("invalid role: status = %d, want 400", code)
+	}
+	if code := register(RegisterRequest{Email: "a@test.com", Role: "patient"}); code != http.StatusBadRequest {
+		t.Fatalf("missing password: status = %d, want 400", code)
+	}
+	if code := register(RegisterRequest{Email: "a@test.com", Password: "pw", Role: "patient"}); code != http.StatusCreated {
+		t.Fatalf("register: status = %d, want 201", code)
+	}
+	if code := register(RegisterRequest{Email: "a@test.com", Password: "pw", Role: "doctor"}); code != http.StatusConflict {
+		t.Fatalf("duplicate email: status = %d, want 409", code)
+	}
+
+	req := testutil.NewRequest(t, http.MethodPost, "/api/auth/register", RegisterRequest{Email: "a@test.com", Password: "pw", Role: "doctor"}, "", "")
+	_, resp := testutil.Serve(t, h.Register, req)
+	testutil.ExpectCode(t, resp, apperrors.CodeEmailTaken)
+
+	req = testutil.NewRequest(t, http.MethodPost, "/api/auth/login", LoginRequest{Email: "a@test.com", Password: "wrong"}, "", "")
+	rec, resp := testutil.Serve(t, h.Login, req)
+	testutil.ExpectStatus(t, rec, http.StatusUnauthorized)
+	testutil.ExpectCode(t, resp, apperrors.CodeInvalidCredentials)
+
+	req = testutil.NewRequest(t, http.MethodPost, "/api/auth/login", LoginRequest{Email: "a@test.com", Password: "pw"}, "", "")
+	rec, resp = testutil.Serve(t, h.Login, req)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+
+	var auth AuthResponse
+	testutil.DecodeData(t, resp, &auth)
+	if auth.Token == "" || auth.User.Email != "a@test.com" || auth.User.Role != "patient" {
+		t.Fatalf("login response = %+v", auth)
+	}
+
+	req = testutil.NewRequest(t, http.MethodGet, "/api/auth/me", nil, "", "")
+	rec, _ = testutil.Serve(t, h.GetCurrentUser, req)
+	testutil.ExpectStatus(t, rec, http.StatusUnauthorized)
+
+	req.Header.Set("Authorization", "Bearer "+auth.Token)
+	rec, resp = testutil.Serve(t, h.GetCurrentUser, req)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+}
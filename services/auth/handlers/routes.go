@@ -0,0 +1,18 @@
+package handlers
+
+import (
+	"health-bar/shared/apperrors"
+
+	"github.com/gorilla/mux"
+)
+
+// RegisterRoutes mounts the auth service endpoints on router
+func RegisterRoutes(router *mux.Router, h *AuthHandler) {
+	router.NotFoundHandler = apperrors.NotFoundHandler()
+	router.MethodNotAllowedHandler = apperrors.MethodNotAllowedHandler()
+
+	// Auth routes
+	router.HandleFunc("/api/auth/register", h.Register).Methods("POST")
+	router.HandleFunc("/api/auth/login", h.Login).Methods("POST")
+	router.HandleFunc("/api/auth/me", h.GetCurrentUser).Methods("GET")
+}
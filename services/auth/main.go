@@ -1,7 +1,7 @@
 package main
 
 import (
-    "health-bar/database"
+    "health-bar/shared/database"
     "health-bar/services/auth/handlers"
     "health-bar/services/auth/repository"
     "log"
@@ -36,11 +36,7 @@ func main() {
 
     // Setup router
     router := mux.NewRouter()
-    
-    // Auth routes
-    router.HandleFunc("/api/auth/register", handler.Register).Methods("POST")
-    router.HandleFunc("/api/auth/login", handler.Login).Methods("POST")
-    router.HandleFunc("/api/auth/me", handler.GetCurrentUser).Methods("GET")
+    handlers.RegisterRoutes(router, handler)
 
     // CORS
     c := cors.New(cors.Options{
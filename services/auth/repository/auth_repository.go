@@ -1,6 +1,8 @@
 package repository
 
 import (
+    "context"
+    "health-bar/shared/database"
     "health-bar/shared/models"
     "github.com/jmoiron/sqlx"
     "github.com/google/uuid"
@@ -14,7 +16,15 @@ func NewAuthRepository(db *sqlx.DB) *AuthRepository {
     return &AuthRepository{db: db}
 }
 
-func (r *AuthRepository) CreateUser(email, passwordHash string, role models.UserRole) (*models.User, error) {
+// WithTx runs fn in a transaction shared by every repository call made with its context
+func (r *AuthRepository) WithTx(ctx context.Context, fn func(ctx context.Context) error) error {
+    return database.WithTx(ctx, r.db, fn)
+}
+
+func (r *AuthRepository) CreateUser(ctx context.Context, email, passwordHash string, role models.UserRole) (*models.User, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
     user := &models.User{
         ID:           uuid.New().String(),
         Email:        email,
@@ -28,7 +38,7 @@ func (r *AuthRepository) CreateUser(email, passwordHash string, role models.User
         RETURNING id, email, role, created_at, updated_at
     `
 
-    err := r.db.QueryRowx(query, user.ID, user.Email, user.PasswordHash, user.Role).
+    err := database.Conn(ctx, r.db).QueryRowxContext(ctx, query, user.ID, user.Email, user.PasswordHash, user.Role).
         StructScan(user)
 
     if err != nil {
@@ -38,11 +48,14 @@ func (r *AuthRepository) CreateUser(email, passwordHash string, role models.User
     return user, nil
 }
 
-func (r *AuthRepository) GetUserByEmail(email string) (*models.User, error) {
+func (r *AuthRepository) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
     user := &models.User{}
     query := `SELECT id, email, password_hash, role, created_at, updated_at FROM users WHERE email = $1`
     
-    err := r.db.Get(user, query, email)
+    err := database.Conn(ctx, r.db).GetContext(ctx, user, query, email)
     if err != nil {
         return nil, err
     }
@@ -50,14 +63,17 @@ func (r *AuthRepository) GetUserByEmail(email string) (*models.User, error) {
     return user, nil
 }
 
-func (r *AuthRepository) GetUserByID(id string) (*models.User, error) {
+func (r *AuthRepository) GetUserByID(ctx context.Context, id string) (*models.User, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
     user := &models.User{}
     query := `SELECT id, email, role, created_at, updated_at FROM users WHERE id = $1`
     
-    err := r.db.Get(user, query, id)
+    err := database.Conn(ctx, r.db).GetContext(ctx, user, query, id)
     if err != nil {
         return nil, err
     }
 
     return user, nil
-}
\ No newline at end of file
+}
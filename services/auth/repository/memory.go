@@ -0,0 +1,73 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"health-bar/shared/memdb"
+	"health-bar/shared/models"
+
+	"github.com/google/uuid"
+)
+
+// MemoryRepository implements Store on top of memdb.
+type MemoryRepository struct {
+	db *memdb.DB
+}
+
+func NewMemoryRepository(db *memdb.DB) *MemoryRepository {
+	return &MemoryRepository{db: db}
+}
+
+func (r *MemoryRepository) WithTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	return r.db.WithTx(ctx, fn)
+}
+
+func (r *MemoryRepository) CreateUser(ctx context.Context, email, passwordHash string, role models.UserRole) (*models.User, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	for _, u := range r.db.Users.Rows {
+		if u.Email == email {
+			return nil, memdb.UniqueViolation("users_email_key")
+		}
+	}
+
+	now := r.db.Now()
+	user := models.User{
+		ID:           uuid.New().String(),
+		Email:        email,
+		PasswordHash: passwordHash,
+		Role:         role,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+	r.db.Users.Rows[user.ID] = user
+
+	// RETURNING does not include password_hash
+	user.PasswordHash = ""
+	return &user, nil
+}
+
+func (r *MemoryRepository) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	for _, u := range r.db.Users.Rows {
+		if u.Email == email {
+			return &u, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (r *MemoryRepository) GetUserByID(ctx context.Context, id string) (*models.User, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	u, ok := r.db.Users.Rows[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	u.PasswordHash = ""
+	return &u, nil
+}
@@ -0,0 +1,20 @@
+package repository
+
+import (
+	"context"
+	"health-bar/shared/models"
+)
+
+// Store is what AuthHandler needs from persistence. AuthRepository is the
+// Postgres implementation and MemoryRepository the in-memory one.
+type Store interface {
+	WithTx(ctx context.Context, fn func(ctx context.Context) error) error
+	CreateUser(ctx context.Context, email, passwordHash string, role models.UserRole) (*models.User, error)
+	GetUserByEmail(ctx context.Context, email string) (*models.User, error)
+	GetUserByID(ctx context.Context, id string) (*models.User, error)
+}
+
+var (
+	_ Store = (*AuthRepository)(nil)
+	_ Store = (*MemoryRepository)(nil)
+)
@@ -0,0 +1,455 @@
+package handlers
+
+import (
+    "context"
+    "database/sql"
+    "encoding/json"
+    "fmt"
+    "health-bar/shared/apperrors"
+    "health-bar/shared/availability"
+    "health-bar/shared/models"
+    "health-bar/shared/notify"
+    "health-bar/shared/pagination"
+    "health-bar/shared/utils"
+    "health-bar/shared/webhooks"
+    "health-bar/services/doctor/repository"
+    "log"
+    "net/http"
+    "strings"
+    "time"
+)
+
+// maxBookingDays is how far ahead appointments can be booked
+const maxBookingDays = 365
+
+type BookAppointmentRequest struct {
+    DoctorID string    `json:"doctor_id"`
+    StartsAt time.Time `json:"starts_at"` // Start of one of the doctor's free slots, RFC 3339
+    Reason   string    `json:"reason"`
+}
+
+type RescheduleAppointmentRequest struct {
+    StartsAt time.Time `json:"starts_at"`
+}
+
+type CancelAppointmentRequest struct {
+    Reason string `json:"reason"`
+}
+
+type CompleteAppointmentRequest struct {
+    Notes string `json:"notes"`
+}
+
+// bookableSlot finds the slot of calendar starting at startsAt. It must lie
+// between now and maxBookingDays ahead; whether it is free is left to the
+// database.
+func (h *DoctorHandler) bookableSlot(ctx context.Context, calendar *models.DoctorCalendar, startsAt, now time.Time) (availability.Slot, error) {
+    if !startsAt.After(now) {
+        return availability.Slot{}, apperrors.New(apperrors.CodeValidation, "starts_at must be in the future")
+    }
+    if startsAt.After(now.AddDate(0, 0, maxBookingDays)) {
+        return availability.Slot{}, apperrors.New(apperrors.CodeValidation, fmt.Sprintf("Appointments can be booked at most %d days ahead", maxBookingDays))
+    }
+
+    date := localDate(calendar, startsAt)
+    exceptions, err := h.repo.ListAvailabilityExceptions(ctx, calendar.DoctorID, date, date)
+    if err != nil {
+        return availability.Slot{}, err
+    }
+    slot, ok := availability.Find(*calendar, exceptions, startsAt)
+    if !ok {
+        return availability.Slot{}, apperrors.New(apperrors.CodeValidation, "starts_at is not one of the doctor's slots")
+    }
+    return slot, nil
+}
+
+// sendBookingError reports a booking that overlaps another one, or err
+func sendBookingError(w http.ResponseWriter, err error, fallback string) {
+    if apperrors.IsExclusionViolation(err) {
+        if apperrors.ConstraintName(err) == "appointments_patient_no_overlap" {
+            utils.SendErrorCode(w, apperrors.CodeConflict, "The patient already has an appointment at that time")
+            return
+        }
+        utils.SendErrorCode(w, apperrors.CodeConflict, "That slot is already booked")
+        return
+    }
+    utils.SendAppError(w, err, fallback)
+}
+
+// BookAppointment books one of a doctor's free slots for the current
+// patient. Two bookings of the same time cannot both succeed.
+func (h *DoctorHandler) BookAppointment(w http.ResponseWriter, r *http.Request) {
+    userID := r.Header.Get("X-User-ID")
+    userRole := r.Header.Get("X-User-Role")
+
+    if userID == "" {
+        utils.SendError(w, http.StatusUnauthorized, "Unauthorized")
+        return
+    }
+
+    if userRole != "patient" {
+        utils.SendError(w, http.StatusForbidden, "Only patients can book appointments")
+        return
+    }
+
+    var req BookAppointmentRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        utils.SendError(w, http.StatusBadRequest, "Invalid request body")
+        return
+    }
+
+    if req.DoctorID == "" || req.StartsAt.IsZero() {
+        utils.SendErrorCode(w, apperrors.CodeValidation, "doctor_id and starts_at are required")
+        return
+    }
+    if len(req.Reason) > maxReasonLen {
+        utils.SendErrorCode(w, apperrors.CodeValidation, fmt.Sprintf("reason must be at most %d characters", maxReasonLen))
+        return
+    }
+
+    patientProfileID, err := h.repo.GetPatientProfileIDByUserID(r.Context(), userID)
+    if err != nil {
+        utils.SendErrorCode(w, apperrors.CodeProfileNotFound, "Patient profile not found")
+        return
+    }
+
+    calendar, err := h.repo.GetCalendar(r.Context(), req.DoctorID)
+    if err != nil {
+        if err == sql.ErrNoRows {
+            utils.SendErrorCode(w, apperrors.CodeInvalidReference, "The doctor does not take appointments")
+            return
+        }
+        utils.SendAppError(w, err, "Failed to book appointment")
+        return
+    }
+
+    slot, err := h.bookableSlot(r.Context(), calendar, req.StartsAt.UTC(), time.Now().UTC())
+    if err != nil {
+        utils.SendAppError(w, err, "Failed to book appointment")
+        return
+    }
+
+    appointment := &models.Appointment{
+        DoctorID:  calendar.DoctorID,
+        PatientID: patientProfileID,
+        StartsAt:  slot.StartsAt,
+        EndsAt:    slot.EndsAt,
+        Reason:    strings.TrimSpace(req.Reason),
+    }
+    if err := h.repo.CreateAppointment(r.Context(), appointment); err != nil {
+        sendBookingError(w, err, "Failed to book appointment")
+        return
+    }
+
+    utils.SendSuccess(w, http.StatusCreated, "Appointment booked", appointment)
+}
+
+// GetAppointments lists the current patient's or doctor's appointments,
+// soonest first. Patients can narrow the list to a doctor_id and doctors to
+// a patient_id.
+func (h *DoctorHandler) GetAppointments(w http.ResponseWriter, r *http.Request) {
+    userID := r.Header.Get("X-User-ID")
+    userRole := r.Header.Get("X-User-Role")
+
+    if userID == "" {
+        utils.SendError(w, http.StatusUnauthorized, "Unauthorized")
+        return
+    }
+
+    page, err := pagination.Parse(r.URL.Query(), repository.AppointmentPages)
+    if err != nil {
+        utils.SendAppError(w, err, "Invalid pagination parameters")
+        return
+    }
+
+    var appointments []models.Appointment
+    var next string
+    switch userRole {
+    case "patient":
+        patientProfileID, err := h.repo.GetPatientProfileIDByUserID(r.Context(), userID)
+        if err != nil {
+            utils.SendErrorCode(w, apperrors.CodeProfileNotFound, "Patient profile not found")
+            return
+        }
+        appointments, next, err = h.repo.GetAppointmentsByPatientID(r.Context(), patientProfileID, page)
+        if err != nil {
+            utils.SendAppError(w, err, "Failed to retrieve appointments")
+            return
+        }
+    case "doctor":
+        doctorProfile, err := h.repo.GetProfileByUserID(r.Context(), userID)
+        if err != nil {
+            utils.SendErrorCode(w, apperrors.CodeProfileNotFound, "Doctor profile not found")
+            return
+        }
+        appointments, next, err = h.repo.GetAppointmentsByDoctorID(r.Context(), doctorProfile.ID, page)
+        if err != nil {
+            utils.SendAppError(w, err, "Failed to retrieve appointments")
+            return
+        }
+    default:
+        utils.SendErrorCode(w, apperrors.CodeAccessDenied, "Access denied")
+        return
+    }
+
+    if appointments == nil {
+        appointments = []models.Appointment{}
+    }
+    utils.SendPage(w, http.StatusOK, "Appointments retrieved", appointments, next)
+}
+
+// partyAppointment loads an appointment the requesting patient or doctor
+// takes part in. It writes the error response and returns false otherwise.
+func (h *DoctorHandler) partyAppointment(w http.ResponseWriter, r *http.Request) (*models.Appointment, bool) {
+    userID := r.Header.Get("X-User-ID")
+    userRole := r.Header.Get("X-User-Role")
+
+    if userID == "" {
+        utils.SendError(w, http.StatusUnauthorized, "Unauthorized")
+        return nil, false
+    }
+
+    appointmentID := r.URL.Query().Get("id")
+    if appointmentID == "" {
+        utils.SendErrorCode(w, apperrors.CodeValidation, "Appointment ID is required")
+        return nil, false
+    }
+
+    var partyID string
+    switch userRole {
+    case "patient":
+        patientProfileID, err := h.repo.GetPatientProfileIDByUserID(r.Context(), userID)
+        if err != nil {
+            utils.SendErrorCode(w, apperrors.CodeProfileNotFound, "Patient profile not found")
+            return nil, false
+        }
+        partyID = patientProfileID
+    case "doctor":
+        doctorProfile, err := h.repo.GetProfileByUserID(r.Context(), userID)
+        if err != nil {
+            utils.SendErrorCode(w, apperrors.CodeProfileNotFound, "Doctor profile not found")
+            return nil, false
+        }
+        partyID = doctorProfile.ID
+    default:
+        utils.SendErrorCode(w, apperrors.CodeAccessDenied, "Access denied")
+        return nil, false
+    }
+
+    // Other people's appointments are reported as missing rather than forbidden
+    appointment, err := h.repo.GetAppointmentByID(r.Context(), appointmentID)
+    if err != nil || (userRole == "patient" && appointment.PatientID != partyID) || (userRole == "doctor" && appointment.DoctorID != partyID) {
+        if err != nil && err != sql.ErrNoRows {
+            utils.SendAppError(w, err, "Failed to retrieve appointment")
+            return nil, false
+        }
+        utils.SendError(w, http.StatusNotFound, "Appointment not found")
+        return nil, false
+    }
+    return appointment, true
+}
+
+// GetAppointment gets one appointment of the current patient or doctor
+func (h *DoctorHandler) GetAppointment(w http.ResponseWriter, r *http.Request) {
+    appointment, ok := h.partyAppointment(w, r)
+    if !ok {
+        return
+    }
+
+    utils.SendSuccess(w, http.StatusOK, "Appointment retrieved", appointment)
+}
+
+// RescheduleAppointment moves a booked appointment that has not started to
+// another free slot of the same doctor. Either party can reschedule.
+func (h *DoctorHandler) RescheduleAppointment(w http.ResponseWriter, r *http.Request) {
+    appointment, ok := h.partyAppointment(w, r)
+    if !ok {
+        return
+    }
+
+    var req RescheduleAppointmentRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        utils.SendError(w, http.StatusBadRequest, "Invalid request body")
+        return
+    }
+    if req.StartsAt.IsZero() {
+        utils.SendErrorCode(w, apperrors.CodeValidation, "starts_at is required")
+        return
+    }
+
+    now := time.Now().UTC()
+    if appointment.Status != models.AppointmentBooked || !appointment.StartsAt.After(now) {
+        utils.SendErrorCode(w, apperrors.CodeConflict, "Only upcoming booked appointments can be rescheduled")
+        return
+    }
+
+    calendar, err := h.repo.GetCalendar(r.Context(), appointment.DoctorID)
+    if err != nil {
+        utils.SendAppError(w, err, "Failed to reschedule appointment")
+        return
+    }
+    slot, err := h.bookableSlot(r.Context(), calendar, req.StartsAt.UTC(), now)
+    if err != nil {
+        utils.SendAppError(w, err, "Failed to reschedule appointment")
+        return
+    }
+
+    moved, err := h.repo.RescheduleAppointment(r.Context(), appointment.ID, slot.StartsAt, slot.EndsAt)
+    if err != nil {
+        if err == sql.ErrNoRows {
+            utils.SendErrorCode(w, apperrors.CodeConflict, "Only upcoming booked appointments can be rescheduled")
+            return
+        }
+        sendBookingError(w, err, "Failed to reschedule appointment")
+        return
+    }
+
+    utils.SendSuccess(w, http.StatusOK, "Appointment rescheduled", moved)
+}
+
+// CancelAppointment cancels a booked appointment and frees its slot. Either
+// party can cancel.
+func (h *DoctorHandler) CancelAppointment(w http.ResponseWriter, r *http.Request) {
+    appointment, ok := h.partyAppointment(w, r)
+    if !ok {
+        return
+    }
+
+    var req CancelAppointmentRequest
+    if r.ContentLength != 0 {
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+            utils.SendError(w, http.StatusBadRequest, "Invalid request body")
+            return
+        }
+    }
+    reason := strings.TrimSpace(req.Reason)
+    if len(reason) > maxReasonLen {
+        utils.SendErrorCode(w, apperrors.CodeValidation, fmt.Sprintf("reason must be at most %d characters", maxReasonLen))
+        return
+    }
+
+    cancelled, err := h.repo.CancelAppointment(r.Context(), appointment.ID, r.Header.Get("X-User-Role"), reason)
+    if err != nil {
+        if err == sql.ErrNoRows {
+            utils.SendErrorCode(w, apperrors.CodeConflict, "Only booked appointments can be cancelled")
+            return
+        }
+        utils.SendAppError(w, err, "Failed to cancel appointment")
+        return
+    }
+
+    utils.SendSuccess(w, http.StatusOK, "Appointment cancelled", cancelled)
+}
+
+// CompleteAppointment marks an appointment that has started as completed.
+// When the doctor's calendar creates visits, a hospital visit is added to
+// the patient's timeline in the same transaction.
+func (h *DoctorHandler) CompleteAppointment(w http.ResponseWriter, r *http.Request) {
+    userID := r.Header.Get("X-User-ID")
+    userRole := r.Header.Get("X-User-Role")
+
+    if userID == "" {
+        utils.SendError(w, http.StatusUnauthorized, "Unauthorized")
+        return
+    }
+
+    if userRole != "doctor" {
+        utils.SendError(w, http.StatusForbidden, "Only doctors can complete appointments")
+        return
+    }
+
+    appointment, ok := h.partyAppointment(w, r)
+    if !ok {
+        return
+    }
+
+    var req CompleteAppointmentRequest
+    if r.ContentLength != 0 {
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+            utils.SendError(w, http.StatusBadRequest, "Invalid request body")
+            return
+        }
+    }
+    notes := strings.TrimSpace(req.Notes)
+    if len(notes) > maxReasonLen {
+        utils.SendErrorCode(w, apperrors.CodeValidation, fmt.Sprintf("notes must be at most %d characters", maxReasonLen))
+        return
+    }
+    if appointment.StartsAt.After(time.Now()) {
+        utils.SendErrorCode(w, apperrors.CodeValidation, "An appointment cannot be completed before it starts")
+        return
+    }
+
+    var completed *models.Appointment
+    var visit *models.HospitalVisit
+    err := h.repo.WithTx(r.Context(), func(ctx context.Context) error {
+        doctorProfile, err := h.repo.GetProfileByID(ctx, appointment.DoctorID)
+        if err != nil {
+            return err
+        }
+        calendar, err := h.repo.GetCalendar(ctx, appointment.DoctorID)
+        if err != nil {
+            return err
+        }
+
+        var visitID *string
+        if calendar.CreateVisits {
+            visit = &models.HospitalVisit{
+                PatientID:    appointment.PatientID,
+                HospitalName: calendar.Location,
+                VisitDate:    localDate(calendar, appointment.StartsAt),
+                Reason:       appointment.Reason,
+                Notes:        notes,
+            }
+            if visit.HospitalName == "" {
+                visit.HospitalName = doctorProfile.FullName
+            }
+            if visit.Reason == "" {
+                visit.Reason = "Appointment with " + doctorProfile.FullName
+            }
+            if err := h.repo.CreateVisit(ctx, visit); err != nil {
+                return err
+            }
+            if err := h.emitVisitCreated(ctx, visit); err != nil {
+                return err
+            }
+            visitID = &visit.ID
+        }
+
+        completed, err = h.repo.CompleteAppointment(ctx, appointment.ID, notes, visitID)
+        return err
+    })
+    if err != nil {
+        if err == sql.ErrNoRows {
+            utils.SendErrorCode(w, apperrors.CodeConflict, "Only booked appointments can be completed")
+            return
+        }
+        utils.SendAppError(w, err, "Failed to complete appointment")
+        return
+    }
+
+    if visit != nil {
+        if patientUserID, err := h.repo.GetPatientUserID(r.Context(), visit.PatientID); err != nil {
+            log.Printf("Failed to look up patient %s to notify: %v", visit.PatientID, err)
+        } else {
+            notify.Send(r.Context(), h.notifier, notify.VisitCreated(patientUserID, visit.PatientID, visit.ID, visit.HospitalName))
+        }
+    }
+
+    utils.SendSuccess(w, http.StatusOK, "Appointment completed", completed)
+}
+
+// emitVisitCreated stores a visit.created event for every doctor with access
+// to the visit's patient, in the transaction that creates the visit
+func (h *DoctorHandler) emitVisitCreated(ctx context.Context, visit *models.HospitalVisit) error {
+    doctorUserIDs, err := h.repo.GetDoctorUserIDsWithAccess(ctx, visit.PatientID)
+    if err != nil {
+        return err
+    }
+
+    events := make([]webhooks.Event, len(doctorUserIDs))
+    for i, doctorUserID := range doctorUserIDs {
+        events[i] = webhooks.VisitCreated(doctorUserID, visit.PatientID, visit.ID, visit.HospitalName, visit.VisitDate)
+    }
+    return h.events.Emit(ctx, events...)
+}
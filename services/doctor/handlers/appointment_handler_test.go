@@ -0,0 +1,210 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"health-bar/shared/apperrors"
+	"health-bar/shared/availability"
+	"health-bar/shared/models"
+	"health-bar/shared/testutil"
+	"health-bar/shared/webhooks"
+
+	"github.com/google/uuid"
+)
+
+// openCalendar makes the doctor bookable around the clock in hour slots
+func openCalendar(t *testing.T, h *DoctorHandler, doctorUserID string) {
+	t.Helper()
+
+	var weekly []models.AvailabilityWindow
+	for day := 0; day < 7; day++ {
+		weekly = append(weekly, models.AvailabilityWindow{Weekday: day, Start: "00:00", End: "24:00"})
+	}
+	req := CalendarRequest{Timezone: "UTC", SlotMinutes: 60, Location: "City Clinic", Weekly: weekly}
+	rec, _ := testutil.Serve(t, h.SetCalendar, testutil.NewRequest(t, http.MethodPut, "/api/doctors/availability", req, doctorUserID, "doctor"))
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+}
+
+func TestSetCalendar(t *testing.T) {
+	h, db := newTestHandler()
+	doctor := db.AddDoctor("d@test.com", "Dr Who")
+	patient := db.AddPatient("p@test.com", "Pat")
+
+	valid := CalendarRequest{Timezone: "Europe/Berlin", SlotMinutes: 20, Weekly: []models.AvailabilityWindow{{Weekday: 1, Start: "09:00", End: "12:00"}}}
+	badZone, badSlot, overlap := valid, valid, valid
+	badZone.Timezone = "Nowhere"
+	badSlot.SlotMinutes = 1
+	overlap.Weekly = append(overlap.Weekly, models.AvailabilityWindow{Weekday: 1, Start: "11:00", End: "13:00"})
+	for name, req := range map[string]CalendarRequest{"timezone": badZone, "slot": badSlot, "overlap": overlap} {
+		_, resp := testutil.Serve(t, h.SetCalendar, testutil.NewRequest(t, http.MethodPut, "/api/doctors/availability", req, doctor.UserID, "doctor"))
+		if resp.Code != apperrors.CodeValidation {
+			t.Errorf("%s: code %q", name, resp.Code)
+		}
+	}
+
+	rec, _ := testutil.Serve(t, h.SetCalendar, testutil.NewRequest(t, http.MethodPut, "/api/doctors/availability", valid, patient.UserID, "patient"))
+	testutil.ExpectStatus(t, rec, http.StatusForbidden)
+
+	rec, _ = testutil.Serve(t, h.SetCalendar, testutil.NewRequest(t, http.MethodPut, "/api/doctors/availability", valid, doctor.UserID, "doctor"))
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+
+	rec, resp := testutil.Serve(t, h.GetCalendar, testutil.NewRequest(t, http.MethodGet, "/api/doctors/availability?doctor_id="+doctor.ID, nil, patient.UserID, "patient"))
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+	var view CalendarView
+	testutil.DecodeData(t, resp, &view)
+	if !view.CreateVisits || view.SlotMinutes != 20 || len(view.Weekly) != 1 || view.Exceptions == nil {
+		t.Fatalf("calendar = %+v", view)
+	}
+}
+
+func TestBookAppointment(t *testing.T) {
+	h, db := newTestHandler()
+	doctor := db.AddDoctor("d@test.com", "Dr Who")
+	busy := db.AddDoctor("b@test.com", "Dr Busy")
+	patient := db.AddPatient("p@test.com", "Pat")
+	other := db.AddPatient("o@test.com", "Other")
+	openCalendar(t, h, doctor.UserID)
+	openCalendar(t, h, busy.UserID)
+
+	tomorrow := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, 1)
+	ten := tomorrow.Add(10 * time.Hour)
+
+	book := func(userID, doctorID string, startsAt time.Time) (int, apperrors.Code, models.Appointment) {
+		req := BookAppointmentRequest{DoctorID: doctorID, StartsAt: startsAt, Reason: "Check-up"}
+		rec, resp := testutil.Serve(t, h.BookAppointment, testutil.NewRequest(t, http.MethodPost, "/api/doctors/appointments", req, userID, "patient"))
+		var appointment models.Appointment
+		if rec.Code == http.StatusCreated {
+			testutil.DecodeData(t, resp, &appointment)
+		}
+		return rec.Code, resp.Code, appointment
+	}
+
+	status, _, appointment := book(patient.UserID, doctor.ID, ten)
+	if status != http.StatusCreated || !appointment.EndsAt.Equal(ten.Add(time.Hour)) || appointment.Status != models.AppointmentBooked {
+		t.Fatalf("book: %d %+v", status, appointment)
+	}
+
+	for i, tc := range []struct {
+		userID, doctorID string
+		startsAt         time.Time
+		code             apperrors.Code
+	}{
+		{other.UserID, doctor.ID, ten, apperrors.CodeConflict},
+		{patient.UserID, busy.ID, ten, apperrors.CodeConflict},
+		{other.UserID, doctor.ID, ten.Add(15 * time.Minute), apperrors.CodeValidation},
+		{other.UserID, doctor.ID, tomorrow.AddDate(0, 0, -2), apperrors.CodeValidation},
+		{other.UserID, uuid.New().String(), ten, apperrors.CodeInvalidReference},
+	} {
+		if _, code, _ := book(tc.userID, tc.doctorID, tc.startsAt); code != tc.code {
+			t.Errorf("case %d: code %q, want %q", i, code, tc.code)
+		}
+	}
+
+	slotsOn := func(day time.Time) []availability.Slot {
+		target := "/api/doctors/slots?doctor_id=" + doctor.ID + "&from=" + day.Format("2006-01-02") + "&to=" + day.Format("2006-01-02")
+		rec, resp := testutil.Serve(t, h.GetSlots, testutil.NewRequest(t, http.MethodGet, target, nil, other.UserID, "patient"))
+		testutil.ExpectStatus(t, rec, http.StatusOK)
+		var slots []availability.Slot
+		testutil.DecodeData(t, resp, &slots)
+		return slots
+	}
+	if slots := slotsOn(tomorrow); len(slots) != 23 {
+		t.Fatalf("%d free slots tomorrow, want 23", len(slots))
+	}
+
+	// The doctor moves it to 11:00, which frees 10:00 for someone else
+	target := "/api/doctors/appointments/reschedule?id=" + appointment.ID
+	rec, resp := testutil.Serve(t, h.RescheduleAppointment, testutil.NewRequest(t, http.MethodPost, target, RescheduleAppointmentRequest{StartsAt: ten.Add(time.Hour)}, doctor.UserID, "doctor"))
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+	testutil.DecodeData(t, resp, &appointment)
+	if !appointment.StartsAt.Equal(ten.Add(time.Hour)) {
+		t.Fatalf("rescheduled to %s", appointment.StartsAt)
+	}
+	if status, _, _ := book(other.UserID, doctor.ID, ten); status != http.StatusCreated {
+		t.Fatalf("booking the freed slot: %d", status)
+	}
+
+	// Another patient cannot see or cancel it
+	rec, _ = testutil.Serve(t, h.CancelAppointment, testutil.NewRequest(t, http.MethodPost, "/api/doctors/appointments/cancel?id="+appointment.ID, nil, other.UserID, "patient"))
+	testutil.ExpectStatus(t, rec, http.StatusNotFound)
+
+	rec, resp = testutil.Serve(t, h.CancelAppointment, testutil.NewRequest(t, http.MethodPost, "/api/doctors/appointments/cancel?id="+appointment.ID, CancelAppointmentRequest{Reason: "Feeling better"}, patient.UserID, "patient"))
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+	testutil.DecodeData(t, resp, &appointment)
+	if appointment.Status != models.AppointmentCancelled || appointment.CancelledBy != "patient" {
+		t.Fatalf("cancelled = %+v", appointment)
+	}
+	rec, _ = testutil.Serve(t, h.CancelAppointment, testutil.NewRequest(t, http.MethodPost, "/api/doctors/appointments/cancel?id="+appointment.ID, nil, patient.UserID, "patient"))
+	testutil.ExpectStatus(t, rec, http.StatusConflict)
+	if status, _, _ := book(patient.UserID, busy.ID, ten); status != http.StatusCreated {
+		t.Fatalf("booking after cancelling: %d", status)
+	}
+
+	// A day off removes the day's slots
+	exception := ExceptionRequest{Date: tomorrow.AddDate(0, 0, 1).Format("2006-01-02"), Reason: "Conference"}
+	rec, _ = testutil.Serve(t, h.CreateException, testutil.NewRequest(t, http.MethodPost, "/api/doctors/availability/exceptions", exception, doctor.UserID, "doctor"))
+	testutil.ExpectStatus(t, rec, http.StatusCreated)
+	if slots := slotsOn(tomorrow.AddDate(0, 0, 1)); len(slots) != 0 {
+		t.Fatalf("%d slots on a day off", len(slots))
+	}
+	if _, code, _ := book(other.UserID, doctor.ID, ten.AddDate(0, 0, 1)); code != apperrors.CodeValidation {
+		t.Fatalf("booking on a day off: code %q", code)
+	}
+
+	rec, resp = testutil.Serve(t, h.GetAppointments, testutil.NewRequest(t, http.MethodGet, "/api/doctors/appointments?status=booked", nil, doctor.UserID, "doctor"))
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+	var appointments []models.Appointment
+	testutil.DecodeData(t, resp, &appointments)
+	if len(appointments) != 1 || appointments[0].PatientID != other.ID {
+		t.Fatalf("doctor's booked appointments = %+v", appointments)
+	}
+}
+
+func TestCompleteAppointmentCreatesVisit(t *testing.T) {
+	h, db := newTestHandler()
+	doctor := db.AddDoctor("d@test.com", "Dr Who")
+	patient := db.AddPatient("p@test.com", "Pat")
+	openCalendar(t, h, doctor.UserID)
+	h.UseWebhooks(webhooks.NewMemoryEmitter(db))
+	db.Grant(patient.ID, doctor.ID)
+	sub := db.AddWebhookSubscription(doctor.UserID, "https://clinic.test/hooks", webhooks.EventVisitCreated)
+
+	// Seed one that already started; bookings must be in the future
+	started := time.Now().UTC().Truncate(time.Hour).Add(-time.Hour)
+	appointment := models.Appointment{ID: uuid.New().String(), DoctorID: doctor.ID, PatientID: patient.ID,
+		StartsAt: started, EndsAt: started.Add(time.Hour), Status: models.AppointmentBooked, Reason: "Back pain"}
+	db.Appointments.Rows[appointment.ID] = appointment
+
+	target := "/api/doctors/appointments/complete?id=" + appointment.ID
+	rec, _ := testutil.Serve(t, h.CompleteAppointment, testutil.NewRequest(t, http.MethodPost, target, nil, patient.UserID, "patient"))
+	testutil.ExpectStatus(t, rec, http.StatusForbidden)
+
+	rec, resp := testutil.Serve(t, h.CompleteAppointment, testutil.NewRequest(t, http.MethodPost, target, CompleteAppointmentRequest{Notes: "Physiotherapy"}, doctor.UserID, "doctor"))
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+	var completed models.Appointment
+	testutil.DecodeData(t, resp, &completed)
+	if completed.Status != models.AppointmentCompleted || completed.VisitID == nil {
+		t.Fatalf("completed = %+v", completed)
+	}
+	visit := db.HospitalVisits.Rows[*completed.VisitID]
+	if visit.PatientID != patient.ID || visit.HospitalName != "City Clinic" || visit.Reason != "Back pain" || visit.Notes != "Physiotherapy" {
+		t.Fatalf("visit = %+v", visit)
+	}
+	if len(db.WebhookDeliveries.Rows) != 1 {
+		t.Fatalf("deliveries = %+v", db.WebhookDeliveries.Rows)
+	}
+	for _, d := range db.WebhookDeliveries.Rows {
+		if d.SubscriptionID != sub.ID || d.EventType != webhooks.EventVisitCreated || !strings.Contains(string(d.Payload), visit.ID) {
+			t.Fatalf("delivery = %+v", d)
+		}
+	}
+
+	rec, _ = testutil.Serve(t, h.CompleteAppointment, testutil.NewRequest(t, http.MethodPost, target, nil, doctor.UserID, "doctor"))
+	testutil.ExpectStatus(t, rec, http.StatusConflict)
+	if len(db.HospitalVisits.Rows) != 1 {
+		t.Fatalf("%d visits after completing twice", len(db.HospitalVisits.Rows))
+	}
+}
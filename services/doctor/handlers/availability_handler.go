@@ -0,0 +1,338 @@
+package handlers
+
+import (
+    "database/sql"
+    "encoding/json"
+    "fmt"
+    "health-bar/shared/apperrors"
+    "health-bar/shared/availability"
+    "health-bar/shared/models"
+    "health-bar/shared/pagination"
+    "health-bar/shared/utils"
+    "net/http"
+    "strings"
+    "time"
+)
+
+const (
+    defaultSlotDays = 7
+    maxSlotDays     = 31
+    maxReasonLen    = 2000
+)
+
+type CalendarRequest struct {
+    Timezone     string                      `json:"timezone"`      // IANA name, e.g. "Europe/Berlin"
+    SlotMinutes  int                         `json:"slot_minutes"`
+    Location     string                      `json:"location"`      // Used as the hospital name of visits
+    CreateVisits *bool                       `json:"create_visits"` // Defaults to true
+    Weekly       []models.AvailabilityWindow `json:"weekly"`
+}
+
+type ExceptionRequest struct {
+    Date      string  `json:"date"`  // YYYY-MM-DD, local to the calendar
+    Start     *string `json:"start"` // Omit start and end to cover the whole day
+    End       *string `json:"end"`
+    Available bool    `json:"available"` // Adds time instead of taking it away
+    Reason    string  `json:"reason"`
+}
+
+// CalendarView is a calendar with its upcoming exceptions
+type CalendarView struct {
+    models.DoctorCalendar
+    Exceptions []models.AvailabilityException `json:"exceptions"`
+}
+
+// localDate is the date of t in the calendar's timezone
+func localDate(calendar *models.DoctorCalendar, t time.Time) time.Time {
+    loc, err := availability.Location(calendar.Timezone)
+    if err != nil {
+        loc = time.UTC
+    }
+    date, _ := time.Parse("2006-01-02", pagination.Date(t.In(loc)))
+    return date
+}
+
+// SetCalendar creates or replaces the current doctor's weekly availability.
+// Appointments already booked are kept.
+func (h *DoctorHandler) SetCalendar(w http.ResponseWriter, r *http.Request) {
+    userID := r.Header.Get("X-User-ID")
+    userRole := r.Header.Get("X-User-Role")
+
+    if userID == "" {
+        utils.SendError(w, http.StatusUnauthorized, "Unauthorized")
+        return
+    }
+
+    if userRole != "doctor" {
+        utils.SendError(w, http.StatusForbidden, "Only doctors can set availability")
+        return
+    }
+
+    var req CalendarRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        utils.SendError(w, http.StatusBadRequest, "Invalid request body")
+        return
+    }
+
+    timezone := strings.TrimSpace(req.Timezone)
+    if _, err := availability.Location(timezone); err != nil {
+        utils.SendErrorCode(w, apperrors.CodeValidation, err.Error())
+        return
+    }
+    if req.SlotMinutes < availability.MinSlotMinutes || req.SlotMinutes > availability.MaxSlotMinutes {
+        utils.SendErrorCode(w, apperrors.CodeValidation, fmt.Sprintf("slot_minutes must be between %d and %d", availability.MinSlotMinutes, availability.MaxSlotMinutes))
+        return
+    }
+    location := strings.TrimSpace(req.Location)
+    if len(location) > 255 {
+        utils.SendErrorCode(w, apperrors.CodeValidation, "location is too long")
+        return
+    }
+    weekly, err := availability.Weekly(req.Weekly)
+    if err != nil {
+        utils.SendErrorCode(w, apperrors.CodeValidation, err.Error())
+        return
+    }
+
+    doctorProfile, err := h.repo.GetProfileByUserID(r.Context(), userID)
+    if err != nil {
+        utils.SendErrorCode(w, apperrors.CodeProfileNotFound, "Doctor profile not found")
+        return
+    }
+
+    calendar := &models.DoctorCalendar{
+        DoctorID:     doctorProfile.ID,
+        Timezone:     timezone,
+        SlotMinutes:  req.SlotMinutes,
+        Location:     location,
+        CreateVisits: req.CreateVisits == nil || *req.CreateVisits,
+        Weekly:       weekly,
+    }
+    if err := h.repo.SaveCalendar(r.Context(), calendar); err != nil {
+        utils.SendAppError(w, err, "Failed to save availability")
+        return
+    }
+
+    utils.SendSuccess(w, http.StatusOK, "Availability saved", calendar)
+}
+
+// calendarDoctor resolves whose calendar a request reads: the doctor_id
+// given, or the current doctor's own. It writes the error response and
+// returns false when there is none.
+func (h *DoctorHandler) calendarDoctor(w http.ResponseWriter, r *http.Request) (*models.DoctorCalendar, bool) {
+    userID := r.Header.Get("X-User-ID")
+    userRole := r.Header.Get("X-User-Role")
+
+    if userID == "" {
+        utils.SendError(w, http.StatusUnauthorized, "Unauthorized")
+        return nil, false
+    }
+
+    doctorID := r.URL.Query().Get("doctor_id")
+    if doctorID == "" {
+        if userRole != "doctor" {
+            utils.SendErrorCode(w, apperrors.CodeValidation, "Doctor ID is required")
+            return nil, false
+        }
+        doctorProfile, err := h.repo.GetProfileByUserID(r.Context(), userID)
+        if err != nil {
+            utils.SendErrorCode(w, apperrors.CodeProfileNotFound, "Doctor profile not found")
+            return nil, false
+        }
+        doctorID = doctorProfile.ID
+    }
+
+    calendar, err := h.repo.GetCalendar(r.Context(), doctorID)
+    if err != nil {
+        if err == sql.ErrNoRows {
+            utils.SendError(w, http.StatusNotFound, "The doctor has no availability calendar")
+            return nil, false
+        }
+        utils.SendAppError(w, err, "Failed to retrieve availability")
+        return nil, false
+    }
+    return calendar, true
+}
+
+// GetCalendar returns a doctor's weekly availability and the exceptions
+// from today on. Doctors omit doctor_id to read their own.
+func (h *DoctorHandler) GetCalendar(w http.ResponseWriter, r *http.Request) {
+    calendar, ok := h.calendarDoctor(w, r)
+    if !ok {
+        return
+    }
+
+    from := localDate(calendar, time.Now())
+    exceptions, err := h.repo.ListAvailabilityExceptions(r.Context(), calendar.DoctorID, from, from.AddDate(1, 0, 0))
+    if err != nil {
+        utils.SendAppError(w, err, "Failed to retrieve availability")
+        return
+    }
+
+    if exceptions == nil {
+        exceptions = []models.AvailabilityException{}
+    }
+    utils.SendSuccess(w, http.StatusOK, "Availability retrieved", CalendarView{*calendar, exceptions})
+}
+
+// CreateException blocks or adds time on one date of the current doctor's
+// calendar. Appointments already booked are kept.
+func (h *DoctorHandler) CreateException(w http.ResponseWriter, r *http.Request) {
+    userID := r.Header.Get("X-User-ID")
+    userRole := r.Header.Get("X-User-Role")
+
+    if userID == "" {
+        utils.SendError(w, http.StatusUnauthorized, "Unauthorized")
+        return
+    }
+
+    if userRole != "doctor" {
+        utils.SendError(w, http.StatusForbidden, "Only doctors can change availability")
+        return
+    }
+
+    var req ExceptionRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        utils.SendError(w, http.StatusBadRequest, "Invalid request body")
+        return
+    }
+
+    date, err := time.Parse("2006-01-02", req.Date)
+    if err != nil {
+        utils.SendErrorCode(w, apperrors.CodeValidation, "Invalid date. Use YYYY-MM-DD")
+        return
+    }
+    if len(req.Reason) > maxReasonLen {
+        utils.SendErrorCode(w, apperrors.CodeValidation, fmt.Sprintf("reason must be at most %d characters", maxReasonLen))
+        return
+    }
+    exception := &models.AvailabilityException{
+        Date:      date,
+        Start:     req.Start,
+        End:       req.End,
+        Available: req.Available,
+        Reason:    strings.TrimSpace(req.Reason),
+    }
+    if err := availability.Exception(*exception); err != nil {
+        utils.SendErrorCode(w, apperrors.CodeValidation, err.Error())
+        return
+    }
+
+    doctorProfile, err := h.repo.GetProfileByUserID(r.Context(), userID)
+    if err != nil {
+        utils.SendErrorCode(w, apperrors.CodeProfileNotFound, "Doctor profile not found")
+        return
+    }
+
+    exception.DoctorID = doctorProfile.ID
+    if err := h.repo.CreateAvailabilityException(r.Context(), exception); err != nil {
+        utils.SendAppError(w, err, "Failed to add exception")
+        return
+    }
+
+    utils.SendSuccess(w, http.StatusCreated, "Exception added", exception)
+}
+
+// DeleteException removes an exception from the current doctor's calendar
+func (h *DoctorHandler) DeleteException(w http.ResponseWriter, r *http.Request) {
+    userID := r.Header.Get("X-User-ID")
+    userRole := r.Header.Get("X-User-Role")
+
+    if userID == "" {
+        utils.SendError(w, http.StatusUnauthorized, "Unauthorized")
+        return
+    }
+
+    if userRole != "doctor" {
+        utils.SendError(w, http.StatusForbidden, "Only doctors can change availability")
+        return
+    }
+
+    exceptionID := r.URL.Query().Get("id")
+    if exceptionID == "" {
+        utils.SendErrorCode(w, apperrors.CodeValidation, "Exception ID is required")
+        return
+    }
+
+    doctorProfile, err := h.repo.GetProfileByUserID(r.Context(), userID)
+    if err != nil {
+        utils.SendErrorCode(w, apperrors.CodeProfileNotFound, "Doctor profile not found")
+        return
+    }
+
+    // Another doctor's exception is reported as missing rather than forbidden
+    exception, err := h.repo.GetAvailabilityExceptionByID(r.Context(), exceptionID)
+    if err != nil || exception.DoctorID != doctorProfile.ID {
+        if err != nil && err != sql.ErrNoRows {
+            utils.SendAppError(w, err, "Failed to delete exception")
+            return
+        }
+        utils.SendError(w, http.StatusNotFound, "Exception not found")
+        return
+    }
+
+    if err := h.repo.DeleteAvailabilityException(r.Context(), exceptionID); err != nil {
+        utils.SendAppError(w, err, "Failed to delete exception")
+        return
+    }
+
+    utils.SendSuccess(w, http.StatusOK, "Exception deleted", nil)
+}
+
+// GetSlots lists a doctor's free slots on the dates from through to, local
+// to the doctor's calendar (the next 7 days by default)
+func (h *DoctorHandler) GetSlots(w http.ResponseWriter, r *http.Request) {
+    calendar, ok := h.calendarDoctor(w, r)
+    if !ok {
+        return
+    }
+
+    var err error
+    q := r.URL.Query()
+    now := time.Now().UTC()
+    from := localDate(calendar, now)
+    if raw := q.Get("from"); raw != "" {
+        if from, err = time.Parse("2006-01-02", raw); err != nil {
+            utils.SendErrorCode(w, apperrors.CodeValidation, "Invalid from date. Use YYYY-MM-DD")
+            return
+        }
+    }
+    to := from.AddDate(0, 0, defaultSlotDays-1)
+    if raw := q.Get("to"); raw != "" {
+        if to, err = time.Parse("2006-01-02", raw); err != nil {
+            utils.SendErrorCode(w, apperrors.CodeValidation, "Invalid to date. Use YYYY-MM-DD")
+            return
+        }
+    }
+    if to.Before(from) {
+        utils.SendErrorCode(w, apperrors.CodeValidation, "from must not be after to")
+        return
+    }
+    if to.Sub(from) >= maxSlotDays*24*time.Hour {
+        utils.SendErrorCode(w, apperrors.CodeValidation, fmt.Sprintf("Slots can be listed for at most %d days", maxSlotDays))
+        return
+    }
+
+    slots, err := h.freeSlots(r, calendar, from, to, now)
+    if err != nil {
+        utils.SendAppError(w, err, "Failed to retrieve slots")
+        return
+    }
+
+    utils.SendSuccess(w, http.StatusOK, "Slots retrieved", slots)
+}
+
+// freeSlots lists the slots of calendar on the local dates from through to
+// that are not booked and have not started
+func (h *DoctorHandler) freeSlots(r *http.Request, calendar *models.DoctorCalendar, from, to, now time.Time) ([]availability.Slot, error) {
+    exceptions, err := h.repo.ListAvailabilityExceptions(r.Context(), calendar.DoctorID, from, to)
+    if err != nil {
+        return nil, err
+    }
+    // Local dates reach up to a day either side of the same UTC dates
+    booked, err := h.repo.GetBookedAppointments(r.Context(), calendar.DoctorID, from.AddDate(0, 0, -1), to.AddDate(0, 0, 2))
+    if err != nil {
+        return nil, err
+    }
+    return availability.Free(availability.Slots(*calendar, exceptions, from, to), booked, now), nil
+}
@@ -1,21 +1,40 @@
 package handlers
 
 import (
+    "context"
     "database/sql"
     "encoding/json"
+    "health-bar/shared/apperrors"
     "health-bar/shared/models"
+    "health-bar/shared/notify"
+    "health-bar/shared/pagination"
+    "health-bar/shared/patch"
     "health-bar/shared/utils"
+    "health-bar/shared/webhooks"
     "health-bar/services/doctor/repository"
     "net/http"
-    "strings"
 )
 
 type DoctorHandler struct {
-    repo *repository.DoctorRepository
+    repo     repository.Store
+    notifier notify.Notifier
+    events   webhooks.Emitter
 }
 
-func NewDoctorHandler(repo *repository.DoctorRepository) *DoctorHandler {
-    return &DoctorHandler{repo: repo}
+func NewDoctorHandler(repo repository.Store) *DoctorHandler {
+    return &DoctorHandler{repo: repo, notifier: notify.LogNotifier{}, events: webhooks.Discard{}}
+}
+
+// UseNotifier sets where patients are told about their record being viewed
+// and visits added from appointments
+func (h *DoctorHandler) UseNotifier(notifier notify.Notifier) {
+    h.notifier = notifier
+}
+
+// UseWebhooks sets where webhook events about visits added from
+// appointments are stored
+func (h *DoctorHandler) UseWebhooks(events webhooks.Emitter) {
+    h.events = events
 }
 
 type CreateProfileRequest struct {
@@ -55,7 +74,7 @@ func (h *DoctorHandler) CreateProfile(w http.ResponseWriter, r *http.Request) {
 
     // Validate
     if req.FullName == "" {
-        utils.SendError(w, http.StatusBadRequest, "Full name is required")
+        utils.SendErrorCode(w, apperrors.CodeValidation, "Full name is required")
         return
     }
 
@@ -66,12 +85,12 @@ func (h *DoctorHandler) CreateProfile(w http.ResponseWriter, r *http.Request) {
         Phone:          req.Phone,
     }
 
-    if err := h.repo.CreateProfile(userID, profile); err != nil {
-        if strings.Contains(err.Error(), "duplicate") || strings.Contains(err.Error(), "unique") {
-            utils.SendError(w, http.StatusConflict, "Profile already exists")
+    if err := h.repo.CreateProfile(r.Context(), userID, profile); err != nil {
+        if apperrors.IsUniqueViolation(err) {
+            utils.SendErrorCode(w, apperrors.CodeProfileExists, "Profile already exists")
             return
         }
-        utils.SendError(w, http.StatusInternalServerError, "Failed to create profile")
+        utils.SendAppError(w, err, "Failed to create profile")
         return
     }
 
@@ -93,20 +112,21 @@ func (h *DoctorHandler) GetMyProfile(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    profile, err := h.repo.GetProfileByUserID(userID)
+    profile, err := h.repo.GetProfileByUserID(r.Context(), userID)
     if err != nil {
         if err == sql.ErrNoRows {
-            utils.SendError(w, http.StatusNotFound, "Profile not found")
+            utils.SendErrorCode(w, apperrors.CodeProfileNotFound, "Profile not found")
             return
         }
-        utils.SendError(w, http.StatusInternalServerError, "Failed to retrieve profile")
+        utils.SendAppError(w, err, "Failed to retrieve profile")
         return
     }
 
+    utils.SetETag(w, profile.Version)
     utils.SendSuccess(w, http.StatusOK, "Profile retrieved", profile)
 }
 
-// UpdateProfile updates doctor profile
+// UpdateProfile replaces the doctor profile
 func (h *DoctorHandler) UpdateProfile(w http.ResponseWriter, r *http.Request) {
     userID := r.Header.Get("X-User-ID")
     userRole := r.Header.Get("X-User-Role")
@@ -127,25 +147,98 @@ func (h *DoctorHandler) UpdateProfile(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    profile := &models.DoctorProfile{
-        FullName:       req.FullName,
-        Specialization: req.Specialization,
-        LicenseNumber:  req.LicenseNumber,
-        Phone:          req.Phone,
+    h.updateProfile(w, r, userID, func(*models.DoctorProfile) (*models.DoctorProfile, error) {
+        return req.profile()
+    })
+}
+
+// PatchProfile applies a JSON merge patch to the doctor profile
+func (h *DoctorHandler) PatchProfile(w http.ResponseWriter, r *http.Request) {
+    userID := r.Header.Get("X-User-ID")
+    userRole := r.Header.Get("X-User-Role")
+
+    if userID == "" {
+        utils.SendError(w, http.StatusUnauthorized, "Unauthorized")
+        return
+    }
+
+    if userRole != "doctor" {
+        utils.SendError(w, http.StatusForbidden, "Only doctors can update their profile")
+        return
+    }
+
+    changes, err := patch.Read(r)
+    if err != nil {
+        utils.SendAppError(w, err, "Invalid request body")
+        return
+    }
+
+    h.updateProfile(w, r, userID, func(current *models.DoctorProfile) (*models.DoctorProfile, error) {
+        doc := UpdateProfileRequest{
+            FullName:       current.FullName,
+            Specialization: current.Specialization,
+            LicenseNumber:  current.LicenseNumber,
+            Phone:          current.Phone,
+        }
+        if err := patch.Apply(&doc, changes); err != nil {
+            return nil, err
+        }
+        return doc.profile()
+    })
+}
+
+// updateProfile writes the profile built by change from the current one,
+// under the If-Match precondition and the row version
+func (h *DoctorHandler) updateProfile(w http.ResponseWriter, r *http.Request, userID string, change func(*models.DoctorProfile) (*models.DoctorProfile, error)) {
+    current, err := h.repo.GetProfileByUserID(r.Context(), userID)
+    if err != nil {
+        if err == sql.ErrNoRows {
+            utils.SendErrorCode(w, apperrors.CodeProfileNotFound, "Profile not found")
+            return
+        }
+        utils.SendAppError(w, err, "Failed to retrieve profile")
+        return
     }
 
-    if err := h.repo.UpdateProfile(userID, profile); err != nil {
+    if err := utils.CheckIfMatch(r, current.Version); err != nil {
+        utils.SendAppError(w, err, "Precondition failed")
+        return
+    }
+
+    profile, err := change(current)
+    if err != nil {
+        utils.SendAppError(w, err, "Invalid profile")
+        return
+    }
+    profile.Version = current.Version
+
+    if err := h.repo.UpdateProfile(r.Context(), userID, profile); err != nil {
         if err == sql.ErrNoRows {
-            utils.SendError(w, http.StatusNotFound, "Profile not found")
+            utils.SendErrorCode(w, apperrors.CodeProfileNotFound, "Profile not found")
             return
         }
-        utils.SendError(w, http.StatusInternalServerError, "Failed to update profile")
+        utils.SendAppError(w, err, "Failed to update profile")
         return
     }
 
+    utils.SetETag(w, profile.Version)
     utils.SendSuccess(w, http.StatusOK, "Profile updated successfully", profile)
 }
 
+// profile validates req and converts it to a profile
+func (req UpdateProfileRequest) profile() (*models.DoctorProfile, error) {
+    if req.FullName == "" {
+        return nil, apperrors.New(apperrors.CodeValidation, "Full name is required")
+    }
+
+    return &models.DoctorProfile{
+        FullName:       req.FullName,
+        Specialization: req.Specialization,
+        LicenseNumber:  req.LicenseNumber,
+        Phone:          req.Phone,
+    }, nil
+}
+
 // GetPatientProfile gets a patient's profile (if doctor has access)
 func (h *DoctorHandler) GetPatientProfile(w http.ResponseWriter, r *http.Request) {
     userID := r.Header.Get("X-User-ID")
@@ -162,31 +255,47 @@ func (h *DoctorHandler) GetPatientProfile(w http.ResponseWriter, r *http.Request
     }
 
     // Get doctor profile
-    doctorProfile, err := h.repo.GetProfileByUserID(userID)
+    doctorProfile, err := h.repo.GetProfileByUserID(r.Context(), userID)
     if err != nil {
-        utils.SendError(w, http.StatusNotFound, "Doctor profile not found")
+        utils.SendErrorCode(w, apperrors.CodeProfileNotFound, "Doctor profile not found")
         return
     }
 
     // Get patient ID from URL query
     patientID := r.URL.Query().Get("patient_id")
     if patientID == "" {
-        utils.SendError(w, http.StatusBadRequest, "Patient ID is required")
+        utils.SendErrorCode(w, apperrors.CodeValidation, "Patient ID is required")
         return
     }
 
-    // Get patient profile (with permission check)
-    patientProfile, err := h.repo.GetPatientProfile(doctorProfile.ID, patientID)
+    // Get patient profile (with permission check) and clinical record. Both
+    // reads see one snapshot, so the record returned is the one at a moment
+    // the doctor had access, even if a revoke commits between the reads.
+    var chart models.PatientChart
+    err = h.repo.ReadSnapshot(r.Context(), func(ctx context.Context) error {
+        patientProfile, err := h.repo.GetPatientProfile(ctx, doctorProfile.ID, patientID)
+        if err != nil {
+            return err
+        }
+        record, err := h.repo.GetClinicalRecord(ctx, patientID)
+        if err != nil {
+            return err
+        }
+        chart = models.PatientChart{PatientProfile: *patientProfile, ClinicalRecord: *record}
+        return nil
+    })
     if err != nil {
         if err == sql.ErrNoRows {
-            utils.SendError(w, http.StatusForbidden, "Access denied or patient not found")
+            utils.SendErrorCode(w, apperrors.CodeAccessDenied, "Access denied or patient not found")
             return
         }
-        utils.SendError(w, http.StatusInternalServerError, "Failed to retrieve patient profile")
+        utils.SendAppError(w, err, "Failed to retrieve patient profile")
         return
     }
 
-    utils.SendSuccess(w, http.StatusOK, "Patient profile retrieved", patientProfile)
+    notify.Send(r.Context(), h.notifier, notify.RecordViewed(chart.UserID, doctorProfile.ID, doctorProfile.FullName))
+
+    utils.SendSuccess(w, http.StatusOK, "Patient profile retrieved", chart)
 }
 
 // ListAccessiblePatients lists all patients the doctor can access
@@ -205,18 +314,24 @@ func (h *DoctorHandler) ListAccessiblePatients(w http.ResponseWriter, r *http.Re
     }
 
     // Get doctor profile
-    doctorProfile, err := h.repo.GetProfileByUserID(userID)
+    doctorProfile, err := h.repo.GetProfileByUserID(r.Context(), userID)
     if err != nil {
-        utils.SendError(w, http.StatusNotFound, "Doctor profile not found")
+        utils.SendErrorCode(w, apperrors.CodeProfileNotFound, "Doctor profile not found")
         return
     }
 
     // Get accessible patients
-    patients, err := h.repo.ListAccessiblePatients(doctorProfile.ID)
+    page, err := pagination.Parse(r.URL.Query(), repository.PatientPages)
+    if err != nil {
+        utils.SendAppError(w, err, "Invalid pagination parameters")
+        return
+    }
+
+    patients, next, err := h.repo.ListAccessiblePatients(r.Context(), doctorProfile.ID, page)
     if err != nil {
-        utils.SendError(w, http.StatusInternalServerError, "Failed to retrieve patients")
+        utils.SendAppError(w, err, "Failed to retrieve patients")
         return
     }
 
-    utils.SendSuccess(w, http.StatusOK, "Patients retrieved", patients)
+    utils.SendPage(w, http.StatusOK, "Patients retrieved", patients, next)
 }
@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"health-bar/services/doctor/repository"
+	"health-bar/shared/memdb"
+	"health-bar/shared/models"
+	"health-bar/shared/notify"
+	"health-bar/shared/testutil"
+	"net/http"
+	"testing"
+)
+
+func newTestHandler() (*DoctorHandler, *memdb.DB) {
+	db := memdb.New()
+	return NewDoctorHandler(repository.NewMemoryRepository(db)), db
+}
+
+func TestCreateProfileRoleChecks(t *testing.T) {
+	h, db := newTestHandler()
+	doctor := db.AddUser("d@test.com", models.RoleDoctor)
+	patient := db.AddUser("p@test.com", models.RolePatient)
+
+	body := CreateProfileRequest{FullName: "Dr Who", Specialization: "Cardiology"}
+
+	tests := []struct {
+		name   string
+		userID string
+		role   string
+		body   interface{}
+		want   int
+	}{
+		{"missing identity", "", "", body, http.StatusUnauthorized},
+		{"patient cannot create", patient.ID, "patient", body, http.StatusForbidden},
+		{"missing name", doctor.ID, "doctor", CreateProfileRequest{}, http.StatusBadRequest},
+		{"created", doctor.ID, "doctor", body, http.StatusCreated},
+		{"duplicate", doctor.ID, "doctor", body, http.StatusConflict},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := testutil.NewRequest(t, http.MethodPost, "/api/doctors/profile", tt.body, tt.userID, tt.role)
+			rec, _ := testutil.Serve(t, h.CreateProfile, req)
+			testutil.ExpectStatus(t, rec, tt.want)
+		})
+	}
+}
+
+func TestGetPatientProfileRequiresActiveGrant(t *testing.T) {
+	h, db := newTestHandler()
+	doctor := db.AddDoctor("d@test.com", "Dr Who")
+	patient := db.AddPatient("p@test.com", "Pat")
+
+	view := func(userID, role, patientID string) int {
+		req := testutil.NewRequest(t, http.MethodGet, "/api/doctors/patients/view?patient_id="+patientID, nil, userID, role)
+		rec, _ := testutil.Serve(t, h.GetPatientProfile, req)
+		return rec.Code
+	}
+
+	if code := view(patient.UserID, "patient", patient.ID); code != http.StatusForbidden {
+		t.Fatalf("patient role: status = %d, want 403", code)
+	}
+	if code := view(doctor.UserID, "doctor", ""); code != http.StatusBadRequest {
+		t.Fatalf("missing patient_id: status = %d, want 400", code)
+	}
+	if code := view(doctor.UserID, "doctor", patient.ID); code != http.StatusForbidden {
+		t.Fatalf("no grant: status = %d, want 403", code)
+	}
+
+	db.Grant(patient.ID, doctor.ID)
+	if code := view(doctor.UserID, "doctor", patient.ID); code != http.StatusOK {
+		t.Fatalf("active grant: status = %d, want 200", code)
+	}
+
+	db.Lock()
+	p, _ := db.Permission(patient.ID, doctor.ID)
+	p.IsActive = false
+	db.AccessPermissions.Rows[p.ID] = p
+	db.Unlock()
+
+	if code := view(doctor.UserID, "doctor", patient.ID); code != http.StatusForbidden {
+		t.Fatalf("revoked grant: status = %d, want 403", code)
+	}
+}
+
+func TestGetPatientProfileWithoutDoctorProfile(t *testing.T) {
+	h, db := newTestHandler()
+	user := db.AddUser("d@test.com", models.RoleDoctor)
+
+	req := testutil.NewRequest(t, http.MethodGet, "/api/doctors/patients/view?patient_id=x", nil, user.ID, "doctor")
+	rec, _ := testutil.Serve(t, h.GetPatientProfile, req)
+	testutil.ExpectStatus(t, rec, http.StatusNotFound)
+}
+
+func TestListAccessiblePatients(t *testing.T) {
+	h, db := newTestHandler()
+	doctor := db.AddDoctor("d@test.com", "Dr Who")
+	zed := db.AddPatient("z@test.com", "Zed")
+	amy := db.AddPatient("a@test.com", "Amy")
+	db.AddPatient("other@test.com", "Not Shared")
+
+	db.Grant(zed.ID, doctor.ID)
+	db.Grant(amy.ID, doctor.ID)
+
+	req := testutil.NewRequest(t, http.MethodGet, "/api/doctors/patients", nil, doctor.UserID, "doctor")
+	rec, resp := testutil.Serve(t, h.ListAccessiblePatients, req)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+
+	var patients []models.PatientProfile
+	testutil.DecodeData(t, resp, &patients)
+	if len(patients) != 2 || patients[0].FullName != "Amy" || patients[1].FullName != "Zed" {
+		t.Fatalf("patients = %+v, want Amy and Zed in name order", patients)
+	}
+}
+
+func TestGetPatientProfileIncludesClinicalRecord(t *testing.T) {
+	h, db := newTestHandler()
+	doctor := db.AddDoctor("d@test.com", "Dr Who")
+	patient := db.AddPatient("p@test.com", "Pat")
+	other := db.AddPatient("o@test.com", "Other")
+	db.Grant(patient.ID, doctor.ID)
+
+	height := 172.5
+	db.Allergies.Rows["a1"] = models.Allergy{ID: "a1", PatientID: patient.ID, Substance: "Penicillin", Severity: models.SeveritySevere}
+	db.Allergies.Rows["a2"] = models.Allergy{ID: "a2", PatientID: other.ID, Substance: "Latex", Severity: models.SeverityMild}
+	db.EmergencyContacts.Rows["c1"] = models.EmergencyContact{ID: "c1", PatientID: patient.ID, Name: "Sam", Phone: "555", IsPrimary: true}
+	db.Biometrics.Rows[patient.ID] = models.Biometrics{PatientID: patient.ID, BloodType: "O-", HeightCM: &height}
+
+	req := testutil.NewRequest(t, http.MethodGet, "/api/doctors/patients/view?patient_id="+patient.ID, nil, doctor.UserID, "doctor")
+	rec, resp := testutil.Serve(t, h.GetPatientProfile, req)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+
+	var chart models.PatientChart
+	testutil.DecodeData(t, resp, &chart)
+	if chart.FullName != "Pat" || chart.ID != patient.ID {
+		t.Fatalf("chart profile = %+v", chart.PatientProfile)
+	}
+	if len(chart.Allergies) != 1 || chart.Allergies[0].Substance != "Penicillin" {
+		t.Fatalf("chart allergies = %+v", chart.Allergies)
+	}
+	if len(chart.EmergencyContacts) != 1 || chart.Conditions == nil || len(chart.Medications) != 0 {
+		t.Fatalf("chart record = %+v", chart.ClinicalRecord)
+	}
+	if chart.Biometrics == nil || chart.Biometrics.BloodType != "O-" || *chart.Biometrics.HeightCM != height {
+		t.Fatalf("chart biometrics = %+v", chart.Biometrics)
+	}
+}
+
+func TestGetPatientProfileNotifiesPatient(t *testing.T) {
+	h, db := newTestHandler()
+	recorder := &notify.Recorder{}
+	h.UseNotifier(recorder)
+	doctor := db.AddDoctor("d@test.com", "Dr Who")
+	patient := db.AddPatient("p@test.com", "Pat")
+
+	view := func() {
+		req := testutil.NewRequest(t, http.MethodGet, "/api/doctors/patients/view?patient_id="+patient.ID, nil, doctor.UserID, "doctor")
+		testutil.Serve(t, h.GetPatientProfile, req)
+	}
+
+	view()
+	if msgs := recorder.Messages(); len(msgs) != 0 {
+		t.Fatalf("denied view sent %+v", msgs)
+	}
+
+	db.Grant(patient.ID, doctor.ID)
+	view()
+	msgs := recorder.Messages()
+	if len(msgs) != 1 {
+		t.Fatalf("sent %d messages, want 1", len(msgs))
+	}
+	if msgs[0].Kind != notify.KindRecordViewed || msgs[0].UserID != patient.UserID || msgs[0].Data["doctor_id"] != doctor.ID {
+		t.Fatalf("message = %+v", msgs[0])
+	}
+}
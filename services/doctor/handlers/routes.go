@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"health-bar/shared/apperrors"
+	"health-bar/shared/idempotency"
+	"health-bar/shared/middleware"
+
+	"github.com/gorilla/mux"
+)
+
+// RegisterRoutes mounts the doctor service endpoints on router. Bookings
+// honour Idempotency-Key headers, recorded in keys.
+func RegisterRoutes(router *mux.Router, h *DoctorHandler, keys idempotency.Store) {
+	idempotent := idempotency.New(keys)
+
+	router.NotFoundHandler = apperrors.NotFoundHandler()
+	router.MethodNotAllowedHandler = apperrors.MethodNotAllowedHandler()
+
+	// Doctor profile routes (protected)
+	router.HandleFunc("/api/doctors/profile", middleware.AuthMiddleware(h.CreateProfile)).Methods("POST")
+	router.HandleFunc("/api/doctors/profile", middleware.AuthMiddleware(h.GetMyProfile)).Methods("GET")
+	router.HandleFunc("/api/doctors/profile", middleware.AuthMiddleware(h.UpdateProfile)).Methods("PUT")
+	router.HandleFunc("/api/doctors/profile", middleware.AuthMiddleware(h.PatchProfile)).Methods("PATCH")
+
+	// Patient viewing routes (protected)
+	router.HandleFunc("/api/doctors/patients", middleware.AuthMiddleware(h.ListAccessiblePatients)).Methods("GET")
+	router.HandleFunc("/api/doctors/patients/view", middleware.AuthMiddleware(h.GetPatientProfile)).Methods("GET")
+
+	// Availability calendars (protected)
+	router.HandleFunc("/api/doctors/availability", middleware.AuthMiddleware(h.SetCalendar)).Methods("PUT")
+	router.HandleFunc("/api/doctors/availability", middleware.AuthMiddleware(h.GetCalendar)).Methods("GET")
+	router.HandleFunc("/api/doctors/availability/exceptions", middleware.AuthMiddleware(h.CreateException)).Methods("POST")
+	router.HandleFunc("/api/doctors/availability/exception", middleware.AuthMiddleware(h.DeleteException)).Methods("DELETE")
+	router.HandleFunc("/api/doctors/slots", middleware.AuthMiddleware(h.GetSlots)).Methods("GET")
+
+	// Appointments (protected)
+	router.HandleFunc("/api/doctors/appointments", middleware.AuthMiddleware(idempotent.Wrap(h.BookAppointment))).Methods("POST")
+	router.HandleFunc("/api/doctors/appointments", middleware.AuthMiddleware(h.GetAppointments)).Methods("GET")
+	router.HandleFunc("/api/doctors/appointment", middleware.AuthMiddleware(h.GetAppointment)).Methods("GET")
+	router.HandleFunc("/api/doctors/appointments/reschedule", middleware.AuthMiddleware(h.RescheduleAppointment)).Methods("POST")
+	router.HandleFunc("/api/doctors/appointments/cancel", middleware.AuthMiddleware(h.CancelAppointment)).Methods("POST")
+	router.HandleFunc("/api/doctors/appointments/complete", middleware.AuthMiddleware(h.CompleteAppointment)).Methods("POST")
+}
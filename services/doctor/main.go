@@ -1,13 +1,17 @@
 package main
 
 import (
+    "context"
     "health-bar/shared/database"
-    "health-bar/shared/middleware"
+    "health-bar/shared/idempotency"
+    "health-bar/shared/notify"
+    "health-bar/shared/webhooks"
     "health-bar/services/doctor/handlers"
     "health-bar/services/doctor/repository"
     "log"
     "net/http"
     "os"
+    "time"
     "github.com/gorilla/mux"
     "github.com/joho/godotenv"
     "github.com/rs/cors"
@@ -31,22 +35,20 @@ func main() {
 
     repo := repository.NewDoctorRepository(db)
     handler := handlers.NewDoctorHandler(repo)
+    handler.UseNotifier(notify.NewPostgresNotifier(db))
+    handler.UseWebhooks(webhooks.NewPostgresEmitter(db))
 
-    router := mux.NewRouter()
-
-    // Doctor profile routes (protected)
-    router.HandleFunc("/api/doctors/profile", middleware.AuthMiddleware(handler.CreateProfile)).Methods("POST")
-    router.HandleFunc("/api/doctors/profile", middleware.AuthMiddleware(handler.GetMyProfile)).Methods("GET")
-    router.HandleFunc("/api/doctors/profile", middleware.AuthMiddleware(handler.UpdateProfile)).Methods("PUT")
+    keys := idempotency.NewPostgresStore(db)
+    go keys.PurgeEvery(context.Background(), time.Hour)
 
-    // Patient viewing routes (protected)
-    router.HandleFunc("/api/doctors/patients", middleware.AuthMiddleware(handler.ListAccessiblePatients)).Methods("GET")
-    router.HandleFunc("/api/doctors/patients/view", middleware.AuthMiddleware(handler.GetPatientProfile)).Methods("GET")
+    router := mux.NewRouter()
+    handlers.RegisterRoutes(router, handler, keys)
 
     c := cors.New(cors.Options{
         AllowedOrigins:   []string{"http://localhost:3000"},
-        AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-        AllowedHeaders:   []string{"Content-Type", "Authorization"},
+        AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+        AllowedHeaders:   []string{"Content-Type", "Authorization", "If-Match", "Idempotency-Key"},
+        ExposedHeaders:   []string{"ETag", "Idempotent-Replayed"},
         AllowCredentials: true,
     })
 
@@ -0,0 +1,283 @@
+package repository
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"slices"
+	"time"
+
+	"health-bar/shared/memdb"
+	"health-bar/shared/models"
+	"health-bar/shared/pagination"
+
+	"github.com/google/uuid"
+)
+
+func (r *MemoryRepository) GetPatientProfileIDByUserID(ctx context.Context, userID string) (string, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	for _, p := range r.db.PatientProfiles.Rows {
+		if p.UserID == userID {
+			return p.ID, nil
+		}
+	}
+	return "", sql.ErrNoRows
+}
+
+func (r *MemoryRepository) GetPatientUserID(ctx context.Context, patientID string) (string, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	p, ok := r.db.PatientProfiles.Rows[patientID]
+	if !ok {
+		return "", sql.ErrNoRows
+	}
+	return p.UserID, nil
+}
+
+func (r *MemoryRepository) GetDoctorUserIDsWithAccess(ctx context.Context, patientID string) ([]string, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	return r.db.DoctorUserIDsWithAccess(patientID), nil
+}
+
+func (r *MemoryRepository) GetCalendar(ctx context.Context, doctorID string) (*models.DoctorCalendar, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	calendar, ok := r.db.DoctorCalendars.Rows[doctorID]
+	if !ok {
+		return &models.DoctorCalendar{}, sql.ErrNoRows
+	}
+	return &calendar, nil
+}
+
+func (r *MemoryRepository) SaveCalendar(ctx context.Context, calendar *models.DoctorCalendar) error {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	if _, ok := r.db.DoctorProfiles.Rows[calendar.DoctorID]; !ok {
+		return memdb.ForeignKeyViolation("doctor_calendars", "doctor_calendars_doctor_id_fkey")
+	}
+
+	now := r.db.Now()
+	calendar.CreatedAt, calendar.UpdatedAt = now, now
+	if existing, ok := r.db.DoctorCalendars.Rows[calendar.DoctorID]; ok {
+		calendar.CreatedAt = existing.CreatedAt
+	}
+	r.db.DoctorCalendars.Rows[calendar.DoctorID] = *calendar
+	return nil
+}
+
+func (r *MemoryRepository) CreateAvailabilityException(ctx context.Context, exception *models.AvailabilityException) error {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	if _, ok := r.db.DoctorProfiles.Rows[exception.DoctorID]; !ok {
+		return memdb.ForeignKeyViolation("availability_exceptions", "availability_exceptions_doctor_id_fkey")
+	}
+
+	exception.ID = uuid.New().String()
+	exception.CreatedAt = r.db.Now()
+	r.db.AvailabilityExceptions.Rows[exception.ID] = *exception
+	return nil
+}
+
+func (r *MemoryRepository) GetAvailabilityExceptionByID(ctx context.Context, exceptionID string) (*models.AvailabilityException, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	exception, ok := r.db.AvailabilityExceptions.Rows[exceptionID]
+	if !ok {
+		return &models.AvailabilityException{}, sql.ErrNoRows
+	}
+	return &exception, nil
+}
+
+func (r *MemoryRepository) ListAvailabilityExceptions(ctx context.Context, doctorID string, from, to time.Time) ([]models.AvailabilityException, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	var exceptions []models.AvailabilityException
+	for _, e := range r.db.AvailabilityExceptions.Rows {
+		if e.DoctorID == doctorID && !e.Date.Before(from) && !e.Date.After(to) {
+			exceptions = append(exceptions, e)
+		}
+	}
+	slices.SortFunc(exceptions, func(a, b models.AvailabilityException) int {
+		return cmp.Or(a.Date.Compare(b.Date), a.CreatedAt.Compare(b.CreatedAt), cmp.Compare(a.ID, b.ID))
+	})
+	return exceptions, nil
+}
+
+func (r *MemoryRepository) DeleteAvailabilityException(ctx context.Context, exceptionID string) error {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	delete(r.db.AvailabilityExceptions.Rows, exceptionID)
+	return nil
+}
+
+// checkOverlap mirrors the appointments exclusion constraints. The caller
+// must hold the lock.
+func (r *MemoryRepository) checkOverlap(a models.Appointment) error {
+	for _, other := range r.db.Appointments.Rows {
+		if other.ID == a.ID || other.Status == models.AppointmentCancelled ||
+			!other.StartsAt.Before(a.EndsAt) || !a.StartsAt.Before(other.EndsAt) {
+			continue
+		}
+		if other.DoctorID == a.DoctorID {
+			return memdb.ExclusionViolation("appointments", "appointments_doctor_no_overlap")
+		}
+		if other.PatientID == a.PatientID {
+			return memdb.ExclusionViolation("appointments", "appointments_patient_no_overlap")
+		}
+	}
+	return nil
+}
+
+func (r *MemoryRepository) CreateAppointment(ctx context.Context, appointment *models.Appointment) error {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	if _, ok := r.db.DoctorProfiles.Rows[appointment.DoctorID]; !ok {
+		return memdb.ForeignKeyViolation("appointments", "appointments_doctor_id_fkey")
+	}
+	if _, ok := r.db.PatientProfiles.Rows[appointment.PatientID]; !ok {
+		return memdb.ForeignKeyViolation("appointments", "appointments_patient_id_fkey")
+	}
+
+	appointment.ID = uuid.New().String()
+	appointment.Status = models.AppointmentBooked
+	if err := r.checkOverlap(*appointment); err != nil {
+		return err
+	}
+
+	now := r.db.Now()
+	appointment.CreatedAt, appointment.UpdatedAt = now, now
+	r.db.Appointments.Rows[appointment.ID] = *appointment
+	return nil
+}
+
+func (r *MemoryRepository) GetAppointmentByID(ctx context.Context, appointmentID string) (*models.Appointment, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	appointment, ok := r.db.Appointments.Rows[appointmentID]
+	if !ok {
+		return &models.Appointment{}, sql.ErrNoRows
+	}
+	return &appointment, nil
+}
+
+func (r *MemoryRepository) GetAppointmentsByDoctorID(ctx context.Context, doctorID string, page AppointmentPage) ([]models.Appointment, string, error) {
+	patientID, byPatient := page.Filters["patient_id"]
+	return r.listAppointments(page, func(a models.Appointment) bool {
+		return a.DoctorID == doctorID && (!byPatient || a.PatientID == patientID)
+	})
+}
+
+func (r *MemoryRepository) GetAppointmentsByPatientID(ctx context.Context, patientID string, page AppointmentPage) ([]models.Appointment, string, error) {
+	doctorID, byDoctor := page.Filters["doctor_id"]
+	return r.listAppointments(page, func(a models.Appointment) bool {
+		return a.PatientID == patientID && (!byDoctor || a.DoctorID == doctorID)
+	})
+}
+
+func (r *MemoryRepository) listAppointments(page AppointmentPage, match func(models.Appointment) bool) ([]models.Appointment, string, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	status, byStatus := page.Filters["status"]
+	var appointments []models.Appointment
+	for _, a := range r.db.Appointments.Rows {
+		if !match(a) || !page.InRange(a.StartsAt) || (byStatus && string(a.Status) != status) {
+			continue
+		}
+		appointments = append(appointments, a)
+	}
+	appointments, next := pagination.Apply(appointments, page)
+	return appointments, next, nil
+}
+
+func (r *MemoryRepository) GetBookedAppointments(ctx context.Context, doctorID string, from, to time.Time) ([]models.Appointment, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	var appointments []models.Appointment
+	for _, a := range r.db.Appointments.Rows {
+		if a.DoctorID == doctorID && a.Status != models.AppointmentCancelled && a.StartsAt.Before(to) && a.EndsAt.After(from) {
+			appointments = append(appointments, a)
+		}
+	}
+	slices.SortFunc(appointments, func(a, b models.Appointment) int {
+		return cmp.Or(a.StartsAt.Compare(b.StartsAt), cmp.Compare(a.ID, b.ID))
+	})
+	return appointments, nil
+}
+
+// updateBooked applies change to an appointment that is still booked. The
+// caller must hold the lock.
+func (r *MemoryRepository) updateBooked(appointmentID string, change func(*models.Appointment) error) (*models.Appointment, error) {
+	appointment, ok := r.db.Appointments.Rows[appointmentID]
+	if !ok || appointment.Status != models.AppointmentBooked {
+		return &models.Appointment{}, sql.ErrNoRows
+	}
+	if err := change(&appointment); err != nil {
+		return &models.Appointment{}, err
+	}
+	appointment.UpdatedAt = r.db.Now()
+	r.db.Appointments.Rows[appointmentID] = appointment
+	return &appointment, nil
+}
+
+func (r *MemoryRepository) RescheduleAppointment(ctx context.Context, appointmentID string, startsAt, endsAt time.Time) (*models.Appointment, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	return r.updateBooked(appointmentID, func(a *models.Appointment) error {
+		a.StartsAt, a.EndsAt = startsAt, endsAt
+		return r.checkOverlap(*a)
+	})
+}
+
+func (r *MemoryRepository) CancelAppointment(ctx context.Context, appointmentID, cancelledBy, reason string) (*models.Appointment, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	now := r.db.Now()
+	return r.updateBooked(appointmentID, func(a *models.Appointment) error {
+		a.Status, a.CancelledBy, a.CancelReason, a.CancelledAt = models.AppointmentCancelled, cancelledBy, reason, &now
+		return nil
+	})
+}
+
+func (r *MemoryRepository) CompleteAppointment(ctx context.Context, appointmentID, notes string, visitID *string) (*models.Appointment, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	now := r.db.Now()
+	return r.updateBooked(appointmentID, func(a *models.Appointment) error {
+		a.Status, a.Notes, a.VisitID, a.CompletedAt = models.AppointmentCompleted, notes, visitID, &now
+		return nil
+	})
+}
+
+func (r *MemoryRepository) CreateVisit(ctx context.Context, visit *models.HospitalVisit) error {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	if _, ok := r.db.PatientProfiles.Rows[visit.PatientID]; !ok {
+		return memdb.ForeignKeyViolation("hospital_visits", "hospital_visits_patient_id_fkey")
+	}
+
+	now := r.db.Now()
+	visit.ID = uuid.New().String()
+	visit.CreatedAt, visit.UpdatedAt = now, now
+	visit.Version = 1
+	r.db.HospitalVisits.Rows[visit.ID] = *visit
+	return nil
+}
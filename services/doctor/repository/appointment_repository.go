@@ -0,0 +1,301 @@
+package repository
+
+import (
+    "context"
+    "health-bar/shared/database"
+    "health-bar/shared/models"
+    "health-bar/shared/pagination"
+    "time"
+    "github.com/google/uuid"
+)
+
+const calendarColumns = `doctor_id, timezone, slot_minutes, location, create_visits, weekly, created_at, updated_at`
+
+const exceptionColumns = `id, doctor_id, date, start_time, end_time, available, reason, created_at`
+
+const appointmentColumns = `id, doctor_id, patient_id, starts_at, ends_at, status, reason, notes, visit_id,
+        cancelled_by, cancel_reason, cancelled_at, completed_at, created_at, updated_at`
+
+// GetPatientProfileIDByUserID gets the patient profile ID of a user
+func (r *DoctorRepository) GetPatientProfileIDByUserID(ctx context.Context, userID string) (string, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    var profileID string
+    query := `SELECT id FROM patient_profiles WHERE user_id = $1`
+    err := database.Conn(ctx, r.db).GetContext(ctx, &profileID, query, userID)
+    return profileID, err
+}
+
+// GetPatientUserID gets the user account of a patient profile
+func (r *DoctorRepository) GetPatientUserID(ctx context.Context, patientID string) (string, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    var userID string
+    query := `SELECT user_id FROM patient_profiles WHERE id = $1`
+    err := database.Conn(ctx, r.db).GetContext(ctx, &userID, query, patientID)
+    return userID, err
+}
+
+// GetDoctorUserIDsWithAccess lists the user IDs of the doctors the patient
+// has granted active access
+func (r *DoctorRepository) GetDoctorUserIDsWithAccess(ctx context.Context, patientID string) ([]string, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    userIDs := []string{}
+    query := `
+        SELECT d.user_id
+        FROM doctor_access_permissions dap
+        INNER JOIN doctor_profiles d ON d.id = dap.doctor_id
+        WHERE dap.patient_id = $1 AND dap.is_active = true
+        ORDER BY d.user_id
+    `
+    err := database.Conn(ctx, r.db).SelectContext(ctx, &userIDs, query, patientID)
+    return userIDs, err
+}
+
+// GetCalendar gets a doctor's availability calendar
+func (r *DoctorRepository) GetCalendar(ctx context.Context, doctorID string) (*models.DoctorCalendar, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    calendar := &models.DoctorCalendar{}
+    query := `SELECT ` + calendarColumns + ` FROM doctor_calendars WHERE doctor_id = $1`
+    err := database.Conn(ctx, r.db).GetContext(ctx, calendar, query, doctorID)
+    return calendar, err
+}
+
+// SaveCalendar creates or replaces a doctor's availability calendar
+func (r *DoctorRepository) SaveCalendar(ctx context.Context, calendar *models.DoctorCalendar) error {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    query := `
+        INSERT INTO doctor_calendars (doctor_id, timezone, slot_minutes, location, create_visits, weekly)
+        VALUES ($1, $2, $3, $4, $5, $6)
+        ON CONFLICT (doctor_id) DO UPDATE
+        SET timezone = EXCLUDED.timezone, slot_minutes = EXCLUDED.slot_minutes, location = EXCLUDED.location,
+            create_visits = EXCLUDED.create_visits, weekly = EXCLUDED.weekly, updated_at = NOW()
+        RETURNING ` + calendarColumns
+
+    return database.Conn(ctx, r.db).QueryRowxContext(ctx, query,
+        calendar.DoctorID, calendar.Timezone, calendar.SlotMinutes, calendar.Location,
+        calendar.CreateVisits, calendar.Weekly,
+    ).StructScan(calendar)
+}
+
+// CreateAvailabilityException adds an exception to a doctor's calendar
+func (r *DoctorRepository) CreateAvailabilityException(ctx context.Context, exception *models.AvailabilityException) error {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    exception.ID = uuid.New().String()
+
+    query := `
+        INSERT INTO availability_exceptions (id, doctor_id, date, start_time, end_time, available, reason)
+        VALUES ($1, $2, $3::date, $4, $5, $6, $7)
+        RETURNING ` + exceptionColumns
+
+    return database.Conn(ctx, r.db).QueryRowxContext(ctx, query,
+        exception.ID, exception.DoctorID, pagination.Date(exception.Date), exception.Start, exception.End,
+        exception.Available, exception.Reason,
+    ).StructScan(exception)
+}
+
+// GetAvailabilityExceptionByID gets an availability exception by ID
+func (r *DoctorRepository) GetAvailabilityExceptionByID(ctx context.Context, exceptionID string) (*models.AvailabilityException, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    exception := &models.AvailabilityException{}
+    query := `SELECT ` + exceptionColumns + ` FROM availability_exceptions WHERE id = $1`
+    err := database.Conn(ctx, r.db).GetContext(ctx, exception, query, exceptionID)
+    return exception, err
+}
+
+// ListAvailabilityExceptions gets a doctor's exceptions on the dates from
+// through to, in the order they were added
+func (r *DoctorRepository) ListAvailabilityExceptions(ctx context.Context, doctorID string, from, to time.Time) ([]models.AvailabilityException, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    var exceptions []models.AvailabilityException
+    query := `
+        SELECT ` + exceptionColumns + `
+        FROM availability_exceptions
+        WHERE doctor_id = $1 AND date >= $2::date AND date <= $3::date
+        ORDER BY date, created_at, id
+    `
+    err := database.Conn(ctx, r.db).SelectContext(ctx, &exceptions, query, doctorID, pagination.Date(from), pagination.Date(to))
+    return exceptions, err
+}
+
+// DeleteAvailabilityException deletes an availability exception
+func (r *DoctorRepository) DeleteAvailabilityException(ctx context.Context, exceptionID string) error {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    query := `DELETE FROM availability_exceptions WHERE id = $1`
+    _, err := database.Conn(ctx, r.db).ExecContext(ctx, query, exceptionID)
+    return err
+}
+
+// CreateAppointment books an appointment. Overlapping bookings of the
+// doctor or the patient fail with an exclusion violation.
+func (r *DoctorRepository) CreateAppointment(ctx context.Context, appointment *models.Appointment) error {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    appointment.ID = uuid.New().String()
+
+    query := `
+        INSERT INTO appointments (id, doctor_id, patient_id, starts_at, ends_at, reason)
+        VALUES ($1, $2, $3, $4::timestamp, $5::timestamp, $6)
+        RETURNING ` + appointmentColumns
+
+    return database.Conn(ctx, r.db).QueryRowxContext(ctx, query,
+        appointment.ID, appointment.DoctorID, appointment.PatientID,
+        pagination.Timestamp(appointment.StartsAt), pagination.Timestamp(appointment.EndsAt), appointment.Reason,
+    ).StructScan(appointment)
+}
+
+// GetAppointmentByID gets an appointment by ID
+func (r *DoctorRepository) GetAppointmentByID(ctx context.Context, appointmentID string) (*models.Appointment, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    appointment := &models.Appointment{}
+    query := `SELECT ` + appointmentColumns + ` FROM appointments WHERE id = $1`
+    err := database.Conn(ctx, r.db).GetContext(ctx, appointment, query, appointmentID)
+    return appointment, err
+}
+
+// GetAppointmentsByDoctorID gets a page of a doctor's appointments and the cursor of the next page
+func (r *DoctorRepository) GetAppointmentsByDoctorID(ctx context.Context, doctorID string, page AppointmentPage) ([]models.Appointment, string, error) {
+    q := &pagination.Query{}
+    q.Where("doctor_id = " + q.Arg(doctorID))
+    if patientID, ok := page.Filters["patient_id"]; ok {
+        q.Where("patient_id::text = " + q.Arg(patientID))
+    }
+    return r.listAppointments(ctx, q, page)
+}
+
+// GetAppointmentsByPatientID gets a page of a patient's appointments and the cursor of the next page
+func (r *DoctorRepository) GetAppointmentsByPatientID(ctx context.Context, patientID string, page AppointmentPage) ([]models.Appointment, string, error) {
+    q := &pagination.Query{}
+    q.Where("patient_id = " + q.Arg(patientID))
+    if doctorID, ok := page.Filters["doctor_id"]; ok {
+        q.Where("doctor_id::text = " + q.Arg(doctorID))
+    }
+    return r.listAppointments(ctx, q, page)
+}
+
+func (r *DoctorRepository) listAppointments(ctx context.Context, q *pagination.Query, page AppointmentPage) ([]models.Appointment, string, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    if !page.From.IsZero() {
+        q.Where("starts_at >= " + q.Arg(pagination.Timestamp(page.From)) + "::timestamp")
+    }
+    if !page.To.IsZero() {
+        q.Where("starts_at <= " + q.Arg(pagination.Timestamp(page.ToEnd())) + "::timestamp")
+    }
+    if status, ok := page.Filters["status"]; ok {
+        q.Where("status = " + q.Arg(status))
+    }
+
+    var appointments []models.Appointment
+    query := `SELECT ` + appointmentColumns + ` FROM appointments` + page.Clauses(q)
+    if err := database.Conn(ctx, r.db).SelectContext(ctx, &appointments, query, q.Args()...); err != nil {
+        return nil, "", err
+    }
+    appointments, next := pagination.Page(appointments, page)
+    return appointments, next, nil
+}
+
+// GetBookedAppointments gets a doctor's appointments that are not cancelled
+// and overlap [from, to)
+func (r *DoctorRepository) GetBookedAppointments(ctx context.Context, doctorID string, from, to time.Time) ([]models.Appointment, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    var appointments []models.Appointment
+    query := `
+        SELECT ` + appointmentColumns + `
+        FROM appointments
+        WHERE doctor_id = $1 AND status <> 'cancelled' AND starts_at < $3::timestamp AND ends_at > $2::timestamp
+        ORDER BY starts_at, id
+    `
+    err := database.Conn(ctx, r.db).SelectContext(ctx, &appointments, query, doctorID, pagination.Timestamp(from), pagination.Timestamp(to))
+    return appointments, err
+}
+
+// RescheduleAppointment moves a booked appointment. It returns sql.ErrNoRows
+// when the appointment is no longer booked.
+func (r *DoctorRepository) RescheduleAppointment(ctx context.Context, appointmentID string, startsAt, endsAt time.Time) (*models.Appointment, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    appointment := &models.Appointment{}
+    query := `
+        UPDATE appointments
+        SET starts_at = $2::timestamp, ends_at = $3::timestamp, updated_at = NOW()
+        WHERE id = $1 AND status = 'booked'
+        RETURNING ` + appointmentColumns
+    err := database.Conn(ctx, r.db).GetContext(ctx, appointment, query, appointmentID, pagination.Timestamp(startsAt), pagination.Timestamp(endsAt))
+    return appointment, err
+}
+
+// CancelAppointment cancels a booked appointment, freeing its slot. It
+// returns sql.ErrNoRows when the appointment is no longer booked.
+func (r *DoctorRepository) CancelAppointment(ctx context.Context, appointmentID, cancelledBy, reason string) (*models.Appointment, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    appointment := &models.Appointment{}
+    query := `
+        UPDATE appointments
+        SET status = 'cancelled', cancelled_by = $2, cancel_reason = $3, cancelled_at = NOW(), updated_at = NOW()
+        WHERE id = $1 AND status = 'booked'
+        RETURNING ` + appointmentColumns
+    err := database.Conn(ctx, r.db).GetContext(ctx, appointment, query, appointmentID, cancelledBy, reason)
+    return appointment, err
+}
+
+// CompleteAppointment marks a booked appointment completed, linking the
+// visit created for it if any. It returns sql.ErrNoRows when the
+// appointment is no longer booked.
+func (r *DoctorRepository) CompleteAppointment(ctx context.Context, appointmentID, notes string, visitID *string) (*models.Appointment, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    appointment := &models.Appointment{}
+    query := `
+        UPDATE appointments
+        SET status = 'completed', notes = $2, visit_id = $3, completed_at = NOW(), updated_at = NOW()
+        WHERE id = $1 AND status = 'booked'
+        RETURNING ` + appointmentColumns
+    err := database.Conn(ctx, r.db).GetContext(ctx, appointment, query, appointmentID, notes, visitID)
+    return appointment, err
+}
+
+// CreateVisit adds a hospital visit to a patient's timeline
+func (r *DoctorRepository) CreateVisit(ctx context.Context, visit *models.HospitalVisit) error {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    visit.ID = uuid.New().String()
+
+    query := `
+        INSERT INTO hospital_visits (id, patient_id, hospital_name, visit_date, reason, notes)
+        VALUES ($1, $2, $3, $4::date, $5, $6)
+        RETURNING id, patient_id, hospital_name, visit_date, reason, notes, created_at, updated_at, version
+    `
+
+    return database.Conn(ctx, r.db).QueryRowxContext(ctx, query,
+        visit.ID, visit.PatientID, visit.HospitalName, pagination.Date(visit.VisitDate),
+        visit.Reason, visit.Notes,
+    ).StructScan(visit)
+}
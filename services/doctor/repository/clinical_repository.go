@@ -0,0 +1,65 @@
+package repository
+
+import (
+    "context"
+    "database/sql"
+    "health-bar/shared/database"
+    "health-bar/shared/models"
+)
+
+// GetClinicalRecord reads a patient's clinical record. It does not check
+// access; callers check it first with GetPatientProfile or CheckAccess.
+func (r *DoctorRepository) GetClinicalRecord(ctx context.Context, patientID string) (*models.ClinicalRecord, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    conn := database.Conn(ctx, r.db)
+    record := &models.ClinicalRecord{
+        Allergies:         []models.Allergy{},
+        Conditions:        []models.Condition{},
+        Medications:       []models.Medication{},
+        EmergencyContacts: []models.EmergencyContact{},
+    }
+
+    if err := conn.SelectContext(ctx, &record.Allergies, `
+        SELECT id, patient_id, substance, reaction, severity, created_at, updated_at
+        FROM patient_allergies WHERE patient_id = $1 ORDER BY lower(substance)
+    `, patientID); err != nil {
+        return nil, err
+    }
+
+    if err := conn.SelectContext(ctx, &record.Conditions, `
+        SELECT id, patient_id, name, status, diagnosed_on, notes, created_at, updated_at
+        FROM patient_conditions WHERE patient_id = $1 ORDER BY created_at, id
+    `, patientID); err != nil {
+        return nil, err
+    }
+
+    if err := conn.SelectContext(ctx, &record.Medications, `
+        SELECT id, patient_id, name, dosage, frequency, started_on, notes, created_at, updated_at
+        FROM patient_medications WHERE patient_id = $1 ORDER BY lower(name), id
+    `, patientID); err != nil {
+        return nil, err
+    }
+
+    if err := conn.SelectContext(ctx, &record.EmergencyContacts, `
+        SELECT id, patient_id, name, relationship, phone, email, is_primary, created_at, updated_at
+        FROM emergency_contacts WHERE patient_id = $1 ORDER BY is_primary DESC, created_at, id
+    `, patientID); err != nil {
+        return nil, err
+    }
+
+    biometrics := &models.Biometrics{}
+    err := conn.GetContext(ctx, biometrics, `
+        SELECT patient_id, blood_type, height_cm, weight_kg, updated_at
+        FROM patient_biometrics WHERE patient_id = $1
+    `, patientID)
+    switch {
+    case err == nil:
+        record.Biometrics = biometrics
+    case err != sql.ErrNoRows:
+        return nil, err
+    }
+
+    return record, nil
+}
@@ -1,8 +1,11 @@
 package repository
 
 import (
+    "context"
     "database/sql"
+    "health-bar/shared/database"
     "health-bar/shared/models"
+    "health-bar/shared/pagination"
     "github.com/jmoiron/sqlx"
     "github.com/google/uuid"
 )
@@ -15,32 +18,48 @@ func NewDoctorRepository(db *sqlx.DB) *DoctorRepository {
     return &DoctorRepository{db: db}
 }
 
+// WithTx runs fn in a transaction shared by every repository call made with its context
+func (r *DoctorRepository) WithTx(ctx context.Context, fn func(ctx context.Context) error) error {
+    return database.WithTx(ctx, r.db, fn)
+}
+
+// ReadSnapshot runs fn in a read-only transaction whose reads all see the same snapshot
+func (r *DoctorRepository) ReadSnapshot(ctx context.Context, fn func(ctx context.Context) error) error {
+    return database.WithSnapshot(ctx, r.db, fn)
+}
+
 // CreateProfile creates a doctor profile
-func (r *DoctorRepository) CreateProfile(userID string, profile *models.DoctorProfile) error {
+func (r *DoctorRepository) CreateProfile(ctx context.Context, userID string, profile *models.DoctorProfile) error {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
     profile.ID = uuid.New().String()
     profile.UserID = userID
 
     query := `
         INSERT INTO doctor_profiles (id, user_id, full_name, specialization, license_number, phone)
         VALUES ($1, $2, $3, $4, $5, $6)
-        RETURNING id, user_id, full_name, specialization, license_number, phone, created_at, updated_at
+        RETURNING id, user_id, full_name, specialization, license_number, phone, created_at, updated_at, version
     `
 
-    return r.db.QueryRowx(query,
+    return database.Conn(ctx, r.db).QueryRowxContext(ctx, query,
         profile.ID, profile.UserID, profile.FullName, profile.Specialization,
         profile.LicenseNumber, profile.Phone,
     ).StructScan(profile)
 }
 
 // GetProfileByUserID gets doctor profile by user ID
-func (r *DoctorRepository) GetProfileByUserID(userID string) (*models.DoctorProfile, error) {
+func (r *DoctorRepository) GetProfileByUserID(ctx context.Context, userID string) (*models.DoctorProfile, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
     profile := &models.DoctorProfile{}
     query := `
-        SELECT id, user_id, full_name, specialization, license_number, phone, created_at, updated_at
+        SELECT id, user_id, full_name, specialization, license_number, phone, created_at, updated_at, version
         FROM doctor_profiles
         WHERE user_id = $1
     `
-    err := r.db.Get(profile, query, userID)
+    err := database.Conn(ctx, r.db).GetContext(ctx, profile, query, userID)
     if err != nil {
         return nil, err
     }
@@ -48,14 +67,17 @@ func (r *DoctorRepository) GetProfileByUserID(userID string) (*models.DoctorProf
 }
 
 // GetProfileByID gets doctor profile by profile ID
-func (r *DoctorRepository) GetProfileByID(profileID string) (*models.DoctorProfile, error) {
+func (r *DoctorRepository) GetProfileByID(ctx context.Context, profileID string) (*models.DoctorProfile, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
     profile := &models.DoctorProfile{}
     query := `
-        SELECT id, user_id, full_name, specialization, license_number, phone, created_at, updated_at
+        SELECT id, user_id, full_name, specialization, license_number, phone, created_at, updated_at, version
         FROM doctor_profiles
         WHERE id = $1
     `
-    err := r.db.Get(profile, query, profileID)
+    err := database.Conn(ctx, r.db).GetContext(ctx, profile, query, profileID)
     if err != nil {
         return nil, err
     }
@@ -63,51 +85,62 @@ func (r *DoctorRepository) GetProfileByID(profileID string) (*models.DoctorProfi
 }
 
 // UpdateProfile updates doctor profile
-func (r *DoctorRepository) UpdateProfile(userID string, profile *models.DoctorProfile) error {
+func (r *DoctorRepository) UpdateProfile(ctx context.Context, userID string, profile *models.DoctorProfile) error {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
     query := `
         UPDATE doctor_profiles
-        SET full_name = $1, specialization = $2, license_number = $3, phone = $4, updated_at = NOW()
-        WHERE user_id = $5
-        RETURNING id, user_id, full_name, specialization, license_number, phone, created_at, updated_at
+        SET full_name = $1, specialization = $2, license_number = $3, phone = $4,
+            version = version + 1, updated_at = NOW()
+        WHERE user_id = $5 AND ($6 = 0 OR version = $6)
+        RETURNING id, user_id, full_name, specialization, license_number, phone, created_at, updated_at, version
     `
 
-    return r.db.QueryRowx(query,
+    // profile.Version is the version the caller read; zero skips the check
+    expected := profile.Version
+    conn := database.Conn(ctx, r.db)
+    err := conn.QueryRowxContext(ctx, query,
         profile.FullName, profile.Specialization, profile.LicenseNumber,
-        profile.Phone, userID,
+        profile.Phone, userID, expected,
     ).StructScan(profile)
+    return database.VersionError(ctx, conn, err, expected,
+        `SELECT EXISTS (SELECT 1 FROM doctor_profiles WHERE user_id = $1)`, userID)
 }
 
 // GetPatientProfile gets a patient profile (with permission check)
-func (r *DoctorRepository) GetPatientProfile(doctorID, patientID string) (*models.PatientProfile, error) {
-    // First check if doctor has access
-    hasAccess, err := r.CheckAccess(doctorID, patientID)
-    if err != nil {
-        return nil, err
-    }
-    if !hasAccess {
-        return nil, sql.ErrNoRows // Return not found if no access
-    }
+func (r *DoctorRepository) GetPatientProfile(ctx context.Context, doctorID, patientID string) (*models.PatientProfile, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
 
-    // Get patient profile
+    // The access check and the read are one statement, so a grant revoked
+    // between them cannot leak the profile. No row = no access or not found.
     profile := &models.PatientProfile{}
     query := `
-        SELECT id, user_id, full_name, date_of_birth, gender, phone, address, created_at, updated_at
-        FROM patient_profiles
-        WHERE id = $1
+        SELECT p.id, p.user_id, p.full_name, p.date_of_birth, p.gender, p.phone, p.address, p.created_at, p.updated_at, p.version
+        FROM patient_profiles p
+        INNER JOIN doctor_access_permissions dap ON p.id = dap.patient_id
+        WHERE p.id = $1 AND dap.doctor_id = $2 AND dap.is_active = true
     `
-    err = r.db.Get(profile, query, patientID)
-    return profile, err
+    err := database.Conn(ctx, r.db).GetContext(ctx, profile, query, patientID, doctorID)
+    if err != nil {
+        return nil, err
+    }
+    return profile, nil
 }
 
 // CheckAccess checks if doctor has access to patient's records
-func (r *DoctorRepository) CheckAccess(doctorID, patientID string) (bool, error) {
+func (r *DoctorRepository) CheckAccess(ctx context.Context, doctorID, patientID string) (bool, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
     var isActive bool
     query := `
         SELECT is_active
         FROM doctor_access_permissions
         WHERE doctor_id = $1 AND patient_id = $2
     `
-    err := r.db.Get(&isActive, query, doctorID, patientID)
+    err := database.Conn(ctx, r.db).GetContext(ctx, &isActive, query, doctorID, patientID)
     if err != nil {
         if err == sql.ErrNoRows {
             return false, nil // No permission found = no access
@@ -117,16 +150,26 @@ func (r *DoctorRepository) CheckAccess(doctorID, patientID string) (bool, error)
     return isActive, nil
 }
 
-// ListAccessiblePatients lists all patients the doctor has access to
-func (r *DoctorRepository) ListAccessiblePatients(doctorID string) ([]models.PatientProfile, error) {
+// ListAccessiblePatients gets a page of the patients the doctor has access to and the cursor of the next page
+func (r *DoctorRepository) ListAccessiblePatients(ctx context.Context, doctorID string, page PatientPage) ([]models.PatientProfile, string, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    q := &pagination.Query{}
+    q.Where("dap.doctor_id = " + q.Arg(doctorID))
+    q.Where("dap.is_active = true")
+    if name, ok := page.Filters["name"]; ok {
+        q.Where("p.full_name ILIKE " + q.Arg(pagination.Contains(name)))
+    }
+
     var patients []models.PatientProfile
     query := `
-        SELECT p.id, p.user_id, p.full_name, p.date_of_birth, p.gender, p.phone, p.address, p.created_at, p.updated_at
+        SELECT p.id, p.user_id, p.full_name, p.date_of_birth, p.gender, p.phone, p.address, p.created_at, p.updated_at, p.version
         FROM patient_profiles p
-        INNER JOIN doctor_access_permissions dap ON p.id = dap.patient_id
-        WHERE dap.doctor_id = $1 AND dap.is_active = true
-        ORDER BY p.full_name
-    `
-    err := r.db.Select(&patients, query, doctorID)
-    return patients, err
+        INNER JOIN doctor_access_permissions dap ON p.id = dap.patient_id` + page.Clauses(q)
+    if err := database.Conn(ctx, r.db).SelectContext(ctx, &patients, query, q.Args()...); err != nil {
+        return nil, "", err
+    }
+    patients, next := pagination.Page(patients, page)
+    return patients, next, nil
 }
@@ -0,0 +1,149 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"health-bar/shared/database"
+	"health-bar/shared/memdb"
+	"health-bar/shared/models"
+	"health-bar/shared/pagination"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// MemoryRepository implements Store on top of memdb.
+type MemoryRepository struct {
+	db *memdb.DB
+}
+
+func NewMemoryRepository(db *memdb.DB) *MemoryRepository {
+	return &MemoryRepository{db: db}
+}
+
+func (r *MemoryRepository) WithTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	return r.db.WithTx(ctx, fn)
+}
+
+// ReadSnapshot is WithTx: memdb transactions are serialized, so their reads
+// are consistent already.
+func (r *MemoryRepository) ReadSnapshot(ctx context.Context, fn func(ctx context.Context) error) error {
+	return r.db.WithTx(ctx, fn)
+}
+
+func (r *MemoryRepository) CreateProfile(ctx context.Context, userID string, profile *models.DoctorProfile) error {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	if _, ok := r.db.Users.Rows[userID]; !ok {
+		return memdb.ForeignKeyViolation("doctor_profiles", "doctor_profiles_user_id_fkey")
+	}
+	if _, ok := r.db.DoctorByUserID(userID); ok {
+		return memdb.UniqueViolation("uq_doctor_profiles_user_id")
+	}
+
+	now := r.db.Now()
+	profile.ID = uuid.New().String()
+	profile.UserID = userID
+	profile.CreatedAt, profile.UpdatedAt = now, now
+	profile.Version = 1
+	r.db.DoctorProfiles.Rows[profile.ID] = *profile
+	return nil
+}
+
+func (r *MemoryRepository) GetProfileByUserID(ctx context.Context, userID string) (*models.DoctorProfile, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	profile, ok := r.db.DoctorByUserID(userID)
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return &profile, nil
+}
+
+func (r *MemoryRepository) GetProfileByID(ctx context.Context, profileID string) (*models.DoctorProfile, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	profile, ok := r.db.DoctorProfiles.Rows[profileID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return &profile, nil
+}
+
+func (r *MemoryRepository) UpdateProfile(ctx context.Context, userID string, profile *models.DoctorProfile) error {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	existing, ok := r.db.DoctorByUserID(userID)
+	if !ok {
+		return sql.ErrNoRows
+	}
+	if profile.Version != 0 && profile.Version != existing.Version {
+		return database.ErrVersionConflict
+	}
+
+	existing.FullName = profile.FullName
+	existing.Specialization = profile.Specialization
+	existing.LicenseNumber = profile.LicenseNumber
+	existing.Phone = profile.Phone
+	existing.UpdatedAt = r.db.Now()
+	existing.Version++
+	r.db.DoctorProfiles.Rows[existing.ID] = existing
+
+	*profile = existing
+	return nil
+}
+
+func (r *MemoryRepository) GetPatientProfile(ctx context.Context, doctorID, patientID string) (*models.PatientProfile, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	p, ok := r.db.Permission(patientID, doctorID)
+	if !ok || !p.IsActive {
+		return nil, sql.ErrNoRows
+	}
+	profile, ok := r.db.PatientProfiles.Rows[patientID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return &profile, nil
+}
+
+func (r *MemoryRepository) CheckAccess(ctx context.Context, doctorID, patientID string) (bool, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	p, ok := r.db.Permission(patientID, doctorID)
+	return ok && p.IsActive, nil
+}
+
+func (r *MemoryRepository) ListAccessiblePatients(ctx context.Context, doctorID string, page PatientPage) ([]models.PatientProfile, string, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	name := strings.ToLower(page.Filters["name"])
+	var patients []models.PatientProfile
+	for _, p := range r.db.AccessPermissions.Rows {
+		if p.DoctorID != doctorID || !p.IsActive {
+			continue
+		}
+		profile, ok := r.db.PatientProfiles.Rows[p.PatientID]
+		if !ok || (name != "" && !strings.Contains(strings.ToLower(profile.FullName), name)) {
+			continue
+		}
+		patients = append(patients, profile)
+	}
+	patients, next := pagination.Apply(patients, page)
+	return patients, next, nil
+}
+
+func (r *MemoryRepository) GetClinicalRecord(ctx context.Context, patientID string) (*models.ClinicalRecord, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	record := r.db.ClinicalRecord(patientID)
+	return &record, nil
+}
@@ -0,0 +1,41 @@
+package repository
+
+import (
+	"health-bar/shared/models"
+	"health-bar/shared/pagination"
+)
+
+// PatientPage is a page request for a doctor's accessible patients.
+type PatientPage = pagination.Params[models.PatientProfile]
+
+// PatientPages lists patients by name by default; name matches
+// case-insensitively anywhere in the full name.
+var PatientPages = &pagination.Spec[models.PatientProfile]{
+	Sorts: []pagination.Sort[models.PatientProfile]{
+		{Name: "full_name", Column: "p.full_name", Cast: "text",
+			Value: func(p models.PatientProfile) string { return p.FullName }},
+		{Name: "date_of_birth", Column: "p.date_of_birth", Cast: "date",
+			Value: func(p models.PatientProfile) string { return pagination.Date(p.DateOfBirth) }},
+	},
+	Default:  "full_name",
+	Filters:  []string{"name"},
+	ID:       func(p models.PatientProfile) string { return p.ID },
+	IDColumn: "p.id",
+}
+
+// AppointmentPage is a page request for appointments.
+type AppointmentPage = pagination.Params[models.Appointment]
+
+// AppointmentPages lists appointments soonest first by default. from and to
+// bound the start date. A doctor's list can be narrowed to one patient_id
+// and a patient's to one doctor_id.
+var AppointmentPages = &pagination.Spec[models.Appointment]{
+	Sorts: []pagination.Sort[models.Appointment]{
+		{Name: "starts_at", Column: "starts_at", Cast: "timestamp",
+			Value: func(a models.Appointment) string { return pagination.Timestamp(a.StartsAt) }},
+	},
+	Default:   "starts_at",
+	Filters:   []string{"status", "patient_id", "doctor_id"},
+	DateRange: true,
+	ID:        func(a models.Appointment) string { return a.ID },
+}
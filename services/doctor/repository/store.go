@@ -0,0 +1,45 @@
+package repository
+
+import (
+	"context"
+	"health-bar/shared/models"
+	"time"
+)
+
+// Store is what DoctorHandler needs from persistence. DoctorRepository is
+// the Postgres implementation and MemoryRepository the in-memory one.
+type Store interface {
+	WithTx(ctx context.Context, fn func(ctx context.Context) error) error
+	ReadSnapshot(ctx context.Context, fn func(ctx context.Context) error) error
+	CreateProfile(ctx context.Context, userID string, profile *models.DoctorProfile) error
+	GetProfileByUserID(ctx context.Context, userID string) (*models.DoctorProfile, error)
+	GetProfileByID(ctx context.Context, profileID string) (*models.DoctorProfile, error)
+	UpdateProfile(ctx context.Context, userID string, profile *models.DoctorProfile) error
+	GetPatientProfile(ctx context.Context, doctorID, patientID string) (*models.PatientProfile, error)
+	GetClinicalRecord(ctx context.Context, patientID string) (*models.ClinicalRecord, error)
+	CheckAccess(ctx context.Context, doctorID, patientID string) (bool, error)
+	ListAccessiblePatients(ctx context.Context, doctorID string, page PatientPage) ([]models.PatientProfile, string, error)
+	GetPatientProfileIDByUserID(ctx context.Context, userID string) (string, error)
+	GetPatientUserID(ctx context.Context, patientID string) (string, error)
+	GetDoctorUserIDsWithAccess(ctx context.Context, patientID string) ([]string, error)
+	GetCalendar(ctx context.Context, doctorID string) (*models.DoctorCalendar, error)
+	SaveCalendar(ctx context.Context, calendar *models.DoctorCalendar) error
+	CreateAvailabilityException(ctx context.Context, exception *models.AvailabilityException) error
+	GetAvailabilityExceptionByID(ctx context.Context, exceptionID string) (*models.AvailabilityException, error)
+	ListAvailabilityExceptions(ctx context.Context, doctorID string, from, to time.Time) ([]models.AvailabilityException, error)
+	DeleteAvailabilityException(ctx context.Context, exceptionID string) error
+	CreateAppointment(ctx context.Context, appointment *models.Appointment) error
+	GetAppointmentByID(ctx context.Context, appointmentID string) (*models.Appointment, error)
+	GetAppointmentsByDoctorID(ctx context.Context, doctorID string, page AppointmentPage) ([]models.Appointment, string, error)
+	GetAppointmentsByPatientID(ctx context.Context, patientID string, page AppointmentPage) ([]models.Appointment, string, error)
+	GetBookedAppointments(ctx context.Context, doctorID string, from, to time.Time) ([]models.Appointment, error)
+	RescheduleAppointment(ctx context.Context, appointmentID string, startsAt, endsAt time.Time) (*models.Appointment, error)
+	CancelAppointment(ctx context.Context, appointmentID, cancelledBy, reason string) (*models.Appointment, error)
+	CompleteAppointment(ctx context.Context, appointmentID, notes string, visitID *string) (*models.Appointment, error)
+	CreateVisit(ctx context.Context, visit *models.HospitalVisit) error
+}
+
+var (
+	_ Store = (*DoctorRepository)(nil)
+	_ Store = (*MemoryRepository)(nil)
+)
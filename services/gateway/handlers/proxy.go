@@ -2,6 +2,8 @@ package handlers
 
 import (
     "fmt"
+    "health-bar/shared/apperrors"
+    "health-bar/shared/utils"
     "io"
     "log"
     "net/http"
@@ -14,6 +16,7 @@ type ServiceConfig struct {
     DoctorServiceURL      string
     TimelineServiceURL    string
     PrescriptionServiceURL string
+    NotificationServiceURL string
 }
 
 type ProxyHandler struct {
@@ -29,18 +32,19 @@ func (h *ProxyHandler) ProxyRequest(w http.ResponseWriter, r *http.Request) {
     // Determine target service based on URL path
     targetURL := h.getTargetURL(r.URL.Path)
     if targetURL == "" {
-        http.Error(w, `{"success":false,"error":"Service not found"}`, http.StatusNotFound)
+        utils.SendErrorCode(w, apperrors.CodeNotFound, "Service not found")
         return
     }
 
     // Build full target URL
     fullURL := targetURL + r.URL.Path + "?" + r.URL.RawQuery
 
-    // Create new request
-    proxyReq, err := http.NewRequest(r.Method, fullURL, r.Body)
+    // Create new request. It is cancelled when the client goes away, which
+    // ends streams to the target service too.
+    proxyReq, err := http.NewRequestWithContext(r.Context(), r.Method, fullURL, r.Body)
     if err != nil {
         log.Printf("Error creating proxy request: %v", err)
-        http.Error(w, `{"success":false,"error":"Internal server error"}`, http.StatusInternalServerError)
+        utils.SendAppError(w, err, "Internal server error")
         return
     }
 
@@ -59,7 +63,7 @@ func (h *ProxyHandler) ProxyRequest(w http.ResponseWriter, r *http.Request) {
     resp, err := client.Do(proxyReq)
     if err != nil {
         log.Printf("Error forwarding request to %s: %v", fullURL, err)
-        http.Error(w, `{"success":false,"error":"Service unavailable"}`, http.StatusServiceUnavailable)
+        utils.SendErrorCode(w, apperrors.CodeUnavailable, "Service unavailable")
         return
     }
     defer resp.Body.Close()
@@ -71,13 +75,80 @@ func (h *ProxyHandler) ProxyRequest(w http.ResponseWriter, r *http.Request) {
         }
     }
 
+    if resp.StatusCode == http.StatusSwitchingProtocols {
+        tunnel(w, resp)
+        return
+    }
+
     // Copy status code
     w.WriteHeader(resp.StatusCode)
 
-    // Copy response body
+    // Copy response body. Event streams are flushed as they arrive instead
+    // of being buffered.
+    if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+        copyFlushing(w, resp.Body)
+        return
+    }
     io.Copy(w, resp.Body)
 }
 
+// tunnel completes a protocol switch, such as a WebSocket upgrade, by
+// taking over the client connection and piping bytes both ways until either
+// side closes
+func tunnel(w http.ResponseWriter, resp *http.Response) {
+    backend, ok := resp.Body.(io.ReadWriteCloser)
+    if !ok {
+        utils.SendErrorCode(w, apperrors.CodeInternal, "Internal server error")
+        return
+    }
+
+    client, buffered, err := http.NewResponseController(w).Hijack()
+    if err != nil {
+        log.Printf("Error taking over connection for upgrade: %v", err)
+        utils.SendErrorCode(w, apperrors.CodeInternal, "Internal server error")
+        return
+    }
+    defer client.Close()
+
+    // resp.Write would wait for the body, which is the tunnel itself
+    fmt.Fprintf(buffered, "HTTP/1.1 %s\r\n", resp.Status)
+    resp.Header.Write(buffered)
+    buffered.WriteString("\r\n")
+    if err := buffered.Flush(); err != nil {
+        log.Printf("Error completing upgrade: %v", err)
+        return
+    }
+
+    done := make(chan struct{}, 2)
+    go func() {
+        io.Copy(backend, buffered)
+        done <- struct{}{}
+    }()
+    go func() {
+        io.Copy(client, backend)
+        done <- struct{}{}
+    }()
+    <-done
+}
+
+// copyFlushing copies src to w, flushing after every read
+func copyFlushing(w http.ResponseWriter, src io.Reader) {
+    controller := http.NewResponseController(w)
+    buf := make([]byte, 32*1024)
+    for {
+        n, err := src.Read(buf)
+        if n > 0 {
+            if _, writeErr := w.Write(buf[:n]); writeErr != nil {
+                return
+            }
+            controller.Flush()
+        }
+        if err != nil {
+            return
+        }
+    }
+}
+
 // getTargetURL determines which service to route to based on the path
 func (h *ProxyHandler) getTargetURL(path string) string {
     switch {
@@ -91,6 +162,14 @@ func (h *ProxyHandler) getTargetURL(path string) string {
         return h.config.TimelineServiceURL
     case strings.HasPrefix(path, "/api/prescriptions"):
         return h.config.PrescriptionServiceURL
+    case strings.HasPrefix(path, "/api/messages"):
+        // Messaging lives in the prescription service
+        return h.config.PrescriptionServiceURL
+    case strings.HasPrefix(path, "/api/notifications"):
+        return h.config.NotificationServiceURL
+    case strings.HasPrefix(path, "/api/webhooks"):
+        // Webhooks are sent by the notification service
+        return h.config.NotificationServiceURL
     default:
         return ""
     }
@@ -105,6 +184,7 @@ func (h *ProxyHandler) HealthCheck(w http.ResponseWriter, r *http.Request) {
         "doctor":       h.config.DoctorServiceURL + "/api/doctors/profile",
         "timeline":     h.config.TimelineServiceURL + "/api/timeline/my",
         "prescription": h.config.PrescriptionServiceURL + "/api/prescriptions/my",
+        "notification": h.config.NotificationServiceURL + "/api/notifications/unread-count",
     }
 
     status := make(map[string]string)
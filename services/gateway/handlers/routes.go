@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"net/http"
+
+	"health-bar/shared/apperrors"
+
+	"github.com/gorilla/mux"
+)
+
+// RegisterRoutes mounts the gateway endpoints on router
+func RegisterRoutes(router *mux.Router, h *ProxyHandler) {
+	router.NotFoundHandler = apperrors.NotFoundHandler()
+	router.MethodNotAllowedHandler = apperrors.MethodNotAllowedHandler()
+
+	// Health check endpoint (no rate limit)
+	router.HandleFunc("/health", h.HealthCheck).Methods("GET")
+
+	// API Gateway info
+	router.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{
+            "service": "Health Bar API Gateway",
+            "version": "1.0.0",
+            "endpoints": {
+                "auth": "/api/auth/*",
+                "patients": "/api/patients/*",
+                "doctors": "/api/doctors/*",
+                "timeline": "/api/timeline/*",
+                "prescriptions": "/api/prescriptions/*",
+                "messages": "/api/messages/*",
+                "notifications": "/api/notifications/*",
+                "webhooks": "/api/webhooks/*"
+            },
+            "rate_limit": "10 requests per second, burst 20"
+        }`))
+	}).Methods("GET")
+
+	// All API routes go through proxy with rate limiting
+	apiRouter := router.PathPrefix("/api").Subrouter()
+	apiRouter.PathPrefix("/").HandlerFunc(h.ProxyRequest)
+}
@@ -23,6 +23,7 @@ func main() {
         DoctorServiceURL:      getEnv("DOCTOR_SERVICE_URL", "http://healthbar-doctor-service:8003"),
         TimelineServiceURL:    getEnv("TIMELINE_SERVICE_URL", "http://healthbar-timeline-service:8004"),
         PrescriptionServiceURL: getEnv("PRESCRIPTION_SERVICE_URL", "http://healthbar-prescription-service:8005"),
+        NotificationServiceURL: getEnv("NOTIFICATION_SERVICE_URL", "http://healthbar-notification-service:8006"),
     }
 
     proxyHandler := handlers.NewProxyHandler(config)
@@ -35,30 +36,7 @@ func main() {
 
     // Create router
     router := mux.NewRouter()
-
-    // Health check endpoint (no rate limit)
-    router.HandleFunc("/health", proxyHandler.HealthCheck).Methods("GET")
-
-    // API Gateway info
-    router.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-        w.Header().Set("Content-Type", "application/json")
-        w.Write([]byte(`{
-            "service": "Health Bar API Gateway",
-            "version": "1.0.0",
-            "endpoints": {
-                "auth": "/api/auth/*",
-                "patients": "/api/patients/*",
-                "doctors": "/api/doctors/*",
-                "timeline": "/api/timeline/*",
-                "prescriptions": "/api/prescriptions/*"
-            },
-            "rate_limit": "10 requests per second, burst 20"
-        }`))
-    }).Methods("GET")
-
-    // All API routes go through proxy with rate limiting
-    apiRouter := router.PathPrefix("/api").Subrouter()
-    apiRouter.PathPrefix("/").HandlerFunc(proxyHandler.ProxyRequest)
+    handlers.RegisterRoutes(router, proxyHandler)
 
     // Apply middlewares
     handler := middleware.LoggingMiddleware(router)
@@ -67,8 +45,9 @@ func main() {
     // CORS configuration
     c := cors.New(cors.Options{
         AllowedOrigins:   []string{"http://localhost:3000", "http://localhost:8000"},
-        AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-        AllowedHeaders:   []string{"Content-Type", "Authorization"},
+        AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "HEAD", "DELETE", "OPTIONS"},
+        AllowedHeaders:   []string{"Content-Type", "Authorization", "If-Match", "Idempotency-Key", "Last-Event-ID", "Tus-Resumable", "Upload-Length", "Upload-Metadata", "Upload-Offset", "Upload-Checksum"},
+        ExposedHeaders:   []string{"ETag", "Idempotent-Replayed", "Location", "Tus-Resumable", "Tus-Version", "Tus-Extension", "Tus-Max-Size", "Upload-Offset", "Upload-Length", "Upload-Expires", "Prescription-ID"},
         AllowCredentials: true,
     })
 
@@ -81,6 +60,9 @@ func main() {
     log.Printf("  /api/doctors/*      -> %s", config.DoctorServiceURL)
     log.Printf("  /api/timeline/*     -> %s", config.TimelineServiceURL)
     log.Printf("  /api/prescriptions/* -> %s", config.PrescriptionServiceURL)
+    log.Printf("  /api/messages/*     -> %s", config.PrescriptionServiceURL)
+    log.Printf("  /api/notifications/* -> %s", config.NotificationServiceURL)
+    log.Printf("  /api/webhooks/*     -> %s", config.NotificationServiceURL)
     
     log.Fatal(http.ListenAndServe(":"+port, c.Handler(handler)))
 }
@@ -6,6 +6,17 @@ import (
     "time"
 )
 
+// redactedURI is the request URI with any access_token query parameter,
+// which streaming clients send instead of a header, blanked out
+func redactedURI(r *http.Request) string {
+    query := r.URL.Query()
+    if !query.Has("access_token") {
+        return r.RequestURI
+    }
+    query.Set("access_token", "REDACTED")
+    return r.URL.Path + "?" + query.Encode()
+}
+
 // LoggingMiddleware logs all requests
 func LoggingMiddleware(next http.Handler) http.Handler {
     return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -22,7 +33,7 @@ func LoggingMiddleware(next http.Handler) http.Handler {
         log.Printf(
             "%s %s %s %d %v",
             r.Method,
-            r.RequestURI,
+            redactedURI(r),
             r.RemoteAddr,
             wrapped.statusCode,
             duration,
@@ -40,3 +51,9 @@ func (w *responseWriterWrapper) WriteHeader(statusCode int) {
     w.statusCode = statusCode
     w.ResponseWriter.WriteHeader(statusCode)
 }
+
+// Unwrap lets http.ResponseController reach the underlying writer to flush
+// event streams and hijack upgraded connections
+func (w *responseWriterWrapper) Unwrap() http.ResponseWriter {
+    return w.ResponseWriter
+}
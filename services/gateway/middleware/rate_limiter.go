@@ -1,6 +1,8 @@
 package middleware
 
 import (
+    "health-bar/shared/apperrors"
+    "health-bar/shared/utils"
     "net/http"
     "sync"
     "time"
@@ -65,7 +67,7 @@ func RateLimitMiddleware(limiter *IPRateLimiter) func(http.Handler) http.Handler
             
             // Check if request is allowed
             if !rateLimiter.Allow() {
-                http.Error(w, `{"success":false,"error":"Rate limit exceeded. Too many requests."}`, http.StatusTooManyRequests)
+                utils.SendErrorCode(w, apperrors.CodeRateLimited, "Rate limit exceeded. Too many requests.")
                 return
             }
 
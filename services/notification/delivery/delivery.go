@@ -0,0 +1,173 @@
+// Package delivery sends stored webhook deliveries. Each attempt POSTs the
+// event to the subscription's URL, signed with its secret. A 2xx response
+// settles the delivery; anything else is retried with exponential backoff
+// until MaxAttempts, after which the delivery is dead-lettered for its owner
+// to inspect and replay.
+package delivery
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/netip"
+	"time"
+
+	"health-bar/services/notification/repository"
+	"health-bar/shared/models"
+	"health-bar/shared/webhooks"
+)
+
+const (
+	// MaxAttempts is how many times a delivery is tried before it is
+	// dead-lettered.
+	MaxAttempts = 8
+	// FirstRetry is the wait after the first failure. It doubles with every
+	// further failure up to MaxRetry.
+	FirstRetry = 30 * time.Second
+	MaxRetry   = 6 * time.Hour
+
+	// batchSize is how many deliveries are claimed at a time.
+	batchSize = 50
+	// timeout bounds one attempt. The lease outlasts it so a delivery is
+	// not claimed twice while it is being sent.
+	timeout = 10 * time.Second
+	lease   = time.Minute
+	// maxErrorLen bounds the error recorded for a failed attempt.
+	maxErrorLen = 500
+)
+
+// Dispatcher sends due deliveries.
+type Dispatcher struct {
+	repo   repository.Store
+	client *http.Client
+	now    func() time.Time
+}
+
+func NewDispatcher(repo repository.Store) *Dispatcher {
+	return &Dispatcher{repo: repo, client: webhooks.NewClient(timeout, webhooks.Public), now: time.Now}
+}
+
+// UseAddresses sets which addresses deliveries may connect to, public ones
+// by default.
+func (d *Dispatcher) UseAddresses(allow func(netip.Addr) bool) {
+	d.client = webhooks.NewClient(timeout, allow)
+}
+
+// Backoff is how long to wait before retrying a delivery that failed its
+// attempts-th attempt.
+func Backoff(attempts int) time.Duration {
+	wait := FirstRetry
+	for i := 1; i < attempts && wait < MaxRetry; i++ {
+		wait *= 2
+	}
+	return min(wait, MaxRetry)
+}
+
+// Dispatch sends every due delivery and returns how many it sent, whatever
+// their outcome. A delivery whose outcome cannot be recorded is sent again
+// once its lease runs out, so receivers must expect duplicates of an event.
+func (d *Dispatcher) Dispatch(ctx context.Context) (int, error) {
+	sent := 0
+	for {
+		due, err := d.repo.ClaimWebhookDeliveries(ctx, lease, batchSize)
+		if err != nil {
+			return sent, err
+		}
+		for _, delivery := range due {
+			attempt := d.send(ctx, delivery)
+			// An attempt cut short by shutdown is not held against the
+			// delivery; it is sent again once its lease runs out
+			if ctx.Err() != nil {
+				return sent, ctx.Err()
+			}
+			if err := d.repo.RecordWebhookAttempt(ctx, attempt); err != nil {
+				if ctx.Err() != nil {
+					return sent, ctx.Err()
+				}
+				log.Printf("webhooks: delivery %s: %v", delivery.ID, err)
+			}
+			sent++
+		}
+		if len(due) < batchSize {
+			return sent, nil
+		}
+	}
+}
+
+// send makes one attempt at a delivery and returns its outcome.
+func (d *Dispatcher) send(ctx context.Context, delivery repository.DueDelivery) repository.WebhookAttempt {
+	attempt := repository.WebhookAttempt{DeliveryID: delivery.ID, Status: models.DeliverySucceeded}
+
+	statusCode, err := d.post(ctx, delivery)
+	attempt.StatusCode = statusCode
+	if err == nil {
+		return attempt
+	}
+	attempt.Error = truncate(err.Error(), maxErrorLen)
+	if attempts := delivery.Attempts + 1; attempts >= MaxAttempts {
+		attempt.Status = models.DeliveryDead
+	} else {
+		attempt.Status = models.DeliveryPending
+		attempt.RetryIn = Backoff(attempts)
+	}
+	return attempt
+}
+
+// post sends a delivery and returns the status code of the response, nil
+// when there was none.
+func (d *Dispatcher) post(ctx context.Context, delivery repository.DueDelivery) (*int, error) {
+	body := []byte(delivery.Payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "health-bar-webhooks/1")
+	req.Header.Set(webhooks.HeaderDelivery, delivery.ID)
+	req.Header.Set(webhooks.HeaderEvent, delivery.EventType)
+	req.Header.Set(webhooks.HeaderSignature, webhooks.Sign(delivery.Secret, d.now(), body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	// Drain a little of the body so the connection can be reused
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &resp.StatusCode, fmt.Errorf("endpoint responded %s", resp.Status)
+	}
+	return &resp.StatusCode, nil
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
+
+// RunEvery sends due deliveries now and then every interval until ctx is
+// done.
+func (d *Dispatcher) RunEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if n, err := d.Dispatch(ctx); err != nil {
+			log.Printf("webhooks: %v", err)
+		} else if n > 0 {
+			log.Printf("webhooks: sent %d deliveries", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,269 @@
+package delivery
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"health-bar/services/notification/repository"
+	"health-bar/shared/memdb"
+	"health-bar/shared/models"
+	"health-bar/shared/webhooks"
+)
+
+// receiver is a webhook endpoint that answers with status and records the
+// requests it gets.
+type receiver struct {
+	mu       sync.Mutex
+	status   int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.requests = append(rc.requests, r)
+	rc.bodies = append(rc.bodies, body)
+	w.WriteHeader(rc.status)
+}
+
+func (rc *receiver) count() int {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return len(rc.requests)
+}
+
+type fixture struct {
+	db         *memdb.DB
+	repo       *repository.MemoryRepository
+	dispatcher *Dispatcher
+	receiver   *receiver
+	sub        models.WebhookSubscription
+	now        time.Time
+}
+
+func newFixture(t *testing.T) *fixture {
+	f := &fixture{db: memdb.New(), receiver: &receiver{status: http.StatusOK}, now: time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)}
+	f.db.Now = func() time.Time { return f.now }
+	srv := httptest.NewServer(f.receiver)
+	t.Cleanup(srv.Close)
+
+	doctor := f.db.AddDoctor("d@test.com", "Dr Who")
+	f.sub = f.db.AddWebhookSubscription(doctor.UserID, srv.URL, webhooks.EventAccessGranted)
+	f.repo = repository.NewMemoryRepository(f.db)
+	f.dispatcher = NewDispatcher(f.repo)
+	f.dispatcher.now = func() time.Time { return f.now }
+	// The receiver listens on loopback, which the real client refuses
+	f.dispatcher.client.Transport = srv.Client().Transport
+	return f
+}
+
+// emit stores a delivery of a new access.granted event and returns it.
+func (f *fixture) emit(t *testing.T) models.WebhookDelivery {
+	t.Helper()
+
+	event := webhooks.AccessGranted(f.sub.UserID, "p1", "Pat")
+	if err := webhooks.NewMemoryEmitter(f.db).Emit(context.Background(), event); err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range f.db.WebhookDeliveries.Rows {
+		if d.EventID == event.ID {
+			return d
+		}
+	}
+	t.Fatal("no delivery stored")
+	return models.WebhookDelivery{}
+}
+
+func (f *fixture) dispatch(t *testing.T) int {
+	t.Helper()
+
+	n, err := f.dispatcher.Dispatch(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func (f *fixture) delivery(id string) models.WebhookDelivery {
+	f.db.Lock()
+	defer f.db.Unlock()
+	return f.db.WebhookDeliveries.Rows[id]
+}
+
+func TestDispatchSignsAndSettles(t *testing.T) {
+	f := newFixture(t)
+	d := f.emit(t)
+
+	if n := f.dispatch(t); n != 1 {
+		t.Fatalf("sent %d, want 1", n)
+	}
+	if n := f.dispatch(t); n != 0 {
+		t.Fatalf("sent %d again", n)
+	}
+
+	req, body := f.receiver.requests[0], f.receiver.bodies[0]
+	if req.Header.Get(webhooks.HeaderDelivery) != d.ID || req.Header.Get(webhooks.HeaderEvent) != webhooks.EventAccessGranted {
+		t.Fatalf("headers = %v", req.Header)
+	}
+	if err := webhooks.Verify(f.sub.Secret, req.Header.Get(webhooks.HeaderSignature), body, time.Minute, f.now); err != nil {
+		t.Fatalf("signature: %v", err)
+	}
+	if string(body) != string(d.Payload) {
+		t.Fatalf("body = %s, want %s", body, d.Payload)
+	}
+
+	got := f.delivery(d.ID)
+	if got.Status != models.DeliverySucceeded || got.Attempts != 1 || got.LastStatusCode == nil || *got.LastStatusCode != http.StatusOK {
+		t.Fatalf("delivery = %+v", got)
+	}
+}
+
+func TestDispatchRetriesWithBackoffThenDeadLetters(t *testing.T) {
+	f := newFixture(t)
+	f.receiver.status = http.StatusServiceUnavailable
+	d := f.emit(t)
+
+	for attempt := 1; attempt < MaxAttempts; attempt++ {
+		if n := f.dispatch(t); n != 1 {
+			t.Fatalf("attempt %d: sent %d", attempt, n)
+		}
+		got := f.delivery(d.ID)
+		wait := Backoff(attempt)
+		if got.Status != models.DeliveryPending || got.Attempts != attempt || !got.NextAttemptAt.Equal(f.now.Add(wait)) {
+			t.Fatalf("after attempt %d: %+v", attempt, got)
+		}
+		if got.LastError != "endpoint responded 503 Service Unavailable" {
+			t.Fatalf("last error = %q", got.LastError)
+		}
+
+		// Not due yet
+		f.now = f.now.Add(wait - time.Second)
+		if n := f.dispatch(t); n != 0 {
+			t.Fatalf("sent %d before the backoff ran out", n)
+		}
+		f.now = f.now.Add(time.Second)
+	}
+
+	f.dispatch(t)
+	got := f.delivery(d.ID)
+	if got.Status != models.DeliveryDead || got.Attempts != MaxAttempts || f.receiver.count() != MaxAttempts {
+		t.Fatalf("after the last attempt: %+v, %d requests", got, f.receiver.count())
+	}
+	f.now = f.now.Add(24 * time.Hour)
+	if n := f.dispatch(t); n != 0 {
+		t.Fatalf("dead delivery sent again")
+	}
+
+	// A replay is a fresh delivery of the same event
+	f.receiver.status = http.StatusNoContent
+	replay, err := f.repo.ReplayWebhookDelivery(context.Background(), d.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := f.dispatch(t); n != 1 {
+		t.Fatalf("replay sent %d", n)
+	}
+	if got := f.delivery(replay.ID); got.Status != models.DeliverySucceeded || got.EventID != d.EventID || *got.ReplayOf != d.ID {
+		t.Fatalf("replay = %+v", got)
+	}
+	if got := f.delivery(d.ID); got.Status != models.DeliveryDead {
+		t.Fatalf("replayed delivery = %+v", got)
+	}
+}
+
+func TestDispatchUnreachableEndpoint(t *testing.T) {
+	f := newFixture(t)
+	f.db.Lock()
+	sub := f.db.WebhookSubscriptions.Rows[f.sub.ID]
+	sub.URL = "http://127.0.0.1:1/hook"
+	f.db.WebhookSubscriptions.Rows[sub.ID] = sub
+	f.db.Unlock()
+	d := f.emit(t)
+
+	f.dispatch(t)
+	got := f.delivery(d.ID)
+	if got.Status != models.DeliveryPending || got.Attempts != 1 || got.LastStatusCode != nil || got.LastError == "" {
+		t.Fatalf("delivery = %+v", got)
+	}
+}
+
+func TestDispatchRefusesInternalAddresses(t *testing.T) {
+	f := newFixture(t)
+	f.dispatcher.UseAddresses(webhooks.Public)
+	d := f.emit(t)
+
+	f.dispatch(t)
+	got := f.delivery(d.ID)
+	if f.receiver.count() != 0 || got.Status != models.DeliveryPending || !strings.Contains(got.LastError, webhooks.ErrInternalAddress.Error()) {
+		t.Fatalf("delivery = %+v, %d requests", got, f.receiver.count())
+	}
+}
+
+func TestDispatchDoesNotFollowRedirects(t *testing.T) {
+	f := newFixture(t)
+	target := &receiver{status: http.StatusOK}
+	targetSrv := httptest.NewServer(target)
+	t.Cleanup(targetSrv.Close)
+	redirect := httptest.NewServer(http.RedirectHandler(targetSrv.URL, http.StatusTemporaryRedirect))
+	t.Cleanup(redirect.Close)
+
+	f.db.Lock()
+	sub := f.db.WebhookSubscriptions.Rows[f.sub.ID]
+	sub.URL = redirect.URL
+	f.db.WebhookSubscriptions.Rows[sub.ID] = sub
+	f.db.Unlock()
+	d := f.emit(t)
+
+	f.dispatch(t)
+	got := f.delivery(d.ID)
+	if target.count() != 0 || got.Status != models.DeliveryPending || got.LastStatusCode == nil || *got.LastStatusCode != http.StatusTemporaryRedirect {
+		t.Fatalf("delivery = %+v, %d requests to the target", got, target.count())
+	}
+}
+
+func TestClaimLeasesDeliveries(t *testing.T) {
+	f := newFixture(t)
+	d := f.emit(t)
+
+	// A dispatcher that claimed the delivery and crashed leaves it to be
+	// claimed again when the lease runs out
+	ctx := context.Background()
+	if due, _ := f.repo.ClaimWebhookDeliveries(ctx, lease, batchSize); len(due) != 1 || due[0].URL != f.sub.URL || due[0].Secret != f.sub.Secret {
+		t.Fatalf("claimed %+v", due)
+	}
+	if due, _ := f.repo.ClaimWebhookDeliveries(ctx, lease, batchSize); len(due) != 0 {
+		t.Fatalf("claimed a leased delivery again")
+	}
+	f.now = f.now.Add(lease)
+	if n := f.dispatch(t); n != 1 || f.delivery(d.ID).Status != models.DeliverySucceeded {
+		t.Fatalf("sent %d after the lease ran out", n)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{5, 8 * time.Minute},
+		{10, 4*time.Hour + 16*time.Minute},
+		{11, 6 * time.Hour},
+		{40, 6 * time.Hour},
+	}
+	for _, tt := range tests {
+		if got := Backoff(tt.attempts); got != tt.want {
+			t.Errorf("Backoff(%d) = %s, want %s", tt.attempts, got, tt.want)
+		}
+	}
+}
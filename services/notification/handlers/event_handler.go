@@ -0,0 +1,41 @@
+package handlers
+
+import (
+    "context"
+    "health-bar/shared/events"
+    "log"
+)
+
+// Subscribe handles the events other services publish about patients
+func (h *NotificationHandler) Subscribe(consumer *events.Consumer) {
+    consumer.Handle(events.TopicPatientDeleted, h.purgeDeletedPatient)
+    consumer.Handle(events.TopicAccessRevoked, h.purgeRevokedAccess)
+}
+
+// purgeDeletedPatient deletes every webhook delivery about a deleted
+// patient, so their data is neither sent nor replayed
+func (h *NotificationHandler) purgeDeletedPatient(ctx context.Context, e events.Event) error {
+    purged, err := h.repo.PurgePatientDeliveries(ctx, e.Data["patient_id"], "")
+    if err != nil {
+        return err
+    }
+    log.Printf("Purged %d webhook deliveries of deleted patient %s", purged, e.Data["patient_id"])
+    return nil
+}
+
+// purgeRevokedAccess deletes the webhook deliveries about a patient to a
+// doctor who lost access to them
+func (h *NotificationHandler) purgeRevokedAccess(ctx context.Context, e events.Event) error {
+    patientID, doctorUserID := e.Data["patient_id"], e.Data["doctor_user_id"]
+    if patientID == "" || doctorUserID == "" {
+        // An empty user would purge the patient's deliveries to everyone
+        log.Printf("Ignoring %s event %s without patient or doctor", e.Topic, e.ID)
+        return nil
+    }
+    purged, err := h.repo.PurgePatientDeliveries(ctx, patientID, doctorUserID)
+    if err != nil {
+        return err
+    }
+    log.Printf("Purged %d webhook deliveries of patient %s to doctor %s", purged, patientID, e.Data["doctor_id"])
+    return nil
+}
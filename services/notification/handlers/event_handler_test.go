@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+
+	"health-bar/shared/events"
+	"health-bar/shared/webhooks"
+)
+
+func TestEventsPurgeWebhookDeliveries(t *testing.T) {
+	h, _, db := newTestHandler()
+	doctor := db.AddDoctor("d@test.com", "Dr Who")
+	other := db.AddDoctor("o@test.com", "Dr No")
+	db.AddWebhookSubscription(doctor.UserID, "https://clinic.test/hooks", webhooks.EventVisitCreated)
+	db.AddWebhookSubscription(other.UserID, "https://other.test/hooks", webhooks.EventVisitCreated)
+
+	ctx := context.Background()
+	emitter := webhooks.NewMemoryEmitter(db)
+	if err := emitter.Emit(ctx,
+		webhooks.VisitCreated(doctor.UserID, "p1", "v1", "General", db.Now()),
+		webhooks.VisitCreated(other.UserID, "p1", "v2", "General", db.Now()),
+		webhooks.VisitCreated(doctor.UserID, "p2", "v3", "General", db.Now()),
+		webhooks.VisitCreated(other.UserID, "p2", "v4", "General", db.Now()),
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	outbox := events.NewMemoryOutbox(db)
+	bus := events.NewLocalBus()
+	consumer := events.NewConsumer("notification-service", events.NewMemoryInbox(db), bus)
+	h.Subscribe(consumer)
+	if err := consumer.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	publish := func(e events.Event) {
+		t.Helper()
+		if err := outbox.Add(ctx, e); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := events.NewRelay(outbox, bus).Publish(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := consumer.Process(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	remaining := func() map[string]int {
+		db.Lock()
+		defer db.Unlock()
+		counts := map[string]int{}
+		for _, d := range db.WebhookDeliveries.Rows {
+			counts[db.WebhookSubscriptions.Rows[d.SubscriptionID].UserID]++
+		}
+		return counts
+	}
+
+	// Revoking purges what the doctor would get about that patient only
+	publish(events.AccessRevoked("p1", doctor.ID, doctor.UserID))
+	if counts := remaining(); counts[doctor.UserID] != 1 || counts[other.UserID] != 2 {
+		t.Fatalf("after revoke: %v", counts)
+	}
+	// Without a doctor the event must not purge everyone's deliveries
+	publish(events.AccessRevoked("p2", doctor.ID, ""))
+	if counts := remaining(); counts[doctor.UserID] != 1 || counts[other.UserID] != 2 {
+		t.Fatalf("after incomplete revoke: %v", counts)
+	}
+
+	publish(events.PatientDeleted("p2", "u2"))
+	if counts := remaining(); counts[doctor.UserID] != 0 || counts[other.UserID] != 1 {
+		t.Fatalf("after delete: %v", counts)
+	}
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+    "sync"
+)
+
+// eventBuffer is how many events a connection may fall behind by before
+// the hub drops it
+const eventBuffer = 32
+
+// Event is one message pushed to a connected client: a notification, with
+// its ID so a reconnecting client can resume after it, or a new unread count
+type Event struct {
+    Name string      `json:"event"`
+    ID   string      `json:"id,omitempty"`
+    Data interface{} `json:"data"`
+}
+
+// Hub fans events out to the connections of each user
+type Hub struct {
+    mu          sync.Mutex
+    subscribers map[string]map[chan Event]struct{}
+}
+
+func NewHub() *Hub {
+    return &Hub{subscribers: map[string]map[chan Event]struct{}{}}
+}
+
+// Subscribe registers a connection for userID. The channel is closed when
+// the connection falls too far behind; cancel unregisters it.
+func (h *Hub) Subscribe(userID string) (<-chan Event, func()) {
+    h.mu.Lock()
+    defer h.mu.Unlock()
+
+    ch := make(chan Event, eventBuffer)
+    if h.subscribers[userID] == nil {
+        h.subscribers[userID] = map[chan Event]struct{}{}
+    }
+    h.subscribers[userID][ch] = struct{}{}
+
+    return ch, func() {
+        h.mu.Lock()
+        defer h.mu.Unlock()
+        h.remove(userID, ch)
+    }
+}
+
+// Connected reports whether userID has any connections
+func (h *Hub) Connected(userID string) bool {
+    h.mu.Lock()
+    defer h.mu.Unlock()
+    return len(h.subscribers[userID]) > 0
+}
+
+// Publish sends ev to every connection of userID without waiting. A
+// connection whose buffer is full is dropped rather than holding up the
+// others; its client reconnects and catches up from its last event.
+func (h *Hub) Publish(userID string, ev Event) {
+    h.mu.Lock()
+    defer h.mu.Unlock()
+
+    for ch := range h.subscribers[userID] {
+        select {
+        case ch <- ev:
+        default:
+            h.remove(userID, ch)
+        }
+    }
+}
+
+// remove must be called with the lock held
+func (h *Hub) remove(userID string, ch chan Event) {
+    if _, ok := h.subscribers[userID][ch]; !ok {
+        return
+    }
+    delete(h.subscribers[userID], ch)
+    if len(h.subscribers[userID]) == 0 {
+        delete(h.subscribers, userID)
+    }
+    close(ch)
+}
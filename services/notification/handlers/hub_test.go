@@ -0,0 +1,40 @@
+package handlers
+
+import "testing"
+
+func TestHubDropsLaggingSubscribers(t *testing.T) {
+	hub := NewHub()
+	slow, cancelSlow := hub.Subscribe("u1")
+	defer cancelSlow()
+	fast, cancelFast := hub.Subscribe("u1")
+	other, cancelOther := hub.Subscribe("u2")
+	defer cancelOther()
+
+	for i := 0; i < eventBuffer; i++ {
+		hub.Publish("u1", Event{Name: "unread"})
+		<-fast
+	}
+	hub.Publish("u1", Event{Name: "unread"})
+
+	// slow is full, so it is dropped: it drains, then reads as closed
+	for i := 0; i < eventBuffer; i++ {
+		<-slow
+	}
+	if _, ok := <-slow; ok {
+		t.Fatal("lagging subscriber still open")
+	}
+	if ev, ok := <-fast; !ok || ev.Name != "unread" {
+		t.Fatalf("fast subscriber got %+v, %v", ev, ok)
+	}
+	if len(other) != 0 {
+		t.Fatal("another user's subscriber got events")
+	}
+
+	cancelFast()
+	if hub.Connected("u1") {
+		t.Fatal("u1 still connected after every subscriber left")
+	}
+	if _, ok := <-fast; ok {
+		t.Fatal("cancelled subscriber still open")
+	}
+}
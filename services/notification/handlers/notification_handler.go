@@ -0,0 +1,222 @@
+package handlers
+
+import (
+    "context"
+    "database/sql"
+    "encoding/json"
+    "health-bar/shared/apperrors"
+    "health-bar/shared/notify"
+    "health-bar/shared/pagination"
+    "health-bar/shared/utils"
+    "health-bar/shared/webhooks"
+    "health-bar/services/notification/repository"
+    "log"
+    "net"
+    "net/http"
+    "net/netip"
+    "sort"
+    "time"
+    "github.com/google/uuid"
+)
+
+// maxReadIDs bounds how many notifications one request can mark read
+const maxReadIDs = 100
+
+type NotificationHandler struct {
+    repo repository.Store
+    hub  *Hub
+    // heartbeat is how often an idle stream is pinged
+    heartbeat time.Duration
+    // resolver looks up webhook hosts, whose addresses allowAddr must accept
+    resolver  webhooks.Resolver
+    allowAddr func(netip.Addr) bool
+}
+
+func NewNotificationHandler(repo repository.Store) *NotificationHandler {
+    return &NotificationHandler{repo: repo, hub: NewHub(), heartbeat: 25 * time.Second, resolver: net.DefaultResolver, allowAddr: webhooks.Public}
+}
+
+// UseResolver sets how webhook hosts are looked up
+func (h *NotificationHandler) UseResolver(resolver webhooks.Resolver) {
+    h.resolver = resolver
+}
+
+// UseWebhookAddresses sets which addresses webhooks may be subscribed to,
+// public ones by default
+func (h *NotificationHandler) UseWebhookAddresses(allow func(netip.Addr) bool) {
+    h.allowAddr = allow
+}
+
+type UnreadCount struct {
+    Unread int `json:"unread"`
+}
+
+type MarkReadRequest struct {
+    IDs []string `json:"ids"`
+}
+
+type ReadReceipt struct {
+    Marked int64 `json:"marked"`
+}
+
+// Kind describes one entry of the notification catalog
+type Kind struct {
+    Kind        string `json:"kind"`
+    Description string `json:"description"`
+}
+
+// GetNotifications lists the current user's notifications
+func (h *NotificationHandler) GetNotifications(w http.ResponseWriter, r *http.Request) {
+    userID := r.Header.Get("X-User-ID")
+    if userID == "" {
+        utils.SendError(w, http.StatusUnauthorized, "Unauthorized")
+        return
+    }
+
+    page, err := pagination.Parse(r.URL.Query(), repository.NotificationPages)
+    if err != nil {
+        utils.SendAppError(w, err, "Invalid pagination parameters")
+        return
+    }
+    if kind, ok := page.Filters["kind"]; ok && !notify.Known(kind) {
+        utils.SendErrorCode(w, apperrors.CodeValidation, "Unknown notification kind: "+kind)
+        return
+    }
+    if unread, ok := page.Filters["unread"]; ok && unread != "true" && unread != "false" {
+        utils.SendErrorCode(w, apperrors.CodeValidation, "unread must be true or false")
+        return
+    }
+
+    notifications, next, err := h.repo.GetNotifications(r.Context(), userID, page)
+    if err != nil {
+        utils.SendAppError(w, err, "Failed to retrieve notifications")
+        return
+    }
+
+    utils.SendPage(w, http.StatusOK, "Notifications retrieved", notifications, next)
+}
+
+// GetUnreadCount counts the current user's unread notifications
+func (h *NotificationHandler) GetUnreadCount(w http.ResponseWriter, r *http.Request) {
+    userID := r.Header.Get("X-User-ID")
+    if userID == "" {
+        utils.SendError(w, http.StatusUnauthorized, "Unauthorized")
+        return
+    }
+
+    count, err := h.repo.CountUnread(r.Context(), userID)
+    if err != nil {
+        utils.SendAppError(w, err, "Failed to count notifications")
+        return
+    }
+
+    utils.SendSuccess(w, http.StatusOK, "Unread notifications counted", UnreadCount{Unread: count})
+}
+
+// MarkRead marks some of the current user's notifications read. IDs of
+// notifications that are already read or not the user's are skipped.
+func (h *NotificationHandler) MarkRead(w http.ResponseWriter, r *http.Request) {
+    userID := r.Header.Get("X-User-ID")
+    if userID == "" {
+        utils.SendError(w, http.StatusUnauthorized, "Unauthorized")
+        return
+    }
+
+    var req MarkReadRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        utils.SendError(w, http.StatusBadRequest, "Invalid request body")
+        return
+    }
+    if len(req.IDs) == 0 {
+        utils.SendErrorCode(w, apperrors.CodeValidation, "At least one notification ID is required")
+        return
+    }
+    if len(req.IDs) > maxReadIDs {
+        utils.SendErrorCode(w, apperrors.CodeValidation, "Too many notification IDs")
+        return
+    }
+    for _, id := range req.IDs {
+        if _, err := uuid.Parse(id); err != nil {
+            utils.SendErrorCode(w, apperrors.CodeValidation, "Invalid notification ID: "+id)
+            return
+        }
+    }
+
+    h.markRead(w, r, userID, req.IDs)
+}
+
+// MarkAllRead marks all of the current user's notifications read
+func (h *NotificationHandler) MarkAllRead(w http.ResponseWriter, r *http.Request) {
+    userID := r.Header.Get("X-User-ID")
+    if userID == "" {
+        utils.SendError(w, http.StatusUnauthorized, "Unauthorized")
+        return
+    }
+
+    h.markRead(w, r, userID, nil)
+}
+
+func (h *NotificationHandler) markRead(w http.ResponseWriter, r *http.Request, userID string, ids []string) {
+    marked, err := h.repo.MarkRead(r.Context(), userID, ids)
+    if err != nil {
+        utils.SendAppError(w, err, "Failed to mark notifications read")
+        return
+    }
+
+    utils.SendSuccess(w, http.StatusOK, "Notifications marked read", ReadReceipt{Marked: marked})
+}
+
+// GetKinds lists the notification catalog
+func (h *NotificationHandler) GetKinds(w http.ResponseWriter, r *http.Request) {
+    kinds := make([]Kind, 0, len(notify.Catalog))
+    for kind, description := range notify.Catalog {
+        kinds = append(kinds, Kind{Kind: kind, Description: description})
+    }
+    sort.Slice(kinds, func(i, j int) bool { return kinds[i].Kind < kinds[j].Kind })
+
+    utils.SendSuccess(w, http.StatusOK, "Notification kinds retrieved", kinds)
+}
+
+// Relay pushes announced notifications to connected clients until ctx is
+// done, listening again after retry when the listener fails
+func (h *NotificationHandler) Relay(ctx context.Context, retry time.Duration) {
+    for {
+        err := h.repo.Listen(ctx, func(a notify.Announcement) { h.deliver(ctx, a) })
+        if ctx.Err() != nil {
+            return
+        }
+        log.Printf("Notification listener stopped, retrying in %s: %v", retry, err)
+
+        select {
+        case <-ctx.Done():
+            return
+        case <-time.After(retry):
+        }
+    }
+}
+
+// deliver sends an announced notification, and the user's new unread
+// count, to the user's connections
+func (h *NotificationHandler) deliver(ctx context.Context, a notify.Announcement) {
+    if !h.hub.Connected(a.UserID) {
+        return
+    }
+
+    if a.NotificationID != "" {
+        n, err := h.repo.GetNotificationByID(ctx, a.NotificationID)
+        if err != nil {
+            if err != sql.ErrNoRows {
+                log.Printf("Failed to load notification %s: %v", a.NotificationID, err)
+            }
+            return
+        }
+        h.hub.Publish(a.UserID, Event{Name: "notification", ID: n.ID, Data: n})
+    }
+
+    count, err := h.repo.CountUnread(ctx, a.UserID)
+    if err != nil {
+        log.Printf("Failed to count notifications of user %s: %v", a.UserID, err)
+        return
+    }
+    h.hub.Publish(a.UserID, Event{Name: "unread", Data: UnreadCount{Unread: count}})
+}
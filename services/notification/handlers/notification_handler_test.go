@@ -0,0 +1,230 @@
+package handlers
+
+import (
+	"context"
+	"health-bar/services/notification/repository"
+	"health-bar/shared/apperrors"
+	"health-bar/shared/memdb"
+	"health-bar/shared/models"
+	"health-bar/shared/notify"
+	"health-bar/shared/pagination"
+	"health-bar/shared/testutil"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func newTestHandler() (*NotificationHandler, *repository.MemoryRepository, *memdb.DB) {
+	db := memdb.New()
+	// A ticking clock keeps notifications in the order they were sent
+	now := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	db.Now = func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+	repo := repository.NewMemoryRepository(db)
+	return NewNotificationHandler(repo), repo, db
+}
+
+func send(t *testing.T, repo *repository.MemoryRepository, msg notify.Message) models.Notification {
+	t.Helper()
+
+	if err := repo.Notify(context.Background(), msg); err != nil {
+		t.Fatal(err)
+	}
+	page, err := pagination.Parse(url.Values{"limit": {"1"}}, repository.NotificationPages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	notifications, _, err := repo.GetNotifications(context.Background(), msg.UserID, page)
+	if err != nil || len(notifications) != 1 {
+		t.Fatalf("stored notifications = %v, %v", notifications, err)
+	}
+	return notifications[0]
+}
+
+func listNotifications(t *testing.T, h *NotificationHandler, userID, query string) []models.Notification {
+	t.Helper()
+
+	req := testutil.NewRequest(t, http.MethodGet, "/api/notifications"+query, nil, userID, "doctor")
+	rec, resp := testutil.Serve(t, h.GetNotifications, req)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+
+	var notifications []models.Notification
+	testutil.DecodeData(t, resp, &notifications)
+	return notifications
+}
+
+func unreadCount(t *testing.T, h *NotificationHandler, userID string) int {
+	t.Helper()
+
+	req := testutil.NewRequest(t, http.MethodGet, "/api/notifications/unread-count", nil, userID, "doctor")
+	rec, resp := testutil.Serve(t, h.GetUnreadCount, req)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+
+	var count UnreadCount
+	testutil.DecodeData(t, resp, &count)
+	return count.Unread
+}
+
+func TestGetNotifications(t *testing.T) {
+	h, repo, db := newTestHandler()
+	doctor := db.AddDoctor("d@test.com", "Dr Who")
+	other := db.AddDoctor("o@test.com", "Dr No")
+
+	granted := send(t, repo, notify.AccessGranted(doctor.UserID, "p1", "Pat"))
+	upload := send(t, repo, notify.UploadCreated(doctor.UserID, "p1", "Pat", "doc1", "scan.pdf"))
+	send(t, repo, notify.AccessGranted(other.UserID, "p1", "Pat"))
+
+	req := testutil.NewRequest(t, http.MethodGet, "/api/notifications", nil, "", "")
+	rec, _ := testutil.Serve(t, h.GetNotifications, req)
+	testutil.ExpectStatus(t, rec, http.StatusUnauthorized)
+
+	notifications := listNotifications(t, h, doctor.UserID, "")
+	if len(notifications) != 2 || notifications[0].ID != upload.ID || notifications[1].ID != granted.ID {
+		t.Fatalf("notifications = %+v, want the doctor's own, newest first", notifications)
+	}
+	if notifications[0].Subject != "Pat uploaded scan.pdf" || notifications[0].Data["document_id"] != "doc1" {
+		t.Fatalf("upload notification = %+v", notifications[0])
+	}
+
+	notifications = listNotifications(t, h, doctor.UserID, "?kind="+notify.KindAccessGranted)
+	if len(notifications) != 1 || notifications[0].ID != granted.ID {
+		t.Fatalf("kind filter = %+v", notifications)
+	}
+
+	for _, query := range []string{"?kind=nonsense", "?unread=maybe"} {
+		req := testutil.NewRequest(t, http.MethodGet, "/api/notifications"+query, nil, doctor.UserID, "doctor")
+		rec, resp := testutil.Serve(t, h.GetNotifications, req)
+		testutil.ExpectStatus(t, rec, http.StatusBadRequest)
+		testutil.ExpectCode(t, resp, apperrors.CodeValidation)
+	}
+}
+
+func TestMarkRead(t *testing.T) {
+	h, repo, db := newTestHandler()
+	doctor := db.AddDoctor("d@test.com", "Dr Who")
+	other := db.AddDoctor("o@test.com", "Dr No")
+
+	first := send(t, repo, notify.AccessGranted(doctor.UserID, "p1", "Pat"))
+	send(t, repo, notify.AccessGranted(doctor.UserID, "p2", "Sam"))
+	theirs := send(t, repo, notify.AccessGranted(other.UserID, "p1", "Pat"))
+
+	if got := unreadCount(t, h, doctor.UserID); got != 2 {
+		t.Fatalf("unread = %d, want 2", got)
+	}
+
+	tests := []struct {
+		name string
+		body interface{}
+		want int
+	}{
+		{"no IDs", MarkReadRequest{}, http.StatusBadRequest},
+		{"not a UUID", MarkReadRequest{IDs: []string{"x"}}, http.StatusBadRequest},
+		{"not a JSON body", "ids", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := testutil.NewRequest(t, http.MethodPost, "/api/notifications/read", tt.body, doctor.UserID, "doctor")
+			rec, _ := testutil.Serve(t, h.MarkRead, req)
+			testutil.ExpectStatus(t, rec, tt.want)
+		})
+	}
+
+	// Another user's notification is skipped, not marked
+	req := testutil.NewRequest(t, http.MethodPost, "/api/notifications/read",
+		MarkReadRequest{IDs: []string{first.ID, theirs.ID}}, doctor.UserID, "doctor")
+	rec, resp := testutil.Serve(t, h.MarkRead, req)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+	var receipt ReadReceipt
+	testutil.DecodeData(t, resp, &receipt)
+	if receipt.Marked != 1 {
+		t.Fatalf("marked = %d, want 1", receipt.Marked)
+	}
+	if got := unreadCount(t, h, doctor.UserID); got != 1 {
+		t.Fatalf("unread = %d, want 1", got)
+	}
+	if got := unreadCount(t, h, other.UserID); got != 1 {
+		t.Fatalf("other user's unread = %d, want 1", got)
+	}
+
+	unread := listNotifications(t, h, doctor.UserID, "?unread=true")
+	if len(unread) != 1 || unread[0].ID == first.ID {
+		t.Fatalf("unread notifications = %+v", unread)
+	}
+
+	req = testutil.NewRequest(t, http.MethodPost, "/api/notifications/read-all", nil, doctor.UserID, "doctor")
+	rec, resp = testutil.Serve(t, h.MarkAllRead, req)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+	testutil.DecodeData(t, resp, &receipt)
+	if receipt.Marked != 1 || unreadCount(t, h, doctor.UserID) != 0 {
+		t.Fatalf("read-all marked %d", receipt.Marked)
+	}
+}
+
+func TestGetKinds(t *testing.T) {
+	h, _, _ := newTestHandler()
+
+	req := testutil.NewRequest(t, http.MethodGet, "/api/notifications/kinds", nil, "u1", "patient")
+	rec, resp := testutil.Serve(t, h.GetKinds, req)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+
+	var kinds []Kind
+	testutil.DecodeData(t, resp, &kinds)
+	if len(kinds) != len(notify.Catalog) {
+		t.Fatalf("got %d kinds, want %d", len(kinds), len(notify.Catalog))
+	}
+	for i := 1; i < len(kinds); i++ {
+		if kinds[i-1].Kind >= kinds[i].Kind {
+			t.Fatalf("kinds not sorted: %+v", kinds)
+		}
+	}
+}
+
+func TestRelayDeliversAnnouncements(t *testing.T) {
+	h, repo, db := newTestHandler()
+	doctor := db.AddDoctor("d@test.com", "Dr Who")
+
+	events, unsubscribe := h.hub.Subscribe(doctor.UserID)
+	defer unsubscribe()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		h.Relay(ctx, time.Millisecond)
+		close(stopped)
+	}()
+
+	// The relay may not be listening yet, so keep sending until one
+	// notification gets through
+	timeout := time.After(5 * time.Second)
+	var got Event
+	for got.Name == "" {
+		repo.Notify(ctx, notify.AccessGranted(doctor.UserID, "p1", "Pat"))
+		select {
+		case got = <-events:
+		case <-time.After(10 * time.Millisecond):
+		case <-timeout:
+			t.Fatal("no event relayed")
+		}
+	}
+	if got.Name != "notification" || got.ID == "" {
+		t.Fatalf("first event = %+v, want a notification", got)
+	}
+	select {
+	case got = <-events:
+	case <-timeout:
+		t.Fatal("no unread count relayed")
+	}
+	if got.Name != "unread" {
+		t.Fatalf("second event = %+v, want the unread count", got)
+	}
+
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Relay did not stop")
+	}
+}
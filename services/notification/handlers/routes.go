@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"health-bar/shared/apperrors"
+	"health-bar/shared/middleware"
+
+	"github.com/gorilla/mux"
+)
+
+// RegisterRoutes mounts the notification service endpoints on router
+func RegisterRoutes(router *mux.Router, h *NotificationHandler) {
+	router.NotFoundHandler = apperrors.NotFoundHandler()
+	router.MethodNotAllowedHandler = apperrors.MethodNotAllowedHandler()
+
+	router.HandleFunc("/api/notifications", middleware.AuthMiddleware(h.GetNotifications)).Methods("GET")
+	router.HandleFunc("/api/notifications/unread-count", middleware.AuthMiddleware(h.GetUnreadCount)).Methods("GET")
+	router.HandleFunc("/api/notifications/read", middleware.AuthMiddleware(h.MarkRead)).Methods("POST")
+	router.HandleFunc("/api/notifications/read-all", middleware.AuthMiddleware(h.MarkAllRead)).Methods("POST")
+	router.HandleFunc("/api/notifications/kinds", middleware.AuthMiddleware(h.GetKinds)).Methods("GET")
+
+	// Outbound webhooks
+	router.HandleFunc("/api/webhooks", middleware.AuthMiddleware(h.CreateSubscription)).Methods("POST")
+	router.HandleFunc("/api/webhooks", middleware.AuthMiddleware(h.GetSubscriptions)).Methods("GET")
+	router.HandleFunc("/api/webhooks/subscription", middleware.AuthMiddleware(h.DeleteSubscription)).Methods("DELETE")
+	router.HandleFunc("/api/webhooks/deliveries", middleware.AuthMiddleware(h.GetDeliveries)).Methods("GET")
+	router.HandleFunc("/api/webhooks/dead-letters", middleware.AuthMiddleware(h.GetDeadLetters)).Methods("GET")
+	router.HandleFunc("/api/webhooks/deliveries/replay", middleware.AuthMiddleware(h.ReplayDelivery)).Methods("POST")
+	router.HandleFunc("/api/webhooks/events", middleware.AuthMiddleware(h.GetEventTypes)).Methods("GET")
+
+	// Live delivery. Browsers cannot set headers on these, so the token may
+	// come in the access_token query parameter.
+	router.HandleFunc("/api/notifications/stream", middleware.StreamAuthMiddleware(h.Stream)).Methods("GET")
+	router.HandleFunc("/api/notifications/ws", middleware.StreamAuthMiddleware(h.Socket)).Methods("GET")
+}
@@ -0,0 +1,192 @@
+package handlers
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "health-bar/shared/apperrors"
+    "health-bar/shared/models"
+    "health-bar/shared/utils"
+    "health-bar/services/notification/websocket"
+    "log"
+    "net/http"
+    "time"
+    "github.com/google/uuid"
+)
+
+// replayLimit bounds how many missed notifications a reconnecting client
+// is sent; it can page through the rest
+const replayLimit = 100
+
+// errLagging ends a stream whose client fell too far behind
+var errLagging = errors.New("client fell behind")
+
+// Stream pushes the current user's notifications as Server-Sent Events.
+// Each notification is a "notification" event whose id is the notification
+// ID, so a reconnecting EventSource resumes with Last-Event-ID. "unread"
+// events carry the unread count: one on connect and one after every change.
+func (h *NotificationHandler) Stream(w http.ResponseWriter, r *http.Request) {
+    userID := r.Header.Get("X-User-ID")
+    if userID == "" {
+        utils.SendError(w, http.StatusUnauthorized, "Unauthorized")
+        return
+    }
+
+    flusher, ok := w.(http.Flusher)
+    if !ok {
+        utils.SendErrorCode(w, apperrors.CodeInternal, "Streaming is not supported")
+        return
+    }
+
+    lastEventID := r.Header.Get("Last-Event-ID")
+    if lastEventID == "" {
+        lastEventID = r.URL.Query().Get("last_event_id")
+    }
+
+    w.Header().Set("Content-Type", "text/event-stream")
+    w.Header().Set("Cache-Control", "no-cache")
+    w.Header().Set("Connection", "keep-alive")
+    // Keeps nginx-style proxies from buffering the stream
+    w.Header().Set("X-Accel-Buffering", "no")
+    w.WriteHeader(http.StatusOK)
+    fmt.Fprint(w, "retry: 3000\n\n")
+    flusher.Flush()
+
+    send := func(ev Event) error {
+        data, err := json.Marshal(ev.Data)
+        if err != nil {
+            return err
+        }
+        if ev.ID != "" {
+            fmt.Fprintf(w, "id: %s\n", ev.ID)
+        }
+        if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Name, data); err != nil {
+            return err
+        }
+        flusher.Flush()
+        return nil
+    }
+    ping := func() error {
+        if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+            return err
+        }
+        flusher.Flush()
+        return nil
+    }
+
+    if err := h.stream(r.Context(), userID, lastEventID, send, ping); err != nil && err != errLagging {
+        log.Printf("Notification stream of user %s ended: %v", userID, err)
+    }
+}
+
+// Socket pushes the current user's notifications over a WebSocket. Every
+// message is a JSON Event, the same events Stream sends. A reconnecting
+// client resumes with the last_event_id query parameter. Notifications are
+// marked read over HTTP; anything the client sends is ignored.
+func (h *NotificationHandler) Socket(w http.ResponseWriter, r *http.Request) {
+    userID := r.Header.Get("X-User-ID")
+    if userID == "" {
+        utils.SendError(w, http.StatusUnauthorized, "Unauthorized")
+        return
+    }
+
+    conn, err := websocket.Upgrade(w, r)
+    if err != nil {
+        var handshakeErr *websocket.HandshakeError
+        if errors.As(err, &handshakeErr) {
+            utils.SendErrorCode(w, apperrors.CodeInvalidRequest, handshakeErr.Error())
+            return
+        }
+        log.Printf("WebSocket upgrade failed: %v", err)
+        return
+    }
+
+    // The request context outlives a hijacked connection, so the stream
+    // ends when reading from the client fails instead
+    ctx, cancel := context.WithCancel(r.Context())
+    defer cancel()
+    go func() {
+        defer cancel()
+        for {
+            if _, _, err := conn.Read(); err != nil {
+                return
+            }
+        }
+    }()
+
+    send := func(ev Event) error {
+        data, err := json.Marshal(ev)
+        if err != nil {
+            return err
+        }
+        return conn.WriteText(data)
+    }
+
+    err = h.stream(ctx, userID, r.URL.Query().Get("last_event_id"), send, conn.Ping)
+    switch {
+    case err == errLagging:
+        conn.Close(websocket.CloseTryAgainLater, "fell behind, reconnect")
+    case err != nil && ctx.Err() == nil:
+        log.Printf("Notification socket of user %s ended: %v", userID, err)
+        conn.Close(websocket.CloseGoingAway, "")
+    default:
+        conn.Close(websocket.CloseNormal, "")
+    }
+}
+
+// stream subscribes to the user's events, then sends the notifications
+// missed since lastEventID, the unread count, and every event until ctx is
+// done, sending fails or the client falls behind. ping is called when the
+// stream has been idle for a heartbeat.
+func (h *NotificationHandler) stream(ctx context.Context, userID, lastEventID string, send func(Event) error, ping func() error) error {
+    // Subscribing first means nothing published during the catch-up is
+    // lost; the client may see a notification twice and dedupes by ID
+    events, unsubscribe := h.hub.Subscribe(userID)
+    defer unsubscribe()
+
+    if _, err := uuid.Parse(lastEventID); err == nil {
+        missed, err := h.repo.GetNotificationsAfter(ctx, userID, lastEventID, replayLimit)
+        if err != nil {
+            return err
+        }
+        for i := range missed {
+            if err := send(notificationEvent(&missed[i])); err != nil {
+                return err
+            }
+        }
+    }
+
+    count, err := h.repo.CountUnread(ctx, userID)
+    if err != nil {
+        return err
+    }
+    if err := send(Event{Name: "unread", Data: UnreadCount{Unread: count}}); err != nil {
+        return err
+    }
+
+    heartbeat := time.NewTicker(h.heartbeat)
+    defer heartbeat.Stop()
+    for {
+        select {
+        case <-ctx.Done():
+            return nil
+        case ev, ok := <-events:
+            if !ok {
+                return errLagging
+            }
+            if err := send(ev); err != nil {
+                return err
+            }
+            heartbeat.Reset(h.heartbeat)
+        case <-heartbeat.C:
+            if err := ping(); err != nil {
+                return err
+            }
+        }
+    }
+}
+
+func notificationEvent(n *models.Notification) Event {
+    return Event{Name: "notification", ID: n.ID, Data: n}
+}
@@ -0,0 +1,247 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"health-bar/services/notification/websocket"
+	"health-bar/shared/notify"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serve runs handler as if AuthMiddleware had identified userID
+func serve(t *testing.T, handler http.HandlerFunc, userID string) *httptest.Server {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Set("X-User-ID", userID)
+		handler(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+type sseEvent struct {
+	id, name, data string
+}
+
+// readSSE reads the next event, skipping comments and the retry hint
+func readSSE(t *testing.T, br *bufio.Reader) sseEvent {
+	t.Helper()
+
+	var ev sseEvent
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			t.Fatalf("read stream: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "":
+			if ev.name != "" {
+				return ev
+			}
+		case strings.HasPrefix(line, "id: "):
+			ev.id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			ev.name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			ev.data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+func openStream(t *testing.T, srv *httptest.Server, lastEventID string) *bufio.Reader {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("stream response = %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	return bufio.NewReader(resp.Body)
+}
+
+func TestStream(t *testing.T) {
+	h, repo, db := newTestHandler()
+	h.heartbeat = 50 * time.Millisecond
+	doctor := db.AddDoctor("d@test.com", "Dr Who")
+	srv := serve(t, h.Stream, doctor.UserID)
+
+	first := send(t, repo, notify.AccessGranted(doctor.UserID, "p1", "Pat"))
+
+	stream := openStream(t, srv, "")
+	if ev := readSSE(t, stream); ev.name != "unread" || ev.data != `{"unread":1}` {
+		t.Fatalf("first event = %+v, want the unread count", ev)
+	}
+
+	// The stream is subscribed once the unread count arrives
+	second := send(t, repo, notify.UploadCreated(doctor.UserID, "p1", "Pat", "doc1", "scan.pdf"))
+	h.deliver(context.Background(), notify.Announcement{UserID: doctor.UserID, NotificationID: second.ID})
+	ev := readSSE(t, stream)
+	if ev.name != "notification" || ev.id != second.ID || !strings.Contains(ev.data, `"kind":"upload_created"`) {
+		t.Fatalf("pushed event = %+v", ev)
+	}
+	if ev := readSSE(t, stream); ev.name != "unread" || ev.data != `{"unread":2}` {
+		t.Fatalf("event after push = %+v, want the new unread count", ev)
+	}
+
+	// Idle streams are pinged
+	line, err := stream.ReadString('\n')
+	if err != nil || line != ": ping\n" {
+		t.Fatalf("idle stream sent %q, %v", line, err)
+	}
+
+	// A reconnecting client gets what it missed, then the unread count
+	resumed := openStream(t, srv, first.ID)
+	if ev := readSSE(t, resumed); ev.name != "notification" || ev.id != second.ID {
+		t.Fatalf("replayed event = %+v, want the missed notification", ev)
+	}
+	if ev := readSSE(t, resumed); ev.name != "unread" {
+		t.Fatalf("event after replay = %+v", ev)
+	}
+}
+
+func TestStreamRequiresIdentity(t *testing.T) {
+	h, _, _ := newTestHandler()
+	srv := serve(t, h.Stream, "")
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want 401", resp.StatusCode)
+	}
+}
+
+// dialSocket performs a WebSocket handshake against srv
+func dialSocket(t *testing.T, srv *httptest.Server) (net.Conn, *bufio.Reader) {
+	t.Helper()
+
+	addr := srv.Listener.Addr().String()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: "+addr+"\r\n"+
+		"Connection: Upgrade\r\nUpgrade: websocket\r\n"+
+		"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n")
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("handshake status = %d", resp.StatusCode)
+	}
+	return conn, br
+}
+
+// readMessage reads one unfragmented server frame
+func readMessage(t *testing.T, br *bufio.Reader) (byte, []byte) {
+	t.Helper()
+
+	var header [2]byte
+	if _, err := io.ReadFull(br, header[:]); err != nil {
+		t.Fatal(err)
+	}
+	length := int(header[1] & 0x7F)
+	if length == 126 {
+		var ext [2]byte
+		io.ReadFull(br, ext[:])
+		length = int(binary.BigEndian.Uint16(ext[:]))
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(br, payload); err != nil {
+		t.Fatal(err)
+	}
+	return header[0] & 0x0F, payload
+}
+
+// writeClose sends a masked close frame with a zero mask
+func writeClose(t *testing.T, conn net.Conn) {
+	t.Helper()
+
+	frame := []byte{0x80 | websocket.OpClose, 0x80 | 2, 0, 0, 0, 0}
+	frame = binary.BigEndian.AppendUint16(frame, websocket.CloseNormal)
+	if _, err := conn.Write(frame); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readEvent(t *testing.T, br *bufio.Reader) Event {
+	t.Helper()
+
+	op, payload := readMessage(t, br)
+	if op != websocket.OpText {
+		t.Fatalf("opcode = %x, want a text message", op)
+	}
+	var ev Event
+	if err := json.Unmarshal(payload, &ev); err != nil {
+		t.Fatalf("decode %s: %v", payload, err)
+	}
+	return ev
+}
+
+func TestSocket(t *testing.T) {
+	h, repo, db := newTestHandler()
+	doctor := db.AddDoctor("d@test.com", "Dr Who")
+	srv := serve(t, h.Socket, doctor.UserID)
+
+	conn, br := dialSocket(t, srv)
+	if ev := readEvent(t, br); ev.Name != "unread" {
+		t.Fatalf("first event = %+v, want the unread count", ev)
+	}
+
+	n := send(t, repo, notify.AccessGranted(doctor.UserID, "p1", "Pat"))
+	h.deliver(context.Background(), notify.Announcement{UserID: doctor.UserID, NotificationID: n.ID})
+	if ev := readEvent(t, br); ev.Name != "notification" || ev.ID != n.ID {
+		t.Fatalf("pushed event = %+v", ev)
+	}
+	if ev := readEvent(t, br); ev.Name != "unread" {
+		t.Fatalf("event after push = %+v", ev)
+	}
+
+	// Closing is answered with a close frame
+	writeClose(t, conn)
+	if op, _ := readMessage(t, br); op != websocket.OpClose {
+		t.Fatalf("opcode = %x, want the close answer", op)
+	}
+}
+
+func TestSocketRejectsPlainRequests(t *testing.T) {
+	h, _, db := newTestHandler()
+	doctor := db.AddDoctor("d@test.com", "Dr Who")
+	srv := serve(t, h.Socket, doctor.UserID)
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400", resp.StatusCode)
+	}
+}
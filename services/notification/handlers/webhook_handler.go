@@ -0,0 +1,321 @@
+package handlers
+
+import (
+    "context"
+    "database/sql"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "health-bar/shared/apperrors"
+    "health-bar/shared/models"
+    "health-bar/shared/pagination"
+    "health-bar/shared/utils"
+    "health-bar/shared/webhooks"
+    "health-bar/services/notification/repository"
+    "net/http"
+    "net/url"
+    "slices"
+    "sort"
+    "strings"
+    "github.com/google/uuid"
+)
+
+// maxSubscriptions bounds how many webhooks one user can subscribe
+const maxSubscriptions = 10
+
+// maxWebhookURLLen bounds the length of a webhook URL
+const maxWebhookURLLen = 2048
+
+type SubscriptionRequest struct {
+    URL        string   `json:"url"`
+    EventTypes []string `json:"event_types"`
+}
+
+// EventType describes one entry of the webhook event catalog
+type EventType struct {
+    Type        string `json:"type"`
+    Description string `json:"description"`
+}
+
+// CreateSubscription subscribes a URL of the current doctor to some event
+// types. The response holds the signing secret, which is not shown again.
+func (h *NotificationHandler) CreateSubscription(w http.ResponseWriter, r *http.Request) {
+    userID := r.Header.Get("X-User-ID")
+    userRole := r.Header.Get("X-User-Role")
+
+    if userID == "" {
+        utils.SendError(w, http.StatusUnauthorized, "Unauthorized")
+        return
+    }
+
+    if userRole != "doctor" {
+        utils.SendError(w, http.StatusForbidden, "Only doctors can subscribe webhooks")
+        return
+    }
+
+    var req SubscriptionRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        utils.SendError(w, http.StatusBadRequest, "Invalid request body")
+        return
+    }
+
+    endpoint := strings.TrimSpace(req.URL)
+    if msg := validateWebhookURL(endpoint); msg != "" {
+        utils.SendErrorCode(w, apperrors.CodeValidation, msg)
+        return
+    }
+    if msg := h.checkWebhookHost(r.Context(), endpoint); msg != "" {
+        utils.SendErrorCode(w, apperrors.CodeValidation, msg)
+        return
+    }
+    if len(req.EventTypes) == 0 {
+        utils.SendErrorCode(w, apperrors.CodeValidation, "At least one event type is required")
+        return
+    }
+    var eventTypes models.WebhookEventTypes
+    for _, eventType := range req.EventTypes {
+        if !webhooks.Known(eventType) {
+            utils.SendErrorCode(w, apperrors.CodeValidation, "Unknown event type: "+eventType)
+            return
+        }
+        if !slices.Contains(eventTypes, eventType) {
+            eventTypes = append(eventTypes, eventType)
+        }
+    }
+
+    subs, err := h.repo.GetWebhookSubscriptions(r.Context(), userID)
+    if err != nil {
+        utils.SendAppError(w, err, "Failed to retrieve webhook subscriptions")
+        return
+    }
+    if len(subs) >= maxSubscriptions {
+        utils.SendErrorCode(w, apperrors.CodeValidation, fmt.Sprintf("At most %d webhook subscriptions are allowed", maxSubscriptions))
+        return
+    }
+
+    secret, err := webhooks.NewSecret()
+    if err != nil {
+        utils.SendAppError(w, err, "Failed to create webhook subscription")
+        return
+    }
+    sub := &models.WebhookSubscription{
+        ID:         uuid.New().String(),
+        UserID:     userID,
+        URL:        endpoint,
+        Secret:     secret,
+        EventTypes: eventTypes,
+    }
+    if err := h.repo.CreateWebhookSubscription(r.Context(), sub); err != nil {
+        utils.SendAppError(w, err, "Failed to create webhook subscription")
+        return
+    }
+
+    utils.SendSuccess(w, http.StatusCreated, "Webhook subscription created", sub)
+}
+
+// validateWebhookURL returns why endpoint cannot receive webhooks, or ""
+func validateWebhookURL(endpoint string) string {
+    if endpoint == "" {
+        return "URL is required"
+    }
+    if len(endpoint) > maxWebhookURLLen {
+        return fmt.Sprintf("URL must be at most %d characters", maxWebhookURLLen)
+    }
+    u, err := url.Parse(endpoint)
+    if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+        return "URL must be an absolute http or https URL"
+    }
+    if u.User != nil {
+        return "URL must not contain credentials"
+    }
+    return ""
+}
+
+// checkWebhookHost returns why the host of endpoint cannot receive webhooks,
+// or "". Deliveries check the address they connect to again.
+func (h *NotificationHandler) checkWebhookHost(ctx context.Context, endpoint string) string {
+    u, _ := url.Parse(endpoint)
+    err := webhooks.CheckHost(ctx, h.resolver, u.Hostname(), h.allowAddr)
+    if errors.Is(err, webhooks.ErrInternalAddress) {
+        return "URL must point to a public address"
+    }
+    if err != nil {
+        return "URL host could not be resolved"
+    }
+    return ""
+}
+
+// GetSubscriptions lists the current user's webhook subscriptions, without
+// their secrets
+func (h *NotificationHandler) GetSubscriptions(w http.ResponseWriter, r *http.Request) {
+    userID := r.Header.Get("X-User-ID")
+    if userID == "" {
+        utils.SendError(w, http.StatusUnauthorized, "Unauthorized")
+        return
+    }
+
+    subs, err := h.repo.GetWebhookSubscriptions(r.Context(), userID)
+    if err != nil {
+        utils.SendAppError(w, err, "Failed to retrieve webhook subscriptions")
+        return
+    }
+    for i := range subs {
+        subs[i].Secret = ""
+    }
+
+    utils.SendSuccess(w, http.StatusOK, "Webhook subscriptions retrieved", subs)
+}
+
+// DeleteSubscription unsubscribes one of the current user's webhooks. Its
+// pending deliveries are dropped along with its delivery log.
+func (h *NotificationHandler) DeleteSubscription(w http.ResponseWriter, r *http.Request) {
+    userID := r.Header.Get("X-User-ID")
+    if userID == "" {
+        utils.SendError(w, http.StatusUnauthorized, "Unauthorized")
+        return
+    }
+
+    subscriptionID := r.URL.Query().Get("id")
+    if subscriptionID == "" {
+        utils.SendErrorCode(w, apperrors.CodeValidation, "Subscription ID is required")
+        return
+    }
+
+    if _, err := uuid.Parse(subscriptionID); err != nil {
+        utils.SendError(w, http.StatusNotFound, "Webhook subscription not found")
+        return
+    }
+
+    // Other people's subscriptions are reported as missing rather than forbidden
+    sub, err := h.repo.GetWebhookSubscriptionByID(r.Context(), subscriptionID)
+    if err != nil || sub.UserID != userID {
+        if err != nil && err != sql.ErrNoRows {
+            utils.SendAppError(w, err, "Failed to retrieve webhook subscription")
+            return
+        }
+        utils.SendError(w, http.StatusNotFound, "Webhook subscription not found")
+        return
+    }
+
+    if err := h.repo.DeleteWebhookSubscription(r.Context(), sub.ID); err != nil {
+        utils.SendAppError(w, err, "Failed to delete webhook subscription")
+        return
+    }
+
+    utils.SendSuccess(w, http.StatusOK, "Webhook subscription deleted", nil)
+}
+
+// GetDeliveries lists the deliveries of the current user's webhooks
+func (h *NotificationHandler) GetDeliveries(w http.ResponseWriter, r *http.Request) {
+    h.listDeliveries(w, r, "")
+}
+
+// GetDeadLetters lists the deliveries of the current user's webhooks that
+// failed every attempt
+func (h *NotificationHandler) GetDeadLetters(w http.ResponseWriter, r *http.Request) {
+    h.listDeliveries(w, r, models.DeliveryDead)
+}
+
+func (h *NotificationHandler) listDeliveries(w http.ResponseWriter, r *http.Request, status string) {
+    userID := r.Header.Get("X-User-ID")
+    if userID == "" {
+        utils.SendError(w, http.StatusUnauthorized, "Unauthorized")
+        return
+    }
+
+    page, err := pagination.Parse(r.URL.Query(), repository.DeliveryPages)
+    if err != nil {
+        utils.SendAppError(w, err, "Invalid pagination parameters")
+        return
+    }
+    if status != "" {
+        page.Filters["status"] = status
+    }
+    switch page.Filters["status"] {
+    case "", models.DeliveryPending, models.DeliverySucceeded, models.DeliveryDead:
+    default:
+        utils.SendErrorCode(w, apperrors.CodeValidation, "status must be pending, succeeded or dead")
+        return
+    }
+    if eventType, ok := page.Filters["event_type"]; ok && !webhooks.Known(eventType) {
+        utils.SendErrorCode(w, apperrors.CodeValidation, "Unknown event type: "+eventType)
+        return
+    }
+    if subscriptionID, ok := page.Filters["subscription_id"]; ok {
+        if _, err := uuid.Parse(subscriptionID); err != nil {
+            utils.SendErrorCode(w, apperrors.CodeValidation, "Invalid subscription ID: "+subscriptionID)
+            return
+        }
+    }
+
+    deliveries, next, err := h.repo.GetWebhookDeliveries(r.Context(), userID, page)
+    if err != nil {
+        utils.SendAppError(w, err, "Failed to retrieve webhook deliveries")
+        return
+    }
+
+    utils.SendPage(w, http.StatusOK, "Webhook deliveries retrieved", deliveries, next)
+}
+
+// ReplayDelivery sends the event of one of the current user's finished
+// deliveries again, as a new delivery due now
+func (h *NotificationHandler) ReplayDelivery(w http.ResponseWriter, r *http.Request) {
+    userID := r.Header.Get("X-User-ID")
+    if userID == "" {
+        utils.SendError(w, http.StatusUnauthorized, "Unauthorized")
+        return
+    }
+
+    deliveryID := r.URL.Query().Get("id")
+    if deliveryID == "" {
+        utils.SendErrorCode(w, apperrors.CodeValidation, "Delivery ID is required")
+        return
+    }
+    if _, err := uuid.Parse(deliveryID); err != nil {
+        utils.SendError(w, http.StatusNotFound, "Webhook delivery not found")
+        return
+    }
+
+    delivery, err := h.repo.GetWebhookDeliveryByID(r.Context(), deliveryID)
+    if err != nil {
+        if err != sql.ErrNoRows {
+            utils.SendAppError(w, err, "Failed to retrieve webhook delivery")
+            return
+        }
+        utils.SendError(w, http.StatusNotFound, "Webhook delivery not found")
+        return
+    }
+    // Other people's deliveries are reported as missing rather than forbidden
+    sub, err := h.repo.GetWebhookSubscriptionByID(r.Context(), delivery.SubscriptionID)
+    if err != nil || sub.UserID != userID {
+        if err != nil && err != sql.ErrNoRows {
+            utils.SendAppError(w, err, "Failed to retrieve webhook subscription")
+            return
+        }
+        utils.SendError(w, http.StatusNotFound, "Webhook delivery not found")
+        return
+    }
+    if delivery.Status == models.DeliveryPending {
+        utils.SendErrorCode(w, apperrors.CodeConflict, "Delivery is still pending")
+        return
+    }
+
+    replay, err := h.repo.ReplayWebhookDelivery(r.Context(), delivery.ID)
+    if err != nil {
+        utils.SendAppError(w, err, "Failed to replay webhook delivery")
+        return
+    }
+
+    utils.SendSuccess(w, http.StatusAccepted, "Webhook delivery replayed", replay)
+}
+
+// GetEventTypes lists the webhook event catalog
+func (h *NotificationHandler) GetEventTypes(w http.ResponseWriter, r *http.Request) {
+    eventTypes := make([]EventType, 0, len(webhooks.Catalog))
+    for eventType, description := range webhooks.Catalog {
+        eventTypes = append(eventTypes, EventType{Type: eventType, Description: description})
+    }
+    sort.Slice(eventTypes, func(i, j int) bool { return eventTypes[i].Type < eventTypes[j].Type })
+
+    utils.SendSuccess(w, http.StatusOK, "Webhook event types retrieved", eventTypes)
+}
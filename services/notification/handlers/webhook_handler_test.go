@@ -0,0 +1,228 @@
+package handlers
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/netip"
+	"testing"
+
+	"health-bar/shared/apperrors"
+	"health-bar/shared/models"
+	"health-bar/shared/testutil"
+	"health-bar/shared/utils"
+	"health-bar/shared/webhooks"
+)
+
+// hosts resolves names from a fixed table.
+type hosts map[string][]netip.Addr
+
+func (h hosts) LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error) {
+	if addrs, ok := h[host]; ok {
+		return addrs, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+func TestCreateSubscription(t *testing.T) {
+	h, _, db := newTestHandler()
+	h.UseResolver(hosts{
+		"clinic.test":          {netip.MustParseAddr("203.0.113.10")},
+		"metadata.clinic.test": {netip.MustParseAddr("169.254.169.254")},
+		"dual.clinic.test":     {netip.MustParseAddr("203.0.113.11"), netip.MustParseAddr("10.0.0.8")},
+	})
+	doctor := db.AddDoctor("d@test.com", "Dr Who")
+	patient := db.AddPatient("p@test.com", "Pat")
+
+	valid := SubscriptionRequest{URL: "https://clinic.test/hooks", EventTypes: []string{webhooks.EventVisitCreated, webhooks.EventVisitCreated}}
+	tests := []struct {
+		name   string
+		userID string
+		role   string
+		body   SubscriptionRequest
+		status int
+		code   apperrors.Code
+	}{
+		{"patient", patient.UserID, "patient", valid, http.StatusForbidden, apperrors.CodeForbidden},
+		{"no URL", doctor.UserID, "doctor", SubscriptionRequest{EventTypes: valid.EventTypes}, http.StatusBadRequest, apperrors.CodeValidation},
+		{"relative URL", doctor.UserID, "doctor", SubscriptionRequest{URL: "/hooks", EventTypes: valid.EventTypes}, http.StatusBadRequest, apperrors.CodeValidation},
+		{"other scheme", doctor.UserID, "doctor", SubscriptionRequest{URL: "ftp://clinic.test/hooks", EventTypes: valid.EventTypes}, http.StatusBadRequest, apperrors.CodeValidation},
+		{"credentials", doctor.UserID, "doctor", SubscriptionRequest{URL: "https://a:b@clinic.test/hooks", EventTypes: valid.EventTypes}, http.StatusBadRequest, apperrors.CodeValidation},
+		{"unresolvable host", doctor.UserID, "doctor", SubscriptionRequest{URL: "https://nowhere.test/hooks", EventTypes: valid.EventTypes}, http.StatusBadRequest, apperrors.CodeValidation},
+		{"service name", doctor.UserID, "doctor", SubscriptionRequest{URL: "http://healthbar-patient-service:8002/api/patients", EventTypes: valid.EventTypes}, http.StatusBadRequest, apperrors.CodeValidation},
+		{"localhost", doctor.UserID, "doctor", SubscriptionRequest{URL: "http://localhost/hooks", EventTypes: valid.EventTypes}, http.StatusBadRequest, apperrors.CodeValidation},
+		{"loopback address", doctor.UserID, "doctor", SubscriptionRequest{URL: "http://127.0.0.1:8002/hooks", EventTypes: valid.EventTypes}, http.StatusBadRequest, apperrors.CodeValidation},
+		{"IPv6 loopback", doctor.UserID, "doctor", SubscriptionRequest{URL: "http://[::1]/hooks", EventTypes: valid.EventTypes}, http.StatusBadRequest, apperrors.CodeValidation},
+		{"metadata address", doctor.UserID, "doctor", SubscriptionRequest{URL: "http://169.254.169.254/latest/meta-data", EventTypes: valid.EventTypes}, http.StatusBadRequest, apperrors.CodeValidation},
+		{"mapped private address", doctor.UserID, "doctor", SubscriptionRequest{URL: "http://[::ffff:192.168.1.1]/hooks", EventTypes: valid.EventTypes}, http.StatusBadRequest, apperrors.CodeValidation},
+		{"name of a link-local address", doctor.UserID, "doctor", SubscriptionRequest{URL: "https://metadata.clinic.test/hooks", EventTypes: valid.EventTypes}, http.StatusBadRequest, apperrors.CodeValidation},
+		{"name with one private address", doctor.UserID, "doctor", SubscriptionRequest{URL: "https://dual.clinic.test/hooks", EventTypes: valid.EventTypes}, http.StatusBadRequest, apperrors.CodeValidation},
+		{"no event types", doctor.UserID, "doctor", SubscriptionRequest{URL: valid.URL}, http.StatusBadRequest, apperrors.CodeValidation},
+		{"unknown event type", doctor.UserID, "doctor", SubscriptionRequest{URL: valid.URL, EventTypes: []string{"visit.exploded"}}, http.StatusBadRequest, apperrors.CodeValidation},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := testutil.NewRequest(t, http.MethodPost, "/api/webhooks", tt.body, tt.userID, tt.role)
+			rec, resp := testutil.Serve(t, h.CreateSubscription, req)
+			testutil.ExpectStatus(t, rec, tt.status)
+			testutil.ExpectCode(t, resp, tt.code)
+		})
+	}
+
+	req := testutil.NewRequest(t, http.MethodPost, "/api/webhooks", valid, doctor.UserID, "doctor")
+	rec, resp := testutil.Serve(t, h.CreateSubscription, req)
+	testutil.ExpectStatus(t, rec, http.StatusCreated)
+	var sub models.WebhookSubscription
+	testutil.DecodeData(t, resp, &sub)
+	if sub.Secret == "" || sub.UserID != doctor.UserID || len(sub.EventTypes) != 1 {
+		t.Fatalf("subscription = %+v", sub)
+	}
+
+	// The secret is only shown once
+	req = testutil.NewRequest(t, http.MethodGet, "/api/webhooks", nil, doctor.UserID, "doctor")
+	rec, resp = testutil.Serve(t, h.GetSubscriptions, req)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+	var subs []models.WebhookSubscription
+	testutil.DecodeData(t, resp, &subs)
+	if len(subs) != 1 || subs[0].ID != sub.ID || subs[0].Secret != "" {
+		t.Fatalf("subscriptions = %+v", subs)
+	}
+
+	for i := 1; i < maxSubscriptions; i++ {
+		db.AddWebhookSubscription(doctor.UserID, valid.URL, webhooks.EventVisitCreated)
+	}
+	req = testutil.NewRequest(t, http.MethodPost, "/api/webhooks", valid, doctor.UserID, "doctor")
+	rec, resp = testutil.Serve(t, h.CreateSubscription, req)
+	testutil.ExpectStatus(t, rec, http.StatusBadRequest)
+	testutil.ExpectCode(t, resp, apperrors.CodeValidation)
+}
+
+func TestDeleteSubscription(t *testing.T) {
+	h, _, db := newTestHandler()
+	doctor := db.AddDoctor("d@test.com", "Dr Who")
+	other := db.AddDoctor("o@test.com", "Dr No")
+	sub := db.AddWebhookSubscription(doctor.UserID, "https://clinic.test/hooks", webhooks.EventVisitCreated)
+	err := webhooks.NewMemoryEmitter(db).Emit(context.Background(), webhooks.VisitCreated(doctor.UserID, "p1", "v1", "General", db.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []string{"", "not-a-uuid", other.UserID} {
+		req := testutil.NewRequest(t, http.MethodDelete, "/api/webhooks/subscription?id="+id, nil, doctor.UserID, "doctor")
+		rec, _ := testutil.Serve(t, h.DeleteSubscription, req)
+		if rec.Code != http.StatusBadRequest && rec.Code != http.StatusNotFound {
+			t.Fatalf("delete %q = %d", id, rec.Code)
+		}
+	}
+
+	// Other people's subscriptions look missing
+	req := testutil.NewRequest(t, http.MethodDelete, "/api/webhooks/subscription?id="+sub.ID, nil, other.UserID, "doctor")
+	rec, _ := testutil.Serve(t, h.DeleteSubscription, req)
+	testutil.ExpectStatus(t, rec, http.StatusNotFound)
+
+	req = testutil.NewRequest(t, http.MethodDelete, "/api/webhooks/subscription?id="+sub.ID, nil, doctor.UserID, "doctor")
+	rec, _ = testutil.Serve(t, h.DeleteSubscription, req)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+	if len(db.WebhookSubscriptions.Rows) != 0 || len(db.WebhookDeliveries.Rows) != 0 {
+		t.Fatalf("left %d subscriptions and %d deliveries", len(db.WebhookSubscriptions.Rows), len(db.WebhookDeliveries.Rows))
+	}
+}
+
+func TestDeliveryLogAndReplay(t *testing.T) {
+	h, _, db := newTestHandler()
+	doctor := db.AddDoctor("d@test.com", "Dr Who")
+	other := db.AddDoctor("o@test.com", "Dr No")
+	sub := db.AddWebhookSubscription(doctor.UserID, "https://clinic.test/hooks", webhooks.EventVisitCreated, webhooks.EventAccessGranted)
+	db.AddWebhookSubscription(other.UserID, "https://other.test/hooks", webhooks.EventVisitCreated)
+
+	emitter := webhooks.NewMemoryEmitter(db)
+	ctx := context.Background()
+	if err := emitter.Emit(ctx,
+		webhooks.AccessGranted(doctor.UserID, "p1", "Pat"),
+		webhooks.VisitCreated(doctor.UserID, "p1", "v1", "General", db.Now()),
+		webhooks.VisitCreated(other.UserID, "p2", "v2", "General", db.Now()),
+	); err != nil {
+		t.Fatal(err)
+	}
+	var dead, pending models.WebhookDelivery
+	db.Lock()
+	for id, d := range db.WebhookDeliveries.Rows {
+		switch {
+		case d.SubscriptionID == sub.ID && d.EventType == webhooks.EventAccessGranted:
+			d.Status, d.Attempts = models.DeliveryDead, 8
+			db.WebhookDeliveries.Rows[id] = d
+			dead = d
+		case d.SubscriptionID == sub.ID:
+			pending = d
+		}
+	}
+	db.Unlock()
+
+	list := func(path, query string) []models.WebhookDelivery {
+		t.Helper()
+
+		req := testutil.NewRequest(t, http.MethodGet, path+query, nil, doctor.UserID, "doctor")
+		handler := h.GetDeliveries
+		if path == "/api/webhooks/dead-letters" {
+			handler = h.GetDeadLetters
+		}
+		rec, resp := testutil.Serve(t, handler, req)
+		testutil.ExpectStatus(t, rec, http.StatusOK)
+		var deliveries []models.WebhookDelivery
+		testutil.DecodeData(t, resp, &deliveries)
+		return deliveries
+	}
+
+	if got := list("/api/webhooks/deliveries", ""); len(got) != 2 {
+		t.Fatalf("deliveries = %+v", got)
+	}
+	if got := list("/api/webhooks/deliveries", "?event_type="+webhooks.EventVisitCreated); len(got) != 1 || got[0].ID != pending.ID {
+		t.Fatalf("visit deliveries = %+v", got)
+	}
+	if got := list("/api/webhooks/dead-letters", ""); len(got) != 1 || got[0].ID != dead.ID {
+		t.Fatalf("dead letters = %+v", got)
+	}
+	for _, query := range []string{"?status=lost", "?event_type=visit.exploded", "?subscription_id=nope"} {
+		req := testutil.NewRequest(t, http.MethodGet, "/api/webhooks/deliveries"+query, nil, doctor.UserID, "doctor")
+		rec, resp := testutil.Serve(t, h.GetDeliveries, req)
+		testutil.ExpectStatus(t, rec, http.StatusBadRequest)
+		testutil.ExpectCode(t, resp, apperrors.CodeValidation)
+	}
+
+	replay := func(deliveryID, userID string) (int, utils.Response) {
+		req := testutil.NewRequest(t, http.MethodPost, "/api/webhooks/deliveries/replay?id="+deliveryID, nil, userID, "doctor")
+		rec, resp := testutil.Serve(t, h.ReplayDelivery, req)
+		return rec.Code, resp
+	}
+
+	if status, _ := replay(dead.ID, other.UserID); status != http.StatusNotFound {
+		t.Fatalf("replay of someone else's delivery = %d", status)
+	}
+	if status, resp := replay(pending.ID, doctor.UserID); status != http.StatusConflict || resp.Code != apperrors.CodeConflict {
+		t.Fatalf("replay of a pending delivery = %d", status)
+	}
+	status, resp := replay(dead.ID, doctor.UserID)
+	if status != http.StatusAccepted {
+		t.Fatalf("replay = %d", status)
+	}
+	var replayed models.WebhookDelivery
+	testutil.DecodeData(t, resp, &replayed)
+	if replayed.Status != models.DeliveryPending || replayed.EventID != dead.EventID || replayed.ReplayOf == nil || *replayed.ReplayOf != dead.ID {
+		t.Fatalf("replayed = %+v", replayed)
+	}
+}
+
+func TestGetEventTypes(t *testing.T) {
+	h, _, db := newTestHandler()
+	doctor := db.AddDoctor("d@test.com", "Dr Who")
+
+	req := testutil.NewRequest(t, http.MethodGet, "/api/webhooks/events", nil, doctor.UserID, "doctor")
+	rec, resp := testutil.Serve(t, h.GetEventTypes, req)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+
+	var eventTypes []EventType
+	testutil.DecodeData(t, resp, &eventTypes)
+	if len(eventTypes) != len(webhooks.Catalog) || eventTypes[0].Type != webhooks.EventAccessGranted {
+		t.Fatalf("event types = %+v", eventTypes)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+    "context"
+    "health-bar/shared/database"
+    "health-bar/shared/events"
+    "health-bar/services/notification/delivery"
+    "health-bar/services/notification/handlers"
+    "health-bar/services/notification/repository"
+    "log"
+    "net/http"
+    "os"
+    "time"
+    "github.com/gorilla/mux"
+    "github.com/joho/godotenv"
+    "github.com/rs/cors"
+)
+
+func main() {
+    godotenv.Load()
+
+    db, err := database.Connect(database.Config{
+        Host:     getEnv("DB_HOST", "localhost"),
+        Port:     getEnv("DB_PORT", "5432"),
+        User:     getEnv("DB_USER", "postgres"),
+        Password: getEnv("DB_PASSWORD", "postgres"),
+        DBName:   getEnv("DB_NAME", "healthbar"),
+        SSLMode:  getEnv("DB_SSLMODE", "disable"),
+    })
+    if err != nil {
+        log.Fatal("Failed to connect to database:", err)
+    }
+    defer db.Close()
+
+    repo := repository.NewNotificationRepository(db)
+    handler := handlers.NewNotificationHandler(repo)
+
+    // Other services store notifications and announce them with pg_notify;
+    // the relay pushes them to the clients connected here
+    go handler.Relay(context.Background(), 5*time.Second)
+
+    // Webhook deliveries are stored by the services that emit events; the
+    // dispatcher sends them. WEBHOOK_DISPATCH_INTERVAL=0 turns sending off.
+    interval, err := time.ParseDuration(getEnv("WEBHOOK_DISPATCH_INTERVAL", "5s"))
+    if err != nil {
+        log.Fatal("Invalid WEBHOOK_DISPATCH_INTERVAL:", err)
+    }
+    if interval > 0 {
+        go delivery.NewDispatcher(repo).RunEvery(context.Background(), interval)
+    }
+
+    // Deliveries about deleted patients, or to doctors who lost access, are
+    // purged when the patient service's events arrive. Events wait in the
+    // service's inbox until handled; NATS only announces them.
+    bus, err := events.DialNATS(getEnv("NATS_URL", "nats://healthbar-nats:4222"), "notification-service")
+    if err != nil {
+        log.Fatal(err)
+    }
+    defer bus.Close()
+    consumer := events.NewConsumer("notification-service", events.NewPostgresInbox(db), bus)
+    handler.Subscribe(consumer)
+    if err := consumer.Start(context.Background()); err != nil {
+        log.Fatal("Failed to subscribe to events:", err)
+    }
+    go consumer.RunEvery(context.Background(), 30*time.Second)
+
+    router := mux.NewRouter()
+    handlers.RegisterRoutes(router, handler)
+
+    c := cors.New(cors.Options{
+        AllowedOrigins:   []string{"http://localhost:3000"},
+        AllowedMethods:   []string{"GET", "POST", "DELETE", "OPTIONS"},
+        AllowedHeaders:   []string{"Content-Type", "Authorization", "Last-Event-ID"},
+        AllowCredentials: true,
+    })
+
+    port := getEnv("PORT", "8006")
+    log.Printf("Notification service starting on port %s", port)
+    log.Fatal(http.ListenAndServe(":"+port, c.Handler(router)))
+}
+
+func getEnv(key, defaultValue string) string {
+    if value := os.Getenv(key); value != "" {
+        return value
+    }
+    return defaultValue
+}
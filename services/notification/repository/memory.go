@@ -0,0 +1,172 @@
+package repository
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"slices"
+	"sync"
+
+	"health-bar/shared/memdb"
+	"health-bar/shared/models"
+	"health-bar/shared/notify"
+	"health-bar/shared/pagination"
+
+	"github.com/google/uuid"
+)
+
+// MemoryRepository implements Store on top of memdb. It is also a
+// notify.Notifier, standing in for the services that send notifications,
+// and announces to Listen what it stores and marks read.
+type MemoryRepository struct {
+	db *memdb.DB
+
+	mu        sync.Mutex
+	listeners map[int]func(notify.Announcement)
+	nextID    int
+}
+
+func NewMemoryRepository(db *memdb.DB) *MemoryRepository {
+	return &MemoryRepository{db: db, listeners: map[int]func(notify.Announcement){}}
+}
+
+func (r *MemoryRepository) Notify(ctx context.Context, msg notify.Message) error {
+	r.db.Lock()
+	if _, ok := r.db.Users.Rows[msg.UserID]; !ok {
+		r.db.Unlock()
+		return memdb.ForeignKeyViolation("notifications", "notifications_user_id_fkey")
+	}
+	n := models.Notification{
+		ID:        uuid.New().String(),
+		UserID:    msg.UserID,
+		Kind:      msg.Kind,
+		Subject:   msg.Subject,
+		Body:      msg.Body,
+		Data:      models.NotificationData(msg.Data),
+		CreatedAt: r.db.Now(),
+	}
+	if n.Data == nil {
+		n.Data = models.NotificationData{}
+	}
+	r.db.Notifications.Rows[n.ID] = n
+	r.db.Unlock()
+
+	r.announce(notify.Announcement{UserID: n.UserID, NotificationID: n.ID})
+	return nil
+}
+
+func (r *MemoryRepository) GetNotifications(ctx context.Context, userID string, page NotificationPage) ([]models.Notification, string, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	kind, byKind := page.Filters["kind"]
+	var notifications []models.Notification
+	for _, n := range r.db.Notifications.Rows {
+		if n.UserID != userID || !page.InRange(n.CreatedAt) || (byKind && n.Kind != kind) {
+			continue
+		}
+		if unread, ok := page.Filters["unread"]; ok && (unread == "true") != (n.ReadAt == nil) {
+			continue
+		}
+		notifications = append(notifications, n)
+	}
+	notifications, next := pagination.Apply(notifications, page)
+	return notifications, next, nil
+}
+
+func (r *MemoryRepository) GetNotificationByID(ctx context.Context, notificationID string) (*models.Notification, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	n, ok := r.db.Notifications.Rows[notificationID]
+	if !ok {
+		return &models.Notification{}, sql.ErrNoRows
+	}
+	return &n, nil
+}
+
+func (r *MemoryRepository) GetNotificationsAfter(ctx context.Context, userID, notificationID string, limit int) ([]models.Notification, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	last, ok := r.db.Notifications.Rows[notificationID]
+	if !ok || last.UserID != userID {
+		return nil, nil
+	}
+	var notifications []models.Notification
+	for _, n := range r.db.Notifications.Rows {
+		if n.UserID == userID && compareNotifications(n, last) > 0 {
+			notifications = append(notifications, n)
+		}
+	}
+	slices.SortFunc(notifications, compareNotifications)
+	if len(notifications) > limit {
+		notifications = notifications[:limit]
+	}
+	return notifications, nil
+}
+
+func compareNotifications(a, b models.Notification) int {
+	return cmp.Or(a.CreatedAt.Compare(b.CreatedAt), cmp.Compare(a.ID, b.ID))
+}
+
+func (r *MemoryRepository) CountUnread(ctx context.Context, userID string) (int, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	count := 0
+	for _, n := range r.db.Notifications.Rows {
+		if n.UserID == userID && n.ReadAt == nil {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (r *MemoryRepository) MarkRead(ctx context.Context, userID string, ids []string) (int64, error) {
+	r.db.Lock()
+	now := r.db.Now()
+	var marked int64
+	for id, n := range r.db.Notifications.Rows {
+		if n.UserID != userID || n.ReadAt != nil || (ids != nil && !slices.Contains(ids, id)) {
+			continue
+		}
+		n.ReadAt = &now
+		r.db.Notifications.Rows[id] = n
+		marked++
+	}
+	r.db.Unlock()
+
+	if marked > 0 {
+		r.announce(notify.Announcement{UserID: userID})
+	}
+	return marked, nil
+}
+
+func (r *MemoryRepository) Listen(ctx context.Context, fn func(notify.Announcement)) error {
+	r.mu.Lock()
+	id := r.nextID
+	r.nextID++
+	r.listeners[id] = fn
+	r.mu.Unlock()
+
+	<-ctx.Done()
+
+	r.mu.Lock()
+	delete(r.listeners, id)
+	r.mu.Unlock()
+	return ctx.Err()
+}
+
+func (r *MemoryRepository) announce(a notify.Announcement) {
+	r.mu.Lock()
+	listeners := make([]func(notify.Announcement), 0, len(r.listeners))
+	for _, fn := range r.listeners {
+		listeners = append(listeners, fn)
+	}
+	r.mu.Unlock()
+
+	for _, fn := range listeners {
+		fn(a)
+	}
+}
@@ -0,0 +1,157 @@
+package repository
+
+import (
+    "context"
+    "encoding/json"
+    "health-bar/shared/database"
+    "health-bar/shared/models"
+    "health-bar/shared/notify"
+    "health-bar/shared/pagination"
+    "log"
+    "github.com/jackc/pgx/v5/stdlib"
+    "github.com/jmoiron/sqlx"
+)
+
+const notificationColumns = `id, user_id, kind, subject, body, data, read_at, created_at`
+
+type NotificationRepository struct {
+    db *sqlx.DB
+}
+
+func NewNotificationRepository(db *sqlx.DB) *NotificationRepository {
+    return &NotificationRepository{db: db}
+}
+
+// GetNotifications gets a page of a user's notifications and the cursor of the next page
+func (r *NotificationRepository) GetNotifications(ctx context.Context, userID string, page NotificationPage) ([]models.Notification, string, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    q := &pagination.Query{}
+    q.Where("user_id = " + q.Arg(userID))
+    if kind, ok := page.Filters["kind"]; ok {
+        q.Where("kind = " + q.Arg(kind))
+    }
+    switch page.Filters["unread"] {
+    case "true":
+        q.Where("read_at IS NULL")
+    case "false":
+        q.Where("read_at IS NOT NULL")
+    }
+    if !page.From.IsZero() {
+        q.Where("created_at >= " + q.Arg(pagination.Timestamp(page.From)) + "::timestamp")
+    }
+    if !page.To.IsZero() {
+        q.Where("created_at <= " + q.Arg(pagination.Timestamp(page.ToEnd())) + "::timestamp")
+    }
+
+    var notifications []models.Notification
+    query := `SELECT ` + notificationColumns + ` FROM notifications` + page.Clauses(q)
+    if err := database.Conn(ctx, r.db).SelectContext(ctx, &notifications, query, q.Args()...); err != nil {
+        return nil, "", err
+    }
+    notifications, next := pagination.Page(notifications, page)
+    return notifications, next, nil
+}
+
+// GetNotificationByID gets a notification by ID
+func (r *NotificationRepository) GetNotificationByID(ctx context.Context, notificationID string) (*models.Notification, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    notification := &models.Notification{}
+    query := `SELECT ` + notificationColumns + ` FROM notifications WHERE id = $1`
+    err := database.Conn(ctx, r.db).GetContext(ctx, notification, query, notificationID)
+    return notification, err
+}
+
+// GetNotificationsAfter gets the notifications a client missed since the last one it saw
+func (r *NotificationRepository) GetNotificationsAfter(ctx context.Context, userID, notificationID string, limit int) ([]models.Notification, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    // The row comparison is NULL, matching nothing, when the notification
+    // is not the user's
+    var notifications []models.Notification
+    query := `
+        SELECT ` + notificationColumns + ` FROM notifications
+        WHERE user_id = $1
+          AND (created_at, id) > (SELECT created_at, id FROM notifications WHERE id = $2 AND user_id = $1)
+        ORDER BY created_at, id
+        LIMIT $3
+    `
+    err := database.Conn(ctx, r.db).SelectContext(ctx, &notifications, query, userID, notificationID, limit)
+    return notifications, err
+}
+
+// CountUnread counts a user's unread notifications
+func (r *NotificationRepository) CountUnread(ctx context.Context, userID string) (int, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    var count int
+    query := `SELECT COUNT(*) FROM notifications WHERE user_id = $1 AND read_at IS NULL`
+    err := database.Conn(ctx, r.db).GetContext(ctx, &count, query, userID)
+    return count, err
+}
+
+// MarkRead marks a user's unread notifications read and announces the
+// change so the user's other connections update their counts
+func (r *NotificationRepository) MarkRead(ctx context.Context, userID string, ids []string) (int64, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    query := `UPDATE notifications SET read_at = NOW() WHERE user_id = $1 AND read_at IS NULL`
+    args := []interface{}{userID}
+    if ids != nil {
+        query += ` AND id = ANY($2::uuid[])`
+        args = append(args, ids)
+    }
+
+    conn := database.Conn(ctx, r.db)
+    result, err := conn.ExecContext(ctx, query, args...)
+    if err != nil {
+        return 0, err
+    }
+    marked, err := result.RowsAffected()
+    if err != nil || marked == 0 {
+        return marked, err
+    }
+
+    announcement := notify.Announcement{UserID: userID}
+    _, err = conn.ExecContext(ctx, `SELECT pg_notify($1, $2)`, notify.Channel, announcement.Payload())
+    return marked, err
+}
+
+// Listen holds a connection listening on the notifications channel. It
+// runs until ctx is done, so it has no query timeout.
+func (r *NotificationRepository) Listen(ctx context.Context, fn func(notify.Announcement)) error {
+    conn, err := r.db.Conn(ctx)
+    if err != nil {
+        return err
+    }
+    defer conn.Close()
+
+    return conn.Raw(func(driverConn interface{}) error {
+        pgConn := driverConn.(*stdlib.Conn).Conn()
+        if _, err := pgConn.Exec(ctx, `LISTEN `+notify.Channel); err != nil {
+            return err
+        }
+        // A connection whose wait was cancelled is closed by pgx and
+        // dropped from the pool; otherwise stop listening before it goes back
+        defer pgConn.Exec(context.Background(), `UNLISTEN `+notify.Channel)
+
+        for {
+            n, err := pgConn.WaitForNotification(ctx)
+            if err != nil {
+                return err
+            }
+            var announcement notify.Announcement
+            if err := json.Unmarshal([]byte(n.Payload), &announcement); err != nil {
+                log.Printf("Ignoring malformed announcement %q: %v", n.Payload, err)
+                continue
+            }
+            fn(announcement)
+        }
+    })
+}
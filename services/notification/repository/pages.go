@@ -0,0 +1,41 @@
+package repository
+
+import (
+	"health-bar/shared/models"
+	"health-bar/shared/pagination"
+)
+
+// NotificationPage is a page request for a user's notifications.
+type NotificationPage = pagination.Params[models.Notification]
+
+// NotificationPages lists notifications newest first by default. from and
+// to bound the date created; kind matches one kind and unread=true or false
+// picks unread or read notifications.
+var NotificationPages = &pagination.Spec[models.Notification]{
+	Sorts: []pagination.Sort[models.Notification]{
+		{Name: "created_at", Column: "created_at", Cast: "timestamp",
+			Value: func(n models.Notification) string { return pagination.Timestamp(n.CreatedAt) }},
+	},
+	Default:   "-created_at",
+	Filters:   []string{"kind", "unread"},
+	DateRange: true,
+	ID:        func(n models.Notification) string { return n.ID },
+}
+
+// DeliveryPage is a page request for the deliveries of a user's webhooks.
+type DeliveryPage = pagination.Params[models.WebhookDelivery]
+
+// DeliveryPages lists webhook deliveries newest first by default. from and
+// to bound the date created; subscription_id, status and event_type each
+// match one value.
+var DeliveryPages = &pagination.Spec[models.WebhookDelivery]{
+	Sorts: []pagination.Sort[models.WebhookDelivery]{
+		{Name: "created_at", Column: "d.created_at", Cast: "timestamp",
+			Value: func(d models.WebhookDelivery) string { return pagination.Timestamp(d.CreatedAt) }},
+	},
+	Default:   "-created_at",
+	Filters:   []string{"subscription_id", "status", "event_type"},
+	DateRange: true,
+	ID:        func(d models.WebhookDelivery) string { return d.ID },
+	IDColumn:  "d.id",
+}
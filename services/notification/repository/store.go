@@ -0,0 +1,63 @@
+package repository
+
+import (
+	"context"
+	"health-bar/shared/models"
+	"health-bar/shared/notify"
+	"time"
+)
+
+// Store is what NotificationHandler needs from persistence.
+// NotificationRepository is the Postgres implementation and MemoryRepository
+// the in-memory one.
+type Store interface {
+	GetNotifications(ctx context.Context, userID string, page NotificationPage) ([]models.Notification, string, error)
+	GetNotificationByID(ctx context.Context, notificationID string) (*models.Notification, error)
+	// GetNotificationsAfter gets, oldest first, up to limit of the user's
+	// notifications created after the one with notificationID. It gets none
+	// when that notification is not the user's.
+	GetNotificationsAfter(ctx context.Context, userID, notificationID string, limit int) ([]models.Notification, error)
+	CountUnread(ctx context.Context, userID string) (int, error)
+	// MarkRead marks the user's unread notifications with the given IDs
+	// read, or all of them when ids is nil, and returns how many it marked
+	MarkRead(ctx context.Context, userID string, ids []string) (int64, error)
+	// Listen calls fn for every announcement until ctx is done or the
+	// connection fails
+	Listen(ctx context.Context, fn func(notify.Announcement)) error
+
+	// Webhooks
+	CreateWebhookSubscription(ctx context.Context, sub *models.WebhookSubscription) error
+	GetWebhookSubscriptions(ctx context.Context, userID string) ([]models.WebhookSubscription, error)
+	GetWebhookSubscriptionByID(ctx context.Context, subscriptionID string) (*models.WebhookSubscription, error)
+	DeleteWebhookSubscription(ctx context.Context, subscriptionID string) error
+	GetWebhookDeliveries(ctx context.Context, userID string, page DeliveryPage) ([]models.WebhookDelivery, string, error)
+	GetWebhookDeliveryByID(ctx context.Context, deliveryID string) (*models.WebhookDelivery, error)
+	ReplayWebhookDelivery(ctx context.Context, deliveryID string) (*models.WebhookDelivery, error)
+	ClaimWebhookDeliveries(ctx context.Context, lease time.Duration, limit int) ([]DueDelivery, error)
+	RecordWebhookAttempt(ctx context.Context, attempt WebhookAttempt) error
+	// PurgePatientDeliveries deletes deliveries about a patient, to one user
+	// or, with an empty userID, to anyone
+	PurgePatientDeliveries(ctx context.Context, patientID, userID string) (int64, error)
+}
+
+// DueDelivery is a claimed delivery with where to send it.
+type DueDelivery struct {
+	models.WebhookDelivery
+	URL    string `db:"url"`
+	Secret string `db:"secret"`
+}
+
+// WebhookAttempt is the outcome of sending a delivery. Status is the
+// delivery's new status; a pending delivery is tried again after RetryIn.
+type WebhookAttempt struct {
+	DeliveryID string
+	Status     string
+	RetryIn    time.Duration
+	StatusCode *int
+	Error      string
+}
+
+var (
+	_ Store = (*NotificationRepository)(nil)
+	_ Store = (*MemoryRepository)(nil)
+)
@@ -0,0 +1,190 @@
+package repository
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"slices"
+	"time"
+
+	"health-bar/shared/memdb"
+	"health-bar/shared/models"
+	"health-bar/shared/pagination"
+
+	"github.com/google/uuid"
+)
+
+func (r *MemoryRepository) CreateWebhookSubscription(ctx context.Context, sub *models.WebhookSubscription) error {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	if _, ok := r.db.Users.Rows[sub.UserID]; !ok {
+		return memdb.ForeignKeyViolation("webhook_subscriptions", "webhook_subscriptions_user_id_fkey")
+	}
+	sub.CreatedAt = r.db.Now()
+	r.db.WebhookSubscriptions.Rows[sub.ID] = *sub
+	return nil
+}
+
+func (r *MemoryRepository) GetWebhookSubscriptions(ctx context.Context, userID string) ([]models.WebhookSubscription, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	subs := []models.WebhookSubscription{}
+	for _, sub := range r.db.WebhookSubscriptions.Rows {
+		if sub.UserID == userID {
+			subs = append(subs, sub)
+		}
+	}
+	slices.SortFunc(subs, func(a, b models.WebhookSubscription) int {
+		return cmp.Or(a.CreatedAt.Compare(b.CreatedAt), cmp.Compare(a.ID, b.ID))
+	})
+	return subs, nil
+}
+
+func (r *MemoryRepository) GetWebhookSubscriptionByID(ctx context.Context, subscriptionID string) (*models.WebhookSubscription, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	sub, ok := r.db.WebhookSubscriptions.Rows[subscriptionID]
+	if !ok {
+		return &models.WebhookSubscription{}, sql.ErrNoRows
+	}
+	return &sub, nil
+}
+
+func (r *MemoryRepository) DeleteWebhookSubscription(ctx context.Context, subscriptionID string) error {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	memdb.Delete(r.db, "webhook_subscriptions", r.db.WebhookSubscriptions, subscriptionID)
+	return nil
+}
+
+func (r *MemoryRepository) GetWebhookDeliveries(ctx context.Context, userID string, page DeliveryPage) ([]models.WebhookDelivery, string, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	var deliveries []models.WebhookDelivery
+	for _, d := range r.db.WebhookDeliveries.Rows {
+		if r.db.WebhookSubscriptions.Rows[d.SubscriptionID].UserID != userID || !page.InRange(d.CreatedAt) {
+			continue
+		}
+		if v, ok := page.Filters["subscription_id"]; ok && d.SubscriptionID != v {
+			continue
+		}
+		if v, ok := page.Filters["status"]; ok && d.Status != v {
+			continue
+		}
+		if v, ok := page.Filters["event_type"]; ok && d.EventType != v {
+			continue
+		}
+		deliveries = append(deliveries, d)
+	}
+	deliveries, next := pagination.Apply(deliveries, page)
+	return deliveries, next, nil
+}
+
+func (r *MemoryRepository) GetWebhookDeliveryByID(ctx context.Context, deliveryID string) (*models.WebhookDelivery, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	d, ok := r.db.WebhookDeliveries.Rows[deliveryID]
+	if !ok {
+		return &models.WebhookDelivery{}, sql.ErrNoRows
+	}
+	return &d, nil
+}
+
+func (r *MemoryRepository) ReplayWebhookDelivery(ctx context.Context, deliveryID string) (*models.WebhookDelivery, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	original, ok := r.db.WebhookDeliveries.Rows[deliveryID]
+	if !ok {
+		return &models.WebhookDelivery{}, sql.ErrNoRows
+	}
+	now := r.db.Now()
+	d := models.WebhookDelivery{
+		ID:             uuid.New().String(),
+		SubscriptionID: original.SubscriptionID,
+		EventID:        original.EventID,
+		EventType:      original.EventType,
+		Payload:        original.Payload,
+		Status:         models.DeliveryPending,
+		NextAttemptAt:  now,
+		ReplayOf:       &original.ID,
+		CreatedAt:      now,
+	}
+	r.db.WebhookDeliveries.Rows[d.ID] = d
+	return &d, nil
+}
+
+func (r *MemoryRepository) ClaimWebhookDeliveries(ctx context.Context, lease time.Duration, limit int) ([]DueDelivery, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	now := r.db.Now()
+	var pending []models.WebhookDelivery
+	for _, d := range r.db.WebhookDeliveries.Rows {
+		if d.Status == models.DeliveryPending && !d.NextAttemptAt.After(now) {
+			pending = append(pending, d)
+		}
+	}
+	slices.SortFunc(pending, func(a, b models.WebhookDelivery) int {
+		return cmp.Or(a.NextAttemptAt.Compare(b.NextAttemptAt), cmp.Compare(a.ID, b.ID))
+	})
+	if len(pending) > limit {
+		pending = pending[:limit]
+	}
+
+	due := []DueDelivery{}
+	for _, d := range pending {
+		d.NextAttemptAt = now.Add(lease)
+		r.db.WebhookDeliveries.Rows[d.ID] = d
+		sub := r.db.WebhookSubscriptions.Rows[d.SubscriptionID]
+		due = append(due, DueDelivery{WebhookDelivery: d, URL: sub.URL, Secret: sub.Secret})
+	}
+	return due, nil
+}
+
+func (r *MemoryRepository) RecordWebhookAttempt(ctx context.Context, attempt WebhookAttempt) error {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	d, ok := r.db.WebhookDeliveries.Rows[attempt.DeliveryID]
+	if !ok {
+		return nil
+	}
+	now := r.db.Now()
+	d.Status = attempt.Status
+	d.Attempts++
+	d.LastAttemptAt = &now
+	d.NextAttemptAt = now.Add(attempt.RetryIn)
+	d.LastStatusCode = attempt.StatusCode
+	d.LastError = attempt.Error
+	r.db.WebhookDeliveries.Rows[d.ID] = d
+	return nil
+}
+
+func (r *MemoryRepository) PurgePatientDeliveries(ctx context.Context, patientID, userID string) (int64, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	var purged int64
+	for id, d := range r.db.WebhookDeliveries.Rows {
+		var payload struct {
+			Data map[string]string `json:"data"`
+		}
+		if json.Unmarshal(d.Payload, &payload) != nil || payload.Data["patient_id"] != patientID {
+			continue
+		}
+		if userID != "" && r.db.WebhookSubscriptions.Rows[d.SubscriptionID].UserID != userID {
+			continue
+		}
+		delete(r.db.WebhookDeliveries.Rows, id)
+		purged++
+	}
+	return purged, nil
+}
@@ -0,0 +1,186 @@
+package repository
+
+import (
+    "context"
+    "health-bar/shared/database"
+    "health-bar/shared/models"
+    "health-bar/shared/pagination"
+    "time"
+)
+
+const subscriptionColumns = `id, user_id, url, secret, event_types, created_at`
+
+const deliveryColumns = `d.id, d.subscription_id, d.event_id, d.event_type, d.payload, d.status, d.attempts,
+        d.next_attempt_at, d.last_attempt_at, d.last_status_code, d.last_error, d.replay_of, d.created_at`
+
+// CreateWebhookSubscription creates a webhook subscription
+func (r *NotificationRepository) CreateWebhookSubscription(ctx context.Context, sub *models.WebhookSubscription) error {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    query := `
+        INSERT INTO webhook_subscriptions (id, user_id, url, secret, event_types)
+        VALUES ($1, $2, $3, $4, $5)
+        RETURNING ` + subscriptionColumns
+
+    return database.Conn(ctx, r.db).QueryRowxContext(ctx, query,
+        sub.ID, sub.UserID, sub.URL, sub.Secret, sub.EventTypes,
+    ).StructScan(sub)
+}
+
+// GetWebhookSubscriptions lists a user's webhook subscriptions, oldest first
+func (r *NotificationRepository) GetWebhookSubscriptions(ctx context.Context, userID string) ([]models.WebhookSubscription, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    subs := []models.WebhookSubscription{}
+    query := `SELECT ` + subscriptionColumns + ` FROM webhook_subscriptions WHERE user_id = $1 ORDER BY created_at, id`
+    err := database.Conn(ctx, r.db).SelectContext(ctx, &subs, query, userID)
+    return subs, err
+}
+
+// GetWebhookSubscriptionByID gets a webhook subscription by ID
+func (r *NotificationRepository) GetWebhookSubscriptionByID(ctx context.Context, subscriptionID string) (*models.WebhookSubscription, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    sub := &models.WebhookSubscription{}
+    query := `SELECT ` + subscriptionColumns + ` FROM webhook_subscriptions WHERE id = $1`
+    err := database.Conn(ctx, r.db).GetContext(ctx, sub, query, subscriptionID)
+    return sub, err
+}
+
+// DeleteWebhookSubscription deletes a webhook subscription and its deliveries
+func (r *NotificationRepository) DeleteWebhookSubscription(ctx context.Context, subscriptionID string) error {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    query := `DELETE FROM webhook_subscriptions WHERE id = $1`
+    _, err := database.Conn(ctx, r.db).ExecContext(ctx, query, subscriptionID)
+    return err
+}
+
+// GetWebhookDeliveries gets a page of the deliveries of a user's webhooks and the cursor of the next page
+func (r *NotificationRepository) GetWebhookDeliveries(ctx context.Context, userID string, page DeliveryPage) ([]models.WebhookDelivery, string, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    q := &pagination.Query{}
+    q.Where("s.user_id = " + q.Arg(userID))
+    if subscriptionID, ok := page.Filters["subscription_id"]; ok {
+        q.Where("d.subscription_id = " + q.Arg(subscriptionID))
+    }
+    if status, ok := page.Filters["status"]; ok {
+        q.Where("d.status = " + q.Arg(status))
+    }
+    if eventType, ok := page.Filters["event_type"]; ok {
+        q.Where("d.event_type = " + q.Arg(eventType))
+    }
+    if !page.From.IsZero() {
+        q.Where("d.created_at >= " + q.Arg(pagination.Timestamp(page.From)) + "::timestamp")
+    }
+    if !page.To.IsZero() {
+        q.Where("d.created_at <= " + q.Arg(pagination.Timestamp(page.ToEnd())) + "::timestamp")
+    }
+
+    var deliveries []models.WebhookDelivery
+    query := `
+        SELECT ` + deliveryColumns + `
+        FROM webhook_deliveries d
+        INNER JOIN webhook_subscriptions s ON s.id = d.subscription_id` + page.Clauses(q)
+    if err := database.Conn(ctx, r.db).SelectContext(ctx, &deliveries, query, q.Args()...); err != nil {
+        return nil, "", err
+    }
+    deliveries, next := pagination.Page(deliveries, page)
+    return deliveries, next, nil
+}
+
+// GetWebhookDeliveryByID gets a webhook delivery by ID
+func (r *NotificationRepository) GetWebhookDeliveryByID(ctx context.Context, deliveryID string) (*models.WebhookDelivery, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    delivery := &models.WebhookDelivery{}
+    query := `SELECT ` + deliveryColumns + ` FROM webhook_deliveries d WHERE d.id = $1`
+    err := database.Conn(ctx, r.db).GetContext(ctx, delivery, query, deliveryID)
+    return delivery, err
+}
+
+// ReplayWebhookDelivery queues a new delivery of a delivery's event, due now
+func (r *NotificationRepository) ReplayWebhookDelivery(ctx context.Context, deliveryID string) (*models.WebhookDelivery, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    delivery := &models.WebhookDelivery{}
+    query := `
+        INSERT INTO webhook_deliveries AS d (subscription_id, event_id, event_type, payload, replay_of)
+        SELECT subscription_id, event_id, event_type, payload, id
+        FROM webhook_deliveries
+        WHERE id = $1
+        RETURNING ` + deliveryColumns
+    err := database.Conn(ctx, r.db).GetContext(ctx, delivery, query, deliveryID)
+    return delivery, err
+}
+
+// ClaimWebhookDeliveries gets up to limit due deliveries, oldest due first,
+// and pushes their next attempt back by lease so that no other dispatcher
+// claims them meanwhile. A dispatcher that crashes mid-send leaves them to
+// be claimed again once the lease runs out.
+func (r *NotificationRepository) ClaimWebhookDeliveries(ctx context.Context, lease time.Duration, limit int) ([]DueDelivery, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    due := []DueDelivery{}
+    query := `
+        UPDATE webhook_deliveries d
+        SET next_attempt_at = NOW() + $1 * INTERVAL '1 millisecond'
+        FROM webhook_subscriptions s
+        WHERE s.id = d.subscription_id
+          AND d.id IN (
+            SELECT id FROM webhook_deliveries
+            WHERE status = 'pending' AND next_attempt_at <= NOW()
+            ORDER BY next_attempt_at, id
+            LIMIT $2
+            FOR UPDATE SKIP LOCKED
+          )
+        RETURNING ` + deliveryColumns + `, s.url, s.secret`
+    err := database.Conn(ctx, r.db).SelectContext(ctx, &due, query, lease.Milliseconds(), limit)
+    return due, err
+}
+
+// RecordWebhookAttempt records the outcome of an attempt to send a delivery
+func (r *NotificationRepository) RecordWebhookAttempt(ctx context.Context, attempt WebhookAttempt) error {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    query := `
+        UPDATE webhook_deliveries
+        SET status = $2, attempts = attempts + 1, last_attempt_at = NOW(),
+            next_attempt_at = NOW() + $3 * INTERVAL '1 millisecond',
+            last_status_code = $4, last_error = $5
+        WHERE id = $1
+    `
+    _, err := database.Conn(ctx, r.db).ExecContext(ctx, query,
+        attempt.DeliveryID, attempt.Status, attempt.RetryIn.Milliseconds(), attempt.StatusCode, attempt.Error)
+    return err
+}
+
+// PurgePatientDeliveries deletes the deliveries, sent or not, whose event is
+// about a patient. An empty userID purges them for every subscriber.
+func (r *NotificationRepository) PurgePatientDeliveries(ctx context.Context, patientID, userID string) (int64, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    query := `
+        DELETE FROM webhook_deliveries d
+        USING webhook_subscriptions s
+        WHERE s.id = d.subscription_id
+          AND d.payload->'data'->>'patient_id' = $1
+          AND ($2 = '' OR s.user_id::text = $2)
+    `
+    result, err := database.Conn(ctx, r.db).ExecContext(ctx, query, patientID, userID)
+    if err != nil {
+        return 0, err
+    }
+    return result.RowsAffected()
+}
@@ -0,0 +1,197 @@
+package websocket
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAccept(t *testing.T) {
+	// The example from RFC 6455, section 1.3
+	if got := Accept("dGhlIHNhbXBsZSBub25jZQ=="); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("Accept = %q", got)
+	}
+}
+
+func TestUpgradeRejectsBadHandshakes(t *testing.T) {
+	tests := []struct {
+		name   string
+		header map[string]string
+	}{
+		{"not an upgrade", map[string]string{"Sec-WebSocket-Version": "13", "Sec-WebSocket-Key": "dGhlIHNhbXBsZSBub25jZQ=="}},
+		{"old version", map[string]string{"Connection": "Upgrade", "Upgrade": "websocket", "Sec-WebSocket-Version": "8", "Sec-WebSocket-Key": "dGhlIHNhbXBsZSBub25jZQ=="}},
+		{"bad key", map[string]string{"Connection": "Upgrade", "Upgrade": "websocket", "Sec-WebSocket-Version": "13", "Sec-WebSocket-Key": "short"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/ws", nil)
+			for name, value := range tt.header {
+				req.Header.Set(name, value)
+			}
+			if _, err := Upgrade(httptest.NewRecorder(), req); err == nil {
+				t.Fatal("Upgrade succeeded")
+			} else if _, ok := err.(*HandshakeError); !ok {
+				t.Fatalf("err = %v, want a HandshakeError", err)
+			}
+		})
+	}
+}
+
+// echo serves one connection that sends back every message it reads
+func echo(t *testing.T) string {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrade(w, r)
+		if err != nil {
+			t.Errorf("Upgrade: %v", err)
+			return
+		}
+		for {
+			_, message, err := conn.Read()
+			if err != nil {
+				return
+			}
+			conn.WriteText(message)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv.Listener.Addr().String()
+}
+
+// dial performs the client side of the handshake
+func dial(t *testing.T, addr string) (net.Conn, *bufio.Reader) {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	io.WriteString(conn, "GET /ws HTTP/1.1\r\nHost: "+addr+"\r\n"+
+		"Connection: Upgrade\r\nUpgrade: websocket\r\n"+
+		"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n")
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("handshake response = %d %v", resp.StatusCode, resp.Header)
+	}
+	return conn, br
+}
+
+// writeFrame sends a masked client frame
+func writeFrame(t *testing.T, conn net.Conn, fin bool, opcode byte, payload []byte) {
+	t.Helper()
+
+	first := opcode
+	if fin {
+		first |= 0x80
+	}
+	frame := []byte{first}
+	switch n := len(payload); {
+	case n < 126:
+		frame = append(frame, 0x80|byte(n))
+	default:
+		frame = append(frame, 0x80|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+	}
+	mask := []byte{1, 2, 3, 4}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	if _, err := conn.Write(frame); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readFrame reads an unmasked server frame
+func readFrame(t *testing.T, br *bufio.Reader) (byte, []byte) {
+	t.Helper()
+
+	var header [2]byte
+	if _, err := io.ReadFull(br, header[:]); err != nil {
+		t.Fatal(err)
+	}
+	if header[1]&0x80 != 0 {
+		t.Fatal("server frame is masked")
+	}
+	length := int(header[1] & 0x7F)
+	if length == 126 {
+		var ext [2]byte
+		io.ReadFull(br, ext[:])
+		length = int(binary.BigEndian.Uint16(ext[:]))
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(br, payload); err != nil {
+		t.Fatal(err)
+	}
+	return header[0] & 0x0F, payload
+}
+
+func TestMessages(t *testing.T) {
+	conn, br := dial(t, echo(t))
+
+	writeFrame(t, conn, true, OpText, []byte("hello"))
+	if op, payload := readFrame(t, br); op != OpText || string(payload) != "hello" {
+		t.Fatalf("echo = %x %q", op, payload)
+	}
+
+	// Pings are answered between the fragments of a message
+	long := strings.Repeat("x", 300)
+	writeFrame(t, conn, false, OpText, []byte(long[:100]))
+	writeFrame(t, conn, true, OpPing, []byte("are you there"))
+	writeFrame(t, conn, true, OpContinuation, []byte(long[100:]))
+	if op, payload := readFrame(t, br); op != OpPong || string(payload) != "are you there" {
+		t.Fatalf("ping answer = %x %q", op, payload)
+	}
+	if op, payload := readFrame(t, br); op != OpText || string(payload) != long {
+		t.Fatalf("reassembled echo = %x, %d bytes", op, len(payload))
+	}
+
+	writeFrame(t, conn, true, OpClose, binary.BigEndian.AppendUint16(nil, CloseNormal))
+	op, payload := readFrame(t, br)
+	if op != OpClose || binary.BigEndian.Uint16(payload) != CloseNormal {
+		t.Fatalf("close answer = %x %v", op, payload)
+	}
+}
+
+func TestProtocolErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		send func(net.Conn)
+		code uint16
+	}{
+		{"unmasked frame", func(conn net.Conn) { conn.Write([]byte{0x80 | OpText, 2, 'h', 'i'}) }, CloseProtocolError},
+		{"stray continuation", func(conn net.Conn) { writeFrame(t, conn, true, OpContinuation, []byte("hi")) }, CloseProtocolError},
+		{"fragmented ping", func(conn net.Conn) { writeFrame(t, conn, false, OpPing, nil) }, CloseProtocolError},
+		{"too big", func(conn net.Conn) {
+			conn.Write([]byte{0x80 | OpText, 0x80 | 127, 0, 0, 0, 0, 0, 2, 0, 0})
+		}, CloseTooBig},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, br := dial(t, echo(t))
+			tt.send(conn)
+
+			op, payload := readFrame(t, br)
+			if op != OpClose || len(payload) < 2 || binary.BigEndian.Uint16(payload) != tt.code {
+				t.Fatalf("frame = %x %q, want close %d", op, payload, tt.code)
+			}
+		})
+	}
+}
@@ -0,0 +1,501 @@
+package handlers
+
+import (
+    "context"
+    "database/sql"
+    "encoding/json"
+    "health-bar/shared/apperrors"
+    "health-bar/shared/models"
+    "health-bar/shared/utils"
+    "net/http"
+    "strings"
+    "time"
+)
+
+type AllergyRequest struct {
+    Substance string `json:"substance"`
+    Reaction  string `json:"reaction"`
+    Severity  string `json:"severity"`
+}
+
+type ConditionRequest struct {
+    Name        string `json:"name"`
+    Status      string `json:"status"`       // active (default), managed or resolved
+    DiagnosedOn string `json:"diagnosed_on"` // Optional, format: YYYY-MM-DD
+    Notes       string `json:"notes"`
+}
+
+type MedicationRequest struct {
+    Name      string `json:"name"`
+    Dosage    string `json:"dosage"`
+    Frequency string `json:"frequency"`
+    StartedOn string `json:"started_on"` // Optional, format: YYYY-MM-DD
+    Notes     string `json:"notes"`
+}
+
+type EmergencyContactRequest struct {
+    Name         string `json:"name"`
+    Relationship string `json:"relationship"`
+    Phone        string `json:"phone"`
+    Email        string `json:"email"`
+    IsPrimary    bool   `json:"is_primary"`
+}
+
+type BiometricsRequest struct {
+    BloodType string   `json:"blood_type"`
+    HeightCM  *float64 `json:"height_cm"`
+    WeightKG  *float64 `json:"weight_kg"`
+}
+
+// ClinicalHandlers are the CRUD endpoints of one list in the clinical record.
+// List and Create serve the collection; Get, Update and Delete an item
+// named by the id query parameter.
+type ClinicalHandlers struct {
+    List   http.HandlerFunc
+    Create http.HandlerFunc
+    Get    http.HandlerFunc
+    Update http.HandlerFunc
+    Delete http.HandlerFunc
+}
+
+// Allergies serves the patient's allergies
+func (h *PatientHandler) Allergies() ClinicalHandlers {
+    return clinicalList[models.Allergy, AllergyRequest]{
+        noun:     "Allergy",
+        plural:   "Allergies",
+        list:     h.repo.ListAllergies,
+        get:      h.repo.GetAllergy,
+        create:   h.repo.CreateAllergy,
+        update:   h.repo.UpdateAllergy,
+        remove:   h.repo.DeleteAllergy,
+        build:    AllergyRequest.allergy,
+        conflict: "An allergy to this substance is already recorded",
+    }.handlers(h)
+}
+
+// Conditions serves the patient's chronic conditions
+func (h *PatientHandler) Conditions() ClinicalHandlers {
+    return clinicalList[models.Condition, ConditionRequest]{
+        noun:   "Condition",
+        plural: "Conditions",
+        list:   h.repo.ListConditions,
+        get:    h.repo.GetCondition,
+        create: h.repo.CreateCondition,
+        update: h.repo.UpdateCondition,
+        remove: h.repo.DeleteCondition,
+        build:  ConditionRequest.condition,
+    }.handlers(h)
+}
+
+// Medications serves the patient's current medications
+func (h *PatientHandler) Medications() ClinicalHandlers {
+    return clinicalList[models.Medication, MedicationRequest]{
+        noun:   "Medication",
+        plural: "Medications",
+        list:   h.repo.ListMedications,
+        get:    h.repo.GetMedication,
+        create: h.repo.CreateMedication,
+        update: h.repo.UpdateMedication,
+        remove: h.repo.DeleteMedication,
+        build:  MedicationRequest.medication,
+    }.handlers(h)
+}
+
+// EmergencyContacts serves the patient's emergency contacts
+func (h *PatientHandler) EmergencyContacts() ClinicalHandlers {
+    return clinicalList[models.EmergencyContact, EmergencyContactRequest]{
+        noun:   "Emergency contact",
+        plural: "Emergency contacts",
+        list:   h.repo.ListEmergencyContacts,
+        get:    h.repo.GetEmergencyContact,
+        create: h.repo.CreateEmergencyContact,
+        update: h.repo.UpdateEmergencyContact,
+        remove: h.repo.DeleteEmergencyContact,
+        build:  EmergencyContactRequest.contact,
+        scored: true,
+    }.handlers(h)
+}
+
+// GetBiometrics gets the patient's blood type, height and weight
+func (h *PatientHandler) GetBiometrics(w http.ResponseWriter, r *http.Request) {
+    patientID, ok := h.clinicalPatient(w, r)
+    if !ok {
+        return
+    }
+
+    biometrics, err := h.repo.GetBiometrics(r.Context(), patientID)
+    if err != nil {
+        if err == sql.ErrNoRows {
+            utils.SendError(w, http.StatusNotFound, "Biometrics not recorded")
+            return
+        }
+        utils.SendAppError(w, err, "Failed to retrieve biometrics")
+        return
+    }
+
+    utils.SendSuccess(w, http.StatusOK, "Biometrics retrieved", biometrics)
+}
+
+// SaveBiometrics creates or replaces the patient's biometrics
+func (h *PatientHandler) SaveBiometrics(w http.ResponseWriter, r *http.Request) {
+    patientID, ok := h.clinicalPatient(w, r)
+    if !ok {
+        return
+    }
+
+    var req BiometricsRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        utils.SendError(w, http.StatusBadRequest, "Invalid request body")
+        return
+    }
+
+    biometrics, err := req.biometrics()
+    if err != nil {
+        utils.SendAppError(w, err, "Invalid biometrics")
+        return
+    }
+
+    err = h.repo.WithTx(r.Context(), func(ctx context.Context) error {
+        if err := h.repo.SaveBiometrics(ctx, patientID, biometrics); err != nil {
+            return err
+        }
+        return h.recordScore(ctx, patientID)
+    })
+    if err != nil {
+        utils.SendAppError(w, err, "Failed to save biometrics")
+        return
+    }
+
+    utils.SendSuccess(w, http.StatusOK, "Biometrics saved", biometrics)
+}
+
+// DeleteBiometrics deletes the patient's biometrics
+func (h *PatientHandler) DeleteBiometrics(w http.ResponseWriter, r *http.Request) {
+    patientID, ok := h.clinicalPatient(w, r)
+    if !ok {
+        return
+    }
+
+    err := h.repo.WithTx(r.Context(), func(ctx context.Context) error {
+        if err := h.repo.DeleteBiometrics(ctx, patientID); err != nil {
+            return err
+        }
+        return h.recordScore(ctx, patientID)
+    })
+    if err != nil {
+        if err == sql.ErrNoRows {
+            utils.SendError(w, http.StatusNotFound, "Biometrics not recorded")
+            return
+        }
+        utils.SendAppError(w, err, "Failed to delete biometrics")
+        return
+    }
+
+    utils.SendSuccess(w, http.StatusOK, "Biometrics deleted", nil)
+}
+
+// clinicalPatient resolves the profile ID of the patient calling a clinical
+// record endpoint. It sends the error response and returns false when there
+// is none.
+func (h *PatientHandler) clinicalPatient(w http.ResponseWriter, r *http.Request) (string, bool) {
+    userID := r.Header.Get("X-User-ID")
+    userRole := r.Header.Get("X-User-Role")
+
+    if userID == "" {
+        utils.SendError(w, http.StatusUnauthorized, "Unauthorized")
+        return "", false
+    }
+
+    if userRole != "patient" {
+        utils.SendError(w, http.StatusForbidden, "Only patients can manage their clinical record")
+        return "", false
+    }
+
+    profile, err := h.repo.GetProfileByUserID(r.Context(), userID)
+    if err != nil {
+        if err == sql.ErrNoRows {
+            utils.SendErrorCode(w, apperrors.CodeProfileNotFound, "Profile not found")
+            return "", false
+        }
+        utils.SendAppError(w, err, "Failed to retrieve profile")
+        return "", false
+    }
+    return profile.ID, true
+}
+
+// clinicalList describes one list in the clinical record: T is the stored
+// row and Req the request body that builds it.
+type clinicalList[T any, Req any] struct {
+    noun   string
+    plural string
+
+    list   func(ctx context.Context, patientID string) ([]T, error)
+    get    func(ctx context.Context, patientID, id string) (*T, error)
+    create func(ctx context.Context, patientID string, item *T) error
+    update func(ctx context.Context, patientID, id string, item *T) error
+    remove func(ctx context.Context, patientID, id string) error
+    build  func(req Req) (*T, error)
+
+    // conflict is the message for a unique violation, if the list has a
+    // uniqueness rule
+    conflict string
+    // scored is set for lists the health score counts, whose changes
+    // record it
+    scored bool
+}
+
+func (c clinicalList[T, Req]) handlers(h *PatientHandler) ClinicalHandlers {
+    return ClinicalHandlers{
+        List: func(w http.ResponseWriter, r *http.Request) {
+            patientID, ok := h.clinicalPatient(w, r)
+            if !ok {
+                return
+            }
+
+            items, err := c.list(r.Context(), patientID)
+            if err != nil {
+                utils.SendAppError(w, err, "Failed to retrieve "+strings.ToLower(c.plural))
+                return
+            }
+            utils.SendSuccess(w, http.StatusOK, c.plural+" retrieved", items)
+        },
+
+        Create: func(w http.ResponseWriter, r *http.Request) {
+            patientID, ok := h.clinicalPatient(w, r)
+            if !ok {
+                return
+            }
+
+            item, ok := c.decode(w, r)
+            if !ok {
+                return
+            }
+
+            err := c.write(r.Context(), h, patientID, func(ctx context.Context) error {
+                return c.create(ctx, patientID, item)
+            })
+            if err != nil {
+                c.sendError(w, err, "Failed to create "+strings.ToLower(c.noun))
+                return
+            }
+            utils.SendSuccess(w, http.StatusCreated, c.noun+" added successfully", item)
+        },
+
+        Get: func(w http.ResponseWriter, r *http.Request) {
+            patientID, ok := h.clinicalPatient(w, r)
+            if !ok {
+                return
+            }
+
+            id, ok := c.id(w, r)
+            if !ok {
+                return
+            }
+
+            item, err := c.get(r.Context(), patientID, id)
+            if err != nil {
+                c.sendError(w, err, "Failed to retrieve "+strings.ToLower(c.noun))
+                return
+            }
+            utils.SendSuccess(w, http.StatusOK, c.noun+" retrieved", item)
+        },
+
+        Update: func(w http.ResponseWriter, r *http.Request) {
+            patientID, ok := h.clinicalPatient(w, r)
+            if !ok {
+                return
+            }
+
+            id, ok := c.id(w, r)
+            if !ok {
+                return
+            }
+
+            item, ok := c.decode(w, r)
+            if !ok {
+                return
+            }
+
+            err := c.write(r.Context(), h, patientID, func(ctx context.Context) error {
+                return c.update(ctx, patientID, id, item)
+            })
+            if err != nil {
+                c.sendError(w, err, "Failed to update "+strings.ToLower(c.noun))
+                return
+            }
+            utils.SendSuccess(w, http.StatusOK, c.noun+" updated successfully", item)
+        },
+
+        Delete: func(w http.ResponseWriter, r *http.Request) {
+            patientID, ok := h.clinicalPatient(w, r)
+            if !ok {
+                return
+            }
+
+            id, ok := c.id(w, r)
+            if !ok {
+                return
+            }
+
+            err := c.write(r.Context(), h, patientID, func(ctx context.Context) error {
+                return c.remove(ctx, patientID, id)
+            })
+            if err != nil {
+                c.sendError(w, err, "Failed to delete "+strings.ToLower(c.noun))
+                return
+            }
+            utils.SendSuccess(w, http.StatusOK, c.noun+" deleted successfully", nil)
+        },
+    }
+}
+
+// write runs a change to the list, recording the health score with it when
+// the list is scored
+func (c clinicalList[T, Req]) write(ctx context.Context, h *PatientHandler, patientID string, change func(ctx context.Context) error) error {
+    if !c.scored {
+        return change(ctx)
+    }
+    return h.repo.WithTx(ctx, func(ctx context.Context) error {
+        if err := change(ctx); err != nil {
+            return err
+        }
+        return h.recordScore(ctx, patientID)
+    })
+}
+
+func (c clinicalList[T, Req]) id(w http.ResponseWriter, r *http.Request) (string, bool) {
+    id := r.URL.Query().Get("id")
+    if id == "" {
+        utils.SendErrorCode(w, apperrors.CodeValidation, c.noun+" ID is required")
+        return "", false
+    }
+    return id, true
+}
+
+func (c clinicalList[T, Req]) decode(w http.ResponseWriter, r *http.Request) (*T, bool) {
+    var req Req
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        utils.SendError(w, http.StatusBadRequest, "Invalid request body")
+        return nil, false
+    }
+
+    item, err := c.build(req)
+    if err != nil {
+        utils.SendAppError(w, err, "Invalid "+strings.ToLower(c.noun))
+        return nil, false
+    }
+    return item, true
+}
+
+func (c clinicalList[T, Req]) sendError(w http.ResponseWriter, err error, fallback string) {
+    switch {
+    case err == sql.ErrNoRows:
+        utils.SendError(w, http.StatusNotFound, c.noun+" not found")
+    case c.conflict != "" && apperrors.IsUniqueViolation(err):
+        utils.SendErrorCode(w, apperrors.CodeConflict, c.conflict)
+    default:
+        utils.SendAppError(w, err, fallback)
+    }
+}
+
+func (req AllergyRequest) allergy() (*models.Allergy, error) {
+    substance := strings.TrimSpace(req.Substance)
+    if substance == "" {
+        return nil, apperrors.New(apperrors.CodeValidation, "Substance is required")
+    }
+
+    severity := models.AllergySeverity(req.Severity)
+    if !severity.Valid() {
+        return nil, apperrors.New(apperrors.CodeValidation, "Severity must be one of: mild, moderate, severe, life_threatening")
+    }
+
+    return &models.Allergy{Substance: substance, Reaction: req.Reaction, Severity: severity}, nil
+}
+
+func (req ConditionRequest) condition() (*models.Condition, error) {
+    name := strings.TrimSpace(req.Name)
+    if name == "" {
+        return nil, apperrors.New(apperrors.CodeValidation, "Condition name is required")
+    }
+
+    status := models.ConditionStatus(req.Status)
+    if status == "" {
+        status = models.ConditionActive
+    }
+    if !status.Valid() {
+        return nil, apperrors.New(apperrors.CodeValidation, "Status must be one of: active, managed, resolved")
+    }
+
+    diagnosedOn, err := parseOptionalDate(req.DiagnosedOn, "diagnosed_on")
+    if err != nil {
+        return nil, err
+    }
+
+    return &models.Condition{Name: name, Status: status, DiagnosedOn: diagnosedOn, Notes: req.Notes}, nil
+}
+
+func (req MedicationRequest) medication() (*models.Medication, error) {
+    name := strings.TrimSpace(req.Name)
+    if name == "" {
+        return nil, apperrors.New(apperrors.CodeValidation, "Medication name is required")
+    }
+
+    startedOn, err := parseOptionalDate(req.StartedOn, "started_on")
+    if err != nil {
+        return nil, err
+    }
+
+    return &models.Medication{
+        Name:      name,
+        Dosage:    req.Dosage,
+        Frequency: req.Frequency,
+        StartedOn: startedOn,
+        Notes:     req.Notes,
+    }, nil
+}
+
+func (req EmergencyContactRequest) contact() (*models.EmergencyContact, error) {
+    name := strings.TrimSpace(req.Name)
+    phone := strings.TrimSpace(req.Phone)
+    if name == "" || phone == "" {
+        return nil, apperrors.New(apperrors.CodeValidation, "Contact name and phone are required")
+    }
+
+    return &models.EmergencyContact{
+        Name:         name,
+        Relationship: req.Relationship,
+        Phone:        phone,
+        Email:        req.Email,
+        IsPrimary:    req.IsPrimary,
+    }, nil
+}
+
+func (req BiometricsRequest) biometrics() (*models.Biometrics, error) {
+    bloodType := models.BloodType(strings.ToUpper(strings.TrimSpace(req.BloodType)))
+    if bloodType != "" && !bloodType.Valid() {
+        return nil, apperrors.New(apperrors.CodeValidation, "Blood type must be one of: A+, A-, B+, B-, AB+, AB-, O+, O-")
+    }
+    if bloodType == "" && req.HeightCM == nil && req.WeightKG == nil {
+        return nil, apperrors.New(apperrors.CodeValidation, "Provide a blood type, height or weight")
+    }
+    if req.HeightCM != nil && (*req.HeightCM <= 0 || *req.HeightCM > 300) {
+        return nil, apperrors.New(apperrors.CodeValidation, "Height must be between 0 and 300 cm")
+    }
+    if req.WeightKG != nil && (*req.WeightKG <= 0 || *req.WeightKG > 700) {
+        return nil, apperrors.New(apperrors.CodeValidation, "Weight must be between 0 and 700 kg")
+    }
+
+    return &models.Biometrics{BloodType: bloodType, HeightCM: req.HeightCM, WeightKG: req.WeightKG}, nil
+}
+
+// parseOptionalDate parses a YYYY-MM-DD date, returning nil for ""
+func parseOptionalDate(value, field string) (*time.Time, error) {
+    if value == "" {
+        return nil, nil
+    }
+    date, err := time.Parse("2006-01-02", value)
+    if err != nil {
+        return nil, apperrors.New(apperrors.CodeValidation, "Invalid "+field+" date. Use YYYY-MM-DD")
+    }
+    return &date, nil
+}
@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"health-bar/shared/apperrors"
+	"health-bar/shared/models"
+	"health-bar/shared/testutil"
+	"net/http"
+	"testing"
+)
+
+func TestAllergyCRUD(t *testing.T) {
+	h, db := newTestHandler()
+	patient := db.AddPatient("p@test.com", "Pat")
+	other := db.AddPatient("o@test.com", "Other")
+	allergies := h.Allergies()
+
+	create := func(userID string, body AllergyRequest) (int, models.Allergy) {
+		t.Helper()
+		req := testutil.NewRequest(t, http.MethodPost, "/api/patients/allergies", body, userID, "patient")
+		rec, resp := testutil.Serve(t, allergies.Create, req)
+		var allergy models.Allergy
+		if rec.Code == http.StatusCreated {
+			testutil.DecodeData(t, resp, &allergy)
+		}
+		return rec.Code, allergy
+	}
+
+	code, penicillin := create(patient.UserID, AllergyRequest{Substance: "Penicillin", Reaction: "Hives", Severity: "severe"})
+	if code != http.StatusCreated || penicillin.PatientID != patient.ID {
+		t.Fatalf("create: status = %d, allergy = %+v", code, penicillin)
+	}
+	if code, _ := create(patient.UserID, AllergyRequest{Substance: "PENICILLIN", Severity: "mild"}); code != http.StatusConflict {
+		t.Fatalf("duplicate substance: status = %d, want 409", code)
+	}
+	if code, _ := create(patient.UserID, AllergyRequest{Substance: "Latex", Severity: "deadly"}); code != http.StatusBadRequest {
+		t.Fatalf("invalid severity: status = %d, want 400", code)
+	}
+	create(patient.UserID, AllergyRequest{Substance: "Latex", Severity: "mild"})
+
+	req := testutil.NewRequest(t, http.MethodGet, "/api/patients/allergies", nil, patient.UserID, "patient")
+	rec, resp := testutil.Serve(t, allergies.List, req)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+	var list []models.Allergy
+	testutil.DecodeData(t, resp, &list)
+	if len(list) != 2 || list[0].Substance != "Latex" || list[1].Substance != "Penicillin" {
+		t.Fatalf("allergies = %+v", list)
+	}
+
+	update := AllergyRequest{Substance: "Penicillin", Reaction: "Anaphylaxis", Severity: "life_threatening"}
+	req = testutil.NewRequest(t, http.MethodPut, "/api/patients/allergy?id="+penicillin.ID, update, other.UserID, "patient")
+	rec, _ = testutil.Serve(t, allergies.Update, req)
+	testutil.ExpectStatus(t, rec, http.StatusNotFound)
+
+	req = testutil.NewRequest(t, http.MethodPut, "/api/patients/allergy?id="+penicillin.ID, update, patient.UserID, "patient")
+	rec, resp = testutil.Serve(t, allergies.Update, req)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+	var updated models.Allergy
+	testutil.DecodeData(t, resp, &updated)
+	if updated.Severity != models.SeverityLifeThreatening || updated.Reaction != "Anaphylaxis" {
+		t.Fatalf("updated allergy = %+v", updated)
+	}
+
+	req = testutil.NewRequest(t, http.MethodDelete, "/api/patients/allergy?id="+penicillin.ID, nil, other.UserID, "patient")
+	rec, _ = testutil.Serve(t, allergies.Delete, req)
+	testutil.ExpectStatus(t, rec, http.StatusNotFound)
+
+	req = testutil.NewRequest(t, http.MethodDelete, "/api/patients/allergy?id="+penicillin.ID, nil, patient.UserID, "patient")
+	rec, _ = testutil.Serve(t, allergies.Delete, req)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+
+	req = testutil.NewRequest(t, http.MethodGet, "/api/patients/allergy?id="+penicillin.ID, nil, patient.UserID, "patient")
+	rec, _ = testutil.Serve(t, allergies.Get, req)
+	testutil.ExpectStatus(t, rec, http.StatusNotFound)
+
+	req = testutil.NewRequest(t, http.MethodGet, "/api/patients/allergies", nil, patient.UserID, "doctor")
+	rec, _ = testutil.Serve(t, allergies.List, req)
+	testutil.ExpectStatus(t, rec, http.StatusForbidden)
+}
+
+func TestEmergencyContactsSinglePrimary(t *testing.T) {
+	h, db := newTestHandler()
+	patient := db.AddPatient("p@test.com", "Pat")
+	contacts := h.EmergencyContacts()
+
+	for _, body := range []EmergencyContactRequest{
+		{Name: "Sam", Phone: "555-0001", IsPrimary: true},
+		{Name: "Alex", Phone: "555-0002", IsPrimary: true},
+	} {
+		req := testutil.NewRequest(t, http.MethodPost, "/api/patients/emergency-contacts", body, patient.UserID, "patient")
+		rec, _ := testutil.Serve(t, contacts.Create, req)
+		testutil.ExpectStatus(t, rec, http.StatusCreated)
+	}
+
+	req := testutil.NewRequest(t, http.MethodPost, "/api/patients/emergency-contacts",
+		EmergencyContactRequest{Name: "No Phone"}, patient.UserID, "patient")
+	rec, resp := testutil.Serve(t, contacts.Create, req)
+	testutil.ExpectStatus(t, rec, http.StatusBadRequest)
+	testutil.ExpectCode(t, resp, apperrors.CodeValidation)
+
+	req = testutil.NewRequest(t, http.MethodGet, "/api/patients/emergency-contacts", nil, patient.UserID, "patient")
+	_, resp = testutil.Serve(t, contacts.List, req)
+	var list []models.EmergencyContact
+	testutil.DecodeData(t, resp, &list)
+	if len(list) != 2 || list[0].Name != "Alex" || !list[0].IsPrimary || list[1].IsPrimary {
+		t.Fatalf("contacts = %+v, want Alex as the only primary", list)
+	}
+}
+
+func TestBiometrics(t *testing.T) {
+	h, db := newTestHandler()
+	patient := db.AddPatient("p@test.com", "Pat")
+
+	save := func(body string) int {
+		req := testutil.NewRequest(t, http.MethodPut, "/api/patients/biometrics", body, patient.UserID, "patient")
+		rec, _ := testutil.Serve(t, h.SaveBiometrics, req)
+		return rec.Code
+	}
+
+	req := testutil.NewRequest(t, http.MethodGet, "/api/patients/biometrics", nil, patient.UserID, "patient")
+	rec, _ := testutil.Serve(t, h.GetBiometrics, req)
+	testutil.ExpectStatus(t, rec, http.StatusNotFound)
+
+	for body, want := range map[string]int{
+		`{}`:                                     http.StatusBadRequest,
+		`{"blood_type":"C+"}`:                    http.StatusBadRequest,
+		`{"height_cm":-4}`:                       http.StatusBadRequest,
+		`{"blood_type":"ab+","height_cm":180.5}`: http.StatusOK,
+		`{"blood_type":"AB+","weight_kg":76,"height_cm":180.5}`: http.StatusOK,
+	} {
+		if code := save(body); code != want {
+			t.Fatalf("save %s: status = %d, want %d", body, code, want)
+		}
+	}
+
+	stored := db.Biometrics.Rows[patient.ID]
+	if stored.BloodType != "AB+" || stored.HeightCM == nil || *stored.HeightCM != 180.5 {
+		t.Fatalf("stored biometrics = %+v", stored)
+	}
+
+	req = testutil.NewRequest(t, http.MethodDelete, "/api/patients/biometrics", nil, patient.UserID, "patient")
+	rec, _ = testutil.Serve(t, h.DeleteBiometrics, req)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+	if _, ok := db.Biometrics.Rows[patient.ID]; ok {
+		t.Fatal("biometrics not deleted")
+	}
+}
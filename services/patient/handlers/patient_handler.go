@@ -1,22 +1,49 @@
 package handlers
 
 import (
+    "context"
     "database/sql"
     "encoding/json"
+    "health-bar/shared/apperrors"
+    "health-bar/shared/events"
+    "health-bar/shared/healthscore"
     "health-bar/shared/models"
+    "health-bar/shared/notify"
+    "health-bar/shared/pagination"
+    "health-bar/shared/patch"
     "health-bar/shared/utils"
+    "health-bar/shared/webhooks"
     "health-bar/services/patient/repository"
+    "log"
     "net/http"
-    "strings"
     "time"
 )
 
 type PatientHandler struct {
-    repo *repository.PatientRepository
+    repo     repository.Store
+    rules    healthscore.RuleSet
+    notifier notify.Notifier
+    events   webhooks.Emitter
+    outbox   events.Outbox
 }
 
-func NewPatientHandler(repo *repository.PatientRepository) *PatientHandler {
-    return &PatientHandler{repo: repo}
+func NewPatientHandler(repo repository.Store) *PatientHandler {
+    return &PatientHandler{repo: repo, rules: healthscore.Latest(), notifier: notify.LogNotifier{}, events: webhooks.Discard{}, outbox: events.Discard{}}
+}
+
+// UseNotifier sets where doctors are told about changes to their access
+func (h *PatientHandler) UseNotifier(notifier notify.Notifier) {
+    h.notifier = notifier
+}
+
+// UseWebhooks sets where webhook events about granted access are stored
+func (h *PatientHandler) UseWebhooks(events webhooks.Emitter) {
+    h.events = events
+}
+
+// UseOutbox sets where events for other services are recorded
+func (h *PatientHandler) UseOutbox(outbox events.Outbox) {
+    h.outbox = outbox
 }
 
 type CreateProfileRequest struct {
@@ -62,14 +89,14 @@ func (h *PatientHandler) CreateProfile(w http.ResponseWriter, r *http.Request) {
 
     // Validate
     if req.FullName == "" || req.DateOfBirth == "" {
-        utils.SendError(w, http.StatusBadRequest, "Full name and date of birth are required")
+        utils.SendErrorCode(w, apperrors.CodeValidation, "Full name and date of birth are required")
         return
     }
 
     // Parse date
     dob, err := time.Parse("2006-01-02", req.DateOfBirth)
     if err != nil {
-        utils.SendError(w, http.StatusBadRequest, "Invalid date format. Use YYYY-MM-DD")
+        utils.SendErrorCode(w, apperrors.CodeValidation, "Invalid date format. Use YYYY-MM-DD")
         return
     }
 
@@ -81,12 +108,20 @@ func (h *PatientHandler) CreateProfile(w http.ResponseWriter, r *http.Request) {
         Address:     req.Address,
     }
 
-    if err := h.repo.CreateProfile(userID, profile); err != nil {
-        if strings.Contains(err.Error(), "duplicate") || strings.Contains(err.Error(), "unique") {
-            utils.SendError(w, http.StatusConflict, "Profile already exists")
+    // The history starts with the score of the new profile
+    err = h.repo.WithTx(r.Context(), func(ctx context.Context) error {
+        if err := h.repo.CreateProfile(ctx, userID, profile); err != nil {
+            return err
+        }
+        _, err := h.refreshScore(ctx, profile)
+        return err
+    })
+    if err != nil {
+        if apperrors.IsUniqueViolation(err) {
+            utils.SendErrorCode(w, apperrors.CodeProfileExists, "Profile already exists")
             return
         }
-        utils.SendError(w, http.StatusInternalServerError, "Failed to create profile")
+        utils.SendAppError(w, err, "Failed to create profile")
         return
     }
 
@@ -108,20 +143,27 @@ func (h *PatientHandler) GetMyProfile(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    profile, err := h.repo.GetProfileByUserID(userID)
+    profile, err := h.repo.GetProfileByUserID(r.Context(), userID)
     if err != nil {
         if err == sql.ErrNoRows {
-            utils.SendError(w, http.StatusNotFound, "Profile not found")
+            utils.SendErrorCode(w, apperrors.CodeProfileNotFound, "Profile not found")
             return
         }
-        utils.SendError(w, http.StatusInternalServerError, "Failed to retrieve profile")
+        utils.SendAppError(w, err, "Failed to retrieve profile")
         return
     }
 
-    utils.SendSuccess(w, http.StatusOK, "Profile retrieved", profile)
+    score, err := h.computeScore(r.Context(), profile)
+    if err != nil {
+        utils.SendAppError(w, err, "Failed to compute health score")
+        return
+    }
+
+    utils.SetETag(w, profile.Version)
+    utils.SendSuccess(w, http.StatusOK, "Profile retrieved", ProfileResponse{PatientProfile: *profile, HealthScore: score})
 }
 
-// UpdateProfile updates patient profile
+// UpdateProfile replaces the patient profile
 func (h *PatientHandler) UpdateProfile(w http.ResponseWriter, r *http.Request) {
     userID := r.Header.Get("X-User-ID")
     userRole := r.Header.Get("X-User-Role")
@@ -142,31 +184,152 @@ func (h *PatientHandler) UpdateProfile(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    // Parse date
-    dob, err := time.Parse("2006-01-02", req.DateOfBirth)
+    h.updateProfile(w, r, userID, func(*models.PatientProfile) (*models.PatientProfile, error) {
+        return req.profile()
+    })
+}
+
+// PatchProfile applies a JSON merge patch to the patient profile
+func (h *PatientHandler) PatchProfile(w http.ResponseWriter, r *http.Request) {
+    userID := r.Header.Get("X-User-ID")
+    userRole := r.Header.Get("X-User-Role")
+
+    if userID == "" {
+        utils.SendError(w, http.StatusUnauthorized, "Unauthorized")
+        return
+    }
+
+    if userRole != "patient" {
+        utils.SendError(w, http.StatusForbidden, "Only patients can update their profile")
+        return
+    }
+
+    changes, err := patch.Read(r)
     if err != nil {
-        utils.SendError(w, http.StatusBadRequest, "Invalid date format. Use YYYY-MM-DD")
+        utils.SendAppError(w, err, "Invalid request body")
         return
     }
 
-    profile := &models.PatientProfile{
+    h.updateProfile(w, r, userID, func(current *models.PatientProfile) (*models.PatientProfile, error) {
+        doc := UpdateProfileRequest{
+            FullName:    current.FullName,
+            DateOfBirth: current.DateOfBirth.Format("2006-01-02"),
+            Gender:      current.Gender,
+            Phone:       current.Phone,
+            Address:     current.Address,
+        }
+        if err := patch.Apply(&doc, changes); err != nil {
+            return nil, err
+        }
+        return doc.profile()
+    })
+}
+
+// updateProfile writes the profile built by change from the current one. The
+// write is rejected with 412 when If-Match names another version or the row
+// changes between the read and the write.
+func (h *PatientHandler) updateProfile(w http.ResponseWriter, r *http.Request, userID string, change func(*models.PatientProfile) (*models.PatientProfile, error)) {
+    current, err := h.repo.GetProfileByUserID(r.Context(), userID)
+    if err != nil {
+        if err == sql.ErrNoRows {
+            utils.SendErrorCode(w, apperrors.CodeProfileNotFound, "Profile not found")
+            return
+        }
+        utils.SendAppError(w, err, "Failed to retrieve profile")
+        return
+    }
+
+    if err := utils.CheckIfMatch(r, current.Version); err != nil {
+        utils.SendAppError(w, err, "Precondition failed")
+        return
+    }
+
+    profile, err := change(current)
+    if err != nil {
+        utils.SendAppError(w, err, "Invalid profile")
+        return
+    }
+    profile.Version = current.Version
+
+    // Age and contact details count towards the health score
+    err = h.repo.WithTx(r.Context(), func(ctx context.Context) error {
+        if err := h.repo.UpdateProfile(ctx, userID, profile); err != nil {
+            return err
+        }
+        return h.recordScore(ctx, current.ID)
+    })
+    if err != nil {
+        if err == sql.ErrNoRows {
+            utils.SendErrorCode(w, apperrors.CodeProfileNotFound, "Profile not found")
+            return
+        }
+        utils.SendAppError(w, err, "Failed to update profile")
+        return
+    }
+
+    utils.SetETag(w, profile.Version)
+    utils.SendSuccess(w, http.StatusOK, "Profile updated successfully", profile)
+}
+
+// profile validates req and converts it to a profile
+func (req UpdateProfileRequest) profile() (*models.PatientProfile, error) {
+    if req.FullName == "" {
+        return nil, apperrors.New(apperrors.CodeValidation, "Full name is required")
+    }
+
+    dob, err := time.Parse("2006-01-02", req.DateOfBirth)
+    if err != nil {
+        return nil, apperrors.New(apperrors.CodeValidation, "Invalid date format. Use YYYY-MM-DD")
+    }
+
+    return &models.PatientProfile{
         FullName:    req.FullName,
         DateOfBirth: dob,
         Gender:      req.Gender,
         Phone:       req.Phone,
         Address:     req.Address,
+    }, nil
+}
+
+// DeleteProfile deletes the patient's profile and their whole record. Other
+// services clean up what they keep about the patient, such as uploaded
+// files, when the patient.deleted event reaches them.
+func (h *PatientHandler) DeleteProfile(w http.ResponseWriter, r *http.Request) {
+    userID := r.Header.Get("X-User-ID")
+    userRole := r.Header.Get("X-User-Role")
+
+    if userID == "" {
+        utils.SendError(w, http.StatusUnauthorized, "Unauthorized")
+        return
     }
 
-    if err := h.repo.UpdateProfile(userID, profile); err != nil {
-        if err == sql.ErrNoRows {
-            utils.SendError(w, http.StatusNotFound, "Profile not found")
-            return
+    if userRole != "patient" {
+        utils.SendError(w, http.StatusForbidden, "Only patients can delete their profile")
+        return
+    }
+
+    profile, err := h.repo.GetProfileByUserID(r.Context(), userID)
+    if err != nil {
+        utils.SendErrorCode(w, apperrors.CodeProfileNotFound, "Patient profile not found")
+        return
+    }
+
+    err = h.repo.WithTx(r.Context(), func(ctx context.Context) error {
+        if err := h.repo.DeleteProfile(ctx, profile.ID); err != nil {
+            return err
         }
-        utils.SendError(w, http.StatusInternalServerError, "Failed to update profile")
+        return h.outbox.Add(ctx, events.PatientDeleted(profile.ID, userID))
+    })
+    if err == sql.ErrNoRows {
+        utils.SendErrorCode(w, apperrors.CodeProfileNotFound, "Patient profile not found")
+        return
+    }
+    if err != nil {
+        utils.SendAppError(w, err, "Failed to delete profile")
         return
     }
 
-    utils.SendSuccess(w, http.StatusOK, "Profile updated successfully", profile)
+    utils.SendSuccess(w, http.StatusOK, "Profile deleted successfully", nil)
 }
 
 // GrantAccess grants a doctor access to patient's records
@@ -185,9 +348,9 @@ func (h *PatientHandler) GrantAccess(w http.ResponseWriter, r *http.Request) {
     }
 
     // Get patient profile ID
-    profile, err := h.repo.GetProfileByUserID(userID)
+    profile, err := h.repo.GetProfileByUserID(r.Context(), userID)
     if err != nil {
-        utils.SendError(w, http.StatusNotFound, "Patient profile not found")
+        utils.SendErrorCode(w, apperrors.CodeProfileNotFound, "Patient profile not found")
         return
     }
 
@@ -198,15 +361,47 @@ func (h *PatientHandler) GrantAccess(w http.ResponseWriter, r *http.Request) {
     }
 
     if req.DoctorID == "" {
-        utils.SendError(w, http.StatusBadRequest, "Doctor ID is required")
+        utils.SendErrorCode(w, apperrors.CodeValidation, "Doctor ID is required")
         return
     }
 
-    if err := h.repo.GrantAccess(profile.ID, req.DoctorID); err != nil {
-        utils.SendError(w, http.StatusInternalServerError, "Failed to grant access")
+    // Granting access again reopens a thread frozen by an earlier revoke
+    var wasActive bool
+    err = h.repo.WithTx(r.Context(), func(ctx context.Context) error {
+        var err error
+        if wasActive, err = h.hasAccess(ctx, profile.ID, req.DoctorID); err != nil {
+            return err
+        }
+        if err := h.repo.GrantAccess(ctx, profile.ID, req.DoctorID); err != nil {
+            return err
+        }
+        if err := h.repo.UnfreezeThread(ctx, profile.ID, req.DoctorID); err != nil {
+            return err
+        }
+        if wasActive {
+            return nil
+        }
+        doctorUserID, err := h.repo.GetDoctorUserID(ctx, req.DoctorID)
+        if err != nil {
+            return err
+        }
+        return h.events.Emit(ctx, webhooks.AccessGranted(doctorUserID, profile.ID, profile.FullName))
+    })
+    if err != nil {
+        if apperrors.IsForeignKeyViolation(err) {
+            utils.SendError(w, http.StatusNotFound, "Doctor not found")
+            return
+        }
+        utils.SendAppError(w, err, "Failed to grant access")
         return
     }
 
+    if !wasActive {
+        h.notifyDoctor(r.Context(), req.DoctorID, func(doctorUserID string) notify.Message {
+            return notify.AccessGranted(doctorUserID, profile.ID, profile.FullName)
+        })
+    }
+
     utils.SendSuccess(w, http.StatusOK, "Access granted successfully", nil)
 }
 
@@ -226,27 +421,77 @@ func (h *PatientHandler) RevokeAccess(w http.ResponseWriter, r *http.Request) {
     }
 
     // Get patient profile ID
-    profile, err := h.repo.GetProfileByUserID(userID)
+    profile, err := h.repo.GetProfileByUserID(r.Context(), userID)
     if err != nil {
-        utils.SendError(w, http.StatusNotFound, "Patient profile not found")
+        utils.SendErrorCode(w, apperrors.CodeProfileNotFound, "Patient profile not found")
         return
     }
 
     // Get doctor ID from URL
     doctorID := r.URL.Query().Get("doctor_id")
     if doctorID == "" {
-        utils.SendError(w, http.StatusBadRequest, "Doctor ID is required")
+        utils.SendErrorCode(w, apperrors.CodeValidation, "Doctor ID is required")
         return
     }
 
-    if err := h.repo.RevokeAccess(profile.ID, doctorID); err != nil {
-        utils.SendError(w, http.StatusInternalServerError, "Failed to revoke access")
+    // The doctor can no longer post to or read new messages in their thread
+    // with the patient
+    var wasActive bool
+    err = h.repo.WithTx(r.Context(), func(ctx context.Context) error {
+        var err error
+        if wasActive, err = h.hasAccess(ctx, profile.ID, doctorID); err != nil {
+            return err
+        }
+        if err := h.repo.RevokeAccess(ctx, profile.ID, doctorID); err != nil {
+            return err
+        }
+        if err := h.repo.FreezeThread(ctx, profile.ID, doctorID); err != nil {
+            return err
+        }
+        if !wasActive {
+            return nil
+        }
+        doctorUserID, err := h.repo.GetDoctorUserID(ctx, doctorID)
+        if err != nil {
+            return err
+        }
+        return h.outbox.Add(ctx, events.AccessRevoked(profile.ID, doctorID, doctorUserID))
+    })
+    if err != nil {
+        utils.SendAppError(w, err, "Failed to revoke access")
         return
     }
 
+    if wasActive {
+        h.notifyDoctor(r.Context(), doctorID, func(doctorUserID string) notify.Message {
+            return notify.AccessRevoked(doctorUserID, profile.ID, profile.FullName)
+        })
+    }
+
     utils.SendSuccess(w, http.StatusOK, "Access revoked successfully", nil)
 }
 
+// hasAccess reports whether a doctor currently has access, with or without
+// an earlier grant
+func (h *PatientHandler) hasAccess(ctx context.Context, patientID, doctorID string) (bool, error) {
+    active, err := h.repo.CheckAccess(ctx, patientID, doctorID)
+    if err == sql.ErrNoRows {
+        return false, nil
+    }
+    return active, err
+}
+
+// notifyDoctor tells a doctor about a change to their access. The change is
+// already committed, so failures are only logged.
+func (h *PatientHandler) notifyDoctor(ctx context.Context, doctorID string, message func(doctorUserID string) notify.Message) {
+    doctorUserID, err := h.repo.GetDoctorUserID(ctx, doctorID)
+    if err != nil {
+        log.Printf("Failed to look up doctor %s to notify: %v", doctorID, err)
+        return
+    }
+    notify.Send(ctx, h.notifier, message(doctorUserID))
+}
+
 // ListPermissions lists all access permissions
 func (h *PatientHandler) ListPermissions(w http.ResponseWriter, r *http.Request) {
     userID := r.Header.Get("X-User-ID")
@@ -263,17 +508,23 @@ func (h *PatientHandler) ListPermissions(w http.ResponseWriter, r *http.Request)
     }
 
     // Get patient profile ID
-    profile, err := h.repo.GetProfileByUserID(userID)
+    profile, err := h.repo.GetProfileByUserID(r.Context(), userID)
+    if err != nil {
+        utils.SendErrorCode(w, apperrors.CodeProfileNotFound, "Patient profile not found")
+        return
+    }
+
+    page, err := pagination.Parse(r.URL.Query(), repository.PermissionPages)
     if err != nil {
-        utils.SendError(w, http.StatusNotFound, "Patient profile not found")
+        utils.SendAppError(w, err, "Invalid pagination parameters")
         return
     }
 
-    permissions, err := h.repo.ListPermissions(profile.ID)
+    permissions, next, err := h.repo.ListPermissions(r.Context(), profile.ID, page)
     if err != nil {
-        utils.SendError(w, http.StatusInternalServerError, "Failed to retrieve permissions")
+        utils.SendAppError(w, err, "Failed to retrieve permissions")
         return
     }
 
-    utils.SendSuccess(w, http.StatusOK, "Permissions retrieved", permissions)
+    utils.SendPage(w, http.StatusOK, "Permissions retrieved", permissions, next)
 }
@@ -0,0 +1,371 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"health-bar/services/patient/repository"
+	"health-bar/shared/apperrors"
+	"health-bar/shared/events"
+	"health-bar/shared/memdb"
+	"health-bar/shared/models"
+	"health-bar/shared/notify"
+	"health-bar/shared/patch"
+	"health-bar/shared/testutil"
+	"health-bar/shared/utils"
+	"health-bar/shared/webhooks"
+	"net/http"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func newTestHandler() (*PatientHandler, *memdb.DB) {
+	db := memdb.New()
+	return NewPatientHandler(repository.NewMemoryRepository(db)), db
+}
+
+func TestCreateProfileRoleChecks(t *testing.T) {
+	h, db := newTestHandler()
+	patient := db.AddUser("p@test.com", models.RolePatient)
+	doctor := db.AddUser("d@test.com", models.RoleDoctor)
+
+	body := CreateProfileRequest{FullName: "Pat", DateOfBirth: "1990-04-01"}
+
+	tests := []struct {
+		name   string
+		userID string
+		role   string
+		body   interface{}
+		want   int
+	}{
+		{"missing identity", "", "", body, http.StatusUnauthorized},
+		{"doctor cannot create", doctor.ID, "doctor", body, http.StatusForbidden},
+		{"invalid json", patient.ID, "patient", "{", http.StatusBadRequest},
+		{"missing fields", patient.ID, "patient", CreateProfileRequest{FullName: "Pat"}, http.StatusBadRequest},
+		{"bad date", patient.ID, "patient", CreateProfileRequest{FullName: "Pat", DateOfBirth: "01/04/1990"}, http.StatusBadRequest},
+		{"created", patient.ID, "patient", body, http.StatusCreated},
+		{"duplicate", patient.ID, "patient", body, http.StatusConflict},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := testutil.NewRequest(t, http.MethodPost, "/api/patients/profile", tt.body, tt.userID, tt.role)
+			rec, _ := testutil.Serve(t, h.CreateProfile, req)
+			testutil.ExpectStatus(t, rec, tt.want)
+		})
+	}
+}
+
+func TestGetAndUpdateProfile(t *testing.T) {
+	h, db := newTestHandler()
+	user := db.AddUser("p@test.com", models.RolePatient)
+
+	req := testutil.NewRequest(t, http.MethodGet, "/api/patients/profile", nil, user.ID, "patient")
+	rec, _ := testutil.Serve(t, h.GetMyProfile, req)
+	testutil.ExpectStatus(t, rec, http.StatusNotFound)
+
+	req = testutil.NewRequest(t, http.MethodPost, "/api/patients/profile",
+		CreateProfileRequest{FullName: "Pat", DateOfBirth: "1990-04-01", Phone: "123"}, user.ID, "patient")
+	rec, _ = testutil.Serve(t, h.CreateProfile, req)
+	testutil.ExpectStatus(t, rec, http.StatusCreated)
+
+	req = testutil.NewRequest(t, http.MethodPut, "/api/patients/profile",
+		UpdateProfileRequest{FullName: "Patricia", DateOfBirth: "1990-04-01"}, user.ID, "patient")
+	rec, _ = testutil.Serve(t, h.UpdateProfile, req)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+
+	req = testutil.NewRequest(t, http.MethodGet, "/api/patients/profile", nil, user.ID, "patient")
+	rec, resp := testutil.Serve(t, h.GetMyProfile, req)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+
+	var profile models.PatientProfile
+	testutil.DecodeData(t, resp, &profile)
+	if profile.FullName != "Patricia" {
+		t.Fatalf("full_name = %q, want Patricia", profile.FullName)
+	}
+
+	req = testutil.NewRequest(t, http.MethodGet, "/api/patients/profile", nil, user.ID, "doctor")
+	rec, _ = testutil.Serve(t, h.GetMyProfile, req)
+	testutil.ExpectStatus(t, rec, http.StatusForbidden)
+}
+
+func TestGrantListRevokeAccess(t *testing.T) {
+	h, db := newTestHandler()
+	patient := db.AddPatient("p@test.com", "Pat")
+	doctor := db.AddDoctor("d@test.com", "Dr Who")
+
+	req := testutil.NewRequest(t, http.MethodPost, "/api/patients/permissions/grant",
+		GrantAccessRequest{DoctorID: doctor.ID}, doctor.UserID, "doctor")
+	rec, _ := testutil.Serve(t, h.GrantAccess, req)
+	testutil.ExpectStatus(t, rec, http.StatusForbidden)
+
+	req = testutil.NewRequest(t, http.MethodPost, "/api/patients/permissions/grant",
+		GrantAccessRequest{}, patient.UserID, "patient")
+	rec, _ = testutil.Serve(t, h.GrantAccess, req)
+	testutil.ExpectStatus(t, rec, http.StatusBadRequest)
+
+	req = testutil.NewRequest(t, http.MethodPost, "/api/patients/permissions/grant",
+		GrantAccessRequest{DoctorID: doctor.ID}, patient.UserID, "patient")
+	rec, _ = testutil.Serve(t, h.GrantAccess, req)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+
+	permissions := listPermissions(t, h, patient.UserID)
+	if len(permissions) != 1 || !permissions[0].IsActive || permissions[0].DoctorID != doctor.ID {
+		t.Fatalf("permissions after grant = %+v", permissions)
+	}
+
+	req = testutil.NewRequest(t, http.MethodDelete, "/api/patients/permissions/revoke", nil, patient.UserID, "patient")
+	rec, _ = testutil.Serve(t, h.RevokeAccess, req)
+	testutil.ExpectStatus(t, rec, http.StatusBadRequest)
+
+	req = testutil.NewRequest(t, http.MethodDelete, "/api/patients/permissions/revoke?doctor_id="+doctor.ID, nil, patient.UserID, "patient")
+	rec, _ = testutil.Serve(t, h.RevokeAccess, req)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+
+	permissions = listPermissions(t, h, patient.UserID)
+	if len(permissions) != 1 || permissions[0].IsActive || permissions[0].RevokedAt == nil {
+		t.Fatalf("permissions after revoke = %+v", permissions)
+	}
+}
+
+func TestRevokeAccessFreezesThread(t *testing.T) {
+	h, db := newTestHandler()
+	patient := db.AddPatient("p@test.com", "Pat")
+	doctor := db.AddDoctor("d@test.com", "Dr Who")
+	db.Grant(patient.ID, doctor.ID)
+	thread := models.MessageThread{ID: uuid.New().String(), PatientID: patient.ID, DoctorID: doctor.ID}
+	db.MessageThreads.Rows[thread.ID] = thread
+
+	req := testutil.NewRequest(t, http.MethodDelete, "/api/patients/permissions/revoke?doctor_id="+doctor.ID, nil, patient.UserID, "patient")
+	rec, _ := testutil.Serve(t, h.RevokeAccess, req)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+	if !db.MessageThreads.Rows[thread.ID].Frozen() {
+		t.Fatal("thread not frozen after revoke")
+	}
+
+	req = testutil.NewRequest(t, http.MethodPost, "/api/patients/permissions/grant",
+		GrantAccessRequest{DoctorID: doctor.ID}, patient.UserID, "patient")
+	rec, _ = testutil.Serve(t, h.GrantAccess, req)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+	if db.MessageThreads.Rows[thread.ID].Frozen() {
+		t.Fatal("thread still frozen after granting access again")
+	}
+}
+
+func TestGrantAccessWithoutProfile(t *testing.T) {
+	h, db := newTestHandler()
+	user := db.AddUser("p@test.com", models.RolePatient)
+
+	req := testutil.NewRequest(t, http.MethodPost, "/api/patients/permissions/grant",
+		GrantAccessRequest{DoctorID: "someone"}, user.ID, "patient")
+	rec, _ := testutil.Serve(t, h.GrantAccess, req)
+	testutil.ExpectStatus(t, rec, http.StatusNotFound)
+}
+
+func TestAccessChangesNotifyDoctor(t *testing.T) {
+	h, db := newTestHandler()
+	recorder := &notify.Recorder{}
+	h.UseNotifier(recorder)
+	patient := db.AddPatient("p@test.com", "Pat")
+	doctor := db.AddDoctor("d@test.com", "Dr Who")
+
+	// Granting or revoking twice only notifies about the change
+	for i := 0; i < 2; i++ {
+		req := testutil.NewRequest(t, http.MethodPost, "/api/patients/permissions/grant",
+			GrantAccessRequest{DoctorID: doctor.ID}, patient.UserID, "patient")
+		rec, _ := testutil.Serve(t, h.GrantAccess, req)
+		testutil.ExpectStatus(t, rec, http.StatusOK)
+	}
+	for i := 0; i < 2; i++ {
+		req := testutil.NewRequest(t, http.MethodDelete, "/api/patients/permissions/revoke?doctor_id="+doctor.ID, nil, patient.UserID, "patient")
+		rec, _ := testutil.Serve(t, h.RevokeAccess, req)
+		testutil.ExpectStatus(t, rec, http.StatusOK)
+	}
+
+	msgs := recorder.Messages()
+	if len(msgs) != 2 {
+		t.Fatalf("sent %d messages, want 2: %+v", len(msgs), msgs)
+	}
+	for i, kind := range []string{notify.KindAccessGranted, notify.KindAccessRevoked} {
+		if msgs[i].Kind != kind || msgs[i].UserID != doctor.UserID || msgs[i].Data["patient_id"] != patient.ID {
+			t.Errorf("message %d = %+v, want %s to the doctor", i, msgs[i], kind)
+		}
+	}
+}
+
+func TestGrantAccessEmitsWebhook(t *testing.T) {
+	h, db := newTestHandler()
+	h.UseWebhooks(webhooks.NewMemoryEmitter(db))
+	patient := db.AddPatient("p@test.com", "Pat")
+	doctor := db.AddDoctor("d@test.com", "Dr Who")
+	sub := db.AddWebhookSubscription(doctor.UserID, "https://clinic.test/hooks", webhooks.EventAccessGranted)
+
+	// Granting access that is already active is not an event
+	for i := 0; i < 2; i++ {
+		req := testutil.NewRequest(t, http.MethodPost, "/api/patients/permissions/grant",
+			GrantAccessRequest{DoctorID: doctor.ID}, patient.UserID, "patient")
+		rec, _ := testutil.Serve(t, h.GrantAccess, req)
+		testutil.ExpectStatus(t, rec, http.StatusOK)
+	}
+
+	if len(db.WebhookDeliveries.Rows) != 1 {
+		t.Fatalf("deliveries = %+v", db.WebhookDeliveries.Rows)
+	}
+	for _, d := range db.WebhookDeliveries.Rows {
+		var event webhooks.Event
+		if err := json.Unmarshal(d.Payload, &event); err != nil {
+			t.Fatal(err)
+		}
+		if d.SubscriptionID != sub.ID || event.Type != webhooks.EventAccessGranted || event.Data["patient_id"] != patient.ID || event.Data["patient_name"] != "Pat" {
+			t.Fatalf("delivery = %+v, event = %+v", d, event)
+		}
+	}
+}
+
+func TestRevokeAccessRecordsEvent(t *testing.T) {
+	h, db := newTestHandler()
+	h.UseOutbox(events.NewMemoryOutbox(db))
+	patient := db.AddPatient("p@test.com", "Pat")
+	doctor := db.AddDoctor("d@test.com", "Dr Who")
+	db.Grant(patient.ID, doctor.ID)
+
+	// Revoking access that is no longer active is not an event
+	for i := 0; i < 2; i++ {
+		req := testutil.NewRequest(t, http.MethodDelete, "/api/patients/permissions/revoke?doctor_id="+doctor.ID, nil, patient.UserID, "patient")
+		rec, _ := testutil.Serve(t, h.RevokeAccess, req)
+		testutil.ExpectStatus(t, rec, http.StatusOK)
+	}
+
+	if len(db.OutboxEvents.Rows) != 1 {
+		t.Fatalf("outbox = %+v", db.OutboxEvents.Rows)
+	}
+	for _, row := range db.OutboxEvents.Rows {
+		event, err := events.Decode(row.Payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if row.Topic != events.TopicAccessRevoked || event.Data["patient_id"] != patient.ID || event.Data["doctor_user_id"] != doctor.UserID {
+			t.Fatalf("row = %+v, event = %+v", row, event)
+		}
+	}
+}
+
+func TestDeleteProfile(t *testing.T) {
+	h, db := newTestHandler()
+	h.UseOutbox(events.NewMemoryOutbox(db))
+	patient := db.AddPatient("p@test.com", "Pat")
+	doctor := db.AddDoctor("d@test.com", "Dr Who")
+	db.Grant(patient.ID, doctor.ID)
+	db.AddVisit(patient.ID, db.Now(), "Checkup")
+
+	req := testutil.NewRequest(t, http.MethodDelete, "/api/patients/profile", nil, doctor.UserID, "doctor")
+	rec, _ := testutil.Serve(t, h.DeleteProfile, req)
+	testutil.ExpectStatus(t, rec, http.StatusForbidden)
+
+	req = testutil.NewRequest(t, http.MethodDelete, "/api/patients/profile", nil, patient.UserID, "patient")
+	rec, _ = testutil.Serve(t, h.DeleteProfile, req)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+
+	if _, ok := db.PatientProfiles.Rows[patient.ID]; ok {
+		t.Fatal("profile not deleted")
+	}
+	if len(db.HospitalVisits.Rows) != 0 || len(db.AccessPermissions.Rows) != 0 {
+		t.Fatalf("dependent rows left: %+v, %+v", db.HospitalVisits.Rows, db.AccessPermissions.Rows)
+	}
+	if len(db.OutboxEvents.Rows) != 1 {
+		t.Fatalf("outbox = %+v", db.OutboxEvents.Rows)
+	}
+	for _, row := range db.OutboxEvents.Rows {
+		event, _ := events.Decode(row.Payload)
+		if row.Topic != events.TopicPatientDeleted || event.Data["patient_id"] != patient.ID || event.Data["user_id"] != patient.UserID {
+			t.Fatalf("row = %+v, event = %+v", row, event)
+		}
+	}
+
+	rec, resp := testutil.Serve(t, h.DeleteProfile, req)
+	testutil.ExpectStatus(t, rec, http.StatusNotFound)
+	testutil.ExpectCode(t, resp, apperrors.CodeProfileNotFound)
+}
+
+type failingOutbox struct{}
+
+func (failingOutbox) Add(ctx context.Context, events ...events.Event) error {
+	return errors.New("outbox unavailable")
+}
+
+func TestDeleteProfileRollsBackWithoutEvent(t *testing.T) {
+	h, db := newTestHandler()
+	h.UseOutbox(failingOutbox{})
+	patient := db.AddPatient("p@test.com", "Pat")
+
+	req := testutil.NewRequest(t, http.MethodDelete, "/api/patients/profile", nil, patient.UserID, "patient")
+	rec, _ := testutil.Serve(t, h.DeleteProfile, req)
+	testutil.ExpectStatus(t, rec, http.StatusInternalServerError)
+	if _, ok := db.PatientProfiles.Rows[patient.ID]; !ok {
+		t.Fatal("profile deleted although its event was not recorded")
+	}
+}
+
+func listPermissions(t *testing.T, h *PatientHandler, userID string) []models.DoctorAccessPermission {
+	t.Helper()
+
+	req := testutil.NewRequest(t, http.MethodGet, "/api/patients/permissions", nil, userID, "patient")
+	rec, resp := testutil.Serve(t, h.ListPermissions, req)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+
+	var permissions []models.DoctorAccessPermission
+	testutil.DecodeData(t, resp, &permissions)
+	return permissions
+}
+
+func TestPatchProfile(t *testing.T) {
+	h, db := newTestHandler()
+	seeded := db.AddPatient("p@test.com", "Pat")
+
+	patchProfile := func(body, contentType, ifMatch string) (int, models.PatientProfile) {
+		t.Helper()
+		req := testutil.NewRequest(t, http.MethodPatch, "/api/patients/profile", body, seeded.UserID, "patient")
+		req.Header.Set("Content-Type", contentType)
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		rec, resp := testutil.Serve(t, h.PatchProfile, req)
+		var profile models.PatientProfile
+		if rec.Code == http.StatusOK {
+			testutil.DecodeData(t, resp, &profile)
+		}
+		return rec.Code, profile
+	}
+
+	code, profile := patchProfile(`{"phone":"555-0100","address":"1 Main St"}`, patch.ContentType, utils.ETag(1))
+	if code != http.StatusOK {
+		t.Fatalf("patch: status = %d, want 200", code)
+	}
+	if profile.FullName != "Pat" || profile.Phone != "555-0100" || profile.Address != "1 Main St" ||
+		!profile.DateOfBirth.Equal(seeded.DateOfBirth) || profile.Version != 2 {
+		t.Fatalf("patched profile = %+v", profile)
+	}
+
+	if code, _ := patchProfile(`{"phone":"1"}`, patch.ContentType, utils.ETag(1)); code != http.StatusPreconditionFailed {
+		t.Fatalf("stale If-Match: status = %d, want 412", code)
+	}
+	if code, _ := patchProfile(`{"full_name":null}`, patch.ContentType, ""); code != http.StatusBadRequest {
+		t.Fatalf("clearing full_name: status = %d, want 400", code)
+	}
+	if code, _ := patchProfile(`{"phone":"1"}`, "text/plain", ""); code != http.StatusUnsupportedMediaType {
+		t.Fatalf("text/plain patch: status = %d, want 415", code)
+	}
+
+	// A full update is held to the same precondition
+	req := testutil.NewRequest(t, http.MethodPut, "/api/patients/profile",
+		UpdateProfileRequest{FullName: "Patricia", DateOfBirth: "1990-04-01"}, seeded.UserID, "patient")
+	req.Header.Set("If-Match", utils.ETag(1))
+	rec, resp := testutil.Serve(t, h.UpdateProfile, req)
+	testutil.ExpectStatus(t, rec, http.StatusPreconditionFailed)
+	testutil.ExpectCode(t, resp, apperrors.CodePreconditionFailed)
+
+	if stored := db.PatientProfiles.Rows[seeded.ID]; stored.FullName != "Pat" || stored.Version != 2 {
+		t.Fatalf("stored profile = %+v", stored)
+	}
+}
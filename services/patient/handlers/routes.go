@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"health-bar/shared/apperrors"
+	"health-bar/shared/middleware"
+
+	"github.com/gorilla/mux"
+)
+
+// RegisterRoutes mounts the patient service endpoints on router
+func RegisterRoutes(router *mux.Router, h *PatientHandler) {
+	router.NotFoundHandler = apperrors.NotFoundHandler()
+	router.MethodNotAllowedHandler = apperrors.MethodNotAllowedHandler()
+
+	// Patient profile routes (protected)
+	router.HandleFunc("/api/patients/profile", middleware.AuthMiddleware(h.CreateProfile)).Methods("POST")
+	router.HandleFunc("/api/patients/profile", middleware.AuthMiddleware(h.GetMyProfile)).Methods("GET")
+	router.HandleFunc("/api/patients/profile", middleware.AuthMiddleware(h.UpdateProfile)).Methods("PUT")
+	router.HandleFunc("/api/patients/profile", middleware.AuthMiddleware(h.PatchProfile)).Methods("PATCH")
+	router.HandleFunc("/api/patients/profile", middleware.AuthMiddleware(h.DeleteProfile)).Methods("DELETE")
+
+	// Health score routes (protected)
+	router.HandleFunc("/api/patients/profile/score", middleware.AuthMiddleware(h.GetHealthScore)).Methods("GET")
+	router.HandleFunc("/api/patients/profile/score/history", middleware.AuthMiddleware(h.GetHealthScoreHistory)).Methods("GET")
+	router.HandleFunc("/api/patients/assessments", middleware.AuthMiddleware(h.ListAssessments)).Methods("GET")
+	router.HandleFunc("/api/patients/assessments", middleware.AuthMiddleware(h.CreateAssessment)).Methods("POST")
+
+	// Access permission routes (protected)
+	router.HandleFunc("/api/patients/permissions/grant", middleware.AuthMiddleware(h.GrantAccess)).Methods("POST")
+	router.HandleFunc("/api/patients/permissions/revoke", middleware.AuthMiddleware(h.RevokeAccess)).Methods("DELETE")
+	router.HandleFunc("/api/patients/permissions", middleware.AuthMiddleware(h.ListPermissions)).Methods("GET")
+
+	// Clinical record routes (protected)
+	registerClinical(router, "/api/patients/allergies", "/api/patients/allergy", h.Allergies())
+	registerClinical(router, "/api/patients/conditions", "/api/patients/condition", h.Conditions())
+	registerClinical(router, "/api/patients/medications", "/api/patients/medication", h.Medications())
+	registerClinical(router, "/api/patients/emergency-contacts", "/api/patients/emergency-contact", h.EmergencyContacts())
+	router.HandleFunc("/api/patients/biometrics", middleware.AuthMiddleware(h.GetBiometrics)).Methods("GET")
+	router.HandleFunc("/api/patients/biometrics", middleware.AuthMiddleware(h.SaveBiometrics)).Methods("PUT")
+	router.HandleFunc("/api/patients/biometrics", middleware.AuthMiddleware(h.DeleteBiometrics)).Methods("DELETE")
+}
+
+// registerClinical mounts a clinical list on its collection path and its
+// item path, which takes the id query parameter
+func registerClinical(router *mux.Router, collection, item string, c ClinicalHandlers) {
+	router.HandleFunc(collection, middleware.AuthMiddleware(c.List)).Methods("GET")
+	router.HandleFunc(collection, middleware.AuthMiddleware(c.Create)).Methods("POST")
+	router.HandleFunc(item, middleware.AuthMiddleware(c.Get)).Methods("GET")
+	router.HandleFunc(item, middleware.AuthMiddleware(c.Update)).Methods("PUT")
+	router.HandleFunc(item, middleware.AuthMiddleware(c.Delete)).Methods("DELETE")
+}
@@ -0,0 +1,344 @@
+package handlers
+
+import (
+    "context"
+    "database/sql"
+    "encoding/json"
+    "fmt"
+    "health-bar/shared/apperrors"
+    "health-bar/shared/healthscore"
+    "health-bar/shared/models"
+    "health-bar/shared/pagination"
+    "health-bar/shared/utils"
+    "health-bar/services/patient/repository"
+    "log"
+    "net/http"
+    "strings"
+    "time"
+)
+
+// How far back the score looks: enough visits for the longest check-up
+// window and enough assessments for the staleness cut-off
+const (
+    scoreVisitYears      = 3
+    scoreAssessmentYears = 1
+)
+
+// scoreBatch is how many patients RecordScores reads at a time
+const scoreBatch = 100
+
+type AssessmentRequest struct {
+    GeneralHealth int    `json:"general_health"` // 1 (poor) to 5 (excellent)
+    Energy        int    `json:"energy"`         // 1 to 5
+    Sleep         int    `json:"sleep"`          // 1 to 5
+    Mood          int    `json:"mood"`           // 1 to 5
+    Pain          int    `json:"pain"`           // 1 (none) to 5 (severe)
+    Notes         string `json:"notes"`
+}
+
+// HealthScoreResponse is the current score with its breakdown and when the
+// next self-assessment is due
+type HealthScoreResponse struct {
+    models.HealthScore
+    AssessmentDue    bool   `json:"assessment_due"`
+    NextAssessmentOn string `json:"next_assessment_on"`
+}
+
+// ProfileResponse is the patient's profile with their current health score
+type ProfileResponse struct {
+    models.PatientProfile
+    HealthScore HealthScoreResponse `json:"health_score"`
+}
+
+type AssessmentResponse struct {
+    Assessment  models.SelfAssessment `json:"assessment"`
+    HealthScore HealthScoreResponse   `json:"health_score"`
+}
+
+func (req AssessmentRequest) assessment() (*models.SelfAssessment, error) {
+    var invalid []string
+    for _, rating := range []struct {
+        name  string
+        value int
+    }{
+        {"general_health", req.GeneralHealth}, {"energy", req.Energy}, {"sleep", req.Sleep},
+        {"mood", req.Mood}, {"pain", req.Pain},
+    } {
+        if rating.value < 1 || rating.value > 5 {
+            invalid = append(invalid, rating.name)
+        }
+    }
+    if len(invalid) > 0 {
+        return nil, apperrors.New(apperrors.CodeValidation, "Ratings must be between 1 and 5: "+strings.Join(invalid, ", "))
+    }
+
+    return &models.SelfAssessment{
+        GeneralHealth: req.GeneralHealth,
+        Energy:        req.Energy,
+        Sleep:         req.Sleep,
+        Mood:          req.Mood,
+        Pain:          req.Pain,
+        Notes:         strings.TrimSpace(req.Notes),
+    }, nil
+}
+
+// UseScoreRules selects the version of the health score rules, the latest
+// one by default
+func (h *PatientHandler) UseScoreRules(version string) error {
+    rules, ok := healthscore.Lookup(version)
+    if !ok {
+        return fmt.Errorf("unknown health score rules %q, have %s", version, strings.Join(healthscore.Versions(), ", "))
+    }
+    h.rules = rules
+    return nil
+}
+
+// GetHealthScore computes the patient's health score and its breakdown.
+// Reading the score does not record it: the history follows the writes that
+// change it, see recordScore.
+func (h *PatientHandler) GetHealthScore(w http.ResponseWriter, r *http.Request) {
+    profile, ok := h.scorePatient(w, r)
+    if !ok {
+        return
+    }
+
+    score, err := h.computeScore(r.Context(), profile)
+    if err != nil {
+        utils.SendAppError(w, err, "Failed to compute health score")
+        return
+    }
+
+    utils.SendSuccess(w, http.StatusOK, "Health score computed", score)
+}
+
+// GetHealthScoreHistory lists the patient's recorded scores
+func (h *PatientHandler) GetHealthScoreHistory(w http.ResponseWriter, r *http.Request) {
+    profile, ok := h.scorePatient(w, r)
+    if !ok {
+        return
+    }
+
+    page, err := pagination.Parse(r.URL.Query(), repository.ScorePages)
+    if err != nil {
+        utils.SendAppError(w, err, "Invalid pagination parameters")
+        return
+    }
+
+    scores, next, err := h.repo.ListHealthScores(r.Context(), profile.ID, page)
+    if err != nil {
+        utils.SendAppError(w, err, "Failed to retrieve health score history")
+        return
+    }
+    if scores == nil {
+        scores = []models.HealthScore{}
+    }
+
+    utils.SendPage(w, http.StatusOK, "Health score history retrieved", scores, next)
+}
+
+// CreateAssessment records a self-assessment and returns the score it
+// results in
+func (h *PatientHandler) CreateAssessment(w http.ResponseWriter, r *http.Request) {
+    profile, ok := h.scorePatient(w, r)
+    if !ok {
+        return
+    }
+
+    var req AssessmentRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        utils.SendError(w, http.StatusBadRequest, "Invalid request body")
+        return
+    }
+
+    assessment, err := req.assessment()
+    if err != nil {
+        utils.SendAppError(w, err, "Invalid self-assessment")
+        return
+    }
+
+    var score HealthScoreResponse
+    err = h.repo.WithTx(r.Context(), func(ctx context.Context) error {
+        if err := h.repo.CreateAssessment(ctx, profile.ID, assessment); err != nil {
+            return err
+        }
+        score, err = h.refreshScore(ctx, profile)
+        return err
+    })
+    if err != nil {
+        utils.SendAppError(w, err, "Failed to record self-assessment")
+        return
+    }
+
+    utils.SendSuccess(w, http.StatusCreated, "Self-assessment recorded", AssessmentResponse{
+        Assessment:  *assessment,
+        HealthScore: score,
+    })
+}
+
+// ListAssessments lists the patient's self-assessments
+func (h *PatientHandler) ListAssessments(w http.ResponseWriter, r *http.Request) {
+    profile, ok := h.scorePatient(w, r)
+    if !ok {
+        return
+    }
+
+    page, err := pagination.Parse(r.URL.Query(), repository.AssessmentPages)
+    if err != nil {
+        utils.SendAppError(w, err, "Invalid pagination parameters")
+        return
+    }
+
+    assessments, next, err := h.repo.ListAssessments(r.Context(), profile.ID, page)
+    if err != nil {
+        utils.SendAppError(w, err, "Failed to retrieve self-assessments")
+        return
+    }
+    if assessments == nil {
+        assessments = []models.SelfAssessment{}
+    }
+
+    utils.SendPage(w, http.StatusOK, "Self-assessments retrieved", assessments, next)
+}
+
+// computeScore computes the current score of a patient without recording it
+func (h *PatientHandler) computeScore(ctx context.Context, profile *models.PatientProfile) (HealthScoreResponse, error) {
+    now := time.Now().UTC().Truncate(time.Microsecond)
+    in := healthscore.Inputs{Now: now, Profile: *profile}
+
+    var err error
+    if in.EmergencyContacts, err = h.repo.ListEmergencyContacts(ctx, profile.ID); err != nil {
+        return HealthScoreResponse{}, err
+    }
+    if in.Biometrics, err = h.repo.GetBiometrics(ctx, profile.ID); err != nil {
+        if err != sql.ErrNoRows {
+            return HealthScoreResponse{}, err
+        }
+        in.Biometrics = nil
+    }
+    if in.Visits, err = h.repo.GetVisitsSince(ctx, profile.ID, now.AddDate(-scoreVisitYears, 0, 0)); err != nil {
+        return HealthScoreResponse{}, err
+    }
+    if in.Assessments, err = h.repo.RecentAssessments(ctx, profile.ID, now.AddDate(-scoreAssessmentYears, 0, 0)); err != nil {
+        return HealthScoreResponse{}, err
+    }
+
+    next := now
+    if len(in.Assessments) > 0 {
+        next = in.Assessments[0].CreatedAt.AddDate(0, 0, healthscore.AssessmentInterval)
+    }
+    return HealthScoreResponse{
+        HealthScore:      h.rules.Score(in),
+        AssessmentDue:    !next.After(now),
+        NextAssessmentOn: pagination.Date(next),
+    }, nil
+}
+
+// refreshScore computes the current score and records it when it differs
+// from the latest recorded one. Writes to the score's inputs call it in
+// their transaction; visits, written by other services, and the passing of
+// time are caught up with by RecordScores.
+func (h *PatientHandler) refreshScore(ctx context.Context, profile *models.PatientProfile) (HealthScoreResponse, error) {
+    score, err := h.computeScore(ctx, profile)
+    if err != nil {
+        return HealthScoreResponse{}, err
+    }
+
+    latest, err := h.repo.LatestHealthScore(ctx, profile.ID)
+    if err != nil && err != sql.ErrNoRows {
+        return HealthScoreResponse{}, err
+    }
+    if latest == nil || latest.Score != score.Score || latest.RulesVersion != score.RulesVersion {
+        if err := h.repo.RecordHealthScore(ctx, &score.HealthScore); err != nil {
+            return HealthScoreResponse{}, err
+        }
+    }
+    return score, nil
+}
+
+// recordScore is refreshScore for a patient known by profile ID
+func (h *PatientHandler) recordScore(ctx context.Context, patientID string) error {
+    profile, err := h.repo.GetProfileByID(ctx, patientID)
+    if err != nil {
+        return err
+    }
+    _, err = h.refreshScore(ctx, profile)
+    return err
+}
+
+// RecordScores refreshes the recorded score of every patient and returns
+// how many it failed for
+func (h *PatientHandler) RecordScores(ctx context.Context) (int, error) {
+    failed := 0
+    after := ""
+    for {
+        ids, err := h.repo.ListPatientIDs(ctx, after, scoreBatch)
+        if err != nil {
+            return failed, err
+        }
+        for _, id := range ids {
+            err := h.repo.WithTx(ctx, func(ctx context.Context) error {
+                return h.recordScore(ctx, id)
+            })
+            // A patient deleted meanwhile has no score to record
+            if err != nil && err != sql.ErrNoRows {
+                if ctx.Err() != nil {
+                    return failed, ctx.Err()
+                }
+                log.Printf("Failed to record health score of patient %s: %v", id, err)
+                failed++
+            }
+        }
+        if len(ids) < scoreBatch {
+            return failed, nil
+        }
+        after = ids[len(ids)-1]
+    }
+}
+
+// RecordScoresEvery runs RecordScores every interval until ctx is done
+func (h *PatientHandler) RecordScoresEvery(ctx context.Context, interval time.Duration) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+            failed, err := h.RecordScores(ctx)
+            if err != nil {
+                log.Printf("Failed to record health scores: %v", err)
+            }
+            if failed > 0 {
+                log.Printf("Failed to record %d health scores", failed)
+            }
+        }
+    }
+}
+
+// scorePatient resolves the calling patient's profile
+func (h *PatientHandler) scorePatient(w http.ResponseWriter, r *http.Request) (*models.PatientProfile, bool) {
+    userID := r.Header.Get("X-User-ID")
+    userRole := r.Header.Get("X-User-Role")
+
+    if userID == "" {
+        utils.SendError(w, http.StatusUnauthorized, "Unauthorized")
+        return nil, false
+    }
+
+    if userRole != "patient" {
+        utils.SendError(w, http.StatusForbidden, "Only patients can view their health score")
+        return nil, false
+    }
+
+    profile, err := h.repo.GetProfileByUserID(r.Context(), userID)
+    if err != nil {
+        if err == sql.ErrNoRows {
+            utils.SendErrorCode(w, apperrors.CodeProfileNotFound, "Profile not found")
+            return nil, false
+        }
+        utils.SendAppError(w, err, "Failed to retrieve profile")
+        return nil, false
+    }
+    return profile, true
+}
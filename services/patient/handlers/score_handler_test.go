@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"health-bar/shared/apperrors"
+	"health-bar/shared/models"
+	"health-bar/shared/testutil"
+)
+
+func TestHealthScore(t *testing.T) {
+	h, db := newTestHandler()
+	patient := db.AddPatient("p@test.com", "Pat")
+
+	getScore := func() HealthScoreResponse {
+		t.Helper()
+		req := testutil.NewRequest(t, http.MethodGet, "/api/patients/profile/score", nil, patient.UserID, "patient")
+		rec, resp := testutil.Serve(t, h.GetHealthScore, req)
+		testutil.ExpectStatus(t, rec, http.StatusOK)
+
+		var score HealthScoreResponse
+		testutil.DecodeData(t, resp, &score)
+		return score
+	}
+	history := func() []models.HealthScore {
+		t.Helper()
+		req := testutil.NewRequest(t, http.MethodGet, "/api/patients/profile/score/history", nil, patient.UserID, "patient")
+		rec, resp := testutil.Serve(t, h.GetHealthScoreHistory, req)
+		testutil.ExpectStatus(t, rec, http.StatusOK)
+
+		var scores []models.HealthScore
+		testutil.DecodeData(t, resp, &scores)
+		return scores
+	}
+
+	first := getScore()
+	if first.Score != 43 || first.RulesVersion != "v1" || !first.AssessmentDue || len(first.Contributions) != 5 {
+		t.Fatalf("first = %+v", first)
+	}
+	// Reading the score does not record it
+	if scores := history(); len(scores) != 0 {
+		t.Fatalf("history after reading = %+v", scores)
+	}
+
+	req := testutil.NewRequest(t, http.MethodGet, "/api/patients/profile", nil, patient.UserID, "patient")
+	rec, resp := testutil.Serve(t, h.GetMyProfile, req)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+	var profile ProfileResponse
+	testutil.DecodeData(t, resp, &profile)
+	if profile.ID != patient.ID || profile.HealthScore.Score != 43 || len(profile.HealthScore.Contributions) != 5 {
+		t.Fatalf("profile = %+v", profile)
+	}
+
+	// The scheduled run records changes made outside the service; an
+	// unchanged score is not recorded twice
+	for i := 0; i < 2; i++ {
+		if failed, err := h.RecordScores(context.Background()); err != nil || failed != 0 {
+			t.Fatalf("RecordScores = %d, %v", failed, err)
+		}
+	}
+	if scores := history(); len(scores) != 1 || scores[0].Score != 43 {
+		t.Fatalf("history = %+v", scores)
+	}
+
+	db.AddVisit(patient.ID, time.Now().UTC().AddDate(0, -2, 0), "Annual check-up")
+
+	req = testutil.NewRequest(t, http.MethodPost, "/api/patients/assessments", AssessmentRequest{
+		GeneralHealth: 4, Energy: 4, Sleep: 3, Mood: 5, Pain: 1,
+	}, patient.UserID, "patient")
+	rec, resp = testutil.Serve(t, h.CreateAssessment, req)
+	testutil.ExpectStatus(t, rec, http.StatusCreated)
+
+	var created AssessmentResponse
+	testutil.DecodeData(t, resp, &created)
+	// 25 for the check-up, 15 + 10 for no other visits, 35 * 0.8125 for the
+	// assessment
+	if created.HealthScore.Score != 78 || created.HealthScore.AssessmentDue {
+		t.Fatalf("after assessment = %+v", created.HealthScore)
+	}
+
+	scores := history()
+	if len(scores) != 2 || scores[0].Score != 78 || scores[1].Score != 43 {
+		t.Fatalf("history = %+v", scores)
+	}
+
+	// Other inputs of the score record it as they change
+	req = testutil.NewRequest(t, http.MethodPost, "/api/patients/emergency-contacts", EmergencyContactRequest{
+		Name: "Sam", Relationship: "Sibling", Phone: "555-0100", IsPrimary: true,
+	}, patient.UserID, "patient")
+	rec, _ = testutil.Serve(t, h.EmergencyContacts().Create, req)
+	testutil.ExpectStatus(t, rec, http.StatusCreated)
+	req = testutil.NewRequest(t, http.MethodGet, "/api/patients/profile/score", nil, patient.UserID, "patient")
+	rec, resp = testutil.Serve(t, h.GetHealthScore, req)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+	var current HealthScoreResponse
+	testutil.DecodeData(t, resp, &current)
+	if scores := history(); len(scores) != 3 || scores[0].Score != current.Score || current.Score == 78 {
+		t.Fatalf("history = %+v, current = %d", scores, current.Score)
+	}
+}
+
+func TestCreateAssessmentValidation(t *testing.T) {
+	h, db := newTestHandler()
+	patient := db.AddPatient("p@test.com", "Pat")
+	doctor := db.AddDoctor("d@test.com", "Dr Who")
+
+	req := testutil.NewRequest(t, http.MethodPost, "/api/patients/assessments", AssessmentRequest{
+		GeneralHealth: 6, Energy: 3, Sleep: 3, Mood: 0, Pain: 1,
+	}, patient.UserID, "patient")
+	rec, resp := testutil.Serve(t, h.CreateAssessment, req)
+	testutil.ExpectStatus(t, rec, http.StatusBadRequest)
+	testutil.ExpectCode(t, resp, apperrors.CodeValidation)
+	if resp.Error != "Ratings must be between 1 and 5: general_health, mood" {
+		t.Fatalf("error = %q", resp.Error)
+	}
+
+	req = testutil.NewRequest(t, http.MethodGet, "/api/patients/profile/score", nil, doctor.UserID, "doctor")
+	rec, _ = testutil.Serve(t, h.GetHealthScore, req)
+	testutil.ExpectStatus(t, rec, http.StatusForbidden)
+
+	if err := h.UseScoreRules("v0"); err == nil {
+		t.Fatal("selected unknown rules v0")
+	}
+}
@@ -1,13 +1,17 @@
 package main
 
 import (
+    "context"
     "health-bar/shared/database"
-    "health-bar/shared/middleware"
+    "health-bar/shared/events"
+    "health-bar/shared/notify"
+    "health-bar/shared/webhooks"
     "health-bar/services/patient/handlers"
     "health-bar/services/patient/repository"
     "log"
     "net/http"
     "os"
+    "time"
     "github.com/gorilla/mux"
     "github.com/joho/godotenv"
     "github.com/rs/cors"
@@ -31,23 +35,50 @@ func main() {
 
     repo := repository.NewPatientRepository(db)
     handler := handlers.NewPatientHandler(repo)
+    handler.UseNotifier(notify.NewPostgresNotifier(db))
+    handler.UseWebhooks(webhooks.NewPostgresEmitter(db))
+    outbox := events.NewPostgresOutbox(db)
+    handler.UseOutbox(outbox)
+    if version := os.Getenv("HEALTH_SCORE_RULES"); version != "" {
+        if err := handler.UseScoreRules(version); err != nil {
+            log.Fatal(err)
+        }
+    }
 
-    router := mux.NewRouter()
+    // Events recorded in the outbox reach other services through NATS.
+    // OUTBOX_RELAY_INTERVAL=0 leaves them to another replica's relay.
+    bus, err := events.DialNATS(getEnv("NATS_URL", "nats://healthbar-nats:4222"), "patient-service")
+    if err != nil {
+        log.Fatal(err)
+    }
+    defer bus.Close()
+    interval, err := time.ParseDuration(getEnv("OUTBOX_RELAY_INTERVAL", "1s"))
+    if err != nil {
+        log.Fatal("Invalid OUTBOX_RELAY_INTERVAL:", err)
+    }
+    if interval > 0 {
+        go events.NewRelay(outbox, bus).RunEvery(context.Background(), interval)
+    }
 
-    // Patient profile routes (protected)
-    router.HandleFunc("/api/patients/profile", middleware.AuthMiddleware(handler.CreateProfile)).Methods("POST")
-    router.HandleFunc("/api/patients/profile", middleware.AuthMiddleware(handler.GetMyProfile)).Methods("GET")
-    router.HandleFunc("/api/patients/profile", middleware.AuthMiddleware(handler.UpdateProfile)).Methods("PUT")
+    // Scores are recorded as the patient's data changes here; visits, which
+    // other services record, and the passing of time are caught up with on
+    // a schedule. HEALTH_SCORE_INTERVAL=0 leaves that to another replica.
+    scoreInterval, err := time.ParseDuration(getEnv("HEALTH_SCORE_INTERVAL", "24h"))
+    if err != nil {
+        log.Fatal("Invalid HEALTH_SCORE_INTERVAL:", err)
+    }
+    if scoreInterval > 0 {
+        go handler.RecordScoresEvery(context.Background(), scoreInterval)
+    }
 
-    // Access permission routes (protected)
-    router.HandleFunc("/api/patients/permissions/grant", middleware.AuthMiddleware(handler.GrantAccess)).Methods("POST")
-    router.HandleFunc("/api/patients/permissions/revoke", middleware.AuthMiddleware(handler.RevokeAccess)).Methods("DELETE")
-    router.HandleFunc("/api/patients/permissions", middleware.AuthMiddleware(handler.ListPermissions)).Methods("GET")
+    router := mux.NewRouter()
+    handlers.RegisterRoutes(router, handler)
 
     c := cors.New(cors.Options{
         AllowedOrigins:   []string{"http://localhost:3000"},
-        AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-        AllowedHeaders:   []string{"Content-Type", "Authorization"},
+        AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+        AllowedHeaders:   []string{"Content-Type", "Authorization", "If-Match"},
+        ExposedHeaders:   []string{"ETag"},
         AllowCredentials: true,
     })
 
@@ -0,0 +1,340 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"health-bar/shared/memdb"
+	"health-bar/shared/models"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+func (r *MemoryRepository) ListAllergies(ctx context.Context, patientID string) ([]models.Allergy, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	return r.db.ClinicalRecord(patientID).Allergies, nil
+}
+
+func (r *MemoryRepository) GetAllergy(ctx context.Context, patientID, id string) (*models.Allergy, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	allergy, ok := r.db.Allergies.Rows[id]
+	if !ok || allergy.PatientID != patientID {
+		return nil, sql.ErrNoRows
+	}
+	return &allergy, nil
+}
+
+func (r *MemoryRepository) CreateAllergy(ctx context.Context, patientID string, allergy *models.Allergy) error {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	if _, ok := r.db.PatientProfiles.Rows[patientID]; !ok {
+		return memdb.ForeignKeyViolation("patient_allergies", "patient_allergies_patient_id_fkey")
+	}
+	if r.allergyExists(patientID, "", allergy.Substance) {
+		return memdb.UniqueViolation("uq_patient_allergies_substance")
+	}
+
+	now := r.db.Now()
+	allergy.ID = uuid.New().String()
+	allergy.PatientID = patientID
+	allergy.CreatedAt, allergy.UpdatedAt = now, now
+	r.db.Allergies.Rows[allergy.ID] = *allergy
+	return nil
+}
+
+func (r *MemoryRepository) UpdateAllergy(ctx context.Context, patientID, id string, allergy *models.Allergy) error {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	existing, ok := r.db.Allergies.Rows[id]
+	if !ok || existing.PatientID != patientID {
+		return sql.ErrNoRows
+	}
+	if r.allergyExists(patientID, id, allergy.Substance) {
+		return memdb.UniqueViolation("uq_patient_allergies_substance")
+	}
+
+	existing.Substance = allergy.Substance
+	existing.Reaction = allergy.Reaction
+	existing.Severity = allergy.Severity
+	existing.UpdatedAt = r.db.Now()
+	r.db.Allergies.Rows[id] = existing
+
+	*allergy = existing
+	return nil
+}
+
+// allergyExists mirrors uq_patient_allergies_substance
+func (r *MemoryRepository) allergyExists(patientID, exceptID, substance string) bool {
+	for id, a := range r.db.Allergies.Rows {
+		if id != exceptID && a.PatientID == patientID && strings.EqualFold(a.Substance, substance) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *MemoryRepository) DeleteAllergy(ctx context.Context, patientID, id string) error {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	if a, ok := r.db.Allergies.Rows[id]; !ok || a.PatientID != patientID {
+		return sql.ErrNoRows
+	}
+	delete(r.db.Allergies.Rows, id)
+	return nil
+}
+
+func (r *MemoryRepository) ListConditions(ctx context.Context, patientID string) ([]models.Condition, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	return r.db.ClinicalRecord(patientID).Conditions, nil
+}
+
+func (r *MemoryRepository) GetCondition(ctx context.Context, patientID, id string) (*models.Condition, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	condition, ok := r.db.Conditions.Rows[id]
+	if !ok || condition.PatientID != patientID {
+		return nil, sql.ErrNoRows
+	}
+	return &condition, nil
+}
+
+func (r *MemoryRepository) CreateCondition(ctx context.Context, patientID string, condition *models.Condition) error {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	if _, ok := r.db.PatientProfiles.Rows[patientID]; !ok {
+		return memdb.ForeignKeyViolation("patient_conditions", "patient_conditions_patient_id_fkey")
+	}
+
+	now := r.db.Now()
+	condition.ID = uuid.New().String()
+	condition.PatientID = patientID
+	condition.CreatedAt, condition.UpdatedAt = now, now
+	r.db.Conditions.Rows[condition.ID] = *condition
+	return nil
+}
+
+func (r *MemoryRepository) UpdateCondition(ctx context.Context, patientID, id string, condition *models.Condition) error {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	existing, ok := r.db.Conditions.Rows[id]
+	if !ok || existing.PatientID != patientID {
+		return sql.ErrNoRows
+	}
+
+	existing.Name = condition.Name
+	existing.Status = condition.Status
+	existing.DiagnosedOn = condition.DiagnosedOn
+	existing.Notes = condition.Notes
+	existing.UpdatedAt = r.db.Now()
+	r.db.Conditions.Rows[id] = existing
+
+	*condition = existing
+	return nil
+}
+
+func (r *MemoryRepository) DeleteCondition(ctx context.Context, patientID, id string) error {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	if c, ok := r.db.Conditions.Rows[id]; !ok || c.PatientID != patientID {
+		return sql.ErrNoRows
+	}
+	delete(r.db.Conditions.Rows, id)
+	return nil
+}
+
+func (r *MemoryRepository) ListMedications(ctx context.Context, patientID string) ([]models.Medication, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	return r.db.ClinicalRecord(patientID).Medications, nil
+}
+
+func (r *MemoryRepository) GetMedication(ctx context.Context, patientID, id string) (*models.Medication, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	medication, ok := r.db.Medications.Rows[id]
+	if !ok || medication.PatientID != patientID {
+		return nil, sql.ErrNoRows
+	}
+	return &medication, nil
+}
+
+func (r *MemoryRepository) CreateMedication(ctx context.Context, patientID string, medication *models.Medication) error {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	if _, ok := r.db.PatientProfiles.Rows[patientID]; !ok {
+		return memdb.ForeignKeyViolation("patient_medications", "patient_medications_patient_id_fkey")
+	}
+
+	now := r.db.Now()
+	medication.ID = uuid.New().String()
+	medication.PatientID = patientID
+	medication.CreatedAt, medication.UpdatedAt = now, now
+	r.db.Medications.Rows[medication.ID] = *medication
+	return nil
+}
+
+func (r *MemoryRepository) UpdateMedication(ctx context.Context, patientID, id string, medication *models.Medication) error {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	existing, ok := r.db.Medications.Rows[id]
+	if !ok || existing.PatientID != patientID {
+		return sql.ErrNoRows
+	}
+
+	existing.Name = medication.Name
+	existing.Dosage = medication.Dosage
+	existing.Frequency = medication.Frequency
+	existing.StartedOn = medication.StartedOn
+	existing.Notes = medication.Notes
+	existing.UpdatedAt = r.db.Now()
+	r.db.Medications.Rows[id] = existing
+
+	*medication = existing
+	return nil
+}
+
+func (r *MemoryRepository) DeleteMedication(ctx context.Context, patientID, id string) error {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	if m, ok := r.db.Medications.Rows[id]; !ok || m.PatientID != patientID {
+		return sql.ErrNoRows
+	}
+	delete(r.db.Medications.Rows, id)
+	return nil
+}
+
+func (r *MemoryRepository) ListEmergencyContacts(ctx context.Context, patientID string) ([]models.EmergencyContact, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	return r.db.ClinicalRecord(patientID).EmergencyContacts, nil
+}
+
+func (r *MemoryRepository) GetEmergencyContact(ctx context.Context, patientID, id string) (*models.EmergencyContact, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	contact, ok := r.db.EmergencyContacts.Rows[id]
+	if !ok || contact.PatientID != patientID {
+		return nil, sql.ErrNoRows
+	}
+	return &contact, nil
+}
+
+func (r *MemoryRepository) CreateEmergencyContact(ctx context.Context, patientID string, contact *models.EmergencyContact) error {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	if _, ok := r.db.PatientProfiles.Rows[patientID]; !ok {
+		return memdb.ForeignKeyViolation("emergency_contacts", "emergency_contacts_patient_id_fkey")
+	}
+
+	now := r.db.Now()
+	contact.ID = uuid.New().String()
+	contact.PatientID = patientID
+	contact.CreatedAt, contact.UpdatedAt = now, now
+	if contact.IsPrimary {
+		r.demotePrimaryContact(patientID, contact.ID)
+	}
+	r.db.EmergencyContacts.Rows[contact.ID] = *contact
+	return nil
+}
+
+func (r *MemoryRepository) UpdateEmergencyContact(ctx context.Context, patientID, id string, contact *models.EmergencyContact) error {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	existing, ok := r.db.EmergencyContacts.Rows[id]
+	if !ok || existing.PatientID != patientID {
+		return sql.ErrNoRows
+	}
+	if contact.IsPrimary {
+		r.demotePrimaryContact(patientID, id)
+	}
+
+	existing.Name = contact.Name
+	existing.Relationship = contact.Relationship
+	existing.Phone = contact.Phone
+	existing.Email = contact.Email
+	existing.IsPrimary = contact.IsPrimary
+	existing.UpdatedAt = r.db.Now()
+	r.db.EmergencyContacts.Rows[id] = existing
+
+	*contact = existing
+	return nil
+}
+
+func (r *MemoryRepository) demotePrimaryContact(patientID, exceptID string) {
+	now := r.db.Now()
+	for id, c := range r.db.EmergencyContacts.Rows {
+		if id != exceptID && c.PatientID == patientID && c.IsPrimary {
+			c.IsPrimary, c.UpdatedAt = false, now
+			r.db.EmergencyContacts.Rows[id] = c
+		}
+	}
+}
+
+func (r *MemoryRepository) DeleteEmergencyContact(ctx context.Context, patientID, id string) error {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	if c, ok := r.db.EmergencyContacts.Rows[id]; !ok || c.PatientID != patientID {
+		return sql.ErrNoRows
+	}
+	delete(r.db.EmergencyContacts.Rows, id)
+	return nil
+}
+
+func (r *MemoryRepository) GetBiometrics(ctx context.Context, patientID string) (*models.Biometrics, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	biometrics, ok := r.db.Biometrics.Rows[patientID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return &biometrics, nil
+}
+
+func (r *MemoryRepository) SaveBiometrics(ctx context.Context, patientID string, biometrics *models.Biometrics) error {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	if _, ok := r.db.PatientProfiles.Rows[patientID]; !ok {
+		return memdb.ForeignKeyViolation("patient_biometrics", "patient_biometrics_patient_id_fkey")
+	}
+
+	biometrics.PatientID = patientID
+	biometrics.UpdatedAt = r.db.Now()
+	r.db.Biometrics.Rows[patientID] = *biometrics
+	return nil
+}
+
+func (r *MemoryRepository) DeleteBiometrics(ctx context.Context, patientID string) error {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	if _, ok := r.db.Biometrics.Rows[patientID]; !ok {
+		return sql.ErrNoRows
+	}
+	delete(r.db.Biometrics.Rows, patientID)
+	return nil
+}
@@ -0,0 +1,356 @@
+package repository
+
+import (
+    "context"
+    "database/sql"
+    "health-bar/shared/database"
+    "health-bar/shared/models"
+    "github.com/google/uuid"
+)
+
+// Every clinical query is scoped by patient_id, so an id belonging to
+// another patient behaves exactly like one that does not exist.
+
+const (
+    allergyColumns          = `id, patient_id, substance, reaction, severity, created_at, updated_at`
+    conditionColumns        = `id, patient_id, name, status, diagnosed_on, notes, created_at, updated_at`
+    medicationColumns       = `id, patient_id, name, dosage, frequency, started_on, notes, created_at, updated_at`
+    emergencyContactColumns = `id, patient_id, name, relationship, phone, email, is_primary, created_at, updated_at`
+    biometricsColumns       = `patient_id, blood_type, height_cm, weight_kg, updated_at`
+)
+
+// ListAllergies lists a patient's allergies
+func (r *PatientRepository) ListAllergies(ctx context.Context, patientID string) ([]models.Allergy, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    allergies := []models.Allergy{}
+    query := `SELECT ` + allergyColumns + ` FROM patient_allergies WHERE patient_id = $1 ORDER BY lower(substance)`
+    if err := database.Conn(ctx, r.db).SelectContext(ctx, &allergies, query, patientID); err != nil {
+        return nil, err
+    }
+    return allergies, nil
+}
+
+// GetAllergy gets one of a patient's allergies
+func (r *PatientRepository) GetAllergy(ctx context.Context, patientID, id string) (*models.Allergy, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    allergy := &models.Allergy{}
+    query := `SELECT ` + allergyColumns + ` FROM patient_allergies WHERE id = $1 AND patient_id = $2`
+    if err := database.Conn(ctx, r.db).GetContext(ctx, allergy, query, id, patientID); err != nil {
+        return nil, err
+    }
+    return allergy, nil
+}
+
+// CreateAllergy records an allergy
+func (r *PatientRepository) CreateAllergy(ctx context.Context, patientID string, allergy *models.Allergy) error {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    query := `
+        INSERT INTO patient_allergies (id, patient_id, substance, reaction, severity)
+        VALUES ($1, $2, $3, $4, $5)
+        RETURNING ` + allergyColumns
+
+    return database.Conn(ctx, r.db).QueryRowxContext(ctx, query,
+        uuid.New().String(), patientID, allergy.Substance, allergy.Reaction, allergy.Severity,
+    ).StructScan(allergy)
+}
+
+// UpdateAllergy replaces an allergy
+func (r *PatientRepository) UpdateAllergy(ctx context.Context, patientID, id string, allergy *models.Allergy) error {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    query := `
+        UPDATE patient_allergies
+        SET substance = $1, reaction = $2, severity = $3, updated_at = NOW()
+        WHERE id = $4 AND patient_id = $5
+        RETURNING ` + allergyColumns
+
+    return database.Conn(ctx, r.db).QueryRowxContext(ctx, query,
+        allergy.Substance, allergy.Reaction, allergy.Severity, id, patientID,
+    ).StructScan(allergy)
+}
+
+// DeleteAllergy deletes an allergy
+func (r *PatientRepository) DeleteAllergy(ctx context.Context, patientID, id string) error {
+    return r.deleteClinical(ctx, `DELETE FROM patient_allergies WHERE id = $1 AND patient_id = $2`, id, patientID)
+}
+
+// ListConditions lists a patient's chronic conditions
+func (r *PatientRepository) ListConditions(ctx context.Context, patientID string) ([]models.Condition, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    conditions := []models.Condition{}
+    query := `SELECT ` + conditionColumns + ` FROM patient_conditions WHERE patient_id = $1 ORDER BY created_at, id`
+    if err := database.Conn(ctx, r.db).SelectContext(ctx, &conditions, query, patientID); err != nil {
+        return nil, err
+    }
+    return conditions, nil
+}
+
+// GetCondition gets one of a patient's conditions
+func (r *PatientRepository) GetCondition(ctx context.Context, patientID, id string) (*models.Condition, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    condition := &models.Condition{}
+    query := `SELECT ` + conditionColumns + ` FROM patient_conditions WHERE id = $1 AND patient_id = $2`
+    if err := database.Conn(ctx, r.db).GetContext(ctx, condition, query, id, patientID); err != nil {
+        return nil, err
+    }
+    return condition, nil
+}
+
+// CreateCondition records a condition
+func (r *PatientRepository) CreateCondition(ctx context.Context, patientID string, condition *models.Condition) error {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    query := `
+        INSERT INTO patient_conditions (id, patient_id, name, status, diagnosed_on, notes)
+        VALUES ($1, $2, $3, $4, $5, $6)
+        RETURNING ` + conditionColumns
+
+    return database.Conn(ctx, r.db).QueryRowxContext(ctx, query,
+        uuid.New().String(), patientID, condition.Name, condition.Status, condition.DiagnosedOn, condition.Notes,
+    ).StructScan(condition)
+}
+
+// UpdateCondition replaces a condition
+func (r *PatientRepository) UpdateCondition(ctx context.Context, patientID, id string, condition *models.Condition) error {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    query := `
+        UPDATE patient_conditions
+        SET name = $1, status = $2, diagnosed_on = $3, notes = $4, updated_at = NOW()
+        WHERE id = $5 AND patient_id = $6
+        RETURNING ` + conditionColumns
+
+    return database.Conn(ctx, r.db).QueryRowxContext(ctx, query,
+        condition.Name, condition.Status, condition.DiagnosedOn, condition.Notes, id, patientID,
+    ).StructScan(condition)
+}
+
+// DeleteCondition deletes a condition
+func (r *PatientRepository) DeleteCondition(ctx context.Context, patientID, id string) error {
+    return r.deleteClinical(ctx, `DELETE FROM patient_conditions WHERE id = $1 AND patient_id = $2`, id, patientID)
+}
+
+// ListMedications lists a patient's current medications
+func (r *PatientRepository) ListMedications(ctx context.Context, patientID string) ([]models.Medication, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    medications := []models.Medication{}
+    query := `SELECT ` + medicationColumns + ` FROM patient_medications WHERE patient_id = $1 ORDER BY lower(name), id`
+    if err := database.Conn(ctx, r.db).SelectContext(ctx, &medications, query, patientID); err != nil {
+        return nil, err
+    }
+    return medications, nil
+}
+
+// GetMedication gets one of a patient's medications
+func (r *PatientRepository) GetMedication(ctx context.Context, patientID, id string) (*models.Medication, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    medication := &models.Medication{}
+    query := `SELECT ` + medicationColumns + ` FROM patient_medications WHERE id = $1 AND patient_id = $2`
+    if err := database.Conn(ctx, r.db).GetContext(ctx, medication, query, id, patientID); err != nil {
+        return nil, err
+    }
+    return medication, nil
+}
+
+// CreateMedication records a medication
+func (r *PatientRepository) CreateMedication(ctx context.Context, patientID string, medication *models.Medication) error {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    query := `
+        INSERT INTO patient_medications (id, patient_id, name, dosage, frequency, started_on, notes)
+        VALUES ($1, $2, $3, $4, $5, $6, $7)
+        RETURNING ` + medicationColumns
+
+    return database.Conn(ctx, r.db).QueryRowxContext(ctx, query,
+        uuid.New().String(), patientID, medication.Name, medication.Dosage, medication.Frequency,
+        medication.StartedOn, medication.Notes,
+    ).StructScan(medication)
+}
+
+// UpdateMedication replaces a medication
+func (r *PatientRepository) UpdateMedication(ctx context.Context, patientID, id string, medication *models.Medication) error {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    query := `
+        UPDATE patient_medications
+        SET name = $1, dosage = $2, frequency = $3, started_on = $4, notes = $5, updated_at = NOW()
+        WHERE id = $6 AND patient_id = $7
+        RETURNING ` + medicationColumns
+
+    return database.Conn(ctx, r.db).QueryRowxContext(ctx, query,
+        medication.Name, medication.Dosage, medication.Frequency, medication.StartedOn, medication.Notes,
+        id, patientID,
+    ).StructScan(medication)
+}
+
+// DeleteMedication deletes a medication
+func (r *PatientRepository) DeleteMedication(ctx context.Context, patientID, id string) error {
+    return r.deleteClinical(ctx, `DELETE FROM patient_medications WHERE id = $1 AND patient_id = $2`, id, patientID)
+}
+
+// ListEmergencyContacts lists a patient's emergency contacts, primary first
+func (r *PatientRepository) ListEmergencyContacts(ctx context.Context, patientID string) ([]models.EmergencyContact, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    contacts := []models.EmergencyContact{}
+    query := `SELECT ` + emergencyContactColumns + ` FROM emergency_contacts WHERE patient_id = $1 ORDER BY is_primary DESC, created_at, id`
+    if err := database.Conn(ctx, r.db).SelectContext(ctx, &contacts, query, patientID); err != nil {
+        return nil, err
+    }
+    return contacts, nil
+}
+
+// GetEmergencyContact gets one of a patient's emergency contacts
+func (r *PatientRepository) GetEmergencyContact(ctx context.Context, patientID, id string) (*models.EmergencyContact, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    contact := &models.EmergencyContact{}
+    query := `SELECT ` + emergencyContactColumns + ` FROM emergency_contacts WHERE id = $1 AND patient_id = $2`
+    if err := database.Conn(ctx, r.db).GetContext(ctx, contact, query, id, patientID); err != nil {
+        return nil, err
+    }
+    return contact, nil
+}
+
+// CreateEmergencyContact records an emergency contact. A new primary contact
+// demotes the previous one.
+func (r *PatientRepository) CreateEmergencyContact(ctx context.Context, patientID string, contact *models.EmergencyContact) error {
+    return r.WithTx(ctx, func(ctx context.Context) error {
+        id := uuid.New().String()
+        if contact.IsPrimary {
+            if err := r.demotePrimaryContact(ctx, patientID, id); err != nil {
+                return err
+            }
+        }
+
+        ctx, cancel := database.WithTimeout(ctx)
+        defer cancel()
+
+        query := `
+            INSERT INTO emergency_contacts (id, patient_id, name, relationship, phone, email, is_primary)
+            VALUES ($1, $2, $3, $4, $5, $6, $7)
+            RETURNING ` + emergencyContactColumns
+
+        return database.Conn(ctx, r.db).QueryRowxContext(ctx, query,
+            id, patientID, contact.Name, contact.Relationship, contact.Phone, contact.Email, contact.IsPrimary,
+        ).StructScan(contact)
+    })
+}
+
+// UpdateEmergencyContact replaces an emergency contact. Making it primary
+// demotes the previous primary contact.
+func (r *PatientRepository) UpdateEmergencyContact(ctx context.Context, patientID, id string, contact *models.EmergencyContact) error {
+    return r.WithTx(ctx, func(ctx context.Context) error {
+        if contact.IsPrimary {
+            if err := r.demotePrimaryContact(ctx, patientID, id); err != nil {
+                return err
+            }
+        }
+
+        ctx, cancel := database.WithTimeout(ctx)
+        defer cancel()
+
+        query := `
+            UPDATE emergency_contacts
+            SET name = $1, relationship = $2, phone = $3, email = $4, is_primary = $5, updated_at = NOW()
+            WHERE id = $6 AND patient_id = $7
+            RETURNING ` + emergencyContactColumns
+
+        return database.Conn(ctx, r.db).QueryRowxContext(ctx, query,
+            contact.Name, contact.Relationship, contact.Phone, contact.Email, contact.IsPrimary, id, patientID,
+        ).StructScan(contact)
+    })
+}
+
+func (r *PatientRepository) demotePrimaryContact(ctx context.Context, patientID, exceptID string) error {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    query := `
+        UPDATE emergency_contacts
+        SET is_primary = false, updated_at = NOW()
+        WHERE patient_id = $1 AND is_primary AND id <> $2
+    `
+    _, err := database.Conn(ctx, r.db).ExecContext(ctx, query, patientID, exceptID)
+    return err
+}
+
+// DeleteEmergencyContact deletes an emergency contact
+func (r *PatientRepository) DeleteEmergencyContact(ctx context.Context, patientID, id string) error {
+    return r.deleteClinical(ctx, `DELETE FROM emergency_contacts WHERE id = $1 AND patient_id = $2`, id, patientID)
+}
+
+// GetBiometrics gets a patient's biometrics
+func (r *PatientRepository) GetBiometrics(ctx context.Context, patientID string) (*models.Biometrics, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    biometrics := &models.Biometrics{}
+    query := `SELECT ` + biometricsColumns + ` FROM patient_biometrics WHERE patient_id = $1`
+    if err := database.Conn(ctx, r.db).GetContext(ctx, biometrics, query, patientID); err != nil {
+        return nil, err
+    }
+    return biometrics, nil
+}
+
+// SaveBiometrics creates or replaces a patient's biometrics
+func (r *PatientRepository) SaveBiometrics(ctx context.Context, patientID string, biometrics *models.Biometrics) error {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    query := `
+        INSERT INTO patient_biometrics (patient_id, blood_type, height_cm, weight_kg)
+        VALUES ($1, $2, $3, $4)
+        ON CONFLICT (patient_id)
+        DO UPDATE SET blood_type = EXCLUDED.blood_type, height_cm = EXCLUDED.height_cm,
+            weight_kg = EXCLUDED.weight_kg, updated_at = NOW()
+        RETURNING ` + biometricsColumns
+
+    return database.Conn(ctx, r.db).QueryRowxContext(ctx, query,
+        patientID, biometrics.BloodType, biometrics.HeightCM, biometrics.WeightKG,
+    ).StructScan(biometrics)
+}
+
+// DeleteBiometrics deletes a patient's biometrics
+func (r *PatientRepository) DeleteBiometrics(ctx context.Context, patientID string) error {
+    return r.deleteClinical(ctx, `DELETE FROM patient_biometrics WHERE patient_id = $1`, patientID)
+}
+
+// deleteClinical runs a single-row delete, returning sql.ErrNoRows when
+// nothing matched
+func (r *PatientRepository) deleteClinical(ctx context.Context, query string, args ...interface{}) error {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    result, err := database.Conn(ctx, r.db).ExecContext(ctx, query, args...)
+    if err != nil {
+        return err
+    }
+    if n, err := result.RowsAffected(); err != nil {
+        return err
+    } else if n == 0 {
+        return sql.ErrNoRows
+    }
+    return nil
+}
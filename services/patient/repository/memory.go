@@ -0,0 +1,190 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"health-bar/shared/database"
+	"health-bar/shared/memdb"
+	"health-bar/shared/models"
+	"health-bar/shared/pagination"
+
+	"github.com/google/uuid"
+)
+
+// MemoryRepository implements Store on top of memdb.
+type MemoryRepository struct {
+	db *memdb.DB
+}
+
+func NewMemoryRepository(db *memdb.DB) *MemoryRepository {
+	return &MemoryRepository{db: db}
+}
+
+func (r *MemoryRepository) WithTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	return r.db.WithTx(ctx, fn)
+}
+
+func (r *MemoryRepository) CreateProfile(ctx context.Context, userID string, profile *models.PatientProfile) error {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	if _, ok := r.db.Users.Rows[userID]; !ok {
+		return memdb.ForeignKeyViolation("patient_profiles", "patient_profiles_user_id_fkey")
+	}
+	if _, ok := r.db.PatientByUserID(userID); ok {
+		return memdb.UniqueViolation("uq_patient_profiles_user_id")
+	}
+
+	now := r.db.Now()
+	profile.ID = uuid.New().String()
+	profile.UserID = userID
+	profile.CreatedAt, profile.UpdatedAt = now, now
+	profile.Version = 1
+	r.db.PatientProfiles.Rows[profile.ID] = *profile
+	return nil
+}
+
+func (r *MemoryRepository) GetProfileByUserID(ctx context.Context, userID string) (*models.PatientProfile, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	profile, ok := r.db.PatientByUserID(userID)
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return &profile, nil
+}
+
+func (r *MemoryRepository) GetProfileByID(ctx context.Context, profileID string) (*models.PatientProfile, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	profile, ok := r.db.PatientProfiles.Rows[profileID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return &profile, nil
+}
+
+func (r *MemoryRepository) UpdateProfile(ctx context.Context, userID string, profile *models.PatientProfile) error {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	existing, ok := r.db.PatientByUserID(userID)
+	if !ok {
+		return sql.ErrNoRows
+	}
+	if profile.Version != 0 && profile.Version != existing.Version {
+		return database.ErrVersionConflict
+	}
+
+	existing.FullName = profile.FullName
+	existing.DateOfBirth = profile.DateOfBirth
+	existing.Gender = profile.Gender
+	existing.Phone = profile.Phone
+	existing.Address = profile.Address
+	existing.UpdatedAt = r.db.Now()
+	existing.Version++
+	r.db.PatientProfiles.Rows[existing.ID] = existing
+
+	*profile = existing
+	return nil
+}
+
+func (r *MemoryRepository) DeleteProfile(ctx context.Context, profileID string) error {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	if !r.db.DeletePatientProfile(profileID) {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (r *MemoryRepository) GrantAccess(ctx context.Context, patientID, doctorID string) error {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	return r.db.UpsertPermission(patientID, doctorID)
+}
+
+func (r *MemoryRepository) RevokeAccess(ctx context.Context, patientID, doctorID string) error {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	p, ok := r.db.Permission(patientID, doctorID)
+	if !ok {
+		return nil // UPDATE matching no rows is not an error
+	}
+	now := r.db.Now()
+	p.IsActive, p.RevokedAt = false, &now
+	r.db.AccessPermissions.Rows[p.ID] = p
+	return nil
+}
+
+func (r *MemoryRepository) FreezeThread(ctx context.Context, patientID, doctorID string) error {
+	return r.setThreadFrozen(patientID, doctorID, true)
+}
+
+func (r *MemoryRepository) UnfreezeThread(ctx context.Context, patientID, doctorID string) error {
+	return r.setThreadFrozen(patientID, doctorID, false)
+}
+
+func (r *MemoryRepository) setThreadFrozen(patientID, doctorID string, frozen bool) error {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	now := r.db.Now()
+	for id, t := range r.db.MessageThreads.Rows {
+		if t.PatientID != patientID || t.DoctorID != doctorID || (t.FrozenAt != nil) == frozen {
+			continue
+		}
+		t.FrozenAt, t.UpdatedAt = nil, now
+		if frozen {
+			t.FrozenAt = &now
+		}
+		r.db.MessageThreads.Rows[id] = t
+	}
+	return nil
+}
+
+func (r *MemoryRepository) GetDoctorUserID(ctx context.Context, doctorID string) (string, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	doctor, ok := r.db.DoctorProfiles.Rows[doctorID]
+	if !ok {
+		return "", sql.ErrNoRows
+	}
+	return doctor.UserID, nil
+}
+
+func (r *MemoryRepository) ListPermissions(ctx context.Context, patientID string, page PermissionPage) ([]models.DoctorAccessPermission, string, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	active, filterActive := activeFilter(page)
+	var permissions []models.DoctorAccessPermission
+	for _, p := range r.db.AccessPermissions.Rows {
+		if p.PatientID != patientID || !page.InRange(p.GrantedAt) {
+			continue
+		}
+		if filterActive && p.IsActive != active {
+			continue
+		}
+		permissions = append(permissions, p)
+	}
+	permissions, next := pagination.Apply(permissions, page)
+	return permissions, next, nil
+}
+
+func (r *MemoryRepository) CheckAccess(ctx context.Context, patientID, doctorID string) (bool, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	p, ok := r.db.Permission(patientID, doctorID)
+	if !ok {
+		return false, sql.ErrNoRows
+	}
+	return p.IsActive, nil
+}
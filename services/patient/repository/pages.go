@@ -0,0 +1,64 @@
+package repository
+
+import (
+	"health-bar/shared/models"
+	"health-bar/shared/pagination"
+)
+
+// PermissionPage is a page request for a patient's access permissions.
+type PermissionPage = pagination.Params[models.DoctorAccessPermission]
+
+// PermissionPages lists permissions most recently granted first by default.
+// from and to bound the grant date; active=true or active=false keeps only
+// active or revoked grants.
+var PermissionPages = &pagination.Spec[models.DoctorAccessPermission]{
+	Sorts: []pagination.Sort[models.DoctorAccessPermission]{
+		{Name: "granted_at", Column: "granted_at", Cast: "timestamp",
+			Value: func(p models.DoctorAccessPermission) string { return pagination.Timestamp(p.GrantedAt) }},
+	},
+	Default:   "-granted_at",
+	Filters:   []string{"active"},
+	DateRange: true,
+	ID:        func(p models.DoctorAccessPermission) string { return p.ID },
+}
+
+// activeFilter reports the requested is_active value, if any.
+func activeFilter(page PermissionPage) (active, ok bool) {
+	switch page.Filters["active"] {
+	case "true":
+		return true, true
+	case "false":
+		return false, true
+	}
+	return false, false
+}
+
+// AssessmentPage is a page request for a patient's self-assessments.
+type AssessmentPage = pagination.Params[models.SelfAssessment]
+
+// AssessmentPages lists self-assessments newest first. from and to bound
+// the date they were submitted.
+var AssessmentPages = &pagination.Spec[models.SelfAssessment]{
+	Sorts: []pagination.Sort[models.SelfAssessment]{
+		{Name: "created_at", Column: "created_at", Cast: "timestamp",
+			Value: func(a models.SelfAssessment) string { return pagination.Timestamp(a.CreatedAt) }},
+	},
+	Default:   "-created_at",
+	DateRange: true,
+	ID:        func(a models.SelfAssessment) string { return a.ID },
+}
+
+// ScorePage is a page request for a patient's health score history.
+type ScorePage = pagination.Params[models.HealthScore]
+
+// ScorePages lists recorded health scores newest first. from and to bound
+// the date they were computed.
+var ScorePages = &pagination.Spec[models.HealthScore]{
+	Sorts: []pagination.Sort[models.HealthScore]{
+		{Name: "computed_at", Column: "computed_at", Cast: "timestamp",
+			Value: func(s models.HealthScore) string { return pagination.Timestamp(s.ComputedAt) }},
+	},
+	Default:   "-computed_at",
+	DateRange: true,
+	ID:        func(s models.HealthScore) string { return s.ID },
+}
@@ -1,7 +1,11 @@
 package repository
 
 import (
+    "context"
+    "database/sql"
+    "health-bar/shared/database"
     "health-bar/shared/models"
+    "health-bar/shared/pagination"
     "github.com/jmoiron/sqlx"
     "github.com/google/uuid"
 )
@@ -14,32 +18,43 @@ func NewPatientRepository(db *sqlx.DB) *PatientRepository {
     return &PatientRepository{db: db}
 }
 
+// WithTx runs fn in a transaction shared by every repository call made with its context
+func (r *PatientRepository) WithTx(ctx context.Context, fn func(ctx context.Context) error) error {
+    return database.WithTx(ctx, r.db, fn)
+}
+
 // CreateProfile creates a patient profile
-func (r *PatientRepository) CreateProfile(userID string, profile *models.PatientProfile) error {
+func (r *PatientRepository) CreateProfile(ctx context.Context, userID string, profile *models.PatientProfile) error {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
     profile.ID = uuid.New().String()
     profile.UserID = userID
 
     query := `
         INSERT INTO patient_profiles (id, user_id, full_name, date_of_birth, gender, phone, address)
         VALUES ($1, $2, $3, $4, $5, $6, $7)
-        RETURNING id, user_id, full_name, date_of_birth, gender, phone, address, created_at, updated_at
+        RETURNING id, user_id, full_name, date_of_birth, gender, phone, address, created_at, updated_at, version
     `
 
-    return r.db.QueryRowx(query,
+    return database.Conn(ctx, r.db).QueryRowxContext(ctx, query,
         profile.ID, profile.UserID, profile.FullName, profile.DateOfBirth,
         profile.Gender, profile.Phone, profile.Address,
     ).StructScan(profile)
 }
 
 // GetProfileByUserID gets patient profile by user ID
-func (r *PatientRepository) GetProfileByUserID(userID string) (*models.PatientProfile, error) {
+func (r *PatientRepository) GetProfileByUserID(ctx context.Context, userID string) (*models.PatientProfile, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
     profile := &models.PatientProfile{}
     query := `
-        SELECT id, user_id, full_name, date_of_birth, gender, phone, address, created_at, updated_at
+        SELECT id, user_id, full_name, date_of_birth, gender, phone, address, created_at, updated_at, version
         FROM patient_profiles
         WHERE user_id = $1
     `
-    err := r.db.Get(profile, query, userID)
+    err := database.Conn(ctx, r.db).GetContext(ctx, profile, query, userID)
     if err != nil {
         return nil, err
     }
@@ -47,14 +62,17 @@ func (r *PatientRepository) GetProfileByUserID(userID string) (*models.PatientPr
 }
 
 // GetProfileByID gets patient profile by profile ID
-func (r *PatientRepository) GetProfileByID(profileID string) (*models.PatientProfile, error) {
+func (r *PatientRepository) GetProfileByID(ctx context.Context, profileID string) (*models.PatientProfile, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
     profile := &models.PatientProfile{}
     query := `
-        SELECT id, user_id, full_name, date_of_birth, gender, phone, address, created_at, updated_at
+        SELECT id, user_id, full_name, date_of_birth, gender, phone, address, created_at, updated_at, version
         FROM patient_profiles
         WHERE id = $1
     `
-    err := r.db.Get(profile, query, profileID)
+    err := database.Conn(ctx, r.db).GetContext(ctx, profile, query, profileID)
     if err != nil {
         return nil, err
     }
@@ -62,22 +80,50 @@ func (r *PatientRepository) GetProfileByID(profileID string) (*models.PatientPro
 }
 
 // UpdateProfile updates patient profile
-func (r *PatientRepository) UpdateProfile(userID string, profile *models.PatientProfile) error {
+func (r *PatientRepository) UpdateProfile(ctx context.Context, userID string, profile *models.PatientProfile) error {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
     query := `
         UPDATE patient_profiles
-        SET full_name = $1, date_of_birth = $2, gender = $3, phone = $4, address = $5, updated_at = NOW()
-        WHERE user_id = $6
-        RETURNING id, user_id, full_name, date_of_birth, gender, phone, address, created_at, updated_at
+        SET full_name = $1, date_of_birth = $2, gender = $3, phone = $4, address = $5,
+            version = version + 1, updated_at = NOW()
+        WHERE user_id = $6 AND ($7 = 0 OR version = $7)
+        RETURNING id, user_id, full_name, date_of_birth, gender, phone, address, created_at, updated_at, version
     `
 
-    return r.db.QueryRowx(query,
+    // profile.Version is the version the caller read; zero skips the check
+    expected := profile.Version
+    conn := database.Conn(ctx, r.db)
+    err := conn.QueryRowxContext(ctx, query,
         profile.FullName, profile.DateOfBirth, profile.Gender,
-        profile.Phone, profile.Address, userID,
+        profile.Phone, profile.Address, userID, expected,
     ).StructScan(profile)
+    return database.VersionError(ctx, conn, err, expected,
+        `SELECT EXISTS (SELECT 1 FROM patient_profiles WHERE user_id = $1)`, userID)
+}
+
+// DeleteProfile deletes a patient profile. Everything that references it
+// is deleted with it by cascading foreign keys.
+func (r *PatientRepository) DeleteProfile(ctx context.Context, profileID string) error {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    result, err := database.Conn(ctx, r.db).ExecContext(ctx, `DELETE FROM patient_profiles WHERE id = $1`, profileID)
+    if err != nil {
+        return err
+    }
+    if n, _ := result.RowsAffected(); n == 0 {
+        return sql.ErrNoRows
+    }
+    return nil
 }
 
 // GrantAccess grants a doctor access to patient's records
-func (r *PatientRepository) GrantAccess(patientID, doctorID string) error {
+func (r *PatientRepository) GrantAccess(ctx context.Context, patientID, doctorID string) error {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
     permission := &models.DoctorAccessPermission{
         ID:        uuid.New().String(),
         PatientID: patientID,
@@ -92,38 +138,101 @@ func (r *PatientRepository) GrantAccess(patientID, doctorID string) error {
         DO UPDATE SET is_active = true, revoked_at = NULL, granted_at = NOW()
     `
 
-    _, err := r.db.Exec(query, permission.ID, permission.PatientID, permission.DoctorID, permission.IsActive)
+    _, err := database.Conn(ctx, r.db).ExecContext(ctx, query, permission.ID, permission.PatientID, permission.DoctorID, permission.IsActive)
     return err
 }
 
 // RevokeAccess revokes a doctor's access to patient's records
-func (r *PatientRepository) RevokeAccess(patientID, doctorID string) error {
+func (r *PatientRepository) RevokeAccess(ctx context.Context, patientID, doctorID string) error {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
     query := `
         UPDATE doctor_access_permissions
         SET is_active = false, revoked_at = NOW()
         WHERE patient_id = $1 AND doctor_id = $2
     `
 
-    _, err := r.db.Exec(query, patientID, doctorID)
+    _, err := database.Conn(ctx, r.db).ExecContext(ctx, query, patientID, doctorID)
+    return err
+}
+
+// FreezeThread closes the message thread between a patient and a doctor, if
+// there is one, to new messages
+func (r *PatientRepository) FreezeThread(ctx context.Context, patientID, doctorID string) error {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    query := `
+        UPDATE message_threads
+        SET frozen_at = NOW(), updated_at = NOW()
+        WHERE patient_id = $1 AND doctor_id = $2 AND frozen_at IS NULL
+    `
+
+    _, err := database.Conn(ctx, r.db).ExecContext(ctx, query, patientID, doctorID)
+    return err
+}
+
+// UnfreezeThread reopens the message thread between a patient and a doctor
+func (r *PatientRepository) UnfreezeThread(ctx context.Context, patientID, doctorID string) error {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    query := `
+        UPDATE message_threads
+        SET frozen_at = NULL, updated_at = NOW()
+        WHERE patient_id = $1 AND doctor_id = $2 AND frozen_at IS NOT NULL
+    `
+
+    _, err := database.Conn(ctx, r.db).ExecContext(ctx, query, patientID, doctorID)
     return err
 }
 
-// ListPermissions lists all doctors who have access to patient's records
-func (r *PatientRepository) ListPermissions(patientID string) ([]models.DoctorAccessPermission, error) {
+// GetDoctorUserID gets the user account of a doctor profile
+func (r *PatientRepository) GetDoctorUserID(ctx context.Context, doctorID string) (string, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    var userID string
+    query := `SELECT user_id FROM doctor_profiles WHERE id = $1`
+    err := database.Conn(ctx, r.db).GetContext(ctx, &userID, query, doctorID)
+    return userID, err
+}
+
+// ListPermissions gets a page of the doctors who have or had access to patient's records and the cursor of the next page
+func (r *PatientRepository) ListPermissions(ctx context.Context, patientID string, page PermissionPage) ([]models.DoctorAccessPermission, string, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    q := &pagination.Query{}
+    q.Where("patient_id = " + q.Arg(patientID))
+    if !page.From.IsZero() {
+        q.Where("granted_at >= " + q.Arg(pagination.Timestamp(page.From)) + "::timestamp")
+    }
+    if !page.To.IsZero() {
+        q.Where("granted_at <= " + q.Arg(pagination.Timestamp(page.ToEnd())) + "::timestamp")
+    }
+    if active, ok := activeFilter(page); ok {
+        q.Where("is_active = " + q.Arg(active))
+    }
+
     var permissions []models.DoctorAccessPermission
     query := `
         SELECT id, patient_id, doctor_id, granted_at, revoked_at, is_active
-        FROM doctor_access_permissions
-        WHERE patient_id = $1
-        ORDER BY granted_at DESC
-    `
+        FROM doctor_access_permissions` + page.Clauses(q)
 
-    err := r.db.Select(&permissions, query, patientID)
-    return permissions, err
+    if err := database.Conn(ctx, r.db).SelectContext(ctx, &permissions, query, q.Args()...); err != nil {
+        return nil, "", err
+    }
+    permissions, next := pagination.Page(permissions, page)
+    return permissions, next, nil
 }
 
 // CheckAccess checks if a doctor has access to a patient's records
-func (r *PatientRepository) CheckAccess(patientID, doctorID string) (bool, error) {
+func (r *PatientRepository) CheckAccess(ctx context.Context, patientID, doctorID string) (bool, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
     var isActive bool
     query := `
         SELECT is_active
@@ -131,7 +240,7 @@ func (r *PatientRepository) CheckAccess(patientID, doctorID string) (bool, error
         WHERE patient_id = $1 AND doctor_id = $2
     `
 
-    err := r.db.Get(&isActive, query, patientID, doctorID)
+    err := database.Conn(ctx, r.db).GetContext(ctx, &isActive, query, patientID, doctorID)
     if err != nil {
         return false, err
     }
@@ -0,0 +1,140 @@
+package repository
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"slices"
+	"time"
+
+	"health-bar/shared/memdb"
+	"health-bar/shared/models"
+	"health-bar/shared/pagination"
+
+	"github.com/google/uuid"
+)
+
+func (r *MemoryRepository) CreateAssessment(ctx context.Context, patientID string, assessment *models.SelfAssessment) error {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	if _, ok := r.db.PatientProfiles.Rows[patientID]; !ok {
+		return memdb.ForeignKeyViolation("patient_self_assessments", "patient_self_assessments_patient_id_fkey")
+	}
+
+	assessment.ID = uuid.New().String()
+	assessment.PatientID = patientID
+	assessment.CreatedAt = r.db.Now()
+	r.db.SelfAssessments.Rows[assessment.ID] = *assessment
+	return nil
+}
+
+func (r *MemoryRepository) ListAssessments(ctx context.Context, patientID string, page AssessmentPage) ([]models.SelfAssessment, string, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	var assessments []models.SelfAssessment
+	for _, a := range r.db.SelfAssessments.Rows {
+		if a.PatientID == patientID && page.InRange(a.CreatedAt) {
+			assessments = append(assessments, a)
+		}
+	}
+	assessments, next := pagination.Apply(assessments, page)
+	return assessments, next, nil
+}
+
+func (r *MemoryRepository) RecentAssessments(ctx context.Context, patientID string, since time.Time) ([]models.SelfAssessment, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	assessments := []models.SelfAssessment{}
+	for _, a := range r.db.SelfAssessments.Rows {
+		if a.PatientID == patientID && !a.CreatedAt.Before(since) {
+			assessments = append(assessments, a)
+		}
+	}
+	slices.SortFunc(assessments, func(a, b models.SelfAssessment) int {
+		return cmp.Or(b.CreatedAt.Compare(a.CreatedAt), cmp.Compare(b.ID, a.ID))
+	})
+	return assessments, nil
+}
+
+func (r *MemoryRepository) GetVisitsSince(ctx context.Context, patientID string, since time.Time) ([]models.HospitalVisit, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	since = time.Date(since.Year(), since.Month(), since.Day(), 0, 0, 0, 0, time.UTC)
+	visits := []models.HospitalVisit{}
+	for _, v := range r.db.HospitalVisits.Rows {
+		if v.PatientID == patientID && !v.VisitDate.Before(since) {
+			visits = append(visits, v)
+		}
+	}
+	slices.SortFunc(visits, func(a, b models.HospitalVisit) int {
+		return cmp.Or(b.VisitDate.Compare(a.VisitDate), b.CreatedAt.Compare(a.CreatedAt))
+	})
+	return visits, nil
+}
+
+func (r *MemoryRepository) RecordHealthScore(ctx context.Context, score *models.HealthScore) error {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	if _, ok := r.db.PatientProfiles.Rows[score.PatientID]; !ok {
+		return memdb.ForeignKeyViolation("health_scores", "health_scores_patient_id_fkey")
+	}
+
+	score.ID = uuid.New().String()
+	r.db.HealthScores.Rows[score.ID] = *score
+	return nil
+}
+
+func (r *MemoryRepository) LatestHealthScore(ctx context.Context, patientID string) (*models.HealthScore, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	var latest *models.HealthScore
+	for _, s := range r.db.HealthScores.Rows {
+		if s.PatientID != patientID {
+			continue
+		}
+		if latest == nil || s.ComputedAt.After(latest.ComputedAt) || (s.ComputedAt.Equal(latest.ComputedAt) && s.ID > latest.ID) {
+			latest = &s
+		}
+	}
+	if latest == nil {
+		return nil, sql.ErrNoRows
+	}
+	return latest, nil
+}
+
+func (r *MemoryRepository) ListHealthScores(ctx context.Context, patientID string, page ScorePage) ([]models.HealthScore, string, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	var scores []models.HealthScore
+	for _, s := range r.db.HealthScores.Rows {
+		if s.PatientID == patientID && page.InRange(s.ComputedAt) {
+			scores = append(scores, s)
+		}
+	}
+	scores, next := pagination.Apply(scores, page)
+	return scores, next, nil
+}
+
+func (r *MemoryRepository) ListPatientIDs(ctx context.Context, after string, limit int) ([]string, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	ids := []string{}
+	for id := range r.db.PatientProfiles.Rows {
+		if id > after {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+	return ids, nil
+}
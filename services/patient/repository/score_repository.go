@@ -0,0 +1,159 @@
+package repository
+
+import (
+    "context"
+    "health-bar/shared/database"
+    "health-bar/shared/models"
+    "health-bar/shared/pagination"
+    "time"
+    "github.com/google/uuid"
+)
+
+const (
+    assessmentColumns  = `id, patient_id, general_health, energy, sleep, mood, pain, notes, created_at`
+    healthScoreColumns = `id, patient_id, score, rules_version, contributions, computed_at`
+)
+
+// CreateAssessment records a self-assessment
+func (r *PatientRepository) CreateAssessment(ctx context.Context, patientID string, assessment *models.SelfAssessment) error {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    query := `
+        INSERT INTO patient_self_assessments (id, patient_id, general_health, energy, sleep, mood, pain, notes)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+        RETURNING ` + assessmentColumns
+
+    return database.Conn(ctx, r.db).QueryRowxContext(ctx, query,
+        uuid.New().String(), patientID, assessment.GeneralHealth, assessment.Energy,
+        assessment.Sleep, assessment.Mood, assessment.Pain, assessment.Notes,
+    ).StructScan(assessment)
+}
+
+// ListAssessments gets a page of a patient's self-assessments and the cursor of the next page
+func (r *PatientRepository) ListAssessments(ctx context.Context, patientID string, page AssessmentPage) ([]models.SelfAssessment, string, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    q := &pagination.Query{}
+    q.Where("patient_id = " + q.Arg(patientID))
+    if !page.From.IsZero() {
+        q.Where("created_at >= " + q.Arg(pagination.Timestamp(page.From)) + "::timestamp")
+    }
+    if !page.To.IsZero() {
+        q.Where("created_at <= " + q.Arg(pagination.Timestamp(page.ToEnd())) + "::timestamp")
+    }
+
+    var assessments []models.SelfAssessment
+    query := `SELECT ` + assessmentColumns + ` FROM patient_self_assessments` + page.Clauses(q)
+    if err := database.Conn(ctx, r.db).SelectContext(ctx, &assessments, query, q.Args()...); err != nil {
+        return nil, "", err
+    }
+    assessments, next := pagination.Page(assessments, page)
+    return assessments, next, nil
+}
+
+// RecentAssessments gets a patient's self-assessments submitted since a time, newest first
+func (r *PatientRepository) RecentAssessments(ctx context.Context, patientID string, since time.Time) ([]models.SelfAssessment, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    assessments := []models.SelfAssessment{}
+    query := `
+        SELECT ` + assessmentColumns + `
+        FROM patient_self_assessments
+        WHERE patient_id = $1 AND created_at >= $2::timestamp
+        ORDER BY created_at DESC, id DESC
+    `
+    err := database.Conn(ctx, r.db).SelectContext(ctx, &assessments, query, patientID, pagination.Timestamp(since))
+    return assessments, err
+}
+
+// GetVisitsSince gets a patient's hospital visits on or after a date, newest first
+func (r *PatientRepository) GetVisitsSince(ctx context.Context, patientID string, since time.Time) ([]models.HospitalVisit, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    visits := []models.HospitalVisit{}
+    query := `
+        SELECT id, patient_id, hospital_name, visit_date, reason, notes, created_at, updated_at, version
+        FROM hospital_visits
+        WHERE patient_id = $1 AND visit_date >= $2::date
+        ORDER BY visit_date DESC, created_at DESC
+    `
+    err := database.Conn(ctx, r.db).SelectContext(ctx, &visits, query, patientID, pagination.Date(since))
+    return visits, err
+}
+
+// RecordHealthScore appends a score to the patient's history
+func (r *PatientRepository) RecordHealthScore(ctx context.Context, score *models.HealthScore) error {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    query := `
+        INSERT INTO health_scores (id, patient_id, score, rules_version, contributions, computed_at)
+        VALUES ($1, $2, $3, $4, $5, $6)
+        RETURNING ` + healthScoreColumns
+
+    return database.Conn(ctx, r.db).QueryRowxContext(ctx, query,
+        uuid.New().String(), score.PatientID, score.Score, score.RulesVersion, score.Contributions, score.ComputedAt,
+    ).StructScan(score)
+}
+
+// LatestHealthScore gets the most recently recorded score of a patient
+func (r *PatientRepository) LatestHealthScore(ctx context.Context, patientID string) (*models.HealthScore, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    score := &models.HealthScore{}
+    query := `
+        SELECT ` + healthScoreColumns + `
+        FROM health_scores
+        WHERE patient_id = $1
+        ORDER BY computed_at DESC, id DESC
+        LIMIT 1
+    `
+    if err := database.Conn(ctx, r.db).GetContext(ctx, score, query, patientID); err != nil {
+        return nil, err
+    }
+    return score, nil
+}
+
+// ListHealthScores gets a page of a patient's score history and the cursor of the next page
+func (r *PatientRepository) ListHealthScores(ctx context.Context, patientID string, page ScorePage) ([]models.HealthScore, string, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    q := &pagination.Query{}
+    q.Where("patient_id = " + q.Arg(patientID))
+    if !page.From.IsZero() {
+        q.Where("computed_at >= " + q.Arg(pagination.Timestamp(page.From)) + "::timestamp")
+    }
+    if !page.To.IsZero() {
+        q.Where("computed_at <= " + q.Arg(pagination.Timestamp(page.ToEnd())) + "::timestamp")
+    }
+
+    var scores []models.HealthScore
+    query := `SELECT ` + healthScoreColumns + ` FROM health_scores` + page.Clauses(q)
+    if err := database.Conn(ctx, r.db).SelectContext(ctx, &scores, query, q.Args()...); err != nil {
+        return nil, "", err
+    }
+    scores, next := pagination.Page(scores, page)
+    return scores, next, nil
+}
+
+// ListPatientIDs gets up to limit patient profile IDs following after, in order
+func (r *PatientRepository) ListPatientIDs(ctx context.Context, after string, limit int) ([]string, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    ids := []string{}
+    query := `
+        SELECT id FROM patient_profiles
+        WHERE id > COALESCE(NULLIF($1, '')::uuid, '00000000-0000-0000-0000-000000000000')
+        ORDER BY id
+        LIMIT $2
+    `
+    err := database.Conn(ctx, r.db).SelectContext(ctx, &ids, query, after, limit)
+    return ids, err
+}
@@ -0,0 +1,70 @@
+package repository
+
+import (
+	"context"
+	"health-bar/shared/models"
+	"time"
+)
+
+// Store is what PatientHandler needs from persistence. PatientRepository is
+// the Postgres implementation and MemoryRepository the in-memory one.
+type Store interface {
+	WithTx(ctx context.Context, fn func(ctx context.Context) error) error
+	CreateProfile(ctx context.Context, userID string, profile *models.PatientProfile) error
+	GetProfileByUserID(ctx context.Context, userID string) (*models.PatientProfile, error)
+	GetProfileByID(ctx context.Context, profileID string) (*models.PatientProfile, error)
+	UpdateProfile(ctx context.Context, userID string, profile *models.PatientProfile) error
+	// DeleteProfile deletes a profile and, by cascade, the patient's record
+	DeleteProfile(ctx context.Context, profileID string) error
+	GrantAccess(ctx context.Context, patientID, doctorID string) error
+	RevokeAccess(ctx context.Context, patientID, doctorID string) error
+	ListPermissions(ctx context.Context, patientID string, page PermissionPage) ([]models.DoctorAccessPermission, string, error)
+	CheckAccess(ctx context.Context, patientID, doctorID string) (bool, error)
+	// FreezeThread and UnfreezeThread follow access to the patient's
+	// message thread with a doctor
+	FreezeThread(ctx context.Context, patientID, doctorID string) error
+	UnfreezeThread(ctx context.Context, patientID, doctorID string) error
+	GetDoctorUserID(ctx context.Context, doctorID string) (string, error)
+
+	// Clinical record. Item methods are scoped by patient and return
+	// sql.ErrNoRows for another patient's rows.
+	ListAllergies(ctx context.Context, patientID string) ([]models.Allergy, error)
+	GetAllergy(ctx context.Context, patientID, id string) (*models.Allergy, error)
+	CreateAllergy(ctx context.Context, patientID string, allergy *models.Allergy) error
+	UpdateAllergy(ctx context.Context, patientID, id string, allergy *models.Allergy) error
+	DeleteAllergy(ctx context.Context, patientID, id string) error
+	ListConditions(ctx context.Context, patientID string) ([]models.Condition, error)
+	GetCondition(ctx context.Context, patientID, id string) (*models.Condition, error)
+	CreateCondition(ctx context.Context, patientID string, condition *models.Condition) error
+	UpdateCondition(ctx context.Context, patientID, id string, condition *models.Condition) error
+	DeleteCondition(ctx context.Context, patientID, id string) error
+	ListMedications(ctx context.Context, patientID string) ([]models.Medication, error)
+	GetMedication(ctx context.Context, patientID, id string) (*models.Medication, error)
+	CreateMedication(ctx context.Context, patientID string, medication *models.Medication) error
+	UpdateMedication(ctx context.Context, patientID, id string, medication *models.Medication) error
+	DeleteMedication(ctx context.Context, patientID, id string) error
+	ListEmergencyContacts(ctx context.Context, patientID string) ([]models.EmergencyContact, error)
+	GetEmergencyContact(ctx context.Context, patientID, id string) (*models.EmergencyContact, error)
+	CreateEmergencyContact(ctx context.Context, patientID string, contact *models.EmergencyContact) error
+	UpdateEmergencyContact(ctx context.Context, patientID, id string, contact *models.EmergencyContact) error
+	DeleteEmergencyContact(ctx context.Context, patientID, id string) error
+	GetBiometrics(ctx context.Context, patientID string) (*models.Biometrics, error)
+	SaveBiometrics(ctx context.Context, patientID string, biometrics *models.Biometrics) error
+	DeleteBiometrics(ctx context.Context, patientID string) error
+
+	// Health score inputs and history
+	CreateAssessment(ctx context.Context, patientID string, assessment *models.SelfAssessment) error
+	ListAssessments(ctx context.Context, patientID string, page AssessmentPage) ([]models.SelfAssessment, string, error)
+	RecentAssessments(ctx context.Context, patientID string, since time.Time) ([]models.SelfAssessment, error)
+	GetVisitsSince(ctx context.Context, patientID string, since time.Time) ([]models.HospitalVisit, error)
+	RecordHealthScore(ctx context.Context, score *models.HealthScore) error
+	LatestHealthScore(ctx context.Context, patientID string) (*models.HealthScore, error)
+	ListHealthScores(ctx context.Context, patientID string, page ScorePage) ([]models.HealthScore, string, error)
+	// ListPatientIDs pages through every patient profile ID in order
+	ListPatientIDs(ctx context.Context, after string, limit int) ([]string, error)
+}
+
+var (
+	_ Store = (*PatientRepository)(nil)
+	_ Store = (*MemoryRepository)(nil)
+)
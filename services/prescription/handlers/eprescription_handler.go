@@ -0,0 +1,387 @@
+package handlers
+
+import (
+    "database/sql"
+    "encoding/json"
+    "fmt"
+    "health-bar/shared/apperrors"
+    "health-bar/shared/interactions"
+    "health-bar/shared/models"
+    "health-bar/shared/pagination"
+    "health-bar/shared/utils"
+    "health-bar/services/prescription/repository"
+    "net/http"
+    "sort"
+    "strings"
+)
+
+const (
+    maxRefills         = 12
+    maxDurationDays    = 3650
+    maxInstructionsLen = 2000
+)
+
+// routes are the administration routes a prescription can name
+var routes = map[string]bool{
+    "oral": true, "sublingual": true, "buccal": true, "topical": true, "transdermal": true,
+    "inhaled": true, "nasal": true, "ophthalmic": true, "otic": true, "rectal": true,
+    "vaginal": true, "intravenous": true, "intramuscular": true, "subcutaneous": true, "other": true,
+}
+
+type IssuePrescriptionRequest struct {
+    PatientID      string  `json:"patient_id"`
+    Drug           string  `json:"drug"`
+    Strength       string  `json:"strength"`        // e.g. "500 mg"
+    Route          string  `json:"route"`           // e.g. "oral"
+    Frequency      string  `json:"frequency"`       // e.g. "twice daily"
+    DurationDays   *int    `json:"duration_days"`   // Optional, omitted until further notice
+    Refills        int     `json:"refills"`
+    Instructions   string  `json:"instructions"`
+    DocumentID     *string `json:"document_id"`     // Optional uploaded scan of the patient's
+    OverrideReason string  `json:"override_reason"` // Required despite a high-severity interaction
+}
+
+// IssuedPrescription is an issued prescription with the interaction
+// warnings it was issued with
+type IssuedPrescription struct {
+    models.EPrescription
+    Warnings []interactions.Warning `json:"interaction_warnings"`
+}
+
+type CancelPrescriptionRequest struct {
+    Reason string `json:"reason"`
+}
+
+func (req IssuePrescriptionRequest) prescription() (*models.EPrescription, error) {
+    drug := strings.TrimSpace(req.Drug)
+    strength := strings.TrimSpace(req.Strength)
+    route := strings.ToLower(strings.TrimSpace(req.Route))
+    frequency := strings.TrimSpace(req.Frequency)
+
+    if drug == "" || frequency == "" {
+        return nil, apperrors.New(apperrors.CodeValidation, "drug and frequency are required")
+    }
+    if len(drug) > 255 || len(strength) > 100 || len(frequency) > 100 {
+        return nil, apperrors.New(apperrors.CodeValidation, "drug, strength or frequency is too long")
+    }
+    if !routes[route] {
+        return nil, apperrors.New(apperrors.CodeValidation, "route must be one of: "+routeNames())
+    }
+    if req.DurationDays != nil && (*req.DurationDays < 1 || *req.DurationDays > maxDurationDays) {
+        return nil, apperrors.New(apperrors.CodeValidation, fmt.Sprintf("duration_days must be between 1 and %d", maxDurationDays))
+    }
+    if req.Refills < 0 || req.Refills > maxRefills {
+        return nil, apperrors.New(apperrors.CodeValidation, fmt.Sprintf("refills must be between 0 and %d", maxRefills))
+    }
+    if len(req.Instructions) > maxInstructionsLen || len(req.OverrideReason) > maxInstructionsLen {
+        return nil, apperrors.New(apperrors.CodeValidation, fmt.Sprintf("instructions and override_reason must be at most %d characters", maxInstructionsLen))
+    }
+
+    prescription := &models.EPrescription{
+        PatientID:    req.PatientID,
+        Drug:         drug,
+        Strength:     strength,
+        Route:        route,
+        Frequency:    frequency,
+        DurationDays: req.DurationDays,
+        Refills:      req.Refills,
+        Instructions: strings.TrimSpace(req.Instructions),
+    }
+    if req.DocumentID != nil && *req.DocumentID != "" {
+        prescription.DocumentID = req.DocumentID
+    }
+    return prescription, nil
+}
+
+func routeNames() string {
+    names := make([]string, 0, len(routes))
+    for name := range routes {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return strings.Join(names, ", ")
+}
+
+// IssuePrescription issues a structured prescription to a patient who
+// granted the doctor access. The drug is checked for interactions first; a
+// high-severity warning needs an override_reason.
+func (h *PrescriptionHandler) IssuePrescription(w http.ResponseWriter, r *http.Request) {
+    userID := r.Header.Get("X-User-ID")
+    userRole := r.Header.Get("X-User-Role")
+
+    if userID == "" {
+        utils.SendError(w, http.StatusUnauthorized, "Unauthorized")
+        return
+    }
+
+    if userRole != "doctor" {
+        utils.SendError(w, http.StatusForbidden, "Only doctors can issue prescriptions")
+        return
+    }
+
+    var req IssuePrescriptionRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        utils.SendError(w, http.StatusBadRequest, "Invalid request body")
+        return
+    }
+
+    if req.PatientID == "" {
+        utils.SendErrorCode(w, apperrors.CodeValidation, "Patient ID is required")
+        return
+    }
+
+    prescription, err := req.prescription()
+    if err != nil {
+        utils.SendAppError(w, err, "Invalid prescription")
+        return
+    }
+
+    doctor, err := h.repo.GetDoctorProfileByUserID(r.Context(), userID)
+    if err != nil {
+        utils.SendErrorCode(w, apperrors.CodeProfileNotFound, "Doctor profile not found")
+        return
+    }
+
+    hasAccess, err := h.repo.CheckDoctorAccess(r.Context(), userID, req.PatientID)
+    if err != nil || !hasAccess {
+        utils.SendErrorCode(w, apperrors.CodeAccessDenied, "Access denied")
+        return
+    }
+
+    if prescription.DocumentID != nil {
+        document, err := h.repo.GetPrescriptionByID(r.Context(), *prescription.DocumentID)
+        if err != nil || document.PatientID != req.PatientID {
+            if err != nil && err != sql.ErrNoRows {
+                utils.SendAppError(w, err, "Failed to issue prescription")
+                return
+            }
+            utils.SendErrorCode(w, apperrors.CodeInvalidReference, "document_id must be one of the patient's uploaded prescriptions")
+            return
+        }
+    }
+
+    check, err := h.checkInteractions(r.Context(), req.PatientID, prescription.Drug)
+    if err != nil {
+        utils.SendAppError(w, err, "Failed to issue prescription")
+        return
+    }
+    override := strings.TrimSpace(req.OverrideReason)
+    if check.RequiresOverride && override == "" {
+        utils.SendErrorCode(w, apperrors.CodeOverrideRequired, check.Summary()+". Give an override_reason to issue it anyway")
+        return
+    }
+
+    prescription.DoctorID = &doctor.ID
+    prescription.PrescriberName = doctor.FullName
+    prescription.InteractionsVersion = check.Version
+    if check.RequiresOverride {
+        prescription.OverrideReason = override
+    }
+    // The grant is checked again by the insert itself, in case it was
+    // revoked since the check above
+    if err := h.repo.CreateEPrescription(r.Context(), prescription); err != nil {
+        if err == sql.ErrNoRows {
+            utils.SendErrorCode(w, apperrors.CodeAccessDenied, "Access denied")
+            return
+        }
+        utils.SendAppError(w, err, "Failed to issue prescription")
+        return
+    }
+
+    utils.SendSuccess(w, http.StatusCreated, "Prescription issued", IssuedPrescription{*prescription, check.Warnings})
+}
+
+// GetIssuedPrescriptions lists the prescriptions the current doctor issued,
+// optionally narrowed to one patient_id
+func (h *PrescriptionHandler) GetIssuedPrescriptions(w http.ResponseWriter, r *http.Request) {
+    userID := r.Header.Get("X-User-ID")
+    userRole := r.Header.Get("X-User-Role")
+
+    if userID == "" {
+        utils.SendError(w, http.StatusUnauthorized, "Unauthorized")
+        return
+    }
+
+    if userRole != "doctor" {
+        utils.SendError(w, http.StatusForbidden, "Only doctors can list the prescriptions they issued")
+        return
+    }
+
+    doctor, err := h.repo.GetDoctorProfileByUserID(r.Context(), userID)
+    if err != nil {
+        utils.SendErrorCode(w, apperrors.CodeProfileNotFound, "Doctor profile not found")
+        return
+    }
+
+    page, ok := parseEPrescriptionPage(w, r)
+    if !ok {
+        return
+    }
+
+    prescriptions, next, err := h.repo.GetEPrescriptionsByDoctorID(r.Context(), doctor.ID, page)
+    if err != nil {
+        utils.SendAppError(w, err, "Failed to retrieve prescriptions")
+        return
+    }
+
+    sendEPrescriptions(w, prescriptions, next)
+}
+
+// listEPrescriptions answers /my and /patient when kind=issued
+func (h *PrescriptionHandler) listEPrescriptions(w http.ResponseWriter, r *http.Request, patientProfileID string) {
+    page, ok := parseEPrescriptionPage(w, r)
+    if !ok {
+        return
+    }
+    // patient_id names the patient here, not a filter
+    delete(page.Filters, "patient_id")
+
+    prescriptions, next, err := h.repo.GetEPrescriptionsByPatientID(r.Context(), patientProfileID, page)
+    if err != nil {
+        utils.SendAppError(w, err, "Failed to retrieve prescriptions")
+        return
+    }
+
+    sendEPrescriptions(w, prescriptions, next)
+}
+
+func parseEPrescriptionPage(w http.ResponseWriter, r *http.Request) (repository.EPrescriptionPage, bool) {
+    page, err := pagination.Parse(r.URL.Query(), repository.EPrescriptionPages)
+    if err != nil {
+        utils.SendAppError(w, err, "Invalid pagination parameters")
+        return page, false
+    }
+    if status, ok := page.Filters["status"]; ok {
+        status = strings.ToLower(status)
+        if status != string(models.PrescriptionActive) && status != string(models.PrescriptionCancelled) {
+            utils.SendErrorCode(w, apperrors.CodeValidation, "status must be active or cancelled")
+            return page, false
+        }
+        page.Filters["status"] = status
+    }
+    return page, true
+}
+
+func sendEPrescriptions(w http.ResponseWriter, prescriptions []models.EPrescription, next string) {
+    if prescriptions == nil {
+        prescriptions = []models.EPrescription{}
+    }
+    utils.SendPage(w, http.StatusOK, "Prescriptions retrieved", prescriptions, next)
+}
+
+// GetIssuedPrescription gets one issued prescription. The patient, the
+// issuing doctor and doctors with access can read it.
+func (h *PrescriptionHandler) GetIssuedPrescription(w http.ResponseWriter, r *http.Request) {
+    userID := r.Header.Get("X-User-ID")
+    userRole := r.Header.Get("X-User-Role")
+
+    if userID == "" {
+        utils.SendError(w, http.StatusUnauthorized, "Unauthorized")
+        return
+    }
+
+    prescriptionID := r.URL.Query().Get("id")
+    if prescriptionID == "" {
+        utils.SendErrorCode(w, apperrors.CodeValidation, "Prescription ID is required")
+        return
+    }
+
+    prescription, err := h.repo.GetEPrescriptionByID(r.Context(), prescriptionID)
+    if err != nil {
+        if err == sql.ErrNoRows {
+            utils.SendError(w, http.StatusNotFound, "Prescription not found")
+            return
+        }
+        utils.SendAppError(w, err, "Failed to retrieve prescription")
+        return
+    }
+
+    // Check access
+    if userRole == "patient" {
+        patientProfileID, err := h.repo.GetPatientProfileIDByUserID(r.Context(), userID)
+        if err != nil || prescription.PatientID != patientProfileID {
+            utils.SendErrorCode(w, apperrors.CodeAccessDenied, "Access denied")
+            return
+        }
+    } else if userRole == "doctor" {
+        doctor, err := h.repo.GetDoctorProfileByUserID(r.Context(), userID)
+        issuer := err == nil && prescription.DoctorID != nil && *prescription.DoctorID == doctor.ID
+        if !issuer {
+            hasAccess, err := h.repo.CheckDoctorAccess(r.Context(), userID, prescription.PatientID)
+            if err != nil || !hasAccess {
+                utils.SendErrorCode(w, apperrors.CodeAccessDenied, "Access denied")
+                return
+            }
+        }
+    } else {
+        utils.SendErrorCode(w, apperrors.CodeAccessDenied, "Access denied")
+        return
+    }
+
+    utils.SendSuccess(w, http.StatusOK, "Prescription retrieved", prescription)
+}
+
+// CancelIssuedPrescription cancels an active prescription. Only the doctor
+// who issued it can cancel it.
+func (h *PrescriptionHandler) CancelIssuedPrescription(w http.ResponseWriter, r *http.Request) {
+    userID := r.Header.Get("X-User-ID")
+    userRole := r.Header.Get("X-User-Role")
+
+    if userID == "" {
+        utils.SendError(w, http.StatusUnauthorized, "Unauthorized")
+        return
+    }
+
+    if userRole != "doctor" {
+        utils.SendError(w, http.StatusForbidden, "Only doctors can cancel prescriptions")
+        return
+    }
+
+    prescriptionID := r.URL.Query().Get("id")
+    if prescriptionID == "" {
+        utils.SendErrorCode(w, apperrors.CodeValidation, "Prescription ID is required")
+        return
+    }
+
+    var req CancelPrescriptionRequest
+    if r.ContentLength != 0 {
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+            utils.SendError(w, http.StatusBadRequest, "Invalid request body")
+            return
+        }
+    }
+    reason := strings.TrimSpace(req.Reason)
+    if len(reason) > maxInstructionsLen {
+        utils.SendErrorCode(w, apperrors.CodeValidation, fmt.Sprintf("reason must be at most %d characters", maxInstructionsLen))
+        return
+    }
+
+    doctor, err := h.repo.GetDoctorProfileByUserID(r.Context(), userID)
+    if err != nil {
+        utils.SendErrorCode(w, apperrors.CodeProfileNotFound, "Doctor profile not found")
+        return
+    }
+
+    // Another doctor's prescription is reported as missing rather than forbidden
+    prescription, err := h.repo.GetEPrescriptionByID(r.Context(), prescriptionID)
+    if err != nil || prescription.DoctorID == nil || *prescription.DoctorID != doctor.ID {
+        if err != nil && err != sql.ErrNoRows {
+            utils.SendAppError(w, err, "Failed to cancel prescription")
+            return
+        }
+        utils.SendError(w, http.StatusNotFound, "Prescription not found")
+        return
+    }
+
+    cancelled, err := h.repo.CancelEPrescription(r.Context(), prescriptionID, reason)
+    if err != nil {
+        if err == sql.ErrNoRows {
+            utils.SendErrorCode(w, apperrors.CodeConflict, "Prescription is already cancelled")
+            return
+        }
+        utils.SendAppError(w, err, "Failed to cancel prescription")
+        return
+    }
+
+    utils.SendSuccess(w, http.StatusOK, "Prescription cancelled", cancelled)
+}
@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"health-bar/services/prescription/repository"
+	"health-bar/shared/apperrors"
+	"health-bar/shared/memdb"
+	"health-bar/shared/models"
+	"health-bar/shared/storage"
+	"health-bar/shared/testutil"
+)
+
+func issue(t *testing.T, h *PrescriptionHandler, doctorUserID string, req IssuePrescriptionRequest) models.EPrescription {
+	t.Helper()
+
+	r := testutil.NewRequest(t, http.MethodPost, "/api/prescriptions/issue", req, doctorUserID, "doctor")
+	rec, resp := testutil.Serve(t, h.IssuePrescription, r)
+	testutil.ExpectStatus(t, rec, http.StatusCreated)
+
+	var prescription models.EPrescription
+	testutil.DecodeData(t, resp, &prescription)
+	return prescription
+}
+
+func TestIssuePrescription(t *testing.T) {
+	h, db, _ := newTestHandler(t)
+	patient := db.AddPatient("p@test.com", "Pat")
+	other := db.AddPatient("o@test.com", "Other")
+	doctor := db.AddDoctor("d@test.com", "Dr Who")
+	scan := upload(t, h, patient.UserID)
+	otherScan := upload(t, h, other.UserID)
+
+	valid := IssuePrescriptionRequest{PatientID: patient.ID, Drug: "Amoxicillin", Strength: "500 mg", Route: "Oral", Frequency: "three times daily"}
+	r := testutil.NewRequest(t, http.MethodPost, "/api/prescriptions/issue", valid, doctor.UserID, "doctor")
+	rec, resp := testutil.Serve(t, h.IssuePrescription, r)
+	testutil.ExpectStatus(t, rec, http.StatusForbidden)
+	testutil.ExpectCode(t, resp, apperrors.CodeAccessDenied)
+
+	db.Grant(patient.ID, doctor.ID)
+	days := 7
+	valid.DurationDays, valid.DocumentID = &days, &scan.ID
+	prescription := issue(t, h, doctor.UserID, valid)
+	if prescription.Route != "oral" || prescription.Status != models.PrescriptionActive || prescription.PrescriberName != "Dr Who" ||
+		prescription.DocumentID == nil || *prescription.DocumentID != scan.ID || *prescription.DurationDays != 7 {
+		t.Fatalf("prescription = %+v", prescription)
+	}
+
+	refills, route, noDrug, foreignScan := valid, valid, valid, valid
+	refills.Refills = 13
+	route.Route = "osmosis"
+	noDrug.Drug = " "
+	foreignScan.DocumentID = &otherScan.ID
+	for name, tc := range map[string]struct {
+		req  IssuePrescriptionRequest
+		code apperrors.Code
+	}{
+		"too many refills": {refills, apperrors.CodeValidation},
+		"unknown route":    {route, apperrors.CodeValidation},
+		"no drug":          {noDrug, apperrors.CodeValidation},
+		"foreign scan":     {foreignScan, apperrors.CodeInvalidReference},
+	} {
+		r := testutil.NewRequest(t, http.MethodPost, "/api/prescriptions/issue", tc.req, doctor.UserID, "doctor")
+		_, resp := testutil.Serve(t, h.IssuePrescription, r)
+		if resp.Code != tc.code {
+			t.Errorf("%s: code %q, want %q", name, resp.Code, tc.code)
+		}
+	}
+
+	r = testutil.NewRequest(t, http.MethodPost, "/api/prescriptions/issue", valid, patient.UserID, "patient")
+	rec, _ = testutil.Serve(t, h.IssuePrescription, r)
+	testutil.ExpectStatus(t, rec, http.StatusForbidden)
+
+	// Deleting the attached scan keeps the prescription
+	r = testutil.NewRequest(t, http.MethodDelete, "/api/prescriptions?id="+scan.ID, nil, patient.UserID, "patient")
+	rec, _ = testutil.Serve(t, h.DeletePrescription, r)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+	if stored := db.EPrescriptions.Rows[prescription.ID]; stored.DocumentID != nil {
+		t.Fatalf("document_id = %v after the scan was deleted", *stored.DocumentID)
+	}
+}
+
+func TestIssuedPrescriptionLists(t *testing.T) {
+	h, db, _ := newTestHandler(t)
+	patient := db.AddPatient("p@test.com", "Pat")
+	other := db.AddPatient("o@test.com", "Other")
+	doctor := db.AddDoctor("d@test.com", "Dr Who")
+	colleague := db.AddDoctor("c@test.com", "Dr No")
+	db.Grant(patient.ID, doctor.ID)
+	db.Grant(other.ID, doctor.ID)
+	db.Grant(patient.ID, colleague.ID)
+	upload(t, h, patient.UserID)
+
+	first := issue(t, h, doctor.UserID, IssuePrescriptionRequest{PatientID: patient.ID, Drug: "Ibuprofen", Route: "oral", Frequency: "as needed"})
+	issue(t, h, doctor.UserID, IssuePrescriptionRequest{PatientID: other.ID, Drug: "Salbutamol", Route: "inhaled", Frequency: "as needed"})
+	issue(t, h, colleague.UserID, IssuePrescriptionRequest{PatientID: patient.ID, Drug: "Cetirizine", Route: "oral", Frequency: "daily"})
+
+	list := func(handler http.HandlerFunc, target, userID, role string) []models.EPrescription {
+		t.Helper()
+		rec, resp := testutil.Serve(t, handler, testutil.NewRequest(t, http.MethodGet, target, nil, userID, role))
+		testutil.ExpectStatus(t, rec, http.StatusOK)
+		var prescriptions []models.EPrescription
+		testutil.DecodeData(t, resp, &prescriptions)
+		return prescriptions
+	}
+
+	if got := list(h.GetMyPrescriptions, "/api/prescriptions/my?kind=issued", patient.UserID, "patient"); len(got) != 2 {
+		t.Fatalf("patient sees %d issued prescriptions, want 2", len(got))
+	}
+	// Uploaded files stay the default
+	if got := list(h.GetMyPrescriptions, "/api/prescriptions/my", patient.UserID, "patient"); len(got) != 1 || got[0].Drug != "" {
+		t.Fatalf("uploaded = %+v", got)
+	}
+	if got := list(h.GetPatientPrescriptions, "/api/prescriptions/patient?kind=issued&patient_id="+patient.ID, colleague.UserID, "doctor"); len(got) != 2 {
+		t.Fatalf("colleague sees %d of the patient's prescriptions, want 2", len(got))
+	}
+	if got := list(h.GetIssuedPrescriptions, "/api/prescriptions/issued", doctor.UserID, "doctor"); len(got) != 2 {
+		t.Fatalf("doctor issued %d, want 2", len(got))
+	}
+	if got := list(h.GetIssuedPrescriptions, "/api/prescriptions/issued?patient_id="+other.ID, doctor.UserID, "doctor"); len(got) != 1 || got[0].Drug != "Salbutamol" {
+		t.Fatalf("issued to other = %+v", got)
+	}
+
+	// Only the issuing doctor can cancel, and only once
+	cancel := func(userID string) (int, apperrors.Code) {
+		r := testutil.NewRequest(t, http.MethodPost, "/api/prescriptions/issued/cancel?id="+first.ID, CancelPrescriptionRequest{Reason: "Wrong drug"}, userID, "doctor")
+		rec, resp := testutil.Serve(t, h.CancelIssuedPrescription, r)
+		return rec.Code, resp.Code
+	}
+	if status, _ := cancel(colleague.UserID); status != http.StatusNotFound {
+		t.Fatalf("colleague cancel status = %d", status)
+	}
+	if status, _ := cancel(doctor.UserID); status != http.StatusOK {
+		t.Fatalf("cancel status = %d", status)
+	}
+	if status, code := cancel(doctor.UserID); status != http.StatusConflict || code != apperrors.CodeConflict {
+		t.Fatalf("second cancel = %d %q", status, code)
+	}
+	if got := list(h.GetMyPrescriptions, "/api/prescriptions/my?kind=issued&status=cancelled", patient.UserID, "patient"); len(got) != 1 || got[0].CancelReason != "Wrong drug" {
+		t.Fatalf("cancelled = %+v", got)
+	}
+
+	r := testutil.NewRequest(t, http.MethodGet, "/api/prescriptions/my?kind=scribbled", nil, patient.UserID, "patient")
+	rec, resp := testutil.Serve(t, h.GetMyPrescriptions, r)
+	testutil.ExpectStatus(t, rec, http.StatusBadRequest)
+	testutil.ExpectCode(t, resp, apperrors.CodeValidation)
+}
+
+// revokingStore withdraws a grant just before the prescription is written,
+// as a patient revoking access mid-request would.
+type revokingStore struct {
+	repository.Store
+	db *memdb.DB
+}
+
+func (s revokingStore) CreateEPrescription(ctx context.Context, prescription *models.EPrescription) error {
+	s.db.Lock()
+	for id, p := range s.db.AccessPermissions.Rows {
+		if p.PatientID == prescription.PatientID && p.DoctorID == *prescription.DoctorID {
+			p.IsActive = false
+			s.db.AccessPermissions.Rows[id] = p
+		}
+	}
+	s.db.Unlock()
+	return s.Store.CreateEPrescription(ctx, prescription)
+}
+
+func TestIssuePrescriptionRechecksAccessOnInsert(t *testing.T) {
+	_, db, dir := newTestHandler(t)
+	files, err := storage.NewFilesystem(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewPrescriptionHandler(revokingStore{repository.NewMemoryRepository(db), db}, files)
+	patient := db.AddPatient("p@test.com", "Pat")
+	doctor := db.AddDoctor("d@test.com", "Dr Who")
+	db.Grant(patient.ID, doctor.ID)
+
+	req := IssuePrescriptionRequest{PatientID: patient.ID, Drug: "Amoxicillin", Strength: "500 mg", Route: "Oral", Frequency: "three times daily"}
+	r := testutil.NewRequest(t, http.MethodPost, "/api/prescriptions/issue", req, doctor.UserID, "doctor")
+	rec, resp := testutil.Serve(t, h.IssuePrescription, r)
+	testutil.ExpectStatus(t, rec, http.StatusForbidden)
+	testutil.ExpectCode(t, resp, apperrors.CodeAccessDenied)
+	if len(db.EPrescriptions.Rows) != 0 {
+		t.Fatalf("issued after the grant was revoked: %+v", db.EPrescriptions.Rows)
+	}
+}
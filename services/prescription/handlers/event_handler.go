@@ -0,0 +1,40 @@
+package handlers
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "health-bar/shared/events"
+    "log"
+    "github.com/google/uuid"
+)
+
+// Subscribe handles the events other services publish about patients
+func (h *PrescriptionHandler) Subscribe(consumer *events.Consumer) {
+    consumer.Handle(events.TopicPatientDeleted, h.removePatientFiles)
+}
+
+// removePatientFiles removes the uploads of a deleted patient. Their rows
+// went with the patient profile, but the files are only in the blob store. Every
+// upload, prescription or attachment, is named after the patient, so a
+// redelivered event finds nothing left to remove. A file that cannot be
+// removed fails the event, which is retried.
+func (h *PrescriptionHandler) removePatientFiles(ctx context.Context, e events.Event) error {
+    patientID := e.Data["patient_id"]
+    if _, err := uuid.Parse(patientID); err != nil {
+        return fmt.Errorf("invalid patient ID %q", patientID)
+    }
+
+    blobs, err := h.files.List(ctx, patientID+"_")
+    if err != nil {
+        return err
+    }
+    var errs []error
+    for _, blob := range blobs {
+        if err := h.files.Delete(ctx, blob.Key); err != nil {
+            errs = append(errs, err)
+        }
+    }
+    log.Printf("Removed %d files of deleted patient %s", len(blobs)-len(errs), patientID)
+    return errors.Join(errs...)
+}
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"context"
+	"health-bar/shared/events"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPatientDeletedRemovesFiles(t *testing.T) {
+	h, db, dir := newTestHandler(t)
+	patient := db.AddPatient("p@test.com", "Pat")
+	other := db.AddPatient("o@test.com", "Other")
+	mine := upload(t, h, patient.UserID)
+	theirs := upload(t, h, other.UserID)
+
+	ctx := context.Background()
+	outbox := events.NewMemoryOutbox(db)
+	bus := events.NewLocalBus()
+	consumer := events.NewConsumer("prescription-service", events.NewMemoryInbox(db), bus)
+	h.Subscribe(consumer)
+	if err := consumer.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	db.DeletePatientProfile(patient.ID)
+	event := events.PatientDeleted(patient.ID, patient.UserID)
+	// Delivery is at least once, so the second one must find nothing to do
+	for i := 0; i < 2; i++ {
+		outbox.Add(ctx, event)
+		if _, err := events.NewRelay(outbox, bus).Publish(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if n, err := consumer.Process(ctx); err != nil || n != 1 {
+			t.Fatalf("Process = %d, %v", n, err)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, mine.FilePath)); !os.IsNotExist(err) {
+		t.Fatalf("deleted patient's file: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, theirs.FilePath)); err != nil {
+		t.Fatalf("other patient's file: %v", err)
+	}
+}
+
+func TestPatientDeletedRejectsPatternIDs(t *testing.T) {
+	h, db, dir := newTestHandler(t)
+	patient := db.AddPatient("p@test.com", "Pat")
+	prescription := upload(t, h, patient.UserID)
+
+	err := h.removePatientFiles(context.Background(), events.PatientDeleted("*", patient.UserID))
+	if err == nil {
+		t.Fatal("expected an error for a patient ID that is a pattern")
+	}
+	if _, err := os.Stat(filepath.Join(dir, prescription.FilePath)); err != nil {
+		t.Fatalf("file removed: %v", err)
+	}
+}
@@ -0,0 +1,63 @@
+package handlers
+
+import (
+    "context"
+    "health-bar/shared/apperrors"
+    "health-bar/shared/interactions"
+    "health-bar/shared/utils"
+    "net/http"
+    "strings"
+)
+
+// checkInteractions checks drug against the patient's medication and
+// allergy lists with the current dataset
+func (h *PrescriptionHandler) checkInteractions(ctx context.Context, patientProfileID, drug string) (interactions.Result, error) {
+    medications, err := h.repo.GetPatientMedicationNames(ctx, patientProfileID)
+    if err != nil {
+        return interactions.Result{}, err
+    }
+    allergies, err := h.repo.GetPatientAllergies(ctx, patientProfileID)
+    if err != nil {
+        return interactions.Result{}, err
+    }
+    return h.interactions.Load().Check(drug, medications, allergies), nil
+}
+
+// CheckInteractions checks a drug a doctor is about to prescribe against
+// the patient's medications and allergies and returns the warnings, most
+// severe first
+func (h *PrescriptionHandler) CheckInteractions(w http.ResponseWriter, r *http.Request) {
+    userID := r.Header.Get("X-User-ID")
+    userRole := r.Header.Get("X-User-Role")
+
+    if userID == "" {
+        utils.SendError(w, http.StatusUnauthorized, "Unauthorized")
+        return
+    }
+
+    if userRole != "doctor" {
+        utils.SendError(w, http.StatusForbidden, "Only doctors can check interactions")
+        return
+    }
+
+    patientProfileID := r.URL.Query().Get("patient_id")
+    drug := strings.TrimSpace(r.URL.Query().Get("drug"))
+    if patientProfileID == "" || drug == "" {
+        utils.SendErrorCode(w, apperrors.CodeValidation, "patient_id and drug are required")
+        return
+    }
+
+    hasAccess, err := h.repo.CheckDoctorAccess(r.Context(), userID, patientProfileID)
+    if err != nil || !hasAccess {
+        utils.SendErrorCode(w, apperrors.CodeAccessDenied, "Access denied")
+        return
+    }
+
+    result, err := h.checkInteractions(r.Context(), patientProfileID, drug)
+    if err != nil {
+        utils.SendAppError(w, err, "Failed to check interactions")
+        return
+    }
+
+    utils.SendSuccess(w, http.StatusOK, "Interactions checked", result)
+}
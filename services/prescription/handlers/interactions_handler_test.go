@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+
+	"health-bar/shared/apperrors"
+	"health-bar/shared/interactions"
+	"health-bar/shared/memdb"
+	"health-bar/shared/models"
+	"health-bar/shared/testutil"
+
+	"github.com/google/uuid"
+)
+
+func addClinical(db *memdb.DB, patientID string, medications []string, allergies []string) {
+	db.Lock()
+	defer db.Unlock()
+
+	for _, name := range medications {
+		m := models.Medication{ID: uuid.New().String(), PatientID: patientID, Name: name}
+		db.Medications.Rows[m.ID] = m
+	}
+	for _, substance := range allergies {
+		a := models.Allergy{ID: uuid.New().String(), PatientID: patientID, Substance: substance, Severity: models.SeveritySevere}
+		db.Allergies.Rows[a.ID] = a
+	}
+}
+
+func TestCheckInteractions(t *testing.T) {
+	h, db, _ := newTestHandler(t)
+	patient := db.AddPatient("p@test.com", "Pat")
+	doctor := db.AddDoctor("d@test.com", "Dr Who")
+	addClinical(db, patient.ID, []string{"Lisinopril 10mg"}, []string{"Penicillin"})
+
+	target := "/api/prescriptions/interactions?drug=Cephalexin&patient_id=" + patient.ID
+	rec, resp := testutil.Serve(t, h.CheckInteractions, testutil.NewRequest(t, http.MethodGet, target, nil, doctor.UserID, "doctor"))
+	testutil.ExpectStatus(t, rec, http.StatusForbidden)
+	testutil.ExpectCode(t, resp, apperrors.CodeAccessDenied)
+
+	db.Grant(patient.ID, doctor.ID)
+	// Active issued prescriptions count as current medications
+	issue(t, h, doctor.UserID, IssuePrescriptionRequest{PatientID: patient.ID, Drug: "Spironolactone", Route: "oral", Frequency: "daily"})
+
+	rec, resp = testutil.Serve(t, h.CheckInteractions, testutil.NewRequest(t, http.MethodGet, target, nil, doctor.UserID, "doctor"))
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+	var result interactions.Result
+	testutil.DecodeData(t, resp, &result)
+	if len(result.Warnings) != 1 || result.Warnings[0].Kind != interactions.CrossReactivity || result.RequiresOverride {
+		t.Fatalf("cephalexin = %+v", result)
+	}
+
+	target = "/api/prescriptions/interactions?drug=Potassium+chloride&patient_id=" + patient.ID
+	rec, resp = testutil.Serve(t, h.CheckInteractions, testutil.NewRequest(t, http.MethodGet, target, nil, doctor.UserID, "doctor"))
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+	testutil.DecodeData(t, resp, &result)
+	if len(result.Warnings) != 1 || result.Warnings[0].With != "Lisinopril 10mg" {
+		t.Fatalf("potassium = %+v", result)
+	}
+}
+
+func TestIssueRequiresOverrideForHighSeverity(t *testing.T) {
+	h, db, _ := newTestHandler(t)
+	patient := db.AddPatient("p@test.com", "Pat")
+	doctor := db.AddDoctor("d@test.com", "Dr Who")
+	db.Grant(patient.ID, doctor.ID)
+	addClinical(db, patient.ID, nil, []string{"Penicillin"})
+
+	req := IssuePrescriptionRequest{PatientID: patient.ID, Drug: "Amoxicillin", Strength: "500 mg", Route: "oral", Frequency: "three times daily"}
+	r := testutil.NewRequest(t, http.MethodPost, "/api/prescriptions/issue", req, doctor.UserID, "doctor")
+	rec, resp := testutil.Serve(t, h.IssuePrescription, r)
+	testutil.ExpectStatus(t, rec, http.StatusConflict)
+	testutil.ExpectCode(t, resp, apperrors.CodeOverrideRequired)
+	if len(db.EPrescriptions.Rows) != 0 {
+		t.Fatal("prescription stored without an override")
+	}
+
+	req.OverrideReason = "Tolerated amoxicillin in 2022 under observation"
+	r = testutil.NewRequest(t, http.MethodPost, "/api/prescriptions/issue", req, doctor.UserID, "doctor")
+	rec, resp = testutil.Serve(t, h.IssuePrescription, r)
+	testutil.ExpectStatus(t, rec, http.StatusCreated)
+
+	var issued IssuedPrescription
+	testutil.DecodeData(t, resp, &issued)
+	if issued.OverrideReason != req.OverrideReason || issued.InteractionsVersion != interactions.Default().Version ||
+		len(issued.Warnings) != 1 || issued.Warnings[0].Severity != interactions.High {
+		t.Fatalf("issued = %+v", issued)
+	}
+
+	// A reason given without a high-severity warning is not recorded
+	safe := IssuePrescriptionRequest{PatientID: patient.ID, Drug: "Paracetamol", Route: "oral", Frequency: "as needed", OverrideReason: "n/a"}
+	if p := issue(t, h, doctor.UserID, safe); p.OverrideReason != "" {
+		t.Fatalf("override reason stored without a warning: %q", p.OverrideReason)
+	}
+}
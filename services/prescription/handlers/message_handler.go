@@ -0,0 +1,388 @@
+package handlers
+
+import (
+    "context"
+    "database/sql"
+    "encoding/json"
+    "fmt"
+    "health-bar/shared/apperrors"
+    "health-bar/shared/models"
+    "health-bar/shared/pagination"
+    "health-bar/shared/utils"
+    "health-bar/services/prescription/repository"
+    "mime/multipart"
+    "net/http"
+    "path/filepath"
+    "strings"
+    "time"
+)
+
+const (
+    maxMessageLen  = 10000
+    maxAttachments = 5
+)
+
+type OpenThreadRequest struct {
+    DoctorID  string `json:"doctor_id"`  // Set by patients
+    PatientID string `json:"patient_id"` // Set by doctors
+}
+
+type SendMessageRequest struct {
+    Body string `json:"body"`
+}
+
+// ReadReceipt reports how many messages a read marked
+type ReadReceipt struct {
+    Marked int64 `json:"marked"`
+}
+
+// visibleUntil is the last moment of a thread the reader may see: doctors
+// stop seeing a thread when it is frozen. Zero means no limit.
+func visibleUntil(thread *models.MessageThread, reader models.UserRole) time.Time {
+    if reader == models.RoleDoctor && thread.FrozenAt != nil {
+        return *thread.FrozenAt
+    }
+    return time.Time{}
+}
+
+// OpenThread gets or starts the thread between the current user and the
+// other party, who must share an active access grant with them
+func (h *PrescriptionHandler) OpenThread(w http.ResponseWriter, r *http.Request) {
+    userID := r.Header.Get("X-User-ID")
+    userRole := r.Header.Get("X-User-Role")
+
+    if userID == "" {
+        utils.SendError(w, http.StatusUnauthorized, "Unauthorized")
+        return
+    }
+
+    var req OpenThreadRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        utils.SendError(w, http.StatusBadRequest, "Invalid request body")
+        return
+    }
+
+    var patientID, doctorID string
+    switch userRole {
+    case "patient":
+        if req.DoctorID == "" {
+            utils.SendErrorCode(w, apperrors.CodeValidation, "Doctor ID is required")
+            return
+        }
+        myProfileID, err := h.repo.GetPatientProfileIDByUserID(r.Context(), userID)
+        if err != nil {
+            utils.SendErrorCode(w, apperrors.CodeProfileNotFound, "Patient profile not found")
+            return
+        }
+        patientID, doctorID = myProfileID, req.DoctorID
+    case "doctor":
+        if req.PatientID == "" {
+            utils.SendErrorCode(w, apperrors.CodeValidation, "Patient ID is required")
+            return
+        }
+        doctorProfile, err := h.repo.GetDoctorProfileByUserID(r.Context(), userID)
+        if err != nil {
+            utils.SendErrorCode(w, apperrors.CodeProfileNotFound, "Doctor profile not found")
+            return
+        }
+        patientID, doctorID = req.PatientID, doctorProfile.ID
+    default:
+        utils.SendError(w, http.StatusForbidden, "Only patients and doctors can message")
+        return
+    }
+
+    hasAccess, err := h.repo.CheckAccessGrant(r.Context(), patientID, doctorID)
+    if err != nil {
+        utils.SendAppError(w, err, "Failed to open thread")
+        return
+    }
+    if !hasAccess {
+        utils.SendErrorCode(w, apperrors.CodeAccessDenied, "Messaging needs an active access grant between the patient and the doctor")
+        return
+    }
+
+    thread, err := h.repo.OpenThread(r.Context(), patientID, doctorID)
+    if err != nil {
+        utils.SendAppError(w, err, "Failed to open thread")
+        return
+    }
+
+    utils.SendSuccess(w, http.StatusOK, "Thread opened", thread)
+}
+
+// GetThreads lists the current user's threads, latest activity first, with
+// their unread counts
+func (h *PrescriptionHandler) GetThreads(w http.ResponseWriter, r *http.Request) {
+    userID := r.Header.Get("X-User-ID")
+    userRole := r.Header.Get("X-User-Role")
+
+    if userID == "" {
+        utils.SendError(w, http.StatusUnauthorized, "Unauthorized")
+        return
+    }
+
+    page, err := pagination.Parse(r.URL.Query(), repository.ThreadPages)
+    if err != nil {
+        utils.SendAppError(w, err, "Invalid pagination parameters")
+        return
+    }
+
+    var threads []models.MessageThread
+    var next string
+    switch userRole {
+    case "patient":
+        patientProfileID, lookupErr := h.repo.GetPatientProfileIDByUserID(r.Context(), userID)
+        if lookupErr != nil {
+            utils.SendErrorCode(w, apperrors.CodeProfileNotFound, "Patient profile not found")
+            return
+        }
+        threads, next, err = h.repo.GetThreadsByPatientID(r.Context(), patientProfileID, page)
+    case "doctor":
+        doctorProfile, lookupErr := h.repo.GetDoctorProfileByUserID(r.Context(), userID)
+        if lookupErr != nil {
+            utils.SendErrorCode(w, apperrors.CodeProfileNotFound, "Doctor profile not found")
+            return
+        }
+        threads, next, err = h.repo.GetThreadsByDoctorID(r.Context(), doctorProfile.ID, page)
+    default:
+        utils.SendError(w, http.StatusForbidden, "Only patients and doctors can message")
+        return
+    }
+    if err != nil {
+        utils.SendAppError(w, err, "Failed to retrieve threads")
+        return
+    }
+
+    utils.SendPage(w, http.StatusOK, "Threads retrieved", threads, next)
+}
+
+// threadParty loads a thread and checks the current user takes part in it.
+// Anyone else is told it does not exist. It writes the error response and
+// returns false when the user is not a party.
+func (h *PrescriptionHandler) threadParty(w http.ResponseWriter, r *http.Request, threadID string) (*models.MessageThread, models.UserRole, bool) {
+    userID := r.Header.Get("X-User-ID")
+    userRole := r.Header.Get("X-User-Role")
+
+    if userID == "" {
+        utils.SendError(w, http.StatusUnauthorized, "Unauthorized")
+        return nil, "", false
+    }
+
+    if threadID == "" {
+        utils.SendErrorCode(w, apperrors.CodeValidation, "Thread ID is required")
+        return nil, "", false
+    }
+
+    thread, err := h.repo.GetThreadByID(r.Context(), threadID)
+    if err != nil {
+        if err == sql.ErrNoRows {
+            utils.SendError(w, http.StatusNotFound, "Thread not found")
+            return nil, "", false
+        }
+        utils.SendAppError(w, err, "Failed to retrieve thread")
+        return nil, "", false
+    }
+
+    party := false
+    switch userRole {
+    case "patient":
+        patientProfileID, err := h.repo.GetPatientProfileIDByUserID(r.Context(), userID)
+        party = err == nil && patientProfileID == thread.PatientID
+    case "doctor":
+        doctorProfile, err := h.repo.GetDoctorProfileByUserID(r.Context(), userID)
+        party = err == nil && doctorProfile.ID == thread.DoctorID
+    }
+    if !party {
+        utils.SendError(w, http.StatusNotFound, "Thread not found")
+        return nil, "", false
+    }
+    return thread, models.UserRole(userRole), true
+}
+
+// GetMessages lists a thread's messages, newest first. Doctors only see
+// what was sent before the patient revoked their access.
+func (h *PrescriptionHandler) GetMessages(w http.ResponseWriter, r *http.Request) {
+    thread, reader, ok := h.threadParty(w, r, r.URL.Query().Get("thread_id"))
+    if !ok {
+        return
+    }
+
+    page, err := pagination.Parse(r.URL.Query(), repository.MessagePages)
+    if err != nil {
+        utils.SendAppError(w, err, "Invalid pagination parameters")
+        return
+    }
+
+    messages, next, err := h.repo.GetMessages(r.Context(), thread.ID, visibleUntil(thread, reader), page)
+    if err != nil {
+        utils.SendAppError(w, err, "Failed to retrieve messages")
+        return
+    }
+
+    utils.SendPage(w, http.StatusOK, "Messages retrieved", messages, next)
+}
+
+// SendMessage posts a message to a thread that is not frozen. The body is
+// JSON, or multipart with a body field and up to five files.
+func (h *PrescriptionHandler) SendMessage(w http.ResponseWriter, r *http.Request) {
+    thread, sender, ok := h.threadParty(w, r, r.URL.Query().Get("thread_id"))
+    if !ok {
+        return
+    }
+
+    var body string
+    var files []*multipart.FileHeader
+    if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+        // Parse multipart form (max 10MB)
+        r.Body = http.MaxBytesReader(w, r.Body, maxMultipartSize)
+        if err := r.ParseMultipartForm(maxMultipartSize); err != nil {
+            utils.SendErrorCode(w, apperrors.CodeFileTooLarge, "Attachments too large. Max size is 10MB")
+            return
+        }
+        body = r.FormValue("body")
+        files = r.MultipartForm.File["files"]
+    } else {
+        var req SendMessageRequest
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+            utils.SendError(w, http.StatusBadRequest, "Invalid request body")
+            return
+        }
+        body = req.Body
+    }
+
+    body = strings.TrimSpace(body)
+    if body == "" && len(files) == 0 {
+        utils.SendErrorCode(w, apperrors.CodeValidation, "A message needs a body or an attachment")
+        return
+    }
+    if len(body) > maxMessageLen {
+        utils.SendErrorCode(w, apperrors.CodeValidation, fmt.Sprintf("body must be at most %d characters", maxMessageLen))
+        return
+    }
+    if len(files) > maxAttachments {
+        utils.SendErrorCode(w, apperrors.CodeValidation, fmt.Sprintf("A message can have at most %d attachments", maxAttachments))
+        return
+    }
+    for _, header := range files {
+        ext := strings.ToLower(filepath.Ext(header.Filename))
+        if !allowedFileTypes[ext] {
+            utils.SendErrorCode(w, apperrors.CodeUnsupportedFile, "Invalid file type. Only PDF, JPG, JPEG, and PNG are allowed")
+            return
+        }
+        if limit := h.sizeLimit(ext); header.Size > limit {
+            utils.SendErrorCode(w, apperrors.CodeFileTooLarge, fmt.Sprintf("Attachment too large. Max size for %s files is %s", ext, FormatSize(limit)))
+            return
+        }
+    }
+    if thread.Frozen() {
+        utils.SendErrorCode(w, apperrors.CodeConflict, "The thread is closed because the patient revoked access")
+        return
+    }
+
+    // Attachments are stored with uploaded prescriptions, named after the
+    // patient
+    var stored []*storedFile
+    for _, header := range files {
+        f, err := h.storeAttachment(r.Context(), header, thread.PatientID)
+        if err != nil {
+            h.discardFiles(r.Context(), stored...)
+            utils.SendAppError(w, err, "Failed to save attachment")
+            return
+        }
+        stored = append(stored, f)
+    }
+
+    message := &models.Message{ThreadID: thread.ID, SenderRole: sender, Body: body}
+    err := h.repo.WithTx(r.Context(), func(ctx context.Context) error {
+        if err := h.repo.CreateMessage(ctx, message); err != nil {
+            return err
+        }
+        for i, header := range files {
+            attachment := &models.MessageAttachment{
+                MessageID: message.ID,
+                FileName:  header.Filename,
+                FileType:  strings.ToLower(filepath.Ext(header.Filename)),
+                FileSize:  stored[i].size,
+                FilePath:  stored[i].key,
+            }
+            if err := h.repo.CreateMessageAttachment(ctx, attachment); err != nil {
+                return err
+            }
+            message.Attachments = append(message.Attachments, *attachment)
+        }
+        return nil
+    })
+    if err != nil {
+        h.discardFiles(r.Context(), stored...)
+        if err == sql.ErrNoRows {
+            // Frozen between loading the thread and posting
+            utils.SendErrorCode(w, apperrors.CodeConflict, "The thread is closed because the patient revoked access")
+            return
+        }
+        utils.SendAppError(w, err, "Failed to send message")
+        return
+    }
+
+    utils.SendSuccess(w, http.StatusCreated, "Message sent", message)
+}
+
+// storeAttachment stores one uploaded attachment
+func (h *PrescriptionHandler) storeAttachment(ctx context.Context, header *multipart.FileHeader, owner string) (*storedFile, error) {
+    file, err := header.Open()
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+    return h.storeFile(ctx, file, owner, strings.ToLower(filepath.Ext(header.Filename)))
+}
+
+// MarkRead marks the other party's messages in a thread as read
+func (h *PrescriptionHandler) MarkRead(w http.ResponseWriter, r *http.Request) {
+    thread, reader, ok := h.threadParty(w, r, r.URL.Query().Get("thread_id"))
+    if !ok {
+        return
+    }
+
+    marked, err := h.repo.MarkMessagesRead(r.Context(), thread.ID, reader, visibleUntil(thread, reader))
+    if err != nil {
+        utils.SendAppError(w, err, "Failed to mark messages read")
+        return
+    }
+
+    utils.SendSuccess(w, http.StatusOK, "Messages marked read", ReadReceipt{Marked: marked})
+}
+
+// DownloadAttachment downloads a file sent with a message
+func (h *PrescriptionHandler) DownloadAttachment(w http.ResponseWriter, r *http.Request) {
+    if r.Header.Get("X-User-ID") == "" {
+        utils.SendError(w, http.StatusUnauthorized, "Unauthorized")
+        return
+    }
+
+    attachmentID := r.URL.Query().Get("id")
+    if attachmentID == "" {
+        utils.SendErrorCode(w, apperrors.CodeValidation, "Attachment ID is required")
+        return
+    }
+
+    attachment, message, err := h.repo.GetMessageAttachmentByID(r.Context(), attachmentID)
+    if err != nil {
+        if err == sql.ErrNoRows {
+            utils.SendError(w, http.StatusNotFound, "Attachment not found")
+            return
+        }
+        utils.SendAppError(w, err, "Failed to retrieve attachment")
+        return
+    }
+
+    thread, reader, ok := h.threadParty(w, r, message.ThreadID)
+    if !ok {
+        return
+    }
+    if until := visibleUntil(thread, reader); !until.IsZero() && message.CreatedAt.After(until) {
+        utils.SendError(w, http.StatusNotFound, "Attachment not found")
+        return
+    }
+
+    h.sendFile(w, r, attachment.FilePath, attachment.FileName, attachment.FileType)
+}
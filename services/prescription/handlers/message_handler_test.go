@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"health-bar/shared/apperrors"
+	"health-bar/shared/models"
+	"health-bar/shared/testutil"
+
+	"github.com/google/uuid"
+)
+
+func messageRequest(t *testing.T, threadID, body, fileName string, userID, role string) *http.Request {
+	t.Helper()
+
+	var form bytes.Buffer
+	writer := multipart.NewWriter(&form)
+	writer.WriteField("body", body)
+	part, err := writer.CreateFormFile("files", fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write([]byte("%PDF-1.4 report\n%%EOF\n"))
+	writer.Close()
+
+	req := testutil.NewRequest(t, http.MethodPost, "/api/messages?thread_id="+threadID, form.Bytes(), userID, role)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req
+}
+
+func TestMessaging(t *testing.T) {
+	h, db, _ := newTestHandler(t)
+	patient := db.AddPatient("p@test.com", "Pat")
+	doctor := db.AddDoctor("d@test.com", "Dr Who")
+	outsider := db.AddDoctor("o@test.com", "Dr No")
+
+	clock := time.Date(2024, 6, 3, 9, 0, 0, 0, time.UTC)
+	db.Now = func() time.Time {
+		clock = clock.Add(time.Minute)
+		return clock
+	}
+
+	open := func(req OpenThreadRequest, userID, role string) (*httptest.ResponseRecorder, models.MessageThread) {
+		rec, resp := testutil.Serve(t, h.OpenThread, testutil.NewRequest(t, http.MethodPost, "/api/messages/threads", req, userID, role))
+		var thread models.MessageThread
+		if rec.Code == http.StatusOK {
+			testutil.DecodeData(t, resp, &thread)
+		}
+		return rec, thread
+	}
+
+	rec, _ := open(OpenThreadRequest{DoctorID: doctor.ID}, patient.UserID, "patient")
+	testutil.ExpectStatus(t, rec, http.StatusForbidden)
+
+	db.Grant(patient.ID, doctor.ID)
+	_, thread := open(OpenThreadRequest{DoctorID: doctor.ID}, patient.UserID, "patient")
+	if _, again := open(OpenThreadRequest{PatientID: patient.ID}, doctor.UserID, "doctor"); again.ID != thread.ID {
+		t.Fatalf("doctor opened %s, patient %s", again.ID, thread.ID)
+	}
+
+	send := func(req *http.Request) (*httptest.ResponseRecorder, models.Message) {
+		rec, resp := testutil.Serve(t, h.SendMessage, req)
+		var message models.Message
+		if rec.Code == http.StatusCreated {
+			testutil.DecodeData(t, resp, &message)
+		}
+		return rec, message
+	}
+	target := "/api/messages?thread_id=" + thread.ID
+
+	rec, _ = send(testutil.NewRequest(t, http.MethodPost, target, SendMessageRequest{Body: "  "}, patient.UserID, "patient"))
+	testutil.ExpectStatus(t, rec, http.StatusBadRequest)
+	rec, _ = send(messageRequest(t, thread.ID, "", "run.exe", doctor.UserID, "doctor"))
+	testutil.ExpectStatus(t, rec, http.StatusUnsupportedMediaType)
+	rec, _ = send(testutil.NewRequest(t, http.MethodPost, target, SendMessageRequest{Body: "Hi"}, outsider.UserID, "doctor"))
+	testutil.ExpectStatus(t, rec, http.StatusNotFound)
+
+	rec, question := send(testutil.NewRequest(t, http.MethodPost, target, SendMessageRequest{Body: "Are my results in?"}, patient.UserID, "patient"))
+	testutil.ExpectStatus(t, rec, http.StatusCreated)
+	rec, answer := send(messageRequest(t, thread.ID, "Yes, attached", "report.pdf", doctor.UserID, "doctor"))
+	testutil.ExpectStatus(t, rec, http.StatusCreated)
+	if question.SenderRole != models.RolePatient || len(answer.Attachments) != 1 || answer.Attachments[0].FileName != "report.pdf" {
+		t.Fatalf("question = %+v, answer = %+v", question, answer)
+	}
+
+	threads := func(userID, role string) []models.MessageThread {
+		rec, resp := testutil.Serve(t, h.GetThreads, testutil.NewRequest(t, http.MethodGet, "/api/messages/threads", nil, userID, role))
+		testutil.ExpectStatus(t, rec, http.StatusOK)
+		var threads []models.MessageThread
+		testutil.DecodeData(t, resp, &threads)
+		return threads
+	}
+	if list := threads(doctor.UserID, "doctor"); len(list) != 1 || list[0].Unread != 1 || list[0].LastMessageAt == nil {
+		t.Fatalf("doctor's threads = %+v", list)
+	}
+	if list := threads(outsider.UserID, "doctor"); len(list) != 0 {
+		t.Fatalf("outsider's threads = %+v", list)
+	}
+
+	// Read receipts only cover the other party's messages
+	rec, resp := testutil.Serve(t, h.MarkRead, testutil.NewRequest(t, http.MethodPost, "/api/messages/read?thread_id="+thread.ID, nil, doctor.UserID, "doctor"))
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+	var receipt ReadReceipt
+	testutil.DecodeData(t, resp, &receipt)
+	if receipt.Marked != 1 || db.Messages.Rows[question.ID].ReadAt == nil || db.Messages.Rows[answer.ID].ReadAt != nil {
+		t.Fatalf("receipt = %+v", receipt)
+	}
+	if list := threads(patient.UserID, "patient"); len(list) != 1 || list[0].Unread != 1 {
+		t.Fatalf("patient's threads = %+v", list)
+	}
+
+	download := func(userID, role string) *httptest.ResponseRecorder {
+		req := testutil.NewRequest(t, http.MethodGet, "/api/messages/attachment?id="+answer.Attachments[0].ID, nil, userID, role)
+		rec := httptest.NewRecorder()
+		h.DownloadAttachment(rec, req)
+		return rec
+	}
+	if rec := download(patient.UserID, "patient"); rec.Body.String() != "%PDF-1.4 report\n%%EOF\n" || rec.Header().Get("Content-Type") != "application/pdf" {
+		t.Fatalf("download = %d %q (%s)", rec.Code, rec.Body.String(), rec.Header().Get("Content-Type"))
+	}
+	if rec := download(outsider.UserID, "doctor"); rec.Code != http.StatusNotFound {
+		t.Fatalf("outsider: status = %d, want 404", rec.Code)
+	}
+
+	// The patient revokes access: nobody can post, and the doctor stops
+	// seeing anything sent after the freeze
+	frozenAt := db.Now()
+	frozen := db.MessageThreads.Rows[thread.ID]
+	frozen.FrozenAt = &frozenAt
+	db.MessageThreads.Rows[thread.ID] = frozen
+	late := models.Message{ID: uuid.New().String(), ThreadID: thread.ID, SenderRole: models.RolePatient, Body: "Late", CreatedAt: db.Now()}
+	db.Messages.Rows[late.ID] = late
+
+	for userID, role := range map[string]string{doctor.UserID: "doctor", patient.UserID: "patient"} {
+		rec, resp = testutil.Serve(t, h.SendMessage, testutil.NewRequest(t, http.MethodPost, target, SendMessageRequest{Body: "Hello?"}, userID, role))
+		testutil.ExpectStatus(t, rec, http.StatusConflict)
+		testutil.ExpectCode(t, resp, apperrors.CodeConflict)
+	}
+
+	messages := func(userID, role string) []models.Message {
+		rec, resp := testutil.Serve(t, h.GetMessages, testutil.NewRequest(t, http.MethodGet, target, nil, userID, role))
+		testutil.ExpectStatus(t, rec, http.StatusOK)
+		var messages []models.Message
+		testutil.DecodeData(t, resp, &messages)
+		return messages
+	}
+	if got := messages(patient.UserID, "patient"); len(got) != 3 || got[0].ID != late.ID {
+		t.Fatalf("patient sees %+v", got)
+	}
+	if got := messages(doctor.UserID, "doctor"); len(got) != 2 || got[0].ID != answer.ID {
+		t.Fatalf("doctor sees %+v", got)
+	}
+	if list := threads(doctor.UserID, "doctor"); len(list) != 1 || list[0].Unread != 0 || !list[0].Frozen() {
+		t.Fatalf("doctor's frozen threads = %+v", list)
+	}
+}
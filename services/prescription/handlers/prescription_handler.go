@@ -1,30 +1,82 @@
 package handlers
 
 import (
+    "context"
     "database/sql"
+    "errors"
     "fmt"
+    "health-bar/shared/apperrors"
+    "health-bar/shared/filecheck"
+    "health-bar/shared/interactions"
     "health-bar/shared/models"
+    "health-bar/shared/notify"
+    "health-bar/shared/pagination"
+    "health-bar/shared/storage"
     "health-bar/shared/utils"
+    "health-bar/shared/webhooks"
     "health-bar/services/prescription/repository"
     "io"
+    "log"
     "net/http"
     "os"
     "path/filepath"
     "strings"
+    "sync/atomic"
+    "time"
 )
 
+// downloadURLExpiry is how long a presigned download URL stays valid
+const downloadURLExpiry = 5 * time.Minute
+
+// maxMultipartSize bounds a multipart request body, all of its files
+// included. Larger files go through resumable uploads.
+const maxMultipartSize = 10 << 20
+
 type PrescriptionHandler struct {
-    repo       *repository.PrescriptionRepository
-    uploadPath string
+    repo         repository.Store
+    files        storage.BlobStore
+    scanner      filecheck.Scanner
+    sizeLimits   map[string]int64
+    interactions atomic.Pointer[interactions.Dataset]
+    notifier     notify.Notifier
+    events       webhooks.Emitter
 }
 
-func NewPrescriptionHandler(repo *repository.PrescriptionRepository, uploadPath string) *PrescriptionHandler {
-    // Create upload directory if it doesn't exist
-    os.MkdirAll(uploadPath, 0755)
-    return &PrescriptionHandler{
+// NewPrescriptionHandler returns a handler keeping uploaded files, and
+// message attachments, in files
+func NewPrescriptionHandler(repo repository.Store, files storage.BlobStore) *PrescriptionHandler {
+    h := &PrescriptionHandler{
         repo:       repo,
-        uploadPath: uploadPath,
-    }
+        files:      files,
+        scanner:    filecheck.NopScanner{},
+        sizeLimits: DefaultSizeLimits,
+        notifier:   notify.LogNotifier{},
+        events:     webhooks.Discard{},
+    }
+    h.interactions.Store(interactions.Default())
+    return h
+}
+
+// UseInteractions replaces the interaction dataset prescriptions are checked
+// against. It is safe to call while requests are served.
+func (h *PrescriptionHandler) UseInteractions(dataset *interactions.Dataset) {
+    h.interactions.Store(dataset)
+}
+
+// UseNotifier sets where doctors are told about their patients' uploads
+func (h *PrescriptionHandler) UseNotifier(notifier notify.Notifier) {
+    h.notifier = notifier
+}
+
+// UseWebhooks sets where webhook events about uploads are stored
+func (h *PrescriptionHandler) UseWebhooks(events webhooks.Emitter) {
+    h.events = events
+}
+
+// UseScanner sets the malware scanner uploads must pass before they are
+// stored
+func (h *PrescriptionHandler) UseScanner(scanner filecheck.Scanner) {
+    h.scanner = scanner
 }
 
 // UploadPrescription handles file upload
@@ -43,57 +95,42 @@ func (h *PrescriptionHandler) UploadPrescription(w http.ResponseWriter, r *http.
     }
 
     // Get patient profile ID
-    patientProfileID, err := h.repo.GetPatientProfileIDByUserID(userID)
+    patientProfileID, err := h.repo.GetPatientProfileIDByUserID(r.Context(), userID)
     if err != nil {
-        utils.SendError(w, http.StatusNotFound, "Patient profile not found")
+        utils.SendErrorCode(w, apperrors.CodeProfileNotFound, "Patient profile not found")
         return
     }
 
     // Parse multipart form (max 10MB)
-    err = r.ParseMultipartForm(10 << 20) // 10 MB
+    r.Body = http.MaxBytesReader(w, r.Body, maxMultipartSize)
+    err = r.ParseMultipartForm(maxMultipartSize)
     if err != nil {
-        utils.SendError(w, http.StatusBadRequest, "File too large. Max size is 10MB")
+        utils.SendErrorCode(w, apperrors.CodeFileTooLarge, "File too large. Max size is 10MB")
         return
     }
 
     // Get file from form
     file, header, err := r.FormFile("file")
     if err != nil {
-        utils.SendError(w, http.StatusBadRequest, "No file uploaded")
+        utils.SendErrorCode(w, apperrors.CodeValidation, "No file uploaded")
         return
     }
     defer file.Close()
 
     // Validate file type (PDF or images)
     fileExt := strings.ToLower(filepath.Ext(header.Filename))
-    allowedTypes := map[string]bool{
-        ".pdf":  true,
-        ".jpg":  true,
-        ".jpeg": true,
-        ".png":  true,
-    }
-
-    if !allowedTypes[fileExt] {
-        utils.SendError(w, http.StatusBadRequest, "Invalid file type. Only PDF, JPG, JPEG, and PNG are allowed")
+    if !allowedFileTypes[fileExt] {
+        utils.SendErrorCode(w, apperrors.CodeUnsupportedFile, "Invalid file type. Only PDF, JPG, JPEG, and PNG are allowed")
         return
     }
-
-    // Generate unique filename
-    uniqueFilename := fmt.Sprintf("%s_%s%s", patientProfileID, utils.GenerateUUID(), fileExt)
-    filePath := filepath.Join(h.uploadPath, uniqueFilename)
-
-    // Create file on disk
-    dst, err := os.Create(filePath)
-    if err != nil {
-        utils.SendError(w, http.StatusInternalServerError, "Failed to save file")
+    if limit := h.sizeLimit(fileExt); header.Size > limit {
+        utils.SendErrorCode(w, apperrors.CodeFileTooLarge, fmt.Sprintf("File too large. Max size for %s files is %s", fileExt, FormatSize(limit)))
         return
     }
-    defer dst.Close()
 
-    // Copy uploaded file to destination
-    fileSize, err := io.Copy(dst, file)
+    stored, err := h.storeFile(r.Context(), file, patientProfileID, fileExt)
     if err != nil {
-        utils.SendError(w, http.StatusInternalServerError, "Failed to save file")
+        utils.SendAppError(w, err, "Failed to save file")
         return
     }
 
@@ -101,21 +138,88 @@ func (h *PrescriptionHandler) UploadPrescription(w http.ResponseWriter, r *http.
     prescription := &models.Prescription{
         FileName: header.Filename,
         FileType: fileExt,
-        FileSize: fileSize,
-        FilePath: uniqueFilename, // Store only filename, not full path
+        FileSize: stored.size,
+        FilePath: stored.key,
     }
 
-    if err := h.repo.CreatePrescription(patientProfileID, prescription); err != nil {
-        // Delete file if database insert fails
-        os.Remove(filePath)
-        utils.SendError(w, http.StatusInternalServerError, "Failed to save prescription record")
+    if err := h.savePrescription(r.Context(), patientProfileID, prescription, nil); err != nil {
+        // Delete file if database insert or commit fails
+        h.discardFiles(r.Context(), stored)
+        utils.SendAppError(w, err, "Failed to save prescription record")
         return
     }
 
     utils.SendSuccess(w, http.StatusCreated, "Prescription uploaded successfully", prescription)
 }
 
-// GetMyPrescriptions gets all prescriptions for the current patient
+// savePrescription records a stored upload and tells the patient's care team
+// about it. also, when set, runs in the same transaction.
+func (h *PrescriptionHandler) savePrescription(ctx context.Context, patientID string, prescription *models.Prescription, also func(ctx context.Context) error) error {
+    err := h.repo.WithTx(ctx, func(ctx context.Context) error {
+        if err := h.repo.CreatePrescription(ctx, patientID, prescription); err != nil {
+            return err
+        }
+        if also != nil {
+            if err := also(ctx); err != nil {
+                return err
+            }
+        }
+        return h.emitToCareTeam(ctx, patientID, func(doctorUserID string) webhooks.Event {
+            return webhooks.PrescriptionUploaded(doctorUserID, patientID, prescription.ID, prescription.FileName)
+        })
+    })
+    if err != nil {
+        return err
+    }
+
+    h.notifyCareTeam(ctx, patientID, func(doctorUserID, patientName string) notify.Message {
+        return notify.UploadCreated(doctorUserID, patientID, patientName, prescription.ID, prescription.FileName)
+    })
+    return nil
+}
+
+// notifyCareTeam tells every doctor with access to a patient about a change
+// to their record. The change is already committed, so failures are only
+// logged.
+func (h *PrescriptionHandler) notifyCareTeam(ctx context.Context, patientID string, message func(doctorUserID, patientName string) notify.Message) {
+    doctorUserIDs, err := h.repo.GetDoctorUserIDsWithAccess(ctx, patientID)
+    if err != nil {
+        log.Printf("Failed to look up doctors of patient %s to notify: %v", patientID, err)
+        return
+    }
+    if len(doctorUserIDs) == 0 {
+        return
+    }
+    patientName, err := h.repo.GetPatientName(ctx, patientID)
+    if err != nil {
+        log.Printf("Failed to look up patient %s to notify: %v", patientID, err)
+        return
+    }
+
+    msgs := make([]notify.Message, len(doctorUserIDs))
+    for i, doctorUserID := range doctorUserIDs {
+        msgs[i] = message(doctorUserID, patientName)
+    }
+    notify.Send(ctx, h.notifier, msgs...)
+}
+
+// emitToCareTeam stores an event for every doctor with access to a patient.
+// It runs in the transaction of the change, so the events commit with it.
+func (h *PrescriptionHandler) emitToCareTeam(ctx context.Context, patientID string, event func(doctorUserID string) webhooks.Event) error {
+    doctorUserIDs, err := h.repo.GetDoctorUserIDsWithAccess(ctx, patientID)
+    if err != nil {
+        return err
+    }
+
+    events := make([]webhooks.Event, len(doctorUserIDs))
+    for i, doctorUserID := range doctorUserIDs {
+        events[i] = event(doctorUserID)
+    }
+    return h.events.Emit(ctx, events...)
+}
+
+// GetMyPrescriptions gets all prescriptions for the current patient, uploaded
+// ones by default and issued ones with kind=issued
 func (h *PrescriptionHandler) GetMyPrescriptions(w http.ResponseWriter, r *http.Request) {
     userID := r.Header.Get("X-User-ID")
     userRole := r.Header.Get("X-User-Role")
@@ -131,19 +235,41 @@ func (h *PrescriptionHandler) GetMyPrescriptions(w http.ResponseWriter, r *http.
     }
 
     // Get patient profile ID
-    patientProfileID, err := h.repo.GetPatientProfileIDByUserID(userID)
+    patientProfileID, err := h.repo.GetPatientProfileIDByUserID(r.Context(), userID)
+    if err != nil {
+        utils.SendErrorCode(w, apperrors.CodeProfileNotFound, "Patient profile not found")
+        return
+    }
+
+    h.listPrescriptions(w, r, patientProfileID)
+}
+
+// listPrescriptions lists a patient's uploaded prescriptions, or with
+// kind=issued the ones doctors issued to them
+func (h *PrescriptionHandler) listPrescriptions(w http.ResponseWriter, r *http.Request, patientProfileID string) {
+    switch r.URL.Query().Get("kind") {
+    case "", "uploaded":
+    case "issued":
+        h.listEPrescriptions(w, r, patientProfileID)
+        return
+    default:
+        utils.SendErrorCode(w, apperrors.CodeValidation, "kind must be uploaded or issued")
+        return
+    }
+
+    page, err := pagination.Parse(r.URL.Query(), repository.PrescriptionPages)
     if err != nil {
-        utils.SendError(w, http.StatusNotFound, "Patient profile not found")
+        utils.SendAppError(w, err, "Invalid pagination parameters")
         return
     }
 
-    prescriptions, err := h.repo.GetPrescriptionsByPatientID(patientProfileID)
+    prescriptions, next, err := h.repo.GetPrescriptionsByPatientID(r.Context(), patientProfileID, page)
     if err != nil {
-        utils.SendError(w, http.StatusInternalServerError, "Failed to retrieve prescriptions")
+        utils.SendAppError(w, err, "Failed to retrieve prescriptions")
         return
     }
 
-    utils.SendSuccess(w, http.StatusOK, "Prescriptions retrieved", prescriptions)
+    utils.SendPage(w, http.StatusOK, "Prescriptions retrieved", prescriptions, next)
 }
 
 // GetPatientPrescriptions gets prescriptions for a specific patient (for doctors with access)
@@ -159,97 +285,141 @@ func (h *PrescriptionHandler) GetPatientPrescriptions(w http.ResponseWriter, r *
     // Get patient profile ID from query
     patientProfileID := r.URL.Query().Get("patient_id")
     if patientProfileID == "" {
-        utils.SendError(w, http.StatusBadRequest, "Patient ID is required")
+        utils.SendErrorCode(w, apperrors.CodeValidation, "Patient ID is required")
         return
     }
 
     // Check access
     if userRole == "patient" {
-        myProfileID, err := h.repo.GetPatientProfileIDByUserID(userID)
+        myProfileID, err := h.repo.GetPatientProfileIDByUserID(r.Context(), userID)
         if err != nil || myProfileID != patientProfileID {
-            utils.SendError(w, http.StatusForbidden, "Access denied")
+            utils.SendErrorCode(w, apperrors.CodeAccessDenied, "Access denied")
             return
         }
     } else if userRole == "doctor" {
-        hasAccess, err := h.repo.CheckDoctorAccess(userID, patientProfileID)
+        hasAccess, err := h.repo.CheckDoctorAccess(r.Context(), userID, patientProfileID)
         if err != nil || !hasAccess {
-            utils.SendError(w, http.StatusForbidden, "Access denied")
+            utils.SendErrorCode(w, apperrors.CodeAccessDenied, "Access denied")
             return
         }
     } else {
-        utils.SendError(w, http.StatusForbidden, "Access denied")
+        utils.SendErrorCode(w, apperrors.CodeAccessDenied, "Access denied")
+        return
+    }
+
+    h.listPrescriptions(w, r, patientProfileID)
+}
+
+// DownloadPrescription downloads a prescription file
+func (h *PrescriptionHandler) DownloadPrescription(w http.ResponseWriter, r *http.Request) {
+    prescription, ok := h.readablePrescription(w, r)
+    if !ok {
+        return
+    }
+
+    h.sendFile(w, r, prescription.FilePath, prescription.FileName, prescription.FileType)
+}
+
+// DownloadURL is a link a prescription file can be downloaded from without
+// credentials, until it expires
+type DownloadURL struct {
+    URL       string    `json:"url"`
+    ExpiresAt time.Time `json:"expires_at"`
+}
+
+// GetDownloadURL hands out a presigned URL for a prescription file, so
+// clients can fetch it straight from the blob store
+func (h *PrescriptionHandler) GetDownloadURL(w http.ResponseWriter, r *http.Request) {
+    prescription, ok := h.readablePrescription(w, r)
+    if !ok {
         return
     }
 
-    prescriptions, err := h.repo.GetPrescriptionsByPatientID(patientProfileID)
+    expiresAt := time.Now().Add(downloadURLExpiry).UTC()
+    url, err := h.files.Presign(r.Context(), prescription.FilePath, downloadURLExpiry)
+    if err == storage.ErrPresignUnsupported {
+        utils.SendErrorCode(w, apperrors.CodeNotImplemented, "Download URLs are not available, use /api/prescriptions/download")
+        return
+    }
     if err != nil {
-        utils.SendError(w, http.StatusInternalServerError, "Failed to retrieve prescriptions")
+        utils.SendAppError(w, err, "Failed to create download URL")
         return
     }
 
-    utils.SendSuccess(w, http.StatusOK, "Prescriptions retrieved", prescriptions)
+    utils.SendSuccess(w, http.StatusOK, "Download URL created", DownloadURL{URL: url, ExpiresAt: expiresAt})
 }
 
-// DownloadPrescription downloads a prescription file
-func (h *PrescriptionHandler) DownloadPrescription(w http.ResponseWriter, r *http.Request) {
+// readablePrescription loads the prescription named by the id query
+// parameter if the caller may read it, and sends the error response if not
+func (h *PrescriptionHandler) readablePrescription(w http.ResponseWriter, r *http.Request) (*models.Prescription, bool) {
     userID := r.Header.Get("X-User-ID")
     userRole := r.Header.Get("X-User-Role")
 
     if userID == "" {
         utils.SendError(w, http.StatusUnauthorized, "Unauthorized")
-        return
+        return nil, false
     }
 
     prescriptionID := r.URL.Query().Get("id")
     if prescriptionID == "" {
-        utils.SendError(w, http.StatusBadRequest, "Prescription ID is required")
-        return
+        utils.SendErrorCode(w, apperrors.CodeValidation, "Prescription ID is required")
+        return nil, false
     }
 
     // Get prescription
-    prescription, err := h.repo.GetPrescriptionByID(prescriptionID)
+    prescription, err := h.repo.GetPrescriptionByID(r.Context(), prescriptionID)
     if err != nil {
         if err == sql.ErrNoRows {
             utils.SendError(w, http.StatusNotFound, "Prescription not found")
-            return
+            return nil, false
         }
-        utils.SendError(w, http.StatusInternalServerError, "Failed to retrieve prescription")
-        return
+        utils.SendAppError(w, err, "Failed to retrieve prescription")
+        return nil, false
     }
 
     // Check access
     if userRole == "patient" {
-        patientProfileID, err := h.repo.GetPatientProfileIDByUserID(userID)
+        patientProfileID, err := h.repo.GetPatientProfileIDByUserID(r.Context(), userID)
         if err != nil || prescription.PatientID != patientProfileID {
-            utils.SendError(w, http.StatusForbidden, "Access denied")
-            return
+            utils.SendErrorCode(w, apperrors.CodeAccessDenied, "Access denied")
+            return nil, false
         }
     } else if userRole == "doctor" {
-        hasAccess, err := h.repo.CheckDoctorAccess(userID, prescription.PatientID)
+        hasAccess, err := h.repo.CheckDoctorAccess(r.Context(), userID, prescription.PatientID)
         if err != nil || !hasAccess {
-            utils.SendError(w, http.StatusForbidden, "Access denied")
-            return
+            utils.SendErrorCode(w, apperrors.CodeAccessDenied, "Access denied")
+            return nil, false
         }
     } else {
-        utils.SendError(w, http.StatusForbidden, "Access denied")
-        return
+        utils.SendErrorCode(w, apperrors.CodeAccessDenied, "Access denied")
+        return nil, false
     }
 
-    // Open file
-    filePath := filepath.Join(h.uploadPath, prescription.FilePath)
-    file, err := os.Open(filePath)
-    if err != nil {
+    return prescription, true
+}
+
+// sendFile streams a stored file to the client as an attachment
+func (h *PrescriptionHandler) sendFile(w http.ResponseWriter, r *http.Request, key, fileName, fileType string) {
+    file, _, err := h.files.Get(r.Context(), key)
+    if err == storage.ErrNotFound {
         utils.SendError(w, http.StatusNotFound, "File not found")
         return
     }
+    if err != nil {
+        utils.SendAppError(w, err, "Failed to open file")
+        return
+    }
     defer file.Close()
 
     // Set headers for file download
-    w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", prescription.FileName))
-    w.Header().Set("Content-Type", getContentType(prescription.FileType))
+    w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", fileName))
+    w.Header().Set("Content-Type", getContentType(fileType))
 
-    // Stream file to response
-    io.Copy(w, file)
+    // Stream file to response. Once it started, a file that fails to
+    // decrypt can only be cut short.
+    if _, err := io.Copy(w, file); err != nil {
+        log.Printf("Failed to send file %s: %v", key, err)
+    }
 }
 
 // DeletePrescription deletes a prescription
@@ -269,37 +439,134 @@ func (h *PrescriptionHandler) DeletePrescription(w http.ResponseWriter, r *http.
 
     prescriptionID := r.URL.Query().Get("id")
     if prescriptionID == "" {
-        utils.SendError(w, http.StatusBadRequest, "Prescription ID is required")
+        utils.SendErrorCode(w, apperrors.CodeValidation, "Prescription ID is required")
         return
     }
 
     // Get prescription
-    prescription, err := h.repo.GetPrescriptionByID(prescriptionID)
+    prescription, err := h.repo.GetPrescriptionByID(r.Context(), prescriptionID)
     if err != nil {
         utils.SendError(w, http.StatusNotFound, "Prescription not found")
         return
     }
 
     // Check if belongs to patient
-    patientProfileID, err := h.repo.GetPatientProfileIDByUserID(userID)
+    patientProfileID, err := h.repo.GetPatientProfileIDByUserID(r.Context(), userID)
     if err != nil || prescription.PatientID != patientProfileID {
-        utils.SendError(w, http.StatusForbidden, "Access denied")
+        utils.SendErrorCode(w, apperrors.CodeAccessDenied, "Access denied")
         return
     }
 
     // Delete from database
-    if err := h.repo.DeletePrescription(prescriptionID); err != nil {
-        utils.SendError(w, http.StatusInternalServerError, "Failed to delete prescription")
+    if err := h.repo.DeletePrescription(r.Context(), prescriptionID); err != nil {
+        utils.SendAppError(w, err, "Failed to delete prescription")
         return
     }
 
-    // Delete file from disk
-    filePath := filepath.Join(h.uploadPath, prescription.FilePath)
-    os.Remove(filePath) // Ignore error if file doesn't exist
+    // The record is gone, so a file left behind is only logged
+    if err := h.files.Delete(r.Context(), prescription.FilePath); err != nil {
+        log.Printf("Failed to delete file of prescription %s: %v", prescriptionID, err)
+    }
 
     utils.SendSuccess(w, http.StatusOK, "Prescription deleted successfully", nil)
 }
 
+// allowedFileTypes are the extensions uploaded files can have
+var allowedFileTypes = map[string]bool{
+    ".pdf":  true,
+    ".jpg":  true,
+    ".jpeg": true,
+    ".png":  true,
+}
+
+// storedFile is an upload written to the blob store under its final key.
+// It is written before the transaction that records it and removed with
+// discardFiles when that transaction fails, so a failed insert or commit
+// leaves no orphaned file behind.
+type storedFile struct {
+    key  string
+    size int64
+}
+
+// storeFile checks src and writes it, sanitized, to a new key named after
+// owner
+func (h *PrescriptionHandler) storeFile(ctx context.Context, src io.ReadSeeker, owner, ext string) (*storedFile, error) {
+    clean, size, err := h.checkFile(ctx, src, ext)
+    if err != nil {
+        return nil, err
+    }
+    defer clean.Close()
+
+    f := &storedFile{key: fmt.Sprintf("%s_%s%s", owner, utils.GenerateUUID(), ext), size: size}
+    if err := h.files.Put(ctx, f.key, clean, size, getContentType(ext)); err != nil {
+        return nil, err
+    }
+    return f, nil
+}
+
+// checkFile sanitizes src into a temporary file and scans the result. It
+// returns the file, rewound, and its size; the file is already unlinked, so
+// closing it is all the cleanup needed.
+func (h *PrescriptionHandler) checkFile(ctx context.Context, src io.ReadSeeker, ext string) (*os.File, int64, error) {
+    tmp, err := os.CreateTemp("", "upload-*"+ext)
+    if err != nil {
+        return nil, 0, err
+    }
+    os.Remove(tmp.Name())
+
+    if err := filecheck.Sanitize(src, ext, tmp); err != nil {
+        tmp.Close()
+        return nil, 0, fileCheckError(err)
+    }
+    size, err := tmp.Seek(0, io.SeekCurrent)
+    if err == nil {
+        _, err = tmp.Seek(0, io.SeekStart)
+    }
+    if err == nil {
+        err = fileCheckError(h.scanner.Scan(ctx, tmp))
+    }
+    if err == nil {
+        _, err = tmp.Seek(0, io.SeekStart)
+    }
+    if err != nil {
+        tmp.Close()
+        return nil, 0, err
+    }
+    return tmp, size, nil
+}
+
+// fileCheckError maps the errors of a rejected file to what clients are told
+func fileCheckError(err error) error {
+    var infected *filecheck.InfectedError
+    switch {
+    case err == nil:
+        return nil
+    case errors.Is(err, filecheck.ErrTypeMismatch):
+        return apperrors.Wrap(err, apperrors.CodeUnsupportedFile, "File content does not match its type")
+    case errors.Is(err, filecheck.ErrInvalidContent):
+        return apperrors.Wrap(err, apperrors.CodeUnsupportedFile, "File is damaged or not a valid PDF, JPG or PNG")
+    case errors.Is(err, filecheck.ErrActiveContent):
+        return apperrors.Wrap(err, apperrors.CodeUnsafeFile, "PDFs with scripts or launch actions are not accepted")
+    case errors.As(err, &infected):
+        log.Printf("Rejected upload containing %s", infected.Threat)
+        return apperrors.Wrap(err, apperrors.CodeUnsafeFile, "File failed the malware scan")
+    case errors.Is(err, filecheck.ErrScanFailed):
+        return apperrors.Wrap(err, apperrors.CodeUnavailable, "File could not be scanned, try again later")
+    }
+    return err
+}
+
+// discardFiles removes stored files whose records were never committed.
+// It runs even when the request was cancelled.
+func (h *PrescriptionHandler) discardFiles(ctx context.Context, files ...*storedFile) {
+    ctx = context.WithoutCancel(ctx)
+    for _, f := range files {
+        if err := h.files.Delete(ctx, f.key); err != nil {
+            log.Printf("Failed to delete uncommitted file %s: %v", f.key, err)
+        }
+    }
+}
+
 // Helper function to get content type
 func getContentType(fileType string) string {
     switch fileType {
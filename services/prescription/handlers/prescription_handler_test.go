@@ -0,0 +1,401 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"health-bar/services/prescription/repository"
+	"health-bar/shared/apperrors"
+	"health-bar/shared/envelope"
+	"health-bar/shared/filecheck"
+	"health-bar/shared/idempotency"
+	"health-bar/shared/memdb"
+	"health-bar/shared/models"
+	"health-bar/shared/notify"
+	"health-bar/shared/storage"
+	"health-bar/shared/testutil"
+	"health-bar/shared/utils"
+	"health-bar/shared/webhooks"
+	"image"
+	"image/jpeg"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func newTestHandler(t *testing.T) (*PrescriptionHandler, *memdb.DB, string) {
+	t.Helper()
+
+	db := memdb.New()
+	dir := t.TempDir()
+	files, err := storage.NewFilesystem(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewPrescriptionHandler(repository.NewMemoryRepository(db), files), db, dir
+}
+
+func uploadRequest(t *testing.T, fileName string, content []byte, userID, role string) *http.Request {
+	t.Helper()
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile("file", fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(content)
+	writer.Close()
+
+	req := testutil.NewRequest(t, http.MethodPost, "/api/prescriptions/upload", body.Bytes(), userID, role)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req
+}
+
+// testPDF is the smallest content that passes as a PDF
+const testPDF = "%PDF-1.4 test\n%%EOF\n"
+
+func upload(t *testing.T, h *PrescriptionHandler, userID string) models.Prescription {
+	t.Helper()
+
+	rec, resp := testutil.Serve(t, h.UploadPrescription, uploadRequest(t, "scan.pdf", []byte(testPDF), userID, "patient"))
+	testutil.ExpectStatus(t, rec, http.StatusCreated)
+
+	var prescription models.Prescription
+	testutil.DecodeData(t, resp, &prescription)
+	return prescription
+}
+
+func TestUploadPrescription(t *testing.T) {
+	h, db, dir := newTestHandler(t)
+	patient := db.AddPatient("p@test.com", "Pat")
+	doctor := db.AddDoctor("d@test.com", "Dr Who")
+
+	rec, _ := testutil.Serve(t, h.UploadPrescription, uploadRequest(t, "scan.pdf", []byte("x"), doctor.UserID, "doctor"))
+	testutil.ExpectStatus(t, rec, http.StatusForbidden)
+
+	rec, _ = testutil.Serve(t, h.UploadPrescription, uploadRequest(t, "run.exe", []byte("x"), patient.UserID, "patient"))
+	testutil.ExpectStatus(t, rec, http.StatusUnsupportedMediaType)
+
+	prescription := upload(t, h, patient.UserID)
+	if prescription.PatientID != patient.ID || prescription.FileType != ".pdf" || prescription.FileSize != int64(len(testPDF)) {
+		t.Fatalf("prescription = %+v", prescription)
+	}
+
+	stored, err := os.ReadFile(filepath.Join(dir, prescription.FilePath))
+	if err != nil || string(stored) != testPDF {
+		t.Fatalf("stored file = %q, %v", stored, err)
+	}
+
+	leftovers, _ := filepath.Glob(filepath.Join(dir, "*.tmp"))
+	if len(leftovers) != 0 {
+		t.Fatalf("temporary files left behind: %v", leftovers)
+	}
+}
+
+func TestUploadPrescriptionNotifiesDoctors(t *testing.T) {
+	h, db, _ := newTestHandler(t)
+	recorder := &notify.Recorder{}
+	h.UseNotifier(recorder)
+	patient := db.AddPatient("p@test.com", "Pat")
+	granted := db.AddDoctor("d@test.com", "Dr Who")
+	db.AddDoctor("o@test.com", "Dr No")
+	db.Grant(patient.ID, granted.ID)
+
+	prescription := upload(t, h, patient.UserID)
+
+	msgs := recorder.Messages()
+	if len(msgs) != 1 {
+		t.Fatalf("sent %d messages, want 1 to the doctor with access", len(msgs))
+	}
+	msg := msgs[0]
+	if msg.Kind != notify.KindUploadCreated || msg.UserID != granted.UserID || msg.Data["document_id"] != prescription.ID || msg.Subject != "Pat uploaded scan.pdf" {
+		t.Fatalf("message = %+v", msg)
+	}
+}
+
+func TestUploadPrescriptionEmitsWebhook(t *testing.T) {
+	h, db, _ := newTestHandler(t)
+	h.UseWebhooks(webhooks.NewMemoryEmitter(db))
+	patient := db.AddPatient("p@test.com", "Pat")
+	granted := db.AddDoctor("d@test.com", "Dr Who")
+	other := db.AddDoctor("o@test.com", "Dr No")
+	db.Grant(patient.ID, granted.ID)
+	sub := db.AddWebhookSubscription(granted.UserID, "https://clinic.test/hooks", webhooks.EventPrescriptionUploaded)
+	db.AddWebhookSubscription(other.UserID, "https://other.test/hooks", webhooks.EventPrescriptionUploaded)
+
+	prescription := upload(t, h, patient.UserID)
+
+	if len(db.WebhookDeliveries.Rows) != 1 {
+		t.Fatalf("deliveries = %+v", db.WebhookDeliveries.Rows)
+	}
+	for _, d := range db.WebhookDeliveries.Rows {
+		if d.SubscriptionID != sub.ID || d.EventType != webhooks.EventPrescriptionUploaded || !strings.Contains(string(d.Payload), prescription.ID) {
+			t.Fatalf("delivery = %+v", d)
+		}
+	}
+}
+
+// failingEmitter fails every emit, so the upload's transaction rolls back
+type failingEmitter struct{}
+
+func (failingEmitter) Emit(context.Context, ...webhooks.Event) error {
+	return errors.New("emit failed")
+}
+
+func TestUploadPrescriptionRemovesFileOnFailure(t *testing.T) {
+	h, db, dir := newTestHandler(t)
+	h.UseWebhooks(failingEmitter{})
+	patient := db.AddPatient("p@test.com", "Pat")
+
+	rec, _ := testutil.Serve(t, h.UploadPrescription, uploadRequest(t, "scan.pdf", []byte(testPDF), patient.UserID, "patient"))
+	testutil.ExpectStatus(t, rec, http.StatusInternalServerError)
+
+	if len(db.Prescriptions.Rows) != 0 {
+		t.Fatalf("prescriptions = %+v", db.Prescriptions.Rows)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Fatalf("files left behind: %v", entries)
+	}
+}
+
+// stubScanner returns err for every scan
+type stubScanner struct{ err error }
+
+func (s stubScanner) Scan(ctx context.Context, r io.Reader) error {
+	io.Copy(io.Discard, r)
+	return s.err
+}
+
+func TestUploadPrescriptionChecksContent(t *testing.T) {
+	h, db, dir := newTestHandler(t)
+	patient := db.AddPatient("p@test.com", "Pat")
+
+	for _, tt := range []struct {
+		name     string
+		fileName string
+		content  string
+		code     apperrors.Code
+	}{
+		{"executable as PDF", "scan.pdf", "MZ\x90\x00\x03\x00\x00\x00", apperrors.CodeUnsupportedFile},
+		{"PDF as PNG", "scan.png", testPDF, apperrors.CodeUnsupportedFile},
+		{"damaged JPEG", "scan.jpg", "\xff\xd8\xff\xe0\x00\x10JFIF", apperrors.CodeUnsupportedFile},
+		{"PDF with JavaScript", "scan.pdf", "%PDF-1.4\n<< /OpenAction << /S /JavaScript /JS (app.alert(1)) >> >>\n%%EOF\n", apperrors.CodeUnsafeFile},
+	} {
+		rec, resp := testutil.Serve(t, h.UploadPrescription, uploadRequest(t, tt.fileName, []byte(tt.content), patient.UserID, "patient"))
+		if rec.Code != tt.code.Status() || resp.Code != tt.code {
+			t.Errorf("%s: status = %d, code = %q", tt.name, rec.Code, resp.Code)
+		}
+	}
+
+	h.UseScanner(stubScanner{&filecheck.InfectedError{Threat: "Eicar-Test-Signature"}})
+	_, resp := testutil.Serve(t, h.UploadPrescription, uploadRequest(t, "scan.pdf", []byte(testPDF), patient.UserID, "patient"))
+	testutil.ExpectCode(t, resp, apperrors.CodeUnsafeFile)
+
+	h.UseScanner(stubScanner{fmt.Errorf("%w: connection refused", filecheck.ErrScanFailed)})
+	_, resp = testutil.Serve(t, h.UploadPrescription, uploadRequest(t, "scan.pdf", []byte(testPDF), patient.UserID, "patient"))
+	testutil.ExpectCode(t, resp, apperrors.CodeUnavailable)
+
+	if len(db.Prescriptions.Rows) != 0 {
+		t.Fatalf("prescriptions = %+v", db.Prescriptions.Rows)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Fatalf("rejected files stored: %v", entries)
+	}
+}
+
+func TestUploadPrescriptionCapsRequestSize(t *testing.T) {
+	h, db, _ := newTestHandler(t)
+	patient := db.AddPatient("p@test.com", "Pat")
+
+	// Within the PDF limit, but too big for a single request
+	large := append([]byte("%PDF-1.4\n"), bytes.Repeat([]byte("%\n"), maxMultipartSize/2)...)
+	rec, resp := testutil.Serve(t, h.UploadPrescription, uploadRequest(t, "scan.pdf", large, patient.UserID, "patient"))
+	testutil.ExpectStatus(t, rec, http.StatusRequestEntityTooLarge)
+	testutil.ExpectCode(t, resp, apperrors.CodeFileTooLarge)
+	if len(db.Prescriptions.Rows) != 0 {
+		t.Fatalf("prescriptions = %+v", db.Prescriptions.Rows)
+	}
+}
+
+func TestUploadPrescriptionStripsImageMetadata(t *testing.T) {
+	h, db, dir := newTestHandler(t)
+	patient := db.AddPatient("p@test.com", "Pat")
+
+	var jpg bytes.Buffer
+	jpeg.Encode(&jpg, image.NewGray(image.Rect(0, 0, 8, 8)), nil)
+	exif := "Exif\x00\x00GPSLatitude 52.5200"
+	content := append([]byte{0xFF, 0xD8, 0xFF, 0xE1, 0, byte(len(exif) + 2)}, exif...)
+	content = append(content, jpg.Bytes()[2:]...)
+
+	rec, resp := testutil.Serve(t, h.UploadPrescription, uploadRequest(t, "photo.jpg", content, patient.UserID, "patient"))
+	testutil.ExpectStatus(t, rec, http.StatusCreated)
+	var prescription models.Prescription
+	testutil.DecodeData(t, resp, &prescription)
+
+	stored, err := os.ReadFile(filepath.Join(dir, prescription.FilePath))
+	if err != nil || bytes.Contains(stored, []byte("GPS")) {
+		t.Fatalf("stored file kept its metadata: %v", err)
+	}
+	if prescription.FileSize != int64(len(stored)) {
+		t.Fatalf("file size = %d, stored %d bytes", prescription.FileSize, len(stored))
+	}
+}
+
+func TestDownloadPrescriptionAccessControl(t *testing.T) {
+	h, db, _ := newTestHandler(t)
+	patient := db.AddPatient("p@test.com", "Pat")
+	other := db.AddPatient("o@test.com", "Other")
+	doctor := db.AddDoctor("d@test.com", "Dr Who")
+	prescription := upload(t, h, patient.UserID)
+
+	download := func(userID, role string) *httptest.ResponseRecorder {
+		req := testutil.NewRequest(t, http.MethodGet, "/api/prescriptions/download?id="+prescription.ID, nil, userID, role)
+		rec := httptest.NewRecorder()
+		h.DownloadPrescription(rec, req)
+		return rec
+	}
+
+	if rec := download(other.UserID, "patient"); rec.Code != http.StatusForbidden {
+		t.Fatalf("other patient: status = %d, want 403", rec.Code)
+	}
+	if rec := download(doctor.UserID, "doctor"); rec.Code != http.StatusForbidden {
+		t.Fatalf("doctor without grant: status = %d, want 403", rec.Code)
+	}
+
+	db.Grant(patient.ID, doctor.ID)
+	rec := download(doctor.UserID, "doctor")
+	if rec.Code != http.StatusOK || rec.Body.String() != testPDF {
+		t.Fatalf("doctor with grant: status = %d body = %q", rec.Code, rec.Body.String())
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/pdf" {
+		t.Fatalf("Content-Type = %q, want application/pdf", ct)
+	}
+}
+
+func TestGetDownloadURL(t *testing.T) {
+	db := memdb.New()
+	files, _ := storage.NewFilesystem(t.TempDir())
+	h := NewPrescriptionHandler(repository.NewMemoryRepository(db), files)
+	patient := db.AddPatient("p@test.com", "Pat")
+	other := db.AddPatient("o@test.com", "Other")
+	prescription := upload(t, h, patient.UserID)
+
+	downloadURL := func(userID string) (*httptest.ResponseRecorder, utils.Response) {
+		req := testutil.NewRequest(t, http.MethodGet, "/api/prescriptions/download-url?id="+prescription.ID, nil, userID, "patient")
+		return testutil.Serve(t, h.GetDownloadURL, req)
+	}
+
+	// A directory without a signing key cannot hand out URLs
+	rec, resp := downloadURL(patient.UserID)
+	testutil.ExpectStatus(t, rec, http.StatusNotImplemented)
+	testutil.ExpectCode(t, resp, apperrors.CodeNotImplemented)
+
+	router := mux.NewRouter()
+	RegisterRoutes(router, h, idempotency.NewMemoryStore())
+	server := httptest.NewServer(router)
+	defer server.Close()
+	files.SignURLs(server.URL+"/api/prescriptions/files", []byte("secret"))
+
+	rec, _ = downloadURL(other.UserID)
+	testutil.ExpectStatus(t, rec, http.StatusForbidden)
+
+	rec, resp = downloadURL(patient.UserID)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+	var link DownloadURL
+	testutil.DecodeData(t, resp, &link)
+	if link.ExpiresAt.IsZero() {
+		t.Fatalf("download URL = %+v", link)
+	}
+
+	// The URL works without credentials
+	got, err := http.Get(link.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(got.Body)
+	got.Body.Close()
+	if got.StatusCode != http.StatusOK || string(body) != testPDF {
+		t.Fatalf("GET download URL = %d %q", got.StatusCode, body)
+	}
+	if ct := got.Header.Get("Content-Type"); ct != "application/pdf" {
+		t.Fatalf("Content-Type = %q, want application/pdf", ct)
+	}
+}
+
+func TestDownloadEncryptedPrescription(t *testing.T) {
+	db := memdb.New()
+	dir := t.TempDir()
+	files, _ := storage.NewFilesystem(dir)
+	masterKey, _ := envelope.GenerateKey("k1")
+	keys, _ := envelope.ParseKeyring(masterKey)
+	h := NewPrescriptionHandler(repository.NewMemoryRepository(db), storage.NewEncrypted(files, keys))
+	patient := db.AddPatient("p@test.com", "Pat")
+	prescription := upload(t, h, patient.UserID)
+
+	stored, _ := os.ReadFile(filepath.Join(dir, prescription.FilePath))
+	if bytes.Contains(stored, []byte("%PDF")) {
+		t.Fatalf("file stored in plaintext: %q", stored)
+	}
+
+	req := testutil.NewRequest(t, http.MethodGet, "/api/prescriptions/download?id="+prescription.ID, nil, patient.UserID, "patient")
+	rec := httptest.NewRecorder()
+	h.DownloadPrescription(rec, req)
+	if rec.Code != http.StatusOK || rec.Body.String() != testPDF {
+		t.Fatalf("download: status = %d body = %q", rec.Code, rec.Body.String())
+	}
+
+	// The store would hand out URLs to ciphertext
+	req = testutil.NewRequest(t, http.MethodGet, "/api/prescriptions/download-url?id="+prescription.ID, nil, patient.UserID, "patient")
+	rec, resp := testutil.Serve(t, h.GetDownloadURL, req)
+	testutil.ExpectStatus(t, rec, http.StatusNotImplemented)
+	testutil.ExpectCode(t, resp, apperrors.CodeNotImplemented)
+}
+
+func TestDeletePrescriptionRemovesFile(t *testing.T) {
+	h, db, dir := newTestHandler(t)
+	patient := db.AddPatient("p@test.com", "Pat")
+	other := db.AddPatient("o@test.com", "Other")
+	prescription := upload(t, h, patient.UserID)
+
+	req := testutil.NewRequest(t, http.MethodDelete, "/api/prescriptions?id="+prescription.ID, nil, other.UserID, "patient")
+	rec, _ := testutil.Serve(t, h.DeletePrescription, req)
+	testutil.ExpectStatus(t, rec, http.StatusForbidden)
+
+	req = testutil.NewRequest(t, http.MethodDelete, "/api/prescriptions?id="+prescription.ID, nil, patient.UserID, "patient")
+	rec, _ = testutil.Serve(t, h.DeletePrescription, req)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+
+	if _, err := os.Stat(filepath.Join(dir, prescription.FilePath)); !os.IsNotExist(err) {
+		t.Fatalf("file still on disk after delete: %v", err)
+	}
+}
+
+func TestGetPatientPrescriptionsAccessControl(t *testing.T) {
+	h, db, _ := newTestHandler(t)
+	patient := db.AddPatient("p@test.com", "Pat")
+	doctor := db.AddDoctor("d@test.com", "Dr Who")
+	upload(t, h, patient.UserID)
+
+	req := testutil.NewRequest(t, http.MethodGet, "/api/prescriptions/patient?patient_id="+patient.ID, nil, doctor.UserID, "doctor")
+	rec, _ := testutil.Serve(t, h.GetPatientPrescriptions, req)
+	testutil.ExpectStatus(t, rec, http.StatusForbidden)
+
+	db.Grant(patient.ID, doctor.ID)
+	rec, resp := testutil.Serve(t, h.GetPatientPrescriptions, req)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+
+	var prescriptions []models.Prescription
+	testutil.DecodeData(t, resp, &prescriptions)
+	if len(prescriptions) != 1 {
+		t.Fatalf("got %d prescriptions, want 1", len(prescriptions))
+	}
+}
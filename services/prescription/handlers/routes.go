@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"net/http"
+
+	"health-bar/shared/apperrors"
+	"health-bar/shared/idempotency"
+	"health-bar/shared/middleware"
+
+	"github.com/gorilla/mux"
+)
+
+// RegisterRoutes mounts the prescription service endpoints on router. Creates
+// honour Idempotency-Key headers, recorded in keys.
+func RegisterRoutes(router *mux.Router, h *PrescriptionHandler, keys idempotency.Store) {
+	idempotent := idempotency.New(keys)
+
+	router.NotFoundHandler = apperrors.NotFoundHandler()
+	router.MethodNotAllowedHandler = apperrors.MethodNotAllowedHandler()
+
+	// Prescription routes (protected)
+	router.HandleFunc("/api/prescriptions/upload", middleware.AuthMiddleware(idempotent.Wrap(h.UploadPrescription))).Methods("POST")
+	router.HandleFunc("/api/prescriptions/my", middleware.AuthMiddleware(h.GetMyPrescriptions)).Methods("GET")
+	router.HandleFunc("/api/prescriptions/patient", middleware.AuthMiddleware(h.GetPatientPrescriptions)).Methods("GET")
+	router.HandleFunc("/api/prescriptions/download", middleware.AuthMiddleware(h.DownloadPrescription)).Methods("GET")
+	router.HandleFunc("/api/prescriptions/download-url", middleware.AuthMiddleware(h.GetDownloadURL)).Methods("GET")
+	router.HandleFunc("/api/prescriptions", middleware.AuthMiddleware(h.DeletePrescription)).Methods("DELETE")
+
+	// Resumable uploads (tus). Creates are not idempotent.Wrap'ed: a replay
+	// would lose the Location header, and retrying a create only leaves an
+	// unused upload to expire.
+	router.HandleFunc("/api/prescriptions/uploads", h.UploadOptions).Methods("OPTIONS")
+	router.HandleFunc("/api/prescriptions/uploads", middleware.AuthMiddleware(h.CreateUpload)).Methods("POST")
+	router.HandleFunc("/api/prescriptions/uploads", middleware.AuthMiddleware(h.GetUploadOffset)).Methods("HEAD")
+	router.HandleFunc("/api/prescriptions/uploads", middleware.AuthMiddleware(h.PatchUpload)).Methods("PATCH")
+	router.HandleFunc("/api/prescriptions/uploads", middleware.AuthMiddleware(h.TerminateUpload)).Methods("DELETE")
+
+	// Presigned download URLs of a store that serves them itself carry their
+	// own authorization
+	if files, ok := h.files.(http.Handler); ok {
+		router.Handle("/api/prescriptions/files", files).Methods("GET", "HEAD")
+	}
+
+	// Structured prescriptions issued by doctors
+	router.HandleFunc("/api/prescriptions/issue", middleware.AuthMiddleware(idempotent.Wrap(h.IssuePrescription))).Methods("POST")
+	router.HandleFunc("/api/prescriptions/interactions", middleware.AuthMiddleware(h.CheckInteractions)).Methods("GET")
+	router.HandleFunc("/api/prescriptions/issued", middleware.AuthMiddleware(h.GetIssuedPrescriptions)).Methods("GET")
+	router.HandleFunc("/api/prescriptions/issued/detail", middleware.AuthMiddleware(h.GetIssuedPrescription)).Methods("GET")
+	router.HandleFunc("/api/prescriptions/issued/cancel", middleware.AuthMiddleware(h.CancelIssuedPrescription)).Methods("POST")
+
+	// Medication schedules and adherence
+	router.HandleFunc("/api/prescriptions/schedules", middleware.AuthMiddleware(idempotent.Wrap(h.CreateSchedule))).Methods("POST")
+	router.HandleFunc("/api/prescriptions/schedules", middleware.AuthMiddleware(h.GetSchedules)).Methods("GET")
+	router.HandleFunc("/api/prescriptions/schedules/end", middleware.AuthMiddleware(h.EndSchedule)).Methods("POST")
+	router.HandleFunc("/api/prescriptions/schedule", middleware.AuthMiddleware(h.DeleteSchedule)).Methods("DELETE")
+	router.HandleFunc("/api/prescriptions/doses", middleware.AuthMiddleware(h.LogDose)).Methods("POST")
+	router.HandleFunc("/api/prescriptions/doses", middleware.AuthMiddleware(h.GetDoses)).Methods("GET")
+	router.HandleFunc("/api/prescriptions/adherence", middleware.AuthMiddleware(h.GetAdherence)).Methods("GET")
+
+	// Messaging between patients and doctors, routed here by the gateway
+	// because attachments share the upload storage
+	router.HandleFunc("/api/messages/threads", middleware.AuthMiddleware(h.OpenThread)).Methods("POST")
+	router.HandleFunc("/api/messages/threads", middleware.AuthMiddleware(h.GetThreads)).Methods("GET")
+	router.HandleFunc("/api/messages", middleware.AuthMiddleware(idempotent.Wrap(h.SendMessage))).Methods("POST")
+	router.HandleFunc("/api/messages", middleware.AuthMiddleware(h.GetMessages)).Methods("GET")
+	router.HandleFunc("/api/messages/read", middleware.AuthMiddleware(h.MarkRead)).Methods("POST")
+	router.HandleFunc("/api/messages/attachment", middleware.AuthMiddleware(h.DownloadAttachment)).Methods("GET")
+}
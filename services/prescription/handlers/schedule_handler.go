@@ -0,0 +1,485 @@
+package handlers
+
+import (
+    "database/sql"
+    "encoding/json"
+    "fmt"
+    "health-bar/shared/adherence"
+    "health-bar/shared/apperrors"
+    "health-bar/shared/models"
+    "health-bar/shared/pagination"
+    "health-bar/shared/utils"
+    "net/http"
+    "strings"
+    "time"
+)
+
+const (
+    maxNotesLen          = 2000
+    defaultAdherenceDays = 30
+    maxAdherenceDays     = 366
+    // doseLogAhead is how long before its time a dose can be logged
+    doseLogAhead = 2 * time.Hour
+)
+
+type CreateScheduleRequest struct {
+    PrescriptionID *string  `json:"prescription_id"` // Optional issued prescription the schedule follows
+    Medication     string   `json:"medication"`      // Defaults to the prescription's drug
+    Dose           string   `json:"dose"`            // e.g. "1 tablet"
+    Times          []string `json:"times"`           // Local times of day, "HH:MM"
+    Timezone       string   `json:"timezone"`        // IANA name, e.g. "Europe/Berlin"
+    StartDate      string   `json:"start_date"`      // YYYY-MM-DD, defaults to today in timezone
+    EndDate        *string  `json:"end_date"`        // YYYY-MM-DD, omitted until further notice
+    Notes          string   `json:"notes"`
+}
+
+type EndScheduleRequest struct {
+    EndDate string `json:"end_date"` // YYYY-MM-DD, defaults to today in the schedule's timezone
+}
+
+type LogDoseRequest struct {
+    ScheduleID   string            `json:"schedule_id"`
+    ScheduledFor time.Time         `json:"scheduled_for"` // The dose's time, RFC 3339
+    Status       models.DoseStatus `json:"status"`        // taken, late or skipped
+    TakenAt      *time.Time        `json:"taken_at"`      // Defaults to now for taken doses
+    Note         string            `json:"note"`
+}
+
+func (req CreateScheduleRequest) schedule(now time.Time) (*models.MedicationSchedule, error) {
+    times, err := adherence.ParseTimes(req.Times)
+    if err != nil {
+        return nil, apperrors.New(apperrors.CodeValidation, err.Error())
+    }
+    timezone := strings.TrimSpace(req.Timezone)
+    loc, err := adherence.Location(timezone)
+    if err != nil {
+        return nil, apperrors.New(apperrors.CodeValidation, err.Error())
+    }
+
+    medication := strings.TrimSpace(req.Medication)
+    dose := strings.TrimSpace(req.Dose)
+    if len(medication) > 255 || len(dose) > 100 {
+        return nil, apperrors.New(apperrors.CodeValidation, "medication or dose is too long")
+    }
+    if len(req.Notes) > maxNotesLen {
+        return nil, apperrors.New(apperrors.CodeValidation, fmt.Sprintf("notes must be at most %d characters", maxNotesLen))
+    }
+
+    startDate, err := time.Parse("2006-01-02", pagination.Date(now.In(loc)))
+    if err != nil {
+        return nil, err
+    }
+    if req.StartDate != "" {
+        if startDate, err = time.Parse("2006-01-02", req.StartDate); err != nil {
+            return nil, apperrors.New(apperrors.CodeValidation, "Invalid start_date. Use YYYY-MM-DD")
+        }
+    }
+
+    schedule := &models.MedicationSchedule{
+        Medication: medication,
+        Dose:       dose,
+        Times:      times,
+        Timezone:   timezone,
+        StartDate:  startDate,
+        Notes:      strings.TrimSpace(req.Notes),
+    }
+    if req.EndDate != nil && *req.EndDate != "" {
+        endDate, err := time.Parse("2006-01-02", *req.EndDate)
+        if err != nil {
+            return nil, apperrors.New(apperrors.CodeValidation, "Invalid end_date. Use YYYY-MM-DD")
+        }
+        if endDate.Before(startDate) {
+            return nil, apperrors.New(apperrors.CodeValidation, "end_date must not be before start_date")
+        }
+        schedule.EndDate = &endDate
+    }
+    if req.PrescriptionID != nil && *req.PrescriptionID != "" {
+        schedule.PrescriptionID = req.PrescriptionID
+    }
+    return schedule, nil
+}
+
+// CreateSchedule records when the patient takes a medication. Linking an
+// issued prescription fills in the medication and dose it names.
+func (h *PrescriptionHandler) CreateSchedule(w http.ResponseWriter, r *http.Request) {
+    userID := r.Header.Get("X-User-ID")
+    userRole := r.Header.Get("X-User-Role")
+
+    if userID == "" {
+        utils.SendError(w, http.StatusUnauthorized, "Unauthorized")
+        return
+    }
+
+    if userRole != "patient" {
+        utils.SendError(w, http.StatusForbidden, "Only patients can create medication schedules")
+        return
+    }
+
+    var req CreateScheduleRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        utils.SendError(w, http.StatusBadRequest, "Invalid request body")
+        return
+    }
+
+    schedule, err := req.schedule(time.Now())
+    if err != nil {
+        utils.SendAppError(w, err, "Invalid schedule")
+        return
+    }
+
+    patientProfileID, err := h.repo.GetPatientProfileIDByUserID(r.Context(), userID)
+    if err != nil {
+        utils.SendErrorCode(w, apperrors.CodeProfileNotFound, "Patient profile not found")
+        return
+    }
+
+    if schedule.PrescriptionID != nil {
+        prescription, err := h.repo.GetEPrescriptionByID(r.Context(), *schedule.PrescriptionID)
+        if err != nil || prescription.PatientID != patientProfileID {
+            if err != nil && err != sql.ErrNoRows {
+                utils.SendAppError(w, err, "Failed to create schedule")
+                return
+            }
+            utils.SendErrorCode(w, apperrors.CodeInvalidReference, "prescription_id must be one of your issued prescriptions")
+            return
+        }
+        if schedule.Medication == "" {
+            schedule.Medication = prescription.Drug
+        }
+        if schedule.Dose == "" {
+            schedule.Dose = prescription.Strength
+        }
+    }
+    if schedule.Medication == "" {
+        utils.SendErrorCode(w, apperrors.CodeValidation, "medication is required")
+        return
+    }
+
+    schedule.PatientID = patientProfileID
+    if err := h.repo.CreateMedicationSchedule(r.Context(), schedule); err != nil {
+        utils.SendAppError(w, err, "Failed to create schedule")
+        return
+    }
+
+    utils.SendSuccess(w, http.StatusCreated, "Schedule created", schedule)
+}
+
+// GetSchedules lists a patient's medication schedules. Patients read their
+// own; doctors name a patient who granted them access.
+func (h *PrescriptionHandler) GetSchedules(w http.ResponseWriter, r *http.Request) {
+    patientProfileID, ok := h.readablePatient(w, r)
+    if !ok {
+        return
+    }
+
+    schedules, err := h.repo.ListMedicationSchedules(r.Context(), patientProfileID)
+    if err != nil {
+        utils.SendAppError(w, err, "Failed to retrieve schedules")
+        return
+    }
+
+    if schedules == nil {
+        schedules = []models.MedicationSchedule{}
+    }
+    utils.SendSuccess(w, http.StatusOK, "Schedules retrieved", schedules)
+}
+
+// ownSchedule loads a schedule of the requesting patient. It writes the
+// error response and returns false when there is none.
+func (h *PrescriptionHandler) ownSchedule(w http.ResponseWriter, r *http.Request, scheduleID, action string) (*models.MedicationSchedule, bool) {
+    userID := r.Header.Get("X-User-ID")
+    userRole := r.Header.Get("X-User-Role")
+
+    if userID == "" {
+        utils.SendError(w, http.StatusUnauthorized, "Unauthorized")
+        return nil, false
+    }
+
+    if userRole != "patient" {
+        utils.SendError(w, http.StatusForbidden, "Only patients can "+action)
+        return nil, false
+    }
+
+    if scheduleID == "" {
+        utils.SendErrorCode(w, apperrors.CodeValidation, "Schedule ID is required")
+        return nil, false
+    }
+
+    patientProfileID, err := h.repo.GetPatientProfileIDByUserID(r.Context(), userID)
+    if err != nil {
+        utils.SendErrorCode(w, apperrors.CodeProfileNotFound, "Patient profile not found")
+        return nil, false
+    }
+
+    // Another patient's schedule is reported as missing rather than forbidden
+    schedule, err := h.repo.GetMedicationScheduleByID(r.Context(), scheduleID)
+    if err != nil || schedule.PatientID != patientProfileID {
+        if err != nil && err != sql.ErrNoRows {
+            utils.SendAppError(w, err, "Failed to retrieve schedule")
+            return nil, false
+        }
+        utils.SendError(w, http.StatusNotFound, "Schedule not found")
+        return nil, false
+    }
+    return schedule, true
+}
+
+// EndSchedule stops a schedule after end_date, keeping the doses logged so
+// far for adherence
+func (h *PrescriptionHandler) EndSchedule(w http.ResponseWriter, r *http.Request) {
+    schedule, ok := h.ownSchedule(w, r, r.URL.Query().Get("id"), "end medication schedules")
+    if !ok {
+        return
+    }
+
+    var req EndScheduleRequest
+    if r.ContentLength != 0 {
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+            utils.SendError(w, http.StatusBadRequest, "Invalid request body")
+            return
+        }
+    }
+
+    loc, err := adherence.Location(schedule.Timezone)
+    if err != nil {
+        utils.SendAppError(w, err, "Failed to end schedule")
+        return
+    }
+    endDate, _ := time.Parse("2006-01-02", pagination.Date(time.Now().In(loc)))
+    if req.EndDate != "" {
+        if endDate, err = time.Parse("2006-01-02", req.EndDate); err != nil {
+            utils.SendErrorCode(w, apperrors.CodeValidation, "Invalid end_date. Use YYYY-MM-DD")
+            return
+        }
+    }
+    if endDate.Before(schedule.StartDate) {
+        utils.SendErrorCode(w, apperrors.CodeValidation, "end_date must not be before start_date")
+        return
+    }
+
+    ended, err := h.repo.EndMedicationSchedule(r.Context(), schedule.ID, endDate)
+    if err != nil {
+        utils.SendAppError(w, err, "Failed to end schedule")
+        return
+    }
+
+    utils.SendSuccess(w, http.StatusOK, "Schedule ended", ended)
+}
+
+// DeleteSchedule deletes a schedule together with its logged doses
+func (h *PrescriptionHandler) DeleteSchedule(w http.ResponseWriter, r *http.Request) {
+    schedule, ok := h.ownSchedule(w, r, r.URL.Query().Get("id"), "delete medication schedules")
+    if !ok {
+        return
+    }
+
+    if err := h.repo.DeleteMedicationSchedule(r.Context(), schedule.ID); err != nil {
+        utils.SendAppError(w, err, "Failed to delete schedule")
+        return
+    }
+
+    utils.SendSuccess(w, http.StatusOK, "Schedule deleted", nil)
+}
+
+// LogDose records a scheduled dose as taken, late or skipped. Logging the
+// same dose again replaces what was recorded; a dose taken more than an
+// hour after its time is stored as late.
+func (h *PrescriptionHandler) LogDose(w http.ResponseWriter, r *http.Request) {
+    var req LogDoseRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        utils.SendError(w, http.StatusBadRequest, "Invalid request body")
+        return
+    }
+
+    schedule, ok := h.ownSchedule(w, r, req.ScheduleID, "log doses")
+    if !ok {
+        return
+    }
+
+    if !req.Status.Valid() {
+        utils.SendErrorCode(w, apperrors.CodeValidation, "status must be one of: taken, late, skipped")
+        return
+    }
+    if len(req.Note) > maxNotesLen {
+        utils.SendErrorCode(w, apperrors.CodeValidation, fmt.Sprintf("note must be at most %d characters", maxNotesLen))
+        return
+    }
+
+    now := time.Now().UTC()
+    scheduledFor := req.ScheduledFor.UTC()
+    if !adherence.IsOccurrence(*schedule, scheduledFor) {
+        utils.SendErrorCode(w, apperrors.CodeValidation, "scheduled_for is not a dose time of this schedule")
+        return
+    }
+    if scheduledFor.Sub(now) > doseLogAhead {
+        utils.SendErrorCode(w, apperrors.CodeValidation, "Doses can be logged at most 2 hours ahead")
+        return
+    }
+
+    var takenAt *time.Time
+    if req.Status != models.DoseSkipped {
+        at := now
+        if req.TakenAt != nil {
+            at = req.TakenAt.UTC()
+        }
+        if at.After(now) || scheduledFor.Sub(at) > doseLogAhead {
+            utils.SendErrorCode(w, apperrors.CodeValidation, "taken_at must be in the past and at most 2 hours before the dose")
+            return
+        }
+        takenAt = &at
+    }
+
+    event := &models.DoseEvent{
+        ScheduleID:   schedule.ID,
+        PatientID:    schedule.PatientID,
+        ScheduledFor: scheduledFor,
+        Status:       adherence.Status(req.Status, scheduledFor, takenAt),
+        TakenAt:      takenAt,
+        Note:         strings.TrimSpace(req.Note),
+    }
+    if err := h.repo.SaveDoseEvent(r.Context(), event); err != nil {
+        utils.SendAppError(w, err, "Failed to log dose")
+        return
+    }
+
+    utils.SendSuccess(w, http.StatusOK, "Dose logged", event)
+}
+
+// doseData loads a patient's schedules and the events logged for doses on
+// the local dates from through to. Events are loaded a day either side so
+// every timezone's doses are covered.
+func (h *PrescriptionHandler) doseData(r *http.Request, patientProfileID string, from, to time.Time) ([]models.MedicationSchedule, []models.DoseEvent, error) {
+    schedules, err := h.repo.ListMedicationSchedules(r.Context(), patientProfileID)
+    if err != nil {
+        return nil, nil, err
+    }
+    events, err := h.repo.GetDoseEvents(r.Context(), patientProfileID, from.AddDate(0, 0, -1), to.AddDate(0, 0, 2))
+    if err != nil {
+        return nil, nil, err
+    }
+    return schedules, events, nil
+}
+
+// GetDoses lists the doses due on a date (today by default) and what became
+// of them. Doctors name a patient who granted them access.
+func (h *PrescriptionHandler) GetDoses(w http.ResponseWriter, r *http.Request) {
+    patientProfileID, ok := h.readablePatient(w, r)
+    if !ok {
+        return
+    }
+
+    now := time.Now().UTC()
+    date := now.Truncate(24 * time.Hour)
+    if raw := r.URL.Query().Get("date"); raw != "" {
+        var err error
+        if date, err = time.Parse("2006-01-02", raw); err != nil {
+            utils.SendErrorCode(w, apperrors.CodeValidation, "Invalid date. Use YYYY-MM-DD")
+            return
+        }
+    }
+
+    schedules, events, err := h.doseData(r, patientProfileID, date, date)
+    if err != nil {
+        utils.SendAppError(w, err, "Failed to retrieve doses")
+        return
+    }
+
+    utils.SendSuccess(w, http.StatusOK, "Doses retrieved", adherence.Doses(schedules, events, date, date, now))
+}
+
+// GetAdherence reports the percentage of due doses taken between from and
+// to (the last 30 days by default), overall and per medication, each broken
+// down by day, week or month. Doctors name a patient who granted them
+// access.
+func (h *PrescriptionHandler) GetAdherence(w http.ResponseWriter, r *http.Request) {
+    patientProfileID, ok := h.readablePatient(w, r)
+    if !ok {
+        return
+    }
+
+    q := r.URL.Query()
+    period := adherence.Week
+    if raw := q.Get("period"); raw != "" {
+        period = adherence.Period(raw)
+        if !period.Valid() {
+            utils.SendErrorCode(w, apperrors.CodeValidation, "period must be one of: day, week, month")
+            return
+        }
+    }
+
+    var err error
+    now := time.Now().UTC()
+    to := now.Truncate(24 * time.Hour)
+    if raw := q.Get("to"); raw != "" {
+        if to, err = time.Parse("2006-01-02", raw); err != nil {
+            utils.SendErrorCode(w, apperrors.CodeValidation, "Invalid to date. Use YYYY-MM-DD")
+            return
+        }
+    }
+    from := to.AddDate(0, 0, 1-defaultAdherenceDays)
+    if raw := q.Get("from"); raw != "" {
+        if from, err = time.Parse("2006-01-02", raw); err != nil {
+            utils.SendErrorCode(w, apperrors.CodeValidation, "Invalid from date. Use YYYY-MM-DD")
+            return
+        }
+    }
+    if to.Before(from) {
+        utils.SendErrorCode(w, apperrors.CodeValidation, "from must not be after to")
+        return
+    }
+    if to.Sub(from) >= maxAdherenceDays*24*time.Hour {
+        utils.SendErrorCode(w, apperrors.CodeValidation, fmt.Sprintf("An adherence report may span at most %d days", maxAdherenceDays))
+        return
+    }
+
+    schedules, events, err := h.doseData(r, patientProfileID, from, to)
+    if err != nil {
+        utils.SendAppError(w, err, "Failed to compute adherence")
+        return
+    }
+
+    utils.SendSuccess(w, http.StatusOK, "Adherence computed", adherence.Compute(schedules, events, from, to, period, now))
+}
+
+// readablePatient resolves whose records a request reads: the patient's own,
+// or for a doctor the patient_id they were granted access to. It writes the
+// error response and returns false when access is refused.
+func (h *PrescriptionHandler) readablePatient(w http.ResponseWriter, r *http.Request) (string, bool) {
+    userID := r.Header.Get("X-User-ID")
+    userRole := r.Header.Get("X-User-Role")
+
+    if userID == "" {
+        utils.SendError(w, http.StatusUnauthorized, "Unauthorized")
+        return "", false
+    }
+
+    patientProfileID := r.URL.Query().Get("patient_id")
+
+    switch userRole {
+    case "patient":
+        myProfileID, err := h.repo.GetPatientProfileIDByUserID(r.Context(), userID)
+        if err != nil {
+            utils.SendErrorCode(w, apperrors.CodeProfileNotFound, "Patient profile not found")
+            return "", false
+        }
+        if patientProfileID != "" && patientProfileID != myProfileID {
+            utils.SendErrorCode(w, apperrors.CodeAccessDenied, "Access denied")
+            return "", false
+        }
+        return myProfileID, true
+    case "doctor":
+        if patientProfileID == "" {
+            utils.SendErrorCode(w, apperrors.CodeValidation, "Patient ID is required")
+            return "", false
+        }
+        hasAccess, err := h.repo.CheckDoctorAccess(r.Context(), userID, patientProfileID)
+        if err != nil || !hasAccess {
+            utils.SendErrorCode(w, apperrors.CodeAccessDenied, "Access denied")
+            return "", false
+        }
+        return patientProfileID, true
+    }
+
+    utils.SendErrorCode(w, apperrors.CodeAccessDenied, "Access denied")
+    return "", false
+}
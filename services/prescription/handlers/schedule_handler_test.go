@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"health-bar/shared/adherence"
+	"health-bar/shared/apperrors"
+	"health-bar/shared/models"
+	"health-bar/shared/testutil"
+)
+
+func TestMedicationSchedules(t *testing.T) {
+	h, db, _ := newTestHandler(t)
+	patient := db.AddPatient("p@test.com", "Pat")
+	other := db.AddPatient("o@test.com", "Other")
+	doctor := db.AddDoctor("d@test.com", "Dr Who")
+	db.Grant(patient.ID, doctor.ID)
+	prescription := issue(t, h, doctor.UserID, IssuePrescriptionRequest{PatientID: patient.ID, Drug: "Metformin", Strength: "500 mg", Route: "oral", Frequency: "twice daily"})
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	start := today.AddDate(0, 0, -2).Format("2006-01-02")
+	req := CreateScheduleRequest{PrescriptionID: &prescription.ID, Times: []string{"20:00", "08:00"}, Timezone: "UTC", StartDate: start}
+	rec, resp := testutil.Serve(t, h.CreateSchedule, testutil.NewRequest(t, http.MethodPost, "/api/prescriptions/schedules", req, patient.UserID, "patient"))
+	testutil.ExpectStatus(t, rec, http.StatusCreated)
+	var schedule models.MedicationSchedule
+	testutil.DecodeData(t, resp, &schedule)
+	if schedule.Medication != "Metformin" || schedule.Dose != "500 mg" || schedule.Times[0] != "08:00" {
+		t.Fatalf("schedule = %+v", schedule)
+	}
+
+	badTimes, badZone, foreign := req, req, req
+	badTimes.Times = []string{"8am"}
+	badZone.Timezone = "Mars/Olympus"
+	foreign.PrescriptionID = &prescription.ID
+	for name, tc := range map[string]struct {
+		req    CreateScheduleRequest
+		userID string
+		code   apperrors.Code
+	}{
+		"bad times":            {badTimes, patient.UserID, apperrors.CodeValidation},
+		"unknown timezone":     {badZone, patient.UserID, apperrors.CodeValidation},
+		"foreign prescription": {foreign, other.UserID, apperrors.CodeInvalidReference},
+	} {
+		r := testutil.NewRequest(t, http.MethodPost, "/api/prescriptions/schedules", tc.req, tc.userID, "patient")
+		_, resp := testutil.Serve(t, h.CreateSchedule, r)
+		if resp.Code != tc.code {
+			t.Errorf("%s: code %q, want %q", name, resp.Code, tc.code)
+		}
+	}
+
+	logDose := func(userID string, req LogDoseRequest) (int, apperrors.Code, models.DoseEvent) {
+		r := testutil.NewRequest(t, http.MethodPost, "/api/prescriptions/doses", req, userID, "patient")
+		rec, resp := testutil.Serve(t, h.LogDose, r)
+		var event models.DoseEvent
+		if rec.Code == http.StatusOK {
+			testutil.DecodeData(t, resp, &event)
+		}
+		return rec.Code, resp.Code, event
+	}
+
+	first := today.AddDate(0, 0, -2).Add(8 * time.Hour)
+	takenAt := first.Add(3 * time.Hour)
+	status, _, event := logDose(patient.UserID, LogDoseRequest{ScheduleID: schedule.ID, ScheduledFor: first, Status: models.DoseTaken, TakenAt: &takenAt})
+	if status != http.StatusOK || event.Status != models.DoseLate {
+		t.Fatalf("dose taken 3h late: %d %+v", status, event)
+	}
+	// Logging the same dose again replaces it
+	status, _, event = logDose(patient.UserID, LogDoseRequest{ScheduleID: schedule.ID, ScheduledFor: first, Status: models.DoseSkipped})
+	if status != http.StatusOK || event.Status != models.DoseSkipped || event.TakenAt != nil || len(db.DoseEvents.Rows) != 1 {
+		t.Fatalf("dose skipped: %d %+v", status, event)
+	}
+	evening := first.Add(12 * time.Hour)
+	takenAt = evening.Add(10 * time.Minute)
+	if status, _, event := logDose(patient.UserID, LogDoseRequest{ScheduleID: schedule.ID, ScheduledFor: evening, Status: models.DoseTaken, TakenAt: &takenAt}); event.Status != models.DoseTaken {
+		t.Fatalf("dose taken on time: %d %+v", status, event)
+	}
+
+	if _, code, _ := logDose(patient.UserID, LogDoseRequest{ScheduleID: schedule.ID, ScheduledFor: first.Add(time.Hour), Status: models.DoseTaken}); code != apperrors.CodeValidation {
+		t.Errorf("dose at 09:00: code %q", code)
+	}
+	if _, code, _ := logDose(patient.UserID, LogDoseRequest{ScheduleID: schedule.ID, ScheduledFor: today.AddDate(0, 0, 1).Add(8 * time.Hour), Status: models.DoseTaken}); code != apperrors.CodeValidation {
+		t.Errorf("dose tomorrow: code %q", code)
+	}
+	if status, _, _ := logDose(other.UserID, LogDoseRequest{ScheduleID: schedule.ID, ScheduledFor: first, Status: models.DoseTaken}); status != http.StatusNotFound {
+		t.Errorf("another patient's schedule: %d", status)
+	}
+
+	// Doctors with access see the patient's doses and adherence
+	target := "/api/prescriptions/doses?date=" + start + "&patient_id=" + patient.ID
+	rec, resp = testutil.Serve(t, h.GetDoses, testutil.NewRequest(t, http.MethodGet, target, nil, doctor.UserID, "doctor"))
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+	var doses []adherence.Dose
+	testutil.DecodeData(t, resp, &doses)
+	if len(doses) != 2 || doses[0].State != adherence.Skipped || doses[1].State != adherence.Taken {
+		t.Fatalf("doses = %+v", doses)
+	}
+
+	target = "/api/prescriptions/adherence?period=day&from=" + start + "&to=" + today.AddDate(0, 0, -1).Format("2006-01-02") + "&patient_id=" + patient.ID
+	rec, resp = testutil.Serve(t, h.GetAdherence, testutil.NewRequest(t, http.MethodGet, target, nil, doctor.UserID, "doctor"))
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+	var report adherence.Report
+	testutil.DecodeData(t, resp, &report)
+	if o := report.Overall; o.Due != 4 || o.Taken != 1 || o.Skipped != 1 || o.Missed != 2 || *o.Adherence != 25 {
+		t.Fatalf("overall = %+v", o)
+	}
+	if len(report.Periods) != 2 || len(report.Medications) != 1 || *report.Periods[0].Adherence != 50 {
+		t.Fatalf("report = %+v", report)
+	}
+
+	stranger := db.AddDoctor("s@test.com", "Dr Strange")
+	rec, resp = testutil.Serve(t, h.GetAdherence, testutil.NewRequest(t, http.MethodGet, target, nil, stranger.UserID, "doctor"))
+	testutil.ExpectStatus(t, rec, http.StatusForbidden)
+	testutil.ExpectCode(t, resp, apperrors.CodeAccessDenied)
+
+	rec, _ = testutil.Serve(t, h.GetAdherence, testutil.NewRequest(t, http.MethodGet, "/api/prescriptions/adherence?period=year", nil, patient.UserID, "patient"))
+	testutil.ExpectStatus(t, rec, http.StatusBadRequest)
+
+	// Deleting the schedule deletes its doses
+	rec, _ = testutil.Serve(t, h.DeleteSchedule, testutil.NewRequest(t, http.MethodDelete, "/api/prescriptions/schedule?id="+schedule.ID, nil, patient.UserID, "patient"))
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+	if len(db.DoseEvents.Rows) != 0 {
+		t.Fatalf("%d dose events left", len(db.DoseEvents.Rows))
+	}
+}
+
+func TestEndSchedule(t *testing.T) {
+	h, db, _ := newTestHandler(t)
+	patient := db.AddPatient("p@test.com", "Pat")
+
+	req := CreateScheduleRequest{Medication: "Vitamin D", Times: []string{"09:00"}, Timezone: "Europe/Berlin", StartDate: "2024-01-10"}
+	rec, resp := testutil.Serve(t, h.CreateSchedule, testutil.NewRequest(t, http.MethodPost, "/api/prescriptions/schedules", req, patient.UserID, "patient"))
+	testutil.ExpectStatus(t, rec, http.StatusCreated)
+	var schedule models.MedicationSchedule
+	testutil.DecodeData(t, resp, &schedule)
+
+	target := "/api/prescriptions/schedules/end?id=" + schedule.ID
+	rec, resp = testutil.Serve(t, h.EndSchedule, testutil.NewRequest(t, http.MethodPost, target, EndScheduleRequest{EndDate: "2024-01-01"}, patient.UserID, "patient"))
+	testutil.ExpectStatus(t, rec, http.StatusBadRequest)
+	testutil.ExpectCode(t, resp, apperrors.CodeValidation)
+
+	rec, resp = testutil.Serve(t, h.EndSchedule, testutil.NewRequest(t, http.MethodPost, target, EndScheduleRequest{EndDate: "2024-01-31"}, patient.UserID, "patient"))
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+	testutil.DecodeData(t, resp, &schedule)
+	if schedule.EndDate == nil || schedule.EndDate.Format("2006-01-02") != "2024-01-31" {
+		t.Fatalf("end_date = %v", schedule.EndDate)
+	}
+
+	rec, resp = testutil.Serve(t, h.GetSchedules, testutil.NewRequest(t, http.MethodGet, "/api/prescriptions/schedules", nil, patient.UserID, "patient"))
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+	var schedules []models.MedicationSchedule
+	testutil.DecodeData(t, resp, &schedules)
+	if len(schedules) != 1 || schedules[0].EndDate == nil {
+		t.Fatalf("schedules = %+v", schedules)
+	}
+}
@@ -0,0 +1,643 @@
+package handlers
+
+import (
+    "bytes"
+    "context"
+    "crypto/sha1"
+    "crypto/sha256"
+    "database/sql"
+    "encoding/base64"
+    "fmt"
+    "hash"
+    "health-bar/shared/apperrors"
+    "health-bar/shared/models"
+    "health-bar/shared/utils"
+    "io"
+    "log"
+    "net/http"
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Resumable uploads follow the tus protocol (https://tus.io), version 1.0.0
+// with the creation, expiration, checksum and termination extensions:
+//
+//	POST   /api/prescriptions/uploads          Upload-Length, Upload-Metadata: filename <base64>
+//	HEAD   /api/prescriptions/uploads?id=...   reports Upload-Offset
+//	PATCH  /api/prescriptions/uploads?id=...   Upload-Offset, optional Upload-Checksum
+//	DELETE /api/prescriptions/uploads?id=...   abandons the upload
+//
+// Each chunk is stored as a blob. The one that completes the upload
+// assembles the file, which is checked like any other upload and becomes a
+// prescription; the PATCH answering it carries Prescription-ID.
+const tusVersion = "1.0.0"
+
+const (
+    // uploadTTL is how long an upload lives without progress
+    uploadTTL = 24 * time.Hour
+    // expireBatch is how many expired uploads are removed at a time
+    expireBatch = 100
+)
+
+// DefaultSizeLimits are the largest files of each type accepted. Resumable
+// uploads can reach them; multipart requests are also capped at
+// maxMultipartSize as a whole. PDFs this large are scanned pages, whose
+// image data filecheck does not search for active names.
+var DefaultSizeLimits = map[string]int64{
+    ".pdf":  200 << 20,
+    ".jpg":  50 << 20,
+    ".jpeg": 50 << 20,
+    ".png":  50 << 20,
+}
+
+// UseSizeLimits overrides the size limits of some file types, as parsed by
+// ParseSizeLimits
+func (h *PrescriptionHandler) UseSizeLimits(limits map[string]int64) {
+    merged := make(map[string]int64, len(DefaultSizeLimits))
+    for ext, limit := range DefaultSizeLimits {
+        merged[ext] = limit
+    }
+    for ext, limit := range limits {
+        merged[ext] = limit
+    }
+    h.sizeLimits = merged
+}
+
+// sizeLimit is the largest file of type ext accepted
+func (h *PrescriptionHandler) sizeLimit(ext string) int64 {
+    return h.sizeLimits[ext]
+}
+
+// maxSizeLimit is the largest file of any type accepted
+func (h *PrescriptionHandler) maxSizeLimit() int64 {
+    largest := int64(0)
+    for _, limit := range h.sizeLimits {
+        largest = max(largest, limit)
+    }
+    return largest
+}
+
+var sizeUnits = []struct {
+    suffix string
+    bytes  int64
+}{
+    {"GB", 1 << 30},
+    {"MB", 1 << 20},
+    {"KB", 1 << 10},
+    {"B", 1},
+}
+
+// ParseSizeLimits parses limits such as "pdf=500MB, png=20MB". Types are
+// extensions, with or without the dot; sizes are bytes or KB, MB or GB.
+func ParseSizeLimits(s string) (map[string]int64, error) {
+    limits := map[string]int64{}
+    for _, entry := range strings.Split(s, ",") {
+        entry = strings.TrimSpace(entry)
+        if entry == "" {
+            continue
+        }
+        ext, size, ok := strings.Cut(entry, "=")
+        if !ok {
+            return nil, fmt.Errorf("size limit %q is not type=size", entry)
+        }
+        ext = "." + strings.TrimPrefix(strings.ToLower(strings.TrimSpace(ext)), ".")
+        if !allowedFileTypes[ext] {
+            return nil, fmt.Errorf("size limit for %s: not an accepted file type", ext)
+        }
+        limit, err := parseSize(strings.TrimSpace(size))
+        if err != nil {
+            return nil, fmt.Errorf("size limit for %s: %v", ext, err)
+        }
+        limits[ext] = limit
+    }
+    return limits, nil
+}
+
+func parseSize(s string) (int64, error) {
+    upper := strings.ToUpper(s)
+    multiplier := int64(1)
+    for _, unit := range sizeUnits {
+        if strings.HasSuffix(upper, unit.suffix) {
+            upper, multiplier = strings.TrimSpace(strings.TrimSuffix(upper, unit.suffix)), unit.bytes
+            break
+        }
+    }
+    n, err := strconv.ParseInt(upper, 10, 64)
+    if err != nil || n <= 0 || n > (1<<62)/multiplier {
+        return 0, fmt.Errorf("invalid size %q", s)
+    }
+    return n * multiplier, nil
+}
+
+// FormatSize formats a size in the largest unit that divides it
+func FormatSize(n int64) string {
+    for _, unit := range sizeUnits {
+        if n >= unit.bytes && n%unit.bytes == 0 {
+            return strconv.FormatInt(n/unit.bytes, 10) + unit.suffix
+        }
+    }
+    return strconv.FormatInt(n, 10) + "B"
+}
+
+// setTusHeaders sets the headers every tus response carries
+func setTusHeaders(w http.ResponseWriter) {
+    w.Header().Set("Tus-Resumable", tusVersion)
+    w.Header().Set("Cache-Control", "no-store")
+}
+
+// UploadOptions describes the upload protocol supported
+func (h *PrescriptionHandler) UploadOptions(w http.ResponseWriter, r *http.Request) {
+    setTusHeaders(w)
+    w.Header().Set("Tus-Version", tusVersion)
+    w.Header().Set("Tus-Extension", "creation,expiration,checksum,termination")
+    w.Header().Set("Tus-Max-Size", strconv.FormatInt(h.maxSizeLimit(), 10))
+    w.Header().Set("Tus-Checksum-Algorithm", "sha1,sha256")
+    w.WriteHeader(http.StatusNoContent)
+}
+
+// tusRequest checks that a request speaks the supported protocol version
+func tusRequest(w http.ResponseWriter, r *http.Request) bool {
+    setTusHeaders(w)
+    if r.Header.Get("Tus-Resumable") != tusVersion {
+        w.Header().Set("Tus-Version", tusVersion)
+        utils.SendErrorCode(w, apperrors.CodePreconditionFailed, "Tus-Resumable must be "+tusVersion)
+        return false
+    }
+    return true
+}
+
+// CreateUpload starts a resumable upload of a prescription file
+func (h *PrescriptionHandler) CreateUpload(w http.ResponseWriter, r *http.Request) {
+    if !tusRequest(w, r) {
+        return
+    }
+    userID := r.Header.Get("X-User-ID")
+    userRole := r.Header.Get("X-User-Role")
+
+    if userID == "" {
+        utils.SendError(w, http.StatusUnauthorized, "Unauthorized")
+        return
+    }
+
+    if userRole != "patient" {
+        utils.SendError(w, http.StatusForbidden, "Only patients can upload prescriptions")
+        return
+    }
+
+    patientProfileID, err := h.repo.GetPatientProfileIDByUserID(r.Context(), userID)
+    if err != nil {
+        utils.SendErrorCode(w, apperrors.CodeProfileNotFound, "Patient profile not found")
+        return
+    }
+
+    if r.Header.Get("Upload-Defer-Length") != "" {
+        utils.SendErrorCode(w, apperrors.CodeValidation, "Upload-Defer-Length is not supported")
+        return
+    }
+    length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+    if err != nil || length <= 0 {
+        utils.SendErrorCode(w, apperrors.CodeValidation, "Upload-Length must be a positive number of bytes")
+        return
+    }
+
+    metadata, err := parseUploadMetadata(r.Header.Get("Upload-Metadata"))
+    if err != nil {
+        utils.SendErrorCode(w, apperrors.CodeValidation, err.Error())
+        return
+    }
+    fileName := filepath.Base(metadata["filename"])
+    if metadata["filename"] == "" || len(fileName) > 255 {
+        utils.SendErrorCode(w, apperrors.CodeValidation, "Upload-Metadata must name the file with filename")
+        return
+    }
+    fileExt := strings.ToLower(filepath.Ext(fileName))
+    if !allowedFileTypes[fileExt] {
+        utils.SendErrorCode(w, apperrors.CodeUnsupportedFile, "Invalid file type. Only PDF, JPG, JPEG, and PNG are allowed")
+        return
+    }
+    if limit := h.sizeLimit(fileExt); length > limit {
+        utils.SendErrorCode(w, apperrors.CodeFileTooLarge, fmt.Sprintf("File too large. Max size for %s files is %s", fileExt, FormatSize(limit)))
+        return
+    }
+
+    upload := &models.Upload{PatientID: patientProfileID, FileName: fileName, FileType: fileExt, Length: length}
+    if err := h.repo.CreateUpload(r.Context(), upload, uploadTTL); err != nil {
+        utils.SendAppError(w, err, "Failed to create upload")
+        return
+    }
+
+    w.Header().Set("Location", "/api/prescriptions/uploads?id="+upload.ID)
+    w.Header().Set("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+    utils.SendSuccess(w, http.StatusCreated, "Upload created", upload)
+}
+
+// parseUploadMetadata decodes an Upload-Metadata header: comma-separated
+// keys, each with an optional base64 value
+func parseUploadMetadata(header string) (map[string]string, error) {
+    metadata := map[string]string{}
+    for _, pair := range strings.Split(header, ",") {
+        pair = strings.TrimSpace(pair)
+        if pair == "" {
+            continue
+        }
+        key, encoded, _ := strings.Cut(pair, " ")
+        value, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+        if err != nil {
+            return nil, fmt.Errorf("Upload-Metadata value of %s is not base64", key)
+        }
+        metadata[key] = string(value)
+    }
+    return metadata, nil
+}
+
+// uploadOwner loads the upload a request names, if it belongs to the calling
+// patient. Uploads of others, and unfinished ones past their expiry, are
+// reported as not found.
+func (h *PrescriptionHandler) uploadOwner(w http.ResponseWriter, r *http.Request) (*models.Upload, bool) {
+    if !tusRequest(w, r) {
+        return nil, false
+    }
+    userID := r.Header.Get("X-User-ID")
+    if userID == "" {
+        utils.SendError(w, http.StatusUnauthorized, "Unauthorized")
+        return nil, false
+    }
+    if r.Header.Get("X-User-Role") != "patient" {
+        utils.SendError(w, http.StatusForbidden, "Only patients can upload prescriptions")
+        return nil, false
+    }
+
+    uploadID := r.URL.Query().Get("id")
+    if uploadID == "" {
+        utils.SendErrorCode(w, apperrors.CodeValidation, "Upload ID is required")
+        return nil, false
+    }
+    upload, err := h.repo.GetUploadByID(r.Context(), uploadID)
+    if err == sql.ErrNoRows {
+        utils.SendErrorCode(w, apperrors.CodeNotFound, "Upload not found")
+        return nil, false
+    }
+    if err != nil {
+        utils.SendAppError(w, err, "Failed to get upload")
+        return nil, false
+    }
+    patientProfileID, err := h.repo.GetPatientProfileIDByUserID(r.Context(), userID)
+    if err != nil || patientProfileID != upload.PatientID {
+        utils.SendErrorCode(w, apperrors.CodeNotFound, "Upload not found")
+        return nil, false
+    }
+    if upload.PrescriptionID == nil && !upload.ExpiresAt.After(time.Now().UTC()) {
+        utils.SendErrorCode(w, apperrors.CodeNotFound, "Upload expired")
+        return nil, false
+    }
+    return upload, true
+}
+
+// setOffsetHeaders reports how far an upload has got
+func setOffsetHeaders(w http.ResponseWriter, upload *models.Upload) {
+    w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+    w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+    if upload.PrescriptionID != nil {
+        w.Header().Set("Prescription-ID", *upload.PrescriptionID)
+    } else {
+        w.Header().Set("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+    }
+}
+
+// GetUploadOffset reports how much of an upload the server has, so a client
+// knows where to resume
+func (h *PrescriptionHandler) GetUploadOffset(w http.ResponseWriter, r *http.Request) {
+    upload, ok := h.uploadOwner(w, r)
+    if !ok {
+        return
+    }
+    setOffsetHeaders(w, upload)
+    w.WriteHeader(http.StatusOK)
+}
+
+// PatchUpload stores the next chunk of an upload. The chunk that completes
+// it assembles the file into a prescription; an empty PATCH retries that
+// when it failed for a passing reason.
+func (h *PrescriptionHandler) PatchUpload(w http.ResponseWriter, r *http.Request) {
+    upload, ok := h.uploadOwner(w, r)
+    if !ok {
+        return
+    }
+    if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+        utils.SendErrorCode(w, apperrors.CodeUnsupportedMedia, "Content-Type must be application/offset+octet-stream")
+        return
+    }
+    offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+    if err != nil || offset < 0 {
+        utils.SendErrorCode(w, apperrors.CodeValidation, "Upload-Offset must be a number of bytes")
+        return
+    }
+    if offset != upload.Offset || upload.PrescriptionID != nil {
+        setOffsetHeaders(w, upload)
+        utils.SendErrorCode(w, apperrors.CodeConflict, "Upload-Offset does not match the upload, check it with HEAD")
+        return
+    }
+    checksum, err := parseChecksum(r.Header.Get("Upload-Checksum"))
+    if err != nil {
+        utils.SendAppError(w, err, "Invalid Upload-Checksum")
+        return
+    }
+
+    chunk, size, err := receiveChunk(r.Body, upload.Length-upload.Offset, checksum)
+    if err != nil {
+        utils.SendAppError(w, err, "Failed to receive chunk")
+        return
+    }
+    defer chunk.Close()
+
+    if size > 0 {
+        if upload, err = h.storeChunk(r.Context(), upload, chunk, size); err != nil {
+            utils.SendAppError(w, err, "Failed to store chunk")
+            return
+        }
+    }
+
+    if upload.Complete() {
+        prescription, err := h.completeUpload(r.Context(), upload)
+        if err != nil {
+            utils.SendAppError(w, err, "Failed to save prescription")
+            return
+        }
+        upload.PrescriptionID = &prescription.ID
+    }
+
+    setOffsetHeaders(w, upload)
+    w.WriteHeader(http.StatusNoContent)
+}
+
+// uploadChecksum is an Upload-Checksum header: an algorithm and the digest
+// the chunk must have
+type uploadChecksum struct {
+    hash   hash.Hash
+    digest []byte
+}
+
+func parseChecksum(header string) (*uploadChecksum, error) {
+    if header == "" {
+        return nil, nil
+    }
+    algorithm, encoded, _ := strings.Cut(header, " ")
+    digest, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+    if err != nil {
+        return nil, apperrors.New(apperrors.CodeValidation, "Upload-Checksum digest is not base64")
+    }
+    switch algorithm {
+    case "sha1":
+        return &uploadChecksum{hash: sha1.New(), digest: digest}, nil
+    case "sha256":
+        return &uploadChecksum{hash: sha256.New(), digest: digest}, nil
+    }
+    return nil, apperrors.New(apperrors.CodeValidation, "Upload-Checksum algorithm must be sha1 or sha256")
+}
+
+// receiveChunk spools a chunk of at most max bytes to a temporary file and
+// checks it against checksum, if there is one. It returns the file, rewound
+// and already unlinked, and the chunk's size.
+func receiveChunk(body io.Reader, max int64, checksum *uploadChecksum) (*os.File, int64, error) {
+    tmp, err := os.CreateTemp("", "chunk-*")
+    if err != nil {
+        return nil, 0, err
+    }
+    os.Remove(tmp.Name())
+
+    var dst io.Writer = tmp
+    if checksum != nil {
+        dst = io.MultiWriter(tmp, checksum.hash)
+    }
+    size, err := io.Copy(dst, io.LimitReader(body, max+1))
+    switch {
+    case err != nil:
+        err = apperrors.Wrap(err, apperrors.CodeInvalidRequest, "Chunk was cut short, check the offset with HEAD and resume")
+    case size > max:
+        err = apperrors.New(apperrors.CodeFileTooLarge, "Chunk runs past Upload-Length")
+    case checksum != nil && !bytes.Equal(checksum.hash.Sum(nil), checksum.digest):
+        err = apperrors.New(apperrors.CodeChecksumMismatch, "Chunk does not match Upload-Checksum")
+    }
+    if err == nil {
+        _, err = tmp.Seek(0, io.SeekStart)
+    }
+    if err != nil {
+        tmp.Close()
+        return nil, 0, err
+    }
+    return tmp, size, nil
+}
+
+// uploadPrefix is what the keys of an upload's chunk blobs start with. They
+// are named after the patient, like other files, so they go with the
+// patient's files when the patient is deleted.
+func uploadPrefix(upload *models.Upload) string {
+    return fmt.Sprintf("%s_upload_%s_", upload.PatientID, upload.ID)
+}
+
+// storeChunk stores a chunk at the upload's offset and moves the offset
+// past it
+func (h *PrescriptionHandler) storeChunk(ctx context.Context, upload *models.Upload, chunk io.Reader, size int64) (*models.Upload, error) {
+    // Keys are unique, so a request that loses a race for the offset only
+    // removes its own chunk
+    key := fmt.Sprintf("%s%020d_%s", uploadPrefix(upload), upload.Offset, utils.GenerateUUID())
+    if err := h.files.Put(ctx, key, chunk, size, "application/octet-stream"); err != nil {
+        return nil, err
+    }
+
+    moved, err := h.repo.AppendUploadChunk(ctx, &models.UploadChunk{
+        UploadID: upload.ID,
+        Offset:   upload.Offset,
+        Size:     size,
+        BlobKey:  key,
+    }, uploadTTL)
+    if err != nil {
+        h.discardFiles(ctx, &storedFile{key: key, size: size})
+        if err == sql.ErrNoRows {
+            return nil, apperrors.New(apperrors.CodeConflict, "The upload moved on or expired, check it with HEAD")
+        }
+        return nil, err
+    }
+    return moved, nil
+}
+
+// completeUpload assembles a complete upload and saves it as a prescription.
+// A file that fails its checks can never succeed, so the upload is removed;
+// other failures leave it to be retried.
+func (h *PrescriptionHandler) completeUpload(ctx context.Context, upload *models.Upload) (*models.Prescription, error) {
+    assembled, err := h.assembleUpload(ctx, upload)
+    if err != nil {
+        return nil, err
+    }
+    defer assembled.Close()
+
+    stored, err := h.storeFile(ctx, assembled, upload.PatientID, upload.FileType)
+    if err != nil {
+        if code := apperrors.CodeOf(err); code == apperrors.CodeUnsupportedFile || code == apperrors.CodeUnsafeFile {
+            if err := h.removeUpload(context.WithoutCancel(ctx), upload); err != nil {
+                log.Printf("Failed to remove rejected upload %s: %v", upload.ID, err)
+            }
+        }
+        return nil, err
+    }
+
+    prescription := &models.Prescription{
+        FileName: upload.FileName,
+        FileType: upload.FileType,
+        FileSize: stored.size,
+        FilePath: stored.key,
+    }
+    err = h.savePrescription(ctx, upload.PatientID, prescription, func(ctx context.Context) error {
+        if err := h.repo.CompleteUpload(ctx, upload.ID, prescription.ID); err == sql.ErrNoRows {
+            return apperrors.New(apperrors.CodeConflict, "The upload was already completed")
+        } else if err != nil {
+            return err
+        }
+        return nil
+    })
+    if err != nil {
+        h.discardFiles(ctx, stored)
+        return nil, err
+    }
+
+    // The chunks are no longer needed; any left behind go when the upload
+    // expires
+    if err := h.deleteUploadBlobs(context.WithoutCancel(ctx), upload); err != nil {
+        log.Printf("Failed to delete chunks of upload %s: %v", upload.ID, err)
+    }
+    return prescription, nil
+}
+
+// assembleUpload joins an upload's chunks into a temporary file, rewound and
+// already unlinked
+func (h *PrescriptionHandler) assembleUpload(ctx context.Context, upload *models.Upload) (*os.File, error) {
+    chunks, err := h.repo.GetUploadChunks(ctx, upload.ID)
+    if err != nil {
+        return nil, err
+    }
+
+    tmp, err := os.CreateTemp("", "upload-*")
+    if err != nil {
+        return nil, err
+    }
+    os.Remove(tmp.Name())
+
+    offset := int64(0)
+    for _, chunk := range chunks {
+        if chunk.Offset != offset {
+            err = fmt.Errorf("upload %s has no chunk at offset %d", upload.ID, offset)
+            break
+        }
+        if err = h.copyChunk(ctx, tmp, chunk); err != nil {
+            break
+        }
+        offset += chunk.Size
+    }
+    if err == nil && offset != upload.Length {
+        err = fmt.Errorf("upload %s has %d of %d bytes", upload.ID, offset, upload.Length)
+    }
+    if err == nil {
+        _, err = tmp.Seek(0, io.SeekStart)
+    }
+    if err != nil {
+        tmp.Close()
+        return nil, err
+    }
+    return tmp, nil
+}
+
+// copyChunk appends a chunk's blob to w
+func (h *PrescriptionHandler) copyChunk(ctx context.Context, w io.Writer, chunk models.UploadChunk) error {
+    blob, _, err := h.files.Get(ctx, chunk.BlobKey)
+    if err != nil {
+        return err
+    }
+    defer blob.Close()
+    n, err := io.Copy(w, blob)
+    if err == nil && n != chunk.Size {
+        err = fmt.Errorf("chunk %s has %d bytes, expected %d", chunk.BlobKey, n, chunk.Size)
+    }
+    return err
+}
+
+// TerminateUpload abandons an upload and removes what was stored of it. A
+// completed upload's prescription stays.
+func (h *PrescriptionHandler) TerminateUpload(w http.ResponseWriter, r *http.Request) {
+    upload, ok := h.uploadOwner(w, r)
+    if !ok {
+        return
+    }
+    if err := h.removeUpload(r.Context(), upload); err != nil {
+        utils.SendAppError(w, err, "Failed to remove upload")
+        return
+    }
+    w.WriteHeader(http.StatusNoContent)
+}
+
+// removeUpload deletes an upload's blobs, then its record. If a blob cannot
+// be deleted the record stays, so removing it can be tried again.
+func (h *PrescriptionHandler) removeUpload(ctx context.Context, upload *models.Upload) error {
+    if err := h.deleteUploadBlobs(ctx, upload); err != nil {
+        return err
+    }
+    return h.repo.DeleteUpload(ctx, upload.ID)
+}
+
+// deleteUploadBlobs deletes every blob stored for an upload, including
+// chunks a failed request stored but never recorded
+func (h *PrescriptionHandler) deleteUploadBlobs(ctx context.Context, upload *models.Upload) error {
+    blobs, err := h.files.List(ctx, uploadPrefix(upload))
+    if err != nil {
+        return err
+    }
+    for _, blob := range blobs {
+        if err := h.files.Delete(ctx, blob.Key); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// ExpireUploads removes uploads past their expiry and returns how many went
+func (h *PrescriptionHandler) ExpireUploads(ctx context.Context) (int, error) {
+    expired := 0
+    for {
+        uploads, err := h.repo.GetExpiredUploads(ctx, expireBatch)
+        if err != nil {
+            return expired, err
+        }
+        for i := range uploads {
+            if err := h.removeUpload(ctx, &uploads[i]); err != nil {
+                return expired, err
+            }
+            expired++
+        }
+        if len(uploads) < expireBatch {
+            return expired, nil
+        }
+    }
+}
+
+// ExpireUploadsEvery removes expired uploads every interval until ctx is done
+func (h *PrescriptionHandler) ExpireUploadsEvery(ctx context.Context, interval time.Duration) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+            expired, err := h.ExpireUploads(ctx)
+            if err != nil {
+                log.Printf("Failed to expire uploads: %v", err)
+            }
+            if expired > 0 {
+                log.Printf("Removed %d expired uploads", expired)
+            }
+        }
+    }
+}
@@ -0,0 +1,349 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"health-bar/shared/apperrors"
+	"health-bar/shared/memdb"
+	"health-bar/shared/testutil"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// tusCall runs a tus request with headers given as name, value pairs
+func tusCall(t *testing.T, handler http.HandlerFunc, method, target string, body []byte, userID string, headers ...string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := testutil.NewRequest(t, method, target, body, userID, "patient")
+	req.Header.Set("Tus-Resumable", tusVersion)
+	req.Header.Set("Content-Type", "application/offset+octet-stream")
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+func createUpload(t *testing.T, h *PrescriptionHandler, userID, fileName string, length int) string {
+	t.Helper()
+
+	rec := tusCall(t, h.CreateUpload, http.MethodPost, "/api/prescriptions/uploads", nil, userID,
+		"Upload-Length", strconv.Itoa(length),
+		"Upload-Metadata", "filename "+base64.StdEncoding.EncodeToString([]byte(fileName))+",filetype")
+	testutil.ExpectStatus(t, rec, http.StatusCreated)
+	location := rec.Header().Get("Location")
+	if !strings.HasPrefix(location, "/api/prescriptions/uploads?id=") || rec.Header().Get("Upload-Expires") == "" {
+		t.Fatalf("headers = %v", rec.Header())
+	}
+	return location
+}
+
+func patchUpload(t *testing.T, h *PrescriptionHandler, location, userID string, offset int, chunk string, headers ...string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	return tusCall(t, h.PatchUpload, http.MethodPatch, location, []byte(chunk), userID,
+		append([]string{"Upload-Offset", strconv.Itoa(offset)}, headers...)...)
+}
+
+func sha256Checksum(chunk string) string {
+	sum := sha256.Sum256([]byte(chunk))
+	return "sha256 " + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func expectOffset(t *testing.T, h *PrescriptionHandler, location, userID string, want int) {
+	t.Helper()
+
+	rec := tusCall(t, h.GetUploadOffset, http.MethodHead, location, nil, userID)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+	if got := rec.Header().Get("Upload-Offset"); got != strconv.Itoa(want) {
+		t.Fatalf("Upload-Offset = %s, want %d", got, want)
+	}
+}
+
+func TestResumableUpload(t *testing.T) {
+	h, db, dir := newTestHandler(t)
+	patient := db.AddPatient("p@test.com", "Pat")
+	content := "%PDF-1.4\n" + strings.Repeat("% scanned page\n", 1000) + "%%EOF\n"
+
+	location := createUpload(t, h, patient.UserID, "imaging.pdf", len(content))
+	expectOffset(t, h, location, patient.UserID, 0)
+
+	first, second, third := content[:5000], content[5000:10000], content[10000:]
+	rec := patchUpload(t, h, location, patient.UserID, 0, first, "Upload-Checksum", sha256Checksum(first))
+	testutil.ExpectStatus(t, rec, http.StatusNoContent)
+	if rec.Header().Get("Upload-Offset") != "5000" || rec.Header().Get("Tus-Resumable") != tusVersion {
+		t.Fatalf("headers = %v", rec.Header())
+	}
+
+	// A retried chunk is refused and the client told where to resume
+	rec = patchUpload(t, h, location, patient.UserID, 0, first)
+	testutil.ExpectStatus(t, rec, http.StatusConflict)
+	if rec.Header().Get("Upload-Offset") != "5000" {
+		t.Fatalf("Upload-Offset = %s", rec.Header().Get("Upload-Offset"))
+	}
+
+	// A chunk damaged on the way is not stored
+	rec = patchUpload(t, h, location, patient.UserID, 5000, second, "Upload-Checksum", sha256Checksum(first))
+	testutil.ExpectStatus(t, rec, apperrors.StatusChecksumMismatch)
+	expectOffset(t, h, location, patient.UserID, 5000)
+
+	testutil.ExpectStatus(t, patchUpload(t, h, location, patient.UserID, 5000, second), http.StatusNoContent)
+	if len(db.Prescriptions.Rows) != 0 {
+		t.Fatal("prescription created before the upload completed")
+	}
+
+	rec = patchUpload(t, h, location, patient.UserID, 10000, third, "Upload-Checksum", sha256Checksum(third))
+	testutil.ExpectStatus(t, rec, http.StatusNoContent)
+	prescriptionID := rec.Header().Get("Prescription-ID")
+	prescription, ok := db.Prescriptions.Rows[prescriptionID]
+	if !ok || prescription.PatientID != patient.ID || prescription.FileName != "imaging.pdf" || prescription.FileSize != int64(len(content)) {
+		t.Fatalf("prescription %q = %+v", prescriptionID, prescription)
+	}
+	stored, err := os.ReadFile(filepath.Join(dir, prescription.FilePath))
+	if err != nil || string(stored) != content {
+		t.Fatalf("stored file: %d bytes, %v", len(stored), err)
+	}
+
+	// Only the assembled file is left
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Fatalf("files = %v", entries)
+	}
+	rec = tusCall(t, h.GetUploadOffset, http.MethodHead, location, nil, patient.UserID)
+	if rec.Header().Get("Prescription-ID") != prescriptionID || rec.Header().Get("Upload-Offset") != strconv.Itoa(len(content)) {
+		t.Fatalf("headers after completion = %v", rec.Header())
+	}
+	testutil.ExpectStatus(t, patchUpload(t, h, location, patient.UserID, len(content), ""), http.StatusConflict)
+}
+
+func TestResumableUploadLargeScan(t *testing.T) {
+	if testing.Short() {
+		t.Skip("uploads a PDF as large as the default limit")
+	}
+	h, db, dir := newTestHandler(t)
+	patient := db.AddPatient("p@test.com", "Pat")
+
+	// A scanned PDF at the size limit is mostly JPEG data, which is bound
+	// to hold bytes that read as an active name and must not be searched
+	const tail = "\nendstream\nendobj\ntrailer\n<< /Root 1 0 R >>\n%%EOF\n"
+	head := func(length int) string {
+		return "%PDF-1.7\n1 0 obj\n<< /Type /XObject /Subtype /Image /Filter /DCTDecode /Length " + strconv.Itoa(length) + " >>\nstream\n"
+	}
+	size := int(DefaultSizeLimits[".pdf"])
+	length := size - len(head(size)) - len(tail)
+	content := make([]byte, 0, size)
+	content = append(content, head(length)...)
+	data := content[len(content) : len(content)+length]
+	rand.NewChaCha8([32]byte{1}).Read(data)
+	copy(data[1000:], "/JS ")
+	copy(data[length/2:], "/Launch\n")
+	content = append(content[:len(content)+length], tail...)
+	if len(content) != size {
+		t.Fatalf("built %d bytes, want %d", len(content), size)
+	}
+
+	location := createUpload(t, h, patient.UserID, "scan.pdf", size)
+	const chunkSize = 64 << 20
+	var rec *httptest.ResponseRecorder
+	for offset := 0; offset < size; offset += chunkSize {
+		chunk := content[offset:min(offset+chunkSize, size)]
+		rec = tusCall(t, h.PatchUpload, http.MethodPatch, location, chunk, patient.UserID, "Upload-Offset", strconv.Itoa(offset))
+		testutil.ExpectStatus(t, rec, http.StatusNoContent)
+	}
+	prescription, ok := db.Prescriptions.Rows[rec.Header().Get("Prescription-ID")]
+	if !ok || prescription.FileSize != int64(size) {
+		t.Fatalf("prescription = %+v", prescription)
+	}
+	if info, err := os.Stat(filepath.Join(dir, prescription.FilePath)); err != nil || info.Size() != int64(size) {
+		t.Fatalf("stored file: %v, %v", info, err)
+	}
+}
+
+func TestCreateUploadValidation(t *testing.T) {
+	h, db, _ := newTestHandler(t)
+	patient := db.AddPatient("p@test.com", "Pat")
+	h.UseSizeLimits(map[string]int64{".png": 1 << 20})
+	filename := func(name string) string { return "filename " + base64.StdEncoding.EncodeToString([]byte(name)) }
+
+	for _, tt := range []struct {
+		name    string
+		headers []string
+		want    int
+	}{
+		{"no length", []string{"Upload-Metadata", filename("a.pdf")}, http.StatusBadRequest},
+		{"deferred length", []string{"Upload-Defer-Length", "1", "Upload-Metadata", filename("a.pdf")}, http.StatusBadRequest},
+		{"no file name", []string{"Upload-Length", "10"}, http.StatusBadRequest},
+		{"bad metadata", []string{"Upload-Length", "10", "Upload-Metadata", "filename !!"}, http.StatusBadRequest},
+		{"executable", []string{"Upload-Length", "10", "Upload-Metadata", filename("run.exe")}, http.StatusUnsupportedMediaType},
+		{"over the type's limit", []string{"Upload-Length", strconv.Itoa(1<<20 + 1), "Upload-Metadata", filename("scan.png")}, http.StatusRequestEntityTooLarge},
+		{"under the default limit", []string{"Upload-Length", strconv.Itoa(100 << 20), "Upload-Metadata", filename("scan.pdf")}, http.StatusCreated},
+	} {
+		rec := tusCall(t, h.CreateUpload, http.MethodPost, "/api/prescriptions/uploads", nil, patient.UserID, tt.headers...)
+		if rec.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d (body: %s)", tt.name, rec.Code, tt.want, rec.Body.String())
+		}
+	}
+
+	req := testutil.NewRequest(t, http.MethodPost, "/api/prescriptions/uploads", nil, patient.UserID, "patient")
+	rec := httptest.NewRecorder()
+	h.CreateUpload(rec, req)
+	testutil.ExpectStatus(t, rec, http.StatusPreconditionFailed)
+	if rec.Header().Get("Tus-Version") != tusVersion {
+		t.Fatalf("headers = %v", rec.Header())
+	}
+
+	rec = httptest.NewRecorder()
+	h.UploadOptions(rec, httptest.NewRequest(http.MethodOptions, "/api/prescriptions/uploads", nil))
+	if rec.Header().Get("Tus-Max-Size") != strconv.Itoa(200<<20) || !strings.Contains(rec.Header().Get("Tus-Extension"), "checksum") {
+		t.Fatalf("options = %v", rec.Header())
+	}
+}
+
+func TestPatchUploadValidation(t *testing.T) {
+	h, db, _ := newTestHandler(t)
+	patient := db.AddPatient("p@test.com", "Pat")
+	other := db.AddPatient("o@test.com", "Other")
+	location := createUpload(t, h, patient.UserID, "scan.pdf", 10)
+
+	testutil.ExpectStatus(t, patchUpload(t, h, location, other.UserID, 0, "%PDF-"), http.StatusNotFound)
+	testutil.ExpectStatus(t, patchUpload(t, h, location, patient.UserID, 0, "%PDF-", "Content-Type", "application/pdf"), http.StatusUnsupportedMediaType)
+	testutil.ExpectStatus(t, patchUpload(t, h, location, patient.UserID, 0, "%PDF-1.4 too long"), http.StatusRequestEntityTooLarge)
+	testutil.ExpectStatus(t, patchUpload(t, h, location, patient.UserID, 0, "%PDF-", "Upload-Checksum", "md5 AAAA"), http.StatusBadRequest)
+	testutil.ExpectStatus(t, tusCall(t, h.GetUploadOffset, http.MethodHead, "/api/prescriptions/uploads?id=00000000-0000-0000-0000-000000000000", nil, patient.UserID), http.StatusNotFound)
+	expectOffset(t, h, location, patient.UserID, 0)
+}
+
+func TestResumableUploadRejectsContent(t *testing.T) {
+	h, db, dir := newTestHandler(t)
+	patient := db.AddPatient("p@test.com", "Pat")
+	content := "MZ\x90\x00 not a PDF"
+	location := createUpload(t, h, patient.UserID, "scan.pdf", len(content))
+
+	rec := patchUpload(t, h, location, patient.UserID, 0, content)
+	testutil.ExpectStatus(t, rec, http.StatusUnsupportedMediaType)
+
+	if len(db.Prescriptions.Rows) != 0 || len(db.Uploads.Rows) != 0 || len(db.UploadChunks.Rows) != 0 {
+		t.Fatalf("rejected upload left %d prescriptions, %d uploads, %d chunks", len(db.Prescriptions.Rows), len(db.Uploads.Rows), len(db.UploadChunks.Rows))
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Fatalf("files left behind: %v", entries)
+	}
+}
+
+func TestTerminateUpload(t *testing.T) {
+	h, db, dir := newTestHandler(t)
+	patient := db.AddPatient("p@test.com", "Pat")
+	location := createUpload(t, h, patient.UserID, "scan.pdf", len(testPDF))
+	testutil.ExpectStatus(t, patchUpload(t, h, location, patient.UserID, 0, testPDF[:5]), http.StatusNoContent)
+
+	testutil.ExpectStatus(t, tusCall(t, h.TerminateUpload, http.MethodDelete, location, nil, patient.UserID), http.StatusNoContent)
+	testutil.ExpectStatus(t, tusCall(t, h.GetUploadOffset, http.MethodHead, location, nil, patient.UserID), http.StatusNotFound)
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Fatalf("files left behind: %v", entries)
+	}
+}
+
+func TestExpireUploads(t *testing.T) {
+	h, db, dir := newTestHandler(t)
+	patient := db.AddPatient("p@test.com", "Pat")
+	abandoned := createUpload(t, h, patient.UserID, "scan.pdf", len(testPDF))
+	testutil.ExpectStatus(t, patchUpload(t, h, abandoned, patient.UserID, 0, testPDF[:5]), http.StatusNoContent)
+	active := createUpload(t, h, patient.UserID, "other.pdf", len(testPDF))
+	testutil.ExpectStatus(t, patchUpload(t, h, active, patient.UserID, 0, testPDF[:5]), http.StatusNoContent)
+	completed := createUpload(t, h, patient.UserID, "done.pdf", len(testPDF))
+	testutil.ExpectStatus(t, patchUpload(t, h, completed, patient.UserID, 0, testPDF), http.StatusNoContent)
+
+	// A chunk stored by a request that failed before recording it
+	abandonedID := strings.TrimPrefix(abandoned, "/api/prescriptions/uploads?id=")
+	upload := db.Uploads.Rows[abandonedID]
+	orphan := filepath.Join(dir, uploadPrefix(&upload)+"00000000000000000005_orphan")
+	if err := os.WriteFile(orphan, []byte("1.4"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	upload.ExpiresAt = time.Now().UTC().Add(-time.Minute)
+	db.Uploads.Rows[abandonedID] = upload
+	completedID := strings.TrimPrefix(completed, "/api/prescriptions/uploads?id=")
+	done := db.Uploads.Rows[completedID]
+	done.ExpiresAt = upload.ExpiresAt
+	db.Uploads.Rows[completedID] = done
+
+	expired, err := h.ExpireUploads(context.Background())
+	if err != nil || expired != 1 {
+		t.Fatalf("expired %d, %v", expired, err)
+	}
+	if _, ok := db.Uploads.Rows[abandonedID]; ok {
+		t.Fatal("expired upload kept")
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 2 {
+		t.Fatalf("files = %v, want the active upload's chunk and the completed file", entries)
+	}
+	expectOffset(t, h, active, patient.UserID, 5)
+
+	// A completed upload still reports how it ended
+	rec := tusCall(t, h.GetUploadOffset, http.MethodHead, completed, nil, patient.UserID)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+	if rec.Header().Get("Prescription-ID") != *done.PrescriptionID {
+		t.Fatalf("headers = %v", rec.Header())
+	}
+}
+
+func TestExpiredUploadRefusesChunks(t *testing.T) {
+	h, db, _ := newTestHandler(t)
+	patient := db.AddPatient("p@test.com", "Pat")
+	location := createUpload(t, h, patient.UserID, "scan.pdf", len(testPDF))
+
+	// The database's clock has moved past the expiry; the handler's not yet
+	db.Now = func() time.Time { return time.Now().UTC().Add(uploadTTL + time.Minute) }
+	testutil.ExpectStatus(t, patchUpload(t, h, location, patient.UserID, 0, testPDF[:5]), http.StatusConflict)
+	if len(db.UploadChunks.Rows) != 0 {
+		t.Fatalf("chunks = %+v", db.UploadChunks.Rows)
+	}
+}
+
+func TestPatientDeletedRemovesUploads(t *testing.T) {
+	h, db, _ := newTestHandler(t)
+	patient := db.AddPatient("p@test.com", "Pat")
+	location := createUpload(t, h, patient.UserID, "scan.pdf", len(testPDF))
+	testutil.ExpectStatus(t, patchUpload(t, h, location, patient.UserID, 0, testPDF[:5]), http.StatusNoContent)
+
+	db.Lock()
+	memdb.Delete(db, "patient_profiles", db.PatientProfiles, patient.ID)
+	db.Unlock()
+	if len(db.Uploads.Rows) != 0 || len(db.UploadChunks.Rows) != 0 {
+		t.Fatalf("uploads = %+v, chunks = %+v", db.Uploads.Rows, db.UploadChunks.Rows)
+	}
+}
+
+func TestParseSizeLimits(t *testing.T) {
+	limits, err := ParseSizeLimits(" pdf=500MB, .PNG=20mb ,jpg=1GB,jpeg=4096 ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int64{".pdf": 500 << 20, ".png": 20 << 20, ".jpg": 1 << 30, ".jpeg": 4096}
+	for ext, limit := range want {
+		if limits[ext] != limit {
+			t.Errorf("%s = %d, want %d", ext, limits[ext], limit)
+		}
+	}
+
+	for _, bad := range []string{"pdf", "exe=1MB", "pdf=", "pdf=-1MB", "pdf=1TB", "pdf=99999999999GB"} {
+		if _, err := ParseSizeLimits(bad); err == nil {
+			t.Errorf("ParseSizeLimits(%q) accepted", bad)
+		}
+	}
+
+	for n, want := range map[int64]string{200 << 20: "200MB", 1 << 30: "1GB", 1536: "1536B", 4 << 10: "4KB"} {
+		if got := FormatSize(n); got != want {
+			t.Errorf("FormatSize(%d) = %s, want %s", n, got, want)
+		}
+	}
+}
@@ -1,13 +1,23 @@
 package main
 
 import (
+    "context"
+    "database/sql"
     "health-bar/shared/database"
-    "health-bar/shared/middleware"
+    "health-bar/shared/envelope"
+    "health-bar/shared/filecheck"
+    "health-bar/shared/events"
+    "health-bar/shared/idempotency"
+    "health-bar/shared/interactions"
+    "health-bar/shared/notify"
+    "health-bar/shared/storage"
+    "health-bar/shared/webhooks"
     "health-bar/services/prescription/handlers"
     "health-bar/services/prescription/repository"
     "log"
     "net/http"
     "os"
+    "time"
     "github.com/gorilla/mux"
     "github.com/joho/godotenv"
     "github.com/rs/cors"
@@ -29,25 +39,103 @@ func main() {
     }
     defer db.Close()
 
-    // Set upload path
-    uploadPath := getEnv("UPLOAD_PATH", "/app/uploads")
+    // Uploads go to a directory or an S3-compatible bucket, see storage.Open
+    blobStoreURL := getEnv("BLOB_STORE_URL", getEnv("UPLOAD_PATH", "/app/uploads"))
+    files, err := storage.Open(blobStoreURL)
+    if err != nil {
+        log.Fatal("Failed to open blob store:", err)
+    }
+    log.Printf("Storing uploads in %s", storage.Redact(blobStoreURL))
+    // A directory hands out download URLs only with a key to sign them,
+    // pointing at the files route through the gateway
+    if fs, ok := files.(*storage.Filesystem); ok {
+        if secret := os.Getenv("BLOB_URL_SECRET"); secret != "" {
+            fs.SignURLs(getEnv("PUBLIC_URL", "http://localhost:8000")+"/api/prescriptions/files", []byte(secret))
+        }
+    }
+
+    // ENCRYPTION_KEYS or ENCRYPTION_KEY_FILE turn on encryption at rest
+    masterKeys, err := envelope.FromEnv()
+    if err != nil {
+        log.Fatal("Failed to load encryption keys:", err)
+    }
+    if masterKeys != nil {
+        encrypted := storage.NewEncrypted(files, masterKeys)
+        // Set until cmd/blobs encrypt has run over files stored before
+        encrypted.ReadPlaintext(getEnv("ENCRYPTION_READ_PLAINTEXT", "false") == "true")
+        files = encrypted
+        log.Printf("Encrypting uploads at rest")
+    }
 
     repo := repository.NewPrescriptionRepository(db)
-    handler := handlers.NewPrescriptionHandler(repo, uploadPath)
+    handler := handlers.NewPrescriptionHandler(repo, files)
+    handler.UseNotifier(notify.NewPostgresNotifier(db))
+    handler.UseWebhooks(webhooks.NewPostgresEmitter(db))
+    // UPLOAD_SIZE_LIMITS overrides the largest file of some types, such as
+    // "pdf=500MB,png=20MB"
+    if value := os.Getenv("UPLOAD_SIZE_LIMITS"); value != "" {
+        limits, err := handlers.ParseSizeLimits(value)
+        if err != nil {
+            log.Fatal("Invalid UPLOAD_SIZE_LIMITS:", err)
+        }
+        handler.UseSizeLimits(limits)
+    }
+    // Uploads are always sanitized, and also scanned for malware when a
+    // clamd is configured
+    if addr := os.Getenv("CLAMD_ADDR"); addr != "" {
+        handler.UseScanner(filecheck.NewClamAV(addr))
+        log.Printf("Scanning uploads with clamd at %s", addr)
+    }
 
-    router := mux.NewRouter()
+    // Interaction checks use the newest imported dataset, or the built-in
+    // one until a dataset is imported with cmd/interactions
+    datasets := interactions.NewPostgresStore(db)
+    current := ""
+    if dataset, err := datasets.Current(context.Background()); err == nil {
+        handler.UseInteractions(dataset)
+        current = dataset.Version
+    } else if err != sql.ErrNoRows {
+        log.Fatal("Failed to load interaction dataset:", err)
+    } else {
+        log.Printf("No interaction dataset imported, using the built-in one")
+    }
+    // INTERACTIONS_RELOAD_INTERVAL=0 keeps the dataset loaded at startup
+    reload, err := time.ParseDuration(getEnv("INTERACTIONS_RELOAD_INTERVAL", "5m"))
+    if err != nil {
+        log.Fatal("Invalid INTERACTIONS_RELOAD_INTERVAL:", err)
+    }
+    if reload > 0 {
+        go datasets.WatchEvery(context.Background(), reload, current, handler.UseInteractions)
+    }
+
+    // Deleted patients' files are removed when patient.deleted arrives.
+    // Events wait in the service's inbox until handled; NATS only
+    // announces them.
+    bus, err := events.DialNATS(getEnv("NATS_URL", "nats://healthbar-nats:4222"), "prescription-service")
+    if err != nil {
+        log.Fatal(err)
+    }
+    defer bus.Close()
+    consumer := events.NewConsumer("prescription-service", events.NewPostgresInbox(db), bus)
+    handler.Subscribe(consumer)
+    if err := consumer.Start(context.Background()); err != nil {
+        log.Fatal("Failed to subscribe to events:", err)
+    }
+    go consumer.RunEvery(context.Background(), 30*time.Second)
 
-    // Prescription routes (protected)
-    router.HandleFunc("/api/prescriptions/upload", middleware.AuthMiddleware(handler.UploadPrescription)).Methods("POST")
-    router.HandleFunc("/api/prescriptions/my", middleware.AuthMiddleware(handler.GetMyPrescriptions)).Methods("GET")
-    router.HandleFunc("/api/prescriptions/patient", middleware.AuthMiddleware(handler.GetPatientPrescriptions)).Methods("GET")
-    router.HandleFunc("/api/prescriptions/download", middleware.AuthMiddleware(handler.DownloadPrescription)).Methods("GET")
-    router.HandleFunc("/api/prescriptions", middleware.AuthMiddleware(handler.DeletePrescription)).Methods("DELETE")
+    keys := idempotency.NewPostgresStore(db)
+    go keys.PurgeEvery(context.Background(), time.Hour)
+    // Resumable uploads left unfinished are removed with their chunks
+    go handler.ExpireUploadsEvery(context.Background(), 10*time.Minute)
+
+    router := mux.NewRouter()
+    handlers.RegisterRoutes(router, handler, keys)
 
     c := cors.New(cors.Options{
         AllowedOrigins:   []string{"http://localhost:3000"},
-        AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-        AllowedHeaders:   []string{"Content-Type", "Authorization"},
+        AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "HEAD", "DELETE", "OPTIONS"},
+        AllowedHeaders:   []string{"Content-Type", "Authorization", "Idempotency-Key", "Tus-Resumable", "Upload-Length", "Upload-Metadata", "Upload-Offset", "Upload-Checksum"},
+        ExposedHeaders:   []string{"Idempotent-Replayed", "Location", "Tus-Resumable", "Tus-Version", "Tus-Extension", "Tus-Max-Size", "Upload-Offset", "Upload-Length", "Upload-Expires", "Prescription-ID"},
         AllowCredentials: true,
     })
 
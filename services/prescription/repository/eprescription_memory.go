@@ -0,0 +1,147 @@
+package repository
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"slices"
+
+	"health-bar/shared/memdb"
+	"health-bar/shared/models"
+	"health-bar/shared/pagination"
+
+	"github.com/google/uuid"
+)
+
+func (r *MemoryRepository) GetDoctorProfileByUserID(ctx context.Context, userID string) (*models.DoctorProfile, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	doctor, ok := r.db.DoctorByUserID(userID)
+	if !ok {
+		return &models.DoctorProfile{}, sql.ErrNoRows
+	}
+	return &doctor, nil
+}
+
+func (r *MemoryRepository) CreateEPrescription(ctx context.Context, prescription *models.EPrescription) error {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	if _, ok := r.db.PatientProfiles.Rows[prescription.PatientID]; !ok {
+		return memdb.ForeignKeyViolation("e_prescriptions", "e_prescriptions_patient_id_fkey")
+	}
+	if prescription.DoctorID != nil {
+		if _, ok := r.db.DoctorProfiles.Rows[*prescription.DoctorID]; !ok {
+			return memdb.ForeignKeyViolation("e_prescriptions", "e_prescriptions_doctor_id_fkey")
+		}
+	}
+	if prescription.DocumentID != nil {
+		if _, ok := r.db.Prescriptions.Rows[*prescription.DocumentID]; !ok {
+			return memdb.ForeignKeyViolation("e_prescriptions", "e_prescriptions_document_id_fkey")
+		}
+	}
+	if prescription.DoctorID == nil {
+		return sql.ErrNoRows
+	}
+	if p, ok := r.db.Permission(prescription.PatientID, *prescription.DoctorID); !ok || !p.IsActive {
+		return sql.ErrNoRows
+	}
+
+	now := r.db.Now()
+	prescription.ID = uuid.New().String()
+	prescription.Status = models.PrescriptionActive
+	prescription.IssuedAt, prescription.CreatedAt, prescription.UpdatedAt = now, now, now
+	prescription.CancelledAt, prescription.CancelReason = nil, ""
+	r.db.EPrescriptions.Rows[prescription.ID] = *prescription
+	return nil
+}
+
+func (r *MemoryRepository) GetEPrescriptionByID(ctx context.Context, prescriptionID string) (*models.EPrescription, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	prescription, ok := r.db.EPrescriptions.Rows[prescriptionID]
+	if !ok {
+		return &models.EPrescription{}, sql.ErrNoRows
+	}
+	return &prescription, nil
+}
+
+func (r *MemoryRepository) GetEPrescriptionsByPatientID(ctx context.Context, patientID string, page EPrescriptionPage) ([]models.EPrescription, string, error) {
+	return r.listEPrescriptions(page, func(p models.EPrescription) bool { return p.PatientID == patientID })
+}
+
+func (r *MemoryRepository) GetEPrescriptionsByDoctorID(ctx context.Context, doctorID string, page EPrescriptionPage) ([]models.EPrescription, string, error) {
+	patientID, byPatient := page.Filters["patient_id"]
+	return r.listEPrescriptions(page, func(p models.EPrescription) bool {
+		return p.DoctorID != nil && *p.DoctorID == doctorID && (!byPatient || p.PatientID == patientID)
+	})
+}
+
+func (r *MemoryRepository) listEPrescriptions(page EPrescriptionPage, match func(models.EPrescription) bool) ([]models.EPrescription, string, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	status, byStatus := page.Filters["status"]
+	var prescriptions []models.EPrescription
+	for _, p := range r.db.EPrescriptions.Rows {
+		if !match(p) || !page.InRange(p.IssuedAt) || (byStatus && string(p.Status) != status) {
+			continue
+		}
+		prescriptions = append(prescriptions, p)
+	}
+	prescriptions, next := pagination.Apply(prescriptions, page)
+	return prescriptions, next, nil
+}
+
+func (r *MemoryRepository) CancelEPrescription(ctx context.Context, prescriptionID, reason string) (*models.EPrescription, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	prescription, ok := r.db.EPrescriptions.Rows[prescriptionID]
+	if !ok || prescription.Status != models.PrescriptionActive {
+		return &models.EPrescription{}, sql.ErrNoRows
+	}
+
+	now := r.db.Now()
+	prescription.Status = models.PrescriptionCancelled
+	prescription.CancelledAt, prescription.CancelReason, prescription.UpdatedAt = &now, reason, now
+	r.db.EPrescriptions.Rows[prescriptionID] = prescription
+	return &prescription, nil
+}
+
+func (r *MemoryRepository) GetPatientAllergies(ctx context.Context, patientID string) ([]models.Allergy, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	var allergies []models.Allergy
+	for _, a := range r.db.Allergies.Rows {
+		if a.PatientID == patientID {
+			allergies = append(allergies, a)
+		}
+	}
+	slices.SortFunc(allergies, func(a, b models.Allergy) int {
+		return cmp.Or(cmp.Compare(a.Substance, b.Substance), cmp.Compare(a.ID, b.ID))
+	})
+	return allergies, nil
+}
+
+func (r *MemoryRepository) GetPatientMedicationNames(ctx context.Context, patientID string) ([]string, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	var names []string
+	for _, m := range r.db.Medications.Rows {
+		if m.PatientID == patientID {
+			names = append(names, m.Name)
+		}
+	}
+	for _, p := range r.db.EPrescriptions.Rows {
+		if p.PatientID == patientID && p.Status == models.PrescriptionActive {
+			names = append(names, p.Drug)
+		}
+	}
+	slices.Sort(names)
+	return slices.Compact(names), nil
+}
@@ -0,0 +1,156 @@
+package repository
+
+import (
+    "context"
+    "health-bar/shared/database"
+    "health-bar/shared/models"
+    "health-bar/shared/pagination"
+    "github.com/google/uuid"
+)
+
+const ePrescriptionColumns = `id, patient_id, doctor_id, prescriber_name, document_id, drug, strength, route,
+        frequency, duration_days, refills, instructions, status, issued_at, cancelled_at, cancel_reason,
+        interactions_version, override_reason, created_at, updated_at`
+
+// GetDoctorProfileByUserID gets the doctor profile of a user
+func (r *PrescriptionRepository) GetDoctorProfileByUserID(ctx context.Context, userID string) (*models.DoctorProfile, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    doctor := &models.DoctorProfile{}
+    query := `
+        SELECT id, user_id, full_name, specialization, license_number, phone, created_at, updated_at, version
+        FROM doctor_profiles
+        WHERE user_id = $1
+    `
+    err := database.Conn(ctx, r.db).GetContext(ctx, doctor, query, userID)
+    return doctor, err
+}
+
+// CreateEPrescription issues a structured prescription. The insert checks
+// that the patient grants the prescribing doctor access in the same
+// statement, so a revoke cannot slip in between the check and the write;
+// without an active grant nothing is inserted and sql.ErrNoRows is returned.
+func (r *PrescriptionRepository) CreateEPrescription(ctx context.Context, prescription *models.EPrescription) error {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    prescription.ID = uuid.New().String()
+
+    query := `
+        INSERT INTO e_prescriptions (id, patient_id, doctor_id, prescriber_name, document_id, drug, strength,
+            route, frequency, duration_days, refills, instructions, interactions_version, override_reason)
+        SELECT $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14
+        WHERE EXISTS (
+            SELECT 1 FROM doctor_access_permissions
+            WHERE patient_id = $2 AND doctor_id = $3 AND is_active = true
+        )
+        RETURNING ` + ePrescriptionColumns
+
+    return database.Conn(ctx, r.db).QueryRowxContext(ctx, query,
+        prescription.ID, prescription.PatientID, prescription.DoctorID, prescription.PrescriberName,
+        prescription.DocumentID, prescription.Drug, prescription.Strength, prescription.Route,
+        prescription.Frequency, prescription.DurationDays, prescription.Refills, prescription.Instructions,
+        prescription.InteractionsVersion, prescription.OverrideReason,
+    ).StructScan(prescription)
+}
+
+// GetEPrescriptionByID gets an issued prescription by ID
+func (r *PrescriptionRepository) GetEPrescriptionByID(ctx context.Context, prescriptionID string) (*models.EPrescription, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    prescription := &models.EPrescription{}
+    query := `SELECT ` + ePrescriptionColumns + ` FROM e_prescriptions WHERE id = $1`
+    err := database.Conn(ctx, r.db).GetContext(ctx, prescription, query, prescriptionID)
+    return prescription, err
+}
+
+// GetEPrescriptionsByPatientID gets a page of the prescriptions issued to a patient and the cursor of the next page
+func (r *PrescriptionRepository) GetEPrescriptionsByPatientID(ctx context.Context, patientID string, page EPrescriptionPage) ([]models.EPrescription, string, error) {
+    q := &pagination.Query{}
+    q.Where("patient_id = " + q.Arg(patientID))
+    return r.listEPrescriptions(ctx, q, page)
+}
+
+// GetEPrescriptionsByDoctorID gets a page of the prescriptions a doctor issued and the cursor of the next page
+func (r *PrescriptionRepository) GetEPrescriptionsByDoctorID(ctx context.Context, doctorID string, page EPrescriptionPage) ([]models.EPrescription, string, error) {
+    q := &pagination.Query{}
+    q.Where("doctor_id = " + q.Arg(doctorID))
+    if patientID, ok := page.Filters["patient_id"]; ok {
+        q.Where("patient_id::text = " + q.Arg(patientID))
+    }
+    return r.listEPrescriptions(ctx, q, page)
+}
+
+func (r *PrescriptionRepository) listEPrescriptions(ctx context.Context, q *pagination.Query, page EPrescriptionPage) ([]models.EPrescription, string, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    if !page.From.IsZero() {
+        q.Where("issued_at >= " + q.Arg(pagination.Timestamp(page.From)) + "::timestamp")
+    }
+    if !page.To.IsZero() {
+        q.Where("issued_at <= " + q.Arg(pagination.Timestamp(page.ToEnd())) + "::timestamp")
+    }
+    if status, ok := page.Filters["status"]; ok {
+        q.Where("status = " + q.Arg(status))
+    }
+
+    var prescriptions []models.EPrescription
+    query := `SELECT ` + ePrescriptionColumns + ` FROM e_prescriptions` + page.Clauses(q)
+    if err := database.Conn(ctx, r.db).SelectContext(ctx, &prescriptions, query, q.Args()...); err != nil {
+        return nil, "", err
+    }
+    prescriptions, next := pagination.Page(prescriptions, page)
+    return prescriptions, next, nil
+}
+
+// CancelEPrescription cancels an active prescription. It returns sql.ErrNoRows
+// when the prescription does not exist or is no longer active.
+func (r *PrescriptionRepository) CancelEPrescription(ctx context.Context, prescriptionID, reason string) (*models.EPrescription, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    prescription := &models.EPrescription{}
+    query := `
+        UPDATE e_prescriptions
+        SET status = 'cancelled', cancelled_at = NOW(), cancel_reason = $2, updated_at = NOW()
+        WHERE id = $1 AND status = 'active'
+        RETURNING ` + ePrescriptionColumns
+    err := database.Conn(ctx, r.db).GetContext(ctx, prescription, query, prescriptionID, reason)
+    return prescription, err
+}
+
+// GetPatientAllergies gets the allergies on a patient's profile
+func (r *PrescriptionRepository) GetPatientAllergies(ctx context.Context, patientID string) ([]models.Allergy, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    var allergies []models.Allergy
+    query := `
+        SELECT id, patient_id, substance, reaction, severity, created_at, updated_at
+        FROM patient_allergies
+        WHERE patient_id = $1
+        ORDER BY substance, id
+    `
+    err := database.Conn(ctx, r.db).SelectContext(ctx, &allergies, query, patientID)
+    return allergies, err
+}
+
+// GetPatientMedicationNames gets the names of the medications on a patient's
+// profile and the drugs of their active issued prescriptions
+func (r *PrescriptionRepository) GetPatientMedicationNames(ctx context.Context, patientID string) ([]string, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    var names []string
+    query := `
+        SELECT name FROM patient_medications WHERE patient_id = $1
+        UNION
+        SELECT drug FROM e_prescriptions WHERE patient_id = $1 AND status = 'active'
+        ORDER BY 1
+    `
+    err := database.Conn(ctx, r.db).SelectContext(ctx, &names, query, patientID)
+    return names, err
+}
@@ -0,0 +1,125 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"health-bar/shared/memdb"
+	"health-bar/shared/models"
+	"health-bar/shared/pagination"
+
+	"github.com/google/uuid"
+)
+
+// MemoryRepository implements Store on top of memdb.
+type MemoryRepository struct {
+	db *memdb.DB
+}
+
+func NewMemoryRepository(db *memdb.DB) *MemoryRepository {
+	return &MemoryRepository{db: db}
+}
+
+func (r *MemoryRepository) WithTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	return r.db.WithTx(ctx, fn)
+}
+
+func (r *MemoryRepository) CreatePrescription(ctx context.Context, patientID string, prescription *models.Prescription) error {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	if _, ok := r.db.PatientProfiles.Rows[patientID]; !ok {
+		return memdb.ForeignKeyViolation("prescriptions", "prescriptions_patient_id_fkey")
+	}
+
+	now := r.db.Now()
+	prescription.ID = uuid.New().String()
+	prescription.PatientID = patientID
+	prescription.UploadDate, prescription.CreatedAt = now, now
+	r.db.Prescriptions.Rows[prescription.ID] = *prescription
+	return nil
+}
+
+func (r *MemoryRepository) GetPrescriptionByID(ctx context.Context, prescriptionID string) (*models.Prescription, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	prescription, ok := r.db.Prescriptions.Rows[prescriptionID]
+	if !ok {
+		return &models.Prescription{}, sql.ErrNoRows
+	}
+	return &prescription, nil
+}
+
+func (r *MemoryRepository) GetPrescriptionsByPatientID(ctx context.Context, patientID string, page PrescriptionPage) ([]models.Prescription, string, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	fileType := fileTypeFilter(page)
+	var prescriptions []models.Prescription
+	for _, p := range r.db.Prescriptions.Rows {
+		if p.PatientID != patientID || !page.InRange(p.UploadDate) {
+			continue
+		}
+		if fileType != "" && p.FileType != fileType {
+			continue
+		}
+		prescriptions = append(prescriptions, p)
+	}
+	prescriptions, next := pagination.Apply(prescriptions, page)
+	return prescriptions, next, nil
+}
+
+func (r *MemoryRepository) DeletePrescription(ctx context.Context, prescriptionID string) error {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	memdb.Delete(r.db, "prescriptions", r.db.Prescriptions, prescriptionID)
+	return nil
+}
+
+func (r *MemoryRepository) GetPatientIDByPrescriptionID(ctx context.Context, prescriptionID string) (string, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	prescription, ok := r.db.Prescriptions.Rows[prescriptionID]
+	if !ok {
+		return "", sql.ErrNoRows
+	}
+	return prescription.PatientID, nil
+}
+
+func (r *MemoryRepository) GetPatientProfileIDByUserID(ctx context.Context, userID string) (string, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	profile, ok := r.db.PatientByUserID(userID)
+	if !ok {
+		return "", sql.ErrNoRows
+	}
+	return profile.ID, nil
+}
+
+func (r *MemoryRepository) GetPatientName(ctx context.Context, patientProfileID string) (string, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	profile, ok := r.db.PatientProfiles.Rows[patientProfileID]
+	if !ok {
+		return "", sql.ErrNoRows
+	}
+	return profile.FullName, nil
+}
+
+func (r *MemoryRepository) GetDoctorUserIDsWithAccess(ctx context.Context, patientProfileID string) ([]string, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	return r.db.DoctorUserIDsWithAccess(patientProfileID), nil
+}
+
+func (r *MemoryRepository) CheckDoctorAccess(ctx context.Context, doctorUserID, patientProfileID string) (bool, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	return r.db.DoctorHasAccess(doctorUserID, patientProfileID), nil
+}
@@ -0,0 +1,189 @@
+package repository
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"slices"
+	"time"
+
+	"health-bar/shared/memdb"
+	"health-bar/shared/models"
+	"health-bar/shared/pagination"
+
+	"github.com/google/uuid"
+)
+
+func (r *MemoryRepository) OpenThread(ctx context.Context, patientID, doctorID string) (*models.MessageThread, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	for _, t := range r.db.MessageThreads.Rows {
+		if t.PatientID == patientID && t.DoctorID == doctorID {
+			return &t, nil
+		}
+	}
+	if _, ok := r.db.PatientProfiles.Rows[patientID]; !ok {
+		return nil, memdb.ForeignKeyViolation("message_threads", "message_threads_patient_id_fkey")
+	}
+	if _, ok := r.db.DoctorProfiles.Rows[doctorID]; !ok {
+		return nil, memdb.ForeignKeyViolation("message_threads", "message_threads_doctor_id_fkey")
+	}
+
+	now := r.db.Now()
+	thread := models.MessageThread{ID: uuid.New().String(), PatientID: patientID, DoctorID: doctorID, CreatedAt: now, UpdatedAt: now}
+	r.db.MessageThreads.Rows[thread.ID] = thread
+	return &thread, nil
+}
+
+func (r *MemoryRepository) CheckAccessGrant(ctx context.Context, patientID, doctorID string) (bool, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	p, ok := r.db.Permission(patientID, doctorID)
+	return ok && p.IsActive, nil
+}
+
+func (r *MemoryRepository) GetThreadByID(ctx context.Context, threadID string) (*models.MessageThread, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	thread, ok := r.db.MessageThreads.Rows[threadID]
+	if !ok {
+		return &models.MessageThread{}, sql.ErrNoRows
+	}
+	return &thread, nil
+}
+
+func (r *MemoryRepository) GetThreadsByPatientID(ctx context.Context, patientID string, page ThreadPage) ([]models.MessageThread, string, error) {
+	return r.listThreads(models.RolePatient, func(t models.MessageThread) bool { return t.PatientID == patientID }, page)
+}
+
+func (r *MemoryRepository) GetThreadsByDoctorID(ctx context.Context, doctorID string, page ThreadPage) ([]models.MessageThread, string, error) {
+	return r.listThreads(models.RoleDoctor, func(t models.MessageThread) bool { return t.DoctorID == doctorID }, page)
+}
+
+func (r *MemoryRepository) listThreads(reader models.UserRole, match func(models.MessageThread) bool, page ThreadPage) ([]models.MessageThread, string, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	var threads []models.MessageThread
+	for _, t := range r.db.MessageThreads.Rows {
+		if !match(t) {
+			continue
+		}
+		t.Unread = 0
+		for _, m := range r.db.Messages.Rows {
+			if m.ThreadID != t.ID || m.SenderRole == reader || m.ReadAt != nil {
+				continue
+			}
+			if t.FrozenAt != nil && reader != models.RolePatient && m.CreatedAt.After(*t.FrozenAt) {
+				continue
+			}
+			t.Unread++
+		}
+		threads = append(threads, t)
+	}
+	threads, next := pagination.Apply(threads, page)
+	return threads, next, nil
+}
+
+func (r *MemoryRepository) CreateMessage(ctx context.Context, message *models.Message) error {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	thread, ok := r.db.MessageThreads.Rows[message.ThreadID]
+	if !ok || thread.FrozenAt != nil {
+		return sql.ErrNoRows
+	}
+
+	now := r.db.Now()
+	thread.LastMessageAt, thread.UpdatedAt = &now, now
+	r.db.MessageThreads.Rows[thread.ID] = thread
+
+	message.ID = uuid.New().String()
+	message.ReadAt = nil
+	message.CreatedAt = now
+	message.Attachments = []models.MessageAttachment{}
+	r.db.Messages.Rows[message.ID] = *message
+	return nil
+}
+
+func (r *MemoryRepository) CreateMessageAttachment(ctx context.Context, attachment *models.MessageAttachment) error {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	if _, ok := r.db.Messages.Rows[attachment.MessageID]; !ok {
+		return memdb.ForeignKeyViolation("message_attachments", "message_attachments_message_id_fkey")
+	}
+
+	attachment.ID = uuid.New().String()
+	attachment.CreatedAt = r.db.Now()
+	r.db.MessageAttachments.Rows[attachment.ID] = *attachment
+	return nil
+}
+
+func (r *MemoryRepository) GetMessages(ctx context.Context, threadID string, until time.Time, page MessagePage) ([]models.Message, string, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	var messages []models.Message
+	for _, m := range r.db.Messages.Rows {
+		if m.ThreadID != threadID || !page.InRange(m.CreatedAt) {
+			continue
+		}
+		if !until.IsZero() && m.CreatedAt.After(until) {
+			continue
+		}
+		messages = append(messages, m)
+	}
+	messages, next := pagination.Apply(messages, page)
+
+	for i := range messages {
+		messages[i].Attachments = []models.MessageAttachment{}
+		for _, a := range r.db.MessageAttachments.Rows {
+			if a.MessageID == messages[i].ID {
+				messages[i].Attachments = append(messages[i].Attachments, a)
+			}
+		}
+		slices.SortFunc(messages[i].Attachments, func(a, b models.MessageAttachment) int {
+			return cmp.Or(a.CreatedAt.Compare(b.CreatedAt), cmp.Compare(a.ID, b.ID))
+		})
+	}
+	return messages, next, nil
+}
+
+func (r *MemoryRepository) GetMessageAttachmentByID(ctx context.Context, attachmentID string) (*models.MessageAttachment, *models.Message, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	attachment, ok := r.db.MessageAttachments.Rows[attachmentID]
+	if !ok {
+		return nil, nil, sql.ErrNoRows
+	}
+	message, ok := r.db.Messages.Rows[attachment.MessageID]
+	if !ok {
+		return nil, nil, sql.ErrNoRows
+	}
+	return &attachment, &message, nil
+}
+
+func (r *MemoryRepository) MarkMessagesRead(ctx context.Context, threadID string, reader models.UserRole, until time.Time) (int64, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	now := r.db.Now()
+	var marked int64
+	for id, m := range r.db.Messages.Rows {
+		if m.ThreadID != threadID || m.SenderRole == reader || m.ReadAt != nil {
+			continue
+		}
+		if !until.IsZero() && m.CreatedAt.After(until) {
+			continue
+		}
+		m.ReadAt = &now
+		r.db.Messages.Rows[id] = m
+		marked++
+	}
+	return marked, nil
+}
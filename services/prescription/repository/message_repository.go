@@ -0,0 +1,248 @@
+package repository
+
+import (
+    "context"
+    "health-bar/shared/database"
+    "health-bar/shared/models"
+    "health-bar/shared/pagination"
+    "time"
+    "github.com/google/uuid"
+)
+
+const threadColumns = `t.id, t.patient_id, t.doctor_id, t.frozen_at, t.last_message_at, t.created_at, t.updated_at`
+
+const messageColumns = `id, thread_id, sender_role, body, read_at, created_at`
+
+const attachmentColumns = `id, message_id, file_name, file_type, file_size, file_path, created_at`
+
+// OpenThread gets the thread between a patient and a doctor, creating it
+// when there is none
+func (r *PrescriptionRepository) OpenThread(ctx context.Context, patientID, doctorID string) (*models.MessageThread, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    // The no-op update makes RETURNING yield the existing row on conflict
+    thread := &models.MessageThread{}
+    query := `
+        INSERT INTO message_threads AS t (id, patient_id, doctor_id)
+        VALUES ($1, $2, $3)
+        ON CONFLICT (patient_id, doctor_id) DO UPDATE SET patient_id = EXCLUDED.patient_id
+        RETURNING ` + threadColumns
+
+    err := database.Conn(ctx, r.db).QueryRowxContext(ctx, query, uuid.New().String(), patientID, doctorID).StructScan(thread)
+    return thread, err
+}
+
+// CheckAccessGrant checks if a patient has an active grant for a doctor
+func (r *PrescriptionRepository) CheckAccessGrant(ctx context.Context, patientID, doctorID string) (bool, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    var active bool
+    query := `
+        SELECT EXISTS (
+            SELECT 1 FROM doctor_access_permissions
+            WHERE patient_id::text = $1 AND doctor_id::text = $2 AND is_active
+        )
+    `
+    err := database.Conn(ctx, r.db).GetContext(ctx, &active, query, patientID, doctorID)
+    return active, err
+}
+
+// GetThreadByID gets a message thread by ID
+func (r *PrescriptionRepository) GetThreadByID(ctx context.Context, threadID string) (*models.MessageThread, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    thread := &models.MessageThread{}
+    query := `SELECT ` + threadColumns + ` FROM message_threads t WHERE t.id = $1`
+    err := database.Conn(ctx, r.db).GetContext(ctx, thread, query, threadID)
+    return thread, err
+}
+
+// GetThreadsByPatientID gets a page of a patient's threads and the cursor of the next page
+func (r *PrescriptionRepository) GetThreadsByPatientID(ctx context.Context, patientID string, page ThreadPage) ([]models.MessageThread, string, error) {
+    q := &pagination.Query{}
+    unread := unreadCount(q, models.RolePatient)
+    q.Where("t.patient_id = " + q.Arg(patientID))
+    return r.listThreads(ctx, q, unread, page)
+}
+
+// GetThreadsByDoctorID gets a page of a doctor's threads and the cursor of the next page
+func (r *PrescriptionRepository) GetThreadsByDoctorID(ctx context.Context, doctorID string, page ThreadPage) ([]models.MessageThread, string, error) {
+    q := &pagination.Query{}
+    unread := unreadCount(q, models.RoleDoctor)
+    q.Where("t.doctor_id = " + q.Arg(doctorID))
+    return r.listThreads(ctx, q, unread, page)
+}
+
+// unreadCount is the select expression counting the messages of thread t
+// that reader has not read. Doctors do not count messages from after a
+// freeze.
+func unreadCount(q *pagination.Query, reader models.UserRole) string {
+    role := q.Arg(string(reader))
+    return `(SELECT COUNT(*) FROM messages m
+            WHERE m.thread_id = t.id AND m.sender_role <> ` + role + ` AND m.read_at IS NULL
+              AND (t.frozen_at IS NULL OR ` + role + ` = 'patient' OR m.created_at <= t.frozen_at)) AS unread`
+}
+
+func (r *PrescriptionRepository) listThreads(ctx context.Context, q *pagination.Query, unread string, page ThreadPage) ([]models.MessageThread, string, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    var threads []models.MessageThread
+    query := `SELECT ` + threadColumns + `, ` + unread + ` FROM message_threads t` + page.Clauses(q)
+    if err := database.Conn(ctx, r.db).SelectContext(ctx, &threads, query, q.Args()...); err != nil {
+        return nil, "", err
+    }
+    threads, next := pagination.Page(threads, page)
+    return threads, next, nil
+}
+
+// CreateMessage adds a message to a thread and bumps the thread's activity.
+// It returns sql.ErrNoRows when the thread is frozen.
+func (r *PrescriptionRepository) CreateMessage(ctx context.Context, message *models.Message) error {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    message.ID = uuid.New().String()
+
+    // Checking the freeze in the same statement keeps a message from
+    // slipping in while access is revoked
+    query := `
+        WITH thread AS (
+            UPDATE message_threads SET last_message_at = NOW(), updated_at = NOW()
+            WHERE id = $2 AND frozen_at IS NULL
+            RETURNING id
+        )
+        INSERT INTO messages (id, thread_id, sender_role, body)
+        SELECT $1::uuid, id, $3, $4 FROM thread
+        RETURNING ` + messageColumns
+
+    err := database.Conn(ctx, r.db).QueryRowxContext(ctx, query,
+        message.ID, message.ThreadID, message.SenderRole, message.Body,
+    ).StructScan(message)
+    if err == nil {
+        message.Attachments = []models.MessageAttachment{}
+    }
+    return err
+}
+
+// CreateMessageAttachment records a file sent with a message
+func (r *PrescriptionRepository) CreateMessageAttachment(ctx context.Context, attachment *models.MessageAttachment) error {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    attachment.ID = uuid.New().String()
+
+    query := `
+        INSERT INTO message_attachments (id, message_id, file_name, file_type, file_size, file_path)
+        VALUES ($1, $2, $3, $4, $5, $6)
+        RETURNING ` + attachmentColumns
+
+    return database.Conn(ctx, r.db).QueryRowxContext(ctx, query,
+        attachment.ID, attachment.MessageID, attachment.FileName,
+        attachment.FileType, attachment.FileSize, attachment.FilePath,
+    ).StructScan(attachment)
+}
+
+// GetMessages gets a page of a thread's messages sent up to until, with
+// their attachments, and the cursor of the next page. A zero until means
+// no limit.
+func (r *PrescriptionRepository) GetMessages(ctx context.Context, threadID string, until time.Time, page MessagePage) ([]models.Message, string, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    q := &pagination.Query{}
+    q.Where("thread_id = " + q.Arg(threadID))
+    if !until.IsZero() {
+        q.Where("created_at <= " + q.Arg(pagination.Timestamp(until)) + "::timestamp")
+    }
+    if !page.From.IsZero() {
+        q.Where("created_at >= " + q.Arg(pagination.Timestamp(page.From)) + "::timestamp")
+    }
+    if !page.To.IsZero() {
+        q.Where("created_at <= " + q.Arg(pagination.Timestamp(page.ToEnd())) + "::timestamp")
+    }
+
+    var messages []models.Message
+    query := `SELECT ` + messageColumns + ` FROM messages` + page.Clauses(q)
+    if err := database.Conn(ctx, r.db).SelectContext(ctx, &messages, query, q.Args()...); err != nil {
+        return nil, "", err
+    }
+    messages, next := pagination.Page(messages, page)
+    if err := r.loadAttachments(ctx, messages); err != nil {
+        return nil, "", err
+    }
+    return messages, next, nil
+}
+
+// loadAttachments fills in the attachments of messages
+func (r *PrescriptionRepository) loadAttachments(ctx context.Context, messages []models.Message) error {
+    if len(messages) == 0 {
+        return nil
+    }
+
+    ids := make([]string, len(messages))
+    byID := make(map[string]*models.Message, len(messages))
+    for i := range messages {
+        ids[i] = messages[i].ID
+        byID[messages[i].ID] = &messages[i]
+        messages[i].Attachments = []models.MessageAttachment{}
+    }
+
+    var attachments []models.MessageAttachment
+    query := `SELECT ` + attachmentColumns + ` FROM message_attachments WHERE message_id = ANY($1::uuid[]) ORDER BY created_at, id`
+    if err := database.Conn(ctx, r.db).SelectContext(ctx, &attachments, query, ids); err != nil {
+        return err
+    }
+    for _, a := range attachments {
+        message := byID[a.MessageID]
+        message.Attachments = append(message.Attachments, a)
+    }
+    return nil
+}
+
+// GetMessageAttachmentByID gets an attachment and the message it was sent with
+func (r *PrescriptionRepository) GetMessageAttachmentByID(ctx context.Context, attachmentID string) (*models.MessageAttachment, *models.Message, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    attachment := &models.MessageAttachment{}
+    query := `SELECT ` + attachmentColumns + ` FROM message_attachments WHERE id = $1`
+    if err := database.Conn(ctx, r.db).GetContext(ctx, attachment, query, attachmentID); err != nil {
+        return nil, nil, err
+    }
+
+    message := &models.Message{}
+    query = `SELECT ` + messageColumns + ` FROM messages WHERE id = $1`
+    if err := database.Conn(ctx, r.db).GetContext(ctx, message, query, attachment.MessageID); err != nil {
+        return nil, nil, err
+    }
+    return attachment, message, nil
+}
+
+// MarkMessagesRead sets the read receipt of the unread messages the other
+// party sent reader in a thread up to until, and returns how many it set. A
+// zero until means no limit.
+func (r *PrescriptionRepository) MarkMessagesRead(ctx context.Context, threadID string, reader models.UserRole, until time.Time) (int64, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    var cutoff interface{}
+    if !until.IsZero() {
+        cutoff = pagination.Timestamp(until)
+    }
+
+    query := `
+        UPDATE messages SET read_at = NOW()
+        WHERE thread_id = $1 AND sender_role <> $2 AND read_at IS NULL
+          AND ($3::timestamp IS NULL OR created_at <= $3::timestamp)
+    `
+
+    result, err := database.Conn(ctx, r.db).ExecContext(ctx, query, threadID, reader, cutoff)
+    if err != nil {
+        return 0, err
+    }
+    return result.RowsAffected()
+}
@@ -0,0 +1,87 @@
+package repository
+
+import (
+	"strings"
+
+	"health-bar/shared/models"
+	"health-bar/shared/pagination"
+)
+
+// PrescriptionPage is a page request for a patient's prescriptions.
+type PrescriptionPage = pagination.Params[models.Prescription]
+
+// PrescriptionPages lists prescriptions newest upload first by default. from
+// and to bound the upload date; file_type matches the extension, with or
+// without the leading dot.
+var PrescriptionPages = &pagination.Spec[models.Prescription]{
+	Sorts: []pagination.Sort[models.Prescription]{
+		{Name: "upload_date", Column: "upload_date", Cast: "timestamp",
+			Value: func(p models.Prescription) string { return pagination.Timestamp(p.UploadDate) }},
+		{Name: "file_name", Column: "file_name", Cast: "text",
+			Value: func(p models.Prescription) string { return p.FileName }},
+		{Name: "file_size", Column: "file_size", Cast: "bigint",
+			Value: func(p models.Prescription) string { return pagination.Int(p.FileSize) }},
+	},
+	Default:   "-upload_date",
+	Filters:   []string{"file_type"},
+	DateRange: true,
+	ID:        func(p models.Prescription) string { return p.ID },
+}
+
+// fileTypeFilter normalises the file_type filter to the stored form, e.g.
+// "PDF" to ".pdf".
+func fileTypeFilter(page PrescriptionPage) string {
+	fileType, ok := page.Filters["file_type"]
+	if !ok {
+		return ""
+	}
+	return "." + strings.TrimPrefix(strings.ToLower(fileType), ".")
+}
+
+// EPrescriptionPage is a page request for issued prescriptions.
+type EPrescriptionPage = pagination.Params[models.EPrescription]
+
+// EPrescriptionPages lists issued prescriptions newest first by default.
+// from and to bound the issue date. A doctor's own list can also be narrowed
+// to one patient_id; patient lists ignore that filter.
+var EPrescriptionPages = &pagination.Spec[models.EPrescription]{
+	Sorts: []pagination.Sort[models.EPrescription]{
+		{Name: "issued_at", Column: "issued_at", Cast: "timestamp",
+			Value: func(p models.EPrescription) string { return pagination.Timestamp(p.IssuedAt) }},
+		{Name: "drug", Column: "drug", Cast: "text",
+			Value: func(p models.EPrescription) string { return p.Drug }},
+	},
+	Default:   "-issued_at",
+	Filters:   []string{"status", "patient_id"},
+	DateRange: true,
+	ID:        func(p models.EPrescription) string { return p.ID },
+}
+
+// ThreadPage is a page request for message threads.
+type ThreadPage = pagination.Params[models.MessageThread]
+
+// ThreadPages lists threads with the latest activity first by default.
+var ThreadPages = &pagination.Spec[models.MessageThread]{
+	Sorts: []pagination.Sort[models.MessageThread]{
+		{Name: "updated_at", Column: "t.updated_at", Cast: "timestamp",
+			Value: func(t models.MessageThread) string { return pagination.Timestamp(t.UpdatedAt) }},
+	},
+	Default:  "-updated_at",
+	ID:       func(t models.MessageThread) string { return t.ID },
+	IDColumn: "t.id",
+}
+
+// MessagePage is a page request for the messages of a thread.
+type MessagePage = pagination.Params[models.Message]
+
+// MessagePages lists messages newest first by default. from and to bound
+// the date sent.
+var MessagePages = &pagination.Spec[models.Message]{
+	Sorts: []pagination.Sort[models.Message]{
+		{Name: "created_at", Column: "created_at", Cast: "timestamp",
+			Value: func(m models.Message) string { return pagination.Timestamp(m.CreatedAt) }},
+	},
+	Default:   "-created_at",
+	DateRange: true,
+	ID:        func(m models.Message) string { return m.ID },
+}
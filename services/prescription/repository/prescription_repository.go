@@ -1,7 +1,11 @@
 package repository
 
 import (
+    "context"
+    "database/sql"
+    "health-bar/shared/database"
     "health-bar/shared/models"
+    "health-bar/shared/pagination"
     "github.com/jmoiron/sqlx"
     "github.com/google/uuid"
 )
@@ -14,8 +18,16 @@ func NewPrescriptionRepository(db *sqlx.DB) *PrescriptionRepository {
     return &PrescriptionRepository{db: db}
 }
 
+// WithTx runs fn in a transaction shared by every repository call made with its context
+func (r *PrescriptionRepository) WithTx(ctx context.Context, fn func(ctx context.Context) error) error {
+    return database.WithTx(ctx, r.db, fn)
+}
+
 // CreatePrescription creates a new prescription record
-func (r *PrescriptionRepository) CreatePrescription(patientID string, prescription *models.Prescription) error {
+func (r *PrescriptionRepository) CreatePrescription(ctx context.Context, patientID string, prescription *models.Prescription) error {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
     prescription.ID = uuid.New().String()
     prescription.PatientID = patientID
 
@@ -25,79 +37,133 @@ func (r *PrescriptionRepository) CreatePrescription(patientID string, prescripti
         RETURNING id, patient_id, file_name, file_type, file_size, file_path, upload_date, created_at
     `
 
-    return r.db.QueryRowx(query,
+    return database.Conn(ctx, r.db).QueryRowxContext(ctx, query,
         prescription.ID, prescription.PatientID, prescription.FileName,
         prescription.FileType, prescription.FileSize, prescription.FilePath,
     ).StructScan(prescription)
 }
 
 // GetPrescriptionByID gets a prescription by ID
-func (r *PrescriptionRepository) GetPrescriptionByID(prescriptionID string) (*models.Prescription, error) {
+func (r *PrescriptionRepository) GetPrescriptionByID(ctx context.Context, prescriptionID string) (*models.Prescription, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
     prescription := &models.Prescription{}
     query := `
         SELECT id, patient_id, file_name, file_type, file_size, file_path, upload_date, created_at
         FROM prescriptions
         WHERE id = $1
     `
-    err := r.db.Get(prescription, query, prescriptionID)
+    err := database.Conn(ctx, r.db).GetContext(ctx, prescription, query, prescriptionID)
     return prescription, err
 }
 
-// GetPrescriptionsByPatientID gets all prescriptions for a patient
-func (r *PrescriptionRepository) GetPrescriptionsByPatientID(patientID string) ([]models.Prescription, error) {
+// GetPrescriptionsByPatientID gets a page of prescriptions for a patient and the cursor of the next page
+func (r *PrescriptionRepository) GetPrescriptionsByPatientID(ctx context.Context, patientID string, page PrescriptionPage) ([]models.Prescription, string, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    q := &pagination.Query{}
+    q.Where("patient_id = " + q.Arg(patientID))
+    if !page.From.IsZero() {
+        q.Where("upload_date >= " + q.Arg(pagination.Timestamp(page.From)) + "::timestamp")
+    }
+    if !page.To.IsZero() {
+        q.Where("upload_date <= " + q.Arg(pagination.Timestamp(page.ToEnd())) + "::timestamp")
+    }
+    if fileType := fileTypeFilter(page); fileType != "" {
+        q.Where("file_type = " + q.Arg(fileType))
+    }
+
     var prescriptions []models.Prescription
     query := `
         SELECT id, patient_id, file_name, file_type, file_size, file_path, upload_date, created_at
-        FROM prescriptions
-        WHERE patient_id = $1
-        ORDER BY upload_date DESC
-    `
-    err := r.db.Select(&prescriptions, query, patientID)
-    return prescriptions, err
+        FROM prescriptions` + page.Clauses(q)
+    if err := database.Conn(ctx, r.db).SelectContext(ctx, &prescriptions, query, q.Args()...); err != nil {
+        return nil, "", err
+    }
+    prescriptions, next := pagination.Page(prescriptions, page)
+    return prescriptions, next, nil
 }
 
 // DeletePrescription deletes a prescription
-func (r *PrescriptionRepository) DeletePrescription(prescriptionID string) error {
+func (r *PrescriptionRepository) DeletePrescription(ctx context.Context, prescriptionID string) error {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
     query := `DELETE FROM prescriptions WHERE id = $1`
-    _, err := r.db.Exec(query, prescriptionID)
+    _, err := database.Conn(ctx, r.db).ExecContext(ctx, query, prescriptionID)
     return err
 }
 
 // GetPatientIDByPrescriptionID gets the patient ID for a prescription
-func (r *PrescriptionRepository) GetPatientIDByPrescriptionID(prescriptionID string) (string, error) {
+func (r *PrescriptionRepository) GetPatientIDByPrescriptionID(ctx context.Context, prescriptionID string) (string, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
     var patientID string
     query := `SELECT patient_id FROM prescriptions WHERE id = $1`
-    err := r.db.Get(&patientID, query, prescriptionID)
+    err := database.Conn(ctx, r.db).GetContext(ctx, &patientID, query, prescriptionID)
     return patientID, err
 }
 
 // GetPatientProfileIDByUserID gets patient profile ID from user ID
-func (r *PrescriptionRepository) GetPatientProfileIDByUserID(userID string) (string, error) {
+func (r *PrescriptionRepository) GetPatientProfileIDByUserID(ctx context.Context, userID string) (string, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
     var profileID string
     query := `SELECT id FROM patient_profiles WHERE user_id = $1`
-    err := r.db.Get(&profileID, query, userID)
+    err := database.Conn(ctx, r.db).GetContext(ctx, &profileID, query, userID)
     return profileID, err
 }
 
+// GetPatientName gets a patient's full name
+func (r *PrescriptionRepository) GetPatientName(ctx context.Context, patientProfileID string) (string, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    var name string
+    query := `SELECT full_name FROM patient_profiles WHERE id = $1`
+    err := database.Conn(ctx, r.db).GetContext(ctx, &name, query, patientProfileID)
+    return name, err
+}
+
+// GetDoctorUserIDsWithAccess lists the user IDs of the doctors the patient
+// has granted active access
+func (r *PrescriptionRepository) GetDoctorUserIDsWithAccess(ctx context.Context, patientProfileID string) ([]string, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    userIDs := []string{}
+    query := `
+        SELECT d.user_id
+        FROM doctor_access_permissions dap
+        INNER JOIN doctor_profiles d ON d.id = dap.doctor_id
+        WHERE dap.patient_id = $1 AND dap.is_active = true
+        ORDER BY d.user_id
+    `
+    err := database.Conn(ctx, r.db).SelectContext(ctx, &userIDs, query, patientProfileID)
+    return userIDs, err
+}
+
 // CheckDoctorAccess checks if a doctor has access to view patient's prescriptions
-func (r *PrescriptionRepository) CheckDoctorAccess(doctorUserID, patientProfileID string) (bool, error) {
-    // Get doctor profile ID
-    var doctorProfileID string
-    query := `SELECT id FROM doctor_profiles WHERE user_id = $1`
-    err := r.db.Get(&doctorProfileID, query, doctorUserID)
-    if err != nil {
-        return false, err
-    }
+func (r *PrescriptionRepository) CheckDoctorAccess(ctx context.Context, doctorUserID, patientProfileID string) (bool, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
 
-    // Check access permission
     var isActive bool
-    query = `
-        SELECT is_active
-        FROM doctor_access_permissions
-        WHERE doctor_id = $1 AND patient_id = $2
+    query := `
+        SELECT dap.is_active
+        FROM doctor_access_permissions dap
+        INNER JOIN doctor_profiles d ON d.id = dap.doctor_id
+        WHERE d.user_id = $1 AND dap.patient_id = $2
     `
-    err = r.db.Get(&isActive, query, doctorProfileID, patientProfileID)
+    err := database.Conn(ctx, r.db).GetContext(ctx, &isActive, query, doctorUserID, patientProfileID)
     if err != nil {
+        if err == sql.ErrNoRows {
+            return false, nil // No permission found = no access
+        }
         return false, err
     }
     return isActive, nil
@@ -0,0 +1,122 @@
+package repository
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"slices"
+	"time"
+
+	"health-bar/shared/memdb"
+	"health-bar/shared/models"
+
+	"github.com/google/uuid"
+)
+
+func (r *MemoryRepository) CreateMedicationSchedule(ctx context.Context, schedule *models.MedicationSchedule) error {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	if _, ok := r.db.PatientProfiles.Rows[schedule.PatientID]; !ok {
+		return memdb.ForeignKeyViolation("medication_schedules", "medication_schedules_patient_id_fkey")
+	}
+	if schedule.PrescriptionID != nil {
+		if _, ok := r.db.EPrescriptions.Rows[*schedule.PrescriptionID]; !ok {
+			return memdb.ForeignKeyViolation("medication_schedules", "medication_schedules_prescription_id_fkey")
+		}
+	}
+
+	now := r.db.Now()
+	schedule.ID = uuid.New().String()
+	schedule.CreatedAt, schedule.UpdatedAt = now, now
+	r.db.MedicationSchedules.Rows[schedule.ID] = *schedule
+	return nil
+}
+
+func (r *MemoryRepository) GetMedicationScheduleByID(ctx context.Context, scheduleID string) (*models.MedicationSchedule, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	schedule, ok := r.db.MedicationSchedules.Rows[scheduleID]
+	if !ok {
+		return &models.MedicationSchedule{}, sql.ErrNoRows
+	}
+	return &schedule, nil
+}
+
+func (r *MemoryRepository) ListMedicationSchedules(ctx context.Context, patientID string) ([]models.MedicationSchedule, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	var schedules []models.MedicationSchedule
+	for _, s := range r.db.MedicationSchedules.Rows {
+		if s.PatientID == patientID {
+			schedules = append(schedules, s)
+		}
+	}
+	slices.SortFunc(schedules, func(a, b models.MedicationSchedule) int {
+		return cmp.Or(a.StartDate.Compare(b.StartDate), cmp.Compare(a.ID, b.ID))
+	})
+	return schedules, nil
+}
+
+func (r *MemoryRepository) EndMedicationSchedule(ctx context.Context, scheduleID string, endDate time.Time) (*models.MedicationSchedule, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	schedule, ok := r.db.MedicationSchedules.Rows[scheduleID]
+	if !ok {
+		return &models.MedicationSchedule{}, sql.ErrNoRows
+	}
+	schedule.EndDate, schedule.UpdatedAt = &endDate, r.db.Now()
+	r.db.MedicationSchedules.Rows[scheduleID] = schedule
+	return &schedule, nil
+}
+
+func (r *MemoryRepository) DeleteMedicationSchedule(ctx context.Context, scheduleID string) error {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	memdb.Delete(r.db, "medication_schedules", r.db.MedicationSchedules, scheduleID)
+	return nil
+}
+
+func (r *MemoryRepository) SaveDoseEvent(ctx context.Context, event *models.DoseEvent) error {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	if _, ok := r.db.MedicationSchedules.Rows[event.ScheduleID]; !ok {
+		return memdb.ForeignKeyViolation("dose_events", "dose_events_schedule_id_fkey")
+	}
+
+	now := r.db.Now()
+	for id, e := range r.db.DoseEvents.Rows {
+		if e.ScheduleID == event.ScheduleID && e.ScheduledFor.Equal(event.ScheduledFor) {
+			e.Status, e.TakenAt, e.Note, e.UpdatedAt = event.Status, event.TakenAt, event.Note, now
+			r.db.DoseEvents.Rows[id] = e
+			*event = e
+			return nil
+		}
+	}
+
+	event.ID = uuid.New().String()
+	event.CreatedAt, event.UpdatedAt = now, now
+	r.db.DoseEvents.Rows[event.ID] = *event
+	return nil
+}
+
+func (r *MemoryRepository) GetDoseEvents(ctx context.Context, patientID string, from, to time.Time) ([]models.DoseEvent, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	var events []models.DoseEvent
+	for _, e := range r.db.DoseEvents.Rows {
+		if e.PatientID == patientID && !e.ScheduledFor.Before(from) && e.ScheduledFor.Before(to) {
+			events = append(events, e)
+		}
+	}
+	slices.SortFunc(events, func(a, b models.DoseEvent) int {
+		return cmp.Or(a.ScheduledFor.Compare(b.ScheduledFor), cmp.Compare(a.ID, b.ID))
+	})
+	return events, nil
+}
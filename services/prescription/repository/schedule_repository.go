@@ -0,0 +1,127 @@
+package repository
+
+import (
+    "context"
+    "health-bar/shared/database"
+    "health-bar/shared/models"
+    "health-bar/shared/pagination"
+    "time"
+    "github.com/google/uuid"
+)
+
+const scheduleColumns = `id, patient_id, prescription_id, medication, dose, times, timezone, start_date, end_date,
+        notes, created_at, updated_at`
+
+const doseEventColumns = `id, schedule_id, patient_id, scheduled_for, status, taken_at, note, created_at, updated_at`
+
+// CreateMedicationSchedule creates a medication schedule
+func (r *PrescriptionRepository) CreateMedicationSchedule(ctx context.Context, schedule *models.MedicationSchedule) error {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    schedule.ID = uuid.New().String()
+
+    var endDate interface{}
+    if schedule.EndDate != nil {
+        endDate = pagination.Date(*schedule.EndDate)
+    }
+
+    query := `
+        INSERT INTO medication_schedules (id, patient_id, prescription_id, medication, dose, times, timezone,
+            start_date, end_date, notes)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8::date, $9::date, $10)
+        RETURNING ` + scheduleColumns
+
+    return database.Conn(ctx, r.db).QueryRowxContext(ctx, query,
+        schedule.ID, schedule.PatientID, schedule.PrescriptionID, schedule.Medication, schedule.Dose,
+        schedule.Times, schedule.Timezone, pagination.Date(schedule.StartDate), endDate, schedule.Notes,
+    ).StructScan(schedule)
+}
+
+// GetMedicationScheduleByID gets a medication schedule by ID
+func (r *PrescriptionRepository) GetMedicationScheduleByID(ctx context.Context, scheduleID string) (*models.MedicationSchedule, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    schedule := &models.MedicationSchedule{}
+    query := `SELECT ` + scheduleColumns + ` FROM medication_schedules WHERE id = $1`
+    err := database.Conn(ctx, r.db).GetContext(ctx, schedule, query, scheduleID)
+    return schedule, err
+}
+
+// ListMedicationSchedules gets all of a patient's medication schedules, oldest first
+func (r *PrescriptionRepository) ListMedicationSchedules(ctx context.Context, patientID string) ([]models.MedicationSchedule, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    var schedules []models.MedicationSchedule
+    query := `SELECT ` + scheduleColumns + ` FROM medication_schedules WHERE patient_id = $1 ORDER BY start_date, id`
+    err := database.Conn(ctx, r.db).SelectContext(ctx, &schedules, query, patientID)
+    return schedules, err
+}
+
+// EndMedicationSchedule sets the last day of a medication schedule
+func (r *PrescriptionRepository) EndMedicationSchedule(ctx context.Context, scheduleID string, endDate time.Time) (*models.MedicationSchedule, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    schedule := &models.MedicationSchedule{}
+    query := `
+        UPDATE medication_schedules
+        SET end_date = $2::date, updated_at = NOW()
+        WHERE id = $1
+        RETURNING ` + scheduleColumns
+    err := database.Conn(ctx, r.db).GetContext(ctx, schedule, query, scheduleID, pagination.Date(endDate))
+    return schedule, err
+}
+
+// DeleteMedicationSchedule deletes a medication schedule and its dose events
+func (r *PrescriptionRepository) DeleteMedicationSchedule(ctx context.Context, scheduleID string) error {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    query := `DELETE FROM medication_schedules WHERE id = $1`
+    _, err := database.Conn(ctx, r.db).ExecContext(ctx, query, scheduleID)
+    return err
+}
+
+// SaveDoseEvent records what became of a scheduled dose, replacing what was
+// logged for it before
+func (r *PrescriptionRepository) SaveDoseEvent(ctx context.Context, event *models.DoseEvent) error {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    event.ID = uuid.New().String()
+
+    var takenAt interface{}
+    if event.TakenAt != nil {
+        takenAt = pagination.Timestamp(*event.TakenAt)
+    }
+
+    query := `
+        INSERT INTO dose_events (id, schedule_id, patient_id, scheduled_for, status, taken_at, note)
+        VALUES ($1, $2, $3, $4::timestamp, $5, $6::timestamp, $7)
+        ON CONFLICT (schedule_id, scheduled_for) DO UPDATE
+        SET status = EXCLUDED.status, taken_at = EXCLUDED.taken_at, note = EXCLUDED.note, updated_at = NOW()
+        RETURNING ` + doseEventColumns
+
+    return database.Conn(ctx, r.db).QueryRowxContext(ctx, query,
+        event.ID, event.ScheduleID, event.PatientID, pagination.Timestamp(event.ScheduledFor), event.Status, takenAt, event.Note,
+    ).StructScan(event)
+}
+
+// GetDoseEvents gets a patient's dose events for doses scheduled in [from, to)
+func (r *PrescriptionRepository) GetDoseEvents(ctx context.Context, patientID string, from, to time.Time) ([]models.DoseEvent, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    var events []models.DoseEvent
+    query := `
+        SELECT ` + doseEventColumns + `
+        FROM dose_events
+        WHERE patient_id = $1 AND scheduled_for >= $2::timestamp AND scheduled_for < $3::timestamp
+        ORDER BY scheduled_for, id
+    `
+    err := database.Conn(ctx, r.db).SelectContext(ctx, &events, query, patientID, pagination.Timestamp(from), pagination.Timestamp(to))
+    return events, err
+}
@@ -0,0 +1,66 @@
+package repository
+
+import (
+	"context"
+	"health-bar/shared/models"
+	"time"
+)
+
+// Store is what PrescriptionHandler needs from persistence.
+// PrescriptionRepository is the Postgres implementation and
+// MemoryRepository the in-memory one.
+type Store interface {
+	WithTx(ctx context.Context, fn func(ctx context.Context) error) error
+	CreatePrescription(ctx context.Context, patientID string, prescription *models.Prescription) error
+	GetPrescriptionByID(ctx context.Context, prescriptionID string) (*models.Prescription, error)
+	GetPrescriptionsByPatientID(ctx context.Context, patientID string, page PrescriptionPage) ([]models.Prescription, string, error)
+	DeletePrescription(ctx context.Context, prescriptionID string) error
+	GetPatientIDByPrescriptionID(ctx context.Context, prescriptionID string) (string, error)
+	GetPatientProfileIDByUserID(ctx context.Context, userID string) (string, error)
+	CheckDoctorAccess(ctx context.Context, doctorUserID, patientProfileID string) (bool, error)
+	GetPatientName(ctx context.Context, patientProfileID string) (string, error)
+	GetDoctorUserIDsWithAccess(ctx context.Context, patientProfileID string) ([]string, error)
+	GetDoctorProfileByUserID(ctx context.Context, userID string) (*models.DoctorProfile, error)
+	// CreateEPrescription returns sql.ErrNoRows, inserting nothing, unless
+	// the patient grants the prescribing doctor access
+	CreateEPrescription(ctx context.Context, prescription *models.EPrescription) error
+	GetEPrescriptionByID(ctx context.Context, prescriptionID string) (*models.EPrescription, error)
+	GetEPrescriptionsByPatientID(ctx context.Context, patientID string, page EPrescriptionPage) ([]models.EPrescription, string, error)
+	GetEPrescriptionsByDoctorID(ctx context.Context, doctorID string, page EPrescriptionPage) ([]models.EPrescription, string, error)
+	CancelEPrescription(ctx context.Context, prescriptionID, reason string) (*models.EPrescription, error)
+	GetPatientAllergies(ctx context.Context, patientID string) ([]models.Allergy, error)
+	GetPatientMedicationNames(ctx context.Context, patientID string) ([]string, error)
+	CreateMedicationSchedule(ctx context.Context, schedule *models.MedicationSchedule) error
+	GetMedicationScheduleByID(ctx context.Context, scheduleID string) (*models.MedicationSchedule, error)
+	ListMedicationSchedules(ctx context.Context, patientID string) ([]models.MedicationSchedule, error)
+	EndMedicationSchedule(ctx context.Context, scheduleID string, endDate time.Time) (*models.MedicationSchedule, error)
+	DeleteMedicationSchedule(ctx context.Context, scheduleID string) error
+	SaveDoseEvent(ctx context.Context, event *models.DoseEvent) error
+	GetDoseEvents(ctx context.Context, patientID string, from, to time.Time) ([]models.DoseEvent, error)
+
+	// Messaging between patients and doctors
+	CheckAccessGrant(ctx context.Context, patientID, doctorID string) (bool, error)
+	OpenThread(ctx context.Context, patientID, doctorID string) (*models.MessageThread, error)
+	GetThreadByID(ctx context.Context, threadID string) (*models.MessageThread, error)
+	GetThreadsByPatientID(ctx context.Context, patientID string, page ThreadPage) ([]models.MessageThread, string, error)
+	GetThreadsByDoctorID(ctx context.Context, doctorID string, page ThreadPage) ([]models.MessageThread, string, error)
+	CreateMessage(ctx context.Context, message *models.Message) error
+	CreateMessageAttachment(ctx context.Context, attachment *models.MessageAttachment) error
+	GetMessages(ctx context.Context, threadID string, until time.Time, page MessagePage) ([]models.Message, string, error)
+	GetMessageAttachmentByID(ctx context.Context, attachmentID string) (*models.MessageAttachment, *models.Message, error)
+	MarkMessagesRead(ctx context.Context, threadID string, reader models.UserRole, until time.Time) (int64, error)
+
+	// Resumable uploads
+	CreateUpload(ctx context.Context, upload *models.Upload, ttl time.Duration) error
+	GetUploadByID(ctx context.Context, uploadID string) (*models.Upload, error)
+	AppendUploadChunk(ctx context.Context, chunk *models.UploadChunk, ttl time.Duration) (*models.Upload, error)
+	GetUploadChunks(ctx context.Context, uploadID string) ([]models.UploadChunk, error)
+	CompleteUpload(ctx context.Context, uploadID, prescriptionID string) error
+	DeleteUpload(ctx context.Context, uploadID string) error
+	GetExpiredUploads(ctx context.Context, limit int) ([]models.Upload, error)
+}
+
+var (
+	_ Store = (*PrescriptionRepository)(nil)
+	_ Store = (*MemoryRepository)(nil)
+)
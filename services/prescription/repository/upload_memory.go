@@ -0,0 +1,122 @@
+package repository
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"slices"
+	"time"
+
+	"health-bar/shared/memdb"
+	"health-bar/shared/models"
+
+	"github.com/google/uuid"
+)
+
+func (r *MemoryRepository) CreateUpload(ctx context.Context, upload *models.Upload, ttl time.Duration) error {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	if _, ok := r.db.PatientProfiles.Rows[upload.PatientID]; !ok {
+		return memdb.ForeignKeyViolation("uploads", "uploads_patient_id_fkey")
+	}
+
+	now := r.db.Now()
+	upload.ID = uuid.New().String()
+	upload.Offset, upload.PrescriptionID = 0, nil
+	upload.ExpiresAt, upload.CreatedAt, upload.UpdatedAt = now.Add(ttl), now, now
+	r.db.Uploads.Rows[upload.ID] = *upload
+	return nil
+}
+
+func (r *MemoryRepository) GetUploadByID(ctx context.Context, uploadID string) (*models.Upload, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	upload, ok := r.db.Uploads.Rows[uploadID]
+	if !ok {
+		return &models.Upload{}, sql.ErrNoRows
+	}
+	return &upload, nil
+}
+
+func (r *MemoryRepository) AppendUploadChunk(ctx context.Context, chunk *models.UploadChunk, ttl time.Duration) (*models.Upload, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	now := r.db.Now()
+	upload, ok := r.db.Uploads.Rows[chunk.UploadID]
+	if !ok || upload.Offset != chunk.Offset || upload.Offset+chunk.Size > upload.Length || !upload.ExpiresAt.After(now) {
+		return &models.Upload{}, sql.ErrNoRows
+	}
+
+	chunk.ID = uuid.New().String()
+	r.db.UploadChunks.Rows[chunk.ID] = *chunk
+	upload.Offset += chunk.Size
+	upload.ExpiresAt, upload.UpdatedAt = now.Add(ttl), now
+	r.db.Uploads.Rows[upload.ID] = upload
+	return &upload, nil
+}
+
+func (r *MemoryRepository) GetUploadChunks(ctx context.Context, uploadID string) ([]models.UploadChunk, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	chunks := []models.UploadChunk{}
+	for _, c := range r.db.UploadChunks.Rows {
+		if c.UploadID == uploadID {
+			chunks = append(chunks, c)
+		}
+	}
+	slices.SortFunc(chunks, func(a, b models.UploadChunk) int { return cmp.Compare(a.Offset, b.Offset) })
+	return chunks, nil
+}
+
+func (r *MemoryRepository) CompleteUpload(ctx context.Context, uploadID, prescriptionID string) error {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	upload, ok := r.db.Uploads.Rows[uploadID]
+	if !ok || upload.PrescriptionID != nil || !upload.Complete() {
+		return sql.ErrNoRows
+	}
+	if _, ok := r.db.Prescriptions.Rows[prescriptionID]; !ok {
+		return memdb.ForeignKeyViolation("uploads", "uploads_prescription_id_fkey")
+	}
+
+	upload.PrescriptionID = &prescriptionID
+	upload.UpdatedAt = r.db.Now()
+	r.db.Uploads.Rows[uploadID] = upload
+	for id, c := range r.db.UploadChunks.Rows {
+		if c.UploadID == uploadID {
+			delete(r.db.UploadChunks.Rows, id)
+		}
+	}
+	return nil
+}
+
+func (r *MemoryRepository) DeleteUpload(ctx context.Context, uploadID string) error {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	memdb.Delete(r.db, "uploads", r.db.Uploads, uploadID)
+	return nil
+}
+
+func (r *MemoryRepository) GetExpiredUploads(ctx context.Context, limit int) ([]models.Upload, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	now := r.db.Now()
+	uploads := []models.Upload{}
+	for _, u := range r.db.Uploads.Rows {
+		if u.PrescriptionID == nil && !u.ExpiresAt.After(now) {
+			uploads = append(uploads, u)
+		}
+	}
+	slices.SortFunc(uploads, func(a, b models.Upload) int { return a.ExpiresAt.Compare(b.ExpiresAt) })
+	if len(uploads) > limit {
+		uploads = uploads[:limit]
+	}
+	return uploads, nil
+}
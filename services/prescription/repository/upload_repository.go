@@ -0,0 +1,135 @@
+package repository
+
+import (
+    "context"
+    "health-bar/shared/database"
+    "health-bar/shared/models"
+    "time"
+    "github.com/google/uuid"
+)
+
+const uploadColumns = `id, patient_id, file_name, file_type, upload_length, upload_offset, prescription_id, expires_at, created_at, updated_at`
+
+// CreateUpload starts a resumable upload that expires after ttl without
+// progress
+func (r *PrescriptionRepository) CreateUpload(ctx context.Context, upload *models.Upload, ttl time.Duration) error {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    upload.ID = uuid.New().String()
+
+    query := `
+        INSERT INTO uploads (id, patient_id, file_name, file_type, upload_length, expires_at)
+        VALUES ($1, $2, $3, $4, $5, NOW() + $6::float8 * INTERVAL '1 second')
+        RETURNING ` + uploadColumns
+
+    return database.Conn(ctx, r.db).QueryRowxContext(ctx, query,
+        upload.ID, upload.PatientID, upload.FileName, upload.FileType, upload.Length, ttl.Seconds(),
+    ).StructScan(upload)
+}
+
+// GetUploadByID gets an upload by ID, expired or not
+func (r *PrescriptionRepository) GetUploadByID(ctx context.Context, uploadID string) (*models.Upload, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    upload := &models.Upload{}
+    query := `SELECT ` + uploadColumns + ` FROM uploads WHERE id = $1`
+    err := database.Conn(ctx, r.db).GetContext(ctx, upload, query, uploadID)
+    return upload, err
+}
+
+// AppendUploadChunk records a stored chunk and moves the upload's offset past
+// it, extending the upload's life to ttl from now. It returns sql.ErrNoRows
+// when the upload is no longer at the chunk's offset, or has expired.
+func (r *PrescriptionRepository) AppendUploadChunk(ctx context.Context, chunk *models.UploadChunk, ttl time.Duration) (*models.Upload, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    chunk.ID = uuid.New().String()
+
+    // Comparing the offset in the same statement keeps two requests from
+    // both writing at it
+    upload := &models.Upload{}
+    query := `
+        WITH moved AS (
+            UPDATE uploads
+            SET upload_offset = upload_offset + $4, expires_at = NOW() + $6::float8 * INTERVAL '1 second', updated_at = NOW()
+            WHERE id = $2 AND upload_offset = $3 AND upload_offset + $4 <= upload_length AND expires_at > NOW()
+            RETURNING ` + uploadColumns + `
+        ), chunk AS (
+            INSERT INTO upload_chunks (id, upload_id, chunk_offset, size, blob_key)
+            SELECT $1::uuid, id, $3, $4, $5 FROM moved
+        )
+        SELECT ` + uploadColumns + ` FROM moved`
+
+    err := database.Conn(ctx, r.db).QueryRowxContext(ctx, query,
+        chunk.ID, chunk.UploadID, chunk.Offset, chunk.Size, chunk.BlobKey, ttl.Seconds(),
+    ).StructScan(upload)
+    return upload, err
+}
+
+// GetUploadChunks lists an upload's chunks in order
+func (r *PrescriptionRepository) GetUploadChunks(ctx context.Context, uploadID string) ([]models.UploadChunk, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    chunks := []models.UploadChunk{}
+    query := `
+        SELECT id, upload_id, chunk_offset, size, blob_key
+        FROM upload_chunks
+        WHERE upload_id = $1
+        ORDER BY chunk_offset
+    `
+    err := database.Conn(ctx, r.db).SelectContext(ctx, &chunks, query, uploadID)
+    return chunks, err
+}
+
+// CompleteUpload records the prescription an upload became and drops its
+// chunks. It returns sql.ErrNoRows when the upload was already completed.
+func (r *PrescriptionRepository) CompleteUpload(ctx context.Context, uploadID, prescriptionID string) error {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    query := `
+        WITH completed AS (
+            UPDATE uploads SET prescription_id = $2, updated_at = NOW()
+            WHERE id = $1 AND prescription_id IS NULL AND upload_offset = upload_length
+            RETURNING id
+        ), chunks AS (
+            DELETE FROM upload_chunks WHERE upload_id IN (SELECT id FROM completed)
+        )
+        SELECT id FROM completed`
+
+    var id string
+    return database.Conn(ctx, r.db).GetContext(ctx, &id, query, uploadID, prescriptionID)
+}
+
+// DeleteUpload deletes an upload and its chunk records
+func (r *PrescriptionRepository) DeleteUpload(ctx context.Context, uploadID string) error {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    query := `DELETE FROM uploads WHERE id = $1`
+    _, err := database.Conn(ctx, r.db).ExecContext(ctx, query, uploadID)
+    return err
+}
+
+// GetExpiredUploads lists up to limit unfinished uploads whose expiry has
+// passed, oldest first. Completed uploads stay until their prescription is
+// deleted, so clients can still ask how they ended.
+func (r *PrescriptionRepository) GetExpiredUploads(ctx context.Context, limit int) ([]models.Upload, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    uploads := []models.Upload{}
+    query := `
+        SELECT ` + uploadColumns + `
+        FROM uploads
+        WHERE expires_at <= NOW() AND prescription_id IS NULL
+        ORDER BY expires_at
+        LIMIT $1
+    `
+    err := database.Conn(ctx, r.db).SelectContext(ctx, &uploads, query, limit)
+    return uploads, err
+}
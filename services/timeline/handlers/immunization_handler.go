@@ -0,0 +1,313 @@
+package handlers
+
+import (
+    "database/sql"
+    "encoding/json"
+    "fmt"
+    "health-bar/shared/apperrors"
+    "health-bar/shared/immunization"
+    "health-bar/shared/models"
+    "health-bar/shared/pagination"
+    "health-bar/shared/utils"
+    "health-bar/services/timeline/repository"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+)
+
+const (
+    defaultDueWithinDays = 90
+    maxDueWithinDays     = 730
+)
+
+type CreateImmunizationRequest struct {
+    Vaccine        string `json:"vaccine"`         // Schedule code such as "mmr", or any code for other vaccines
+    VaccineName    string `json:"vaccine_name"`    // Required for vaccines outside the schedule
+    DoseNumber     *int   `json:"dose_number"`     // Optional, defaults to the dose after the last recorded one
+    LotNumber      string `json:"lot_number"`
+    AdministeredOn string `json:"administered_on"` // Format: YYYY-MM-DD
+    Facility       string `json:"facility"`
+    Notes          string `json:"notes"`
+}
+
+// DueImmunizationsResponse lists the next dose of each vaccine that is
+// overdue, due or due within WithinDays of AsOf
+type DueImmunizationsResponse struct {
+    Schedule   string                 `json:"schedule"`
+    AsOf       string                 `json:"as_of"`
+    WithinDays int                    `json:"within_days"`
+    Doses      []immunization.DueDose `json:"doses"`
+}
+
+// UseSchedule replaces the built-in immunization schedule
+func (h *TimelineHandler) UseSchedule(schedule *immunization.Schedule) {
+    h.schedule = schedule
+}
+
+func (req CreateImmunizationRequest) immunization(schedule *immunization.Schedule, dob, today time.Time) (*models.Immunization, error) {
+    code := strings.ToLower(strings.TrimSpace(req.Vaccine))
+    name := strings.TrimSpace(req.VaccineName)
+    if code == "" {
+        return nil, apperrors.New(apperrors.CodeValidation, "vaccine is required")
+    }
+    if vaccine, ok := schedule.Lookup(code); ok && name == "" {
+        name = vaccine.Name
+    }
+    if name == "" {
+        return nil, apperrors.New(apperrors.CodeValidation, fmt.Sprintf("vaccine_name is required for %s, which is not in the schedule", code))
+    }
+    if len(code) > 50 || len(name) > 255 {
+        return nil, apperrors.New(apperrors.CodeValidation, "vaccine or vaccine_name is too long")
+    }
+    if req.DoseNumber != nil && (*req.DoseNumber < 1 || *req.DoseNumber > 99) {
+        return nil, apperrors.New(apperrors.CodeValidation, "dose_number must be between 1 and 99")
+    }
+
+    lot := strings.TrimSpace(req.LotNumber)
+    facility := strings.TrimSpace(req.Facility)
+    if len(lot) > 50 || len(facility) > 255 {
+        return nil, apperrors.New(apperrors.CodeValidation, "lot_number or facility is too long")
+    }
+
+    administeredOn, err := time.Parse("2006-01-02", req.AdministeredOn)
+    if err != nil {
+        return nil, apperrors.New(apperrors.CodeValidation, "Invalid administered_on. Use YYYY-MM-DD")
+    }
+    if administeredOn.After(today) {
+        return nil, apperrors.New(apperrors.CodeValidation, "administered_on must not be in the future")
+    }
+    if administeredOn.Before(dob) {
+        return nil, apperrors.New(apperrors.CodeValidation, "administered_on must not be before the date of birth")
+    }
+
+    imm := &models.Immunization{
+        Vaccine:        code,
+        VaccineName:    name,
+        LotNumber:      lot,
+        AdministeredOn: administeredOn,
+        Facility:       facility,
+        Notes:          req.Notes,
+    }
+    if req.DoseNumber != nil {
+        imm.DoseNumber = *req.DoseNumber
+    }
+    return imm, nil
+}
+
+// CreateImmunization records a vaccine dose the current patient received
+func (h *TimelineHandler) CreateImmunization(w http.ResponseWriter, r *http.Request) {
+    userID := r.Header.Get("X-User-ID")
+    userRole := r.Header.Get("X-User-Role")
+
+    if userID == "" {
+        utils.SendError(w, http.StatusUnauthorized, "Unauthorized")
+        return
+    }
+
+    if userRole != "patient" {
+        utils.SendError(w, http.StatusForbidden, "Only patients can record immunizations")
+        return
+    }
+
+    patientProfileID, err := h.repo.GetPatientProfileIDByUserID(r.Context(), userID)
+    if err != nil {
+        utils.SendErrorCode(w, apperrors.CodeProfileNotFound, "Patient profile not found")
+        return
+    }
+
+    var req CreateImmunizationRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        utils.SendError(w, http.StatusBadRequest, "Invalid request body")
+        return
+    }
+
+    profile, err := h.repo.GetPatientProfile(r.Context(), patientProfileID)
+    if err != nil {
+        utils.SendAppError(w, err, "Failed to record immunization")
+        return
+    }
+
+    imm, err := req.immunization(h.schedule, profile.DateOfBirth, time.Now().UTC())
+    if err != nil {
+        utils.SendAppError(w, err, "Invalid immunization")
+        return
+    }
+
+    if imm.DoseNumber == 0 {
+        given, err := h.repo.ListImmunizations(r.Context(), patientProfileID)
+        if err != nil {
+            utils.SendAppError(w, err, "Failed to record immunization")
+            return
+        }
+        for _, g := range given {
+            if g.Vaccine == imm.Vaccine {
+                imm.DoseNumber = max(imm.DoseNumber, g.DoseNumber)
+            }
+        }
+        imm.DoseNumber++
+    }
+
+    if err := h.repo.CreateImmunization(r.Context(), patientProfileID, imm); err != nil {
+        if apperrors.IsUniqueViolation(err) {
+            utils.SendErrorCode(w, apperrors.CodeConflict, fmt.Sprintf("Dose %d of %s is already recorded", imm.DoseNumber, imm.Vaccine))
+            return
+        }
+        utils.SendAppError(w, err, "Failed to record immunization")
+        return
+    }
+
+    utils.SendSuccess(w, http.StatusCreated, "Immunization recorded", imm)
+}
+
+// GetMyImmunizations lists the current patient's vaccine doses
+func (h *TimelineHandler) GetMyImmunizations(w http.ResponseWriter, r *http.Request) {
+    userID := r.Header.Get("X-User-ID")
+    userRole := r.Header.Get("X-User-Role")
+
+    if userID == "" {
+        utils.SendError(w, http.StatusUnauthorized, "Unauthorized")
+        return
+    }
+
+    if userRole != "patient" {
+        utils.SendError(w, http.StatusForbidden, "Only patients can view their immunizations")
+        return
+    }
+
+    patientProfileID, err := h.repo.GetPatientProfileIDByUserID(r.Context(), userID)
+    if err != nil {
+        utils.SendErrorCode(w, apperrors.CodeProfileNotFound, "Patient profile not found")
+        return
+    }
+
+    h.listImmunizations(w, r, patientProfileID)
+}
+
+// GetPatientImmunizations lists a patient's vaccine doses (for doctors with access)
+func (h *TimelineHandler) GetPatientImmunizations(w http.ResponseWriter, r *http.Request) {
+    patientProfileID, ok := h.readablePatient(w, r)
+    if !ok {
+        return
+    }
+
+    h.listImmunizations(w, r, patientProfileID)
+}
+
+func (h *TimelineHandler) listImmunizations(w http.ResponseWriter, r *http.Request, patientProfileID string) {
+    page, err := pagination.Parse(r.URL.Query(), repository.ImmunizationPages)
+    if err != nil {
+        utils.SendAppError(w, err, "Invalid pagination parameters")
+        return
+    }
+    if vaccine, ok := page.Filters["vaccine"]; ok {
+        page.Filters["vaccine"] = strings.ToLower(vaccine)
+    }
+
+    imms, next, err := h.repo.GetImmunizationsByPatientID(r.Context(), patientProfileID, page)
+    if err != nil {
+        utils.SendAppError(w, err, "Failed to retrieve immunizations")
+        return
+    }
+
+    if imms == nil {
+        imms = []models.Immunization{}
+    }
+
+    utils.SendPage(w, http.StatusOK, "Immunizations retrieved", imms, next)
+}
+
+// GetDueImmunizations lists the doses the patient is overdue for, due for
+// today or due for within the next within days (90 by default). Patients
+// see their own; doctors name a patient who granted them access.
+func (h *TimelineHandler) GetDueImmunizations(w http.ResponseWriter, r *http.Request) {
+    patientProfileID, ok := h.readablePatient(w, r)
+    if !ok {
+        return
+    }
+
+    within := defaultDueWithinDays
+    if raw := r.URL.Query().Get("within"); raw != "" {
+        n, err := strconv.Atoi(raw)
+        if err != nil || n < 0 || n > maxDueWithinDays {
+            utils.SendErrorCode(w, apperrors.CodeValidation, fmt.Sprintf("within must be between 0 and %d days", maxDueWithinDays))
+            return
+        }
+        within = n
+    }
+
+    profile, err := h.repo.GetPatientProfile(r.Context(), patientProfileID)
+    if err != nil {
+        if err == sql.ErrNoRows {
+            utils.SendErrorCode(w, apperrors.CodeProfileNotFound, "Patient profile not found")
+            return
+        }
+        utils.SendAppError(w, err, "Failed to compute due immunizations")
+        return
+    }
+
+    given, err := h.repo.ListImmunizations(r.Context(), patientProfileID)
+    if err != nil {
+        utils.SendAppError(w, err, "Failed to compute due immunizations")
+        return
+    }
+
+    today := time.Now().UTC()
+    utils.SendSuccess(w, http.StatusOK, "Due immunizations computed", DueImmunizationsResponse{
+        Schedule:   h.schedule.Name,
+        AsOf:       pagination.Date(today),
+        WithinDays: within,
+        Doses:      h.schedule.Due(profile.DateOfBirth, given, today, within),
+    })
+}
+
+// GetImmunizationSchedule returns the schedule due doses are computed from
+func (h *TimelineHandler) GetImmunizationSchedule(w http.ResponseWriter, r *http.Request) {
+    utils.SendSuccess(w, http.StatusOK, "Immunization schedule retrieved", h.schedule)
+}
+
+// DeleteImmunization deletes one of the current patient's vaccine doses
+func (h *TimelineHandler) DeleteImmunization(w http.ResponseWriter, r *http.Request) {
+    userID := r.Header.Get("X-User-ID")
+    userRole := r.Header.Get("X-User-Role")
+
+    if userID == "" {
+        utils.SendError(w, http.StatusUnauthorized, "Unauthorized")
+        return
+    }
+
+    if userRole != "patient" {
+        utils.SendError(w, http.StatusForbidden, "Only patients can delete immunizations")
+        return
+    }
+
+    immunizationID := r.URL.Query().Get("id")
+    if immunizationID == "" {
+        utils.SendErrorCode(w, apperrors.CodeValidation, "Immunization ID is required")
+        return
+    }
+
+    patientProfileID, err := h.repo.GetPatientProfileIDByUserID(r.Context(), userID)
+    if err != nil {
+        utils.SendErrorCode(w, apperrors.CodeProfileNotFound, "Patient profile not found")
+        return
+    }
+
+    // Another patient's dose is reported as missing rather than forbidden
+    imm, err := h.repo.GetImmunizationByID(r.Context(), immunizationID)
+    if err != nil || imm.PatientID != patientProfileID {
+        if err != nil && err != sql.ErrNoRows {
+            utils.SendAppError(w, err, "Failed to delete immunization")
+            return
+        }
+        utils.SendError(w, http.StatusNotFound, "Immunization not found")
+        return
+    }
+
+    if err := h.repo.DeleteImmunization(r.Context(), immunizationID); err != nil {
+        utils.SendAppError(w, err, "Failed to delete immunization")
+        return
+    }
+
+    utils.SendSuccess(w, http.StatusOK, "Immunization deleted", nil)
+}
@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+
+	"health-bar/shared/apperrors"
+	"health-bar/shared/immunization"
+	"health-bar/shared/models"
+	"health-bar/shared/testutil"
+)
+
+func recordImmunization(t *testing.T, h *TimelineHandler, userID string, req CreateImmunizationRequest) models.Immunization {
+	t.Helper()
+
+	r := testutil.NewRequest(t, http.MethodPost, "/api/timeline/immunizations", req, userID, "patient")
+	rec, resp := testutil.Serve(t, h.CreateImmunization, r)
+	testutil.ExpectStatus(t, rec, http.StatusCreated)
+
+	var created models.Immunization
+	testutil.DecodeData(t, resp, &created)
+	return created
+}
+
+func TestCreateImmunization(t *testing.T) {
+	h, db := newTestHandler()
+	patient := db.AddPatient("p@test.com", "Pat")
+
+	first := recordImmunization(t, h, patient.UserID, CreateImmunizationRequest{Vaccine: "MMR", AdministeredOn: "1991-01-15", LotNumber: "A12"})
+	if first.Vaccine != "mmr" || first.VaccineName != "Measles, mumps and rubella" || first.DoseNumber != 1 {
+		t.Fatalf("first = %+v", first)
+	}
+	// The dose number defaults to the one after the last recorded
+	second := recordImmunization(t, h, patient.UserID, CreateImmunizationRequest{Vaccine: "mmr", AdministeredOn: "1994-02-01"})
+	if second.DoseNumber != 2 {
+		t.Fatalf("second dose number = %d", second.DoseNumber)
+	}
+
+	dose := 2
+	for name, tc := range map[string]struct {
+		req    CreateImmunizationRequest
+		status int
+		code   apperrors.Code
+	}{
+		"repeated dose":         {CreateImmunizationRequest{Vaccine: "mmr", DoseNumber: &dose, AdministeredOn: "1995-01-01"}, http.StatusConflict, apperrors.CodeConflict},
+		"unnamed other vaccine": {CreateImmunizationRequest{Vaccine: "yellow_fever", AdministeredOn: "2015-01-01"}, http.StatusBadRequest, apperrors.CodeValidation},
+		"before birth":          {CreateImmunizationRequest{Vaccine: "hepb", AdministeredOn: "1989-12-31"}, http.StatusBadRequest, apperrors.CodeValidation},
+		"future":                {CreateImmunizationRequest{Vaccine: "hepb", AdministeredOn: "2999-01-01"}, http.StatusBadRequest, apperrors.CodeValidation},
+		"doctor cannot record":  {CreateImmunizationRequest{Vaccine: "hepb", AdministeredOn: "2000-01-01"}, http.StatusForbidden, ""},
+	} {
+		role := "patient"
+		if tc.status == http.StatusForbidden {
+			role = "doctor"
+		}
+		r := testutil.NewRequest(t, http.MethodPost, "/api/timeline/immunizations", tc.req, patient.UserID, role)
+		rec, resp := testutil.Serve(t, h.CreateImmunization, r)
+		if rec.Code != tc.status || (tc.code != "" && resp.Code != tc.code) {
+			t.Errorf("%s: status %d code %q", name, rec.Code, resp.Code)
+		}
+	}
+
+	recordImmunization(t, h, patient.UserID, CreateImmunizationRequest{Vaccine: "yellow_fever", VaccineName: "Yellow fever", AdministeredOn: "2015-01-01"})
+	if n := len(db.Immunizations.Rows); n != 3 {
+		t.Fatalf("stored %d immunizations, want 3", n)
+	}
+}
+
+func TestDueImmunizationsAccess(t *testing.T) {
+	h, db := newTestHandler()
+	patient := db.AddPatient("p@test.com", "Pat")
+	doctor := db.AddDoctor("d@test.com", "Doc")
+	recordImmunization(t, h, patient.UserID, CreateImmunizationRequest{Vaccine: "mmr", AdministeredOn: "1991-01-15"})
+	recordImmunization(t, h, patient.UserID, CreateImmunizationRequest{Vaccine: "mmr", AdministeredOn: "1994-02-01"})
+
+	target := "/api/timeline/immunizations/due?patient_id=" + patient.ID
+	r := testutil.NewRequest(t, http.MethodGet, target, nil, doctor.UserID, "doctor")
+	rec, resp := testutil.Serve(t, h.GetDueImmunizations, r)
+	testutil.ExpectStatus(t, rec, http.StatusForbidden)
+	testutil.ExpectCode(t, resp, apperrors.CodeAccessDenied)
+
+	db.Grant(patient.ID, doctor.ID)
+	r = testutil.NewRequest(t, http.MethodGet, target, nil, doctor.UserID, "doctor")
+	rec, resp = testutil.Serve(t, h.GetDueImmunizations, r)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+
+	var due DueImmunizationsResponse
+	testutil.DecodeData(t, resp, &due)
+	if due.WithinDays != 90 || len(due.Doses) == 0 {
+		t.Fatalf("due = %+v", due)
+	}
+	for _, d := range due.Doses {
+		if d.Vaccine == "mmr" {
+			t.Fatalf("mmr series is complete: %+v", d)
+		}
+		if d.Vaccine == "hepb" && d.Status != immunization.Overdue {
+			t.Fatalf("hepb = %+v", d)
+		}
+	}
+
+	r = testutil.NewRequest(t, http.MethodGet, "/api/timeline/immunizations/due?within=1000", nil, patient.UserID, "patient")
+	rec, resp = testutil.Serve(t, h.GetDueImmunizations, r)
+	testutil.ExpectStatus(t, rec, http.StatusBadRequest)
+	testutil.ExpectCode(t, resp, apperrors.CodeValidation)
+}
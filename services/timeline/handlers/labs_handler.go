@@ -0,0 +1,417 @@
+package handlers
+
+import (
+    "database/sql"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "health-bar/shared/apperrors"
+    "health-bar/shared/labs"
+    "health-bar/shared/models"
+    "health-bar/shared/pagination"
+    "health-bar/shared/utils"
+    "health-bar/services/timeline/repository"
+    "net/http"
+    "strings"
+    "time"
+)
+
+const (
+    // MaxLabResults is the most results one panel may carry
+    MaxLabResults = 100
+    maxLabBody    = 1 << 20
+)
+
+type LabResultRequest struct {
+    Analyte string   `json:"analyte"` // Catalog code such as "hemoglobin", or any code for other tests
+    Name    string   `json:"name"`    // Optional for catalogued analytes
+    Value   *float64 `json:"value"`
+    Unit    string   `json:"unit"`     // Optional for catalogued analytes, defaults to the catalog unit
+    RefLow  *float64 `json:"ref_low"`  // Range printed on the report, in unit. Defaults to the catalog range
+    RefHigh *float64 `json:"ref_high"` // for the patient's sex and age
+}
+
+type CreateLabPanelRequest struct {
+    Name        string             `json:"name"`
+    LabName     string             `json:"lab_name"`
+    CollectedAt string             `json:"collected_at"` // RFC 3339 timestamp or YYYY-MM-DD
+    VisitID     *string            `json:"visit_id"`     // Optional visit the tests were ordered on
+    DocumentID  *string            `json:"document_id"`  // Optional uploaded report
+    Notes       string             `json:"notes"`
+    Results     []LabResultRequest `json:"results"`
+}
+
+// LabTrendResponse is one analyte's results over time with the change
+// between the last two
+type LabTrendResponse struct {
+    Analyte   string             `json:"analyte"`
+    Name      string             `json:"name"`
+    Unit      string             `json:"unit"`
+    Results   []models.LabResult `json:"results"`
+    Change    *float64           `json:"change,omitempty"`
+    Direction labs.Direction     `json:"direction,omitempty"`
+}
+
+func (req CreateLabPanelRequest) panel(now time.Time) (*models.LabPanel, error) {
+    name := strings.TrimSpace(req.Name)
+    if name == "" {
+        return nil, apperrors.New(apperrors.CodeValidation, "name is required")
+    }
+    if req.CollectedAt == "" {
+        return nil, apperrors.New(apperrors.CodeValidation, "collected_at is required")
+    }
+    collectedAt, err := time.Parse(time.RFC3339Nano, req.CollectedAt)
+    if err != nil {
+        if collectedAt, err = time.Parse("2006-01-02", req.CollectedAt); err != nil {
+            return nil, apperrors.New(apperrors.CodeValidation, "Invalid collected_at. Use an RFC 3339 timestamp or YYYY-MM-DD")
+        }
+    }
+    collectedAt = collectedAt.UTC().Truncate(time.Microsecond)
+    if collectedAt.After(now.Add(maxClockSkew)) {
+        return nil, apperrors.New(apperrors.CodeValidation, "collected_at must not be in the future")
+    }
+    if len(req.Results) == 0 || len(req.Results) > MaxLabResults {
+        return nil, apperrors.New(apperrors.CodeValidation, fmt.Sprintf("Between 1 and %d results are required", MaxLabResults))
+    }
+
+    return &models.LabPanel{
+        VisitID:     emptyToNil(req.VisitID),
+        DocumentID:  emptyToNil(req.DocumentID),
+        Name:        name,
+        LabName:     strings.TrimSpace(req.LabName),
+        CollectedAt: collectedAt,
+        Notes:       req.Notes,
+    }, nil
+}
+
+// CreateLabPanel records a panel of lab results. Each result is converted to
+// the catalog unit and flagged against its reference range; one invalid
+// result rejects the panel.
+func (h *TimelineHandler) CreateLabPanel(w http.ResponseWriter, r *http.Request) {
+    userID := r.Header.Get("X-User-ID")
+    userRole := r.Header.Get("X-User-Role")
+
+    if userID == "" {
+        utils.SendError(w, http.StatusUnauthorized, "Unauthorized")
+        return
+    }
+
+    if userRole != "patient" {
+        utils.SendError(w, http.StatusForbidden, "Only patients can record lab results")
+        return
+    }
+
+    patientProfileID, err := h.repo.GetPatientProfileIDByUserID(r.Context(), userID)
+    if err != nil {
+        utils.SendErrorCode(w, apperrors.CodeProfileNotFound, "Patient profile not found")
+        return
+    }
+
+    var req CreateLabPanelRequest
+    if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxLabBody)).Decode(&req); err != nil {
+        utils.SendError(w, http.StatusBadRequest, "Invalid request body")
+        return
+    }
+
+    panel, err := req.panel(time.Now().UTC())
+    if err != nil {
+        utils.SendAppError(w, err, "Invalid lab panel")
+        return
+    }
+
+    // Linked records must be the patient's own
+    if panel.VisitID != nil {
+        owner, err := h.repo.GetPatientIDByVisitID(r.Context(), *panel.VisitID)
+        if err != nil || owner != patientProfileID {
+            if err != nil && err != sql.ErrNoRows {
+                utils.SendAppError(w, err, "Failed to record lab results")
+                return
+            }
+            utils.SendErrorCode(w, apperrors.CodeValidation, "visit_id does not match one of your visits")
+            return
+        }
+    }
+    if panel.DocumentID != nil {
+        owner, err := h.repo.GetDocumentPatientID(r.Context(), *panel.DocumentID)
+        if err != nil || owner != patientProfileID {
+            if err != nil && err != sql.ErrNoRows {
+                utils.SendAppError(w, err, "Failed to record lab results")
+                return
+            }
+            utils.SendErrorCode(w, apperrors.CodeValidation, "document_id does not match one of your uploaded documents")
+            return
+        }
+    }
+
+    profile, err := h.repo.GetPatientProfile(r.Context(), patientProfileID)
+    if err != nil {
+        utils.SendAppError(w, err, "Failed to record lab results")
+        return
+    }
+
+    seen := map[string]int{}
+    panel.Results = make([]models.LabResult, len(req.Results))
+    for i, item := range req.Results {
+        if item.Value == nil {
+            utils.SendErrorCode(w, apperrors.CodeValidation, fmt.Sprintf("results[%d]: value is required", i))
+            return
+        }
+        result := models.LabResult{
+            Analyte:     item.Analyte,
+            Name:        item.Name,
+            Value:       *item.Value,
+            RefLow:      item.RefLow,
+            RefHigh:     item.RefHigh,
+            CollectedAt: panel.CollectedAt,
+        }
+        if err := labs.Interpret(&result, item.Unit, *profile); err != nil {
+            message := err.Error()
+            var appErr *apperrors.Error
+            if errors.As(err, &appErr) {
+                message = appErr.Message
+            }
+            utils.SendErrorCode(w, apperrors.CodeValidation, fmt.Sprintf("results[%d]: %s", i, message))
+            return
+        }
+        if len(result.Analyte) > 50 || len(result.Unit) > 20 || len(result.Name) > 255 {
+            utils.SendErrorCode(w, apperrors.CodeValidation, fmt.Sprintf("results[%d]: analyte, unit or name is too long", i))
+            return
+        }
+        if j, ok := seen[result.Analyte]; ok {
+            utils.SendErrorCode(w, apperrors.CodeValidation, fmt.Sprintf("results[%d]: %s repeats results[%d]", i, result.Analyte, j))
+            return
+        }
+        seen[result.Analyte] = i
+        panel.Results[i] = result
+    }
+
+    if err := h.repo.CreateLabPanel(r.Context(), patientProfileID, panel); err != nil {
+        utils.SendAppError(w, err, "Failed to record lab results")
+        return
+    }
+
+    utils.SendSuccess(w, http.StatusCreated, "Lab results recorded", panel)
+}
+
+// GetMyLabPanels lists the current patient's lab panels
+func (h *TimelineHandler) GetMyLabPanels(w http.ResponseWriter, r *http.Request) {
+    userID := r.Header.Get("X-User-ID")
+    userRole := r.Header.Get("X-User-Role")
+
+    if userID == "" {
+        utils.SendError(w, http.StatusUnauthorized, "Unauthorized")
+        return
+    }
+
+    if userRole != "patient" {
+        utils.SendError(w, http.StatusForbidden, "Only patients can view their lab results")
+        return
+    }
+
+    patientProfileID, err := h.repo.GetPatientProfileIDByUserID(r.Context(), userID)
+    if err != nil {
+        utils.SendErrorCode(w, apperrors.CodeProfileNotFound, "Patient profile not found")
+        return
+    }
+
+    h.listLabPanels(w, r, patientProfileID)
+}
+
+// GetPatientLabPanels lists a patient's lab panels (for doctors with access)
+func (h *TimelineHandler) GetPatientLabPanels(w http.ResponseWriter, r *http.Request) {
+    patientProfileID, ok := h.readablePatient(w, r)
+    if !ok {
+        return
+    }
+
+    h.listLabPanels(w, r, patientProfileID)
+}
+
+func (h *TimelineHandler) listLabPanels(w http.ResponseWriter, r *http.Request, patientProfileID string) {
+    page, err := pagination.Parse(r.URL.Query(), repository.LabPanelPages)
+    if err != nil {
+        utils.SendAppError(w, err, "Invalid pagination parameters")
+        return
+    }
+    if abnormal, ok := page.Filters["abnormal"]; ok && abnormal != "true" && abnormal != "false" {
+        utils.SendErrorCode(w, apperrors.CodeValidation, "abnormal must be true or false")
+        return
+    }
+
+    panels, next, err := h.repo.GetLabPanelsByPatientID(r.Context(), patientProfileID, page)
+    if err != nil {
+        utils.SendAppError(w, err, "Failed to retrieve lab results")
+        return
+    }
+
+    if panels == nil {
+        panels = []models.LabPanel{}
+    }
+
+    utils.SendPage(w, http.StatusOK, "Lab results retrieved", panels, next)
+}
+
+// GetLabPanel gets one lab panel with its results
+func (h *TimelineHandler) GetLabPanel(w http.ResponseWriter, r *http.Request) {
+    userID := r.Header.Get("X-User-ID")
+    userRole := r.Header.Get("X-User-Role")
+
+    if userID == "" {
+        utils.SendError(w, http.StatusUnauthorized, "Unauthorized")
+        return
+    }
+
+    panelID := r.URL.Query().Get("id")
+    if panelID == "" {
+        utils.SendErrorCode(w, apperrors.CodeValidation, "Panel ID is required")
+        return
+    }
+
+    panel, err := h.repo.GetLabPanel(r.Context(), panelID)
+    if err != nil {
+        if err == sql.ErrNoRows {
+            utils.SendError(w, http.StatusNotFound, "Lab panel not found")
+            return
+        }
+        utils.SendAppError(w, err, "Failed to retrieve lab panel")
+        return
+    }
+
+    // Check if user has access
+    if userRole == "patient" {
+        patientProfileID, err := h.repo.GetPatientProfileIDByUserID(r.Context(), userID)
+        if err != nil || panel.PatientID != patientProfileID {
+            utils.SendErrorCode(w, apperrors.CodeAccessDenied, "Access denied")
+            return
+        }
+    } else if userRole == "doctor" {
+        hasAccess, err := h.repo.CheckDoctorAccess(r.Context(), userID, panel.PatientID)
+        if err != nil || !hasAccess {
+            utils.SendErrorCode(w, apperrors.CodeAccessDenied, "Access denied")
+            return
+        }
+    } else {
+        utils.SendErrorCode(w, apperrors.CodeAccessDenied, "Access denied")
+        return
+    }
+
+    utils.SendSuccess(w, http.StatusOK, "Lab panel retrieved", panel)
+}
+
+// GetLabTrend lists one analyte's results oldest first, between the from and
+// to dates when given. Patients see their own; doctors name a patient who
+// granted them access.
+func (h *TimelineHandler) GetLabTrend(w http.ResponseWriter, r *http.Request) {
+    patientProfileID, ok := h.readablePatient(w, r)
+    if !ok {
+        return
+    }
+
+    q := r.URL.Query()
+    analyte := strings.ToLower(strings.TrimSpace(q.Get("analyte")))
+    if analyte == "" {
+        utils.SendErrorCode(w, apperrors.CodeValidation, "analyte is required")
+        return
+    }
+
+    var from time.Time
+    to := time.Now().UTC().Truncate(24 * time.Hour)
+    var err error
+    if raw := q.Get("from"); raw != "" {
+        if from, err = time.Parse("2006-01-02", raw); err != nil {
+            utils.SendErrorCode(w, apperrors.CodeValidation, "Invalid from date. Use YYYY-MM-DD")
+            return
+        }
+    }
+    if raw := q.Get("to"); raw != "" {
+        if to, err = time.Parse("2006-01-02", raw); err != nil {
+            utils.SendErrorCode(w, apperrors.CodeValidation, "Invalid to date. Use YYYY-MM-DD")
+            return
+        }
+    }
+    if to.Before(from) {
+        utils.SendErrorCode(w, apperrors.CodeValidation, "from must not be after to")
+        return
+    }
+
+    results, err := h.repo.GetLabTrend(r.Context(), patientProfileID, analyte, from, to.AddDate(0, 0, 1))
+    if err != nil {
+        utils.SendAppError(w, err, "Failed to retrieve lab trend")
+        return
+    }
+
+    trend := LabTrendResponse{Analyte: analyte, Results: results}
+    if a, ok := labs.Lookup(analyte); ok {
+        trend.Name, trend.Unit = a.Name, a.Unit
+    }
+    if len(results) > 0 {
+        latest := results[len(results)-1]
+        trend.Name, trend.Unit = latest.Name, latest.Unit
+    }
+    if change, direction, ok := labs.Trend(results); ok {
+        trend.Change, trend.Direction = &change, direction
+    }
+
+    utils.SendSuccess(w, http.StatusOK, "Lab trend retrieved", trend)
+}
+
+// GetLabAnalytes lists the catalogued analytes with their units and
+// reference ranges
+func (h *TimelineHandler) GetLabAnalytes(w http.ResponseWriter, r *http.Request) {
+    utils.SendSuccess(w, http.StatusOK, "Lab analytes retrieved", labs.Catalog())
+}
+
+// DeleteLabPanel deletes one of the current patient's lab panels
+func (h *TimelineHandler) DeleteLabPanel(w http.ResponseWriter, r *http.Request) {
+    userID := r.Header.Get("X-User-ID")
+    userRole := r.Header.Get("X-User-Role")
+
+    if userID == "" {
+        utils.SendError(w, http.StatusUnauthorized, "Unauthorized")
+        return
+    }
+
+    if userRole != "patient" {
+        utils.SendError(w, http.StatusForbidden, "Only patients can delete lab results")
+        return
+    }
+
+    panelID := r.URL.Query().Get("id")
+    if panelID == "" {
+        utils.SendErrorCode(w, apperrors.CodeValidation, "Panel ID is required")
+        return
+    }
+
+    patientProfileID, err := h.repo.GetPatientProfileIDByUserID(r.Context(), userID)
+    if err != nil {
+        utils.SendErrorCode(w, apperrors.CodeProfileNotFound, "Patient profile not found")
+        return
+    }
+
+    // Another patient's panel is reported as missing rather than forbidden
+    panel, err := h.repo.GetLabPanel(r.Context(), panelID)
+    if err != nil || panel.PatientID != patientProfileID {
+        if err != nil && err != sql.ErrNoRows {
+            utils.SendAppError(w, err, "Failed to delete lab panel")
+            return
+        }
+        utils.SendError(w, http.StatusNotFound, "Lab panel not found")
+        return
+    }
+
+    if err := h.repo.DeleteLabPanel(r.Context(), panelID); err != nil {
+        utils.SendAppError(w, err, "Failed to delete lab panel")
+        return
+    }
+
+    utils.SendSuccess(w, http.StatusOK, "Lab panel deleted", nil)
+}
+
+// emptyToNil treats an empty optional ID as absent
+func emptyToNil(id *string) *string {
+    if id == nil || strings.TrimSpace(*id) == "" {
+        return nil
+    }
+    trimmed := strings.TrimSpace(*id)
+    return &trimmed
+}
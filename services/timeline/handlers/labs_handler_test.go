@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"health-bar/shared/apperrors"
+	"health-bar/shared/labs"
+	"health-bar/shared/models"
+	"health-bar/shared/testutil"
+)
+
+func recordLabs(t *testing.T, h *TimelineHandler, userID string, panel CreateLabPanelRequest) models.LabPanel {
+	t.Helper()
+
+	req := testutil.NewRequest(t, http.MethodPost, "/api/timeline/labs", panel, userID, "patient")
+	rec, resp := testutil.Serve(t, h.CreateLabPanel, req)
+	testutil.ExpectStatus(t, rec, http.StatusCreated)
+
+	var created models.LabPanel
+	testutil.DecodeData(t, resp, &created)
+	return created
+}
+
+func TestCreateLabPanel(t *testing.T) {
+	h, db := newTestHandler()
+	patient := db.AddPatient("p@test.com", "Pat")
+	other := db.AddPatient("o@test.com", "Other")
+	db.Lock()
+	p := db.PatientProfiles.Rows[patient.ID]
+	p.Gender = "female"
+	db.PatientProfiles.Rows[patient.ID] = p
+	db.Unlock()
+	visit := db.AddVisit(patient.ID, time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), "Annual check-up")
+	otherVisit := db.AddVisit(other.ID, time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), "Check-up")
+
+	panel := recordLabs(t, h, patient.UserID, CreateLabPanelRequest{
+		Name:        "Blood work",
+		CollectedAt: "2024-03-01",
+		VisitID:     &visit.ID,
+		Results: []LabResultRequest{
+			{Analyte: "hemoglobin", Value: num(13)},
+			{Analyte: "glucose", Value: num(7), Unit: "mmol/L"},
+			{Analyte: "ferritin", Name: "Ferritin", Value: num(8), Unit: "ng/mL", RefLow: num(15), RefHigh: num(150)},
+		},
+	})
+	if panel.VisitID == nil || *panel.VisitID != visit.ID || len(panel.Results) != 3 {
+		t.Fatalf("panel = %+v", panel)
+	}
+	flags := map[string]models.LabFlag{}
+	for _, res := range panel.Results {
+		flags[res.Analyte] = res.Flag
+	}
+	// 13 g/dL is normal for a woman; 7 mmol/L of glucose is 126 mg/dL
+	if flags["hemoglobin"] != models.LabNormal || flags["glucose"] != models.LabHigh || flags["ferritin"] != models.LabLow {
+		t.Fatalf("flags = %v", flags)
+	}
+
+	for name, tc := range map[string]struct {
+		req    CreateLabPanelRequest
+		prefix string
+	}{
+		"other patient's visit": {CreateLabPanelRequest{Name: "CBC", CollectedAt: "2024-03-01", VisitID: &otherVisit.ID,
+			Results: []LabResultRequest{{Analyte: "wbc", Value: num(5)}}}, "visit_id"},
+		"repeated analyte": {CreateLabPanelRequest{Name: "CBC", CollectedAt: "2024-03-01",
+			Results: []LabResultRequest{{Analyte: "wbc", Value: num(5)}, {Analyte: "WBC", Value: num(6)}}}, "results[1]: "},
+		"bad unit": {CreateLabPanelRequest{Name: "CBC", CollectedAt: "2024-03-01",
+			Results: []LabResultRequest{{Analyte: "wbc", Value: num(5)}, {Analyte: "glucose", Value: num(5), Unit: "g"}}}, "results[1]: "},
+		"future": {CreateLabPanelRequest{Name: "CBC", CollectedAt: time.Now().AddDate(0, 0, 2).Format("2006-01-02"),
+			Results: []LabResultRequest{{Analyte: "wbc", Value: num(5)}}}, "collected_at"},
+	} {
+		req := testutil.NewRequest(t, http.MethodPost, "/api/timeline/labs", tc.req, patient.UserID, "patient")
+		rec, resp := testutil.Serve(t, h.CreateLabPanel, req)
+		testutil.ExpectStatus(t, rec, http.StatusBadRequest)
+		testutil.ExpectCode(t, resp, apperrors.CodeValidation)
+		if !strings.HasPrefix(resp.Error, tc.prefix) {
+			t.Errorf("%s: error = %q", name, resp.Error)
+		}
+	}
+	if n := len(db.LabPanels.Rows); n != 1 {
+		t.Fatalf("stored %d panels, want 1", n)
+	}
+
+	// Deleting the visit keeps the panel but unlinks it
+	req := testutil.NewRequest(t, http.MethodDelete, "/api/timeline/visit?visit_id="+visit.ID, nil, patient.UserID, "patient")
+	rec, _ := testutil.Serve(t, h.DeleteVisit, req)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+	if stored := db.LabPanels.Rows[panel.ID]; stored.VisitID != nil {
+		t.Fatalf("visit_id = %v after deleting the visit", *stored.VisitID)
+	}
+}
+
+func TestLabTrendAndAccess(t *testing.T) {
+	h, db := newTestHandler()
+	patient := db.AddPatient("p@test.com", "Pat")
+	doctor := db.AddDoctor("d@test.com", "Doc")
+
+	for _, tc := range []struct {
+		date  string
+		value float64
+	}{{"2024-01-10", 95}, {"2024-04-10", 104}, {"2024-07-10", 118}} {
+		recordLabs(t, h, patient.UserID, CreateLabPanelRequest{Name: "Glucose", CollectedAt: tc.date,
+			Results: []LabResultRequest{{Analyte: "glucose", Value: num(tc.value)}}})
+	}
+
+	trendURL := "/api/timeline/labs/trend?analyte=glucose&patient_id=" + patient.ID
+	req := testutil.NewRequest(t, http.MethodGet, trendURL, nil, doctor.UserID, "doctor")
+	rec, resp := testutil.Serve(t, h.GetLabTrend, req)
+	testutil.ExpectStatus(t, rec, http.StatusForbidden)
+	testutil.ExpectCode(t, resp, apperrors.CodeAccessDenied)
+
+	db.Grant(patient.ID, doctor.ID)
+	req = testutil.NewRequest(t, http.MethodGet, trendURL, nil, doctor.UserID, "doctor")
+	rec, resp = testutil.Serve(t, h.GetLabTrend, req)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+
+	var trend LabTrendResponse
+	testutil.DecodeData(t, resp, &trend)
+	if len(trend.Results) != 3 || trend.Results[0].Value != 95 || trend.Unit != "mg/dL" {
+		t.Fatalf("trend = %+v", trend)
+	}
+	if trend.Change == nil || *trend.Change != 14 || trend.Direction != labs.Rising {
+		t.Fatalf("change = %v %q", trend.Change, trend.Direction)
+	}
+
+	// Only the two later panels have an abnormal result
+	req = testutil.NewRequest(t, http.MethodGet, "/api/timeline/labs/my?abnormal=true", nil, patient.UserID, "patient")
+	rec, resp = testutil.Serve(t, h.GetMyLabPanels, req)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+	var panels []models.LabPanel
+	testutil.DecodeData(t, resp, &panels)
+	if len(panels) != 2 || panels[0].Results[0].Value != 118 {
+		t.Fatalf("abnormal panels = %+v", panels)
+	}
+
+	// Doctors can read but not delete
+	req = testutil.NewRequest(t, http.MethodDelete, "/api/timeline/lab?id="+panels[0].ID, nil, doctor.UserID, "doctor")
+	rec, _ = testutil.Serve(t, h.DeleteLabPanel, req)
+	testutil.ExpectStatus(t, rec, http.StatusForbidden)
+
+	req = testutil.NewRequest(t, http.MethodDelete, "/api/timeline/lab?id="+panels[0].ID, nil, patient.UserID, "patient")
+	rec, _ = testutil.Serve(t, h.DeleteLabPanel, req)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+	if n := len(db.LabResults.Rows); n != 2 {
+		t.Fatalf("%d results left, want 2", n)
+	}
+}
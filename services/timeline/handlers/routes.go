@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"health-bar/shared/apperrors"
+	"health-bar/shared/idempotency"
+	"health-bar/shared/middleware"
+
+	"github.com/gorilla/mux"
+)
+
+// RegisterRoutes mounts the timeline service endpoints on router. Creates
+// honour Idempotency-Key headers, recorded in keys.
+func RegisterRoutes(router *mux.Router, h *TimelineHandler, keys idempotency.Store) {
+	idempotent := idempotency.New(keys)
+
+	router.NotFoundHandler = apperrors.NotFoundHandler()
+	router.MethodNotAllowedHandler = apperrors.MethodNotAllowedHandler()
+
+	// Timeline routes (protected)
+	router.HandleFunc("/api/timeline/visits", middleware.AuthMiddleware(idempotent.Wrap(h.CreateVisit))).Methods("POST")
+	router.HandleFunc("/api/timeline/my", middleware.AuthMiddleware(h.GetMyTimeline)).Methods("GET")
+	router.HandleFunc("/api/timeline/patient", middleware.AuthMiddleware(h.GetPatientTimeline)).Methods("GET")
+	router.HandleFunc("/api/timeline/visit", middleware.AuthMiddleware(h.GetVisit)).Methods("GET")
+	router.HandleFunc("/api/timeline/visit", middleware.AuthMiddleware(h.UpdateVisit)).Methods("PUT")
+	router.HandleFunc("/api/timeline/visit", middleware.AuthMiddleware(h.PatchVisit)).Methods("PATCH")
+	router.HandleFunc("/api/timeline/visit", middleware.AuthMiddleware(h.DeleteVisit)).Methods("DELETE")
+
+	// Vital signs (protected)
+	router.HandleFunc("/api/timeline/vitals", middleware.AuthMiddleware(idempotent.Wrap(h.CreateVitals))).Methods("POST")
+	router.HandleFunc("/api/timeline/vitals/my", middleware.AuthMiddleware(h.GetMyVitals)).Methods("GET")
+	router.HandleFunc("/api/timeline/vitals/patient", middleware.AuthMiddleware(h.GetPatientVitals)).Methods("GET")
+	router.HandleFunc("/api/timeline/vitals/summary", middleware.AuthMiddleware(h.GetVitalsSummary)).Methods("GET")
+	router.HandleFunc("/api/timeline/vitals/kinds", middleware.AuthMiddleware(h.GetVitalKinds)).Methods("GET")
+	router.HandleFunc("/api/timeline/vital", middleware.AuthMiddleware(h.DeleteVital)).Methods("DELETE")
+
+	// Lab results (protected)
+	router.HandleFunc("/api/timeline/labs", middleware.AuthMiddleware(idempotent.Wrap(h.CreateLabPanel))).Methods("POST")
+	router.HandleFunc("/api/timeline/labs/my", middleware.AuthMiddleware(h.GetMyLabPanels)).Methods("GET")
+	router.HandleFunc("/api/timeline/labs/patient", middleware.AuthMiddleware(h.GetPatientLabPanels)).Methods("GET")
+	router.HandleFunc("/api/timeline/labs/trend", middleware.AuthMiddleware(h.GetLabTrend)).Methods("GET")
+	router.HandleFunc("/api/timeline/labs/analytes", middleware.AuthMiddleware(h.GetLabAnalytes)).Methods("GET")
+	router.HandleFunc("/api/timeline/lab", middleware.AuthMiddleware(h.GetLabPanel)).Methods("GET")
+	router.HandleFunc("/api/timeline/lab", middleware.AuthMiddleware(h.DeleteLabPanel)).Methods("DELETE")
+
+	// Immunizations (protected)
+	router.HandleFunc("/api/timeline/immunizations", middleware.AuthMiddleware(idempotent.Wrap(h.CreateImmunization))).Methods("POST")
+	router.HandleFunc("/api/timeline/immunizations/my", middleware.AuthMiddleware(h.GetMyImmunizations)).Methods("GET")
+	router.HandleFunc("/api/timeline/immunizations/patient", middleware.AuthMiddleware(h.GetPatientImmunizations)).Methods("GET")
+	router.HandleFunc("/api/timeline/immunizations/due", middleware.AuthMiddleware(h.GetDueImmunizations)).Methods("GET")
+	router.HandleFunc("/api/timeline/immunizations/schedule", middleware.AuthMiddleware(h.GetImmunizationSchedule)).Methods("GET")
+	router.HandleFunc("/api/timeline/immunization", middleware.AuthMiddleware(h.DeleteImmunization)).Methods("DELETE")
+}
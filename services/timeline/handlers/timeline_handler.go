@@ -1,21 +1,43 @@
 package handlers
 
 import (
+    "context"
     "database/sql"
     "encoding/json"
+    "health-bar/shared/apperrors"
+    "health-bar/shared/immunization"
     "health-bar/shared/models"
+    "health-bar/shared/notify"
+    "health-bar/shared/pagination"
+    "health-bar/shared/patch"
     "health-bar/shared/utils"
+    "health-bar/shared/webhooks"
     "health-bar/services/timeline/repository"
+    "log"
     "net/http"
     "time"
 )
 
 type TimelineHandler struct {
-    repo *repository.TimelineRepository
+    repo     repository.Store
+    schedule *immunization.Schedule
+    notifier notify.Notifier
+    events   webhooks.Emitter
 }
 
-func NewTimelineHandler(repo *repository.TimelineRepository) *TimelineHandler {
-    return &TimelineHandler{repo: repo}
+func NewTimelineHandler(repo repository.Store) *TimelineHandler {
+    return &TimelineHandler{repo: repo, schedule: immunization.Default(), notifier: notify.LogNotifier{}, events: webhooks.Discard{}}
+}
+
+// UseNotifier sets where doctors are told about changes to their patients'
+// timelines
+func (h *TimelineHandler) UseNotifier(notifier notify.Notifier) {
+    h.notifier = notifier
+}
+
+// UseWebhooks sets where webhook events about new visits are stored
+func (h *TimelineHandler) UseWebhooks(events webhooks.Emitter) {
+    h.events = events
 }
 
 type CreateVisitRequest struct {
@@ -48,9 +70,9 @@ func (h *TimelineHandler) CreateVisit(w http.ResponseWriter, r *http.Request) {
     }
 
     // Get patient profile ID
-    patientProfileID, err := h.repo.GetPatientProfileIDByUserID(userID)
+    patientProfileID, err := h.repo.GetPatientProfileIDByUserID(r.Context(), userID)
     if err != nil {
-        utils.SendError(w, http.StatusNotFound, "Patient profile not found")
+        utils.SendErrorCode(w, apperrors.CodeProfileNotFound, "Patient profile not found")
         return
     }
 
@@ -62,14 +84,14 @@ func (h *TimelineHandler) CreateVisit(w http.ResponseWriter, r *http.Request) {
 
     // Validate
     if req.HospitalName == "" || req.VisitDate == "" || req.Reason == "" {
-        utils.SendError(w, http.StatusBadRequest, "Hospital name, visit date, and reason are required")
+        utils.SendErrorCode(w, apperrors.CodeValidation, "Hospital name, visit date, and reason are required")
         return
     }
 
     // Parse date
     visitDate, err := time.Parse("2006-01-02", req.VisitDate)
     if err != nil {
-        utils.SendError(w, http.StatusBadRequest, "Invalid date format. Use YYYY-MM-DD")
+        utils.SendErrorCode(w, apperrors.CodeValidation, "Invalid date format. Use YYYY-MM-DD")
         return
     }
 
@@ -80,11 +102,23 @@ func (h *TimelineHandler) CreateVisit(w http.ResponseWriter, r *http.Request) {
         Notes:        req.Notes,
     }
 
-    if err := h.repo.CreateVisit(patientProfileID, visit); err != nil {
-        utils.SendError(w, http.StatusInternalServerError, "Failed to create visit")
+    err = h.repo.WithTx(r.Context(), func(ctx context.Context) error {
+        if err := h.repo.CreateVisit(ctx, patientProfileID, visit); err != nil {
+            return err
+        }
+        return h.emitToCareTeam(ctx, patientProfileID, func(doctorUserID string) webhooks.Event {
+            return webhooks.VisitCreated(doctorUserID, patientProfileID, visit.ID, visit.HospitalName, visit.VisitDate)
+        })
+    })
+    if err != nil {
+        utils.SendAppError(w, err, "Failed to create visit")
         return
     }
 
+    h.notifyCareTeam(r.Context(), patientProfileID, func(doctorUserID string) notify.Message {
+        return notify.VisitCreated(doctorUserID, patientProfileID, visit.ID, visit.HospitalName)
+    })
+
     utils.SendSuccess(w, http.StatusCreated, "Visit added successfully", visit)
 }
 
@@ -104,19 +138,25 @@ func (h *TimelineHandler) GetMyTimeline(w http.ResponseWriter, r *http.Request)
     }
 
     // Get patient profile ID
-    patientProfileID, err := h.repo.GetPatientProfileIDByUserID(userID)
+    patientProfileID, err := h.repo.GetPatientProfileIDByUserID(r.Context(), userID)
+    if err != nil {
+        utils.SendErrorCode(w, apperrors.CodeProfileNotFound, "Patient profile not found")
+        return
+    }
+
+    page, err := pagination.Parse(r.URL.Query(), repository.VisitPages)
     if err != nil {
-        utils.SendError(w, http.StatusNotFound, "Patient profile not found")
+        utils.SendAppError(w, err, "Invalid pagination parameters")
         return
     }
 
-    visits, err := h.repo.GetVisitsByPatientID(patientProfileID)
+    visits, next, err := h.repo.GetVisitsByPatientID(r.Context(), patientProfileID, page)
     if err != nil {
-        utils.SendError(w, http.StatusInternalServerError, "Failed to retrieve timeline")
+        utils.SendAppError(w, err, "Failed to retrieve timeline")
         return
     }
 
-    utils.SendSuccess(w, http.StatusOK, "Timeline retrieved", visits)
+    utils.SendPage(w, http.StatusOK, "Timeline retrieved", visits, next)
 }
 
 // GetPatientTimeline gets timeline for a specific patient (for doctors with access)
@@ -132,36 +172,42 @@ func (h *TimelineHandler) GetPatientTimeline(w http.ResponseWriter, r *http.Requ
     // Get patient profile ID from query
     patientProfileID := r.URL.Query().Get("patient_id")
     if patientProfileID == "" {
-        utils.SendError(w, http.StatusBadRequest, "Patient ID is required")
+        utils.SendErrorCode(w, apperrors.CodeValidation, "Patient ID is required")
         return
     }
 
     // If patient is viewing their own timeline
     if userRole == "patient" {
-        myProfileID, err := h.repo.GetPatientProfileIDByUserID(userID)
+        myProfileID, err := h.repo.GetPatientProfileIDByUserID(r.Context(), userID)
         if err != nil || myProfileID != patientProfileID {
-            utils.SendError(w, http.StatusForbidden, "Access denied")
+            utils.SendErrorCode(w, apperrors.CodeAccessDenied, "Access denied")
             return
         }
     } else if userRole == "doctor" {
         // Check if doctor has access
-        hasAccess, err := h.repo.CheckDoctorAccess(userID, patientProfileID)
+        hasAccess, err := h.repo.CheckDoctorAccess(r.Context(), userID, patientProfileID)
         if err != nil || !hasAccess {
-            utils.SendError(w, http.StatusForbidden, "Access denied")
+            utils.SendErrorCode(w, apperrors.CodeAccessDenied, "Access denied")
             return
         }
     } else {
-        utils.SendError(w, http.StatusForbidden, "Access denied")
+        utils.SendErrorCode(w, apperrors.CodeAccessDenied, "Access denied")
         return
     }
 
-    visits, err := h.repo.GetVisitsByPatientID(patientProfileID)
+    page, err := pagination.Parse(r.URL.Query(), repository.VisitPages)
     if err != nil {
-        utils.SendError(w, http.StatusInternalServerError, "Failed to retrieve timeline")
+        utils.SendAppError(w, err, "Invalid pagination parameters")
         return
     }
 
-    utils.SendSuccess(w, http.StatusOK, "Timeline retrieved", visits)
+    visits, next, err := h.repo.GetVisitsByPatientID(r.Context(), patientProfileID, page)
+    if err != nil {
+        utils.SendAppError(w, err, "Failed to retrieve timeline")
+        return
+    }
+
+    utils.SendPage(w, http.StatusOK, "Timeline retrieved", visits, next)
 }
 
 // GetVisit gets a specific hospital visit
@@ -176,42 +222,43 @@ func (h *TimelineHandler) GetVisit(w http.ResponseWriter, r *http.Request) {
 
     visitID := r.URL.Query().Get("visit_id")
     if visitID == "" {
-        utils.SendError(w, http.StatusBadRequest, "Visit ID is required")
+        utils.SendErrorCode(w, apperrors.CodeValidation, "Visit ID is required")
         return
     }
 
-    visit, err := h.repo.GetVisitByID(visitID)
+    visit, err := h.repo.GetVisitByID(r.Context(), visitID)
     if err != nil {
         if err == sql.ErrNoRows {
             utils.SendError(w, http.StatusNotFound, "Visit not found")
             return
         }
-        utils.SendError(w, http.StatusInternalServerError, "Failed to retrieve visit")
+        utils.SendAppError(w, err, "Failed to retrieve visit")
         return
     }
 
     // Check if user has access
     if userRole == "patient" {
-        patientProfileID, err := h.repo.GetPatientProfileIDByUserID(userID)
+        patientProfileID, err := h.repo.GetPatientProfileIDByUserID(r.Context(), userID)
         if err != nil || visit.PatientID != patientProfileID {
-            utils.SendError(w, http.StatusForbidden, "Access denied")
+            utils.SendErrorCode(w, apperrors.CodeAccessDenied, "Access denied")
             return
         }
     } else if userRole == "doctor" {
-        hasAccess, err := h.repo.CheckDoctorAccess(userID, visit.PatientID)
+        hasAccess, err := h.repo.CheckDoctorAccess(r.Context(), userID, visit.PatientID)
         if err != nil || !hasAccess {
-            utils.SendError(w, http.StatusForbidden, "Access denied")
+            utils.SendErrorCode(w, apperrors.CodeAccessDenied, "Access denied")
             return
         }
     } else {
-        utils.SendError(w, http.StatusForbidden, "Access denied")
+        utils.SendErrorCode(w, apperrors.CodeAccessDenied, "Access denied")
         return
     }
 
+    utils.SetETag(w, visit.Version)
     utils.SendSuccess(w, http.StatusOK, "Visit retrieved", visit)
 }
 
-// UpdateVisit updates a hospital visit
+// UpdateVisit replaces a hospital visit
 func (h *TimelineHandler) UpdateVisit(w http.ResponseWriter, r *http.Request) {
     userID := r.Header.Get("X-User-ID")
     userRole := r.Header.Get("X-User-Role")
@@ -228,56 +275,121 @@ func (h *TimelineHandler) UpdateVisit(w http.ResponseWriter, r *http.Request) {
 
     visitID := r.URL.Query().Get("visit_id")
     if visitID == "" {
-        utils.SendError(w, http.StatusBadRequest, "Visit ID is required")
+        utils.SendErrorCode(w, apperrors.CodeValidation, "Visit ID is required")
         return
     }
 
-    // Check if visit belongs to this patient
-    patientProfileID, err := h.repo.GetPatientProfileIDByUserID(userID)
-    if err != nil {
-        utils.SendError(w, http.StatusNotFound, "Patient profile not found")
+    var req UpdateVisitRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        utils.SendError(w, http.StatusBadRequest, "Invalid request body")
         return
     }
 
-    visitPatientID, err := h.repo.GetPatientIDByVisitID(visitID)
-    if err != nil {
-        utils.SendError(w, http.StatusNotFound, "Visit not found")
+    h.updateVisit(w, r, userID, visitID, func(*models.HospitalVisit) (*models.HospitalVisit, error) {
+        return req.visit()
+    })
+}
+
+// PatchVisit applies a JSON merge patch to a hospital visit
+func (h *TimelineHandler) PatchVisit(w http.ResponseWriter, r *http.Request) {
+    userID := r.Header.Get("X-User-ID")
+    userRole := r.Header.Get("X-User-Role")
+
+    if userID == "" {
+        utils.SendError(w, http.StatusUnauthorized, "Unauthorized")
         return
     }
 
-    if visitPatientID != patientProfileID {
-        utils.SendError(w, http.StatusForbidden, "Access denied")
+    if userRole != "patient" {
+        utils.SendError(w, http.StatusForbidden, "Only patients can update visits")
         return
     }
 
-    var req UpdateVisitRequest
-    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-        utils.SendError(w, http.StatusBadRequest, "Invalid request body")
+    visitID := r.URL.Query().Get("visit_id")
+    if visitID == "" {
+        utils.SendErrorCode(w, apperrors.CodeValidation, "Visit ID is required")
         return
     }
 
-    // Parse date
-    visitDate, err := time.Parse("2006-01-02", req.VisitDate)
+    changes, err := patch.Read(r)
     if err != nil {
-        utils.SendError(w, http.StatusBadRequest, "Invalid date format. Use YYYY-MM-DD")
+        utils.SendAppError(w, err, "Invalid request body")
         return
     }
 
-    visit := &models.HospitalVisit{
-        HospitalName: req.HospitalName,
-        VisitDate:    visitDate,
-        Reason:       req.Reason,
-        Notes:        req.Notes,
-    }
+    h.updateVisit(w, r, userID, visitID, func(current *models.HospitalVisit) (*models.HospitalVisit, error) {
+        doc := UpdateVisitRequest{
+            HospitalName: current.HospitalName,
+            VisitDate:    current.VisitDate.Format("2006-01-02"),
+            Reason:       current.Reason,
+            Notes:        current.Notes,
+        }
+        if err := patch.Apply(&doc, changes); err != nil {
+            return nil, err
+        }
+        return doc.visit()
+    })
+}
 
-    if err := h.repo.UpdateVisit(visitID, visit); err != nil {
-        utils.SendError(w, http.StatusInternalServerError, "Failed to update visit")
+// updateVisit writes the visit built by change from the current one. The
+// ownership check, the If-Match precondition and the versioned write run in
+// one transaction.
+func (h *TimelineHandler) updateVisit(w http.ResponseWriter, r *http.Request, userID, visitID string, change func(*models.HospitalVisit) (*models.HospitalVisit, error)) {
+    var visit *models.HospitalVisit
+    var patientID string
+    err := h.repo.WithTx(r.Context(), func(ctx context.Context) error {
+        if err := h.checkVisitOwner(ctx, userID, visitID); err != nil {
+            return err
+        }
+
+        current, err := h.repo.GetVisitByID(ctx, visitID)
+        if err != nil {
+            return err
+        }
+        patientID = current.PatientID
+        if err := utils.CheckIfMatch(r, current.Version); err != nil {
+            return err
+        }
+
+        visit, err = change(current)
+        if err != nil {
+            return err
+        }
+        visit.Version = current.Version
+        return h.repo.UpdateVisit(ctx, visitID, visit)
+    })
+    if err != nil {
+        utils.SendAppError(w, err, "Failed to update visit")
         return
     }
 
+    h.notifyCareTeam(r.Context(), patientID, func(doctorUserID string) notify.Message {
+        return notify.VisitUpdated(doctorUserID, patientID, visitID, visit.HospitalName)
+    })
+
+    utils.SetETag(w, visit.Version)
     utils.SendSuccess(w, http.StatusOK, "Visit updated successfully", visit)
 }
 
+// visit validates req and converts it to a visit
+func (req UpdateVisitRequest) visit() (*models.HospitalVisit, error) {
+    if req.HospitalName == "" || req.Reason == "" {
+        return nil, apperrors.New(apperrors.CodeValidation, "Hospital name and reason are required")
+    }
+
+    visitDate, err := time.Parse("2006-01-02", req.VisitDate)
+    if err != nil {
+        return nil, apperrors.New(apperrors.CodeValidation, "Invalid date format. Use YYYY-MM-DD")
+    }
+
+    return &models.HospitalVisit{
+        HospitalName: req.HospitalName,
+        VisitDate:    visitDate,
+        Reason:       req.Reason,
+        Notes:        req.Notes,
+    }, nil
+}
+
 // DeleteVisit deletes a hospital visit
 func (h *TimelineHandler) DeleteVisit(w http.ResponseWriter, r *http.Request) {
     userID := r.Header.Get("X-User-ID")
@@ -295,32 +407,86 @@ func (h *TimelineHandler) DeleteVisit(w http.ResponseWriter, r *http.Request) {
 
     visitID := r.URL.Query().Get("visit_id")
     if visitID == "" {
-        utils.SendError(w, http.StatusBadRequest, "Visit ID is required")
+        utils.SendErrorCode(w, apperrors.CodeValidation, "Visit ID is required")
         return
     }
 
-    // Check if visit belongs to this patient
-    patientProfileID, err := h.repo.GetPatientProfileIDByUserID(userID)
+    // Ownership check and delete run in one transaction
+    var visit *models.HospitalVisit
+    err := h.repo.WithTx(r.Context(), func(ctx context.Context) error {
+        if err := h.checkVisitOwner(ctx, userID, visitID); err != nil {
+            return err
+        }
+        var err error
+        if visit, err = h.repo.GetVisitByID(ctx, visitID); err != nil {
+            return err
+        }
+        return h.repo.DeleteVisit(ctx, visitID)
+    })
     if err != nil {
-        utils.SendError(w, http.StatusNotFound, "Patient profile not found")
+        utils.SendAppError(w, err, "Failed to delete visit")
         return
     }
 
-    visitPatientID, err := h.repo.GetPatientIDByVisitID(visitID)
+    h.notifyCareTeam(r.Context(), visit.PatientID, func(doctorUserID string) notify.Message {
+        return notify.VisitDeleted(doctorUserID, visit.PatientID, visitID, visit.HospitalName)
+    })
+
+    utils.SendSuccess(w, http.StatusOK, "Visit deleted successfully", nil)
+}
+
+// notifyCareTeam tells every doctor with access to a patient about a change
+// to their timeline. The change is already committed, so failures are only
+// logged.
+func (h *TimelineHandler) notifyCareTeam(ctx context.Context, patientID string, message func(doctorUserID string) notify.Message) {
+    doctorUserIDs, err := h.repo.GetDoctorUserIDsWithAccess(ctx, patientID)
     if err != nil {
-        utils.SendError(w, http.StatusNotFound, "Visit not found")
+        log.Printf("Failed to look up doctors of patient %s to notify: %v", patientID, err)
         return
     }
 
-    if visitPatientID != patientProfileID {
-        utils.SendError(w, http.StatusForbidden, "Access denied")
-        return
+    msgs := make([]notify.Message, len(doctorUserIDs))
+    for i, doctorUserID := range doctorUserIDs {
+        msgs[i] = message(doctorUserID)
     }
+    notify.Send(ctx, h.notifier, msgs...)
+}
 
-    if err := h.repo.DeleteVisit(visitID); err != nil {
-        utils.SendError(w, http.StatusInternalServerError, "Failed to delete visit")
-        return
+// emitToCareTeam stores an event for every doctor with access to a patient.
+// It runs in the transaction of the change, so the events commit with it.
+func (h *TimelineHandler) emitToCareTeam(ctx context.Context, patientID string, event func(doctorUserID string) webhooks.Event) error {
+    doctorUserIDs, err := h.repo.GetDoctorUserIDsWithAccess(ctx, patientID)
+    if err != nil {
+        return err
     }
 
-    utils.SendSuccess(w, http.StatusOK, "Visit deleted successfully", nil)
+    events := make([]webhooks.Event, len(doctorUserIDs))
+    for i, doctorUserID := range doctorUserIDs {
+        events[i] = event(doctorUserID)
+    }
+    return h.events.Emit(ctx, events...)
+}
+
+var (
+    errPatientProfileNotFound = apperrors.New(apperrors.CodeProfileNotFound, "Patient profile not found")
+    errVisitNotFound          = apperrors.New(apperrors.CodeNotFound, "Visit not found")
+    errAccessDenied           = apperrors.New(apperrors.CodeAccessDenied, "Access denied")
+)
+
+// checkVisitOwner verifies that the visit belongs to the patient behind userID
+func (h *TimelineHandler) checkVisitOwner(ctx context.Context, userID, visitID string) error {
+    patientProfileID, err := h.repo.GetPatientProfileIDByUserID(ctx, userID)
+    if err != nil {
+        return errPatientProfileNotFound
+    }
+
+    visitPatientID, err := h.repo.GetPatientIDByVisitID(ctx, visitID)
+    if err != nil {
+        return errVisitNotFound
+    }
+
+    if visitPatientID != patientProfileID {
+        return errAccessDenied
+    }
+    return nil
 }
@@ -0,0 +1,378 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"health-bar/services/timeline/repository"
+	"health-bar/shared/apperrors"
+	"health-bar/shared/memdb"
+	"health-bar/shared/models"
+	"health-bar/shared/notify"
+	"health-bar/shared/patch"
+	"health-bar/shared/testutil"
+	"health-bar/shared/utils"
+	"health-bar/shared/webhooks"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func newTestHandler() (*TimelineHandler, *memdb.DB) {
+	db := memdb.New()
+	return NewTimelineHandler(repository.NewMemoryRepository(db)), db
+}
+
+func createVisit(t *testing.T, h *TimelineHandler, userID string) models.HospitalVisit {
+	t.Helper()
+
+	req := testutil.NewRequest(t, http.MethodPost, "/api/timeline/visits", CreateVisitRequest{
+		HospitalName: "General", VisitDate: "2024-03-01", Reason: "Check-up",
+	}, userID, "patient")
+	rec, resp := testutil.Serve(t, h.CreateVisit, req)
+	testutil.ExpectStatus(t, rec, http.StatusCreated)
+
+	var visit models.HospitalVisit
+	testutil.DecodeData(t, resp, &visit)
+	return visit
+}
+
+func TestCreateVisitValidation(t *testing.T) {
+	h, db := newTestHandler()
+	patient := db.AddPatient("p@test.com", "Pat")
+	noProfile := db.AddUser("np@test.com", models.RolePatient)
+
+	valid := CreateVisitRequest{HospitalName: "General", VisitDate: "2024-03-01", Reason: "Check-up"}
+
+	tests := []struct {
+		name   string
+		userID string
+		role   string
+		body   interface{}
+		want   int
+	}{
+		{"missing identity", "", "", valid, http.StatusUnauthorized},
+		{"doctor cannot add", patient.UserID, "doctor", valid, http.StatusForbidden},
+		{"no profile", noProfile.ID, "patient", valid, http.StatusNotFound},
+		{"missing reason", patient.UserID, "patient", CreateVisitRequest{HospitalName: "General", VisitDate: "2024-03-01"}, http.StatusBadRequest},
+		{"bad date", patient.UserID, "patient", CreateVisitRequest{HospitalName: "General", VisitDate: "March", Reason: "x"}, http.StatusBadRequest},
+		{"created", patient.UserID, "patient", valid, http.StatusCreated},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := testutil.NewRequest(t, http.MethodPost, "/api/timeline/visits", tt.body, tt.userID, tt.role)
+			rec, _ := testutil.Serve(t, h.CreateVisit, req)
+			testutil.ExpectStatus(t, rec, tt.want)
+		})
+	}
+}
+
+func TestPatientTimelineAccessControl(t *testing.T) {
+	h, db := newTestHandler()
+	patient := db.AddPatient("p@test.com", "Pat")
+	other := db.AddPatient("o@test.com", "Other")
+	doctor := db.AddDoctor("d@test.com", "Dr Who")
+	createVisit(t, h, patient.UserID)
+
+	view := func(userID, role string) int {
+		req := testutil.NewRequest(t, http.MethodGet, "/api/timeline/patient?patient_id="+patient.ID, nil, userID, role)
+		rec, _ := testutil.Serve(t, h.GetPatientTimeline, req)
+		return rec.Code
+	}
+
+	if code := view(patient.UserID, "patient"); code != http.StatusOK {
+		t.Fatalf("owner: status = %d, want 200", code)
+	}
+	if code := view(other.UserID, "patient"); code != http.StatusForbidden {
+		t.Fatalf("other patient: status = %d, want 403", code)
+	}
+	if code := view(doctor.UserID, "doctor"); code != http.StatusForbidden {
+		t.Fatalf("doctor without grant: status = %d, want 403", code)
+	}
+	if code := view(doctor.UserID, "admin"); code != http.StatusForbidden {
+		t.Fatalf("unknown role: status = %d, want 403", code)
+	}
+
+	db.Grant(patient.ID, doctor.ID)
+	if code := view(doctor.UserID, "doctor"); code != http.StatusOK {
+		t.Fatalf("doctor with grant: status = %d, want 200", code)
+	}
+}
+
+func TestGetVisitAccessControl(t *testing.T) {
+	h, db := newTestHandler()
+	patient := db.AddPatient("p@test.com", "Pat")
+	other := db.AddPatient("o@test.com", "Other")
+	doctor := db.AddDoctor("d@test.com", "Dr Who")
+	visit := createVisit(t, h, patient.UserID)
+
+	get := func(visitID, userID, role string) int {
+		req := testutil.NewRequest(t, http.MethodGet, "/api/timeline/visit?visit_id="+visitID, nil, userID, role)
+		rec, _ := testutil.Serve(t, h.GetVisit, req)
+		return rec.Code
+	}
+
+	if code := get("missing", patient.UserID, "patient"); code != http.StatusNotFound {
+		t.Fatalf("unknown visit: status = %d, want 404", code)
+	}
+	if code := get(visit.ID, other.UserID, "patient"); code != http.StatusForbidden {
+		t.Fatalf("other patient: status = %d, want 403", code)
+	}
+	if code := get(visit.ID, doctor.UserID, "doctor"); code != http.StatusForbidden {
+		t.Fatalf("doctor without grant: status = %d, want 403", code)
+	}
+
+	db.Grant(patient.ID, doctor.ID)
+	if code := get(visit.ID, doctor.UserID, "doctor"); code != http.StatusOK {
+		t.Fatalf("doctor with grant: status = %d, want 200", code)
+	}
+}
+
+func TestUpdateAndDeleteVisitOwnership(t *testing.T) {
+	h, db := newTestHandler()
+	patient := db.AddPatient("p@test.com", "Pat")
+	other := db.AddPatient("o@test.com", "Other")
+	visit := createVisit(t, h, patient.UserID)
+
+	update := UpdateVisitRequest{HospitalName: "City", VisitDate: "2024-03-02", Reason: "Follow-up"}
+
+	req := testutil.NewRequest(t, http.MethodPut, "/api/timeline/visit?visit_id="+visit.ID, update, other.UserID, "patient")
+	rec, resp := testutil.Serve(t, h.UpdateVisit, req)
+	testutil.ExpectStatus(t, rec, http.StatusForbidden)
+	testutil.ExpectCode(t, resp, apperrors.CodeAccessDenied)
+
+	req = testutil.NewRequest(t, http.MethodPut, "/api/timeline/visit?visit_id=missing", update, patient.UserID, "patient")
+	rec, resp = testutil.Serve(t, h.UpdateVisit, req)
+	testutil.ExpectStatus(t, rec, http.StatusNotFound)
+	testutil.ExpectCode(t, resp, apperrors.CodeNotFound)
+
+	req = testutil.NewRequest(t, http.MethodPut, "/api/timeline/visit?visit_id="+visit.ID, update, patient.UserID, "patient")
+	rec, resp = testutil.Serve(t, h.UpdateVisit, req)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+
+	var updated models.HospitalVisit
+	testutil.DecodeData(t, resp, &updated)
+	if updated.HospitalName != "City" || updated.PatientID != patient.ID {
+		t.Fatalf("updated visit = %+v", updated)
+	}
+
+	req = testutil.NewRequest(t, http.MethodDelete, "/api/timeline/visit?visit_id="+visit.ID, nil, other.UserID, "patient")
+	rec, _ = testutil.Serve(t, h.DeleteVisit, req)
+	testutil.ExpectStatus(t, rec, http.StatusForbidden)
+
+	req = testutil.NewRequest(t, http.MethodDelete, "/api/timeline/visit?visit_id="+visit.ID, nil, patient.UserID, "patient")
+	rec, _ = testutil.Serve(t, h.DeleteVisit, req)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+
+	if len(db.HospitalVisits.Rows) != 0 {
+		t.Fatalf("visit still stored after delete")
+	}
+}
+
+type failingEmitter struct{}
+
+func (failingEmitter) Emit(ctx context.Context, events ...webhooks.Event) error {
+	return errors.New("emit failed")
+}
+
+func TestCreateVisitEmitsWebhookWithTheVisit(t *testing.T) {
+	h, db := newTestHandler()
+	h.UseWebhooks(webhooks.NewMemoryEmitter(db))
+	patient := db.AddPatient("p@test.com", "Pat")
+	doctor := db.AddDoctor("d@test.com", "Dr Who")
+	db.Grant(patient.ID, doctor.ID)
+	sub := db.AddWebhookSubscription(doctor.UserID, "https://clinic.test/hooks", webhooks.EventVisitCreated)
+
+	visit := createVisit(t, h, patient.UserID)
+	if len(db.WebhookDeliveries.Rows) != 1 {
+		t.Fatalf("deliveries = %+v", db.WebhookDeliveries.Rows)
+	}
+	for _, d := range db.WebhookDeliveries.Rows {
+		if d.SubscriptionID != sub.ID || d.EventType != webhooks.EventVisitCreated || !strings.Contains(string(d.Payload), visit.ID) {
+			t.Fatalf("delivery = %+v", d)
+		}
+	}
+
+	// The event commits with the visit: when it cannot be stored, neither is
+	// the visit
+	h.UseWebhooks(failingEmitter{})
+	req := testutil.NewRequest(t, http.MethodPost, "/api/timeline/visits", CreateVisitRequest{
+		HospitalName: "General", VisitDate: "2024-03-02", Reason: "Follow-up",
+	}, patient.UserID, "patient")
+	rec, _ := testutil.Serve(t, h.CreateVisit, req)
+	testutil.ExpectStatus(t, rec, http.StatusInternalServerError)
+	if len(db.HospitalVisits.Rows) != 1 {
+		t.Fatalf("%d visits stored", len(db.HospitalVisits.Rows))
+	}
+}
+
+func TestVisitChangesNotifyDoctors(t *testing.T) {
+	h, db := newTestHandler()
+	recorder := &notify.Recorder{}
+	h.UseNotifier(recorder)
+	patient := db.AddPatient("p@test.com", "Pat")
+	doctor := db.AddDoctor("d@test.com", "Dr Who")
+	revoked := db.AddDoctor("r@test.com", "Dr No")
+	db.Grant(patient.ID, doctor.ID)
+	db.Grant(patient.ID, revoked.ID)
+	db.Lock()
+	p, _ := db.Permission(patient.ID, revoked.ID)
+	p.IsActive = false
+	db.AccessPermissions.Rows[p.ID] = p
+	db.Unlock()
+
+	visit := createVisit(t, h, patient.UserID)
+
+	req := testutil.NewRequest(t, http.MethodPatch, "/api/timeline/visit?visit_id="+visit.ID, `{"hospital_name":"City"}`, patient.UserID, "patient")
+	req.Header.Set("Content-Type", patch.ContentType)
+	rec, _ := testutil.Serve(t, h.PatchVisit, req)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+
+	req = testutil.NewRequest(t, http.MethodDelete, "/api/timeline/visit?visit_id="+visit.ID, nil, patient.UserID, "patient")
+	rec, _ = testutil.Serve(t, h.DeleteVisit, req)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+
+	msgs := recorder.Messages()
+	want := []struct{ kind, subject string }{
+		{notify.KindVisitCreated, "A visit to General was added"},
+		{notify.KindVisitUpdated, "A visit to City was updated"},
+		{notify.KindVisitDeleted, "A visit to City was removed"},
+	}
+	if len(msgs) != len(want) {
+		t.Fatalf("sent %d messages, want %d: %+v", len(msgs), len(want), msgs)
+	}
+	for i, w := range want {
+		if msgs[i].Kind != w.kind || msgs[i].Subject != w.subject || msgs[i].UserID != doctor.UserID || msgs[i].Data["visit_id"] != visit.ID {
+			t.Errorf("message %d = %+v, want %s %q to the doctor with access", i, msgs[i], w.kind, w.subject)
+		}
+	}
+}
+
+func TestVisitsCascadeWithPatientProfile(t *testing.T) {
+	h, db := newTestHandler()
+	patient := db.AddPatient("p@test.com", "Pat")
+	createVisit(t, h, patient.UserID)
+	createVisit(t, h, patient.UserID)
+
+	db.Lock()
+	db.DeleteUser(patient.UserID)
+	db.Unlock()
+
+	if len(db.HospitalVisits.Rows) != 0 || len(db.PatientProfiles.Rows) != 0 {
+		t.Fatalf("deleting the user did not cascade to profile and visits")
+	}
+}
+
+func TestGetMyTimelinePagination(t *testing.T) {
+	h, db := newTestHandler()
+	patient := db.AddPatient("p@test.com", "Pat")
+
+	for _, v := range []struct{ hospital, date string }{
+		{"General", "2024-01-10"}, {"St. Mary's", "2024-02-10"}, {"General", "2024-03-10"},
+		{"City Clinic", "2024-04-10"}, {"General", "2024-05-10"},
+	} {
+		req := testutil.NewRequest(t, http.MethodPost, "/api/timeline/visits", CreateVisitRequest{
+			HospitalName: v.hospital, VisitDate: v.date, Reason: "Check-up",
+		}, patient.UserID, "patient")
+		rec, _ := testutil.Serve(t, h.CreateVisit, req)
+		testutil.ExpectStatus(t, rec, http.StatusCreated)
+	}
+
+	list := func(query string) ([]string, string) {
+		t.Helper()
+
+		req := testutil.NewRequest(t, http.MethodGet, "/api/timeline/my?"+query, nil, patient.UserID, "patient")
+		rec, resp := testutil.Serve(t, h.GetMyTimeline, req)
+		testutil.ExpectStatus(t, rec, http.StatusOK)
+
+		var visits []models.HospitalVisit
+		testutil.DecodeData(t, resp, &visits)
+		dates := make([]string, len(visits))
+		for i, v := range visits {
+			dates[i] = v.VisitDate.Format("01-02")
+		}
+		return dates, resp.NextCursor
+	}
+
+	first, cursor := list("limit=2")
+	if len(first) != 2 || first[0] != "05-10" || first[1] != "04-10" || cursor == "" {
+		t.Fatalf("first page = %v, cursor %q", first, cursor)
+	}
+	second, cursor := list("limit=2&cursor=" + cursor)
+	if len(second) != 2 || second[0] != "03-10" || second[1] != "02-10" || cursor == "" {
+		t.Fatalf("second page = %v, cursor %q", second, cursor)
+	}
+	last, cursor := list("limit=2&cursor=" + cursor)
+	if len(last) != 1 || last[0] != "01-10" || cursor != "" {
+		t.Fatalf("last page = %v, cursor %q", last, cursor)
+	}
+
+	filtered, _ := list("hospital_name=general&from=2024-02-01&to=2024-05-01&sort=visit_date")
+	if len(filtered) != 1 || filtered[0] != "03-10" {
+		t.Fatalf("filtered = %v", filtered)
+	}
+
+	req := testutil.NewRequest(t, http.MethodGet, "/api/timeline/my?sort=notes", nil, patient.UserID, "patient")
+	rec, resp := testutil.Serve(t, h.GetMyTimeline, req)
+	testutil.ExpectStatus(t, rec, http.StatusBadRequest)
+	testutil.ExpectCode(t, resp, apperrors.CodeValidation)
+}
+
+func TestPatchVisit(t *testing.T) {
+	h, db := newTestHandler()
+	patient := db.AddPatient("p@test.com", "Pat")
+	visit := createVisit(t, h, patient.UserID)
+
+	patchVisit := func(body, ifMatch string) (int, models.HospitalVisit, *http.Response) {
+		t.Helper()
+		req := testutil.NewRequest(t, http.MethodPatch, "/api/timeline/visit?visit_id="+visit.ID, body, patient.UserID, "patient")
+		req.Header.Set("Content-Type", patch.ContentType)
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		rec, resp := testutil.Serve(t, h.PatchVisit, req)
+		var updated models.HospitalVisit
+		if rec.Code == http.StatusOK {
+			testutil.DecodeData(t, resp, &updated)
+		}
+		return rec.Code, updated, rec.Result()
+	}
+
+	req := testutil.NewRequest(t, http.MethodGet, "/api/timeline/visit?visit_id="+visit.ID, nil, patient.UserID, "patient")
+	rec, _ := testutil.Serve(t, h.GetVisit, req)
+	etag := rec.Header().Get("ETag")
+	if etag != utils.ETag(1) {
+		t.Fatalf("GET ETag = %q, want %q", etag, utils.ETag(1))
+	}
+
+	code, updated, resp := patchVisit(`{"notes":"Bring scans"}`, etag)
+	if code != http.StatusOK {
+		t.Fatalf("patch notes: status = %d, want 200", code)
+	}
+	if updated.Notes != "Bring scans" || updated.HospitalName != "General" || updated.Reason != "Check-up" || updated.Version != 2 {
+		t.Fatalf("patched visit = %+v", updated)
+	}
+	if got := resp.Header.Get("ETag"); got != utils.ETag(2) {
+		t.Fatalf("PATCH ETag = %q, want %q", got, utils.ETag(2))
+	}
+
+	// The first ETag is stale now
+	if code, _, _ := patchVisit(`{"reason":"Other"}`, etag); code != http.StatusPreconditionFailed {
+		t.Fatalf("stale If-Match: status = %d, want 412", code)
+	}
+
+	code, updated, _ = patchVisit(`{"notes":null}`, "")
+	if code != http.StatusOK || updated.Notes != "" {
+		t.Fatalf("clear notes: status = %d, visit = %+v", code, updated)
+	}
+
+	for _, body := range []string{`{"reason":null}`, `{"visit_date":"March"}`, `{"patient_id":"someone"}`} {
+		if code, _, _ := patchVisit(body, ""); code != http.StatusBadRequest {
+			t.Fatalf("patch %s: status = %d, want 400", body, code)
+		}
+	}
+
+	if v := db.HospitalVisits.Rows[visit.ID]; v.Reason != "Check-up" || v.Version != 3 {
+		t.Fatalf("stored visit = %+v", v)
+	}
+}
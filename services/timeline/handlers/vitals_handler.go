@@ -0,0 +1,407 @@
+package handlers
+
+import (
+    "database/sql"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "health-bar/shared/apperrors"
+    "health-bar/shared/models"
+    "health-bar/shared/pagination"
+    "health-bar/shared/utils"
+    "health-bar/shared/vitals"
+    "health-bar/services/timeline/repository"
+    "net/http"
+    "strings"
+    "time"
+)
+
+const (
+    // MaxVitalsBatch is the most readings one CreateVitals request may carry
+    MaxVitalsBatch = 500
+    maxVitalsBody  = 1 << 20
+    // Devices' clocks drift; readings up to this far ahead are accepted
+    maxClockSkew = 5 * time.Minute
+)
+
+// Longest range a summary may span per bucket size, and the default when
+// from is omitted
+var (
+    maxSummaryDays     = map[string]int{"day": 366, "week": 5 * 366}
+    defaultSummaryDays = map[string]int{"day": 30, "week": 12 * 7}
+)
+
+type VitalReadingRequest struct {
+    Kind       string   `json:"kind"`
+    Value      *float64 `json:"value"`
+    Diastolic  *float64 `json:"diastolic"`   // Blood pressure only
+    Unit       string   `json:"unit"`        // Optional, defaults to the kind's canonical unit
+    MeasuredAt string   `json:"measured_at"` // Optional RFC 3339 timestamp, defaults to now
+    Source     string   `json:"source"`      // e.g. "manual" or a device name
+    Notes      string   `json:"notes"`
+}
+
+type CreateVitalsRequest struct {
+    Readings []VitalReadingRequest `json:"readings"`
+}
+
+type CreateVitalsResponse struct {
+    Readings []models.VitalReading `json:"readings"`
+    // Skipped counts readings that repeated an already recorded kind and
+    // measured_at
+    Skipped int `json:"skipped"`
+}
+
+type VitalsSummaryResponse struct {
+    Kind    models.VitalKind     `json:"kind"`
+    Unit    string               `json:"unit"`
+    Bucket  string               `json:"bucket"`
+    From    string               `json:"from"`
+    To      string               `json:"to"`
+    Buckets []models.VitalBucket `json:"buckets"`
+}
+
+func (req VitalReadingRequest) reading(now time.Time) (models.VitalReading, error) {
+    kind, err := vitals.ParseKind(req.Kind)
+    if err != nil {
+        return models.VitalReading{}, err
+    }
+    if req.Value == nil {
+        return models.VitalReading{}, apperrors.New(apperrors.CodeValidation, "value is required")
+    }
+
+    measuredAt := now
+    if req.MeasuredAt != "" {
+        measuredAt, err = time.Parse(time.RFC3339Nano, req.MeasuredAt)
+        if err != nil {
+            return models.VitalReading{}, apperrors.New(apperrors.CodeValidation, "Invalid measured_at. Use an RFC 3339 timestamp")
+        }
+        measuredAt = measuredAt.UTC().Truncate(time.Microsecond)
+        if measuredAt.After(now.Add(maxClockSkew)) {
+            return models.VitalReading{}, apperrors.New(apperrors.CodeValidation, "measured_at must not be in the future")
+        }
+    }
+
+    source := strings.TrimSpace(req.Source)
+    if len(source) > 50 {
+        return models.VitalReading{}, apperrors.New(apperrors.CodeValidation, "source must be at most 50 characters")
+    }
+
+    reading := models.VitalReading{
+        Kind:       kind,
+        Value:      *req.Value,
+        Diastolic:  req.Diastolic,
+        MeasuredAt: measuredAt,
+        Source:     source,
+        Notes:      req.Notes,
+    }
+    if err := vitals.Normalize(&reading, req.Unit); err != nil {
+        return models.VitalReading{}, err
+    }
+    return reading, nil
+}
+
+// CreateVitals records a batch of vital sign readings. The batch is
+// validated as a whole: one invalid reading rejects all of them.
+func (h *TimelineHandler) CreateVitals(w http.ResponseWriter, r *http.Request) {
+    userID := r.Header.Get("X-User-ID")
+    userRole := r.Header.Get("X-User-Role")
+
+    if userID == "" {
+        utils.SendError(w, http.StatusUnauthorized, "Unauthorized")
+        return
+    }
+
+    if userRole != "patient" {
+        utils.SendError(w, http.StatusForbidden, "Only patients can record vital signs")
+        return
+    }
+
+    patientProfileID, err := h.repo.GetPatientProfileIDByUserID(r.Context(), userID)
+    if err != nil {
+        utils.SendErrorCode(w, apperrors.CodeProfileNotFound, "Patient profile not found")
+        return
+    }
+
+    var req CreateVitalsRequest
+    if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxVitalsBody)).Decode(&req); err != nil {
+        utils.SendError(w, http.StatusBadRequest, "Invalid request body")
+        return
+    }
+
+    if len(req.Readings) == 0 || len(req.Readings) > MaxVitalsBatch {
+        utils.SendErrorCode(w, apperrors.CodeValidation, fmt.Sprintf("Between 1 and %d readings are required", MaxVitalsBatch))
+        return
+    }
+
+    now := time.Now().UTC().Truncate(time.Microsecond)
+    readings := make([]models.VitalReading, len(req.Readings))
+    for i, item := range req.Readings {
+        reading, err := item.reading(now)
+        if err != nil {
+            message := err.Error()
+            var appErr *apperrors.Error
+            if errors.As(err, &appErr) {
+                message = appErr.Message
+            }
+            utils.SendErrorCode(w, apperrors.CodeValidation, fmt.Sprintf("readings[%d]: %s", i, message))
+            return
+        }
+        readings[i] = reading
+    }
+
+    created, err := h.repo.CreateVitals(r.Context(), patientProfileID, readings)
+    if err != nil {
+        utils.SendAppError(w, err, "Failed to record vital signs")
+        return
+    }
+
+    utils.SendSuccess(w, http.StatusCreated, "Vital signs recorded", CreateVitalsResponse{
+        Readings: created,
+        Skipped:  len(readings) - len(created),
+    })
+}
+
+// GetMyVitals lists the current patient's readings
+func (h *TimelineHandler) GetMyVitals(w http.ResponseWriter, r *http.Request) {
+    userID := r.Header.Get("X-User-ID")
+    userRole := r.Header.Get("X-User-Role")
+
+    if userID == "" {
+        utils.SendError(w, http.StatusUnauthorized, "Unauthorized")
+        return
+    }
+
+    if userRole != "patient" {
+        utils.SendError(w, http.StatusForbidden, "Only patients can view their vital signs")
+        return
+    }
+
+    patientProfileID, err := h.repo.GetPatientProfileIDByUserID(r.Context(), userID)
+    if err != nil {
+        utils.SendErrorCode(w, apperrors.CodeProfileNotFound, "Patient profile not found")
+        return
+    }
+
+    h.listVitals(w, r, patientProfileID)
+}
+
+// GetPatientVitals lists a patient's readings (for doctors with access)
+func (h *TimelineHandler) GetPatientVitals(w http.ResponseWriter, r *http.Request) {
+    patientProfileID, ok := h.readablePatient(w, r)
+    if !ok {
+        return
+    }
+
+    h.listVitals(w, r, patientProfileID)
+}
+
+// listVitals sends a page of readings, converted to the unit query
+// parameter when one is given. A unit only makes sense for one kind, so it
+// requires the kind filter.
+func (h *TimelineHandler) listVitals(w http.ResponseWriter, r *http.Request, patientProfileID string) {
+    page, err := pagination.Parse(r.URL.Query(), repository.VitalPages)
+    if err != nil {
+        utils.SendAppError(w, err, "Invalid pagination parameters")
+        return
+    }
+    if kind, ok := page.Filters["kind"]; ok {
+        if _, err := vitals.ParseKind(kind); err != nil {
+            utils.SendAppError(w, err, "Invalid kind")
+            return
+        }
+    }
+
+    unit := r.URL.Query().Get("unit")
+    if unit != "" && page.Filters["kind"] == "" {
+        utils.SendErrorCode(w, apperrors.CodeValidation, "unit requires kind")
+        return
+    }
+
+    readings, next, err := h.repo.GetVitalsByPatientID(r.Context(), patientProfileID, page)
+    if err != nil {
+        utils.SendAppError(w, err, "Failed to retrieve vital signs")
+        return
+    }
+
+    if readings == nil {
+        readings = []models.VitalReading{}
+    }
+    if err := vitals.Convert(readings, unit); err != nil {
+        utils.SendAppError(w, err, "Invalid unit")
+        return
+    }
+
+    utils.SendPage(w, http.StatusOK, "Vital signs retrieved", readings, next)
+}
+
+// GetVitalsSummary aggregates one kind of reading into daily or weekly
+// minimum, maximum and average. Patients summarize their own readings;
+// doctors name a patient who granted them access.
+func (h *TimelineHandler) GetVitalsSummary(w http.ResponseWriter, r *http.Request) {
+    patientProfileID, ok := h.readablePatient(w, r)
+    if !ok {
+        return
+    }
+
+    q := r.URL.Query()
+    kind, err := vitals.ParseKind(q.Get("kind"))
+    if err != nil {
+        utils.SendAppError(w, err, "Invalid kind")
+        return
+    }
+
+    bucket := q.Get("bucket")
+    if bucket == "" {
+        bucket = "day"
+    }
+    maxDays, ok := maxSummaryDays[bucket]
+    if !ok {
+        utils.SendErrorCode(w, apperrors.CodeValidation, "bucket must be day or week")
+        return
+    }
+
+    to := time.Now().UTC().Truncate(24 * time.Hour)
+    if raw := q.Get("to"); raw != "" {
+        if to, err = time.Parse("2006-01-02", raw); err != nil {
+            utils.SendErrorCode(w, apperrors.CodeValidation, "Invalid to date. Use YYYY-MM-DD")
+            return
+        }
+    }
+    from := to.AddDate(0, 0, 1-defaultSummaryDays[bucket])
+    if raw := q.Get("from"); raw != "" {
+        if from, err = time.Parse("2006-01-02", raw); err != nil {
+            utils.SendErrorCode(w, apperrors.CodeValidation, "Invalid from date. Use YYYY-MM-DD")
+            return
+        }
+    }
+    if to.Before(from) {
+        utils.SendErrorCode(w, apperrors.CodeValidation, "from must not be after to")
+        return
+    }
+    if to.Sub(from) >= time.Duration(maxDays)*24*time.Hour {
+        utils.SendErrorCode(w, apperrors.CodeValidation, fmt.Sprintf("A %s summary may span at most %d days", bucket, maxDays))
+        return
+    }
+
+    buckets, err := h.repo.SummarizeVitals(r.Context(), patientProfileID, repository.VitalRange{
+        Kind:   kind,
+        Bucket: bucket,
+        From:   from,
+        To:     to.AddDate(0, 0, 1),
+    })
+    if err != nil {
+        utils.SendAppError(w, err, "Failed to summarize vital signs")
+        return
+    }
+
+    unit, err := vitals.ConvertBuckets(kind, buckets, q.Get("unit"))
+    if err != nil {
+        utils.SendAppError(w, err, "Invalid unit")
+        return
+    }
+
+    utils.SendSuccess(w, http.StatusOK, "Vital signs summarized", VitalsSummaryResponse{
+        Kind:    kind,
+        Unit:    unit,
+        Bucket:  bucket,
+        From:    pagination.Date(from),
+        To:      pagination.Date(to),
+        Buckets: buckets,
+    })
+}
+
+// GetVitalKinds lists the kinds of vital sign with their units and
+// plausible ranges
+func (h *TimelineHandler) GetVitalKinds(w http.ResponseWriter, r *http.Request) {
+    utils.SendSuccess(w, http.StatusOK, "Vital sign kinds retrieved", vitals.Specs())
+}
+
+// DeleteVital deletes one of the current patient's readings
+func (h *TimelineHandler) DeleteVital(w http.ResponseWriter, r *http.Request) {
+    userID := r.Header.Get("X-User-ID")
+    userRole := r.Header.Get("X-User-Role")
+
+    if userID == "" {
+        utils.SendError(w, http.StatusUnauthorized, "Unauthorized")
+        return
+    }
+
+    if userRole != "patient" {
+        utils.SendError(w, http.StatusForbidden, "Only patients can delete vital signs")
+        return
+    }
+
+    readingID := r.URL.Query().Get("id")
+    if readingID == "" {
+        utils.SendErrorCode(w, apperrors.CodeValidation, "Reading ID is required")
+        return
+    }
+
+    patientProfileID, err := h.repo.GetPatientProfileIDByUserID(r.Context(), userID)
+    if err != nil {
+        utils.SendErrorCode(w, apperrors.CodeProfileNotFound, "Patient profile not found")
+        return
+    }
+
+    // Another patient's reading is reported as missing rather than forbidden
+    reading, err := h.repo.GetVitalByID(r.Context(), readingID)
+    if err != nil || reading.PatientID != patientProfileID {
+        if err != nil && err != sql.ErrNoRows {
+            utils.SendAppError(w, err, "Failed to delete reading")
+            return
+        }
+        utils.SendError(w, http.StatusNotFound, "Reading not found")
+        return
+    }
+
+    if err := h.repo.DeleteVital(r.Context(), readingID); err != nil {
+        utils.SendAppError(w, err, "Failed to delete reading")
+        return
+    }
+
+    utils.SendSuccess(w, http.StatusOK, "Reading deleted", nil)
+}
+
+// readablePatient resolves whose records a request reads: the patient's own,
+// or for a doctor the patient_id they were granted access to. It writes the
+// error response and returns false when access is refused.
+func (h *TimelineHandler) readablePatient(w http.ResponseWriter, r *http.Request) (string, bool) {
+    userID := r.Header.Get("X-User-ID")
+    userRole := r.Header.Get("X-User-Role")
+
+    if userID == "" {
+        utils.SendError(w, http.StatusUnauthorized, "Unauthorized")
+        return "", false
+    }
+
+    patientProfileID := r.URL.Query().Get("patient_id")
+
+    switch userRole {
+    case "patient":
+        myProfileID, err := h.repo.GetPatientProfileIDByUserID(r.Context(), userID)
+        if err != nil {
+            utils.SendErrorCode(w, apperrors.CodeProfileNotFound, "Patient profile not found")
+            return "", false
+        }
+        if patientProfileID != "" && patientProfileID != myProfileID {
+            utils.SendErrorCode(w, apperrors.CodeAccessDenied, "Access denied")
+            return "", false
+        }
+        return myProfileID, true
+    case "doctor":
+        if patientProfileID == "" {
+            utils.SendErrorCode(w, apperrors.CodeValidation, "Patient ID is required")
+            return "", false
+        }
+        hasAccess, err := h.repo.CheckDoctorAccess(r.Context(), userID, patientProfileID)
+        if err != nil || !hasAccess {
+            utils.SendErrorCode(w, apperrors.CodeAccessDenied, "Access denied")
+            return "", false
+        }
+        return patientProfileID, true
+    }
+
+    utils.SendErrorCode(w, apperrors.CodeAccessDenied, "Access denied")
+    return "", false
+}
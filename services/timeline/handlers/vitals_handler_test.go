@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"health-bar/shared/apperrors"
+	"health-bar/shared/models"
+	"health-bar/shared/testutil"
+)
+
+func num(v float64) *float64 { return &v }
+
+func recordVitals(t *testing.T, h *TimelineHandler, userID string, readings ...VitalReadingRequest) CreateVitalsResponse {
+	t.Helper()
+
+	req := testutil.NewRequest(t, http.MethodPost, "/api/timeline/vitals", CreateVitalsRequest{Readings: readings}, userID, "patient")
+	rec, resp := testutil.Serve(t, h.CreateVitals, req)
+	testutil.ExpectStatus(t, rec, http.StatusCreated)
+
+	var created CreateVitalsResponse
+	testutil.DecodeData(t, resp, &created)
+	return created
+}
+
+func TestCreateVitalsBatch(t *testing.T) {
+	h, db := newTestHandler()
+	patient := db.AddPatient("p@test.com", "Pat")
+
+	batch := []VitalReadingRequest{
+		{Kind: "weight", Value: num(154), Unit: "lb", MeasuredAt: "2024-03-01T07:30:00Z"},
+		{Kind: "blood_pressure", Value: num(121), Diastolic: num(79), MeasuredAt: "2024-03-01T07:31:00+01:00", Source: "cuff"},
+		{Kind: "temperature", Value: num(99.5), Unit: "F", MeasuredAt: "2024-03-01T08:00:00Z"},
+	}
+	created := recordVitals(t, h, patient.UserID, batch...)
+	if len(created.Readings) != 3 || created.Skipped != 0 {
+		t.Fatalf("created = %+v", created)
+	}
+	// Sorted by measurement time; the +01:00 reading is the earliest
+	first, weight, temp := created.Readings[0], created.Readings[1], created.Readings[2]
+	if first.Kind != models.VitalBloodPressure || *first.Diastolic != 79 || first.Unit != "mmHg" {
+		t.Fatalf("blood pressure = %+v", first)
+	}
+	if weight.Value != 69.8532 || weight.Unit != "kg" {
+		t.Fatalf("weight = %+v", weight)
+	}
+	if temp.Value != 37.5 || temp.Unit != "C" {
+		t.Fatalf("temperature = %+v", temp)
+	}
+
+	// Re-sending a batch only stores what is new
+	again := recordVitals(t, h, patient.UserID, append(batch, VitalReadingRequest{Kind: "spo2", Value: num(97)})...)
+	if len(again.Readings) != 1 || again.Skipped != 3 {
+		t.Fatalf("resend = %+v", again)
+	}
+
+	// One bad reading rejects the whole batch
+	req := testutil.NewRequest(t, http.MethodPost, "/api/timeline/vitals", CreateVitalsRequest{Readings: []VitalReadingRequest{
+		{Kind: "heart_rate", Value: num(64), MeasuredAt: "2024-03-02T07:00:00Z"},
+		{Kind: "heart_rate", Value: num(64), Unit: "mmHg", MeasuredAt: "2024-03-02T08:00:00Z"},
+	}}, patient.UserID, "patient")
+	rec, resp := testutil.Serve(t, h.CreateVitals, req)
+	testutil.ExpectStatus(t, rec, http.StatusBadRequest)
+	testutil.ExpectCode(t, resp, apperrors.CodeValidation)
+	if !strings.HasPrefix(resp.Error, "readings[1]: ") {
+		t.Fatalf("error = %q", resp.Error)
+	}
+	if n := len(db.VitalReadings.Rows); n != 4 {
+		t.Fatalf("stored %d readings, want 4", n)
+	}
+}
+
+func TestVitalsSummary(t *testing.T) {
+	h, db := newTestHandler()
+	patient := db.AddPatient("p@test.com", "Pat")
+
+	// 2024-03-04 is a Monday
+	recordVitals(t, h, patient.UserID,
+		VitalReadingRequest{Kind: "glucose", Value: num(90), MeasuredAt: "2024-03-04T07:00:00Z"},
+		VitalReadingRequest{Kind: "glucose", Value: num(140), MeasuredAt: "2024-03-04T13:00:00Z"},
+		VitalReadingRequest{Kind: "glucose", Value: num(100), MeasuredAt: "2024-03-06T07:00:00Z"},
+		VitalReadingRequest{Kind: "glucose", Value: num(110), MeasuredAt: "2024-03-11T07:00:00Z"},
+		VitalReadingRequest{Kind: "heart_rate", Value: num(70), MeasuredAt: "2024-03-04T07:00:00Z"},
+	)
+
+	summarize := func(query string) VitalsSummaryResponse {
+		t.Helper()
+
+		req := testutil.NewRequest(t, http.MethodGet, "/api/timeline/vitals/summary?"+query, nil, patient.UserID, "patient")
+		rec, resp := testutil.Serve(t, h.GetVitalsSummary, req)
+		testutil.ExpectStatus(t, rec, http.StatusOK)
+
+		var summary VitalsSummaryResponse
+		testutil.DecodeData(t, resp, &summary)
+		return summary
+	}
+
+	daily := summarize("kind=glucose&from=2024-03-01&to=2024-03-10")
+	if len(daily.Buckets) != 2 || daily.Unit != "mg/dL" {
+		t.Fatalf("daily = %+v", daily)
+	}
+	if b := daily.Buckets[0]; b.Start.Format("2006-01-02") != "2024-03-04" || b.Count != 2 || b.Min != 90 || b.Max != 140 || b.Avg != 115 {
+		t.Fatalf("first day = %+v", b)
+	}
+
+	weekly := summarize("kind=glucose&bucket=week&from=2024-03-01&to=2024-03-31&unit=mmol/L")
+	if len(weekly.Buckets) != 2 || weekly.Unit != "mmol/L" {
+		t.Fatalf("weekly = %+v", weekly)
+	}
+	if b := weekly.Buckets[0]; b.Start.Format("2006-01-02") != "2024-03-04" || b.Count != 3 || b.Min != 5 || b.Max != 7.77 {
+		t.Fatalf("first week = %+v", b)
+	}
+
+	for _, query := range []string{
+		"bucket=day",
+		"kind=glucose&bucket=month",
+		"kind=glucose&unit=kg",
+		"kind=glucose&from=2022-01-01&to=2024-01-01",
+	} {
+		req := testutil.NewRequest(t, http.MethodGet, "/api/timeline/vitals/summary?"+query, nil, patient.UserID, "patient")
+		rec, _ := testutil.Serve(t, h.GetVitalsSummary, req)
+		testutil.ExpectStatus(t, rec, http.StatusBadRequest)
+	}
+}
+
+func TestPatientVitalsAccessControl(t *testing.T) {
+	h, db := newTestHandler()
+	patient := db.AddPatient("p@test.com", "Pat")
+	other := db.AddPatient("o@test.com", "Other")
+	doctor := db.AddDoctor("d@test.com", "Dr Who")
+	recordVitals(t, h, patient.UserID, VitalReadingRequest{Kind: "heart_rate", Value: num(64)})
+
+	view := func(userID, role string) int {
+		req := testutil.NewRequest(t, http.MethodGet, "/api/timeline/vitals/patient?patient_id="+patient.ID, nil, userID, role)
+		rec, _ := testutil.Serve(t, h.GetPatientVitals, req)
+		return rec.Code
+	}
+
+	if code := view(patient.UserID, "patient"); code != http.StatusOK {
+		t.Fatalf("owner: status = %d, want 200", code)
+	}
+	if code := view(other.UserID, "patient"); code != http.StatusForbidden {
+		t.Fatalf("other patient: status = %d, want 403", code)
+	}
+	if code := view(doctor.UserID, "doctor"); code != http.StatusForbidden {
+		t.Fatalf("doctor without grant: status = %d, want 403", code)
+	}
+
+	db.Grant(patient.ID, doctor.ID)
+	if code := view(doctor.UserID, "doctor"); code != http.StatusOK {
+		t.Fatalf("doctor with grant: status = %d, want 200", code)
+	}
+
+	// Doctors can read but not delete
+	reading := recordVitals(t, h, patient.UserID, VitalReadingRequest{Kind: "spo2", Value: num(98)}).Readings[0]
+	req := testutil.NewRequest(t, http.MethodDelete, "/api/timeline/vital?id="+reading.ID, nil, doctor.UserID, "doctor")
+	rec, _ := testutil.Serve(t, h.DeleteVital, req)
+	testutil.ExpectStatus(t, rec, http.StatusForbidden)
+
+	req = testutil.NewRequest(t, http.MethodDelete, "/api/timeline/vital?id="+reading.ID, nil, other.UserID, "patient")
+	rec, _ = testutil.Serve(t, h.DeleteVital, req)
+	testutil.ExpectStatus(t, rec, http.StatusNotFound)
+}
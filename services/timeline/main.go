@@ -1,13 +1,19 @@
 package main
 
 import (
+    "context"
     "health-bar/shared/database"
-    "health-bar/shared/middleware"
+    "health-bar/shared/idempotency"
+    "health-bar/shared/immunization"
+    "health-bar/shared/notify"
+    "health-bar/shared/webhooks"
     "health-bar/services/timeline/handlers"
+    "health-bar/services/timeline/reminders"
     "health-bar/services/timeline/repository"
     "log"
     "net/http"
     "os"
+    "time"
     "github.com/gorilla/mux"
     "github.com/joho/godotenv"
     "github.com/rs/cors"
@@ -30,22 +36,39 @@ func main() {
     defer db.Close()
 
     repo := repository.NewTimelineRepository(db)
+    notifier := notify.NewPostgresNotifier(db)
     handler := handlers.NewTimelineHandler(repo)
+    handler.UseNotifier(notifier)
+    handler.UseWebhooks(webhooks.NewPostgresEmitter(db))
 
-    router := mux.NewRouter()
+    schedule := immunization.Default()
+    if path := os.Getenv("IMMUNIZATION_SCHEDULE"); path != "" {
+        if schedule, err = immunization.Load(path); err != nil {
+            log.Fatal("Failed to load immunization schedule:", err)
+        }
+        handler.UseSchedule(schedule)
+    }
+
+    // IMMUNIZATION_REMINDER_INTERVAL=0 turns reminders off
+    interval, err := time.ParseDuration(getEnv("IMMUNIZATION_REMINDER_INTERVAL", "24h"))
+    if err != nil {
+        log.Fatal("Invalid IMMUNIZATION_REMINDER_INTERVAL:", err)
+    }
+    if interval > 0 {
+        go reminders.NewImmunizations(repo, schedule, notifier).RunEvery(context.Background(), interval)
+    }
 
-    // Timeline routes (protected)
-    router.HandleFunc("/api/timeline/visits", middleware.AuthMiddleware(handler.CreateVisit)).Methods("POST")
-    router.HandleFunc("/api/timeline/my", middleware.AuthMiddleware(handler.GetMyTimeline)).Methods("GET")
-    router.HandleFunc("/api/timeline/patient", middleware.AuthMiddleware(handler.GetPatientTimeline)).Methods("GET")
-    router.HandleFunc("/api/timeline/visit", middleware.AuthMiddleware(handler.GetVisit)).Methods("GET")
-    router.HandleFunc("/api/timeline/visit", middleware.AuthMiddleware(handler.UpdateVisit)).Methods("PUT")
-    router.HandleFunc("/api/timeline/visit", middleware.AuthMiddleware(handler.DeleteVisit)).Methods("DELETE")
+    keys := idempotency.NewPostgresStore(db)
+    go keys.PurgeEvery(context.Background(), time.Hour)
+
+    router := mux.NewRouter()
+    handlers.RegisterRoutes(router, handler, keys)
 
     c := cors.New(cors.Options{
         AllowedOrigins:   []string{"http://localhost:3000"},
-        AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-        AllowedHeaders:   []string{"Content-Type", "Authorization"},
+        AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+        AllowedHeaders:   []string{"Content-Type", "Authorization", "If-Match", "Idempotency-Key"},
+        ExposedHeaders:   []string{"ETag", "Idempotent-Replayed"},
         AllowCredentials: true,
     })
 
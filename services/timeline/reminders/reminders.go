@@ -0,0 +1,129 @@
+// Package reminders tells patients about vaccine doses that fall due. A
+// dose is reminded about once when it becomes due and once more if it is
+// still missing when it becomes overdue.
+package reminders
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"health-bar/services/timeline/repository"
+	"health-bar/shared/immunization"
+	"health-bar/shared/notify"
+)
+
+// batchSize is how many patients are read at a time.
+const batchSize = 200
+
+// Immunizations sends due-dose reminders.
+type Immunizations struct {
+	repo     repository.Store
+	schedule *immunization.Schedule
+	notifier notify.Notifier
+}
+
+func NewImmunizations(repo repository.Store, schedule *immunization.Schedule, notifier notify.Notifier) *Immunizations {
+	return &Immunizations{repo: repo, schedule: schedule, notifier: notifier}
+}
+
+// Send reminds every patient about the doses due or overdue on today that
+// they were not yet reminded about, and returns how many reminders it sent.
+// Only patients who keep an immunization record are reminded: for the rest
+// every dose would look missing. A failure for one patient is logged and
+// does not stop the others.
+func (s *Immunizations) Send(ctx context.Context, today time.Time) (int, error) {
+	sent := 0
+	after := ""
+	for {
+		patients, err := s.repo.GetPatientProfilesAfter(ctx, after, batchSize)
+		if err != nil {
+			return sent, err
+		}
+		for _, p := range patients {
+			n, err := s.sendTo(ctx, p.ID, p.UserID, p.DateOfBirth, today)
+			sent += n
+			if err != nil {
+				if ctx.Err() != nil {
+					return sent, ctx.Err()
+				}
+				log.Printf("reminders: patient %s: %v", p.ID, err)
+			}
+		}
+		if len(patients) < batchSize {
+			return sent, nil
+		}
+		after = patients[len(patients)-1].ID
+	}
+}
+
+func (s *Immunizations) sendTo(ctx context.Context, patientID, userID string, dob, today time.Time) (int, error) {
+	given, err := s.repo.ListImmunizations(ctx, patientID)
+	if err != nil || len(given) == 0 {
+		return 0, err
+	}
+
+	sent := 0
+	for _, dose := range s.schedule.Due(dob, given, today, 0) {
+		if dose.Status == immunization.Upcoming {
+			continue
+		}
+		// Recording and sending share a transaction so a failed delivery
+		// is retried on the next run
+		err := s.repo.WithTx(ctx, func(ctx context.Context) error {
+			fresh, err := s.repo.RecordImmunizationReminder(ctx, patientID, dose.Vaccine, dose.DoseNumber, string(dose.Status))
+			if err != nil || !fresh {
+				return err
+			}
+			if err := s.notifier.Notify(ctx, message(userID, dose)); err != nil {
+				return err
+			}
+			sent++
+			return nil
+		})
+		if err != nil {
+			return sent, err
+		}
+	}
+	return sent, nil
+}
+
+func message(userID string, dose immunization.DueDose) notify.Message {
+	subject := fmt.Sprintf("%s dose %d is due on %s", dose.Name, dose.DoseNumber, dose.DueOn)
+	if dose.Status == immunization.Overdue {
+		subject = fmt.Sprintf("%s dose %d is overdue since %s", dose.Name, dose.DoseNumber, dose.DueOn)
+	}
+	return notify.Message{
+		UserID:  userID,
+		Kind:    "immunization_" + string(dose.Status),
+		Subject: subject,
+		Body:    subject + ". Record it in your immunizations once you have received it.",
+		Data: map[string]string{
+			"vaccine":     dose.Vaccine,
+			"dose_number": strconv.Itoa(dose.DoseNumber),
+			"due_on":      dose.DueOn,
+		},
+	}
+}
+
+// RunEvery sends reminders now and then every interval until ctx is done.
+func (s *Immunizations) RunEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if n, err := s.Send(ctx, time.Now().UTC()); err != nil {
+			log.Printf("reminders: %v", err)
+		} else if n > 0 {
+			log.Printf("reminders: sent %d immunization reminders", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
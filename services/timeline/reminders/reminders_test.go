@@ -0,0 +1,86 @@
+package reminders
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"health-bar/services/timeline/repository"
+	"health-bar/shared/immunization"
+	"health-bar/shared/memdb"
+	"health-bar/shared/models"
+	"health-bar/shared/notify"
+
+	"github.com/google/uuid"
+)
+
+type recorder struct {
+	messages []notify.Message
+	err      error
+}
+
+func (r *recorder) Notify(ctx context.Context, msg notify.Message) error {
+	if r.err != nil {
+		return r.err
+	}
+	r.messages = append(r.messages, msg)
+	return nil
+}
+
+func addImmunization(db *memdb.DB, patientID, vaccine string, dose int, on time.Time) {
+	db.Lock()
+	defer db.Unlock()
+
+	id := uuid.New().String()
+	db.Immunizations.Rows[id] = models.Immunization{ID: id, PatientID: patientID, Vaccine: vaccine, DoseNumber: dose, AdministeredOn: on}
+}
+
+func TestSendRemindsOncePerStatus(t *testing.T) {
+	db := memdb.New()
+	tracked := db.AddPatient("tracked@test.com", "Tracked")
+	db.AddPatient("untracked@test.com", "Untracked")
+
+	// Born 1990-01-01, so only vaccines without an age limit remain
+	schedule, err := immunization.Parse([]byte(`{"grace_days": 30, "vaccines": [
+		{"code": "tdap", "name": "Tdap", "doses": [{"min_age_months": 132}], "booster_every_months": 120}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	addImmunization(db, tracked.ID, "tdap", 1, time.Date(2015, 5, 1, 0, 0, 0, 0, time.UTC))
+
+	notifier := &recorder{}
+	s := NewImmunizations(repository.NewMemoryRepository(db), schedule, notifier)
+	ctx := context.Background()
+
+	// Failed deliveries are not recorded, so the next run retries them
+	notifier.err = errors.New("smtp down")
+	if n, _ := s.Send(ctx, time.Date(2025, 5, 10, 0, 0, 0, 0, time.UTC)); n != 0 || len(db.ImmunizationReminders.Rows) != 0 {
+		t.Fatalf("sent %d with a failing notifier", n)
+	}
+	notifier.err = nil
+
+	for _, tc := range []struct {
+		day  time.Time
+		sent int
+		kind string
+	}{
+		{time.Date(2025, 5, 10, 0, 0, 0, 0, time.UTC), 1, "immunization_due"},
+		{time.Date(2025, 5, 20, 0, 0, 0, 0, time.UTC), 0, ""},
+		{time.Date(2025, 6, 15, 0, 0, 0, 0, time.UTC), 1, "immunization_overdue"},
+		{time.Date(2025, 7, 15, 0, 0, 0, 0, time.UTC), 0, ""},
+	} {
+		before := len(notifier.messages)
+		n, err := s.Send(ctx, tc.day)
+		if err != nil || n != tc.sent {
+			t.Fatalf("%s: sent %d, %v; want %d", tc.day.Format("2006-01-02"), n, err, tc.sent)
+		}
+		if tc.sent > 0 {
+			msg := notifier.messages[before]
+			if msg.UserID != tracked.UserID || msg.Kind != tc.kind || msg.Data["dose_number"] != "2" {
+				t.Fatalf("%s: message = %+v", tc.day.Format("2006-01-02"), msg)
+			}
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package repository
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+
+	"health-bar/shared/memdb"
+	"health-bar/shared/models"
+	"health-bar/shared/pagination"
+
+	"github.com/google/uuid"
+)
+
+func (r *MemoryRepository) CreateImmunization(ctx context.Context, patientID string, imm *models.Immunization) error {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	if _, ok := r.db.PatientProfiles.Rows[patientID]; !ok {
+		return memdb.ForeignKeyViolation("immunizations", "immunizations_patient_id_fkey")
+	}
+	for _, existing := range r.db.Immunizations.Rows {
+		if existing.PatientID == patientID && existing.Vaccine == imm.Vaccine && existing.DoseNumber == imm.DoseNumber {
+			return memdb.UniqueViolation("immunizations_patient_id_vaccine_dose_number_key")
+		}
+	}
+
+	imm.ID = uuid.New().String()
+	imm.PatientID = patientID
+	imm.CreatedAt = r.db.Now()
+	r.db.Immunizations.Rows[imm.ID] = *imm
+	return nil
+}
+
+func (r *MemoryRepository) GetImmunizationByID(ctx context.Context, id string) (*models.Immunization, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	imm, ok := r.db.Immunizations.Rows[id]
+	if !ok {
+		return &models.Immunization{}, sql.ErrNoRows
+	}
+	return &imm, nil
+}
+
+func (r *MemoryRepository) GetImmunizationsByPatientID(ctx context.Context, patientID string, page ImmunizationPage) ([]models.Immunization, string, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	vaccine, filtered := page.Filters["vaccine"]
+	var imms []models.Immunization
+	for _, imm := range r.db.Immunizations.Rows {
+		if imm.PatientID != patientID || !page.InRange(imm.AdministeredOn) {
+			continue
+		}
+		if filtered && imm.Vaccine != vaccine {
+			continue
+		}
+		imms = append(imms, imm)
+	}
+	imms, next := pagination.Apply(imms, page)
+	return imms, next, nil
+}
+
+func (r *MemoryRepository) ListImmunizations(ctx context.Context, patientID string) ([]models.Immunization, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	imms := []models.Immunization{}
+	for _, imm := range r.db.Immunizations.Rows {
+		if imm.PatientID == patientID {
+			imms = append(imms, imm)
+		}
+	}
+	slices.SortFunc(imms, func(a, b models.Immunization) int {
+		return cmp.Or(a.AdministeredOn.Compare(b.AdministeredOn), cmp.Compare(a.ID, b.ID))
+	})
+	return imms, nil
+}
+
+func (r *MemoryRepository) DeleteImmunization(ctx context.Context, id string) error {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	delete(r.db.Immunizations.Rows, id)
+	return nil
+}
+
+func (r *MemoryRepository) GetPatientProfilesAfter(ctx context.Context, afterID string, limit int) ([]models.PatientProfile, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	profiles := []models.PatientProfile{}
+	for _, p := range r.db.PatientProfiles.Rows {
+		if p.ID > afterID {
+			profiles = append(profiles, p)
+		}
+	}
+	slices.SortFunc(profiles, func(a, b models.PatientProfile) int { return cmp.Compare(a.ID, b.ID) })
+	if len(profiles) > limit {
+		profiles = profiles[:limit]
+	}
+	return profiles, nil
+}
+
+func (r *MemoryRepository) RecordImmunizationReminder(ctx context.Context, patientID, vaccine string, doseNumber int, status string) (bool, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	if _, ok := r.db.PatientProfiles.Rows[patientID]; !ok {
+		return false, memdb.ForeignKeyViolation("immunization_reminders", "immunization_reminders_patient_id_fkey")
+	}
+
+	key := fmt.Sprintf("%s/%s/%d/%s", patientID, vaccine, doseNumber, status)
+	if _, ok := r.db.ImmunizationReminders.Rows[key]; ok {
+		return false, nil
+	}
+	r.db.ImmunizationReminders.Rows[key] = models.ImmunizationReminder{
+		PatientID:  patientID,
+		Vaccine:    vaccine,
+		DoseNumber: doseNumber,
+		Status:     status,
+		SentAt:     r.db.Now(),
+	}
+	return true, nil
+}
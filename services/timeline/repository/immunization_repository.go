@@ -0,0 +1,128 @@
+package repository
+
+import (
+    "context"
+    "health-bar/shared/database"
+    "health-bar/shared/models"
+    "health-bar/shared/pagination"
+    "github.com/google/uuid"
+)
+
+const immunizationColumns = `id, patient_id, vaccine, vaccine_name, dose_number, lot_number, administered_on, facility, notes, created_at`
+
+// CreateImmunization records a vaccine dose
+func (r *TimelineRepository) CreateImmunization(ctx context.Context, patientID string, imm *models.Immunization) error {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    query := `
+        INSERT INTO immunizations (id, patient_id, vaccine, vaccine_name, dose_number, lot_number, administered_on, facility, notes)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+        RETURNING ` + immunizationColumns
+
+    return database.Conn(ctx, r.db).QueryRowxContext(ctx, query,
+        uuid.New().String(), patientID, imm.Vaccine, imm.VaccineName, imm.DoseNumber,
+        imm.LotNumber, imm.AdministeredOn, imm.Facility, imm.Notes,
+    ).StructScan(imm)
+}
+
+// GetImmunizationByID gets a vaccine dose by ID
+func (r *TimelineRepository) GetImmunizationByID(ctx context.Context, id string) (*models.Immunization, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    imm := &models.Immunization{}
+    query := `SELECT ` + immunizationColumns + ` FROM immunizations WHERE id = $1`
+    if err := database.Conn(ctx, r.db).GetContext(ctx, imm, query, id); err != nil {
+        return nil, err
+    }
+    return imm, nil
+}
+
+// GetImmunizationsByPatientID gets a page of a patient's vaccine doses and the cursor of the next page
+func (r *TimelineRepository) GetImmunizationsByPatientID(ctx context.Context, patientID string, page ImmunizationPage) ([]models.Immunization, string, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    q := &pagination.Query{}
+    q.Where("patient_id = " + q.Arg(patientID))
+    if !page.From.IsZero() {
+        q.Where("administered_on >= " + q.Arg(pagination.Date(page.From)) + "::date")
+    }
+    if !page.To.IsZero() {
+        q.Where("administered_on <= " + q.Arg(pagination.Date(page.To)) + "::date")
+    }
+    if vaccine, ok := page.Filters["vaccine"]; ok {
+        q.Where("vaccine = " + q.Arg(vaccine))
+    }
+
+    var imms []models.Immunization
+    query := `SELECT ` + immunizationColumns + ` FROM immunizations` + page.Clauses(q)
+    if err := database.Conn(ctx, r.db).SelectContext(ctx, &imms, query, q.Args()...); err != nil {
+        return nil, "", err
+    }
+    imms, next := pagination.Page(imms, page)
+    return imms, next, nil
+}
+
+// ListImmunizations gets all of a patient's vaccine doses, oldest first
+func (r *TimelineRepository) ListImmunizations(ctx context.Context, patientID string) ([]models.Immunization, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    imms := []models.Immunization{}
+    query := `SELECT ` + immunizationColumns + ` FROM immunizations WHERE patient_id = $1 ORDER BY administered_on, id`
+    err := database.Conn(ctx, r.db).SelectContext(ctx, &imms, query, patientID)
+    return imms, err
+}
+
+// DeleteImmunization deletes a vaccine dose
+func (r *TimelineRepository) DeleteImmunization(ctx context.Context, id string) error {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    query := `DELETE FROM immunizations WHERE id = $1`
+    _, err := database.Conn(ctx, r.db).ExecContext(ctx, query, id)
+    return err
+}
+
+// GetPatientProfilesAfter gets up to limit patient profiles with IDs after afterID, in ID order.
+// Pass an empty afterID for the first batch.
+func (r *TimelineRepository) GetPatientProfilesAfter(ctx context.Context, afterID string, limit int) ([]models.PatientProfile, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    if afterID == "" {
+        afterID = uuid.Nil.String()
+    }
+
+    profiles := []models.PatientProfile{}
+    query := `
+        SELECT id, user_id, full_name, date_of_birth, gender, phone, address, created_at, updated_at, version
+        FROM patient_profiles
+        WHERE id > $1::uuid
+        ORDER BY id
+        LIMIT $2
+    `
+    err := database.Conn(ctx, r.db).SelectContext(ctx, &profiles, query, afterID, limit)
+    return profiles, err
+}
+
+// RecordImmunizationReminder notes that a reminder about a dose in the given status was sent.
+// It returns false when one already was.
+func (r *TimelineRepository) RecordImmunizationReminder(ctx context.Context, patientID, vaccine string, doseNumber int, status string) (bool, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    query := `
+        INSERT INTO immunization_reminders (patient_id, vaccine, dose_number, status)
+        VALUES ($1, $2, $3, $4)
+        ON CONFLICT DO NOTHING
+    `
+    result, err := database.Conn(ctx, r.db).ExecContext(ctx, query, patientID, vaccine, doseNumber, status)
+    if err != nil {
+        return false, err
+    }
+    n, err := result.RowsAffected()
+    return n == 1, err
+}
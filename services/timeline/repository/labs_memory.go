@@ -0,0 +1,155 @@
+package repository
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"slices"
+	"time"
+
+	"health-bar/shared/memdb"
+	"health-bar/shared/models"
+	"health-bar/shared/pagination"
+
+	"github.com/google/uuid"
+)
+
+func (r *MemoryRepository) CreateLabPanel(ctx context.Context, patientID string, panel *models.LabPanel) error {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	if _, ok := r.db.PatientProfiles.Rows[patientID]; !ok {
+		return memdb.ForeignKeyViolation("lab_panels", "lab_panels_patient_id_fkey")
+	}
+	if panel.VisitID != nil {
+		if _, ok := r.db.HospitalVisits.Rows[*panel.VisitID]; !ok {
+			return memdb.ForeignKeyViolation("lab_panels", "lab_panels_visit_id_fkey")
+		}
+	}
+	if panel.DocumentID != nil {
+		if _, ok := r.db.Prescriptions.Rows[*panel.DocumentID]; !ok {
+			return memdb.ForeignKeyViolation("lab_panels", "lab_panels_document_id_fkey")
+		}
+	}
+	seen := map[string]bool{}
+	for _, res := range panel.Results {
+		if seen[res.Analyte] {
+			return memdb.UniqueViolation("lab_results_panel_id_analyte_key")
+		}
+		seen[res.Analyte] = true
+	}
+
+	now := r.db.Now()
+	panel.ID = uuid.New().String()
+	panel.PatientID = patientID
+	panel.CreatedAt = now
+	panel.UpdatedAt = now
+
+	results := make([]models.LabResult, len(panel.Results))
+	for i, res := range panel.Results {
+		res.ID = uuid.New().String()
+		res.PanelID = panel.ID
+		res.PatientID = patientID
+		res.CollectedAt = panel.CollectedAt
+		res.CreatedAt = now
+		r.db.LabResults.Rows[res.ID] = res
+		results[i] = res
+	}
+	sortLabResults(results)
+
+	panel.Results = nil
+	r.db.LabPanels.Rows[panel.ID] = *panel
+	panel.Results = results
+	return nil
+}
+
+func (r *MemoryRepository) GetLabPanel(ctx context.Context, id string) (*models.LabPanel, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	panel, ok := r.db.LabPanels.Rows[id]
+	if !ok {
+		return &models.LabPanel{}, sql.ErrNoRows
+	}
+	panel.Results = r.labResults(panel.ID)
+	return &panel, nil
+}
+
+func (r *MemoryRepository) GetLabPanelsByPatientID(ctx context.Context, patientID string, page LabPanelPage) ([]models.LabPanel, string, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	abnormal := page.Filters["abnormal"] == "true"
+	var panels []models.LabPanel
+	for _, p := range r.db.LabPanels.Rows {
+		if p.PatientID != patientID || !page.InRange(p.CollectedAt) {
+			continue
+		}
+		p.Results = r.labResults(p.ID)
+		if abnormal && !slices.ContainsFunc(p.Results, func(res models.LabResult) bool { return res.Flag.Abnormal() }) {
+			continue
+		}
+		panels = append(panels, p)
+	}
+	panels, next := pagination.Apply(panels, page)
+	return panels, next, nil
+}
+
+func (r *MemoryRepository) DeleteLabPanel(ctx context.Context, id string) error {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	memdb.Delete(r.db, "lab_panels", r.db.LabPanels, id)
+	return nil
+}
+
+func (r *MemoryRepository) GetLabTrend(ctx context.Context, patientID, analyte string, from, to time.Time) ([]models.LabResult, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	results := []models.LabResult{}
+	for _, res := range r.db.LabResults.Rows {
+		if res.PatientID == patientID && res.Analyte == analyte && !res.CollectedAt.Before(from) && res.CollectedAt.Before(to) {
+			results = append(results, res)
+		}
+	}
+	slices.SortFunc(results, func(a, b models.LabResult) int {
+		return cmp.Or(a.CollectedAt.Compare(b.CollectedAt), cmp.Compare(a.ID, b.ID))
+	})
+	return results, nil
+}
+
+func (r *MemoryRepository) GetPatientProfile(ctx context.Context, patientID string) (*models.PatientProfile, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	profile, ok := r.db.PatientProfiles.Rows[patientID]
+	if !ok {
+		return &models.PatientProfile{}, sql.ErrNoRows
+	}
+	return &profile, nil
+}
+
+func (r *MemoryRepository) GetDocumentPatientID(ctx context.Context, documentID string) (string, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	doc, ok := r.db.Prescriptions.Rows[documentID]
+	if !ok {
+		return "", sql.ErrNoRows
+	}
+	return doc.PatientID, nil
+}
+
+// labResults lists a panel's results ordered by name. The caller must hold
+// the lock.
+func (r *MemoryRepository) labResults(panelID string) []models.LabResult {
+	results := []models.LabResult{}
+	for _, res := range r.db.LabResults.Rows {
+		if res.PanelID == panelID {
+			results = append(results, res)
+		}
+	}
+	sortLabResults(results)
+	return results
+}
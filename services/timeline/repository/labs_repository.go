@@ -0,0 +1,196 @@
+package repository
+
+import (
+    "context"
+    "health-bar/shared/database"
+    "health-bar/shared/models"
+    "health-bar/shared/pagination"
+    "sort"
+    "time"
+    "github.com/google/uuid"
+)
+
+const (
+    labPanelColumns  = `id, patient_id, visit_id, document_id, name, lab_name, collected_at, notes, created_at, updated_at`
+    labResultColumns = `id, panel_id, patient_id, analyte, name, value, unit, ref_low, ref_high, flag, collected_at, created_at`
+    // abnormalPanel matches panels with a result outside its range
+    abnormalPanel = `EXISTS (SELECT 1 FROM lab_results lr WHERE lr.panel_id = lab_panels.id AND lr.flag NOT IN ('', 'normal'))`
+)
+
+// CreateLabPanel inserts a panel and its results
+func (r *TimelineRepository) CreateLabPanel(ctx context.Context, patientID string, panel *models.LabPanel) error {
+    return r.WithTx(ctx, func(ctx context.Context) error {
+        ctx, cancel := database.WithTimeout(ctx)
+        defer cancel()
+
+        results := panel.Results
+        query := `
+            INSERT INTO lab_panels (id, patient_id, visit_id, document_id, name, lab_name, collected_at, notes)
+            VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+            RETURNING ` + labPanelColumns
+
+        err := database.Conn(ctx, r.db).QueryRowxContext(ctx, query,
+            uuid.New().String(), patientID, panel.VisitID, panel.DocumentID,
+            panel.Name, panel.LabName, panel.CollectedAt, panel.Notes,
+        ).StructScan(panel)
+        if err != nil {
+            return err
+        }
+
+        query = `
+            INSERT INTO lab_results (id, panel_id, patient_id, analyte, name, value, unit, ref_low, ref_high, flag, collected_at)
+            VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+            RETURNING ` + labResultColumns
+
+        panel.Results = make([]models.LabResult, len(results))
+        for i, res := range results {
+            err := database.Conn(ctx, r.db).QueryRowxContext(ctx, query,
+                uuid.New().String(), panel.ID, patientID, res.Analyte, res.Name, res.Value, res.Unit,
+                res.RefLow, res.RefHigh, res.Flag, panel.CollectedAt,
+            ).StructScan(&panel.Results[i])
+            if err != nil {
+                return err
+            }
+        }
+        sortLabResults(panel.Results)
+        return nil
+    })
+}
+
+// GetLabPanel gets a lab panel with its results
+func (r *TimelineRepository) GetLabPanel(ctx context.Context, id string) (*models.LabPanel, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    panel := &models.LabPanel{}
+    query := `SELECT ` + labPanelColumns + ` FROM lab_panels WHERE id = $1`
+    if err := database.Conn(ctx, r.db).GetContext(ctx, panel, query, id); err != nil {
+        return nil, err
+    }
+
+    panels := []models.LabPanel{*panel}
+    if err := r.loadLabResults(ctx, panels); err != nil {
+        return nil, err
+    }
+    return &panels[0], nil
+}
+
+// GetLabPanelsByPatientID gets a page of a patient's lab panels with their results and the cursor of the next page
+func (r *TimelineRepository) GetLabPanelsByPatientID(ctx context.Context, patientID string, page LabPanelPage) ([]models.LabPanel, string, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    q := &pagination.Query{}
+    q.Where("patient_id = " + q.Arg(patientID))
+    if !page.From.IsZero() {
+        q.Where("collected_at >= " + q.Arg(pagination.Timestamp(page.From)) + "::timestamp")
+    }
+    if !page.To.IsZero() {
+        q.Where("collected_at <= " + q.Arg(pagination.Timestamp(page.ToEnd())) + "::timestamp")
+    }
+    if page.Filters["abnormal"] == "true" {
+        q.Where(abnormalPanel)
+    }
+
+    var panels []models.LabPanel
+    query := `SELECT ` + labPanelColumns + ` FROM lab_panels` + page.Clauses(q)
+    if err := database.Conn(ctx, r.db).SelectContext(ctx, &panels, query, q.Args()...); err != nil {
+        return nil, "", err
+    }
+    panels, next := pagination.Page(panels, page)
+    if err := r.loadLabResults(ctx, panels); err != nil {
+        return nil, "", err
+    }
+    return panels, next, nil
+}
+
+// loadLabResults fills in the results of panels
+func (r *TimelineRepository) loadLabResults(ctx context.Context, panels []models.LabPanel) error {
+    if len(panels) == 0 {
+        return nil
+    }
+
+    ids := make([]string, len(panels))
+    byID := make(map[string]*models.LabPanel, len(panels))
+    for i := range panels {
+        ids[i] = panels[i].ID
+        byID[panels[i].ID] = &panels[i]
+        panels[i].Results = []models.LabResult{}
+    }
+
+    var results []models.LabResult
+    query := `SELECT ` + labResultColumns + ` FROM lab_results WHERE panel_id = ANY($1::uuid[]) ORDER BY name, analyte`
+    if err := database.Conn(ctx, r.db).SelectContext(ctx, &results, query, ids); err != nil {
+        return err
+    }
+    for _, res := range results {
+        panel := byID[res.PanelID]
+        panel.Results = append(panel.Results, res)
+    }
+    return nil
+}
+
+// DeleteLabPanel deletes a lab panel and its results
+func (r *TimelineRepository) DeleteLabPanel(ctx context.Context, id string) error {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    query := `DELETE FROM lab_panels WHERE id = $1`
+    _, err := database.Conn(ctx, r.db).ExecContext(ctx, query, id)
+    return err
+}
+
+// GetLabTrend gets a patient's results for one analyte collected in [from, to), oldest first
+func (r *TimelineRepository) GetLabTrend(ctx context.Context, patientID, analyte string, from, to time.Time) ([]models.LabResult, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    results := []models.LabResult{}
+    query := `
+        SELECT ` + labResultColumns + `
+        FROM lab_results
+        WHERE patient_id = $1 AND analyte = $2 AND collected_at >= $3::timestamp AND collected_at < $4::timestamp
+        ORDER BY collected_at, id
+    `
+    err := database.Conn(ctx, r.db).SelectContext(ctx, &results, query,
+        patientID, analyte, pagination.Timestamp(from), pagination.Timestamp(to))
+    return results, err
+}
+
+// GetPatientProfile gets a patient profile by ID
+func (r *TimelineRepository) GetPatientProfile(ctx context.Context, patientID string) (*models.PatientProfile, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    profile := &models.PatientProfile{}
+    query := `
+        SELECT id, user_id, full_name, date_of_birth, gender, phone, address, created_at, updated_at, version
+        FROM patient_profiles
+        WHERE id = $1
+    `
+    if err := database.Conn(ctx, r.db).GetContext(ctx, profile, query, patientID); err != nil {
+        return nil, err
+    }
+    return profile, nil
+}
+
+// GetDocumentPatientID gets the patient an uploaded document belongs to
+func (r *TimelineRepository) GetDocumentPatientID(ctx context.Context, documentID string) (string, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    var patientID string
+    query := `SELECT patient_id FROM prescriptions WHERE id = $1`
+    err := database.Conn(ctx, r.db).GetContext(ctx, &patientID, query, documentID)
+    return patientID, err
+}
+
+func sortLabResults(results []models.LabResult) {
+    sort.Slice(results, func(i, j int) bool {
+        a, b := results[i], results[j]
+        if a.Name != b.Name {
+            return a.Name < b.Name
+        }
+        return a.Analyte < b.Analyte
+    })
+}
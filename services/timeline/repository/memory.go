@@ -0,0 +1,141 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"health-bar/shared/database"
+	"health-bar/shared/memdb"
+	"health-bar/shared/models"
+	"health-bar/shared/pagination"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// MemoryRepository implements Store on top of memdb.
+type MemoryRepository struct {
+	db *memdb.DB
+}
+
+func NewMemoryRepository(db *memdb.DB) *MemoryRepository {
+	return &MemoryRepository{db: db}
+}
+
+func (r *MemoryRepository) WithTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	return r.db.WithTx(ctx, fn)
+}
+
+func (r *MemoryRepository) CreateVisit(ctx context.Context, patientID string, visit *models.HospitalVisit) error {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	if _, ok := r.db.PatientProfiles.Rows[patientID]; !ok {
+		return memdb.ForeignKeyViolation("hospital_visits", "hospital_visits_patient_id_fkey")
+	}
+
+	now := r.db.Now()
+	visit.ID = uuid.New().String()
+	visit.PatientID = patientID
+	visit.CreatedAt, visit.UpdatedAt = now, now
+	visit.Version = 1
+	r.db.HospitalVisits.Rows[visit.ID] = *visit
+	return nil
+}
+
+func (r *MemoryRepository) GetVisitByID(ctx context.Context, visitID string) (*models.HospitalVisit, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	visit, ok := r.db.HospitalVisits.Rows[visitID]
+	if !ok {
+		return &models.HospitalVisit{}, sql.ErrNoRows
+	}
+	return &visit, nil
+}
+
+func (r *MemoryRepository) GetVisitsByPatientID(ctx context.Context, patientID string, page VisitPage) ([]models.HospitalVisit, string, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	name := strings.ToLower(page.Filters["hospital_name"])
+	var visits []models.HospitalVisit
+	for _, v := range r.db.HospitalVisits.Rows {
+		if v.PatientID != patientID || !page.InRange(v.VisitDate) {
+			continue
+		}
+		if name != "" && !strings.Contains(strings.ToLower(v.HospitalName), name) {
+			continue
+		}
+		visits = append(visits, v)
+	}
+	visits, next := pagination.Apply(visits, page)
+	return visits, next, nil
+}
+
+func (r *MemoryRepository) UpdateVisit(ctx context.Context, visitID string, visit *models.HospitalVisit) error {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	existing, ok := r.db.HospitalVisits.Rows[visitID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	if visit.Version != 0 && visit.Version != existing.Version {
+		return database.ErrVersionConflict
+	}
+
+	existing.HospitalName = visit.HospitalName
+	existing.VisitDate = visit.VisitDate
+	existing.Reason = visit.Reason
+	existing.Notes = visit.Notes
+	existing.UpdatedAt = r.db.Now()
+	existing.Version++
+	r.db.HospitalVisits.Rows[visitID] = existing
+
+	*visit = existing
+	return nil
+}
+
+func (r *MemoryRepository) DeleteVisit(ctx context.Context, visitID string) error {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	memdb.Delete(r.db, "hospital_visits", r.db.HospitalVisits, visitID)
+	return nil
+}
+
+func (r *MemoryRepository) GetPatientIDByVisitID(ctx context.Context, visitID string) (string, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	visit, ok := r.db.HospitalVisits.Rows[visitID]
+	if !ok {
+		return "", sql.ErrNoRows
+	}
+	return visit.PatientID, nil
+}
+
+func (r *MemoryRepository) GetPatientProfileIDByUserID(ctx context.Context, userID string) (string, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	profile, ok := r.db.PatientByUserID(userID)
+	if !ok {
+		return "", sql.ErrNoRows
+	}
+	return profile.ID, nil
+}
+
+func (r *MemoryRepository) GetDoctorUserIDsWithAccess(ctx context.Context, patientProfileID string) ([]string, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	return r.db.DoctorUserIDsWithAccess(patientProfileID), nil
+}
+
+func (r *MemoryRepository) CheckDoctorAccess(ctx context.Context, doctorUserID, patientProfileID string) (bool, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	return r.db.DoctorHasAccess(doctorUserID, patientProfileID), nil
+}
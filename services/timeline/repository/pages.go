@@ -0,0 +1,76 @@
+package repository
+
+import (
+	"health-bar/shared/models"
+	"health-bar/shared/pagination"
+)
+
+// VisitPage is a page request for a patient's visits.
+type VisitPage = pagination.Params[models.HospitalVisit]
+
+// VisitPages lists visits newest first by default. from and to bound the
+// visit date; hospital_name matches case-insensitively anywhere in the name.
+var VisitPages = &pagination.Spec[models.HospitalVisit]{
+	Sorts: []pagination.Sort[models.HospitalVisit]{
+		{Name: "visit_date", Column: "visit_date", Cast: "date",
+			Value: func(v models.HospitalVisit) string { return pagination.Date(v.VisitDate) }},
+		{Name: "created_at", Column: "created_at", Cast: "timestamp",
+			Value: func(v models.HospitalVisit) string { return pagination.Timestamp(v.CreatedAt) }},
+		{Name: "hospital_name", Column: "hospital_name", Cast: "text",
+			Value: func(v models.HospitalVisit) string { return v.HospitalName }},
+	},
+	Default:   "-visit_date",
+	Filters:   []string{"hospital_name"},
+	DateRange: true,
+	ID:        func(v models.HospitalVisit) string { return v.ID },
+}
+
+// VitalPage is a page request for a patient's vital sign readings.
+type VitalPage = pagination.Params[models.VitalReading]
+
+// VitalPages lists readings newest first. from and to bound the measurement
+// date; kind restricts the list to one kind.
+var VitalPages = &pagination.Spec[models.VitalReading]{
+	Sorts: []pagination.Sort[models.VitalReading]{
+		{Name: "measured_at", Column: "measured_at", Cast: "timestamp",
+			Value: func(v models.VitalReading) string { return pagination.Timestamp(v.MeasuredAt) }},
+	},
+	Default:   "-measured_at",
+	Filters:   []string{"kind"},
+	DateRange: true,
+	ID:        func(v models.VitalReading) string { return v.ID },
+}
+
+// LabPanelPage is a page request for a patient's lab panels.
+type LabPanelPage = pagination.Params[models.LabPanel]
+
+// LabPanelPages lists panels most recently collected first. from and to
+// bound the collection date; abnormal=true keeps panels with at least one
+// result outside its reference range.
+var LabPanelPages = &pagination.Spec[models.LabPanel]{
+	Sorts: []pagination.Sort[models.LabPanel]{
+		{Name: "collected_at", Column: "collected_at", Cast: "timestamp",
+			Value: func(p models.LabPanel) string { return pagination.Timestamp(p.CollectedAt) }},
+	},
+	Default:   "-collected_at",
+	Filters:   []string{"abnormal"},
+	DateRange: true,
+	ID:        func(p models.LabPanel) string { return p.ID },
+}
+
+// ImmunizationPage is a page request for a patient's immunizations.
+type ImmunizationPage = pagination.Params[models.Immunization]
+
+// ImmunizationPages lists doses most recently administered first. from and
+// to bound the administration date; vaccine restricts the list to one
+// vaccine code.
+var ImmunizationPages = &pagination.Spec[models.Immunization]{
+	Sorts: []pagination.Sort[models.Immunization]{
+		{Name: "administered_on", Column: "administered_on", Cast: "date",
+			Value: func(i models.Immunization) string { return pagination.Date(i.AdministeredOn) }},
+	},
+	Default:   "-administered_on",
+	Filters:   []string{"vaccine"},
+	DateRange: true,
+	ID:        func(i models.Immunization) string { return i.ID },
+}
@@ -0,0 +1,46 @@
+package repository
+
+import (
+	"context"
+	"health-bar/shared/models"
+	"time"
+)
+
+// Store is what TimelineHandler needs from persistence. TimelineRepository
+// is the Postgres implementation and MemoryRepository the in-memory one.
+type Store interface {
+	WithTx(ctx context.Context, fn func(ctx context.Context) error) error
+	CreateVisit(ctx context.Context, patientID string, visit *models.HospitalVisit) error
+	GetVisitByID(ctx context.Context, visitID string) (*models.HospitalVisit, error)
+	GetVisitsByPatientID(ctx context.Context, patientID string, page VisitPage) ([]models.HospitalVisit, string, error)
+	UpdateVisit(ctx context.Context, visitID string, visit *models.HospitalVisit) error
+	DeleteVisit(ctx context.Context, visitID string) error
+	GetPatientIDByVisitID(ctx context.Context, visitID string) (string, error)
+	GetPatientProfileIDByUserID(ctx context.Context, userID string) (string, error)
+	CreateVitals(ctx context.Context, patientID string, readings []models.VitalReading) ([]models.VitalReading, error)
+	GetVitalByID(ctx context.Context, id string) (*models.VitalReading, error)
+	GetVitalsByPatientID(ctx context.Context, patientID string, page VitalPage) ([]models.VitalReading, string, error)
+	SummarizeVitals(ctx context.Context, patientID string, rng VitalRange) ([]models.VitalBucket, error)
+	DeleteVital(ctx context.Context, id string) error
+	CreateLabPanel(ctx context.Context, patientID string, panel *models.LabPanel) error
+	GetLabPanel(ctx context.Context, id string) (*models.LabPanel, error)
+	GetLabPanelsByPatientID(ctx context.Context, patientID string, page LabPanelPage) ([]models.LabPanel, string, error)
+	DeleteLabPanel(ctx context.Context, id string) error
+	GetLabTrend(ctx context.Context, patientID, analyte string, from, to time.Time) ([]models.LabResult, error)
+	GetPatientProfile(ctx context.Context, patientID string) (*models.PatientProfile, error)
+	GetDocumentPatientID(ctx context.Context, documentID string) (string, error)
+	CreateImmunization(ctx context.Context, patientID string, imm *models.Immunization) error
+	GetImmunizationByID(ctx context.Context, id string) (*models.Immunization, error)
+	GetImmunizationsByPatientID(ctx context.Context, patientID string, page ImmunizationPage) ([]models.Immunization, string, error)
+	ListImmunizations(ctx context.Context, patientID string) ([]models.Immunization, error)
+	DeleteImmunization(ctx context.Context, id string) error
+	GetPatientProfilesAfter(ctx context.Context, afterID string, limit int) ([]models.PatientProfile, error)
+	RecordImmunizationReminder(ctx context.Context, patientID, vaccine string, doseNumber int, status string) (bool, error)
+	CheckDoctorAccess(ctx context.Context, doctorUserID, patientProfileID string) (bool, error)
+	GetDoctorUserIDsWithAccess(ctx context.Context, patientProfileID string) ([]string, error)
+}
+
+var (
+	_ Store = (*TimelineRepository)(nil)
+	_ Store = (*MemoryRepository)(nil)
+)
@@ -1,7 +1,11 @@
 package repository
 
 import (
+    "context"
+    "database/sql"
+    "health-bar/shared/database"
     "health-bar/shared/models"
+    "health-bar/shared/pagination"
     "github.com/jmoiron/sqlx"
     "github.com/google/uuid"
 )
@@ -14,105 +18,170 @@ func NewTimelineRepository(db *sqlx.DB) *TimelineRepository {
     return &TimelineRepository{db: db}
 }
 
+// WithTx runs fn in a transaction shared by every repository call made with its context
+func (r *TimelineRepository) WithTx(ctx context.Context, fn func(ctx context.Context) error) error {
+    return database.WithTx(ctx, r.db, fn)
+}
+
 // CreateVisit creates a new hospital visit
-func (r *TimelineRepository) CreateVisit(patientID string, visit *models.HospitalVisit) error {
+func (r *TimelineRepository) CreateVisit(ctx context.Context, patientID string, visit *models.HospitalVisit) error {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
     visit.ID = uuid.New().String()
     visit.PatientID = patientID
 
     query := `
         INSERT INTO hospital_visits (id, patient_id, hospital_name, visit_date, reason, notes)
         VALUES ($1, $2, $3, $4, $5, $6)
-        RETURNING id, patient_id, hospital_name, visit_date, reason, notes, created_at, updated_at
+        RETURNING id, patient_id, hospital_name, visit_date, reason, notes, created_at, updated_at, version
     `
 
-    return r.db.QueryRowx(query,
+    return database.Conn(ctx, r.db).QueryRowxContext(ctx, query,
         visit.ID, visit.PatientID, visit.HospitalName, visit.VisitDate,
         visit.Reason, visit.Notes,
     ).StructScan(visit)
 }
 
 // GetVisitByID gets a hospital visit by ID
-func (r *TimelineRepository) GetVisitByID(visitID string) (*models.HospitalVisit, error) {
+func (r *TimelineRepository) GetVisitByID(ctx context.Context, visitID string) (*models.HospitalVisit, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
     visit := &models.HospitalVisit{}
     query := `
-        SELECT id, patient_id, hospital_name, visit_date, reason, notes, created_at, updated_at
+        SELECT id, patient_id, hospital_name, visit_date, reason, notes, created_at, updated_at, version
         FROM hospital_visits
         WHERE id = $1
     `
-    err := r.db.Get(visit, query, visitID)
+    err := database.Conn(ctx, r.db).GetContext(ctx, visit, query, visitID)
     return visit, err
 }
 
-// GetVisitsByPatientID gets all visits for a patient
-func (r *TimelineRepository) GetVisitsByPatientID(patientID string) ([]models.HospitalVisit, error) {
+// GetVisitsByPatientID gets a page of visits for a patient and the cursor of the next page
+func (r *TimelineRepository) GetVisitsByPatientID(ctx context.Context, patientID string, page VisitPage) ([]models.HospitalVisit, string, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    q := &pagination.Query{}
+    q.Where("patient_id = " + q.Arg(patientID))
+    if !page.From.IsZero() {
+        q.Where("visit_date >= " + q.Arg(pagination.Date(page.From)) + "::date")
+    }
+    if !page.To.IsZero() {
+        q.Where("visit_date <= " + q.Arg(pagination.Date(page.To)) + "::date")
+    }
+    if name, ok := page.Filters["hospital_name"]; ok {
+        q.Where("hospital_name ILIKE " + q.Arg(pagination.Contains(name)))
+    }
+
     var visits []models.HospitalVisit
     query := `
-        SELECT id, patient_id, hospital_name, visit_date, reason, notes, created_at, updated_at
-        FROM hospital_visits
-        WHERE patient_id = $1
-        ORDER BY visit_date DESC, created_at DESC
-    `
-    err := r.db.Select(&visits, query, patientID)
-    return visits, err
+        SELECT id, patient_id, hospital_name, visit_date, reason, notes, created_at, updated_at, version
+        FROM hospital_visits` + page.Clauses(q)
+    if err := database.Conn(ctx, r.db).SelectContext(ctx, &visits, query, q.Args()...); err != nil {
+        return nil, "", err
+    }
+    visits, next := pagination.Page(visits, page)
+    return visits, next, nil
 }
 
 // UpdateVisit updates a hospital visit
-func (r *TimelineRepository) UpdateVisit(visitID string, visit *models.HospitalVisit) error {
+func (r *TimelineRepository) UpdateVisit(ctx context.Context, visitID string, visit *models.HospitalVisit) error {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
     query := `
         UPDATE hospital_visits
-        SET hospital_name = $1, visit_date = $2, reason = $3, notes = $4, updated_at = NOW()
-        WHERE id = $5
-        RETURNING id, patient_id, hospital_name, visit_date, reason, notes, created_at, updated_at
+        SET hospital_name = $1, visit_date = $2, reason = $3, notes = $4,
+            version = version + 1, updated_at = NOW()
+        WHERE id = $5 AND ($6 = 0 OR version = $6)
+        RETURNING id, patient_id, hospital_name, visit_date, reason, notes, created_at, updated_at, version
     `
 
-    return r.db.QueryRowx(query,
-        visit.HospitalName, visit.VisitDate, visit.Reason, visit.Notes, visitID,
+    // visit.Version is the version the caller read; zero skips the check
+    expected := visit.Version
+    conn := database.Conn(ctx, r.db)
+    err := conn.QueryRowxContext(ctx, query,
+        visit.HospitalName, visit.VisitDate, visit.Reason, visit.Notes, visitID, expected,
     ).StructScan(visit)
+    return database.VersionError(ctx, conn, err, expected,
+        `SELECT EXISTS (SELECT 1 FROM hospital_visits WHERE id = $1)`, visitID)
 }
 
 // DeleteVisit deletes a hospital visit
-func (r *TimelineRepository) DeleteVisit(visitID string) error {
+func (r *TimelineRepository) DeleteVisit(ctx context.Context, visitID string) error {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
     query := `DELETE FROM hospital_visits WHERE id = $1`
-    _, err := r.db.Exec(query, visitID)
+    _, err := database.Conn(ctx, r.db).ExecContext(ctx, query, visitID)
     return err
 }
 
 // GetPatientIDByVisitID gets the patient ID associated with a visit
-func (r *TimelineRepository) GetPatientIDByVisitID(visitID string) (string, error) {
+func (r *TimelineRepository) GetPatientIDByVisitID(ctx context.Context, visitID string) (string, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
     var patientID string
     query := `SELECT patient_id FROM hospital_visits WHERE id = $1`
-    err := r.db.Get(&patientID, query, visitID)
+    if database.InTx(ctx) {
+        // Lock the row so the ownership check holds until the transaction ends
+        query += ` FOR UPDATE`
+    }
+    err := database.Conn(ctx, r.db).GetContext(ctx, &patientID, query, visitID)
     return patientID, err
 }
 
 // GetPatientProfileIDByUserID gets patient profile ID from user ID
-func (r *TimelineRepository) GetPatientProfileIDByUserID(userID string) (string, error) {
+func (r *TimelineRepository) GetPatientProfileIDByUserID(ctx context.Context, userID string) (string, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
     var profileID string
     query := `SELECT id FROM patient_profiles WHERE user_id = $1`
-    err := r.db.Get(&profileID, query, userID)
+    err := database.Conn(ctx, r.db).GetContext(ctx, &profileID, query, userID)
     return profileID, err
 }
 
+// GetDoctorUserIDsWithAccess lists the user IDs of the doctors the patient
+// has granted active access
+func (r *TimelineRepository) GetDoctorUserIDsWithAccess(ctx context.Context, patientProfileID string) ([]string, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    userIDs := []string{}
+    query := `
+        SELECT d.user_id
+        FROM doctor_access_permissions dap
+        INNER JOIN doctor_profiles d ON d.id = dap.doctor_id
+        WHERE dap.patient_id = $1 AND dap.is_active = true
+        ORDER BY d.user_id
+    `
+    err := database.Conn(ctx, r.db).SelectContext(ctx, &userIDs, query, patientProfileID)
+    return userIDs, err
+}
+
 // CheckDoctorAccess checks if a doctor has access to view patient's timeline
-func (r *TimelineRepository) CheckDoctorAccess(doctorUserID, patientProfileID string) (bool, error) {
-    // Get doctor profile ID
-    var doctorProfileID string
-    query := `SELECT id FROM doctor_profiles WHERE user_id = $1`
-    err := r.db.Get(&doctorProfileID, query, doctorUserID)
-    if err != nil {
-        return false, err
-    }
+func (r *TimelineRepository) CheckDoctorAccess(ctx context.Context, doctorUserID, patientProfileID string) (bool, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
 
-    // Check access permission
     var isActive bool
-    query = `
-        SELECT is_active
-        FROM doctor_access_permissions
-        WHERE doctor_id = $1 AND patient_id = $2
+    query := `
+        SELECT dap.is_active
+        FROM doctor_access_permissions dap
+        INNER JOIN doctor_profiles d ON d.id = dap.doctor_id
+        WHERE d.user_id = $1 AND dap.patient_id = $2
     `
-    err = r.db.Get(&isActive, query, doctorProfileID, patientProfileID)
+    err := database.Conn(ctx, r.db).GetContext(ctx, &isActive, query, doctorUserID, patientProfileID)
     if err != nil {
+        if err == sql.ErrNoRows {
+            return false, nil // No permission found = no access
+        }
         return false, err
     }
     return isActive, nil
 }
+
@@ -0,0 +1,152 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+	"time"
+
+	"health-bar/shared/memdb"
+	"health-bar/shared/models"
+	"health-bar/shared/pagination"
+
+	"github.com/google/uuid"
+)
+
+func (r *MemoryRepository) CreateVitals(ctx context.Context, patientID string, readings []models.VitalReading) ([]models.VitalReading, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	created := []models.VitalReading{}
+	if len(readings) == 0 {
+		return created, nil
+	}
+	if _, ok := r.db.PatientProfiles.Rows[patientID]; !ok {
+		return nil, memdb.ForeignKeyViolation("vital_readings", "vital_readings_patient_id_fkey")
+	}
+
+	type instant struct {
+		kind models.VitalKind
+		at   time.Time
+	}
+	taken := map[instant]bool{}
+	for _, v := range r.db.VitalReadings.Rows {
+		if v.PatientID == patientID {
+			taken[instant{v.Kind, v.MeasuredAt}] = true
+		}
+	}
+
+	now := r.db.Now()
+	for _, v := range readings {
+		key := instant{v.Kind, v.MeasuredAt}
+		if taken[key] {
+			continue
+		}
+		taken[key] = true
+
+		v.ID = uuid.New().String()
+		v.PatientID = patientID
+		v.CreatedAt = now
+		r.db.VitalReadings.Rows[v.ID] = v
+		created = append(created, v)
+	}
+	sortVitals(created)
+	return created, nil
+}
+
+func (r *MemoryRepository) GetVitalByID(ctx context.Context, id string) (*models.VitalReading, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	reading, ok := r.db.VitalReadings.Rows[id]
+	if !ok {
+		return &models.VitalReading{}, sql.ErrNoRows
+	}
+	return &reading, nil
+}
+
+func (r *MemoryRepository) GetVitalsByPatientID(ctx context.Context, patientID string, page VitalPage) ([]models.VitalReading, string, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	kind, filtered := page.Filters["kind"]
+	var readings []models.VitalReading
+	for _, v := range r.db.VitalReadings.Rows {
+		if v.PatientID != patientID || !page.InRange(v.MeasuredAt) {
+			continue
+		}
+		if filtered && string(v.Kind) != kind {
+			continue
+		}
+		readings = append(readings, v)
+	}
+	readings, next := pagination.Apply(readings, page)
+	return readings, next, nil
+}
+
+func (r *MemoryRepository) SummarizeVitals(ctx context.Context, patientID string, rng VitalRange) ([]models.VitalBucket, error) {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	type sums struct {
+		bucket        models.VitalBucket
+		total, dTotal float64
+	}
+	byStart := map[time.Time]*sums{}
+	for _, v := range r.db.VitalReadings.Rows {
+		if v.PatientID != patientID || v.Kind != rng.Kind || v.MeasuredAt.Before(rng.From) || !v.MeasuredAt.Before(rng.To) {
+			continue
+		}
+		start := truncate(v.MeasuredAt, rng.Bucket)
+		s, ok := byStart[start]
+		if !ok {
+			s = &sums{bucket: models.VitalBucket{Start: start, Min: v.Value, Max: v.Value}}
+			byStart[start] = s
+		}
+		b := &s.bucket
+		b.Count++
+		b.Min, b.Max = min(b.Min, v.Value), max(b.Max, v.Value)
+		s.total += v.Value
+		if v.Diastolic != nil {
+			d := *v.Diastolic
+			if b.DiastolicMin == nil {
+				b.DiastolicMin, b.DiastolicMax = &d, &d
+			}
+			lo, hi := min(*b.DiastolicMin, d), max(*b.DiastolicMax, d)
+			b.DiastolicMin, b.DiastolicMax = &lo, &hi
+			s.dTotal += d
+		}
+	}
+
+	buckets := []models.VitalBucket{}
+	for _, s := range byStart {
+		b := s.bucket
+		b.Avg = s.total / float64(b.Count)
+		if b.DiastolicMin != nil {
+			avg := s.dTotal / float64(b.Count)
+			b.DiastolicAvg = &avg
+		}
+		buckets = append(buckets, b)
+	}
+	slices.SortFunc(buckets, func(a, b models.VitalBucket) int { return a.Start.Compare(b.Start) })
+	return buckets, nil
+}
+
+func (r *MemoryRepository) DeleteVital(ctx context.Context, id string) error {
+	r.db.Lock()
+	defer r.db.Unlock()
+
+	delete(r.db.VitalReadings.Rows, id)
+	return nil
+}
+
+// truncate mirrors Postgres date_trunc for the day and week fields; weeks
+// start on Monday.
+func truncate(t time.Time, bucket string) time.Time {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	if bucket == "week" {
+		offset := (int(day.Weekday()) + 6) % 7
+		day = day.AddDate(0, 0, -offset)
+	}
+	return day
+}
@@ -0,0 +1,138 @@
+package repository
+
+import (
+    "context"
+    "fmt"
+    "health-bar/shared/database"
+    "health-bar/shared/models"
+    "health-bar/shared/pagination"
+    "sort"
+    "strings"
+    "time"
+    "github.com/google/uuid"
+)
+
+const vitalColumns = `id, patient_id, kind, value, diastolic, unit, measured_at, source, notes, created_at`
+
+// VitalRange selects the readings of one kind measured in [From, To) and
+// how SummarizeVitals groups them: by UTC "day" or ISO "week".
+type VitalRange struct {
+    Kind   models.VitalKind
+    Bucket string
+    From   time.Time
+    To     time.Time
+}
+
+// CreateVitals inserts a batch of readings in one statement. Readings that
+// repeat an existing kind and measured_at are skipped; the ones inserted are
+// returned in measurement order.
+func (r *TimelineRepository) CreateVitals(ctx context.Context, patientID string, readings []models.VitalReading) ([]models.VitalReading, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    created := []models.VitalReading{}
+    if len(readings) == 0 {
+        return created, nil
+    }
+
+    const columns = 8
+    rows := make([]string, len(readings))
+    args := make([]interface{}, 0, len(readings)*columns)
+    for i, v := range readings {
+        n := i * columns
+        rows[i] = fmt.Sprintf("($%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d)",
+            n+1, n+2, n+3, n+4, n+5, n+6, n+7, n+8, len(readings)*columns+1)
+        args = append(args, uuid.New().String(), v.Kind, v.Value, v.Diastolic, v.Unit, v.MeasuredAt, v.Source, v.Notes)
+    }
+    args = append(args, patientID)
+
+    query := `
+        INSERT INTO vital_readings (id, kind, value, diastolic, unit, measured_at, source, notes, patient_id)
+        VALUES ` + strings.Join(rows, ", ") + `
+        ON CONFLICT (patient_id, kind, measured_at) DO NOTHING
+        RETURNING ` + vitalColumns
+
+    if err := database.Conn(ctx, r.db).SelectContext(ctx, &created, query, args...); err != nil {
+        return nil, err
+    }
+    sortVitals(created)
+    return created, nil
+}
+
+// GetVitalByID gets a vital sign reading by ID
+func (r *TimelineRepository) GetVitalByID(ctx context.Context, id string) (*models.VitalReading, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    reading := &models.VitalReading{}
+    query := `SELECT ` + vitalColumns + ` FROM vital_readings WHERE id = $1`
+    err := database.Conn(ctx, r.db).GetContext(ctx, reading, query, id)
+    return reading, err
+}
+
+// GetVitalsByPatientID gets a page of a patient's readings and the cursor of the next page
+func (r *TimelineRepository) GetVitalsByPatientID(ctx context.Context, patientID string, page VitalPage) ([]models.VitalReading, string, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    q := &pagination.Query{}
+    q.Where("patient_id = " + q.Arg(patientID))
+    if kind, ok := page.Filters["kind"]; ok {
+        q.Where("kind = " + q.Arg(kind))
+    }
+    if !page.From.IsZero() {
+        q.Where("measured_at >= " + q.Arg(pagination.Timestamp(page.From)) + "::timestamp")
+    }
+    if !page.To.IsZero() {
+        q.Where("measured_at <= " + q.Arg(pagination.Timestamp(page.ToEnd())) + "::timestamp")
+    }
+
+    var readings []models.VitalReading
+    query := `SELECT ` + vitalColumns + ` FROM vital_readings` + page.Clauses(q)
+    if err := database.Conn(ctx, r.db).SelectContext(ctx, &readings, query, q.Args()...); err != nil {
+        return nil, "", err
+    }
+    readings, next := pagination.Page(readings, page)
+    return readings, next, nil
+}
+
+// SummarizeVitals aggregates a patient's readings into day or week buckets,
+// oldest first. Empty buckets are omitted.
+func (r *TimelineRepository) SummarizeVitals(ctx context.Context, patientID string, rng VitalRange) ([]models.VitalBucket, error) {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    buckets := []models.VitalBucket{}
+    query := `
+        SELECT date_trunc($1, measured_at) AS start, count(*) AS count,
+               min(value) AS min, max(value) AS max, avg(value) AS avg,
+               min(diastolic) AS diastolic_min, max(diastolic) AS diastolic_max, avg(diastolic) AS diastolic_avg
+        FROM vital_readings
+        WHERE patient_id = $2 AND kind = $3 AND measured_at >= $4::timestamp AND measured_at < $5::timestamp
+        GROUP BY 1
+        ORDER BY 1
+    `
+    err := database.Conn(ctx, r.db).SelectContext(ctx, &buckets, query,
+        rng.Bucket, patientID, rng.Kind, pagination.Timestamp(rng.From), pagination.Timestamp(rng.To))
+    return buckets, err
+}
+
+// DeleteVital deletes a vital sign reading
+func (r *TimelineRepository) DeleteVital(ctx context.Context, id string) error {
+    ctx, cancel := database.WithTimeout(ctx)
+    defer cancel()
+
+    query := `DELETE FROM vital_readings WHERE id = $1`
+    _, err := database.Conn(ctx, r.db).ExecContext(ctx, query, id)
+    return err
+}
+
+func sortVitals(readings []models.VitalReading) {
+    sort.Slice(readings, func(i, j int) bool {
+        a, b := readings[i], readings[j]
+        if !a.MeasuredAt.Equal(b.MeasuredAt) {
+            return a.MeasuredAt.Before(b.MeasuredAt)
+        }
+        return a.Kind < b.Kind
+    })
+}
@@ -0,0 +1,354 @@
+// Package adherence expands medication schedules into the doses they call
+// for and measures how many of them were taken.
+//
+// Schedules are kept in the patient's timezone: a dose at 08:00 is taken at
+// 08:00 local time every day, across daylight saving changes, and is stored
+// as that instant in UTC. Ranges and periods are local dates.
+package adherence
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"time"
+	_ "time/tzdata"
+
+	"health-bar/shared/models"
+)
+
+const (
+	// MaxTimesPerDay is how many doses a day a schedule can have.
+	MaxTimesPerDay = 12
+	// LateAfter is how long after its time a dose counts as late when
+	// taken, and as missed while nothing is logged.
+	LateAfter = time.Hour
+)
+
+// State is what became of a scheduled dose. Pending doses are not due yet
+// and do not count towards adherence.
+type State string
+
+const (
+	Taken   State = State(models.DoseTaken)
+	Late    State = State(models.DoseLate)
+	Skipped State = State(models.DoseSkipped)
+	Missed  State = "missed"
+	Pending State = "pending"
+)
+
+// Period groups doses for per-period adherence.
+type Period string
+
+const (
+	Day   Period = "day"
+	Week  Period = "week"
+	Month Period = "month"
+)
+
+func (p Period) Valid() bool {
+	switch p {
+	case Day, Week, Month:
+		return true
+	}
+	return false
+}
+
+// ParseTimes validates "HH:MM" times of day and returns them sorted.
+func ParseTimes(raw []string) (models.DoseTimes, error) {
+	if len(raw) == 0 || len(raw) > MaxTimesPerDay {
+		return nil, fmt.Errorf("times must list between 1 and %d times of day", MaxTimesPerDay)
+	}
+	times := make(models.DoseTimes, 0, len(raw))
+	for _, t := range raw {
+		t = strings.TrimSpace(t)
+		if _, err := time.Parse("15:04", t); err != nil || len(t) != 5 {
+			return nil, fmt.Errorf("invalid time %q, use HH:MM", t)
+		}
+		times = append(times, t)
+	}
+	slices.Sort(times)
+	if len(slices.Compact(slices.Clone(times))) != len(times) {
+		return nil, fmt.Errorf("times must not repeat")
+	}
+	return times, nil
+}
+
+// Location loads an IANA timezone such as "Europe/Berlin".
+func Location(name string) (*time.Location, error) {
+	if name == "" || name == "Local" {
+		return nil, fmt.Errorf("timezone is required, e.g. Europe/Berlin")
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown timezone %q", name)
+	}
+	return loc, nil
+}
+
+func dateOf(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// active reports whether the schedule runs on date.
+func active(s models.MedicationSchedule, date time.Time) bool {
+	return !date.Before(dateOf(s.StartDate)) && (s.EndDate == nil || !date.After(dateOf(*s.EndDate)))
+}
+
+// on returns the doses of s on a local date, in UTC. A time skipped by a
+// daylight saving change moves forward with the clock.
+func on(s models.MedicationSchedule, loc *time.Location, date time.Time) []time.Time {
+	if !active(s, date) {
+		return nil
+	}
+	out := make([]time.Time, 0, len(s.Times))
+	for _, hm := range s.Times {
+		t, err := time.Parse("15:04", hm)
+		if err != nil {
+			continue
+		}
+		out = append(out, time.Date(date.Year(), date.Month(), date.Day(), t.Hour(), t.Minute(), 0, 0, loc).UTC())
+	}
+	return out
+}
+
+// Occurrences returns the doses s calls for on the local dates from through
+// to, in UTC.
+func Occurrences(s models.MedicationSchedule, from, to time.Time) []time.Time {
+	loc, err := Location(s.Timezone)
+	if err != nil {
+		return nil
+	}
+	var out []time.Time
+	for date := dateOf(from); !date.After(dateOf(to)); date = date.AddDate(0, 0, 1) {
+		out = append(out, on(s, loc, date)...)
+	}
+	return out
+}
+
+// IsOccurrence reports whether t is one of the doses s calls for.
+func IsOccurrence(s models.MedicationSchedule, t time.Time) bool {
+	loc, err := Location(s.Timezone)
+	if err != nil {
+		return false
+	}
+	local := dateOf(t.In(loc))
+	for _, date := range []time.Time{local.AddDate(0, 0, -1), local, local.AddDate(0, 0, 1)} {
+		for _, o := range on(s, loc, date) {
+			if o.Equal(t) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Status is the state a dose logged with status at takenAt is stored with:
+// a dose taken more than LateAfter after its time is late.
+func Status(status models.DoseStatus, scheduledFor time.Time, takenAt *time.Time) models.DoseStatus {
+	if status == models.DoseTaken && takenAt != nil && takenAt.Sub(scheduledFor) > LateAfter {
+		return models.DoseLate
+	}
+	return status
+}
+
+// Dose is one scheduled dose and what became of it.
+type Dose struct {
+	ScheduleID   string            `json:"schedule_id"`
+	Medication   string            `json:"medication"`
+	Dose         string            `json:"dose"`
+	ScheduledFor time.Time         `json:"scheduled_for"`
+	LocalTime    string            `json:"local_time"`
+	State        State             `json:"state"`
+	Event        *models.DoseEvent `json:"event,omitempty"`
+}
+
+type eventKey struct {
+	schedule string
+	at       int64
+}
+
+// Doses lists the doses of schedules on the local dates from through to, in
+// time order, with the events logged for them.
+func Doses(schedules []models.MedicationSchedule, events []models.DoseEvent, from, to, now time.Time) []Dose {
+	logged := make(map[eventKey]models.DoseEvent, len(events))
+	for _, e := range events {
+		logged[eventKey{e.ScheduleID, e.ScheduledFor.Unix()}] = e
+	}
+
+	doses := []Dose{}
+	for _, s := range schedules {
+		loc, err := Location(s.Timezone)
+		if err != nil {
+			continue
+		}
+		for _, at := range Occurrences(s, from, to) {
+			dose := Dose{
+				ScheduleID:   s.ID,
+				Medication:   s.Medication,
+				Dose:         s.Dose,
+				ScheduledFor: at,
+				LocalTime:    at.In(loc).Format("2006-01-02T15:04"),
+				State:        Pending,
+			}
+			if e, ok := logged[eventKey{s.ID, at.Unix()}]; ok {
+				dose.State, dose.Event = State(e.Status), &e
+			} else if now.Sub(at) > LateAfter {
+				dose.State = Missed
+			}
+			doses = append(doses, dose)
+		}
+	}
+	slices.SortStableFunc(doses, func(a, b Dose) int { return a.ScheduledFor.Compare(b.ScheduledFor) })
+	return doses
+}
+
+// Stats counts doses by state. Adherence is the percentage of due doses
+// taken, late or not; OnTime the percentage taken on time. Both are nil
+// while no dose is due.
+type Stats struct {
+	Due       int      `json:"due"`
+	Taken     int      `json:"taken"`
+	Late      int      `json:"late"`
+	Skipped   int      `json:"skipped"`
+	Missed    int      `json:"missed"`
+	Pending   int      `json:"pending"`
+	Adherence *float64 `json:"adherence_pct"`
+	OnTime    *float64 `json:"on_time_pct"`
+}
+
+func (s *Stats) add(state State) {
+	switch state {
+	case Taken:
+		s.Taken++
+	case Late:
+		s.Late++
+	case Skipped:
+		s.Skipped++
+	case Missed:
+		s.Missed++
+	default:
+		s.Pending++
+		return
+	}
+	s.Due++
+}
+
+func percent(n, of int) *float64 {
+	if of == 0 {
+		return nil
+	}
+	p := math.Round(float64(n)*1000/float64(of)) / 10
+	return &p
+}
+
+func (s *Stats) finish() {
+	s.Adherence = percent(s.Taken+s.Late, s.Due)
+	s.OnTime = percent(s.Taken, s.Due)
+}
+
+// PeriodStats are the stats of the period starting on Start.
+type PeriodStats struct {
+	Start string `json:"start"`
+	Stats
+}
+
+// MedicationAdherence is the adherence to one schedule.
+type MedicationAdherence struct {
+	ScheduleID string        `json:"schedule_id"`
+	Medication string        `json:"medication"`
+	Stats      Stats         `json:"stats"`
+	Periods    []PeriodStats `json:"periods"`
+}
+
+// Report is the adherence over the local dates From through To, overall,
+// per period and per medication.
+type Report struct {
+	From        string                `json:"from"`
+	To          string                `json:"to"`
+	Period      Period                `json:"period"`
+	Overall     Stats                 `json:"overall"`
+	Periods     []PeriodStats         `json:"periods"`
+	Medications []MedicationAdherence `json:"medications"`
+}
+
+// periodStart is the first local date of the period containing date. Weeks
+// start on Monday.
+func periodStart(p Period, date time.Time) time.Time {
+	switch p {
+	case Week:
+		return date.AddDate(0, 0, -(int(date.Weekday())+6)%7)
+	case Month:
+		return time.Date(date.Year(), date.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+	return date
+}
+
+type periods struct {
+	period Period
+	stats  map[string]*PeriodStats
+}
+
+func (p *periods) add(date time.Time, state State) {
+	key := periodStart(p.period, date).Format("2006-01-02")
+	s, ok := p.stats[key]
+	if !ok {
+		s = &PeriodStats{Start: key}
+		p.stats[key] = s
+	}
+	s.add(state)
+}
+
+func (p *periods) list() []PeriodStats {
+	out := make([]PeriodStats, 0, len(p.stats))
+	for _, s := range p.stats {
+		s.finish()
+		out = append(out, *s)
+	}
+	slices.SortFunc(out, func(a, b PeriodStats) int { return strings.Compare(a.Start, b.Start) })
+	return out
+}
+
+// Compute reports adherence to schedules over the local dates from through
+// to as of now.
+func Compute(schedules []models.MedicationSchedule, events []models.DoseEvent, from, to time.Time, period Period, now time.Time) Report {
+	report := Report{
+		From:        from.Format("2006-01-02"),
+		To:          to.Format("2006-01-02"),
+		Period:      period,
+		Medications: []MedicationAdherence{},
+	}
+
+	overall := periods{period, map[string]*PeriodStats{}}
+	byID := map[string]*MedicationAdherence{}
+	perMedication := map[string]*periods{}
+	for _, s := range schedules {
+		report.Medications = append(report.Medications, MedicationAdherence{ScheduleID: s.ID, Medication: s.Medication})
+		perMedication[s.ID] = &periods{period, map[string]*PeriodStats{}}
+	}
+	for i := range report.Medications {
+		byID[report.Medications[i].ScheduleID] = &report.Medications[i]
+	}
+
+	locs := map[string]*time.Location{}
+	for _, s := range schedules {
+		locs[s.ID], _ = Location(s.Timezone)
+	}
+
+	for _, dose := range Doses(schedules, events, from, to, now) {
+		date := dateOf(dose.ScheduledFor.In(locs[dose.ScheduleID]))
+		report.Overall.add(dose.State)
+		overall.add(date, dose.State)
+		byID[dose.ScheduleID].Stats.add(dose.State)
+		perMedication[dose.ScheduleID].add(date, dose.State)
+	}
+
+	report.Overall.finish()
+	report.Periods = overall.list()
+	for i := range report.Medications {
+		m := &report.Medications[i]
+		m.Stats.finish()
+		m.Periods = perMedication[m.ScheduleID].list()
+	}
+	return report
+}
@@ -0,0 +1,117 @@
+package adherence
+
+import (
+	"testing"
+	"time"
+
+	"health-bar/shared/models"
+)
+
+func date(s string) time.Time {
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func schedule(id string, times []string, tz, start string) models.MedicationSchedule {
+	return models.MedicationSchedule{ID: id, Medication: id, Times: times, Timezone: tz, StartDate: date(start)}
+}
+
+func TestParseTimes(t *testing.T) {
+	times, err := ParseTimes([]string{"20:00", " 08:00"})
+	if err != nil || len(times) != 2 || times[0] != "08:00" || times[1] != "20:00" {
+		t.Fatalf("ParseTimes = %v, %v", times, err)
+	}
+	for _, bad := range [][]string{nil, {"8:00"}, {"24:00"}, {"08:00", "08:00"}, {"noon"}} {
+		if _, err := ParseTimes(bad); err == nil {
+			t.Errorf("ParseTimes(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestOccurrencesFollowLocalTimeAcrossDST(t *testing.T) {
+	// Europe/Berlin moves to summer time on 2024-03-31
+	s := schedule("s", []string{"08:00"}, "Europe/Berlin", "2024-03-30")
+	got := Occurrences(s, date("2024-03-30"), date("2024-03-31"))
+	want := []time.Time{
+		time.Date(2024, 3, 30, 7, 0, 0, 0, time.UTC),
+		time.Date(2024, 3, 31, 6, 0, 0, 0, time.UTC),
+	}
+	if len(got) != len(want) || !got[0].Equal(want[0]) || !got[1].Equal(want[1]) {
+		t.Fatalf("Occurrences = %v, want %v", got, want)
+	}
+	if !IsOccurrence(s, want[1]) || IsOccurrence(s, want[1].Add(time.Hour)) {
+		t.Fatal("IsOccurrence does not match the local dose time")
+	}
+
+	end := date("2024-03-30")
+	s.EndDate = &end
+	if got := Occurrences(s, date("2024-03-29"), date("2024-04-02")); len(got) != 1 {
+		t.Fatalf("Occurrences outside start and end dates: %v", got)
+	}
+}
+
+func TestStatusMarksLateDoses(t *testing.T) {
+	at := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	onTime, late := at.Add(30*time.Minute), at.Add(90*time.Minute)
+	if got := Status(models.DoseTaken, at, &onTime); got != models.DoseTaken {
+		t.Errorf("on time = %s", got)
+	}
+	if got := Status(models.DoseTaken, at, &late); got != models.DoseLate {
+		t.Errorf("late = %s", got)
+	}
+}
+
+func TestCompute(t *testing.T) {
+	morning := schedule("a", []string{"08:00", "20:00"}, "UTC", "2024-05-01")
+	evening := schedule("b", []string{"21:00"}, "America/New_York", "2024-05-02")
+
+	at := func(day, hour int) time.Time { return time.Date(2024, 5, day, hour, 0, 0, 0, time.UTC) }
+	events := []models.DoseEvent{
+		{ScheduleID: "a", ScheduledFor: at(1, 8), Status: models.DoseTaken},
+		{ScheduleID: "a", ScheduledFor: at(1, 20), Status: models.DoseLate},
+		{ScheduleID: "a", ScheduledFor: at(2, 8), Status: models.DoseSkipped},
+		// 21:00 in New York on 2 May is 01:00 UTC on 3 May
+		{ScheduleID: "b", ScheduledFor: at(3, 1), Status: models.DoseTaken},
+	}
+	now := at(3, 12)
+
+	report := Compute([]models.MedicationSchedule{morning, evening}, events, date("2024-05-01"), date("2024-05-03"), Day, now)
+
+	// a: three doses logged, 20:00 on the 2nd and 08:00 on the 3rd missed,
+	// 20:00 on the 3rd pending. b: the 2nd taken, the 3rd pending.
+	o := report.Overall
+	if o.Due != 6 || o.Taken != 2 || o.Late != 1 || o.Skipped != 1 || o.Missed != 2 || o.Pending != 2 {
+		t.Fatalf("overall = %+v", o)
+	}
+	if *o.Adherence != 50 || *o.OnTime != 33.3 {
+		t.Fatalf("overall adherence = %v / %v", *o.Adherence, *o.OnTime)
+	}
+
+	if len(report.Periods) != 3 || report.Periods[0].Start != "2024-05-01" || *report.Periods[0].Adherence != 100 {
+		t.Fatalf("periods = %+v", report.Periods)
+	}
+	if len(report.Medications) != 2 || report.Medications[1].Stats.Due != 1 || *report.Medications[1].Stats.Adherence != 100 {
+		t.Fatalf("medications = %+v", report.Medications)
+	}
+	// b's dose on the 3rd falls on the 2nd in New York
+	if p := report.Medications[1].Periods; len(p) != 2 || p[0].Start != "2024-05-02" || p[0].Taken != 1 {
+		t.Fatalf("b periods = %+v", p)
+	}
+
+	if p := Compute(nil, nil, date("2024-05-01"), date("2024-05-31"), Week, now).Overall; p.Adherence != nil || p.Due != 0 {
+		t.Fatalf("empty report = %+v", p)
+	}
+}
+
+func TestWeeksStartOnMonday(t *testing.T) {
+	// 2024-05-05 is a Sunday
+	if got := periodStart(Week, date("2024-05-05")); !got.Equal(date("2024-04-29")) {
+		t.Fatalf("week of Sunday starts %s", got)
+	}
+	if got := periodStart(Week, date("2024-05-06")); !got.Equal(date("2024-05-06")) {
+		t.Fatalf("week of Monday starts %s", got)
+	}
+}
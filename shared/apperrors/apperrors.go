@@ -0,0 +1,326 @@
+// Package apperrors is the error taxonomy shared by every service. Each
+// error carries a stable machine-readable Code; Write renders it as an RFC
+// 7807 problem+json body so clients can branch on the code instead of
+// matching human-readable messages.
+package apperrors
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Code is a stable identifier for a class of failure. Codes are part of the
+// public API: add new ones freely, but never rename or repurpose one.
+type Code string
+
+const (
+	CodeInvalidRequest     Code = "invalid_request"
+	CodeValidation         Code = "validation_failed"
+	CodeInvalidReference   Code = "invalid_reference"
+	CodeUnauthorized       Code = "unauthorized"
+	CodeInvalidToken       Code = "invalid_token"
+	CodeInvalidCredentials Code = "invalid_credentials"
+	CodeForbidden          Code = "forbidden"
+	CodeAccessDenied       Code = "access_denied"
+	CodeNotFound           Code = "not_found"
+	CodeProfileNotFound    Code = "profile_not_found"
+	CodeMethodNotAllowed   Code = "method_not_allowed"
+	CodeConflict           Code = "conflict"
+	CodeProfileExists      Code = "profile_exists"
+	CodeEmailTaken         Code = "email_taken"
+	CodeConcurrentUpdate   Code = "concurrent_update"
+	CodePreconditionFailed Code = "precondition_failed"
+	CodeIdempotencyReused  Code = "idempotency_key_reused"
+	CodeRequestInProgress  Code = "request_in_progress"
+	CodeOverrideRequired   Code = "interaction_override_required"
+	CodeFileTooLarge       Code = "file_too_large"
+	CodeUnsupportedFile    Code = "unsupported_file_type"
+	CodeUnsafeFile         Code = "unsafe_file"
+	CodeChecksumMismatch   Code = "checksum_mismatch"
+	CodeUnsupportedMedia   Code = "unsupported_media_type"
+	CodeRateLimited        Code = "rate_limited"
+	CodeInternal           Code = "internal_error"
+	CodeUnavailable        Code = "service_unavailable"
+	CodeTimeout            Code = "timeout"
+	CodeNotImplemented     Code = "not_implemented"
+)
+
+// StatusChecksumMismatch is the status the tus upload protocol gives a chunk
+// whose content does not match its checksum. net/http has no name for it.
+const StatusChecksumMismatch = 460
+
+var statuses = map[Code]int{
+	CodeInvalidRequest:     http.StatusBadRequest,
+	CodeValidation:         http.StatusBadRequest,
+	CodeInvalidReference:   http.StatusUnprocessableEntity,
+	CodeUnauthorized:       http.StatusUnauthorized,
+	CodeInvalidToken:       http.StatusUnauthorized,
+	CodeInvalidCredentials: http.StatusUnauthorized,
+	CodeForbidden:          http.StatusForbidden,
+	CodeAccessDenied:       http.StatusForbidden,
+	CodeNotFound:           http.StatusNotFound,
+	CodeProfileNotFound:    http.StatusNotFound,
+	CodeMethodNotAllowed:   http.StatusMethodNotAllowed,
+	CodeConflict:           http.StatusConflict,
+	CodeProfileExists:      http.StatusConflict,
+	CodeEmailTaken:         http.StatusConflict,
+	CodeConcurrentUpdate:   http.StatusConflict,
+	CodePreconditionFailed: http.StatusPreconditionFailed,
+	CodeIdempotencyReused:  http.StatusUnprocessableEntity,
+	CodeRequestInProgress:  http.StatusConflict,
+	CodeOverrideRequired:   http.StatusConflict,
+	CodeFileTooLarge:       http.StatusRequestEntityTooLarge,
+	CodeUnsupportedFile:    http.StatusUnsupportedMediaType,
+	CodeUnsafeFile:         http.StatusUnprocessableEntity,
+	CodeChecksumMismatch:   StatusChecksumMismatch,
+	CodeUnsupportedMedia:   http.StatusUnsupportedMediaType,
+	CodeRateLimited:        http.StatusTooManyRequests,
+	CodeInternal:           http.StatusInternalServerError,
+	CodeUnavailable:        http.StatusServiceUnavailable,
+	CodeTimeout:            http.StatusGatewayTimeout,
+	CodeNotImplemented:     http.StatusNotImplemented,
+}
+
+// Status is the HTTP status the code is served with.
+func (c Code) Status() int {
+	if status, ok := statuses[c]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+// Type is the problem type URI for the code.
+func (c Code) Type() string {
+	return "urn:health-bar:error:" + string(c)
+}
+
+// CodeForStatus is the generic code for an HTTP status, used when a caller
+// only knows the status it wants to send.
+func CodeForStatus(status int) Code {
+	switch status {
+	case http.StatusBadRequest:
+		return CodeInvalidRequest
+	case http.StatusUnauthorized:
+		return CodeUnauthorized
+	case http.StatusForbidden:
+		return CodeForbidden
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusMethodNotAllowed:
+		return CodeMethodNotAllowed
+	case http.StatusConflict:
+		return CodeConflict
+	case http.StatusPreconditionFailed:
+		return CodePreconditionFailed
+	case http.StatusRequestEntityTooLarge:
+		return CodeFileTooLarge
+	case http.StatusUnsupportedMediaType:
+		return CodeUnsupportedMedia
+	case http.StatusUnprocessableEntity:
+		return CodeValidation
+	case http.StatusTooManyRequests:
+		return CodeRateLimited
+	case http.StatusServiceUnavailable, http.StatusBadGateway:
+		return CodeUnavailable
+	case http.StatusGatewayTimeout:
+		return CodeTimeout
+	case http.StatusNotImplemented:
+		return CodeNotImplemented
+	}
+	if status >= 400 && status < 500 {
+		return CodeInvalidRequest
+	}
+	return CodeInternal
+}
+
+// Error is an application error with a code and a client-safe message. Err,
+// when set, is the underlying cause; it is logged but never sent to clients.
+type Error struct {
+	Code    Code
+	Message string
+	Err     error
+}
+
+// New returns an error with code and message.
+func New(code Code, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+// Wrap returns an error with code and message caused by err.
+func Wrap(err error, code Code, message string) *Error {
+	return &Error{Code: code, Message: message, Err: err}
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return string(e.Code) + ": " + e.Message + ": " + e.Err.Error()
+	}
+	return string(e.Code) + ": " + e.Message
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// Status is the HTTP status the error is served with.
+func (e *Error) Status() int { return e.Code.Status() }
+
+// CodeOf returns the code of the first *Error in err's chain, or
+// CodeInternal.
+func CodeOf(err error) Code {
+	var appErr *Error
+	if errors.As(err, &appErr) {
+		return appErr.Code
+	}
+	return CodeInternal
+}
+
+// Postgres SQLSTATE codes the taxonomy knows about.
+const (
+	pgUniqueViolation      = "23505"
+	pgForeignKeyViolation  = "23503"
+	pgNotNullViolation     = "23502"
+	pgCheckViolation       = "23514"
+	pgExclusionViolation   = "23P01"
+	pgInvalidText          = "22P02"
+	pgStringTooLong        = "22001"
+	pgInvalidDatetime      = "22007"
+	pgDatetimeOverflow     = "22008"
+	pgSerializationFailure = "40001"
+	pgDeadlockDetected     = "40P01"
+	pgQueryCanceled        = "57014"
+)
+
+// IsUniqueViolation reports whether err is a Postgres unique constraint
+// violation.
+func IsUniqueViolation(err error) bool { return pgCode(err) == pgUniqueViolation }
+
+// IsForeignKeyViolation reports whether err is a Postgres foreign key
+// violation.
+func IsForeignKeyViolation(err error) bool { return pgCode(err) == pgForeignKeyViolation }
+
+// IsExclusionViolation reports whether err is a Postgres exclusion
+// constraint violation.
+func IsExclusionViolation(err error) bool { return pgCode(err) == pgExclusionViolation }
+
+// ConstraintName returns the constraint a Postgres error refers to, if any.
+func ConstraintName(err error) string {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.ConstraintName
+	}
+	return ""
+}
+
+func pgCode(err error) string {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code
+	}
+	return ""
+}
+
+// Classify maps err onto the taxonomy. An *Error in the chain is returned
+// as is; Postgres, sql.ErrNoRows and context errors get their matching code;
+// anything else becomes CodeInternal with fallback as the message.
+func Classify(err error, fallback string) *Error {
+	var appErr *Error
+	if errors.As(err, &appErr) {
+		return appErr
+	}
+
+	switch {
+	case err == nil:
+		return New(CodeInternal, fallback)
+	case errors.Is(err, sql.ErrNoRows):
+		return Wrap(err, CodeNotFound, "Resource not found")
+	case errors.Is(err, context.DeadlineExceeded):
+		return Wrap(err, CodeTimeout, "The request timed out")
+	case errors.Is(err, context.Canceled):
+		return Wrap(err, CodeUnavailable, "The request was canceled")
+	}
+
+	switch code := pgCode(err); {
+	case code == pgUniqueViolation, code == pgExclusionViolation:
+		return Wrap(err, CodeConflict, "Resource already exists")
+	case code == pgForeignKeyViolation:
+		return Wrap(err, CodeInvalidReference, "Referenced resource does not exist")
+	case code == pgNotNullViolation, code == pgCheckViolation, code == pgInvalidText,
+		code == pgStringTooLong, code == pgInvalidDatetime, code == pgDatetimeOverflow:
+		return Wrap(err, CodeValidation, "Invalid value")
+	case code == pgSerializationFailure, code == pgDeadlockDetected:
+		return Wrap(err, CodeConcurrentUpdate, "Concurrent update, please retry")
+	case code == pgQueryCanceled:
+		return Wrap(err, CodeTimeout, "The request timed out")
+	case len(code) == 5 && (code[:2] == "08" || code[:2] == "53" || code[:2] == "57"):
+		// connection exceptions, insufficient resources, operator intervention
+		return Wrap(err, CodeUnavailable, "Database unavailable")
+	}
+	return Wrap(err, CodeInternal, fallback)
+}
+
+// ProblemContentType is the media type of problem responses.
+const ProblemContentType = "application/problem+json"
+
+// Problem is an RFC 7807 problem details body. Success and Error repeat the
+// legacy response envelope fields so existing clients keep working.
+type Problem struct {
+	Type    string `json:"type"`
+	Title   string `json:"title"`
+	Status  int    `json:"status"`
+	Detail  string `json:"detail,omitempty"`
+	Code    Code   `json:"code"`
+	Success bool   `json:"success"`
+	Error   string `json:"error"`
+}
+
+// ProblemFor builds the problem body for err.
+func ProblemFor(err *Error) Problem {
+	status := err.Status()
+	title := http.StatusText(status)
+	if status == StatusChecksumMismatch {
+		title = "Checksum Mismatch"
+	}
+	return Problem{
+		Type:   err.Code.Type(),
+		Title:  title,
+		Status: status,
+		Detail: err.Message,
+		Code:   err.Code,
+		Error:  err.Message,
+	}
+}
+
+// Write sends err as a problem+json response. Errors that are not already
+// an *Error are classified first; server-side failures are logged with their
+// cause.
+func Write(w http.ResponseWriter, err error) {
+	appErr := Classify(err, http.StatusText(http.StatusInternalServerError))
+	if appErr.Status() >= 500 && appErr.Err != nil {
+		log.Printf("%s: %v", appErr.Message, appErr.Err)
+	}
+
+	w.Header().Set("Content-Type", ProblemContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(appErr.Status())
+	json.NewEncoder(w).Encode(ProblemFor(appErr))
+}
+
+// NotFoundHandler answers requests that match no route.
+func NotFoundHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Write(w, New(CodeNotFound, "Route not found"))
+	})
+}
+
+// MethodNotAllowedHandler answers requests whose path matches a route but
+// whose method does not.
+func MethodNotAllowedHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Write(w, New(CodeMethodNotAllowed, "Method not allowed"))
+	})
+}
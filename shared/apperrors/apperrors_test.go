@@ -0,0 +1,82 @@
+package apperrors
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want Code
+	}{
+		{"app error", fmt.Errorf("wrapped: %w", New(CodeAccessDenied, "no")), CodeAccessDenied},
+		{"no rows", fmt.Errorf("get: %w", sql.ErrNoRows), CodeNotFound},
+		{"deadline", context.DeadlineExceeded, CodeTimeout},
+		{"unique", &pgconn.PgError{Code: "23505"}, CodeConflict},
+		{"foreign key", &pgconn.PgError{Code: "23503"}, CodeInvalidReference},
+		{"bad uuid", &pgconn.PgError{Code: "22P02"}, CodeValidation},
+		{"serialization", &pgconn.PgError{Code: "40001"}, CodeConcurrentUpdate},
+		{"connection", &pgconn.PgError{Code: "08006"}, CodeUnavailable},
+		{"other", errors.New("boom"), CodeInternal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Classify(tt.err, "fallback").Code; got != tt.want {
+				t.Fatalf("Classify(%v) = %s, want %s", tt.err, got, tt.want)
+			}
+		})
+	}
+
+	if got := Classify(errors.New("boom"), "Failed to save"); got.Message != "Failed to save" || got.Status() != 500 {
+		t.Fatalf("internal error = %+v", got)
+	}
+}
+
+func TestWriteProblem(t *testing.T) {
+	rec := httptest.NewRecorder()
+	Write(rec, New(CodeProfileNotFound, "Patient profile not found"))
+
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("status = %d, want 404", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != ProblemContentType {
+		t.Fatalf("content type = %q", ct)
+	}
+
+	var problem Problem
+	if err := json.Unmarshal(rec.Body.Bytes(), &problem); err != nil {
+		t.Fatal(err)
+	}
+	want := Problem{
+		Type:   "urn:health-bar:error:profile_not_found",
+		Title:  "Not Found",
+		Status: 404,
+		Detail: "Patient profile not found",
+		Code:   CodeProfileNotFound,
+		Error:  "Patient profile not found",
+	}
+	if problem != want {
+		t.Fatalf("problem = %+v, want %+v", problem, want)
+	}
+}
+
+func TestWriteHidesCause(t *testing.T) {
+	rec := httptest.NewRecorder()
+	Write(rec, Wrap(errors.New("pq: password authentication failed"), CodeInternal, "Failed to create visit"))
+
+	var problem Problem
+	json.Unmarshal(rec.Body.Bytes(), &problem)
+	if problem.Detail != "Failed to create visit" || problem.Code != CodeInternal {
+		t.Fatalf("problem = %+v", problem)
+	}
+}
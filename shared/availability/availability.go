@@ -0,0 +1,246 @@
+// Package availability turns a doctor's calendar into bookable slots.
+//
+// A calendar has weekly windows in the doctor's timezone, cut into slots of
+// a fixed length from the start of each window. Exceptions add or remove
+// time on single dates. Slots are reported in UTC; dates are local to the
+// calendar.
+package availability
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+	_ "time/tzdata"
+
+	"health-bar/shared/models"
+)
+
+const (
+	MinSlotMinutes = 5
+	MaxSlotMinutes = 480
+	// MaxWindows is how many weekly windows a calendar can have.
+	MaxWindows = 70
+)
+
+// Clock parses "HH:MM" into minutes after midnight. "24:00" is accepted as
+// the end of the day when end is set.
+func Clock(s string, end bool) (int, error) {
+	if end && s == "24:00" {
+		return 24 * 60, nil
+	}
+	t, err := time.Parse("15:04", s)
+	if err != nil || len(s) != 5 {
+		return 0, fmt.Errorf("invalid time %q, use HH:MM", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Location loads an IANA timezone such as "Europe/Berlin".
+func Location(name string) (*time.Location, error) {
+	if name == "" || name == "Local" {
+		return nil, fmt.Errorf("timezone is required, e.g. Europe/Berlin")
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown timezone %q", name)
+	}
+	return loc, nil
+}
+
+// span is [start, end) in minutes after local midnight.
+type span struct{ start, end int }
+
+func parseSpan(start, end string) (span, error) {
+	s, err := Clock(strings.TrimSpace(start), false)
+	if err != nil {
+		return span{}, err
+	}
+	e, err := Clock(strings.TrimSpace(end), true)
+	if err != nil {
+		return span{}, err
+	}
+	if e <= s {
+		return span{}, fmt.Errorf("%s-%s ends before it starts", start, end)
+	}
+	return span{s, e}, nil
+}
+
+// Weekly validates weekly windows and returns them sorted by day and time.
+// Windows on the same day must not overlap.
+func Weekly(windows []models.AvailabilityWindow) (models.AvailabilityWindows, error) {
+	if len(windows) > MaxWindows {
+		return nil, fmt.Errorf("a calendar can have at most %d weekly windows", MaxWindows)
+	}
+	out := make(models.AvailabilityWindows, 0, len(windows))
+	for _, w := range windows {
+		if w.Weekday < 0 || w.Weekday > 6 {
+			return nil, fmt.Errorf("weekday must be between 0 (Sunday) and 6 (Saturday)")
+		}
+		if _, err := parseSpan(w.Start, w.End); err != nil {
+			return nil, err
+		}
+		out = append(out, models.AvailabilityWindow{Weekday: w.Weekday, Start: strings.TrimSpace(w.Start), End: strings.TrimSpace(w.End)})
+	}
+	slices.SortFunc(out, func(a, b models.AvailabilityWindow) int {
+		if a.Weekday != b.Weekday {
+			return a.Weekday - b.Weekday
+		}
+		return strings.Compare(a.Start, b.Start)
+	})
+	for i := 1; i < len(out); i++ {
+		if out[i].Weekday == out[i-1].Weekday && out[i].Start < out[i-1].End {
+			return nil, fmt.Errorf("windows %s-%s and %s-%s on weekday %d overlap",
+				out[i-1].Start, out[i-1].End, out[i].Start, out[i].End, out[i].Weekday)
+		}
+	}
+	return out, nil
+}
+
+// Exception validates the times of an exception: both or neither.
+func Exception(e models.AvailabilityException) error {
+	if (e.Start == nil) != (e.End == nil) {
+		return fmt.Errorf("start and end go together")
+	}
+	if e.Start == nil {
+		if e.Available {
+			return fmt.Errorf("extra availability needs a start and an end")
+		}
+		return nil
+	}
+	_, err := parseSpan(*e.Start, *e.End)
+	return err
+}
+
+// Slot is one bookable period.
+type Slot struct {
+	StartsAt  time.Time `json:"starts_at"`
+	EndsAt    time.Time `json:"ends_at"`
+	LocalTime string    `json:"local_time"`
+}
+
+func dateOf(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// spans returns the bookable time on date: the weekly windows with the
+// exceptions for that date applied in order, merged and sorted.
+func spans(cal models.DoctorCalendar, exceptions []models.AvailabilityException, date time.Time) []span {
+	var out []span
+	for _, w := range cal.Weekly {
+		if w.Weekday == int(date.Weekday()) {
+			if s, err := parseSpan(w.Start, w.End); err == nil {
+				out = append(out, s)
+			}
+		}
+	}
+	for _, e := range exceptions {
+		if !dateOf(e.Date).Equal(date) {
+			continue
+		}
+		if e.Start == nil {
+			if !e.Available {
+				out = nil
+			}
+			continue
+		}
+		s, err := parseSpan(*e.Start, *e.End)
+		if err != nil {
+			continue
+		}
+		if e.Available {
+			out = append(out, s)
+		} else {
+			out = subtract(out, s)
+		}
+	}
+	return merge(out)
+}
+
+func subtract(spans []span, cut span) []span {
+	var out []span
+	for _, s := range spans {
+		if cut.end <= s.start || cut.start >= s.end {
+			out = append(out, s)
+			continue
+		}
+		if s.start < cut.start {
+			out = append(out, span{s.start, cut.start})
+		}
+		if cut.end < s.end {
+			out = append(out, span{cut.end, s.end})
+		}
+	}
+	return out
+}
+
+func merge(spans []span) []span {
+	slices.SortFunc(spans, func(a, b span) int { return a.start - b.start })
+	var out []span
+	for _, s := range spans {
+		if n := len(out); n > 0 && s.start <= out[n-1].end {
+			out[n-1].end = max(out[n-1].end, s.end)
+			continue
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+// Slots lists every slot of cal on the local dates from through to, booked
+// or not, in time order.
+func Slots(cal models.DoctorCalendar, exceptions []models.AvailabilityException, from, to time.Time) []Slot {
+	loc, err := Location(cal.Timezone)
+	if err != nil || cal.SlotMinutes < MinSlotMinutes {
+		return nil
+	}
+	length := time.Duration(cal.SlotMinutes) * time.Minute
+
+	slots := []Slot{}
+	for date := dateOf(from); !date.After(dateOf(to)); date = date.AddDate(0, 0, 1) {
+		for _, s := range spans(cal, exceptions, date) {
+			for m := s.start; m+cal.SlotMinutes <= s.end; m += cal.SlotMinutes {
+				start := time.Date(date.Year(), date.Month(), date.Day(), m/60, m%60, 0, 0, loc)
+				slots = append(slots, Slot{
+					StartsAt:  start.UTC(),
+					EndsAt:    start.Add(length).UTC(),
+					LocalTime: start.Format("2006-01-02T15:04"),
+				})
+			}
+		}
+	}
+	return slots
+}
+
+// Find returns the slot of cal starting at t, if there is one.
+func Find(cal models.DoctorCalendar, exceptions []models.AvailabilityException, t time.Time) (Slot, bool) {
+	loc, err := Location(cal.Timezone)
+	if err != nil {
+		return Slot{}, false
+	}
+	date := dateOf(t.In(loc))
+	for _, s := range Slots(cal, exceptions, date, date) {
+		if s.StartsAt.Equal(t) {
+			return s, true
+		}
+	}
+	return Slot{}, false
+}
+
+// Free removes the slots that overlap booked appointments or start before
+// now.
+func Free(slots []Slot, booked []models.Appointment, now time.Time) []Slot {
+	free := []Slot{}
+	for _, s := range slots {
+		if s.StartsAt.Before(now) {
+			continue
+		}
+		taken := slices.ContainsFunc(booked, func(a models.Appointment) bool {
+			return a.Status != models.AppointmentCancelled && a.StartsAt.Before(s.EndsAt) && s.StartsAt.Before(a.EndsAt)
+		})
+		if !taken {
+			free = append(free, s)
+		}
+	}
+	return free
+}
@@ -0,0 +1,122 @@
+package availability
+
+import (
+	"testing"
+	"time"
+
+	"health-bar/shared/models"
+)
+
+func date(s string) time.Time {
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func clock(s string) *string { return &s }
+
+// calendar has Monday 09:00-11:00 and 14:00-15:00 in 30 minute slots.
+func calendar(tz string) models.DoctorCalendar {
+	return models.DoctorCalendar{
+		DoctorID:    "d",
+		Timezone:    tz,
+		SlotMinutes: 30,
+		Weekly: models.AvailabilityWindows{
+			{Weekday: 1, Start: "09:00", End: "11:00"},
+			{Weekday: 1, Start: "14:00", End: "15:00"},
+		},
+	}
+}
+
+func TestWeekly(t *testing.T) {
+	weekly, err := Weekly([]models.AvailabilityWindow{{Weekday: 1, Start: "14:00", End: "15:00"}, {Weekday: 1, Start: "09:00", End: "11:00"}, {Weekday: 0, Start: "10:00", End: "24:00"}})
+	if err != nil || weekly[0].Weekday != 0 || weekly[1].Start != "09:00" {
+		t.Fatalf("Weekly = %v, %v", weekly, err)
+	}
+	for name, bad := range map[string][]models.AvailabilityWindow{
+		"overlap":  {{Weekday: 1, Start: "09:00", End: "11:00"}, {Weekday: 1, Start: "10:30", End: "12:00"}},
+		"backward": {{Weekday: 1, Start: "11:00", End: "09:00"}},
+		"weekday":  {{Weekday: 7, Start: "09:00", End: "11:00"}},
+		"clock":    {{Weekday: 1, Start: "9am", End: "11:00"}},
+	} {
+		if _, err := Weekly(bad); err == nil {
+			t.Errorf("%s: Weekly succeeded", name)
+		}
+	}
+}
+
+func TestSlots(t *testing.T) {
+	// 2024-06-03 is a Monday; Berlin is UTC+2 in summer
+	slots := Slots(calendar("Europe/Berlin"), nil, date("2024-06-02"), date("2024-06-04"))
+	if len(slots) != 6 {
+		t.Fatalf("%d slots, want 6: %v", len(slots), slots)
+	}
+	if want := time.Date(2024, 6, 3, 7, 0, 0, 0, time.UTC); !slots[0].StartsAt.Equal(want) || slots[0].LocalTime != "2024-06-03T09:00" {
+		t.Fatalf("first slot = %+v", slots[0])
+	}
+	if !slots[0].EndsAt.Equal(slots[1].StartsAt) || slots[4].LocalTime != "2024-06-03T14:00" {
+		t.Fatalf("slots = %v", slots)
+	}
+}
+
+func TestExceptions(t *testing.T) {
+	cal := calendar("UTC")
+	monday := date("2024-06-03")
+	exceptions := []models.AvailabilityException{
+		// Out 09:30-10:30, then in on the afternoon of the same day until 16:00
+		{Date: monday, Start: clock("09:30"), End: clock("10:30")},
+		{Date: monday, Start: clock("15:00"), End: clock("16:00"), Available: true},
+	}
+	var got []string
+	for _, s := range Slots(cal, exceptions, monday, monday) {
+		got = append(got, s.LocalTime[11:])
+	}
+	want := []string{"09:00", "10:30", "14:00", "14:30", "15:00", "15:30"}
+	if len(got) != len(want) {
+		t.Fatalf("slots = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("slots = %v, want %v", got, want)
+		}
+	}
+
+	dayOff := append(exceptions, models.AvailabilityException{Date: monday})
+	if slots := Slots(cal, dayOff, monday, monday); len(slots) != 0 {
+		t.Fatalf("slots on a day off = %v", slots)
+	}
+
+	if err := Exception(models.AvailabilityException{Start: clock("09:00")}); err == nil {
+		t.Error("exception with a start but no end accepted")
+	}
+	if err := Exception(models.AvailabilityException{Available: true}); err == nil {
+		t.Error("all-day extra availability accepted")
+	}
+}
+
+func TestFindAndFree(t *testing.T) {
+	cal := calendar("America/New_York")
+	nineAM := time.Date(2024, 6, 3, 13, 0, 0, 0, time.UTC)
+
+	if _, ok := Find(cal, nil, nineAM); !ok {
+		t.Fatal("09:00 New York is not a slot")
+	}
+	if _, ok := Find(cal, nil, nineAM.Add(10*time.Minute)); ok {
+		t.Fatal("09:10 New York is a slot")
+	}
+
+	slots := Slots(cal, nil, date("2024-06-03"), date("2024-06-03"))
+	booked := []models.Appointment{
+		{StartsAt: nineAM, EndsAt: nineAM.Add(30 * time.Minute), Status: models.AppointmentBooked},
+		{StartsAt: nineAM.Add(time.Hour), EndsAt: nineAM.Add(90 * time.Minute), Status: models.AppointmentCancelled},
+	}
+	free := Free(slots, booked, nineAM.Add(-time.Hour))
+	if len(free) != len(slots)-1 || free[0].StartsAt.Equal(nineAM) {
+		t.Fatalf("free = %v", free)
+	}
+	if free := Free(slots, nil, nineAM.Add(time.Hour)); len(free) != len(slots)-2 {
+		t.Fatalf("free after 10:00 = %v", free)
+	}
+}
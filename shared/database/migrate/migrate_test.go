@@ -0,0 +1,166 @@
+package migrate
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"health-bar/database/migrations"
+)
+
+func TestLoadOrdersByVersion(t *testing.T) {
+	fsys := fstest.MapFS{
+		"010_add_visits.up.sql":   {Data: []byte("CREATE TABLE visits ();")},
+		"010_add_visits.down.sql": {Data: []byte("DROP TABLE visits;")},
+		"2_add_users.up.sql":      {Data: []byte("CREATE TABLE users ();")},
+		"001_init.up.sql":         {Data: []byte("CREATE TABLE init ();")},
+		"001_init.down.sql":       {Data: []byte("DROP TABLE init;")},
+		"README.md":               {Data: []byte("not a migration")},
+		"seeds/001_seed.up.sql":   {Data: []byte("INSERT INTO users DEFAULT VALUES;")},
+	}
+
+	migrations, err := Load(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, m := range migrations {
+		names = append(names, m.Name)
+	}
+	if strings.Join(names, ",") != "init,add_users,add_visits" {
+		t.Fatalf("order = %v", names)
+	}
+
+	visits := migrations[2]
+	if visits.Version != 10 || visits.Up != "CREATE TABLE visits ();" || visits.Down != "DROP TABLE visits;" {
+		t.Fatalf("visits = %+v", visits)
+	}
+	sum := sha256.Sum256([]byte(visits.Up))
+	if visits.Checksum != hex.EncodeToString(sum[:]) {
+		t.Fatalf("checksum = %s", visits.Checksum)
+	}
+	if migrations[1].Down != "" {
+		t.Fatalf("add_users has a down file: %+v", migrations[1])
+	}
+}
+
+func TestLoadRejectsBadFiles(t *testing.T) {
+	tests := []struct {
+		name  string
+		files []string
+		want  string
+	}{
+		{"bad name", []string{"001-init.up.sql"}, `invalid migration file name "001-init.up.sql"`},
+		{"upper case", []string{"001_Init.up.sql"}, "invalid migration file name"},
+		{"no direction", []string{"001_init.sql"}, "invalid migration file name"},
+		{"down only", []string{"001_init.down.sql"}, "migration 1_init has no up file"},
+		{"shared version", []string{"001_init.up.sql", "001_users.up.sql"}, `version 1 used by both "init" and "users"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys := fstest.MapFS{}
+			for _, name := range tt.files {
+				fsys[name] = &fstest.MapFile{Data: []byte("SELECT 1;")}
+			}
+			if _, err := Load(fsys); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("Load = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyRejectsModifiedMigrations(t *testing.T) {
+	m := &Migrator{migrations: []Migration{
+		{Version: 1, Name: "init", Checksum: "aaa"},
+		{Version: 2, Name: "add_users", Checksum: "bbb"},
+	}}
+
+	if err := m.verify(map[int64]Status{1: {Version: 1, Checksum: "aaa"}}); err != nil {
+		t.Fatalf("unchanged: %v", err)
+	}
+	// Applied migrations that are gone locally are Status's concern
+	if err := m.verify(map[int64]Status{1: {Version: 1, Checksum: "aaa"}, 9: {Version: 9, Checksum: "zzz"}}); err != nil {
+		t.Fatalf("missing locally: %v", err)
+	}
+
+	err := m.verify(map[int64]Status{1: {Version: 1, Checksum: "aaa"}, 2: {Version: 2, Checksum: "edited"}})
+	if !errors.Is(err, ErrChecksumMismatch) || !strings.Contains(err.Error(), "2_add_users") {
+		t.Fatalf("modified: %v", err)
+	}
+}
+
+func TestStatuses(t *testing.T) {
+	appliedAt := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	migrations := []Migration{
+		{Version: 1, Name: "init", Checksum: "aaa"},
+		{Version: 2, Name: "add_users", Checksum: "bbb"},
+		{Version: 4, Name: "add_visits", Checksum: "ddd"},
+	}
+	current := map[int64]Status{
+		1: {Version: 1, Name: "init", Checksum: "aaa", AppliedAt: &appliedAt},
+		2: {Version: 2, Name: "add_users", Checksum: "edited", AppliedAt: &appliedAt},
+		3: {Version: 3, Name: "dropped", Checksum: "ccc", AppliedAt: &appliedAt},
+	}
+
+	got := statuses(migrations, current)
+	want := []Status{
+		{Version: 1, Name: "init", Checksum: "aaa", Applied: true, AppliedAt: &appliedAt},
+		{Version: 2, Name: "add_users", Checksum: "bbb", Applied: true, AppliedAt: &appliedAt, Modified: true},
+		{Version: 3, Name: "dropped", Checksum: "ccc", Applied: true, AppliedAt: &appliedAt, Missing: true},
+		{Version: 4, Name: "add_visits", Checksum: "ddd"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("statuses = %+v", got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("statuses[%d] = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+	if len(current) != 3 {
+		t.Fatalf("statuses changed current: %+v", current)
+	}
+}
+
+func TestCreateNumbersAfterTheLatest(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"001_init.up.sql", "007_add_users.up.sql"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("SELECT 1;"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	up, down, err := Create(dir, "  Add Visit Notes! ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Base(up) != "008_add_visit_notes.up.sql" || filepath.Base(down) != "008_add_visit_notes.down.sql" {
+		t.Fatalf("created %s, %s", up, down)
+	}
+	migrations, err := Load(os.DirFS(dir))
+	if err != nil || len(migrations) != 3 || migrations[2].Version != 8 {
+		t.Fatalf("Load = %+v, %v", migrations, err)
+	}
+
+	if _, _, err := Create(dir, "!!!"); err == nil {
+		t.Fatal("created a migration without a name")
+	}
+}
+
+func TestRepositoryMigrationsLoad(t *testing.T) {
+	loaded, err := Load(migrations.FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, m := range loaded {
+		if m.Version != int64(i+1) || m.Down == "" {
+			t.Errorf("migration %d_%s: versions must run 1, 2, ... and each needs a down file", m.Version, m.Name)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package database
+
+import (
+    "context"
+    "database/sql"
+    "fmt"
+    "time"
+
+    "github.com/jmoiron/sqlx"
+)
+
+// QueryTimeout bounds every individual repository query. A caller's own
+// deadline wins when it is earlier.
+var QueryTimeout = 5 * time.Second
+
+// DBTX is satisfied by both *sqlx.DB and *sqlx.Tx, so repositories can run
+// the same statements inside or outside a transaction.
+type DBTX interface {
+    sqlx.ExtContext
+    GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error
+    SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error
+}
+
+type txKey struct{}
+
+// WithTimeout derives a context that expires after QueryTimeout.
+func WithTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+    return context.WithTimeout(ctx, QueryTimeout)
+}
+
+// Conn returns the transaction carried by ctx, or db when there is none.
+func Conn(ctx context.Context, db *sqlx.DB) DBTX {
+    if tx, ok := ctx.Value(txKey{}).(*sqlx.Tx); ok {
+        return tx
+    }
+    return db
+}
+
+// InTx reports whether ctx already carries a transaction.
+func InTx(ctx context.Context) bool {
+    _, ok := ctx.Value(txKey{}).(*sqlx.Tx)
+    return ok
+}
+
+// WithTx runs fn as a single unit of work. Repository calls made with the
+// context passed to fn share one transaction, which is committed when fn
+// returns nil and rolled back otherwise. Nested calls join the outer
+// transaction.
+func WithTx(ctx context.Context, db *sqlx.DB, fn func(ctx context.Context) error) error {
+    return withTx(ctx, db, nil, fn)
+}
+
+// WithSnapshot runs fn in a read-only REPEATABLE READ transaction, so every
+// read it makes sees the database as of its first one. WithTx runs at READ
+// COMMITTED, where each statement sees whatever committed before it. Nested
+// in another transaction, fn joins it and gets its isolation instead.
+func WithSnapshot(ctx context.Context, db *sqlx.DB, fn func(ctx context.Context) error) error {
+    return withTx(ctx, db, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true}, fn)
+}
+
+func withTx(ctx context.Context, db *sqlx.DB, opts *sql.TxOptions, fn func(ctx context.Context) error) error {
+    if InTx(ctx) {
+        return fn(ctx)
+    }
+
+    tx, err := db.BeginTxx(ctx, opts)
+    if err != nil {
+        return fmt.Errorf("failed to begin transaction: %w", err)
+    }
+
+    defer func() {
+        if p := recover(); p != nil {
+            tx.Rollback()
+            panic(p)
+        }
+    }()
+
+    if err := fn(context.WithValue(ctx, txKey{}, tx)); err != nil {
+        tx.Rollback()
+        return err
+    }
+
+    if err := tx.Commit(); err != nil {
+        return fmt.Errorf("failed to commit transaction: %w", err)
+    }
+    return nil
+}
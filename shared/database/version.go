@@ -0,0 +1,29 @@
+package database
+
+import (
+    "context"
+    "database/sql"
+    "errors"
+
+    "health-bar/shared/apperrors"
+)
+
+// ErrVersionConflict is returned by a version-guarded update when the row
+// changed after the caller read it.
+var ErrVersionConflict = apperrors.New(apperrors.CodePreconditionFailed, "The resource was modified by another request")
+
+// VersionError resolves the error of an update guarded by
+// "($n = 0 OR version = $n)". When the update matched no row although the
+// caller expected a version and the row exists, the row has moved on and
+// ErrVersionConflict is returned; otherwise err is returned unchanged.
+func VersionError(ctx context.Context, db DBTX, err error, expected int, existsQuery string, args ...interface{}) error {
+    if !errors.Is(err, sql.ErrNoRows) || expected == 0 {
+        return err
+    }
+
+    var exists bool
+    if lookupErr := db.GetContext(ctx, &exists, existsQuery, args...); lookupErr != nil || !exists {
+        return err
+    }
+    return ErrVersionConflict
+}
@@ -0,0 +1,369 @@
+// Package envelope encrypts files at rest with envelope encryption. Each
+// file gets its own random data key, which encrypts the content with
+// AES-256-GCM in chunks, so files of any size stream through in constant
+// memory. The data key is stored with the file, wrapped by a master key
+// from a KeyProvider, so rotating master keys only rewrites file headers.
+//
+// A sealed file is a header followed by the chunks:
+//
+//	"HBE1"              magic
+//	uint8   n, n bytes  ID of the master key that wrapped the data key
+//	uint16  n, n bytes  wrapped data key
+//	7 bytes             random nonce prefix
+//	chunks              64 KiB of plaintext each, sealed with a 16 byte tag
+//
+// Chunk i is sealed with the nonce prefix || uint32 i || 1 for the last
+// chunk, 0 for the others, as in the STREAM construction, so chunks cannot
+// be reordered, dropped or appended, and a truncated file fails to open.
+// Integers are big-endian. The last chunk may be empty; a file with no
+// content is one empty chunk.
+package envelope
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+const (
+	// Magic starts every sealed file.
+	Magic = "HBE1"
+
+	chunkSize    = 64 << 10
+	tagSize      = 16
+	prefixSize   = 7
+	dataKeySize  = 32
+	maxKeyIDSize = math.MaxUint8
+)
+
+var (
+	// ErrNotEncrypted is returned for content that does not start with Magic.
+	ErrNotEncrypted = errors.New("envelope: not encrypted")
+	// ErrCorrupt is returned for a file that was modified, truncated or
+	// sealed for another name.
+	ErrCorrupt = errors.New("envelope: file is corrupt or was tampered with")
+	// ErrTooLarge is returned when a file needs more chunks than nonces allow.
+	ErrTooLarge = errors.New("envelope: file too large")
+)
+
+// IsSealed reports whether prefix, the first bytes of a file, starts a
+// sealed file.
+func IsSealed(prefix []byte) bool {
+	return bytes.HasPrefix(prefix, []byte(Magic))
+}
+
+// Header is the part of a sealed file before its content.
+type Header struct {
+	KeyID      string
+	WrappedKey []byte
+	prefix     [prefixSize]byte
+}
+
+// ReadHeader reads a header from r, leaving r at the first chunk.
+func ReadHeader(r io.Reader) (*Header, error) {
+	var magic [len(Magic)]byte
+	if _, err := io.ReadFull(r, magic[:]); err != nil || string(magic[:]) != Magic {
+		return nil, ErrNotEncrypted
+	}
+	h := &Header{}
+	var idLen [1]byte
+	if _, err := io.ReadFull(r, idLen[:]); err != nil {
+		return nil, ErrCorrupt
+	}
+	id := make([]byte, idLen[0])
+	if _, err := io.ReadFull(r, id); err != nil {
+		return nil, ErrCorrupt
+	}
+	h.KeyID = string(id)
+	var wrappedLen [2]byte
+	if _, err := io.ReadFull(r, wrappedLen[:]); err != nil {
+		return nil, ErrCorrupt
+	}
+	h.WrappedKey = make([]byte, binary.BigEndian.Uint16(wrappedLen[:]))
+	if _, err := io.ReadFull(r, h.WrappedKey); err != nil {
+		return nil, ErrCorrupt
+	}
+	if _, err := io.ReadFull(r, h.prefix[:]); err != nil {
+		return nil, ErrCorrupt
+	}
+	return h, nil
+}
+
+// Bytes encodes the header.
+func (h *Header) Bytes() []byte {
+	b := make([]byte, 0, h.Size())
+	b = append(b, Magic...)
+	b = append(b, byte(len(h.KeyID)))
+	b = append(b, h.KeyID...)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(h.WrappedKey)))
+	b = append(b, h.WrappedKey...)
+	return append(b, h.prefix[:]...)
+}
+
+// Size is the length of the encoded header.
+func (h *Header) Size() int64 {
+	return int64(len(Magic) + 1 + len(h.KeyID) + 2 + len(h.WrappedKey) + prefixSize)
+}
+
+// SealedSize is the size of a file with this header and n bytes of content.
+func (h *Header) SealedSize(n int64) int64 {
+	chunks := max(1, (n+chunkSize-1)/chunkSize)
+	return h.Size() + n + chunks*tagSize
+}
+
+// PlaintextSize is the content size of a sealed file of the given size with
+// this header. It is the inverse of SealedSize.
+func (h *Header) PlaintextSize(sealed int64) int64 {
+	body := sealed - h.Size()
+	full, rest := body/(chunkSize+tagSize), body%(chunkSize+tagSize)
+	if rest == 0 {
+		return full * chunkSize
+	}
+	return full*chunkSize + max(0, rest-tagSize)
+}
+
+// Rewrap wraps the data key with the provider's current master key. The
+// content stays as it is: write the new header followed by the old chunks.
+func (h *Header) Rewrap(ctx context.Context, keys KeyProvider) error {
+	dataKey, err := keys.Unwrap(ctx, h.KeyID, h.WrappedKey)
+	if err != nil {
+		return err
+	}
+	keyID, wrapped, err := keys.Wrap(ctx, dataKey)
+	if err != nil {
+		return err
+	}
+	if err := checkWrapped(keyID, wrapped); err != nil {
+		return err
+	}
+	h.KeyID, h.WrappedKey = keyID, wrapped
+	return nil
+}
+
+func checkWrapped(keyID string, wrapped []byte) error {
+	if keyID == "" || len(keyID) > maxKeyIDSize || len(wrapped) > math.MaxUint16 {
+		return fmt.Errorf("envelope: key provider returned key ID %q with %d bytes", keyID, len(wrapped))
+	}
+	return nil
+}
+
+// stream seals or opens the chunks of one file.
+type stream struct {
+	aead    cipher.AEAD
+	aad     []byte
+	prefix  [prefixSize]byte
+	counter uint32
+}
+
+func newStream(dataKey []byte, prefix [prefixSize]byte, aad []byte) (*stream, error) {
+	block, err := aes.NewCipher(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &stream{aead: aead, aad: aad, prefix: prefix}, nil
+}
+
+// nonce is the nonce of the next chunk.
+func (s *stream) nonce(last bool) []byte {
+	nonce := make([]byte, 0, prefixSize+5)
+	nonce = append(nonce, s.prefix[:]...)
+	nonce = binary.BigEndian.AppendUint32(nonce, s.counter)
+	if last {
+		return append(nonce, 1)
+	}
+	return append(nonce, 0)
+}
+
+// next moves to the following chunk.
+func (s *stream) next() error {
+	if s.counter == math.MaxUint32 {
+		return ErrTooLarge
+	}
+	s.counter++
+	return nil
+}
+
+// readChunk reads the next chunk of up to size bytes into buf and reports
+// whether it is the last one. It returns io.EOF when there is no chunk.
+func readChunk(r *bufio.Reader, buf []byte) (int, bool, error) {
+	n, err := io.ReadFull(r, buf)
+	switch err {
+	case io.ErrUnexpectedEOF:
+		return n, true, nil
+	case nil:
+		if _, err := r.Peek(1); err == io.EOF {
+			return n, true, nil
+		} else if err != nil {
+			return n, false, err
+		}
+		return n, false, nil
+	}
+	return n, false, err
+}
+
+// Encryptor reads as the sealed form of its source.
+type Encryptor struct {
+	Header *Header
+
+	src    *bufio.Reader
+	stream *stream
+	plain  []byte
+	sealed []byte
+	out    []byte
+	done   bool
+	err    error
+}
+
+// Encrypt returns a reader of plaintext sealed with a new data key, wrapped
+// by the provider's current master key. aad, such as the name the file is
+// stored under, must be given again to open it.
+func Encrypt(ctx context.Context, plaintext io.Reader, keys KeyProvider, aad []byte) (*Encryptor, error) {
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	keyID, wrapped, err := keys.Wrap(ctx, dataKey)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkWrapped(keyID, wrapped); err != nil {
+		return nil, err
+	}
+	header := &Header{KeyID: keyID, WrappedKey: wrapped}
+	if _, err := rand.Read(header.prefix[:]); err != nil {
+		return nil, err
+	}
+	stream, err := newStream(dataKey, header.prefix, aad)
+	if err != nil {
+		return nil, err
+	}
+	return &Encryptor{
+		Header: header,
+		src:    bufio.NewReaderSize(plaintext, chunkSize),
+		stream: stream,
+		plain:  make([]byte, chunkSize),
+		sealed: make([]byte, 0, chunkSize+tagSize),
+		out:    header.Bytes(),
+	}, nil
+}
+
+func (e *Encryptor) Read(p []byte) (int, error) {
+	for len(e.out) == 0 {
+		if e.err != nil {
+			return 0, e.err
+		}
+		if e.done {
+			return 0, io.EOF
+		}
+		e.err = e.seal()
+	}
+	n := copy(p, e.out)
+	e.out = e.out[n:]
+	return n, nil
+}
+
+// seal seals the next chunk into out.
+func (e *Encryptor) seal() error {
+	n, last, err := readChunk(e.src, e.plain)
+	if err == io.EOF {
+		// An empty source is one empty chunk
+		last, err = true, nil
+	}
+	if err != nil {
+		return err
+	}
+	e.out = e.stream.aead.Seal(e.sealed[:0], e.stream.nonce(last), e.plain[:n], e.stream.aad)
+	if last {
+		e.done = true
+		return nil
+	}
+	return e.stream.next()
+}
+
+// Decryptor reads as the content of a sealed file. It returns ErrCorrupt,
+// possibly after some content, when the file does not open.
+type Decryptor struct {
+	Header *Header
+
+	src    *bufio.Reader
+	stream *stream
+	sealed []byte
+	plain  []byte
+	out    []byte
+	done   bool
+	err    error
+}
+
+// Decrypt reads the header of a sealed file and unwraps its data key. aad
+// must be what the file was sealed with.
+func Decrypt(ctx context.Context, sealed io.Reader, keys KeyProvider, aad []byte) (*Decryptor, error) {
+	src := bufio.NewReaderSize(sealed, chunkSize+tagSize)
+	header, err := ReadHeader(src)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := keys.Unwrap(ctx, header.KeyID, header.WrappedKey)
+	if err != nil {
+		return nil, err
+	}
+	stream, err := newStream(dataKey, header.prefix, aad)
+	if err != nil {
+		return nil, ErrCorrupt
+	}
+	return &Decryptor{
+		Header: header,
+		src:    src,
+		stream: stream,
+		sealed: make([]byte, chunkSize+tagSize),
+		plain:  make([]byte, 0, chunkSize),
+	}, nil
+}
+
+func (d *Decryptor) Read(p []byte) (int, error) {
+	for len(d.out) == 0 {
+		if d.err != nil {
+			return 0, d.err
+		}
+		if d.done {
+			return 0, io.EOF
+		}
+		d.err = d.open()
+	}
+	n := copy(p, d.out)
+	d.out = d.out[n:]
+	return n, nil
+}
+
+// open opens the next chunk into out.
+func (d *Decryptor) open() error {
+	n, last, err := readChunk(d.src, d.sealed)
+	if err == io.EOF {
+		// The last chunk is missing
+		return ErrCorrupt
+	}
+	if err != nil {
+		return err
+	}
+	plain, err := d.stream.aead.Open(d.plain[:0], d.stream.nonce(last), d.sealed[:n], d.stream.aad)
+	if err != nil {
+		return ErrCorrupt
+	}
+	d.out = plain
+	if last {
+		d.done = true
+		return nil
+	}
+	return d.stream.next()
+}
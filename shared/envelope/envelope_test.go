@@ -0,0 +1,229 @@
+package envelope
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testKeyring(t *testing.T, ids ...string) *Keyring {
+	t.Helper()
+	entries := make([]string, len(ids))
+	for i, id := range ids {
+		entry, err := GenerateKey(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries[i] = entry
+	}
+	keys, err := ParseKeyring(strings.Join(entries, ","))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+func seal(t *testing.T, keys KeyProvider, plaintext []byte, aad string) []byte {
+	t.Helper()
+	enc, err := Encrypt(context.Background(), bytes.NewReader(plaintext), keys, []byte(aad))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := io.ReadAll(enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int64(len(sealed)) != enc.Header.SealedSize(int64(len(plaintext))) {
+		t.Fatalf("sealed %d bytes into %d, SealedSize = %d", len(plaintext), len(sealed), enc.Header.SealedSize(int64(len(plaintext))))
+	}
+	return sealed
+}
+
+func open(keys KeyProvider, sealed []byte, aad string) ([]byte, error) {
+	dec, err := Decrypt(context.Background(), bytes.NewReader(sealed), keys, []byte(aad))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(dec)
+}
+
+func TestRoundTrip(t *testing.T) {
+	keys := testKeyring(t, "k1")
+	for _, size := range []int{0, 1, chunkSize - 1, chunkSize, chunkSize + 1, 3*chunkSize + 100} {
+		plaintext := make([]byte, size)
+		rand.Read(plaintext)
+
+		sealed := seal(t, keys, plaintext, "p1_a.pdf")
+		if size >= 16 && bytes.Contains(sealed, plaintext[:16]) {
+			t.Fatalf("size %d: plaintext visible in sealed file", size)
+		}
+		header, err := ReadHeader(bytes.NewReader(sealed))
+		if err != nil || header.KeyID != "k1" {
+			t.Fatalf("size %d: header = %+v, %v", size, header, err)
+		}
+		if got := header.PlaintextSize(int64(len(sealed))); got != int64(size) {
+			t.Fatalf("size %d: PlaintextSize = %d", size, got)
+		}
+
+		opened, err := open(keys, sealed, "p1_a.pdf")
+		if err != nil || !bytes.Equal(opened, plaintext) {
+			t.Fatalf("size %d: opened %d bytes, %v", size, len(opened), err)
+		}
+	}
+}
+
+func TestTamperingIsDetected(t *testing.T) {
+	keys := testKeyring(t, "k1")
+	plaintext := bytes.Repeat([]byte("x"), 2*chunkSize+10)
+	sealed := seal(t, keys, plaintext, "p1_a.pdf")
+	header, _ := ReadHeader(bytes.NewReader(sealed))
+	firstChunk := int(header.Size())
+	chunk := chunkSize + tagSize
+
+	flipped := bytes.Clone(sealed)
+	flipped[len(flipped)-1] ^= 1
+	reordered := bytes.Clone(sealed)
+	copy(reordered[firstChunk:], sealed[firstChunk+chunk:firstChunk+2*chunk])
+	copy(reordered[firstChunk+chunk:], sealed[firstChunk:firstChunk+chunk])
+
+	for name, tampered := range map[string][]byte{
+		"flipped bit":          flipped,
+		"reordered chunks":     reordered,
+		"truncated at a chunk": sealed[:firstChunk+2*chunk],
+		"truncated in a chunk": sealed[:len(sealed)-5],
+		"no chunks":            sealed[:firstChunk],
+		"appended":             append(bytes.Clone(sealed), 0),
+	} {
+		if _, err := open(keys, tampered, "p1_a.pdf"); err != ErrCorrupt {
+			t.Errorf("%s: err = %v, want ErrCorrupt", name, err)
+		}
+	}
+
+	if _, err := open(keys, sealed, "p2_b.pdf"); err != ErrCorrupt {
+		t.Errorf("opened under another name: err = %v", err)
+	}
+	if _, err := open(keys, []byte("%PDF-1.4"), "p1_a.pdf"); err != ErrNotEncrypted {
+		t.Errorf("plaintext: err = %v", err)
+	}
+	if _, err := open(testKeyring(t, "k2"), sealed, "p1_a.pdf"); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("without the master key: err = %v", err)
+	}
+}
+
+func TestRewrapKeepsContent(t *testing.T) {
+	ctx := context.Background()
+	plaintext := []byte("%PDF-1.4 scan")
+	k1, _ := GenerateKey("k1")
+	k2, _ := GenerateKey("k2")
+	old, _ := ParseKeyring(k1)
+	sealed := seal(t, old, plaintext, "p1_a.pdf")
+
+	// k2 is added in front of k1, then k1 is retired once files are rewrapped
+	rotated, _ := ParseKeyring(k2 + "\n" + k1)
+
+	src := bytes.NewReader(sealed)
+	header, err := ReadHeader(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldSize := header.Size()
+	if err := header.Rewrap(ctx, rotated); err != nil {
+		t.Fatal(err)
+	}
+	rest, _ := io.ReadAll(src)
+	rewrapped := append(header.Bytes(), rest...)
+
+	if !bytes.Equal(rewrapped[header.Size():], sealed[oldSize:]) {
+		t.Fatal("rewrapping changed the content")
+	}
+	retired, _ := ParseKeyring(k2)
+	opened, err := open(retired, rewrapped, "p1_a.pdf")
+	if err != nil || !bytes.Equal(opened, plaintext) {
+		t.Fatalf("opened %q, %v", opened, err)
+	}
+	if _, err := open(retired, sealed, "p1_a.pdf"); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("file not rewrapped: err = %v", err)
+	}
+}
+
+func TestParseKeyring(t *testing.T) {
+	k1, _ := GenerateKey("2026-01")
+	k2, _ := GenerateKey("2026-07")
+	keys, err := ParseKeyring("# rotated in July\n" + k2 + "\n\n" + k1 + "\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current, _ := keys.Current(context.Background()); current != "2026-07" || len(keys.keys) != 2 {
+		t.Fatalf("keyring = %+v", keys)
+	}
+
+	for _, text := range []string{"", "# nothing", "k1", "k1:c2hvcnQ=", "bad id:" + strings.Split(k1, ":")[1], k1 + "," + k1} {
+		if _, err := ParseKeyring(text); err == nil {
+			t.Errorf("ParseKeyring(%q) succeeded", text)
+		}
+	}
+}
+
+func TestFileKeysReload(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "keys")
+	k1, _ := GenerateKey("k1")
+	k2, _ := GenerateKey("k2")
+	os.WriteFile(path, []byte(k1), 0600)
+
+	keys, err := NewFileKeys(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed := seal(t, keys, []byte("scan"), "p1_a.pdf")
+
+	os.WriteFile(path, []byte(k2+"\n"+k1), 0600)
+	later := time.Now().Add(time.Second)
+	os.Chtimes(path, later, later)
+	if current, _ := keys.Current(ctx); current != "k2" {
+		t.Fatalf("current key after rotation = %s", current)
+	}
+	if opened, err := open(keys, sealed, "p1_a.pdf"); err != nil || string(opened) != "scan" {
+		t.Fatalf("opened %q, %v", opened, err)
+	}
+
+	// A broken file keeps the keys read before
+	os.WriteFile(path, []byte("garbage"), 0600)
+	later = later.Add(time.Second)
+	os.Chtimes(path, later, later)
+	if current, err := keys.Current(ctx); err != nil || current != "k2" {
+		t.Fatalf("current key after a bad write = %s, %v", current, err)
+	}
+
+	if _, err := NewFileKeys(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Fatal("NewFileKeys of a missing file succeeded")
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	t.Setenv("ENCRYPTION_KEY_FILE", "")
+	t.Setenv("ENCRYPTION_KEYS", "")
+	if keys, err := FromEnv(); keys != nil || err != nil {
+		t.Fatalf("FromEnv without keys = %v, %v", keys, err)
+	}
+
+	k1, _ := GenerateKey("k1")
+	t.Setenv("ENCRYPTION_KEYS", k1)
+	if keys, err := FromEnv(); err != nil {
+		t.Fatal(err)
+	} else if _, ok := keys.(*Keyring); !ok {
+		t.Fatalf("FromEnv = %T", keys)
+	}
+
+	t.Setenv("ENCRYPTION_KEY_FILE", filepath.Join(t.TempDir(), "missing"))
+	if keys, err := FromEnv(); keys != nil || err == nil {
+		t.Fatalf("FromEnv with a missing file = %v, %v", keys, err)
+	}
+}
@@ -0,0 +1,230 @@
+package envelope
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrUnknownKey is returned for a wrapped key whose master key is not known.
+var ErrUnknownKey = errors.New("envelope: unknown master key")
+
+// KeyProvider wraps data keys with master keys it never hands out, so a
+// provider can be backed by a key management service.
+type KeyProvider interface {
+	// Wrap encrypts dataKey with the current master key and returns that
+	// key's ID with the result.
+	Wrap(ctx context.Context, dataKey []byte) (keyID string, wrapped []byte, err error)
+	// Unwrap decrypts a data key Wrap returned, with the master key keyID.
+	Unwrap(ctx context.Context, keyID string, wrapped []byte) ([]byte, error)
+	// Current is the ID of the master key Wrap uses.
+	Current(ctx context.Context) (string, error)
+}
+
+// Keyring is a KeyProvider holding AES-256 master keys in memory. Data keys
+// are wrapped with the first key; the others are kept to unwrap data keys
+// wrapped before a rotation.
+type Keyring struct {
+	current string
+	keys    map[string]cipher.AEAD
+}
+
+// ParseKeyring reads master keys written as id:key, with key 32 bytes in
+// standard base64, separated by commas or newlines. The first one is the
+// current key. Blank lines and lines starting with '#' are skipped.
+func ParseKeyring(text string) (*Keyring, error) {
+	k := &Keyring{keys: map[string]cipher.AEAD{}}
+	for _, line := range strings.Split(text, "\n") {
+		if line = strings.TrimSpace(line); strings.HasPrefix(line, "#") {
+			continue
+		}
+		for _, entry := range strings.Split(line, ",") {
+			if entry = strings.TrimSpace(entry); entry == "" {
+				continue
+			}
+			id, encoded, ok := strings.Cut(entry, ":")
+			if !ok || !validKeyID(id) {
+				return nil, fmt.Errorf("envelope: master key %q is not id:key", id)
+			}
+			key, err := base64.StdEncoding.DecodeString(encoded)
+			if err != nil || len(key) != 32 {
+				return nil, fmt.Errorf("envelope: master key %s is not 32 bytes of base64", id)
+			}
+			if _, ok := k.keys[id]; ok {
+				return nil, fmt.Errorf("envelope: master key %s is listed twice", id)
+			}
+			block, _ := aes.NewCipher(key)
+			k.keys[id], _ = cipher.NewGCM(block)
+			if k.current == "" {
+				k.current = id
+			}
+		}
+	}
+	if k.current == "" {
+		return nil, errors.New("envelope: no master keys")
+	}
+	return k, nil
+}
+
+// GenerateKey returns a new master key for a keyring, as id:key.
+func GenerateKey(id string) (string, error) {
+	if !validKeyID(id) {
+		return "", fmt.Errorf("envelope: invalid key ID %q", id)
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return id + ":" + base64.StdEncoding.EncodeToString(key), nil
+}
+
+func validKeyID(id string) bool {
+	if id == "" || len(id) > maxKeyIDSize {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// Wrap seals dataKey with a random nonce, bound to the key ID.
+func (k *Keyring) Wrap(ctx context.Context, dataKey []byte) (string, []byte, error) {
+	aead := k.keys[k.current]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", nil, err
+	}
+	return k.current, aead.Seal(nonce, nonce, dataKey, []byte(k.current)), nil
+}
+
+func (k *Keyring) Unwrap(ctx context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	aead, ok := k.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKey, keyID)
+	}
+	if len(wrapped) < aead.NonceSize() {
+		return nil, ErrCorrupt
+	}
+	dataKey, err := aead.Open(nil, wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():], []byte(keyID))
+	if err != nil {
+		return nil, ErrCorrupt
+	}
+	return dataKey, nil
+}
+
+func (k *Keyring) Current(ctx context.Context) (string, error) {
+	return k.current, nil
+}
+
+// NewEnvKeys reads a keyring from the environment variable name.
+func NewEnvKeys(name string) (*Keyring, error) {
+	keys, err := ParseKeyring(os.Getenv(name))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return keys, nil
+}
+
+// FileKeys is a KeyProvider reading a keyring from a file, in the format of
+// ParseKeyring. The file is read again when it changes, so a rotation does
+// not need a restart.
+type FileKeys struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	keys    *Keyring
+}
+
+// NewFileKeys reads the keyring in path.
+func NewFileKeys(path string) (*FileKeys, error) {
+	f := &FileKeys{path: path}
+	if _, err := f.keyring(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// keyring returns the keys in the file, reading it again if it changed. A
+// file that became unreadable or invalid keeps the keys read before.
+func (f *FileKeys) keyring() (*Keyring, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	info, err := os.Stat(f.path)
+	if err == nil && info.ModTime().Equal(f.modTime) {
+		return f.keys, nil
+	}
+	var keys *Keyring
+	if err == nil {
+		var data []byte
+		if data, err = os.ReadFile(f.path); err == nil {
+			keys, err = ParseKeyring(string(data))
+		}
+	}
+	if err != nil {
+		if f.keys != nil {
+			return f.keys, nil
+		}
+		return nil, fmt.Errorf("%s: %w", f.path, err)
+	}
+	f.keys, f.modTime = keys, info.ModTime()
+	return keys, nil
+}
+
+func (f *FileKeys) Wrap(ctx context.Context, dataKey []byte) (string, []byte, error) {
+	keys, err := f.keyring()
+	if err != nil {
+		return "", nil, err
+	}
+	return keys.Wrap(ctx, dataKey)
+}
+
+func (f *FileKeys) Unwrap(ctx context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	keys, err := f.keyring()
+	if err != nil {
+		return nil, err
+	}
+	return keys.Unwrap(ctx, keyID, wrapped)
+}
+
+func (f *FileKeys) Current(ctx context.Context) (string, error) {
+	keys, err := f.keyring()
+	if err != nil {
+		return "", err
+	}
+	return keys.Current(ctx)
+}
+
+// FromEnv returns the key provider the environment configures: the keyring
+// file ENCRYPTION_KEY_FILE names, or the keyring in ENCRYPTION_KEYS. It
+// returns nil when neither is set, for services that then store files
+// unencrypted.
+func FromEnv() (KeyProvider, error) {
+	if path := os.Getenv("ENCRYPTION_KEY_FILE"); path != "" {
+		keys, err := NewFileKeys(path)
+		if err != nil {
+			return nil, err
+		}
+		return keys, nil
+	}
+	if os.Getenv("ENCRYPTION_KEYS") != "" {
+		keys, err := NewEnvKeys("ENCRYPTION_KEYS")
+		if err != nil {
+			return nil, err
+		}
+		return keys, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,149 @@
+package events
+
+import (
+	"context"
+	"strings"
+	"sync"
+)
+
+// Bus carries messages between services. It follows the NATS model, so
+// NATSBus and LocalBus are interchangeable: subjects are dot-separated
+// tokens, a subscription's subject may use "*" for one token and a final
+// ">" for the rest, and of the subscriptions sharing a queue group only one
+// receives each message. Subscribers that are not connected when a message
+// is published miss it, which is why events are delivered through inboxes
+// and the bus only announces them.
+type Bus interface {
+	Publish(ctx context.Context, subject string, data []byte) error
+	// Subscribe calls fn for each message on a subject matching subject.
+	// An empty queue subscribes outside any queue group.
+	Subscribe(subject, queue string, fn Handler) (Subscription, error)
+}
+
+// Message is one message received from a Bus.
+type Message struct {
+	Subject string
+	Data    []byte
+}
+
+// Handler handles the messages of a subscription. ctx ends when the bus is
+// closed.
+type Handler func(ctx context.Context, msg Message)
+
+// Subscription stops delivery to its handler when it is unsubscribed.
+type Subscription interface {
+	Unsubscribe() error
+}
+
+// Match reports whether subject matches the subscription subject pattern.
+func Match(pattern, subject string) bool {
+	patterns := strings.Split(pattern, ".")
+	tokens := strings.Split(subject, ".")
+	for i, p := range patterns {
+		if p == ">" {
+			return i == len(patterns)-1 && len(tokens) > i
+		}
+		if i >= len(tokens) || (p != "*" && p != tokens[i]) {
+			return false
+		}
+	}
+	return len(tokens) == len(patterns)
+}
+
+// validSubject reports whether subject can be published or subscribed to.
+// Wildcards are only allowed when subscribing.
+func validSubject(subject string, wildcards bool) bool {
+	if subject == "" || strings.ContainsAny(subject, " \t\r\n") {
+		return false
+	}
+	tokens := strings.Split(subject, ".")
+	for i, t := range tokens {
+		switch {
+		case t == "":
+			return false
+		case t == "*" || t == ">":
+			if !wildcards || (t == ">" && i != len(tokens)-1) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// LocalBus is a Bus inside one process, standing in for NATS where every
+// service runs together, as in tests. Publish calls the handlers before it
+// returns, so a published message has been handled by then.
+type LocalBus struct {
+	mu     sync.Mutex
+	subs   map[int]*localSub
+	nextID int
+	// turns picks the next member of each queue group
+	turns map[string]int
+}
+
+type localSub struct {
+	bus     *LocalBus
+	id      int
+	subject string
+	queue   string
+	fn      Handler
+}
+
+func NewLocalBus() *LocalBus {
+	return &LocalBus{subs: map[int]*localSub{}, turns: map[string]int{}}
+}
+
+func (b *LocalBus) Publish(ctx context.Context, subject string, data []byte) error {
+	if !validSubject(subject, false) {
+		return ErrInvalidSubject
+	}
+
+	b.mu.Lock()
+	var handlers []Handler
+	groups := map[string][]*localSub{}
+	for id := 0; id < b.nextID; id++ {
+		sub, ok := b.subs[id]
+		if !ok || !Match(sub.subject, subject) {
+			continue
+		}
+		if sub.queue == "" {
+			handlers = append(handlers, sub.fn)
+		} else {
+			groups[sub.queue] = append(groups[sub.queue], sub)
+		}
+	}
+	for queue, members := range groups {
+		turn := b.turns[queue]
+		b.turns[queue] = turn + 1
+		handlers = append(handlers, members[turn%len(members)].fn)
+	}
+	b.mu.Unlock()
+
+	msg := Message{Subject: subject, Data: append([]byte(nil), data...)}
+	for _, fn := range handlers {
+		fn(context.Background(), msg)
+	}
+	return nil
+}
+
+func (b *LocalBus) Subscribe(subject, queue string, fn Handler) (Subscription, error) {
+	if !validSubject(subject, true) || strings.ContainsAny(queue, " \t\r\n") {
+		return nil, ErrInvalidSubject
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	sub := &localSub{bus: b, id: b.nextID, subject: subject, queue: queue, fn: fn}
+	b.subs[sub.id] = sub
+	b.nextID++
+	return sub, nil
+}
+
+func (s *localSub) Unsubscribe() error {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+
+	delete(s.bus.subs, s.id)
+	return nil
+}
@@ -0,0 +1,161 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"health-bar/shared/models"
+)
+
+const (
+	// consumerBatch is how many inbox events are claimed at a time.
+	consumerBatch = 100
+	// consumerLease keeps claimed events from other replicas while they
+	// are handled.
+	consumerLease = time.Minute
+)
+
+// InboxStore keeps the inboxes of consumers. PostgresInbox and MemoryInbox
+// implement it.
+type InboxStore interface {
+	// Register records that consumer consumes topics, so the relay copies
+	// their events into its inbox from then on.
+	Register(ctx context.Context, consumer string, topics []string) error
+	// Claim gets up to limit due events of consumer, oldest first, and
+	// holds them back from its other replicas for lease.
+	Claim(ctx context.Context, consumer string, lease time.Duration, limit int) ([]models.InboxEvent, error)
+	// Remove deletes a handled event.
+	Remove(ctx context.Context, consumer, eventID string) error
+	// Retry records a failure to handle an event, which is due again
+	// after retryIn.
+	Retry(ctx context.Context, consumer, eventID string, retryIn time.Duration, lastError string) error
+}
+
+// Consumer handles the events in one service's inbox. Replicas of a service
+// share a consumer name and so an inbox; each event is claimed by one of
+// them at a time. An event whose handler fails is retried with backoff until
+// it succeeds, so handlers must tolerate events they already handled.
+type Consumer struct {
+	name     string
+	store    InboxStore
+	bus      Bus
+	handlers map[string]func(ctx context.Context, e Event) error
+	wake     chan struct{}
+}
+
+// NewConsumer returns a consumer named name. The bus only wakes it early:
+// it reads events from store either way.
+func NewConsumer(name string, store InboxStore, bus Bus) *Consumer {
+	return &Consumer{
+		name:     name,
+		store:    store,
+		bus:      bus,
+		handlers: map[string]func(ctx context.Context, e Event) error{},
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// Handle sets the handler of topic's events. It must be called before
+// Start.
+func (c *Consumer) Handle(topic string, fn func(ctx context.Context, e Event) error) {
+	c.handlers[topic] = fn
+}
+
+// Start registers the consumer's topics and subscribes to them on the bus.
+// Events recorded before a consumer first starts do not reach it.
+func (c *Consumer) Start(ctx context.Context) error {
+	topics := make([]string, 0, len(c.handlers))
+	for topic := range c.handlers {
+		if !validSubject(topic, false) {
+			return fmt.Errorf("%w: %q", ErrInvalidSubject, topic)
+		}
+		topics = append(topics, topic)
+	}
+	if err := c.store.Register(ctx, c.name, topics); err != nil {
+		return fmt.Errorf("events: register %s: %w", c.name, err)
+	}
+	for _, topic := range topics {
+		_, err := c.bus.Subscribe(topic, c.name, func(ctx context.Context, msg Message) {
+			select {
+			case c.wake <- struct{}{}:
+			default:
+			}
+		})
+		if err != nil {
+			return fmt.Errorf("events: subscribe %s to %s: %w", c.name, topic, err)
+		}
+	}
+	return nil
+}
+
+// Process handles every due event in the inbox and returns how many were
+// handled successfully.
+func (c *Consumer) Process(ctx context.Context) (int, error) {
+	handled := 0
+	for {
+		claimed, err := c.store.Claim(ctx, c.name, consumerLease, consumerBatch)
+		if err != nil {
+			return handled, err
+		}
+		for _, row := range claimed {
+			err := c.handle(ctx, row)
+			if ctx.Err() != nil {
+				return handled, ctx.Err()
+			}
+			if err != nil {
+				log.Printf("events: %s: %s %s: %v", c.name, row.Topic, row.EventID, err)
+				if err := c.store.Retry(ctx, c.name, row.EventID, retryIn(row.Attempts+1), err.Error()); err != nil {
+					return handled, err
+				}
+				continue
+			}
+			// An event handled but not removed is handled again once its
+			// lease runs out
+			if err := c.store.Remove(ctx, c.name, row.EventID); err != nil {
+				return handled, err
+			}
+			handled++
+		}
+		if len(claimed) < consumerBatch {
+			return handled, nil
+		}
+	}
+}
+
+// handle runs the handler of one inbox event. Events that do not decode, or
+// of topics no longer handled, are logged and dropped: retrying cannot help.
+func (c *Consumer) handle(ctx context.Context, row models.InboxEvent) error {
+	e, err := Decode(row.Payload)
+	if err != nil {
+		log.Printf("events: %s: dropping undecodable event %s: %v", c.name, row.EventID, err)
+		return nil
+	}
+	fn, ok := c.handlers[row.Topic]
+	if !ok {
+		log.Printf("events: %s: dropping %s event %s, which it no longer handles", c.name, row.Topic, row.EventID)
+		return nil
+	}
+	return fn(ctx, e)
+}
+
+// RunEvery processes the inbox now, whenever the bus announces an event,
+// and every interval until ctx is done.
+func (c *Consumer) RunEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := c.Process(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("events: %s: %v", c.name, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-c.wake:
+		}
+	}
+}
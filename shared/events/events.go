@@ -0,0 +1,89 @@
+// Package events carries domain events between services. A service records
+// the events of a change in its outbox, inside the transaction that makes
+// the change, so they commit or roll back with it. Services consume topics
+// through a Consumer, which registers them; a relay moves each recorded
+// event into the inbox of every consumer of its topic and announces it on a
+// Bus. A consumer handles the events in its inbox and retries those that
+// fail, so an event reaches a service that was down when it was recorded.
+//
+// Delivery is at least once: an event is handled again when its consumer
+// fails before removing it, so handlers must tolerate duplicates.
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Topics events are published on. Subscribers in other services depend on
+// them, so add new topics rather than changing existing ones.
+const (
+	TopicPatientDeleted = "patient.deleted"
+	TopicAccessRevoked  = "access.revoked"
+)
+
+// Event is one occurrence of a topic. Its JSON encoding is what the bus
+// carries; ID stays the same when the event is published again.
+type Event struct {
+	ID        string            `json:"id"`
+	Topic     string            `json:"topic"`
+	CreatedAt time.Time         `json:"created_at"`
+	Data      map[string]string `json:"data"`
+}
+
+// Payload encodes e for the bus.
+func (e Event) Payload() []byte {
+	b, _ := json.Marshal(e)
+	return b
+}
+
+// Decode decodes an event published on the bus.
+func Decode(data []byte) (Event, error) {
+	var e Event
+	err := json.Unmarshal(data, &e)
+	return e, err
+}
+
+func newEvent(topic string, data map[string]string) Event {
+	return Event{
+		ID:        uuid.New().String(),
+		Topic:     topic,
+		CreatedAt: time.Now().UTC().Truncate(time.Microsecond),
+		Data:      data,
+	}
+}
+
+// PatientDeleted announces that a patient profile and the rows that
+// reference it were deleted. Data the database does not own, such as
+// uploaded files, is left to subscribers.
+func PatientDeleted(patientID, userID string) Event {
+	return newEvent(TopicPatientDeleted, map[string]string{
+		"patient_id": patientID,
+		"user_id":    userID,
+	})
+}
+
+// AccessRevoked announces that a patient revoked a doctor's access to their
+// record.
+func AccessRevoked(patientID, doctorID, doctorUserID string) Event {
+	return newEvent(TopicAccessRevoked, map[string]string{
+		"patient_id":     patientID,
+		"doctor_id":      doctorID,
+		"doctor_user_id": doctorUserID,
+	})
+}
+
+// Outbox records events for the relay. Add must be called with the context
+// of the transaction that makes the change the events describe.
+type Outbox interface {
+	Add(ctx context.Context, events ...Event) error
+}
+
+// Discard drops every event. It is the default until an outbox is
+// configured.
+type Discard struct{}
+
+func (Discard) Add(ctx context.Context, events ...Event) error { return nil }
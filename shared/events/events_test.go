@@ -0,0 +1,268 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"health-bar/shared/memdb"
+	"health-bar/shared/models"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern, subject string
+		want             bool
+	}{
+		{"patient.deleted", "patient.deleted", true},
+		{"patient.deleted", "patient.created", false},
+		{"patient.*", "patient.deleted", true},
+		{"patient.*", "patient.deleted.files", false},
+		{"*.deleted", "patient.deleted", true},
+		{"patient.>", "patient.deleted.files", true},
+		{"patient.>", "patient", false},
+		{">", "access.revoked", true},
+	}
+	for _, tt := range tests {
+		if got := Match(tt.pattern, tt.subject); got != tt.want {
+			t.Errorf("Match(%q, %q) = %v, want %v", tt.pattern, tt.subject, got, tt.want)
+		}
+	}
+}
+
+func TestLocalBusQueueGroups(t *testing.T) {
+	bus := NewLocalBus()
+	counts := map[string]int{}
+	handler := func(name string) Handler {
+		return func(ctx context.Context, msg Message) { counts[name]++ }
+	}
+	bus.Subscribe("patient.deleted", "prescription", handler("prescription-1"))
+	bus.Subscribe("patient.deleted", "prescription", handler("prescription-2"))
+	bus.Subscribe("patient.*", "", handler("audit"))
+	unsubscribed, _ := bus.Subscribe("patient.deleted", "", handler("gone"))
+	unsubscribed.Unsubscribe()
+
+	for i := 0; i < 4; i++ {
+		if err := bus.Publish(context.Background(), "patient.deleted", []byte("{}")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if counts["prescription-1"] != 2 || counts["prescription-2"] != 2 || counts["audit"] != 4 || counts["gone"] != 0 {
+		t.Fatalf("counts = %v", counts)
+	}
+
+	if err := bus.Publish(context.Background(), "patient.*", nil); !errors.Is(err, ErrInvalidSubject) {
+		t.Fatalf("publishing to a wildcard = %v", err)
+	}
+	if _, err := bus.Subscribe("patient.>.x", "", handler("bad")); !errors.Is(err, ErrInvalidSubject) {
+		t.Fatalf("subscribing to a misplaced > = %v", err)
+	}
+}
+
+type failingBus struct{ err error }
+
+func (b failingBus) Publish(ctx context.Context, subject string, data []byte) error { return b.err }
+
+func (b failingBus) Subscribe(subject, queue string, fn Handler) (Subscription, error) {
+	return nil, b.err
+}
+
+func TestRelayForwardsToInboxes(t *testing.T) {
+	ctx := context.Background()
+	db := memdb.New()
+	outbox := NewMemoryOutbox(db)
+	inbox := NewMemoryInbox(db)
+	inbox.Register(ctx, "prescription", []string{TopicPatientDeleted})
+	inbox.Register(ctx, "notification", []string{TopicPatientDeleted, TopicAccessRevoked})
+	first := PatientDeleted("p1", "u1")
+	second := AccessRevoked("p2", "d1", "u2")
+	second.CreatedAt = first.CreatedAt.Add(time.Second)
+	if err := outbox.Add(ctx, second, first); err != nil {
+		t.Fatal(err)
+	}
+
+	bus := NewLocalBus()
+	var topics []string
+	bus.Subscribe(">", "", func(ctx context.Context, msg Message) { topics = append(topics, msg.Subject) })
+
+	published, err := NewRelay(outbox, bus).Publish(ctx)
+	if err != nil || published != 2 {
+		t.Fatalf("Publish = %d, %v", published, err)
+	}
+	if strings.Join(topics, ",") != "patient.deleted,access.revoked" {
+		t.Fatalf("published %v", topics)
+	}
+	if len(db.OutboxEvents.Rows) != 0 {
+		t.Fatalf("outbox = %+v", db.OutboxEvents.Rows)
+	}
+	for key, want := range map[string]string{
+		"prescription/" + first.ID:  TopicPatientDeleted,
+		"notification/" + first.ID:  TopicPatientDeleted,
+		"notification/" + second.ID: TopicAccessRevoked,
+	} {
+		if row, ok := db.EventInbox.Rows[key]; !ok || row.Topic != want {
+			t.Errorf("inbox %s = %+v", key, row)
+		}
+	}
+	if len(db.EventInbox.Rows) != 3 {
+		t.Fatalf("inbox = %+v", db.EventInbox.Rows)
+	}
+}
+
+// failingOutbox cannot forward events.
+type failingOutbox struct {
+	*MemoryOutbox
+}
+
+func (o failingOutbox) Forward(ctx context.Context, id string) error {
+	return errors.New("database down")
+}
+
+func TestRelayRetriesWithBackoff(t *testing.T) {
+	db := memdb.New()
+	now := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	db.Now = func() time.Time { return now }
+	outbox := NewMemoryOutbox(db)
+	event := PatientDeleted("p1", "u1")
+	outbox.Add(context.Background(), event)
+
+	relay := NewRelay(failingOutbox{outbox}, NewLocalBus())
+	for attempt := 1; attempt <= 3; attempt++ {
+		if published, err := relay.Publish(context.Background()); err != nil || published != 0 {
+			t.Fatalf("Publish = %d, %v", published, err)
+		}
+		row := db.OutboxEvents.Rows[event.ID]
+		wait := time.Duration(1<<(attempt-1)) * time.Second
+		if row.Attempts != attempt || row.LastError != "database down" || !row.NextAttemptAt.Equal(now.Add(wait)) {
+			t.Fatalf("after attempt %d: %+v", attempt, row)
+		}
+		now = row.NextAttemptAt
+	}
+
+	if got := retryIn(20); got != relayMaxRetry {
+		t.Fatalf("retryIn(20) = %s", got)
+	}
+}
+
+func TestRelayDoesNotNeedTheBus(t *testing.T) {
+	ctx := context.Background()
+	db := memdb.New()
+	outbox := NewMemoryOutbox(db)
+	NewMemoryInbox(db).Register(ctx, "prescription", []string{TopicPatientDeleted})
+	event := PatientDeleted("p1", "u1")
+	outbox.Add(ctx, event)
+
+	// The event is safe in the inbox; only its announcement is lost
+	published, err := NewRelay(outbox, failingBus{errors.New("bus down")}).Publish(ctx)
+	if err != nil || published != 1 || len(db.OutboxEvents.Rows) != 0 {
+		t.Fatalf("Publish = %d, %v; outbox = %+v", published, err, db.OutboxEvents.Rows)
+	}
+	if _, ok := db.EventInbox.Rows["prescription/"+event.ID]; !ok {
+		t.Fatalf("inbox = %+v", db.EventInbox.Rows)
+	}
+}
+
+func TestMemoryOutboxRollsBackWithTransaction(t *testing.T) {
+	db := memdb.New()
+	outbox := NewMemoryOutbox(db)
+	err := db.WithTx(context.Background(), func(ctx context.Context) error {
+		if err := outbox.Add(ctx, PatientDeleted("p1", "u1")); err != nil {
+			return err
+		}
+		return errors.New("change failed")
+	})
+	if err == nil || len(db.OutboxEvents.Rows) != 0 {
+		t.Fatalf("err = %v, outbox = %+v", err, db.OutboxEvents.Rows)
+	}
+}
+
+func TestConsumerHandlesEventsRelayedWhileItWasDown(t *testing.T) {
+	ctx := context.Background()
+	db := memdb.New()
+	now := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	db.Now = func() time.Time { return now }
+	outbox := NewMemoryOutbox(db)
+	bus := NewLocalBus()
+
+	var handled []string
+	fail := errors.New("disk full")
+	consumer := NewConsumer("prescription", NewMemoryInbox(db), bus)
+	consumer.Handle(TopicPatientDeleted, func(ctx context.Context, e Event) error {
+		if fail != nil {
+			return fail
+		}
+		handled = append(handled, e.Data["patient_id"])
+		return nil
+	})
+	if err := consumer.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Relayed while nothing handles the inbox: the announcement only wakes
+	// the consumer
+	event := PatientDeleted("p1", "u1")
+	outbox.Add(ctx, event)
+	if _, err := NewRelay(outbox, bus).Publish(ctx); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-consumer.wake:
+	default:
+		t.Fatal("announcement did not wake the consumer")
+	}
+
+	// A failing handler leaves the event to be retried with backoff
+	if n, err := consumer.Process(ctx); err != nil || n != 0 {
+		t.Fatalf("Process = %d, %v", n, err)
+	}
+	row := db.EventInbox.Rows["prescription/"+event.ID]
+	if row.Attempts != 1 || row.LastError != "disk full" || !row.NextAttemptAt.Equal(now.Add(time.Second)) {
+		t.Fatalf("after failure: %+v", row)
+	}
+	if n, _ := consumer.Process(ctx); n != 0 {
+		t.Fatal("event retried before it was due")
+	}
+
+	now, fail = row.NextAttemptAt, nil
+	if n, err := consumer.Process(ctx); err != nil || n != 1 {
+		t.Fatalf("Process = %d, %v", n, err)
+	}
+	if strings.Join(handled, ",") != "p1" || len(db.EventInbox.Rows) != 0 {
+		t.Fatalf("handled %v, inbox %+v", handled, db.EventInbox.Rows)
+	}
+}
+
+func TestConsumerDropsUndecodableEvents(t *testing.T) {
+	ctx := context.Background()
+	db := memdb.New()
+	inbox := NewMemoryInbox(db)
+	consumer := NewConsumer("prescription", inbox, NewLocalBus())
+	consumer.Handle(TopicPatientDeleted, func(ctx context.Context, e Event) error {
+		t.Fatalf("handled %+v", e)
+		return nil
+	})
+	if err := consumer.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	db.EventInbox.Rows["prescription/e1"] = models.InboxEvent{
+		Consumer: "prescription", EventID: "e1", Topic: TopicPatientDeleted,
+		Payload: models.RawJSON(`{"id":`), NextAttemptAt: db.Now(), CreatedAt: db.Now(),
+	}
+
+	if _, err := consumer.Process(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(db.EventInbox.Rows) != 0 {
+		t.Fatalf("inbox = %+v", db.EventInbox.Rows)
+	}
+}
+
+func TestConsumerRejectsPatternTopics(t *testing.T) {
+	consumer := NewConsumer("prescription", NewMemoryInbox(memdb.New()), NewLocalBus())
+	consumer.Handle("patient.*", func(ctx context.Context, e Event) error { return nil })
+	if err := consumer.Start(context.Background()); !errors.Is(err, ErrInvalidSubject) {
+		t.Fatalf("Start = %v", err)
+	}
+}
@@ -0,0 +1,184 @@
+package events
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"time"
+
+	"health-bar/shared/memdb"
+	"health-bar/shared/models"
+)
+
+// MemoryOutbox mirrors PostgresOutbox on top of memdb. Inside a memdb
+// transaction the events it adds roll back with everything else.
+type MemoryOutbox struct {
+	db *memdb.DB
+}
+
+func NewMemoryOutbox(db *memdb.DB) *MemoryOutbox {
+	return &MemoryOutbox{db: db}
+}
+
+func (o *MemoryOutbox) Add(ctx context.Context, events ...Event) error {
+	o.db.Lock()
+	defer o.db.Unlock()
+
+	now := o.db.Now()
+	for _, e := range events {
+		o.db.OutboxEvents.Rows[e.ID] = models.OutboxEvent{
+			ID:            e.ID,
+			Topic:         e.Topic,
+			Payload:       e.Payload(),
+			NextAttemptAt: now,
+			CreatedAt:     e.CreatedAt,
+		}
+	}
+	return nil
+}
+
+func (o *MemoryOutbox) Claim(ctx context.Context, lease time.Duration, limit int) ([]models.OutboxEvent, error) {
+	o.db.Lock()
+	defer o.db.Unlock()
+
+	now := o.db.Now()
+	claimed := []models.OutboxEvent{}
+	for _, e := range o.db.OutboxEvents.Rows {
+		if !e.NextAttemptAt.After(now) {
+			claimed = append(claimed, e)
+		}
+	}
+	sortOutbox(claimed)
+	if len(claimed) > limit {
+		claimed = claimed[:limit]
+	}
+	for i := range claimed {
+		claimed[i].NextAttemptAt = now.Add(lease)
+		o.db.OutboxEvents.Rows[claimed[i].ID] = claimed[i]
+	}
+	return claimed, nil
+}
+
+func (o *MemoryOutbox) Forward(ctx context.Context, id string) error {
+	o.db.Lock()
+	defer o.db.Unlock()
+
+	e, ok := o.db.OutboxEvents.Rows[id]
+	if !ok {
+		return nil
+	}
+	now := o.db.Now()
+	for _, c := range o.db.EventConsumers.Rows {
+		key := c.Consumer + "/" + e.ID
+		if _, ok := o.db.EventInbox.Rows[key]; c.Topic != e.Topic || ok {
+			continue
+		}
+		o.db.EventInbox.Rows[key] = models.InboxEvent{
+			Consumer:      c.Consumer,
+			EventID:       e.ID,
+			Topic:         e.Topic,
+			Payload:       e.Payload,
+			NextAttemptAt: now,
+			CreatedAt:     e.CreatedAt,
+		}
+	}
+	delete(o.db.OutboxEvents.Rows, id)
+	return nil
+}
+
+func (o *MemoryOutbox) Retry(ctx context.Context, id string, retryIn time.Duration, lastError string) error {
+	o.db.Lock()
+	defer o.db.Unlock()
+
+	e, ok := o.db.OutboxEvents.Rows[id]
+	if !ok {
+		return nil
+	}
+	e.Attempts++
+	e.NextAttemptAt = o.db.Now().Add(retryIn)
+	e.LastError = lastError
+	o.db.OutboxEvents.Rows[id] = e
+	return nil
+}
+
+// MemoryInbox mirrors PostgresInbox on top of memdb.
+type MemoryInbox struct {
+	db *memdb.DB
+}
+
+func NewMemoryInbox(db *memdb.DB) *MemoryInbox {
+	return &MemoryInbox{db: db}
+}
+
+func (i *MemoryInbox) Register(ctx context.Context, consumer string, topics []string) error {
+	i.db.Lock()
+	defer i.db.Unlock()
+
+	for _, topic := range topics {
+		key := consumer + "/" + topic
+		if _, ok := i.db.EventConsumers.Rows[key]; !ok {
+			i.db.EventConsumers.Rows[key] = models.EventConsumer{Consumer: consumer, Topic: topic, CreatedAt: i.db.Now()}
+		}
+	}
+	return nil
+}
+
+func (i *MemoryInbox) Claim(ctx context.Context, consumer string, lease time.Duration, limit int) ([]models.InboxEvent, error) {
+	i.db.Lock()
+	defer i.db.Unlock()
+
+	now := i.db.Now()
+	claimed := []models.InboxEvent{}
+	for _, e := range i.db.EventInbox.Rows {
+		if e.Consumer == consumer && !e.NextAttemptAt.After(now) {
+			claimed = append(claimed, e)
+		}
+	}
+	sortInbox(claimed)
+	if len(claimed) > limit {
+		claimed = claimed[:limit]
+	}
+	for j := range claimed {
+		claimed[j].NextAttemptAt = now.Add(lease)
+		i.db.EventInbox.Rows[consumer+"/"+claimed[j].EventID] = claimed[j]
+	}
+	return claimed, nil
+}
+
+func (i *MemoryInbox) Remove(ctx context.Context, consumer, eventID string) error {
+	i.db.Lock()
+	defer i.db.Unlock()
+
+	delete(i.db.EventInbox.Rows, consumer+"/"+eventID)
+	return nil
+}
+
+func (i *MemoryInbox) Retry(ctx context.Context, consumer, eventID string, retryIn time.Duration, lastError string) error {
+	i.db.Lock()
+	defer i.db.Unlock()
+
+	key := consumer + "/" + eventID
+	e, ok := i.db.EventInbox.Rows[key]
+	if !ok {
+		return nil
+	}
+	e.Attempts++
+	e.NextAttemptAt = i.db.Now().Add(retryIn)
+	e.LastError = lastError
+	i.db.EventInbox.Rows[key] = e
+	return nil
+}
+
+// sortOutbox puts events in the order they were recorded.
+func sortOutbox(events []models.OutboxEvent) {
+	slices.SortFunc(events, func(a, b models.OutboxEvent) int {
+		return cmp.Or(a.CreatedAt.Compare(b.CreatedAt), cmp.Compare(a.ID, b.ID))
+	})
+}
+
+// sortInbox puts inbox events in the order they were recorded.
+func sortInbox(events []models.InboxEvent) {
+	slices.SortFunc(events, func(a, b models.InboxEvent) int {
+		return cmp.Or(a.CreatedAt.Compare(b.CreatedAt), cmp.Compare(a.EventID, b.EventID))
+	})
+}
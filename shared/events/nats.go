@@ -0,0 +1,351 @@
+package events
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrInvalidSubject is returned for a subject or queue group the bus
+	// does not accept.
+	ErrInvalidSubject = errors.New("events: invalid subject")
+	// ErrNotConnected is returned by Publish while the bus is reconnecting.
+	ErrNotConnected = errors.New("events: not connected")
+	// ErrClosed is returned once the bus is closed.
+	ErrClosed = errors.New("events: bus closed")
+)
+
+const (
+	natsDialTimeout  = 5 * time.Second
+	natsMaxReconnect = 30 * time.Second
+	// natsPending is how many messages a subscription buffers before it
+	// drops new ones, as a NATS server does with slow consumers.
+	natsPending = 256
+	// natsMaxLine bounds a protocol line; INFO is the longest
+	natsMaxLine = 32 << 10
+)
+
+// NATSBus is a Bus on a NATS server, speaking the core NATS text protocol.
+// It connects in the background and reconnects with backoff when the
+// connection drops, subscribing again as it does; Publish fails while it is
+// not connected.
+type NATSBus struct {
+	addr string
+	user string
+	pass string
+	name string
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu      sync.Mutex
+	conn    net.Conn
+	w       *bufio.Writer
+	subs    map[int]*natsSub
+	nextSID int
+}
+
+type natsSub struct {
+	bus     *NATSBus
+	sid     int
+	subject string
+	queue   string
+	msgs    chan Message
+	stop    chan struct{}
+}
+
+// DialNATS returns a bus on the server at rawURL, nats://[user:pass@]host:port,
+// that identifies itself as name. It does not wait for the connection.
+func DialNATS(rawURL, name string) (*NATSBus, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "nats" || u.Host == "" {
+		return nil, fmt.Errorf("events: invalid NATS URL %q", rawURL)
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "4222")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	b := &NATSBus{addr: addr, name: name, ctx: ctx, cancel: cancel, done: make(chan struct{}), subs: map[int]*natsSub{}}
+	if u.User != nil {
+		b.user = u.User.Username()
+		b.pass, _ = u.User.Password()
+	}
+	go b.run()
+	return b, nil
+}
+
+// Close disconnects and stops every subscription.
+func (b *NATSBus) Close() error {
+	b.cancel()
+	b.mu.Lock()
+	if b.conn != nil {
+		b.conn.Close()
+	}
+	for _, sub := range b.subs {
+		close(sub.stop)
+	}
+	b.subs = map[int]*natsSub{}
+	b.mu.Unlock()
+	<-b.done
+	return nil
+}
+
+// Connected reports whether the bus is connected.
+func (b *NATSBus) Connected() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.conn != nil
+}
+
+func (b *NATSBus) Publish(ctx context.Context, subject string, data []byte) error {
+	if !validSubject(subject, false) {
+		return ErrInvalidSubject
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.ctx.Err() != nil {
+		return ErrClosed
+	}
+	if b.conn == nil {
+		return ErrNotConnected
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		b.conn.SetWriteDeadline(deadline)
+		defer b.conn.SetWriteDeadline(time.Time{})
+	}
+	fmt.Fprintf(b.w, "PUB %s %d\r\n", subject, len(data))
+	b.w.Write(data)
+	b.w.WriteString("\r\n")
+	return b.w.Flush()
+}
+
+func (b *NATSBus) Subscribe(subject, queue string, fn Handler) (Subscription, error) {
+	if !validSubject(subject, true) || strings.ContainsAny(queue, " \t\r\n") {
+		return nil, ErrInvalidSubject
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.ctx.Err() != nil {
+		return nil, ErrClosed
+	}
+	b.nextSID++
+	sub := &natsSub{bus: b, sid: b.nextSID, subject: subject, queue: queue, msgs: make(chan Message, natsPending), stop: make(chan struct{})}
+	b.subs[sub.sid] = sub
+	if b.conn != nil {
+		// A failed write drops the connection, and reconnecting subscribes
+		// again
+		sub.write(b.w)
+		b.w.Flush()
+	}
+
+	go func() {
+		for {
+			select {
+			case msg := <-sub.msgs:
+				fn(b.ctx, msg)
+			case <-sub.stop:
+				return
+			}
+		}
+	}()
+	return sub, nil
+}
+
+func (s *natsSub) write(w *bufio.Writer) {
+	if s.queue == "" {
+		fmt.Fprintf(w, "SUB %s %d\r\n", s.subject, s.sid)
+	} else {
+		fmt.Fprintf(w, "SUB %s %s %d\r\n", s.subject, s.queue, s.sid)
+	}
+}
+
+func (s *natsSub) Unsubscribe() error {
+	b := s.bus
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.subs[s.sid]; !ok {
+		return nil
+	}
+	delete(b.subs, s.sid)
+	close(s.stop)
+	if b.conn != nil {
+		fmt.Fprintf(b.w, "UNSUB %d\r\n", s.sid)
+		return b.w.Flush()
+	}
+	return nil
+}
+
+// run keeps the bus connected until it is closed.
+func (b *NATSBus) run() {
+	defer close(b.done)
+
+	wait := 100 * time.Millisecond
+	for b.ctx.Err() == nil {
+		conn, r, err := b.connect()
+		if err == nil {
+			wait = 100 * time.Millisecond
+			err = b.read(r)
+			b.mu.Lock()
+			b.conn, b.w = nil, nil
+			b.mu.Unlock()
+			conn.Close()
+		}
+		if b.ctx.Err() != nil {
+			return
+		}
+		log.Printf("events: NATS %s: %v; reconnecting in %s", b.addr, err, wait)
+
+		select {
+		case <-b.ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+		wait = min(2*wait, natsMaxReconnect)
+	}
+}
+
+// connect dials the server, completes the handshake and subscribes every
+// subscription.
+func (b *NATSBus) connect() (net.Conn, *bufio.Reader, error) {
+	var d net.Dialer
+	ctx, cancel := context.WithTimeout(b.ctx, natsDialTimeout)
+	defer cancel()
+	conn, err := d.DialContext(ctx, "tcp", b.addr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	conn.SetDeadline(time.Now().Add(natsDialTimeout))
+	r := bufio.NewReaderSize(conn, natsMaxLine)
+	line, err := readLine(r)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		conn.Close()
+		return nil, nil, fmt.Errorf("unexpected greeting %q", line)
+	}
+
+	options := map[string]interface{}{"verbose": false, "pedantic": false, "name": b.name, "lang": "go", "version": "1"}
+	if b.user != "" {
+		options["user"], options["pass"] = b.user, b.pass
+	}
+	connect, _ := json.Marshal(options)
+	w := bufio.NewWriter(conn)
+	fmt.Fprintf(w, "CONNECT %s\r\nPING\r\n", connect)
+	if err := w.Flush(); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	// The server answers an unacceptable CONNECT with -ERR instead of PONG
+	if line, err = readLine(r); err != nil || line != "PONG" {
+		conn.Close()
+		if err == nil {
+			err = fmt.Errorf("handshake refused: %s", line)
+		}
+		return nil, nil, err
+	}
+	conn.SetDeadline(time.Time{})
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, sub := range b.subs {
+		sub.write(w)
+	}
+	if err := w.Flush(); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	b.conn, b.w = conn, w
+	return conn, r, nil
+}
+
+// read handles what the server sends until the connection fails.
+func (b *NATSBus) read(r *bufio.Reader) error {
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			return err
+		}
+		verb, args, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "MSG":
+			if err := b.deliver(r, strings.Fields(args)); err != nil {
+				return err
+			}
+		case "PING":
+			b.mu.Lock()
+			if b.w != nil {
+				b.w.WriteString("PONG\r\n")
+				b.w.Flush()
+			}
+			b.mu.Unlock()
+		case "-ERR":
+			log.Printf("events: NATS %s: %s", b.addr, args)
+		}
+	}
+}
+
+// deliver reads the payload of a MSG line, whose arguments are
+// "<subject> <sid> [reply-to] <#bytes>", and queues it for its subscription.
+func (b *NATSBus) deliver(r *bufio.Reader, args []string) error {
+	if len(args) != 3 && len(args) != 4 {
+		return fmt.Errorf("malformed MSG %v", args)
+	}
+	sid, err := strconv.Atoi(args[1])
+	if err != nil {
+		return fmt.Errorf("malformed MSG %v", args)
+	}
+	size, err := strconv.Atoi(args[len(args)-1])
+	if err != nil || size < 0 {
+		return fmt.Errorf("malformed MSG %v", args)
+	}
+	data := make([]byte, size+2)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	sub, ok := b.subs[sid]
+	b.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	select {
+	case sub.msgs <- Message{Subject: args[0], Data: data[:size]}:
+	default:
+		log.Printf("events: subscription to %s is not keeping up; dropped a message", sub.subject)
+	}
+	return nil
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return "", errors.New("protocol line too long")
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(line), "\r\n"), nil
+}
@@ -0,0 +1,238 @@
+package events
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// natsServer is just enough of a NATS server for NATSBus: it greets, answers
+// PING, and routes PUB to matching SUBs, one member per queue group.
+type natsServer struct {
+	t  *testing.T
+	ln net.Listener
+
+	mu    sync.Mutex
+	conns map[net.Conn]*bufio.Writer
+	subs  []serverSub
+}
+
+type serverSub struct {
+	conn    net.Conn
+	subject string
+	queue   string
+	sid     string
+}
+
+func startNATSServer(t *testing.T) *natsServer {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &natsServer{t: t, ln: ln, conns: map[net.Conn]*bufio.Writer{}}
+	t.Cleanup(func() { ln.Close(); s.dropAll() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *natsServer) url() string { return "nats://" + s.ln.Addr().String() }
+
+func (s *natsServer) serve(conn net.Conn) {
+	w := bufio.NewWriter(conn)
+	s.mu.Lock()
+	s.conns[conn] = w
+	fmt.Fprintf(w, "INFO {\"server_id\":\"test\",\"max_payload\":1048576}\r\n")
+	w.Flush()
+	s.mu.Unlock()
+
+	defer s.drop(conn)
+	r := bufio.NewReader(conn)
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			return
+		}
+		verb, args, _ := strings.Cut(line, " ")
+		fields := strings.Fields(args)
+		s.mu.Lock()
+		switch verb {
+		case "PING":
+			w.WriteString("PONG\r\n")
+			w.Flush()
+		case "SUB":
+			sub := serverSub{conn: conn, subject: fields[0], sid: fields[len(fields)-1]}
+			if len(fields) == 3 {
+				sub.queue = fields[1]
+			}
+			s.subs = append(s.subs, sub)
+		case "UNSUB":
+			for i, sub := range s.subs {
+				if sub.conn == conn && sub.sid == fields[0] {
+					s.subs = append(s.subs[:i], s.subs[i+1:]...)
+					break
+				}
+			}
+		case "PUB":
+			s.mu.Unlock()
+			size, _ := strconv.Atoi(fields[len(fields)-1])
+			data := make([]byte, size+2)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.route(fields[0], data[:size])
+		}
+		s.mu.Unlock()
+	}
+}
+
+func (s *natsServer) route(subject string, data []byte) {
+	groups := map[string]bool{}
+	for _, sub := range s.subs {
+		if !Match(sub.subject, subject) || (sub.queue != "" && groups[sub.queue]) {
+			continue
+		}
+		if sub.queue != "" {
+			groups[sub.queue] = true
+		}
+		w := s.conns[sub.conn]
+		fmt.Fprintf(w, "MSG %s %s %d\r\n%s\r\n", subject, sub.sid, len(data), data)
+		w.Flush()
+	}
+}
+
+func (s *natsServer) drop(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	conn.Close()
+	delete(s.conns, conn)
+	subs := s.subs[:0]
+	for _, sub := range s.subs {
+		if sub.conn != conn {
+			subs = append(subs, sub)
+		}
+	}
+	s.subs = subs
+}
+
+func (s *natsServer) dropAll() {
+	s.mu.Lock()
+	conns := make([]net.Conn, 0, len(s.conns))
+	for conn := range s.conns {
+		conns = append(conns, conn)
+	}
+	s.mu.Unlock()
+	for _, conn := range conns {
+		s.drop(conn)
+	}
+}
+
+func (s *natsServer) subscriptions() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.subs)
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestNATSBusPublishSubscribe(t *testing.T) {
+	server := startNATSServer(t)
+	bus, err := DialNATS(server.url(), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bus.Close()
+
+	received := make(chan Message, 10)
+	if _, err := bus.Subscribe("patient.*", "prescription", func(ctx context.Context, msg Message) { received <- msg }); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "connection", func() bool { return bus.Connected() && server.subscriptions() == 1 })
+
+	if err := bus.Publish(context.Background(), "patient.deleted", []byte(`{"id":"e1"}`)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-received:
+		if msg.Subject != "patient.deleted" || string(msg.Data) != `{"id":"e1"}` {
+			t.Fatalf("received %+v", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("message not received")
+	}
+}
+
+func TestNATSBusReconnectsAndResubscribes(t *testing.T) {
+	server := startNATSServer(t)
+	bus, err := DialNATS(server.url(), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bus.Close()
+
+	received := make(chan Message, 10)
+	bus.Subscribe("access.revoked", "", func(ctx context.Context, msg Message) { received <- msg })
+	waitFor(t, "subscription", func() bool { return server.subscriptions() == 1 })
+
+	server.dropAll()
+	waitFor(t, "resubscription", func() bool { return bus.Connected() && server.subscriptions() == 1 })
+
+	if err := bus.Publish(context.Background(), "access.revoked", []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("message not received after reconnecting")
+	}
+}
+
+func TestNATSBusNotConnected(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	bus, err := DialNATS("nats://"+addr, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bus.Publish(context.Background(), "patient.deleted", nil); err != ErrNotConnected {
+		t.Fatalf("Publish = %v, want ErrNotConnected", err)
+	}
+	bus.Close()
+	if err := bus.Publish(context.Background(), "patient.deleted", nil); err != ErrClosed {
+		t.Fatalf("Publish after Close = %v, want ErrClosed", err)
+	}
+
+	if _, err := DialNATS("http://"+addr, "test"); err == nil {
+		t.Fatal("expected an error for a non-NATS URL")
+	}
+}
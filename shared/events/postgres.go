@@ -0,0 +1,161 @@
+package events
+
+import (
+	"context"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"health-bar/shared/database"
+	"health-bar/shared/models"
+)
+
+const outboxColumns = `id, topic, payload, attempts, next_attempt_at, last_error, created_at`
+
+// PostgresOutbox keeps events in the outbox_events table, which every
+// service shares. Add joins the caller's transaction.
+type PostgresOutbox struct {
+	db *sqlx.DB
+}
+
+func NewPostgresOutbox(db *sqlx.DB) *PostgresOutbox {
+	return &PostgresOutbox{db: db}
+}
+
+func (o *PostgresOutbox) Add(ctx context.Context, events ...Event) error {
+	ctx, cancel := database.WithTimeout(ctx)
+	defer cancel()
+
+	query := `INSERT INTO outbox_events (id, topic, payload, created_at) VALUES ($1, $2, $3, $4)`
+	for _, e := range events {
+		_, err := database.Conn(ctx, o.db).ExecContext(ctx, query, e.ID, e.Topic, models.RawJSON(e.Payload()), e.CreatedAt)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (o *PostgresOutbox) Claim(ctx context.Context, lease time.Duration, limit int) ([]models.OutboxEvent, error) {
+	ctx, cancel := database.WithTimeout(ctx)
+	defer cancel()
+
+	claimed := []models.OutboxEvent{}
+	query := `
+		UPDATE outbox_events
+		SET next_attempt_at = NOW() + $1 * INTERVAL '1 millisecond'
+		WHERE id IN (
+			SELECT id FROM outbox_events
+			WHERE next_attempt_at <= NOW()
+			ORDER BY created_at, id
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING ` + outboxColumns
+	if err := database.Conn(ctx, o.db).SelectContext(ctx, &claimed, query, lease.Milliseconds(), limit); err != nil {
+		return nil, err
+	}
+	sortOutbox(claimed)
+	return claimed, nil
+}
+
+func (o *PostgresOutbox) Forward(ctx context.Context, id string) error {
+	ctx, cancel := database.WithTimeout(ctx)
+	defer cancel()
+
+	query := `
+		WITH forwarded AS (
+			DELETE FROM outbox_events WHERE id = $1
+			RETURNING id, topic, payload, created_at
+		)
+		INSERT INTO event_inbox (consumer, event_id, topic, payload, created_at)
+		SELECT c.consumer, f.id, f.topic, f.payload, f.created_at
+		FROM forwarded f
+		JOIN event_consumers c ON c.topic = f.topic
+		ON CONFLICT (consumer, event_id) DO NOTHING
+	`
+	_, err := database.Conn(ctx, o.db).ExecContext(ctx, query, id)
+	return err
+}
+
+func (o *PostgresOutbox) Retry(ctx context.Context, id string, retryIn time.Duration, lastError string) error {
+	ctx, cancel := database.WithTimeout(ctx)
+	defer cancel()
+
+	query := `
+		UPDATE outbox_events
+		SET attempts = attempts + 1, next_attempt_at = NOW() + $2 * INTERVAL '1 millisecond', last_error = $3
+		WHERE id = $1
+	`
+	_, err := database.Conn(ctx, o.db).ExecContext(ctx, query, id, retryIn.Milliseconds(), lastError)
+	return err
+}
+
+const inboxColumns = `consumer, event_id, topic, payload, attempts, next_attempt_at, last_error, created_at`
+
+// PostgresInbox keeps the inboxes of every service in the event_inbox
+// table, and who consumes what in event_consumers.
+type PostgresInbox struct {
+	db *sqlx.DB
+}
+
+func NewPostgresInbox(db *sqlx.DB) *PostgresInbox {
+	return &PostgresInbox{db: db}
+}
+
+func (i *PostgresInbox) Register(ctx context.Context, consumer string, topics []string) error {
+	ctx, cancel := database.WithTimeout(ctx)
+	defer cancel()
+
+	query := `INSERT INTO event_consumers (consumer, topic) VALUES ($1, $2) ON CONFLICT (consumer, topic) DO NOTHING`
+	for _, topic := range topics {
+		if _, err := database.Conn(ctx, i.db).ExecContext(ctx, query, consumer, topic); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (i *PostgresInbox) Claim(ctx context.Context, consumer string, lease time.Duration, limit int) ([]models.InboxEvent, error) {
+	ctx, cancel := database.WithTimeout(ctx)
+	defer cancel()
+
+	claimed := []models.InboxEvent{}
+	query := `
+		UPDATE event_inbox
+		SET next_attempt_at = NOW() + $2 * INTERVAL '1 millisecond'
+		WHERE consumer = $1 AND event_id IN (
+			SELECT event_id FROM event_inbox
+			WHERE consumer = $1 AND next_attempt_at <= NOW()
+			ORDER BY created_at, event_id
+			LIMIT $3
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING ` + inboxColumns
+	if err := database.Conn(ctx, i.db).SelectContext(ctx, &claimed, query, consumer, lease.Milliseconds(), limit); err != nil {
+		return nil, err
+	}
+	sortInbox(claimed)
+	return claimed, nil
+}
+
+func (i *PostgresInbox) Remove(ctx context.Context, consumer, eventID string) error {
+	ctx, cancel := database.WithTimeout(ctx)
+	defer cancel()
+
+	_, err := database.Conn(ctx, i.db).ExecContext(ctx, `DELETE FROM event_inbox WHERE consumer = $1 AND event_id = $2`, consumer, eventID)
+	return err
+}
+
+func (i *PostgresInbox) Retry(ctx context.Context, consumer, eventID string, retryIn time.Duration, lastError string) error {
+	ctx, cancel := database.WithTimeout(ctx)
+	defer cancel()
+
+	query := `
+		UPDATE event_inbox
+		SET attempts = attempts + 1, next_attempt_at = NOW() + $3 * INTERVAL '1 millisecond', last_error = $4
+		WHERE consumer = $1 AND event_id = $2
+	`
+	_, err := database.Conn(ctx, i.db).ExecContext(ctx, query, consumer, eventID, retryIn.Milliseconds(), lastError)
+	return err
+}
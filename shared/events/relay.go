@@ -0,0 +1,109 @@
+package events
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"health-bar/shared/models"
+)
+
+const (
+	// relayBatch is how many events are claimed at a time.
+	relayBatch = 100
+	// relayLease keeps claimed events from other relays while they are
+	// published.
+	relayLease = 30 * time.Second
+	// relayFirstRetry is the wait after the first failure to forward or
+	// handle an event. It doubles with every further failure up to
+	// relayMaxRetry.
+	relayFirstRetry = time.Second
+	relayMaxRetry   = 5 * time.Minute
+)
+
+// RelayStore is the outbox as the relay sees it. PostgresOutbox and
+// MemoryOutbox implement it.
+type RelayStore interface {
+	// Claim gets up to limit due events, oldest first, and holds them back
+	// from other relays for lease.
+	Claim(ctx context.Context, lease time.Duration, limit int) ([]models.OutboxEvent, error)
+	// Forward moves an event into the inbox of every consumer of its
+	// topic, at once.
+	Forward(ctx context.Context, id string) error
+	// Retry records a failure to forward an event, which is due again
+	// after retryIn.
+	Retry(ctx context.Context, id string, retryIn time.Duration, lastError string) error
+}
+
+// Relay forwards outbox events to the inboxes of their consumers, then
+// publishes them on a bus to wake the consumers up. The inboxes make
+// delivery durable; a message the bus loses only delays its event until the
+// consumer next polls. Several relays can share an outbox; each event is
+// claimed by one of them at a time.
+type Relay struct {
+	store RelayStore
+	bus   Bus
+}
+
+func NewRelay(store RelayStore, bus Bus) *Relay {
+	return &Relay{store: store, bus: bus}
+}
+
+// Publish forwards every due event and returns how many it forwarded. An
+// event that fails is retried with backoff, so it can overtake others.
+func (r *Relay) Publish(ctx context.Context) (int, error) {
+	published := 0
+	for {
+		claimed, err := r.store.Claim(ctx, relayLease, relayBatch)
+		if err != nil {
+			return published, err
+		}
+		for _, e := range claimed {
+			if err := r.store.Forward(ctx, e.ID); err != nil {
+				if ctx.Err() != nil {
+					return published, ctx.Err()
+				}
+				if err := r.store.Retry(ctx, e.ID, retryIn(e.Attempts+1), err.Error()); err != nil {
+					return published, err
+				}
+				continue
+			}
+			published++
+			if err := r.bus.Publish(ctx, e.Topic, e.Payload); err != nil && ctx.Err() == nil {
+				log.Printf("events: relay: announce %s %s: %v", e.Topic, e.ID, err)
+			}
+		}
+		if len(claimed) < relayBatch {
+			return published, nil
+		}
+	}
+}
+
+// retryIn is how long to wait before forwarding or handling an event that
+// failed attempts times.
+func retryIn(attempts int) time.Duration {
+	wait := relayFirstRetry
+	for i := 1; i < attempts && wait < relayMaxRetry; i++ {
+		wait *= 2
+	}
+	return min(wait, relayMaxRetry)
+}
+
+// RunEvery publishes due events now and then every interval until ctx is
+// done.
+func (r *Relay) RunEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := r.Publish(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("events: relay: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,76 @@
+// Package filecheck checks that uploaded files are what their extension
+// claims before they are stored. Sanitize compares the content with the
+// type's signature, decodes images, strips image metadata such as EXIF
+// location tags, and rejects PDFs that carry JavaScript or launch actions.
+// A Scanner, such as ClamAV, can then look for malware.
+package filecheck
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+var (
+	// ErrTypeMismatch is returned for content that is not of the type its
+	// extension names, or of no type that is accepted.
+	ErrTypeMismatch = errors.New("filecheck: content does not match the file type")
+	// ErrInvalidContent is returned, wrapped with the reason, for content
+	// that starts like its type but does not decode.
+	ErrInvalidContent = errors.New("filecheck: invalid content")
+	// ErrActiveContent is returned, wrapped with what was found, for a PDF
+	// that could run code when it is opened.
+	ErrActiveContent = errors.New("filecheck: active content")
+)
+
+// signatures are the magic bytes each accepted extension starts with.
+var signatures = map[string][]byte{
+	".pdf":  []byte("%PDF-"),
+	".jpg":  {0xFF, 0xD8, 0xFF},
+	".jpeg": {0xFF, 0xD8, 0xFF},
+	".png":  []byte("\x89PNG\r\n\x1a\n"),
+}
+
+// Detect returns the extension whose signature head starts with, or "" for
+// content of no accepted type. JPEG content is ".jpg".
+func Detect(head []byte) string {
+	for _, ext := range []string{".pdf", ".jpg", ".png"} {
+		if bytes.HasPrefix(head, signatures[ext]) {
+			return ext
+		}
+	}
+	return ""
+}
+
+// Sanitize checks that r holds a file of type ext, an extension such as
+// ".pdf", and writes it to w as it should be stored: images without their
+// metadata, PDFs as they are. A file that fails may be partly written.
+func Sanitize(r io.ReadSeeker, ext string, w io.Writer) error {
+	ext = strings.ToLower(ext)
+	signature, ok := signatures[ext]
+	if !ok {
+		return ErrTypeMismatch
+	}
+	head := make([]byte, len(signature))
+	if _, err := io.ReadFull(r, head); err != nil || !bytes.Equal(head, signature) {
+		return ErrTypeMismatch
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	switch ext {
+	case ".pdf":
+		return sanitizePDF(r, w)
+	case ".png":
+		return sanitizePNG(r, w)
+	default:
+		return sanitizeJPEG(r, w)
+	}
+}
+
+func invalid(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", ErrInvalidContent, fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,301 @@
+package filecheck
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func testImage() image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	for i := range img.Pix {
+		img.Pix[i] = byte(i)
+	}
+	img.Set(3, 3, color.White)
+	return img
+}
+
+// withSegments inserts JPEG segments right after SOI.
+func withSegments(jpg []byte, segments ...[]byte) []byte {
+	out := append([]byte{}, jpg[:2]...)
+	for _, segment := range segments {
+		out = append(out, segment...)
+	}
+	return append(out, jpg[2:]...)
+}
+
+func jpegSegment(marker byte, data string) []byte {
+	segment := []byte{0xFF, marker}
+	segment = binary.BigEndian.AppendUint16(segment, uint16(len(data)+2))
+	return append(segment, data...)
+}
+
+// withChunks inserts PNG chunks right after IHDR.
+func withChunks(pngData []byte, chunks ...[]byte) []byte {
+	ihdrEnd := 8 + 8 + 13 + 4
+	out := append([]byte{}, pngData[:ihdrEnd]...)
+	for _, chunk := range chunks {
+		out = append(out, chunk...)
+	}
+	return append(out, pngData[ihdrEnd:]...)
+}
+
+func pngChunk(chunkType, data string) []byte {
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	chunk = append(chunk, chunkType...)
+	chunk = append(chunk, data...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE([]byte(chunkType+data)))
+}
+
+func sanitize(content []byte, ext string) ([]byte, error) {
+	var out bytes.Buffer
+	err := Sanitize(bytes.NewReader(content), ext, &out)
+	return out.Bytes(), err
+}
+
+func TestDetect(t *testing.T) {
+	var jpg, pngData bytes.Buffer
+	jpeg.Encode(&jpg, testImage(), nil)
+	png.Encode(&pngData, testImage())
+	for content, want := range map[string]string{
+		"%PDF-1.7\n":          ".pdf",
+		jpg.String():          ".jpg",
+		pngData.String():      ".png",
+		"MZ\x90\x00\x03":      "",
+		"#!/bin/sh\nrm -rf /": "",
+		"":                    "",
+	} {
+		if got := Detect([]byte(content)); got != want {
+			t.Errorf("Detect(%.8q) = %q, want %q", content, got, want)
+		}
+	}
+}
+
+func TestSanitizeRejectsMismatchedContent(t *testing.T) {
+	var jpg bytes.Buffer
+	jpeg.Encode(&jpg, testImage(), nil)
+
+	for _, tt := range []struct {
+		name    string
+		content []byte
+		ext     string
+	}{
+		{"executable as PDF", []byte("MZ\x90\x00\x03\x00\x00\x00\x04\x00"), ".pdf"},
+		{"executable as JPEG", []byte("MZ\x90\x00\x03\x00\x00\x00\x04\x00"), ".jpg"},
+		{"JPEG as PNG", jpg.Bytes(), ".png"},
+		{"empty", nil, ".pdf"},
+		{"unknown type", []byte("%PDF-1.7"), ".exe"},
+	} {
+		if _, err := sanitize(tt.content, tt.ext); err != ErrTypeMismatch {
+			t.Errorf("%s: err = %v, want ErrTypeMismatch", tt.name, err)
+		}
+	}
+}
+
+func TestSanitizeJPEGStripsMetadata(t *testing.T) {
+	var jpg bytes.Buffer
+	jpeg.Encode(&jpg, testImage(), nil)
+	tagged := withSegments(jpg.Bytes(),
+		jpegSegment(0xE1, "Exif\x00\x00GPSLatitude 52.5200 GPSLongitude 13.4050"),
+		jpegSegment(0xE1, "http://ns.adobe.com/xap/1.0/\x00<x:xmpmeta>author</x:xmpmeta>"),
+		jpegSegment(0xED, "Photoshop 3.0\x00IPTC"),
+		jpegSegment(0xFE, "taken at home"),
+		jpegSegment(0xE2, "ICC_PROFILE\x00\x01\x01profile"),
+	)
+
+	out, err := sanitize(tagged, ".JPG")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, leak := range []string{"Exif", "GPS", "xmpmeta", "IPTC", "taken at home"} {
+		if bytes.Contains(out, []byte(leak)) {
+			t.Errorf("sanitized JPEG still contains %q", leak)
+		}
+	}
+	if !bytes.Contains(out, []byte("ICC_PROFILE")) {
+		t.Error("sanitized JPEG lost its color profile")
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(out)); err != nil {
+		t.Fatalf("sanitized JPEG does not decode: %v", err)
+	}
+
+	// Only the metadata goes
+	plain, _ := sanitize(jpg.Bytes(), ".jpeg")
+	if !bytes.Equal(plain, jpg.Bytes()) {
+		t.Fatal("a JPEG without metadata changed")
+	}
+}
+
+func TestSanitizePNGStripsMetadata(t *testing.T) {
+	var pngData bytes.Buffer
+	png.Encode(&pngData, testImage())
+	tagged := withChunks(pngData.Bytes(),
+		pngChunk("tEXt", "Comment\x00GPS 52.5200,13.4050"),
+		pngChunk("eXIf", "MM\x00\x2aGPSInfo"),
+		pngChunk("tIME", "\x07\xea\x0a\x12\x0c\x00\x00"),
+		pngChunk("gAMA", "\x00\x00\xb1\x8f"),
+	)
+	if _, err := png.Decode(bytes.NewReader(tagged)); err != nil {
+		t.Fatalf("fixture does not decode: %v", err)
+	}
+
+	out, err := sanitize(tagged, ".png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, leak := range []string{"tEXt", "GPS", "eXIf", "tIME"} {
+		if bytes.Contains(out, []byte(leak)) {
+			t.Errorf("sanitized PNG still contains %q", leak)
+		}
+	}
+	if !bytes.Contains(out, []byte("gAMA")) {
+		t.Error("sanitized PNG lost its gamma")
+	}
+	if _, err := png.Decode(bytes.NewReader(out)); err != nil {
+		t.Fatalf("sanitized PNG does not decode: %v", err)
+	}
+}
+
+func TestSanitizeRejectsBrokenImages(t *testing.T) {
+	var jpg, pngData bytes.Buffer
+	jpeg.Encode(&jpg, testImage(), nil)
+	png.Encode(&pngData, testImage())
+
+	// A PNG that claims to be 100000x100000 pixels
+	huge := bytes.Clone(pngData.Bytes())
+	binary.BigEndian.PutUint32(huge[16:], 100000)
+	binary.BigEndian.PutUint32(huge[20:], 100000)
+	binary.BigEndian.PutUint32(huge[29:], crc32.ChecksumIEEE(huge[12:29]))
+
+	for _, tt := range []struct {
+		name    string
+		content []byte
+		ext     string
+	}{
+		{"truncated JPEG", jpg.Bytes()[:jpg.Len()/2], ".jpg"},
+		{"JPEG signature only", []byte{0xFF, 0xD8, 0xFF, 0xE0, 0, 0}, ".jpg"},
+		{"truncated PNG", pngData.Bytes()[:pngData.Len()-20], ".png"},
+		{"huge PNG", huge, ".png"},
+	} {
+		if _, err := sanitize(tt.content, tt.ext); !errors.Is(err, ErrInvalidContent) {
+			t.Errorf("%s: err = %v, want ErrInvalidContent", tt.name, err)
+		}
+	}
+}
+
+func testPDF(objects string) []byte {
+	return []byte("%PDF-1.7\n1 0 obj\n<< /Type /Catalog /Pages 2 0 R " + objects + " >>\nendobj\ntrailer\n<< /Root 1 0 R >>\n%%EOF\n")
+}
+
+func flateStream(content string) string {
+	var compressed bytes.Buffer
+	z := zlib.NewWriter(&compressed)
+	z.Write([]byte(content))
+	z.Close()
+	return "/Extra 3 0 R >>\nendobj\n3 0 obj\n<< /Type /ObjStm /Filter /FlateDecode /Length " +
+		strconv.Itoa(compressed.Len()) + " >>\nstream\n" + compressed.String() + "\nendstream\nendobj\n4 0 obj\n<<"
+}
+
+func TestSanitizePDF(t *testing.T) {
+	clean := testPDF("/Metadata /JSON /Launched")
+	out, err := sanitize(clean, ".pdf")
+	if err != nil || !bytes.Equal(out, clean) {
+		t.Fatalf("clean PDF: %q, %v", out, err)
+	}
+	if out, err := sanitize(testPDF(flateStream("<< /Type /Page >>")), ".pdf"); err != nil || len(out) == 0 {
+		t.Fatalf("clean PDF with an object stream: %v", err)
+	}
+
+	for name, pdf := range map[string][]byte{
+		"JavaScript action":       testPDF("/OpenAction << /S /JavaScript /JS (app.alert(1)) >>"),
+		"JS key":                  testPDF("/AA << /O << /JS 5 0 R >> >>"),
+		"launch action":           testPDF("/OpenAction << /S /Launch /F (cmd.exe) >>"),
+		"escaped name":            testPDF("/OpenAction << /S /J#61vaScript >>"),
+		"name without whitespace": testPDF("/OpenAction<</S/Launch/F(calc)>>"),
+		"in an object stream":     testPDF(flateStream("<< /S /JavaScript /JS (app.alert(1)) >>")),
+		"in a CRLF stream":        testPDF(strings.Replace(flateStream("<< /S /Launch >>"), "stream\n", "stream\r\n", 1)),
+		"after a large stream":    testPDF(flateStream(strings.Repeat("q 1 0 0 1 0 0 cm Q\n", 100000)) + " /AA << /JS 5 0 R >>"),
+	} {
+		if _, err := sanitize(pdf, ".pdf"); !errors.Is(err, ErrActiveContent) {
+			t.Errorf("%s: err = %v, want ErrActiveContent", name, err)
+		}
+	}
+
+	if _, err := sanitize([]byte("%PDF-1.7\n1 0 obj\n<< >>\n"), ".pdf"); !errors.Is(err, ErrInvalidContent) {
+		t.Errorf("truncated PDF: err = %v, want ErrInvalidContent", err)
+	}
+}
+
+// imageStream is an object holding a stream of data with dict's entries.
+func imageStream(dict string, data []byte) string {
+	return "/Extra 3 0 R >>\nendobj\n3 0 obj\n<< /Type /XObject /Subtype /Image " + dict + " /Length " +
+		strconv.Itoa(len(data)) + " >>\nstream\n" + string(data) + "\nendstream\nendobj\n4 0 obj\n<<"
+}
+
+func TestSanitizePDFSkipsImageData(t *testing.T) {
+	// Scanned pages are large runs of binary data, bound to contain bytes
+	// that read as an active name somewhere
+	data := make([]byte, 20<<20)
+	rand.NewChaCha8([32]byte{1}).Read(data)
+	copy(data[1000:], "/JS ")
+	copy(data[len(data)/2:], "/Launch\n")
+	copy(data[len(data)-100:], "(/JavaScript)")
+	var compressed bytes.Buffer
+	z := zlib.NewWriter(&compressed)
+	z.Write([]byte("pixels /JS pixels"))
+	z.Close()
+
+	for name, pdf := range map[string][]byte{
+		"JPEG":  testPDF(imageStream("/Filter /DCTDecode", data)),
+		"JPX":   testPDF(imageStream("/Filter [/JPXDecode]", data)),
+		"raw":   testPDF(imageStream("", data)),
+		"Flate": testPDF(imageStream("/Filter /FlateDecode", compressed.Bytes())),
+	} {
+		out, err := sanitize(pdf, ".pdf")
+		if err != nil || !bytes.Equal(out, pdf) {
+			t.Errorf("%s image: %v", name, err)
+		}
+	}
+
+	// Objects after the image are still searched
+	pdf := testPDF(imageStream("/Filter /DCTDecode", data) + " /AA << /JS 5 0 R >>")
+	if _, err := sanitize(pdf, ".pdf"); !errors.Is(err, ErrActiveContent) {
+		t.Errorf("after an image: err = %v, want ErrActiveContent", err)
+	}
+}
+
+func TestSanitizePDFObjectStreams(t *testing.T) {
+	objects := "<< /S /JavaScript /JS (app.alert(1)) >>"
+	plain := "/Extra 3 0 R >>\nendobj\n3 0 obj\n<< /Type /ObjStm /N 1 /First 0 >>\nstream\n" + objects + "\nendstream\nendobj\n4 0 obj\n<<"
+	if _, err := sanitize(testPDF(plain), ".pdf"); !errors.Is(err, ErrActiveContent) {
+		t.Errorf("uncompressed object stream: err = %v, want ErrActiveContent", err)
+	}
+
+	// Object streams that cannot be searched are refused
+	hex := strings.Replace(plain, "/Type /ObjStm", "/Type /ObjStm /Filter /ASCIIHexDecode", 1)
+	if _, err := sanitize(testPDF(hex), ".pdf"); !errors.Is(err, ErrInvalidContent) {
+		t.Errorf("ASCIIHex object stream: err = %v, want ErrInvalidContent", err)
+	}
+}
+
+func TestSanitizePDFBoundsInflation(t *testing.T) {
+	var compressed bytes.Buffer
+	z := zlib.NewWriter(&compressed)
+	z.Write(make([]byte, maxInflated+1))
+	z.Close()
+	bomb := testPDF("/Extra 3 0 R >>\nendobj\n3 0 obj\n<< /Type /ObjStm /Filter /FlateDecode >>\nstream\n" + compressed.String() + "\nendstream\nendobj\n4 0 obj\n<<")
+
+	if _, err := sanitize(bomb, ".pdf"); !errors.Is(err, ErrInvalidContent) {
+		t.Fatalf("err = %v, want ErrInvalidContent", err)
+	}
+}
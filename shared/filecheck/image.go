@@ -0,0 +1,191 @@
+package filecheck
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+)
+
+// maxPixels bounds the images that are decoded, so a small file cannot
+// claim dimensions that take gigabytes to decode.
+const maxPixels = 50_000_000
+
+// checkImage decodes the image in r with decodeConfig and decode, rewinding
+// r before and after.
+func checkImage(r io.ReadSeeker, decodeConfig func(io.Reader) (image.Config, error), decode func(io.Reader) (image.Image, error)) error {
+	config, err := decodeConfig(bufio.NewReader(r))
+	if err != nil {
+		return invalid("image does not decode: %v", err)
+	}
+	if config.Width <= 0 || config.Height <= 0 || int64(config.Width)*int64(config.Height) > maxPixels {
+		return invalid("image is %dx%d pixels", config.Width, config.Height)
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := decode(bufio.NewReader(r)); err != nil {
+		return invalid("image does not decode: %v", err)
+	}
+	_, err = r.Seek(0, io.SeekStart)
+	return err
+}
+
+// JPEG markers
+const (
+	markerSOI  = 0xD8
+	markerEOI  = 0xD9
+	markerSOS  = 0xDA
+	markerAPP0 = 0xE0
+	markerAPP2 = 0xE2
+	markerAPPE = 0xEE
+	markerAPPF = 0xEF
+	markerCOM  = 0xFE
+)
+
+// sanitizeJPEG writes the image without comments and application segments
+// other than JFIF, ICC profiles and Adobe color information, which takes
+// EXIF (with GPS and the orientation tag), XMP and IPTC data out.
+func sanitizeJPEG(r io.ReadSeeker, w io.Writer) error {
+	if err := checkImage(r, jpeg.DecodeConfig, jpeg.Decode); err != nil {
+		return err
+	}
+
+	src := bufio.NewReader(r)
+	out := bufio.NewWriter(w)
+	var soi [2]byte
+	if _, err := io.ReadFull(src, soi[:]); err != nil || soi[1] != markerSOI {
+		return invalid("JPEG does not start with SOI")
+	}
+	out.Write(soi[:])
+
+	for {
+		marker, err := readMarker(src)
+		if err != nil {
+			return invalid("JPEG marker: %v", err)
+		}
+		if marker == markerEOI {
+			out.Write([]byte{0xFF, marker})
+			return out.Flush()
+		}
+		// Markers without a segment
+		if marker == 0x01 || marker >= 0xD0 && marker <= 0xD7 {
+			out.Write([]byte{0xFF, marker})
+			continue
+		}
+
+		var length [2]byte
+		if _, err := io.ReadFull(src, length[:]); err != nil {
+			return invalid("JPEG segment: %v", err)
+		}
+		n := int(binary.BigEndian.Uint16(length[:]))
+		if n < 2 {
+			return invalid("JPEG segment length %d", n)
+		}
+		segment := make([]byte, n-2)
+		if _, err := io.ReadFull(src, segment); err != nil {
+			return invalid("JPEG segment: %v", err)
+		}
+		if !keepSegment(marker, segment) {
+			continue
+		}
+		out.Write([]byte{0xFF, marker})
+		out.Write(length[:])
+		out.Write(segment)
+
+		if marker == markerSOS {
+			// The scans follow; metadata segments come before them
+			if _, err := io.Copy(out, src); err != nil {
+				return err
+			}
+			return out.Flush()
+		}
+	}
+}
+
+// readMarker reads the next marker, skipping fill bytes.
+func readMarker(r *bufio.Reader) (byte, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	if b != 0xFF {
+		return 0, io.ErrUnexpectedEOF
+	}
+	for b == 0xFF {
+		if b, err = r.ReadByte(); err != nil {
+			return 0, err
+		}
+	}
+	return b, nil
+}
+
+func keepSegment(marker byte, segment []byte) bool {
+	switch {
+	case marker == markerCOM:
+		return false
+	case marker == markerAPP0:
+		return bytes.HasPrefix(segment, []byte("JFIF\x00")) || bytes.HasPrefix(segment, []byte("JFXX\x00"))
+	case marker == markerAPP2:
+		return bytes.HasPrefix(segment, []byte("ICC_PROFILE\x00"))
+	case marker == markerAPPE:
+		return bytes.HasPrefix(segment, []byte("Adobe"))
+	case marker >= markerAPP0 && marker <= markerAPPF:
+		return false
+	}
+	return true
+}
+
+// pngMetadata are the ancillary PNG chunks that carry text, EXIF and
+// timestamps.
+var pngMetadata = map[string]bool{
+	"tEXt": true,
+	"zTXt": true,
+	"iTXt": true,
+	"eXIf": true,
+	"tIME": true,
+}
+
+// sanitizePNG writes the image without its metadata chunks.
+func sanitizePNG(r io.ReadSeeker, w io.Writer) error {
+	if err := checkImage(r, png.DecodeConfig, png.Decode); err != nil {
+		return err
+	}
+
+	src := bufio.NewReader(r)
+	out := bufio.NewWriter(w)
+	signature := make([]byte, len(signatures[".png"]))
+	if _, err := io.ReadFull(src, signature); err != nil {
+		return invalid("PNG signature: %v", err)
+	}
+	out.Write(signature)
+
+	for {
+		// Length, type, data and CRC
+		var header [8]byte
+		if _, err := io.ReadFull(src, header[:]); err != nil {
+			return invalid("PNG chunk: %v", err)
+		}
+		n := binary.BigEndian.Uint32(header[:4])
+		if n > 1<<31-1 {
+			return invalid("PNG chunk length %d", n)
+		}
+		chunkType := string(header[4:])
+		if pngMetadata[chunkType] {
+			if _, err := src.Discard(int(n) + 4); err != nil {
+				return invalid("PNG chunk: %v", err)
+			}
+			continue
+		}
+		out.Write(header[:])
+		if _, err := io.CopyN(out, src, int64(n)+4); err != nil {
+			return invalid("PNG chunk: %v", err)
+		}
+		if chunkType == "IEND" {
+			return out.Flush()
+		}
+	}
+}
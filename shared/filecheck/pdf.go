@@ -0,0 +1,295 @@
+package filecheck
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// maxInflated bounds how much a PDF's compressed streams may inflate to
+// while they are searched, across the whole file.
+const maxInflated = 256 << 20
+
+// activeNames are the PDF names that run code or programs: JavaScript
+// actions, and launch actions that open other files or applications.
+var activeNames = map[string]bool{
+	"JavaScript": true,
+	"JS":         true,
+	"Launch":     true,
+}
+
+// sanitizePDF rejects a PDF with an active name in its objects, whether
+// they are written out plainly or packed in object streams, and copies it
+// otherwise. Names are compared after #xx escapes are decoded, so
+// /J#61vaScript is found too. The data of other streams, such as images,
+// fonts and page content, is not PDF objects and is not searched: binary
+// image data regularly contains bytes that read as /JS. The file is read
+// from r a few times rather than held in memory, so checking a large PDF
+// takes no more memory than a small one.
+func sanitizePDF(r io.ReadSeeker, w io.Writer) error {
+	streams, err := scanPDF(r)
+	if err != nil {
+		return err
+	}
+
+	inflated := int64(0)
+	br := bufio.NewReader(nil)
+	for _, stream := range streams {
+		n, err := searchStream(r, br, stream, maxInflated-inflated)
+		if err != nil {
+			return err
+		}
+		inflated += n
+	}
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	return err
+}
+
+// span is where the data of an object stream lies in a file, and whether
+// it is Flate-compressed or stored as is.
+type span struct {
+	start, end int64
+	flate      bool
+}
+
+// streamNames are the names of a stream's dictionary that tell how to
+// search it: whether it is an object stream, and its filters.
+var streamNames = map[string]bool{
+	"ObjStm": true, "Filter": true, "FlateDecode": true, "Fl": true,
+}
+
+// scanPDF searches a PDF's objects outside streams for active names and
+// returns where its object streams are, their data lying between the
+// stream and endstream keywords. Other streams are skipped.
+func scanPDF(r io.Reader) ([]span, error) {
+	// Each block starts with the last bytes of the one before, so keywords
+	// split between blocks are still found
+	const keep = 16
+	var (
+		buf      = make([]byte, keep+64<<10)
+		carried  int
+		base     int64 // offset of buf[0] in the file
+		names    = nameFinder{dict: map[string]bool{}}
+		streams  []span
+		inStream bool
+		search   bool // whether the current stream is an object stream
+		stream   span
+		afterCR  bool
+		sawEOF   bool
+	)
+	// begin starts the stream whose data is at start; its dictionary's
+	// names were gathered since the object began
+	begin := func(start int64) error {
+		inStream, search = true, names.dict["ObjStm"]
+		stream = span{start: start, flate: names.dict["FlateDecode"] || names.dict["Fl"]}
+		if search && names.dict["Filter"] && !stream.flate {
+			return invalid("PDF object stream has a filter other than FlateDecode")
+		}
+		clear(names.dict)
+		names.reset()
+		return nil
+	}
+	for {
+		n, err := io.ReadFull(r, buf[carried:])
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return nil, err
+		}
+		block := buf[:carried+n]
+		for i := carried; i < len(block); i++ {
+			c := block[i]
+			switch {
+			case afterCR:
+				// The stream keyword ends with CRLF or LF
+				afterCR = false
+				if c == '\n' {
+					if err := begin(base + int64(i) + 1); err != nil {
+						return nil, err
+					}
+				}
+			case inStream:
+				// Stream data is not PDF syntax, so names are not looked for
+				if c == 'm' && bytes.HasSuffix(block[:i+1], []byte("endstream")) {
+					if end := base + int64(i) + 1 - int64(len("endstream")); end >= stream.start {
+						if search {
+							stream.end = end
+							streams = append(streams, stream)
+						}
+						inStream = false
+					}
+				}
+				continue
+			case c == '\n' || c == '\r':
+				if bytes.HasSuffix(block[:i], []byte("stream")) && !bytes.HasSuffix(block[:i], []byte("endstream")) {
+					if c == '\r' {
+						afterCR = true
+					} else if err := begin(base + int64(i) + 1); err != nil {
+						return nil, err
+					}
+				}
+			}
+			if names.add(c) {
+				return nil, names.err()
+			}
+			switch {
+			case c == 'j' && bytes.HasSuffix(block[:i+1], []byte("obj")):
+				// A new object, or the end of one: its dictionary is done
+				clear(names.dict)
+			case c == 'F' && bytes.HasSuffix(block[:i+1], []byte("%%EOF")):
+				sawEOF = true
+			}
+		}
+		if err != nil {
+			break
+		}
+		carried = copy(buf, block[len(block)-keep:])
+		base += int64(len(block) - carried)
+	}
+	if names.end() {
+		return nil, names.err()
+	}
+	if !sawEOF {
+		return nil, invalid("PDF has no end-of-file marker")
+	}
+	return streams, nil
+}
+
+// searchStream searches the data of an object stream for active names,
+// inflating it first when it is Flate-compressed, up to limit bytes. It
+// returns how much the stream inflated to. A Flate stream too damaged to
+// inflate holds no objects a reader could load either, and is let through.
+// br is reset to read the stream, saving a buffer per stream.
+func searchStream(r io.ReadSeeker, br *bufio.Reader, stream span, limit int64) (int64, error) {
+	if _, err := r.Seek(stream.start, io.SeekStart); err != nil {
+		return 0, err
+	}
+	br.Reset(io.LimitReader(r, stream.end-stream.start))
+	var data io.Reader = br
+	if stream.flate {
+		z, err := zlib.NewReader(br)
+		if err != nil {
+			return 0, nil
+		}
+		defer z.Close()
+		data = z
+	}
+
+	var names nameFinder
+	// A stream cut short still has names worth searching, so read errors
+	// only end the search
+	n, err := io.Copy(&names, io.LimitReader(data, limit+1))
+	if err == errActiveName || names.end() {
+		return n, names.err()
+	}
+	if n > limit {
+		return n, invalid("PDF streams inflate to more than %d bytes", maxInflated)
+	}
+	return n, nil
+}
+
+var errActiveName = errors.New("active name")
+
+// maxNameLen is the longest name PDF allows; longer ones are not active.
+const maxNameLen = 127
+
+// nameFinder finds active names in content written to it piece by piece.
+// With dict set, it also gathers the streamNames it passes.
+type nameFinder struct {
+	inName bool
+	name   []byte
+	found  string
+	dict   map[string]bool
+}
+
+// Write feeds p to the finder and fails with errActiveName once one is
+// found.
+func (f *nameFinder) Write(p []byte) (int, error) {
+	for i, c := range p {
+		if f.add(c) {
+			return i + 1, errActiveName
+		}
+	}
+	return len(p), nil
+}
+
+// add feeds one byte to the finder and reports whether it completed an
+// active name.
+func (f *nameFinder) add(c byte) bool {
+	if f.inName {
+		if !isDelimiter(c) {
+			if len(f.name) <= maxNameLen*3 {
+				f.name = append(f.name, c)
+			}
+			return false
+		}
+		if f.end() {
+			return true
+		}
+	}
+	if c == '/' {
+		f.inName, f.name = true, f.name[:0]
+	}
+	return false
+}
+
+// end completes the name being read, if any, and reports whether it is
+// active.
+func (f *nameFinder) end() bool {
+	if !f.inName {
+		return false
+	}
+	f.inName = false
+	name := decodeName(f.name)
+	if activeNames[name] {
+		f.found = name
+		return true
+	}
+	if f.dict != nil && streamNames[name] {
+		f.dict[name] = true
+	}
+	return false
+}
+
+// reset drops a name cut off by stream data, which is not searched.
+func (f *nameFinder) reset() {
+	f.inName = false
+}
+
+func (f *nameFinder) err() error {
+	return fmt.Errorf("%w: PDF contains /%s", ErrActiveContent, f.found)
+}
+
+// isDelimiter reports whether c ends a PDF name.
+func isDelimiter(c byte) bool {
+	switch c {
+	case ' ', '\t', '\r', '\n', '\f', 0, '/', '<', '>', '[', ']', '(', ')', '{', '}', '%':
+		return true
+	}
+	return false
+}
+
+// decodeName decodes the #xx escapes in a name.
+func decodeName(raw []byte) string {
+	if !bytes.Contains(raw, []byte("#")) {
+		return string(raw)
+	}
+	var name []byte
+	for i := 0; i < len(raw); i++ {
+		if raw[i] == '#' && i+2 < len(raw) {
+			if c, err := strconv.ParseUint(string(raw[i+1:i+3]), 16, 8); err == nil {
+				name = append(name, byte(c))
+				i += 2
+				continue
+			}
+		}
+		name = append(name, raw[i])
+	}
+	return string(name)
+}
@@ -0,0 +1,137 @@
+package filecheck
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// ErrScanFailed is returned, wrapped with the cause, when a file could not
+// be scanned. Files are not accepted unscanned.
+var ErrScanFailed = errors.New("filecheck: scan failed")
+
+// InfectedError is returned for a file a Scanner found a threat in.
+type InfectedError struct {
+	Threat string
+}
+
+func (e *InfectedError) Error() string {
+	return "filecheck: file contains " + e.Threat
+}
+
+// Scanner looks for malware in files before they are stored.
+type Scanner interface {
+	// Scan reads r and returns an *InfectedError if it holds a threat.
+	Scan(ctx context.Context, r io.Reader) error
+}
+
+// NopScanner accepts every file. It is the default until a scanner is
+// configured.
+type NopScanner struct{}
+
+func (NopScanner) Scan(ctx context.Context, r io.Reader) error { return nil }
+
+// ClamAV scans files with clamd, over its INSTREAM protocol.
+type ClamAV struct {
+	network string
+	address string
+	// Timeout bounds a whole scan, unless the context ends it sooner.
+	Timeout time.Duration
+}
+
+// clamChunkSize is how much is sent in one INSTREAM chunk.
+const clamChunkSize = 64 << 10
+
+// NewClamAV returns a scanner for the clamd at addr: host:port, or the path
+// of a Unix socket.
+func NewClamAV(addr string) *ClamAV {
+	if strings.HasPrefix(addr, "/") {
+		return &ClamAV{network: "unix", address: addr, Timeout: time.Minute}
+	}
+	return &ClamAV{network: "tcp", address: addr, Timeout: time.Minute}
+}
+
+func (c *ClamAV) Scan(ctx context.Context, r io.Reader) error {
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, c.network, c.address)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrScanFailed, err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	reply, err := c.instream(conn, r)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrScanFailed, err)
+	}
+	return parseReply(reply)
+}
+
+// instream sends r as a stream and returns clamd's reply.
+func (c *ClamAV) instream(conn net.Conn, r io.Reader) (string, error) {
+	if _, err := io.WriteString(conn, "zINSTREAM\x00"); err != nil {
+		return "", err
+	}
+	chunk := make([]byte, 4+clamChunkSize)
+	for {
+		n, err := io.ReadFull(r, chunk[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(chunk, uint32(n))
+			if _, werr := conn.Write(chunk[:4+n]); werr != nil {
+				// clamd closes the connection once a stream is too long,
+				// and says so
+				if reply, rerr := readReply(conn); rerr == nil {
+					return reply, nil
+				}
+				return "", werr
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	if _, err := conn.Write([]byte{0, 0, 0, 0}); err != nil {
+		return "", err
+	}
+	return readReply(conn)
+}
+
+// readReply reads a null-terminated reply.
+func readReply(conn net.Conn) (string, error) {
+	reply, err := bufio.NewReader(io.LimitReader(conn, 4096)).ReadString(0)
+	if reply = strings.TrimSuffix(reply, "\x00"); reply != "" {
+		return reply, nil
+	}
+	if err == nil || err == io.EOF {
+		err = errors.New("clamd closed the connection without a reply")
+	}
+	return "", err
+}
+
+// parseReply interprets "stream: OK", "stream: <threat> FOUND" and
+// "<message> ERROR".
+func parseReply(reply string) error {
+	reply = strings.TrimSpace(reply)
+	result := strings.TrimPrefix(reply, "stream: ")
+	switch {
+	case result == "OK":
+		return nil
+	case strings.HasSuffix(result, " FOUND"):
+		return &InfectedError{Threat: strings.TrimSuffix(result, " FOUND")}
+	}
+	return fmt.Errorf("%w: clamd replied %q", ErrScanFailed, reply)
+}
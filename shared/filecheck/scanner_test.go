@@ -0,0 +1,125 @@
+package filecheck
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+const eicar = `X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`
+
+// clamd is a stand-in for clamd's INSTREAM command. It finds EICAR, and
+// like clamd refuses streams over maxStream bytes.
+func clamd(t *testing.T, maxStream int) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				if command, err := r.ReadString(0); err != nil || command != "zINSTREAM\x00" {
+					io.WriteString(conn, "UNKNOWN COMMAND\x00")
+					return
+				}
+				var stream []byte
+				for {
+					var size uint32
+					if binary.Read(r, binary.BigEndian, &size) != nil {
+						return
+					}
+					if size == 0 {
+						break
+					}
+					if len(stream)+int(size) > maxStream {
+						io.WriteString(conn, "INSTREAM size limit exceeded. ERROR\x00")
+						return
+					}
+					chunk := make([]byte, size)
+					if _, err := io.ReadFull(r, chunk); err != nil {
+						return
+					}
+					stream = append(stream, chunk...)
+				}
+				if bytes.Contains(stream, []byte(eicar)) {
+					io.WriteString(conn, "stream: Eicar-Test-Signature FOUND\x00")
+					return
+				}
+				io.WriteString(conn, "stream: OK\x00")
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestClamAV(t *testing.T) {
+	scanner := NewClamAV(clamd(t, 1<<20))
+	ctx := context.Background()
+
+	if err := scanner.Scan(ctx, strings.NewReader("%PDF-1.7 clean")); err != nil {
+		t.Fatalf("clean file: %v", err)
+	}
+
+	// The signature spans two chunks
+	infected := append(bytes.Repeat([]byte{' '}, clamChunkSize-10), eicar...)
+	err := scanner.Scan(ctx, bytes.NewReader(infected))
+	var threat *InfectedError
+	if !errors.As(err, &threat) || threat.Threat != "Eicar-Test-Signature" {
+		t.Fatalf("infected file: err = %v", err)
+	}
+
+	if err := scanner.Scan(ctx, bytes.NewReader(nil)); err != nil {
+		t.Fatalf("empty file: %v", err)
+	}
+}
+
+func TestClamAVFailures(t *testing.T) {
+	ctx := context.Background()
+
+	limited := NewClamAV(clamd(t, 100<<10))
+	err := limited.Scan(ctx, bytes.NewReader(make([]byte, 4<<20)))
+	if !errors.Is(err, ErrScanFailed) || !strings.Contains(err.Error(), "size limit") {
+		t.Fatalf("stream over the limit: err = %v", err)
+	}
+
+	ln, _ := net.Listen("tcp", "127.0.0.1:0")
+	addr := ln.Addr().String()
+	ln.Close()
+	if err := NewClamAV(addr).Scan(ctx, strings.NewReader("x")); !errors.Is(err, ErrScanFailed) {
+		t.Fatalf("clamd down: err = %v", err)
+	}
+}
+
+func TestParseReply(t *testing.T) {
+	for reply, want := range map[string]string{
+		"stream: OK":                         "",
+		"stream: OK\n":                       "",
+		"stream: Win.Test.EICAR_HDB-1 FOUND": "Win.Test.EICAR_HDB-1",
+		"stream: lstat() failed. ERROR":      "error",
+		"":                                   "error",
+	} {
+		err := parseReply(reply)
+		var threat *InfectedError
+		switch {
+		case want == "" && err != nil,
+			want == "error" && !errors.Is(err, ErrScanFailed),
+			want != "" && want != "error" && (!errors.As(err, &threat) || threat.Threat != want):
+			t.Errorf("parseReply(%q) = %v, want %s", reply, err, want)
+		}
+	}
+}
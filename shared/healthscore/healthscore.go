@@ -0,0 +1,131 @@
+// Package healthscore computes the health bar: a 0–100 score per patient
+// made of the points a set of rules award from the patient's record and
+// self-assessments.
+//
+// Rules are grouped into versioned rule sets. A score records the version
+// that produced it, so stored history stays explainable after the rules
+// change; changing a rule means registering a new version rather than
+// editing an old one.
+package healthscore
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"sync"
+	"time"
+
+	"health-bar/shared/models"
+)
+
+// Inputs is everything the rules may look at.
+type Inputs struct {
+	Now               time.Time
+	Profile           models.PatientProfile
+	EmergencyContacts []models.EmergencyContact
+	// Biometrics is nil when none are recorded.
+	Biometrics *models.Biometrics
+	// Visits and Assessments are ordered newest first.
+	Visits      []models.HospitalVisit
+	Assessments []models.SelfAssessment
+}
+
+// Rule awards part of the score. Evaluate returns the fraction of
+// MaxPoints earned, between 0 and 1, and a human-readable explanation.
+type Rule interface {
+	Name() string
+	Label() string
+	MaxPoints() float64
+	Evaluate(in Inputs) (fraction float64, detail string)
+}
+
+// RuleSet is one version of the scoring rules. The MaxPoints of its rules
+// add up to 100.
+type RuleSet struct {
+	Version string
+	Rules   []Rule
+}
+
+// Score evaluates every rule against in.
+func (s RuleSet) Score(in Inputs) models.HealthScore {
+	score := models.HealthScore{
+		PatientID:     in.Profile.ID,
+		RulesVersion:  s.Version,
+		Contributions: make(models.ScoreContributions, len(s.Rules)),
+		ComputedAt:    in.Now,
+	}
+
+	var total float64
+	for i, rule := range s.Rules {
+		fraction, detail := rule.Evaluate(in)
+		fraction = math.Max(0, math.Min(1, fraction))
+		points := math.Round(rule.MaxPoints()*fraction*10) / 10
+		total += points
+		score.Contributions[i] = models.ScoreContribution{
+			Rule:      rule.Name(),
+			Label:     rule.Label(),
+			Points:    points,
+			MaxPoints: rule.MaxPoints(),
+			Detail:    detail,
+		}
+	}
+	score.Score = int(math.Round(total))
+	return score
+}
+
+var (
+	mu       sync.RWMutex
+	sets     = map[string]RuleSet{}
+	versions []string
+)
+
+// Register adds a rule set. Versions are registered oldest first; the last
+// one registered is the default. It panics on a duplicate version or rules
+// that do not add up to 100 points.
+func Register(set RuleSet) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if _, ok := sets[set.Version]; ok {
+		panic("healthscore: duplicate rule set " + set.Version)
+	}
+	var total float64
+	names := map[string]bool{}
+	for _, rule := range set.Rules {
+		if names[rule.Name()] {
+			panic(fmt.Sprintf("healthscore: rule set %s has two %s rules", set.Version, rule.Name()))
+		}
+		names[rule.Name()] = true
+		total += rule.MaxPoints()
+	}
+	if math.Abs(total-100) > 1e-9 {
+		panic(fmt.Sprintf("healthscore: rule set %s awards %g points, want 100", set.Version, total))
+	}
+	sets[set.Version] = set
+	versions = append(versions, set.Version)
+}
+
+// Lookup returns the rule set registered as version.
+func Lookup(version string) (RuleSet, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	set, ok := sets[version]
+	return set, ok
+}
+
+// Latest returns the most recently registered rule set.
+func Latest() RuleSet {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	return sets[versions[len(versions)-1]]
+}
+
+// Versions lists the registered versions, oldest first.
+func Versions() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	return slices.Clone(versions)
+}
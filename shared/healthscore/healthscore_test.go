@@ -0,0 +1,94 @@
+package healthscore
+
+import (
+	"testing"
+	"time"
+
+	"health-bar/shared/models"
+)
+
+var now = time.Date(2024, 6, 15, 12, 0, 0, 0, time.UTC)
+
+func visit(daysAgo int, reason string) models.HospitalVisit {
+	return models.HospitalVisit{VisitDate: now.AddDate(0, 0, -daysAgo).Truncate(24 * time.Hour), Reason: reason}
+}
+
+func contribution(t *testing.T, score models.HealthScore, rule string) models.ScoreContribution {
+	t.Helper()
+	for _, c := range score.Contributions {
+		if c.Rule == rule {
+			return c
+		}
+	}
+	t.Fatalf("no %s contribution in %+v", rule, score.Contributions)
+	return models.ScoreContribution{}
+}
+
+func TestV1(t *testing.T) {
+	rules, ok := Lookup("v1")
+	if !ok {
+		t.Fatal("v1 not registered")
+	}
+
+	empty := rules.Score(Inputs{Now: now})
+	// Nothing on record: no check-up, no profile extras, neutral self-assessment
+	if empty.Score != 43 || empty.RulesVersion != "v1" || len(empty.Contributions) != 5 {
+		t.Fatalf("empty record = %+v", empty)
+	}
+
+	healthy := rules.Score(Inputs{
+		Now:               now,
+		Profile:           models.PatientProfile{Gender: "female", Phone: "555", Address: "1 Main St"},
+		EmergencyContacts: []models.EmergencyContact{{Name: "Sam"}},
+		Biometrics:        &models.Biometrics{BloodType: "O+"},
+		Visits:            []models.HospitalVisit{visit(100, "Annual check-up"), visit(400, "Flu")},
+		Assessments: []models.SelfAssessment{{
+			GeneralHealth: 5, Energy: 5, Sleep: 5, Mood: 5, Pain: 1, CreatedAt: now.AddDate(0, 0, -3),
+		}},
+	})
+	if healthy.Score != 100 {
+		t.Fatalf("healthy = %+v", healthy)
+	}
+
+	unwell := rules.Score(Inputs{
+		Now:     now,
+		Profile: models.PatientProfile{Gender: "male"},
+		Visits: []models.HospitalVisit{
+			visit(9, "Chest pain"), visit(30, "Chest pain"), visit(60, "Follow-up"), visit(90, "Follow-up"),
+			visit(120, "Follow-up"), visit(500, "Routine physical"),
+		},
+		Assessments: []models.SelfAssessment{{
+			GeneralHealth: 2, Energy: 2, Sleep: 3, Mood: 2, Pain: 4, CreatedAt: now.AddDate(0, 0, -10),
+		}},
+	})
+	if c := contribution(t, unwell, "profile_completeness"); c.Points != 3 || c.Detail != "1 of 5 filled in; missing phone, address, emergency contact, blood type" {
+		t.Fatalf("completeness = %+v", c)
+	}
+	if c := contribution(t, unwell, "checkup_recency"); c.Points != 12.5 {
+		t.Fatalf("check-up = %+v", c)
+	}
+	if c := contribution(t, unwell, "visit_frequency"); c.Points != 7.5 {
+		t.Fatalf("frequency = %+v", c)
+	}
+	if c := contribution(t, unwell, "visit_recency"); c.Points != 1 {
+		t.Fatalf("recency = %+v", c)
+	}
+	if c := contribution(t, unwell, "self_assessment"); c.Points != 10.5 {
+		t.Fatalf("self-assessment = %+v", c)
+	}
+	if unwell.Score != 35 {
+		t.Fatalf("unwell score = %d", unwell.Score)
+	}
+}
+
+func TestRegisterRejectsUnbalancedRules(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("registered a rule set worth 50 points")
+		}
+		if _, ok := Lookup("test-unbalanced"); ok {
+			t.Fatal("unbalanced rule set was registered")
+		}
+	}()
+	Register(RuleSet{Version: "test-unbalanced", Rules: []Rule{ProfileCompleteness{Points: 50}}})
+}
@@ -0,0 +1,200 @@
+package healthscore
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"health-bar/shared/models"
+)
+
+// CheckupKeywords mark a visit as a routine check-up when its reason
+// contains one of them.
+var CheckupKeywords = []string{"check-up", "checkup", "check up", "physical", "annual", "wellness", "screening", "routine"}
+
+// IsCheckup reports whether a visit was a routine check-up rather than care
+// for a problem.
+func IsCheckup(v models.HospitalVisit) bool {
+	reason := strings.ToLower(v.Reason)
+	for _, keyword := range CheckupKeywords {
+		if strings.Contains(reason, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// pastVisits returns the visits up to now that are, or are not, check-ups.
+func pastVisits(in Inputs, checkups bool) []models.HospitalVisit {
+	var visits []models.HospitalVisit
+	for _, v := range in.Visits {
+		if !v.VisitDate.After(in.Now) && IsCheckup(v) == checkups {
+			visits = append(visits, v)
+		}
+	}
+	return visits
+}
+
+func days(in Inputs, v models.HospitalVisit) int {
+	return int(in.Now.Sub(v.VisitDate).Hours() / 24)
+}
+
+// ProfileCompleteness awards an equal share of Points for each optional
+// part of the record that is filled in: gender, phone, address, an
+// emergency contact and a blood type.
+type ProfileCompleteness struct {
+	Points float64
+}
+
+func (ProfileCompleteness) Name() string         { return "profile_completeness" }
+func (ProfileCompleteness) Label() string        { return "Profile completeness" }
+func (r ProfileCompleteness) MaxPoints() float64 { return r.Points }
+
+func (ProfileCompleteness) Evaluate(in Inputs) (float64, string) {
+	parts := []struct {
+		name   string
+		filled bool
+	}{
+		{"gender", in.Profile.Gender != ""},
+		{"phone", in.Profile.Phone != ""},
+		{"address", in.Profile.Address != ""},
+		{"emergency contact", len(in.EmergencyContacts) > 0},
+		{"blood type", in.Biometrics != nil && in.Biometrics.BloodType != ""},
+	}
+
+	var missing []string
+	for _, p := range parts {
+		if !p.filled {
+			missing = append(missing, p.name)
+		}
+	}
+	filled := len(parts) - len(missing)
+	if len(missing) == 0 {
+		return 1, "Profile complete"
+	}
+	return float64(filled) / float64(len(parts)),
+		fmt.Sprintf("%d of %d filled in; missing %s", filled, len(parts), strings.Join(missing, ", "))
+}
+
+// CheckupRecency awards Points when the last check-up was at most
+// FullMonths ago and half of them up to HalfMonths ago.
+type CheckupRecency struct {
+	Points     float64
+	FullMonths int
+	HalfMonths int
+}
+
+func (CheckupRecency) Name() string         { return "checkup_recency" }
+func (CheckupRecency) Label() string        { return "Regular check-ups" }
+func (r CheckupRecency) MaxPoints() float64 { return r.Points }
+
+func (r CheckupRecency) Evaluate(in Inputs) (float64, string) {
+	checkups := pastVisits(in, true)
+	if len(checkups) == 0 {
+		return 0, "No check-up on record"
+	}
+	last := checkups[0]
+	detail := fmt.Sprintf("Last check-up on %s", last.VisitDate.Format("2006-01-02"))
+	switch {
+	case !last.VisitDate.Before(in.Now.AddDate(0, -r.FullMonths, 0)):
+		return 1, detail
+	case !last.VisitDate.Before(in.Now.AddDate(0, -r.HalfMonths, 0)):
+		return 0.5, detail + fmt.Sprintf(", more than %d months ago", r.FullMonths)
+	}
+	return 0, detail + fmt.Sprintf(", more than %d months ago", r.HalfMonths)
+}
+
+// VisitFrequency scores how often the patient needed care in the last
+// WindowMonths. Up to Few visits earn all Points, Many or more earn none,
+// with a straight line in between. Check-ups do not count.
+type VisitFrequency struct {
+	Points       float64
+	WindowMonths int
+	Few, Many    int
+}
+
+func (VisitFrequency) Name() string         { return "visit_frequency" }
+func (VisitFrequency) Label() string        { return "Visit frequency" }
+func (r VisitFrequency) MaxPoints() float64 { return r.Points }
+
+func (r VisitFrequency) Evaluate(in Inputs) (float64, string) {
+	since := in.Now.AddDate(0, -r.WindowMonths, 0)
+	n := 0
+	for _, v := range pastVisits(in, false) {
+		if !v.VisitDate.Before(since) {
+			n++
+		}
+	}
+
+	detail := fmt.Sprintf("%d visit(s) in the last %d months", n, r.WindowMonths)
+	switch {
+	case n <= r.Few:
+		return 1, detail
+	case n >= r.Many:
+		return 0, detail
+	}
+	return float64(r.Many-n) / float64(r.Many-r.Few), detail
+}
+
+// VisitRecency treats a recent visit for a problem as a sign of something
+// not yet resolved. Points are withheld right after such a visit and earned
+// back linearly over RecoveryDays.
+type VisitRecency struct {
+	Points       float64
+	RecoveryDays int
+}
+
+func (VisitRecency) Name() string         { return "visit_recency" }
+func (VisitRecency) Label() string        { return "Time since last visit" }
+func (r VisitRecency) MaxPoints() float64 { return r.Points }
+
+func (r VisitRecency) Evaluate(in Inputs) (float64, string) {
+	visits := pastVisits(in, false)
+	if len(visits) == 0 {
+		return 1, "No visits for a health problem on record"
+	}
+	last := visits[0]
+	elapsed := days(in, last)
+	detail := fmt.Sprintf("Last visit %d day(s) ago: %s", elapsed, last.Reason)
+	return math.Min(1, float64(elapsed)/float64(r.RecoveryDays)), detail
+}
+
+// SelfAssessed scores the latest self-assessment: the mean of its ratings,
+// pain inverted, mapped onto Points. An assessment older than FreshDays
+// counts halfway towards Neutral, one older than StaleDays or none at all
+// counts as Neutral, the fraction given to patients we know nothing about.
+type SelfAssessed struct {
+	Points    float64
+	FreshDays int
+	StaleDays int
+	Neutral   float64
+}
+
+func (SelfAssessed) Name() string         { return "self_assessment" }
+func (SelfAssessed) Label() string        { return "How you feel" }
+func (r SelfAssessed) MaxPoints() float64 { return r.Points }
+
+func (r SelfAssessed) Evaluate(in Inputs) (float64, string) {
+	if len(in.Assessments) == 0 {
+		return r.Neutral, "No self-assessment yet; counted as neutral"
+	}
+	latest := in.Assessments[0]
+	age := int(in.Now.Sub(latest.CreatedAt).Hours() / 24)
+	if age > r.StaleDays {
+		return r.Neutral, fmt.Sprintf("Last self-assessment %d days ago; counted as neutral", age)
+	}
+
+	rating := Rating(latest)
+	detail := fmt.Sprintf("Rated %.1f of 5 on %s", 1+rating*4, latest.CreatedAt.Format("2006-01-02"))
+	if age > r.FreshDays {
+		return (rating + r.Neutral) / 2, detail + fmt.Sprintf(", more than %d days ago", r.FreshDays)
+	}
+	return rating, detail
+}
+
+// Rating is the mean of an assessment's ratings, with pain inverted,
+// mapped from 1–5 onto 0–1.
+func Rating(a models.SelfAssessment) float64 {
+	sum := a.GeneralHealth + a.Energy + a.Sleep + a.Mood + (6 - a.Pain)
+	return (float64(sum)/5 - 1) / 4
+}
@@ -0,0 +1,18 @@
+package healthscore
+
+// AssessmentInterval is how often patients are asked for a self-assessment,
+// in days.
+const AssessmentInterval = 30
+
+func init() {
+	Register(RuleSet{
+		Version: "v1",
+		Rules: []Rule{
+			ProfileCompleteness{Points: 15},
+			CheckupRecency{Points: 25, FullMonths: 12, HalfMonths: 24},
+			VisitFrequency{Points: 15, WindowMonths: 12, Few: 2, Many: 8},
+			VisitRecency{Points: 10, RecoveryDays: 90},
+			SelfAssessed{Points: 35, FreshDays: 2 * AssessmentInterval, StaleDays: 6 * AssessmentInterval, Neutral: 0.5},
+		},
+	})
+}
@@ -0,0 +1,106 @@
+package idempotency
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strings"
+
+	"health-bar/shared/apperrors"
+)
+
+// readBody buffers r's body and puts it back for the handler.
+func readBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil {
+		return nil, nil
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, MaxBody+1))
+	if err != nil {
+		return nil, apperrors.Wrap(err, apperrors.CodeInvalidRequest, "Invalid request body")
+	}
+	if int64(len(body)) > MaxBody {
+		return nil, apperrors.New(apperrors.CodeFileTooLarge, "Request body too large")
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// Fingerprint hashes what identifies a request: its method, path, query and
+// body. Bodies are compared by meaning where the encoding allows it: JSON
+// ignores whitespace and member order, and multipart ignores the boundary,
+// which most clients regenerate on every attempt.
+func Fingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	writeField(h, []byte(r.Method))
+	writeField(h, []byte(r.URL.Path))
+	writeField(h, []byte(r.URL.Query().Encode()))
+
+	mediaType, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch {
+	case mediaType == "application/json":
+		if canonical, ok := canonicalJSON(body); ok {
+			body = canonical
+		}
+	case strings.HasPrefix(mediaType, "multipart/") && params["boundary"] != "":
+		if hashMultipart(h, body, params["boundary"]) {
+			return hex.EncodeToString(h.Sum(nil))
+		}
+	}
+	writeField(h, []byte(mediaType))
+	writeField(h, body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// writeField writes b length-prefixed, so adjacent fields cannot run into
+// each other.
+func writeField(h hash.Hash, b []byte) {
+	var size [8]byte
+	binary.BigEndian.PutUint64(size[:], uint64(len(b)))
+	h.Write(size[:])
+	h.Write(b)
+}
+
+func canonicalJSON(body []byte) ([]byte, bool) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var v interface{}
+	if err := decoder.Decode(&v); err != nil {
+		return nil, false
+	}
+	canonical, err := json.Marshal(v)
+	return canonical, err == nil
+}
+
+// hashMultipart hashes each part's name, file name, type and content. It
+// reports false, having written nothing, when body is not valid multipart.
+func hashMultipart(h hash.Hash, body []byte, boundary string) bool {
+	parts := sha256.New()
+	reader := multipart.NewReader(bytes.NewReader(body), boundary)
+	for {
+		part, err := reader.NextRawPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return false
+		}
+		content, err := io.ReadAll(part)
+		if err != nil {
+			return false
+		}
+		writeField(parts, []byte(part.FormName()))
+		writeField(parts, []byte(part.FileName()))
+		writeField(parts, []byte(part.Header.Get("Content-Type")))
+		writeField(parts, content)
+	}
+	writeField(h, []byte("multipart"))
+	writeField(h, parts.Sum(nil))
+	return true
+}
@@ -0,0 +1,210 @@
+// Package idempotency makes create endpoints safe to retry.
+//
+// A client sends an Idempotency-Key header with a request it may retry. The
+// first request with a key reserves it and runs; its response is stored with
+// a fingerprint of the request. Retries within Window get the stored
+// response back instead of running again. Keys are scoped to the caller, so
+// two users can never see each other's responses.
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"health-bar/shared/apperrors"
+	"health-bar/shared/utils"
+)
+
+const (
+	// Header carries the client-chosen key.
+	Header = "Idempotency-Key"
+	// ReplayedHeader is set to "true" on responses served from the store.
+	ReplayedHeader = "Idempotent-Replayed"
+	// MaxKeyLength bounds the key; UUIDs fit comfortably.
+	MaxKeyLength = 255
+)
+
+var (
+	// Window is how long a stored response is replayed.
+	Window = 24 * time.Hour
+	// LockTimeout is how long a reservation without a response blocks
+	// retries. A request that crashed its server is run again afterwards.
+	LockTimeout = time.Minute
+	// MaxBody bounds the request bodies that are buffered for fingerprinting.
+	MaxBody int64 = 32 << 20
+	// CompleteRetry is the first delay before storing a response is tried
+	// again; it doubles up to LockTimeout/4.
+	CompleteRetry = time.Second
+)
+
+// Record is a reserved key and, once the request finished, its response.
+type Record struct {
+	UserID      string
+	Key         string
+	Method      string
+	Path        string
+	RequestHash string
+
+	Completed   bool
+	StatusCode  int
+	ContentType string
+	Body        []byte
+}
+
+// Store persists records.
+type Store interface {
+	// Reserve claims rec's key for a new request. It returns nil when the
+	// caller now holds the key, or the record already holding it.
+	Reserve(ctx context.Context, rec *Record) (*Record, error)
+	// Complete stores the response of a reserved key.
+	Complete(ctx context.Context, rec *Record) error
+	// Release gives up a reservation that has no response.
+	Release(ctx context.Context, userID, key string) error
+}
+
+// Middleware applies idempotency keys to the handlers it wraps.
+type Middleware struct {
+	store Store
+}
+
+// New returns a Middleware backed by store.
+func New(store Store) *Middleware {
+	return &Middleware{store: store}
+}
+
+// Wrap makes next idempotent. It must run inside AuthMiddleware, which
+// provides the caller identity keys are scoped to. Requests without a key
+// pass straight through.
+//
+// Only successful responses are stored. A failed request has not created
+// anything, so its reservation is released and the client may retry with
+// the same key, even with a corrected payload. A successful request keeps
+// its reservation even when its response cannot be stored: storing is
+// retried in the background, and retries meanwhile get 409 instead of
+// creating the resource twice.
+func (m *Middleware) Wrap(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(Header)
+		userID := r.Header.Get("X-User-ID")
+		if key == "" || userID == "" {
+			next(w, r)
+			return
+		}
+		if len(key) > MaxKeyLength {
+			utils.SendErrorCode(w, apperrors.CodeValidation, Header+" must be at most "+strconv.Itoa(MaxKeyLength)+" characters")
+			return
+		}
+
+		body, err := readBody(r)
+		if err != nil {
+			utils.SendAppError(w, err, "Invalid request body")
+			return
+		}
+
+		rec := &Record{
+			UserID:      userID,
+			Key:         key,
+			Method:      r.Method,
+			Path:        r.URL.Path,
+			RequestHash: Fingerprint(r, body),
+		}
+
+		existing, err := m.store.Reserve(r.Context(), rec)
+		if err != nil {
+			utils.SendAppError(w, err, "Failed to reserve idempotency key")
+			return
+		}
+		if existing != nil {
+			replay(w, rec, existing)
+			return
+		}
+
+		// The outcome must be recorded even if the client hung up meanwhile;
+		// its retry depends on it.
+		ctx := context.WithoutCancel(r.Context())
+		recorder := &recorder{ResponseWriter: w, status: http.StatusOK}
+		succeeded := false
+		defer func() {
+			if !succeeded {
+				if err := m.store.Release(ctx, userID, key); err != nil {
+					log.Printf("idempotency: release %q: %v", key, err)
+				}
+			}
+		}()
+
+		next(recorder, r)
+
+		if recorder.status < 200 || recorder.status >= 300 {
+			return
+		}
+		succeeded = true
+		rec.Completed = true
+		rec.StatusCode = recorder.status
+		rec.ContentType = recorder.Header().Get("Content-Type")
+		rec.Body = recorder.body.Bytes()
+		if err := m.store.Complete(ctx, rec); err != nil {
+			log.Printf("idempotency: complete %q: %v; retrying", key, err)
+			go m.completeLater(ctx, rec)
+		}
+	}
+}
+
+// completeLater keeps trying to store the response of a successful request
+// until it is stored or the key's window has passed.
+func (m *Middleware) completeLater(ctx context.Context, rec *Record) {
+	deadline := time.Now().Add(Window)
+	delay := CompleteRetry
+	for time.Now().Before(deadline) {
+		time.Sleep(delay)
+		err := m.store.Complete(ctx, rec)
+		if err == nil {
+			return
+		}
+		log.Printf("idempotency: complete %q: %v", rec.Key, err)
+		delay = min(delay*2, max(LockTimeout/4, CompleteRetry))
+	}
+}
+
+// replay answers a request whose key is already held by existing.
+func replay(w http.ResponseWriter, rec, existing *Record) {
+	if existing.Method != rec.Method || existing.Path != rec.Path || existing.RequestHash != rec.RequestHash {
+		utils.SendErrorCode(w, apperrors.CodeIdempotencyReused, "Idempotency key was already used for a different request")
+		return
+	}
+	if !existing.Completed {
+		utils.SendErrorCode(w, apperrors.CodeRequestInProgress, "A request with this idempotency key is still in progress")
+		return
+	}
+
+	if existing.ContentType != "" {
+		w.Header().Set("Content-Type", existing.ContentType)
+	}
+	w.Header().Set(ReplayedHeader, "true")
+	w.WriteHeader(existing.StatusCode)
+	w.Write(existing.Body)
+}
+
+// recorder passes a response through while keeping a copy of it.
+type recorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (r *recorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status, r.wroteHeader = status, true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *recorder) Write(p []byte) (int, error) {
+	r.wroteHeader = true
+	r.body.Write(p)
+	return r.ResponseWriter.Write(p)
+}
@@ -0,0 +1,225 @@
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"health-bar/shared/apperrors"
+	"health-bar/shared/testutil"
+	"health-bar/shared/utils"
+)
+
+// counter is a create handler that numbers the resources it makes.
+type counter struct {
+	calls  int
+	status int
+}
+
+func (c *counter) handle(w http.ResponseWriter, r *http.Request) {
+	c.calls++
+	if c.status != 0 {
+		utils.SendError(w, c.status, "failed")
+		return
+	}
+	utils.SendSuccess(w, http.StatusCreated, "created", map[string]int{"n": c.calls})
+}
+
+func send(t *testing.T, h http.HandlerFunc, body interface{}, userID, key string) (*httptest.ResponseRecorder, utils.Response) {
+	t.Helper()
+	req := testutil.NewRequest(t, http.MethodPost, "/api/things", body, userID, "patient")
+	if key != "" {
+		req.Header.Set(Header, key)
+	}
+	return testutil.Serve(t, h, req)
+}
+
+func TestReplay(t *testing.T) {
+	c := &counter{}
+	h := New(NewMemoryStore()).Wrap(c.handle)
+
+	rec, first := send(t, h, `{"name":"a","size":1}`, "u1", "k1")
+	testutil.ExpectStatus(t, rec, http.StatusCreated)
+
+	// Same payload, differently encoded
+	rec, again := send(t, h, `{ "size": 1, "name": "a" }`, "u1", "k1")
+	testutil.ExpectStatus(t, rec, http.StatusCreated)
+	if c.calls != 1 || rec.Header().Get(ReplayedHeader) != "true" {
+		t.Fatalf("calls = %d, replayed = %q; want one call and a replay", c.calls, rec.Header().Get(ReplayedHeader))
+	}
+	var original, replayed map[string]int
+	testutil.DecodeData(t, first, &original)
+	testutil.DecodeData(t, again, &replayed)
+	if replayed["n"] != original["n"] {
+		t.Fatalf("replayed data %v, want %v", replayed, original)
+	}
+
+	rec, resp := send(t, h, `{"name":"b","size":1}`, "u1", "k1")
+	testutil.ExpectStatus(t, rec, http.StatusUnprocessableEntity)
+	testutil.ExpectCode(t, resp, apperrors.CodeIdempotencyReused)
+
+	// Keys belong to their user, and requests without one always run
+	send(t, h, `{"name":"a","size":1}`, "u2", "k1")
+	send(t, h, `{"name":"a","size":1}`, "u1", "")
+	if c.calls != 3 {
+		t.Fatalf("calls = %d, want 3", c.calls)
+	}
+}
+
+func TestInProgress(t *testing.T) {
+	store := NewMemoryStore()
+	c := &counter{}
+	h := New(store).Wrap(c.handle)
+
+	// Reserve the key as a concurrent request would
+	req := testutil.NewRequest(t, http.MethodPost, "/api/things", `{"name":"a"}`, "u1", "patient")
+	held := &Record{UserID: "u1", Key: "k1", Method: http.MethodPost, Path: "/api/things", RequestHash: Fingerprint(req, []byte(`{"name":"a"}`))}
+	if existing, err := store.Reserve(req.Context(), held); err != nil || existing != nil {
+		t.Fatalf("Reserve = %v, %v", existing, err)
+	}
+
+	rec, resp := send(t, h, `{"name":"a"}`, "u1", "k1")
+	testutil.ExpectStatus(t, rec, http.StatusConflict)
+	testutil.ExpectCode(t, resp, apperrors.CodeRequestInProgress)
+
+	// A reservation that never completes is taken over after LockTimeout
+	now := time.Now()
+	store.Now = func() time.Time { return now.Add(LockTimeout + time.Second) }
+	rec, _ = send(t, h, `{"name":"a"}`, "u1", "k1")
+	testutil.ExpectStatus(t, rec, http.StatusCreated)
+	if c.calls != 1 {
+		t.Fatalf("calls = %d, want 1", c.calls)
+	}
+}
+
+func TestFailuresAreNotStored(t *testing.T) {
+	c := &counter{status: http.StatusInternalServerError}
+	h := New(NewMemoryStore()).Wrap(c.handle)
+
+	send(t, h, `{"name":"a"}`, "u1", "k1")
+	c.status = http.StatusBadRequest
+	send(t, h, `{"name":"a"}`, "u1", "k1")
+
+	// The corrected request may reuse the key
+	c.status = 0
+	rec, _ := send(t, h, `{"name":"b"}`, "u1", "k1")
+	testutil.ExpectStatus(t, rec, http.StatusCreated)
+	if c.calls != 3 {
+		t.Fatalf("calls = %d, want 3", c.calls)
+	}
+}
+
+// flakyStore fails to store responses until healed.
+type flakyStore struct {
+	*MemoryStore
+	mu     sync.Mutex
+	broken bool
+}
+
+func (s *flakyStore) Complete(ctx context.Context, rec *Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.broken {
+		return errors.New("connection refused")
+	}
+	return s.MemoryStore.Complete(ctx, rec)
+}
+
+func (s *flakyStore) heal() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.broken = false
+}
+
+func TestSuccessIsKeptWhenStoringFails(t *testing.T) {
+	retry := CompleteRetry
+	CompleteRetry = 10 * time.Millisecond
+	t.Cleanup(func() { CompleteRetry = retry })
+
+	store := &flakyStore{MemoryStore: NewMemoryStore(), broken: true}
+	c := &counter{}
+	h := New(store).Wrap(c.handle)
+
+	rec, _ := send(t, h, `{"name":"a"}`, "u1", "k1")
+	testutil.ExpectStatus(t, rec, http.StatusCreated)
+
+	// The resource exists, so a retry must not create it again
+	rec, resp := send(t, h, `{"name":"a"}`, "u1", "k1")
+	testutil.ExpectStatus(t, rec, http.StatusConflict)
+	testutil.ExpectCode(t, resp, apperrors.CodeRequestInProgress)
+
+	store.heal()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		rec, _ = send(t, h, `{"name":"a"}`, "u1", "k1")
+		if rec.Code == http.StatusCreated || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	testutil.ExpectStatus(t, rec, http.StatusCreated)
+	if c.calls != 1 || rec.Header().Get(ReplayedHeader) != "true" {
+		t.Fatalf("calls = %d, replayed = %q; want the stored response", c.calls, rec.Header().Get(ReplayedHeader))
+	}
+}
+
+func TestWindowExpiry(t *testing.T) {
+	store := NewMemoryStore()
+	c := &counter{}
+	h := New(store).Wrap(c.handle)
+
+	send(t, h, `{"name":"a"}`, "u1", "k1")
+	now := time.Now()
+	store.Now = func() time.Time { return now.Add(Window + time.Minute) }
+	rec, resp := send(t, h, `{"name":"b"}`, "u1", "k1")
+	testutil.ExpectStatus(t, rec, http.StatusCreated)
+	if c.calls != 2 || rec.Header().Get(ReplayedHeader) != "" {
+		t.Fatalf("calls = %d, replayed = %q after the window; resp %+v", c.calls, rec.Header().Get(ReplayedHeader), resp)
+	}
+}
+
+func TestFingerprintMultipart(t *testing.T) {
+	upload := func(content string) *http.Request {
+		var buf bytes.Buffer
+		writer := multipart.NewWriter(&buf)
+		part, _ := writer.CreateFormFile("file", "scan.pdf")
+		part.Write([]byte(content))
+		writer.Close()
+
+		req := httptest.NewRequest(http.MethodPost, "/api/prescriptions/upload", &buf)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		return req
+	}
+	fingerprint := func(req *http.Request) string {
+		body, err := readBody(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return Fingerprint(req, body)
+	}
+
+	// Each writer picks a fresh random boundary
+	a, b := upload("%PDF-1.4"), upload("%PDF-1.4")
+	if a.Header.Get("Content-Type") == b.Header.Get("Content-Type") {
+		t.Fatal("expected different boundaries")
+	}
+	if fingerprint(a) != fingerprint(b) {
+		t.Fatal("same upload with different boundaries has different fingerprints")
+	}
+	if fingerprint(upload("%PDF-1.4")) == fingerprint(upload("%PDF-1.5")) {
+		t.Fatal("different uploads have the same fingerprint")
+	}
+}
+
+func TestKeyTooLong(t *testing.T) {
+	h := New(NewMemoryStore()).Wrap((&counter{}).handle)
+	rec, resp := send(t, h, `{}`, "u1", string(bytes.Repeat([]byte("k"), MaxKeyLength+1)))
+	testutil.ExpectStatus(t, rec, http.StatusBadRequest)
+	testutil.ExpectCode(t, resp, apperrors.CodeValidation)
+}
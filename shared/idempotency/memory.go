@@ -0,0 +1,72 @@
+package idempotency
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps records in memory. It backs tests and single-process
+// deployments.
+type MemoryStore struct {
+	mu      sync.Mutex
+	records map[[2]string]*memoryRecord
+	// Now is the clock; tests move it to expire records.
+	Now func() time.Time
+}
+
+type memoryRecord struct {
+	Record
+	lockedAt  time.Time
+	expiresAt time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{records: map[[2]string]*memoryRecord{}, Now: time.Now}
+}
+
+func (s *MemoryStore) Reserve(ctx context.Context, rec *Record) (*Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.Now()
+	id := [2]string{rec.UserID, rec.Key}
+	if existing, ok := s.records[id]; ok {
+		abandoned := !existing.Completed && existing.RequestHash == rec.RequestHash && now.Sub(existing.lockedAt) > LockTimeout
+		if now.Before(existing.expiresAt) && !abandoned {
+			copied := existing.Record
+			return &copied, nil
+		}
+	}
+
+	s.records[id] = &memoryRecord{
+		Record:    Record{UserID: rec.UserID, Key: rec.Key, Method: rec.Method, Path: rec.Path, RequestHash: rec.RequestHash},
+		lockedAt:  now,
+		expiresAt: now.Add(Window),
+	}
+	return nil, nil
+}
+
+func (s *MemoryStore) Complete(ctx context.Context, rec *Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if existing, ok := s.records[[2]string{rec.UserID, rec.Key}]; ok {
+		existing.Completed = true
+		existing.StatusCode = rec.StatusCode
+		existing.ContentType = rec.ContentType
+		existing.Body = append([]byte(nil), rec.Body...)
+	}
+	return nil
+}
+
+func (s *MemoryStore) Release(ctx context.Context, userID, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := [2]string{userID, key}
+	if existing, ok := s.records[id]; ok && !existing.Completed {
+		delete(s.records, id)
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package idempotency
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"health-bar/shared/database"
+)
+
+// PostgresStore keeps records in the idempotency_keys table, which every
+// service shares.
+type PostgresStore struct {
+	db *sqlx.DB
+}
+
+func NewPostgresStore(db *sqlx.DB) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+type row struct {
+	Method       string         `db:"method"`
+	Path         string         `db:"path"`
+	RequestHash  string         `db:"request_hash"`
+	StatusCode   sql.NullInt64  `db:"status_code"`
+	ContentType  sql.NullString `db:"content_type"`
+	ResponseBody []byte         `db:"response_body"`
+}
+
+// Reserve inserts the key, or takes over a record that expired or whose
+// request was abandoned without a response.
+func (s *PostgresStore) Reserve(ctx context.Context, rec *Record) (*Record, error) {
+	ctx, cancel := database.WithTimeout(ctx)
+	defer cancel()
+
+	conn := database.Conn(ctx, s.db)
+	claim := `
+		INSERT INTO idempotency_keys (user_id, key, method, path, request_hash, locked_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, NOW(), NOW() + $6 * INTERVAL '1 second')
+		ON CONFLICT (user_id, key) DO UPDATE
+		SET method = EXCLUDED.method, path = EXCLUDED.path, request_hash = EXCLUDED.request_hash,
+			status_code = NULL, content_type = NULL, response_body = NULL, completed_at = NULL,
+			created_at = NOW(), locked_at = NOW(), expires_at = EXCLUDED.expires_at
+		WHERE idempotency_keys.expires_at < NOW()
+			OR (idempotency_keys.completed_at IS NULL
+				AND idempotency_keys.request_hash = EXCLUDED.request_hash
+				AND idempotency_keys.locked_at < NOW() - $7 * INTERVAL '1 second')
+		RETURNING true
+	`
+	lookup := `
+		SELECT method, path, request_hash, status_code, content_type, response_body
+		FROM idempotency_keys
+		WHERE user_id = $1 AND key = $2
+	`
+
+	// The holder may release its reservation between the two statements;
+	// claiming again then succeeds.
+	for attempt := 0; attempt < 3; attempt++ {
+		var claimed bool
+		err := conn.GetContext(ctx, &claimed, claim, rec.UserID, rec.Key, rec.Method, rec.Path, rec.RequestHash,
+			Window.Seconds(), LockTimeout.Seconds())
+		if err == nil {
+			return nil, nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+
+		var existing row
+		err = conn.GetContext(ctx, &existing, lookup, rec.UserID, rec.Key)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &Record{
+			UserID:      rec.UserID,
+			Key:         rec.Key,
+			Method:      existing.Method,
+			Path:        existing.Path,
+			RequestHash: existing.RequestHash,
+			Completed:   existing.StatusCode.Valid,
+			StatusCode:  int(existing.StatusCode.Int64),
+			ContentType: existing.ContentType.String,
+			Body:        existing.ResponseBody,
+		}, nil
+	}
+	return nil, errors.New("idempotency key is contended")
+}
+
+func (s *PostgresStore) Complete(ctx context.Context, rec *Record) error {
+	ctx, cancel := database.WithTimeout(ctx)
+	defer cancel()
+
+	query := `
+		UPDATE idempotency_keys
+		SET status_code = $3, content_type = $4, response_body = $5, completed_at = NOW()
+		WHERE user_id = $1 AND key = $2
+	`
+	_, err := database.Conn(ctx, s.db).ExecContext(ctx, query, rec.UserID, rec.Key, rec.StatusCode, rec.ContentType, rec.Body)
+	return err
+}
+
+func (s *PostgresStore) Release(ctx context.Context, userID, key string) error {
+	ctx, cancel := database.WithTimeout(ctx)
+	defer cancel()
+
+	query := `DELETE FROM idempotency_keys WHERE user_id = $1 AND key = $2 AND completed_at IS NULL`
+	_, err := database.Conn(ctx, s.db).ExecContext(ctx, query, userID, key)
+	return err
+}
+
+// Purge deletes expired records and returns how many there were.
+func (s *PostgresStore) Purge(ctx context.Context) (int64, error) {
+	ctx, cancel := database.WithTimeout(ctx)
+	defer cancel()
+
+	result, err := database.Conn(ctx, s.db).ExecContext(ctx, `DELETE FROM idempotency_keys WHERE expires_at < NOW()`)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// PurgeEvery purges expired records every interval until ctx is done.
+func (s *PostgresStore) PurgeEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.Purge(ctx); err != nil {
+				log.Printf("idempotency: purge: %v", err)
+			}
+		}
+	}
+}
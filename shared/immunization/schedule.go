@@ -0,0 +1,230 @@
+// Package immunization works out which vaccine doses a patient is due for.
+// A Schedule lists the vaccines with the age each dose is given at and the
+// minimum spacing between doses; Due compares it with the doses a patient
+// has received.
+//
+// The built-in schedule is embedded from schedule.json. Deployments
+// following a different national schedule load their own file with Load.
+package immunization
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"health-bar/shared/models"
+)
+
+//go:embed schedule.json
+var defaultSchedule []byte
+
+// Schedule is a set of vaccines and when their doses are given.
+type Schedule struct {
+	Name string `json:"name"`
+	// GraceDays is how long after its due date a dose becomes overdue.
+	GraceDays int       `json:"grace_days"`
+	Vaccines  []Vaccine `json:"vaccines"`
+}
+
+// Vaccine is a primary series of doses, optionally followed by boosters
+// every BoosterEveryMonths after the last dose.
+type Vaccine struct {
+	Code               string `json:"code"`
+	Name               string `json:"name"`
+	Doses              []Dose `json:"doses"`
+	BoosterEveryMonths int    `json:"booster_every_months,omitempty"`
+}
+
+// Dose is one dose of a primary series.
+type Dose struct {
+	MinAgeMonths int `json:"min_age_months"`
+	// MinIntervalDays is the least time since the previous dose.
+	MinIntervalDays int `json:"min_interval_days,omitempty"`
+	// MaxAgeMonths is the age from which the dose is no longer given; 0
+	// means never too late.
+	MaxAgeMonths int `json:"max_age_months,omitempty"`
+}
+
+// Status says whether a dose is already late, due now or coming up.
+type Status string
+
+const (
+	Overdue  Status = "overdue"
+	DueNow   Status = "due"
+	Upcoming Status = "upcoming"
+)
+
+// DueDose is the next dose of one vaccine.
+type DueDose struct {
+	Vaccine    string `json:"vaccine"`
+	Name       string `json:"name"`
+	DoseNumber int    `json:"dose_number"`
+	// Booster is true past the primary series.
+	Booster   bool   `json:"booster"`
+	DueOn     string `json:"due_on"`
+	OverdueOn string `json:"overdue_on"`
+	Status    Status `json:"status"`
+}
+
+// Default returns the built-in schedule.
+func Default() *Schedule {
+	s, err := Parse(defaultSchedule)
+	if err != nil {
+		panic("immunization: built-in schedule: " + err.Error())
+	}
+	return s
+}
+
+// Load reads a schedule from a JSON file.
+func Load(path string) (*Schedule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return s, nil
+}
+
+// Parse decodes and validates a JSON schedule. Vaccine codes are
+// lowercased.
+func Parse(data []byte) (*Schedule, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var s Schedule
+	if err := dec.Decode(&s); err != nil {
+		return nil, fmt.Errorf("invalid schedule: %w", err)
+	}
+
+	if s.GraceDays < 0 {
+		return nil, fmt.Errorf("grace_days must not be negative")
+	}
+	seen := map[string]bool{}
+	for i := range s.Vaccines {
+		v := &s.Vaccines[i]
+		v.Code = strings.ToLower(strings.TrimSpace(v.Code))
+		switch {
+		case v.Code == "":
+			return nil, fmt.Errorf("vaccines[%d]: code is required", i)
+		case seen[v.Code]:
+			return nil, fmt.Errorf("vaccines[%d]: duplicate code %q", i, v.Code)
+		case v.Name == "":
+			return nil, fmt.Errorf("%s: name is required", v.Code)
+		case len(v.Doses) == 0:
+			return nil, fmt.Errorf("%s: at least one dose is required", v.Code)
+		case v.BoosterEveryMonths < 0:
+			return nil, fmt.Errorf("%s: booster_every_months must not be negative", v.Code)
+		}
+		seen[v.Code] = true
+
+		for j, d := range v.Doses {
+			switch {
+			case d.MinAgeMonths < 0 || d.MinIntervalDays < 0 || d.MaxAgeMonths < 0:
+				return nil, fmt.Errorf("%s dose %d: ages and intervals must not be negative", v.Code, j+1)
+			case d.MaxAgeMonths != 0 && d.MaxAgeMonths <= d.MinAgeMonths:
+				return nil, fmt.Errorf("%s dose %d: max_age_months must be above min_age_months", v.Code, j+1)
+			case j > 0 && d.MinAgeMonths < v.Doses[j-1].MinAgeMonths:
+				return nil, fmt.Errorf("%s dose %d: doses must be in order of age", v.Code, j+1)
+			}
+		}
+	}
+	return &s, nil
+}
+
+// Lookup finds a vaccine by code, ignoring case.
+func (s *Schedule) Lookup(code string) (Vaccine, bool) {
+	code = strings.ToLower(strings.TrimSpace(code))
+	for _, v := range s.Vaccines {
+		if v.Code == code {
+			return v, true
+		}
+	}
+	return Vaccine{}, false
+}
+
+// Due lists the next dose of every vaccine that is overdue, due on today or
+// due within the following days, soonest first. A vaccine counts as having
+// as many doses as the highest dose number recorded for it, so a history
+// that starts midway through a series is not asked to repeat earlier doses.
+func (s *Schedule) Due(dob time.Time, given []models.Immunization, today time.Time, within int) []DueDose {
+	today = day(today)
+	dob = day(dob)
+	horizon := today.AddDate(0, 0, within)
+
+	type history struct {
+		doses int
+		last  time.Time
+	}
+	histories := map[string]history{}
+	for _, imm := range given {
+		h := histories[strings.ToLower(imm.Vaccine)]
+		h.doses = max(h.doses, imm.DoseNumber)
+		if imm.AdministeredOn.After(h.last) {
+			h.last = day(imm.AdministeredOn)
+		}
+		histories[strings.ToLower(imm.Vaccine)] = h
+	}
+
+	due := []DueDose{}
+	for _, v := range s.Vaccines {
+		h := histories[v.Code]
+		next := DueDose{Vaccine: v.Code, Name: v.Name, DoseNumber: h.doses + 1}
+
+		var on time.Time
+		switch {
+		case h.doses < len(v.Doses):
+			dose := v.Doses[h.doses]
+			on = dob.AddDate(0, dose.MinAgeMonths, 0)
+			if h.doses > 0 {
+				on = later(on, h.last.AddDate(0, 0, dose.MinIntervalDays))
+			}
+			if dose.MaxAgeMonths > 0 {
+				limit := dob.AddDate(0, dose.MaxAgeMonths, 0)
+				if !on.Before(limit) || !today.Before(limit) {
+					continue
+				}
+			}
+		case v.BoosterEveryMonths > 0:
+			on = h.last.AddDate(0, v.BoosterEveryMonths, 0)
+			next.Booster = true
+		default:
+			continue
+		}
+
+		overdueOn := on.AddDate(0, 0, s.GraceDays)
+		switch {
+		case today.After(overdueOn):
+			next.Status = Overdue
+		case !today.Before(on):
+			next.Status = DueNow
+		case !on.After(horizon):
+			next.Status = Upcoming
+		default:
+			continue
+		}
+		next.DueOn = on.Format("2006-01-02")
+		next.OverdueOn = overdueOn.Format("2006-01-02")
+		due = append(due, next)
+	}
+
+	slices.SortStableFunc(due, func(a, b DueDose) int { return strings.Compare(a.DueOn, b.DueOn) })
+	return due
+}
+
+func day(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+func later(a, b time.Time) time.Time {
+	if b.After(a) {
+		return b
+	}
+	return a
+}
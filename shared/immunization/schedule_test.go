@@ -0,0 +1,106 @@
+package immunization
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"health-bar/shared/models"
+)
+
+func date(s string) time.Time {
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func dose(vaccine string, number int, on string) models.Immunization {
+	return models.Immunization{Vaccine: vaccine, DoseNumber: number, AdministeredOn: date(on)}
+}
+
+func byVaccine(doses []DueDose) map[string]DueDose {
+	out := map[string]DueDose{}
+	for _, d := range doses {
+		out[d.Vaccine] = d
+	}
+	return out
+}
+
+func TestDueForInfant(t *testing.T) {
+	s := Default()
+	dob := date("2024-01-01")
+
+	due := byVaccine(s.Due(dob, nil, date("2024-03-15"), 90))
+	if d := due["hepb"]; d.Status != Overdue || d.DoseNumber != 1 || d.DueOn != "2024-01-01" {
+		t.Errorf("hepb = %+v", d)
+	}
+	for _, code := range []string{"rotavirus", "dtap", "hib", "pcv", "ipv"} {
+		if d := due[code]; d.Status != DueNow || d.DueOn != "2024-03-01" {
+			t.Errorf("%s = %+v", code, d)
+		}
+	}
+	if d, ok := due["influenza"]; ok {
+		t.Errorf("influenza at six months is beyond 90 days: %+v", d)
+	}
+	if d := byVaccine(s.Due(dob, nil, date("2024-03-15"), 120))["influenza"]; d.Status != Upcoming {
+		t.Errorf("influenza within 120 days = %+v", d)
+	}
+
+	// The third hepatitis B dose waits for six months of age even though
+	// the interval since dose 2 has passed
+	given := []models.Immunization{dose("hepb", 1, "2024-01-02"), dose("hepb", 2, "2024-02-05")}
+	due = byVaccine(s.Due(dob, given, date("2024-06-20"), 30))
+	if d := due["hepb"]; d.DoseNumber != 3 || d.DueOn != "2024-07-01" || d.Status != Upcoming {
+		t.Errorf("hepb dose 3 = %+v", d)
+	}
+}
+
+func TestDueIntervalsBoostersAndAgeLimits(t *testing.T) {
+	s := Default()
+
+	// A late first varicella dose pushes the second out by the interval
+	child := date("2019-01-01")
+	given := []models.Immunization{dose("varicella", 1, "2022-12-01")}
+	if d := byVaccine(s.Due(child, given, date("2023-02-01"), 60))["varicella"]; d.DueOn != "2023-02-23" {
+		t.Errorf("varicella dose 2 = %+v", d)
+	}
+
+	// A history that starts at dose 4 continues from there
+	given = []models.Immunization{dose("dtap", 4, "2020-06-01")}
+	if d := byVaccine(s.Due(child, given, date("2023-01-15"), 0))["dtap"]; d.DoseNumber != 5 || d.DueOn != "2023-01-01" || d.Status != DueNow {
+		t.Errorf("dtap dose 5 = %+v", d)
+	}
+
+	adult := date("1990-01-01")
+	given = []models.Immunization{dose("tdap", 1, "2015-05-01")}
+	due := byVaccine(s.Due(adult, given, date("2025-06-15"), 0))
+	if d := due["tdap"]; !d.Booster || d.DoseNumber != 2 || d.DueOn != "2025-05-01" || d.Status != Overdue {
+		t.Errorf("tdap booster = %+v", d)
+	}
+	for _, code := range []string{"rotavirus", "dtap", "hib", "pcv", "ipv", "hpv", "menacwy"} {
+		if d, ok := due[code]; ok {
+			t.Errorf("%s is past its age limit for an adult: %+v", code, d)
+		}
+	}
+	if _, ok := due["mmr"]; !ok {
+		t.Error("mmr has no age limit and should be due")
+	}
+}
+
+func TestParseRejects(t *testing.T) {
+	for name, tc := range map[string]struct {
+		json, err string
+	}{
+		"unknown field": {`{"vaccines": [{"code": "x", "name": "X", "doses": [{"min_age": 1}]}]}`, "unknown field"},
+		"duplicate":     {`{"vaccines": [{"code": "x", "name": "X", "doses": [{}]}, {"code": "X", "name": "Y", "doses": [{}]}]}`, "duplicate"},
+		"no doses":      {`{"vaccines": [{"code": "x", "name": "X"}]}`, "at least one dose"},
+		"out of order":  {`{"vaccines": [{"code": "x", "name": "X", "doses": [{"min_age_months": 4}, {"min_age_months": 2}]}]}`, "order"},
+		"max age":       {`{"vaccines": [{"code": "x", "name": "X", "doses": [{"min_age_months": 4, "max_age_months": 4}]}]}`, "max_age_months"},
+	} {
+		if _, err := Parse([]byte(tc.json)); err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%s: err = %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,369 @@
+// Package interactions checks a candidate drug against a patient's current
+// medications and allergies before it is prescribed.
+//
+// The knowledge comes from a Dataset: drugs with their aliases and classes,
+// interactions between drugs or classes, and allergy classes with the
+// classes they cross-react with. Datasets are versioned and imported with
+// cmd/interactions; every check reports the version it was made against.
+package interactions
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+
+	"health-bar/shared/models"
+)
+
+// Severity ranks a warning. High warnings block a prescription unless the
+// prescriber gives an override reason.
+type Severity string
+
+const (
+	Low      Severity = "low"
+	Moderate Severity = "moderate"
+	High     Severity = "high"
+)
+
+func (s Severity) rank() int {
+	switch s {
+	case Low:
+		return 1
+	case Moderate:
+		return 2
+	case High:
+		return 3
+	}
+	return 0
+}
+
+// Kind is what a warning was raised against.
+type Kind string
+
+const (
+	DrugInteraction Kind = "drug_interaction"
+	Allergy         Kind = "allergy"
+	CrossReactivity Kind = "cross_reactivity"
+)
+
+// Dataset is one version of the interaction knowledge.
+type Dataset struct {
+	Version        string         `json:"version"`
+	Drugs          []Drug         `json:"drugs"`
+	Interactions   []Interaction  `json:"interactions"`
+	AllergyClasses []AllergyClass `json:"allergy_classes"`
+
+	drugs map[string]*Drug
+	// longest is the most words in a drug name or alias
+	longest int
+}
+
+// Drug is a drug known by Name or any of its Aliases (brand names) and a
+// member of Classes.
+type Drug struct {
+	Name    string   `json:"name"`
+	Aliases []string `json:"aliases,omitempty"`
+	Classes []string `json:"classes,omitempty"`
+}
+
+// Interaction between A and B, each a drug name or a class.
+type Interaction struct {
+	A        string   `json:"a"`
+	B        string   `json:"b"`
+	Severity Severity `json:"severity"`
+	Effect   string   `json:"effect"`
+	Advice   string   `json:"advice,omitempty"`
+}
+
+// AllergyClass groups the drugs a patient allergic to one of Substances
+// (or to the class itself) must not take. CrossReactive lists other classes
+// that may trigger the same allergy.
+type AllergyClass struct {
+	Class         string          `json:"class"`
+	Name          string          `json:"name"`
+	Substances    []string        `json:"substances,omitempty"`
+	CrossReactive []CrossReaction `json:"cross_reactive,omitempty"`
+}
+
+type CrossReaction struct {
+	Class    string   `json:"class"`
+	Severity Severity `json:"severity"`
+	Note     string   `json:"note,omitempty"`
+}
+
+// Warning is one problem found with a candidate drug. With is the
+// medication or allergy it concerns.
+type Warning struct {
+	Kind     Kind     `json:"kind"`
+	Severity Severity `json:"severity"`
+	With     string   `json:"with"`
+	Message  string   `json:"message"`
+	Advice   string   `json:"advice,omitempty"`
+}
+
+// Result is the outcome of a check. Recognized is false when the candidate
+// is not in the dataset, in which case only exact name matches against the
+// patient's allergies could be checked.
+type Result struct {
+	Version          string    `json:"dataset_version"`
+	Drug             string    `json:"drug"`
+	Recognized       bool      `json:"recognized"`
+	Warnings         []Warning `json:"warnings"`
+	RequiresOverride bool      `json:"requires_override"`
+}
+
+//go:embed dataset.json
+var defaultDataset []byte
+
+// Default returns the dataset built into the binary, used until one is
+// imported.
+func Default() *Dataset {
+	d, err := Parse(defaultDataset)
+	if err != nil {
+		panic("interactions: built-in dataset: " + err.Error())
+	}
+	return d
+}
+
+// Parse decodes and validates a dataset.
+func Parse(data []byte) (*Dataset, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+
+	var d Dataset
+	if err := dec.Decode(&d); err != nil {
+		return nil, fmt.Errorf("interactions: %w", err)
+	}
+	if err := d.index(); err != nil {
+		return nil, fmt.Errorf("interactions: %w", err)
+	}
+	return &d, nil
+}
+
+func normalize(s string) string {
+	return strings.Join(strings.Fields(strings.ToLower(s)), " ")
+}
+
+// nameKey reduces a drug name to the words it is looked up by: lower case,
+// apostrophes dropped and other punctuation read as spaces, so "St. John's
+// Wort", "st johns wort" and "co-amoxiclav"/"co amoxiclav" match.
+func nameKey(s string) []string {
+	s = strings.NewReplacer("'", "", "’", "").Replace(strings.ToLower(s))
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+func (d *Dataset) index() error {
+	d.Version = strings.TrimSpace(d.Version)
+	if d.Version == "" || len(d.Version) > 50 {
+		return fmt.Errorf("version is required and at most 50 characters")
+	}
+
+	known := map[string]bool{}
+	d.drugs = map[string]*Drug{}
+	for i := range d.Drugs {
+		drug := &d.Drugs[i]
+		drug.Name = normalize(drug.Name)
+		if drug.Name == "" {
+			return fmt.Errorf("drug %d has no name", i+1)
+		}
+		for j, c := range drug.Classes {
+			drug.Classes[j] = normalize(c)
+			known[drug.Classes[j]] = true
+		}
+		for _, name := range append([]string{drug.Name}, drug.Aliases...) {
+			words := nameKey(name)
+			key := strings.Join(words, " ")
+			if key == "" {
+				return fmt.Errorf("drug %q has a name or alias without letters or digits", drug.Name)
+			}
+			if _, dup := d.drugs[key]; dup {
+				return fmt.Errorf("drug name or alias %q is listed twice", normalize(name))
+			}
+			d.drugs[key] = drug
+			d.longest = max(d.longest, len(words))
+		}
+		known[drug.Name] = true
+	}
+
+	allergyClasses := map[string]bool{}
+	for i := range d.AllergyClasses {
+		class := &d.AllergyClasses[i]
+		class.Class = normalize(class.Class)
+		if class.Class == "" || class.Name == "" {
+			return fmt.Errorf("allergy class %d needs a class and a name", i+1)
+		}
+		if allergyClasses[class.Class] {
+			return fmt.Errorf("allergy class %q is listed twice", class.Class)
+		}
+		if !known[class.Class] {
+			return fmt.Errorf("allergy class %q has no drugs", class.Class)
+		}
+		for j, s := range class.Substances {
+			class.Substances[j] = normalize(s)
+		}
+		for j := range class.CrossReactive {
+			cr := &class.CrossReactive[j]
+			cr.Class = normalize(cr.Class)
+			if !known[cr.Class] {
+				return fmt.Errorf("allergy class %q cross-reacts with unknown class %q", class.Class, cr.Class)
+			}
+			if cr.Severity.rank() == 0 {
+				return fmt.Errorf("allergy class %q: invalid severity %q", class.Class, cr.Severity)
+			}
+		}
+		allergyClasses[class.Class] = true
+	}
+
+	for i := range d.Interactions {
+		in := &d.Interactions[i]
+		in.A, in.B = normalize(in.A), normalize(in.B)
+		if !known[in.A] || !known[in.B] {
+			return fmt.Errorf("interaction %d names an unknown drug or class (%q, %q)", i+1, in.A, in.B)
+		}
+		if in.Severity.rank() == 0 {
+			return fmt.Errorf("interaction %d: invalid severity %q", i+1, in.Severity)
+		}
+		if in.Effect == "" {
+			return fmt.Errorf("interaction %d has no effect", i+1)
+		}
+	}
+	return nil
+}
+
+// Lookup finds a drug by name or alias. Free-text names such as
+// "Acetylsalicylic acid 100mg" are matched on the longest run of words that
+// names a drug, the leftmost of equally long ones, so "amoxicillin-
+// clavulanate 875 mg" finds co-amoxiclav rather than amoxicillin.
+func (d *Dataset) Lookup(name string) (*Drug, bool) {
+	words := nameKey(name)
+	for n := min(len(words), d.longest); n > 0; n-- {
+		for i := 0; i+n <= len(words); i++ {
+			if drug, ok := d.drugs[strings.Join(words[i:i+n], " ")]; ok {
+				return drug, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// is reports whether drug is term or a member of class term.
+func (drug *Drug) is(term string) bool {
+	return drug.Name == term || slices.Contains(drug.Classes, term)
+}
+
+// Check checks candidate against the patient's medications and allergies
+// and returns the warnings, most severe first.
+func (d *Dataset) Check(candidate string, medications []string, allergies []models.Allergy) Result {
+	result := Result{Version: d.Version, Drug: candidate, Warnings: []Warning{}}
+
+	drug, ok := d.Lookup(candidate)
+	result.Recognized = ok
+	if !ok {
+		drug = &Drug{Name: normalize(candidate)}
+	}
+
+	for _, med := range medications {
+		other, ok := d.Lookup(med)
+		if !ok {
+			continue
+		}
+		for _, in := range d.Interactions {
+			if (drug.is(in.A) && other.is(in.B)) || (drug.is(in.B) && other.is(in.A)) {
+				result.Warnings = append(result.Warnings, Warning{
+					Kind:     DrugInteraction,
+					Severity: in.Severity,
+					With:     med,
+					Message:  in.Effect,
+					Advice:   in.Advice,
+				})
+			}
+		}
+	}
+
+	for _, allergy := range allergies {
+		result.Warnings = append(result.Warnings, d.checkAllergy(drug, allergy)...)
+	}
+
+	slices.SortStableFunc(result.Warnings, func(a, b Warning) int {
+		return b.Severity.rank() - a.Severity.rank()
+	})
+	for _, w := range result.Warnings {
+		if w.Severity == High {
+			result.RequiresOverride = true
+		}
+	}
+	return result
+}
+
+func (d *Dataset) checkAllergy(drug *Drug, allergy models.Allergy) []Warning {
+	substance := normalize(allergy.Substance)
+	if substance == "" {
+		return nil
+	}
+
+	// The allergy names the drug itself
+	if allergic, ok := d.Lookup(substance); (ok && allergic == drug) || substance == drug.Name {
+		return []Warning{{
+			Kind:     Allergy,
+			Severity: High,
+			With:     allergy.Substance,
+			Message:  fmt.Sprintf("Patient is allergic to %s", allergy.Substance),
+		}}
+	}
+
+	var warnings []Warning
+	for _, class := range d.allergyClasses(substance) {
+		if drug.is(class.Class) {
+			warnings = append(warnings, Warning{
+				Kind:     Allergy,
+				Severity: High,
+				With:     allergy.Substance,
+				Message:  fmt.Sprintf("%s belongs to the %s the patient is allergic to", drug.Name, class.Name),
+			})
+			continue
+		}
+		for _, cr := range class.CrossReactive {
+			if drug.is(cr.Class) {
+				warnings = append(warnings, Warning{
+					Kind:     CrossReactivity,
+					Severity: cr.Severity,
+					With:     allergy.Substance,
+					Message:  fmt.Sprintf("%s may cross-react with the patient's allergy to %s", drug.Name, class.Name),
+					Advice:   cr.Note,
+				})
+			}
+		}
+	}
+	return warnings
+}
+
+// allergyClasses returns the allergy classes an allergy to substance
+// implies: classes it names or lists, and the classes of a drug it names.
+func (d *Dataset) allergyClasses(substance string) []*AllergyClass {
+	var out []*AllergyClass
+	drug, isDrug := d.Lookup(substance)
+	for i := range d.AllergyClasses {
+		class := &d.AllergyClasses[i]
+		if class.Class == substance || slices.Contains(class.Substances, substance) || (isDrug && drug.is(class.Class)) {
+			out = append(out, class)
+		}
+	}
+	return out
+}
+
+// Summary describes the high-severity warnings of r in one line.
+func (r Result) Summary() string {
+	var parts []string
+	for _, w := range r.Warnings {
+		if w.Severity == High {
+			parts = append(parts, fmt.Sprintf("%s (%s)", w.Message, w.With))
+		}
+	}
+	return strings.Join(parts, "; ")
+}
@@ -0,0 +1,129 @@
+package interactions
+
+import (
+	"strings"
+	"testing"
+
+	"health-bar/shared/models"
+)
+
+func allergy(substance string) models.Allergy {
+	return models.Allergy{Substance: substance, Severity: models.SeveritySevere}
+}
+
+func TestCheckDrugInteractions(t *testing.T) {
+	d := Default()
+
+	result := d.Check("Ibuprofen", []string{"Warfarin 5mg", "Sertraline", "Unknown herbal tea"}, nil)
+	if !result.Recognized || !result.RequiresOverride || result.Version != d.Version {
+		t.Fatalf("result = %+v", result)
+	}
+	if len(result.Warnings) != 2 {
+		t.Fatalf("warnings = %+v", result.Warnings)
+	}
+	// Most severe first
+	if w := result.Warnings[0]; w.Kind != DrugInteraction || w.Severity != High || w.With != "Warfarin 5mg" {
+		t.Errorf("first warning = %+v", w)
+	}
+	if w := result.Warnings[1]; w.Severity != Moderate || w.With != "Sertraline" {
+		t.Errorf("second warning = %+v", w)
+	}
+
+	// Brand names resolve to the drug
+	if result := d.Check("Viagra", []string{"GTN spray"}, nil); !result.RequiresOverride {
+		t.Errorf("sildenafil with nitrates = %+v", result)
+	}
+	if result := d.Check("paracetamol", []string{"warfarin"}, nil); len(result.Warnings) != 0 {
+		t.Errorf("paracetamol with warfarin = %+v", result.Warnings)
+	}
+}
+
+func TestLookupFreeText(t *testing.T) {
+	d := Default()
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"Warfarin 5mg", "warfarin"},
+		{"acetylsalicylic acid 100mg", "aspirin"},
+		{"Acetylsalicylic-Acid", "aspirin"},
+		{"St John's wort", "st john's wort"},
+		{"St. John’s Wort extract 300 mg", "st john's wort"},
+		{"st johns wort", "st john's wort"},
+		{"Co-Amoxiclav 625", "co-amoxiclav"},
+		{"co amoxiclav", "co-amoxiclav"},
+		// The longer alias wins over the drug named by its first word
+		{"Amoxicillin-clavulanate 875/125 mg", "co-amoxiclav"},
+		{"Penicillin V 250 mg four times daily", "penicillin v"},
+		{"slow-release potassium chloride", "potassium chloride"},
+		{"Glyceryl trinitrate spray", "nitroglycerin"},
+	}
+	for _, tt := range tests {
+		drug, ok := d.Lookup(tt.name)
+		if !ok || drug.Name != tt.want {
+			t.Errorf("Lookup(%q) = %v, %v; want %s", tt.name, drug, ok, tt.want)
+		}
+	}
+
+	// Part of a multi-word name is not the drug
+	for _, name := range []string{"acid", "potassium", "st john", "wort", "penicillin", "Unknown herbal tea", ""} {
+		if drug, ok := d.Lookup(name); ok {
+			t.Errorf("Lookup(%q) = %s", name, drug.Name)
+		}
+	}
+
+	if result := d.Check("St John's Wort", []string{"Sertraline 50mg"}, nil); !result.Recognized || len(result.Warnings) != 1 {
+		t.Errorf("St John's wort with sertraline = %+v", result)
+	}
+}
+
+func TestCheckAllergies(t *testing.T) {
+	d := Default()
+
+	for name, tc := range map[string]struct {
+		drug     string
+		allergy  string
+		kind     Kind
+		severity Severity
+	}{
+		"class named":      {"Amoxicillin", "Penicillin", Allergy, High},
+		"class via drug":   {"ampicillin", "amoxicillin", Allergy, High},
+		"drug itself":      {"Augmentin", "co-amoxiclav", Allergy, High},
+		"cross-reactivity": {"cephalexin", "PCN", CrossReactivity, Moderate},
+		"abbreviation":     {"bactrim", "sulfa", Allergy, High},
+		"unknown drug":     {"Foozole", "foozole", Allergy, High},
+	} {
+		result := d.Check(tc.drug, nil, []models.Allergy{allergy(tc.allergy)})
+		if len(result.Warnings) != 1 || result.Warnings[0].Kind != tc.kind || result.Warnings[0].Severity != tc.severity {
+			t.Errorf("%s: warnings = %+v", name, result.Warnings)
+		}
+	}
+
+	if result := d.Check("azithromycin", nil, []models.Allergy{allergy("penicillin")}); len(result.Warnings) != 0 {
+		t.Errorf("macrolide with a penicillin allergy = %+v", result.Warnings)
+	}
+	if result := d.Check("Foozole", nil, nil); result.Recognized {
+		t.Errorf("unknown drug recognized: %+v", result)
+	}
+}
+
+func TestParseRejects(t *testing.T) {
+	for name, tc := range map[string]struct {
+		json, err string
+	}{
+		"no version":    {`{"drugs": []}`, "version"},
+		"unknown field": {`{"version": "1", "drugz": []}`, "unknown field"},
+		"duplicate":     {`{"version": "1", "drugs": [{"name": "a"}, {"name": "b", "aliases": ["A"]}]}`, "listed twice"},
+		"same words":    {`{"version": "1", "drugs": [{"name": "co-a"}, {"name": "b", "aliases": ["Co A"]}]}`, "listed twice"},
+		"no words":      {`{"version": "1", "drugs": [{"name": "a", "aliases": ["--"]}]}`, "without letters or digits"},
+		"unknown term":  {`{"version": "1", "drugs": [{"name": "a"}], "interactions": [{"a": "a", "b": "c", "severity": "high", "effect": "x"}]}`, "unknown drug or class"},
+		"bad severity":  {`{"version": "1", "drugs": [{"name": "a"}], "interactions": [{"a": "a", "b": "a", "severity": "severe", "effect": "x"}]}`, "invalid severity"},
+		"empty class":   {`{"version": "1", "allergy_classes": [{"class": "x", "name": "X"}]}`, "has no drugs"},
+		"unknown cross": {`{"version": "1", "drugs": [{"name": "a", "classes": ["x"]}], "allergy_classes": [{"class": "x", "name": "X", "cross_reactive": [{"class": "y", "severity": "low"}]}]}`, "unknown class"},
+	} {
+		if _, err := Parse([]byte(tc.json)); err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%s: err = %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package interactions
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"health-bar/shared/database"
+)
+
+// PostgresStore keeps imported datasets in the interaction_datasets table.
+// The most recently imported version is the current one.
+type PostgresStore struct {
+	db *sqlx.DB
+}
+
+func NewPostgresStore(db *sqlx.DB) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+// Version describes an imported dataset.
+type Version struct {
+	Version    string    `db:"version"`
+	Checksum   string    `db:"checksum"`
+	ImportedAt time.Time `db:"imported_at"`
+}
+
+// Import validates data and stores it as a new version. Importing the same
+// version again is a no-op when the content is unchanged and an error when
+// it differs: a changed dataset needs a new version.
+func (s *PostgresStore) Import(ctx context.Context, data []byte) (*Dataset, bool, error) {
+	d, err := Parse(data)
+	if err != nil {
+		return nil, false, err
+	}
+	sum := sha256.Sum256(data)
+	checksum := hex.EncodeToString(sum[:])
+
+	ctx, cancel := database.WithTimeout(ctx)
+	defer cancel()
+
+	conn := database.Conn(ctx, s.db)
+	var inserted bool
+	err = conn.GetContext(ctx, &inserted, `
+		INSERT INTO interaction_datasets (version, checksum, data)
+		VALUES ($1, $2, $3::jsonb)
+		ON CONFLICT (version) DO NOTHING
+		RETURNING true
+	`, d.Version, checksum, string(data))
+	if err == nil {
+		return d, true, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, false, err
+	}
+
+	var existing string
+	if err := conn.GetContext(ctx, &existing, `SELECT checksum FROM interaction_datasets WHERE version = $1`, d.Version); err != nil {
+		return nil, false, err
+	}
+	if existing != checksum {
+		return nil, false, fmt.Errorf("interactions: version %s was already imported with different content", d.Version)
+	}
+	return d, false, nil
+}
+
+// Current loads the most recently imported dataset. It returns
+// sql.ErrNoRows when none was imported.
+func (s *PostgresStore) Current(ctx context.Context) (*Dataset, error) {
+	ctx, cancel := database.WithTimeout(ctx)
+	defer cancel()
+
+	var data []byte
+	query := `SELECT data FROM interaction_datasets ORDER BY imported_at DESC, version DESC LIMIT 1`
+	if err := database.Conn(ctx, s.db).GetContext(ctx, &data, query); err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// Versions lists the imported datasets, newest first.
+func (s *PostgresStore) Versions(ctx context.Context) ([]Version, error) {
+	ctx, cancel := database.WithTimeout(ctx)
+	defer cancel()
+
+	var versions []Version
+	query := `SELECT version, checksum, imported_at FROM interaction_datasets ORDER BY imported_at DESC, version DESC`
+	err := database.Conn(ctx, s.db).SelectContext(ctx, &versions, query)
+	return versions, err
+}
+
+// WatchEvery loads the current dataset every interval until ctx is done and
+// passes it to use whenever its version changes from current.
+func (s *PostgresStore) WatchEvery(ctx context.Context, interval time.Duration, current string, use func(*Dataset)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			d, err := s.Current(ctx)
+			if err != nil {
+				if !errors.Is(err, sql.ErrNoRows) {
+					log.Printf("interactions: reload: %v", err)
+				}
+				continue
+			}
+			if d.Version != current {
+				log.Printf("interactions: using dataset %s", d.Version)
+				current = d.Version
+				use(d)
+			}
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package labs
+
+// Adult reference ranges follow common laboratory practice; individual labs
+// differ slightly, which is why a range printed on the report takes
+// precedence.
+
+// Conversion factors from SI units
+const (
+	glucoseMmol     = 18.016 // mmol/L to mg/dL
+	cholesterolMmol = 38.67  // mmol/L to mg/dL
+	triglycerideMol = 88.57  // mmol/L to mg/dL
+	creatinineMumol = 1 / 88.42
+	vitaminDNmol    = 1 / 2.496
+)
+
+func between(low, high float64) Bounds { return Bounds{Low: ptr(low), High: ptr(high)} }
+func below(high float64) Bounds        { return Bounds{High: ptr(high)} }
+func above(low float64) Bounds         { return Bounds{Low: ptr(low)} }
+
+var catalog = map[string]Analyte{}
+
+func add(a Analyte) { catalog[a.Code] = a }
+
+func init() {
+	add(Analyte{
+		Code: "hemoglobin", Name: "Hemoglobin", Unit: "g/dL",
+		Units: map[string]float64{"g/L": 0.1},
+		Ranges: []Range{
+			{MinAge: 1, MaxAge: 18, Bounds: between(11, 15.5)},
+			{Sex: Male, MinAge: 18, Bounds: between(13.5, 17.5)},
+			{Sex: Female, MinAge: 18, Bounds: between(12, 15.5)},
+		},
+		Critical: between(7, 20),
+	})
+	add(Analyte{
+		Code: "wbc", Name: "White blood cells", Unit: "10^9/L",
+		Units:    map[string]float64{"10^3/uL": 1},
+		Ranges:   []Range{{Bounds: between(4, 11)}},
+		Critical: between(2, 30),
+	})
+	add(Analyte{
+		Code: "platelets", Name: "Platelets", Unit: "10^9/L",
+		Units:    map[string]float64{"10^3/uL": 1},
+		Ranges:   []Range{{Bounds: between(150, 400)}},
+		Critical: between(50, 1000),
+	})
+	add(Analyte{
+		Code: "glucose", Name: "Fasting glucose", Unit: "mg/dL",
+		Units:    map[string]float64{"mmol/L": glucoseMmol},
+		Ranges:   []Range{{Bounds: between(70, 99)}},
+		Critical: between(40, 500),
+	})
+	add(Analyte{
+		Code: "hba1c", Name: "Hemoglobin A1c", Unit: "%",
+		Ranges: []Range{{Bounds: between(4, 5.6)}},
+	})
+	add(Analyte{
+		Code: "creatinine", Name: "Creatinine", Unit: "mg/dL",
+		Units: map[string]float64{"umol/L": creatinineMumol},
+		Ranges: []Range{
+			{MaxAge: 18, Bounds: between(0.3, 0.7)},
+			{Sex: Male, MinAge: 18, Bounds: between(0.74, 1.35)},
+			{Sex: Female, MinAge: 18, Bounds: between(0.59, 1.04)},
+		},
+		Critical: below(10),
+	})
+	add(Analyte{
+		Code: "sodium", Name: "Sodium", Unit: "mmol/L",
+		Units:    map[string]float64{"mEq/L": 1},
+		Ranges:   []Range{{Bounds: between(135, 145)}},
+		Critical: between(120, 160),
+	})
+	add(Analyte{
+		Code: "potassium", Name: "Potassium", Unit: "mmol/L",
+		Units:    map[string]float64{"mEq/L": 1},
+		Ranges:   []Range{{Bounds: between(3.5, 5)}},
+		Critical: between(2.5, 6.5),
+	})
+	add(Analyte{
+		Code: "total_cholesterol", Name: "Total cholesterol", Unit: "mg/dL",
+		Units:  map[string]float64{"mmol/L": cholesterolMmol},
+		Ranges: []Range{{Bounds: below(200)}},
+	})
+	add(Analyte{
+		Code: "ldl", Name: "LDL cholesterol", Unit: "mg/dL",
+		Units:  map[string]float64{"mmol/L": cholesterolMmol},
+		Ranges: []Range{{Bounds: below(100)}},
+	})
+	add(Analyte{
+		Code: "hdl", Name: "HDL cholesterol", Unit: "mg/dL",
+		Units: map[string]float64{"mmol/L": cholesterolMmol},
+		Ranges: []Range{
+			{Sex: Male, Bounds: above(40)},
+			{Sex: Female, Bounds: above(50)},
+		},
+	})
+	add(Analyte{
+		Code: "triglycerides", Name: "Triglycerides", Unit: "mg/dL",
+		Units:    map[string]float64{"mmol/L": triglycerideMol},
+		Ranges:   []Range{{Bounds: below(150)}},
+		Critical: below(1000),
+	})
+	add(Analyte{
+		Code: "tsh", Name: "Thyroid-stimulating hormone", Unit: "mIU/L",
+		Units:  map[string]float64{"uIU/mL": 1},
+		Ranges: []Range{{Bounds: between(0.4, 4)}},
+	})
+	add(Analyte{
+		Code: "alt", Name: "Alanine aminotransferase", Unit: "U/L",
+		Units: map[string]float64{"IU/L": 1},
+		Ranges: []Range{
+			{Sex: Male, Bounds: between(7, 55)},
+			{Sex: Female, Bounds: between(7, 45)},
+		},
+	})
+	add(Analyte{
+		Code: "vitamin_d", Name: "Vitamin D, 25-hydroxy", Unit: "ng/mL",
+		Units:  map[string]float64{"nmol/L": vitaminDNmol},
+		Ranges: []Range{{Bounds: between(30, 100)}},
+	})
+}
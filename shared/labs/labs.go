@@ -0,0 +1,265 @@
+// Package labs interprets lab results. It holds a catalog of common
+// analytes with their units and reference ranges by sex and age, and flags
+// results against the range that applies to the patient.
+//
+// Analytes outside the catalog are accepted as reported: they keep their
+// unit and are flagged only against a range given with the result.
+package labs
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"time"
+
+	"health-bar/shared/apperrors"
+	"health-bar/shared/models"
+)
+
+// Sex selects sex-specific reference ranges. Unknown applies to patients
+// whose profile does not say.
+type Sex string
+
+const (
+	Unknown Sex = ""
+	Male    Sex = "male"
+	Female  Sex = "female"
+)
+
+// ParseSex reads the free-text gender of a patient profile.
+func ParseSex(gender string) Sex {
+	switch strings.ToLower(strings.TrimSpace(gender)) {
+	case "male", "m", "man":
+		return Male
+	case "female", "f", "woman":
+		return Female
+	}
+	return Unknown
+}
+
+// AgeAt is the age in whole years of someone born on dob at time at.
+func AgeAt(dob, at time.Time) int {
+	age := at.Year() - dob.Year()
+	if at.Month() < dob.Month() || (at.Month() == dob.Month() && at.Day() < dob.Day()) {
+		age--
+	}
+	return max(age, 0)
+}
+
+// Bounds is a closed interval; a nil end is unbounded.
+type Bounds struct {
+	Low  *float64 `json:"low,omitempty"`
+	High *float64 `json:"high,omitempty"`
+}
+
+func (b Bounds) empty() bool { return b.Low == nil && b.High == nil }
+
+func (b Bounds) scaled(factor float64) Bounds {
+	scale := func(v *float64) *float64 {
+		if v == nil {
+			return nil
+		}
+		return ptr(round(*v * factor))
+	}
+	return Bounds{Low: scale(b.Low), High: scale(b.High)}
+}
+
+// Range is the reference range for one sex and age band.
+type Range struct {
+	// Sex is Unknown for ranges that apply to everyone.
+	Sex Sex `json:"sex,omitempty"`
+	// MinAge is inclusive, MaxAge exclusive and 0 for no upper limit.
+	MinAge int `json:"min_age"`
+	MaxAge int `json:"max_age,omitempty"`
+	Bounds
+}
+
+func (r Range) covers(age int) bool {
+	return age >= r.MinAge && (r.MaxAge == 0 || age < r.MaxAge)
+}
+
+// Analyte is a catalogued test.
+type Analyte struct {
+	Code string `json:"code"`
+	Name string `json:"name"`
+	// Unit is the unit results are stored in.
+	Unit string `json:"unit"`
+	// Units maps other accepted units to the factor converting them to Unit.
+	Units    map[string]float64 `json:"units,omitempty"`
+	Ranges   []Range            `json:"ranges"`
+	Critical Bounds             `json:"critical"`
+}
+
+// factor returns the factor converting unit into the analyte's unit.
+func (a Analyte) factor(unit string) (float64, bool) {
+	if unit == "" || strings.EqualFold(unit, a.Unit) {
+		return 1, true
+	}
+	for name, factor := range a.Units {
+		if strings.EqualFold(name, unit) {
+			return factor, true
+		}
+	}
+	return 0, false
+}
+
+// ReferenceRange picks the range for a patient. A sex-specific range wins
+// over a general one; when the sex is unknown and only sex-specific ranges
+// exist the widest combination of them is used.
+func (a Analyte) ReferenceRange(sex Sex, age int) (Bounds, bool) {
+	var general, specific []Range
+	for _, r := range a.Ranges {
+		if !r.covers(age) {
+			continue
+		}
+		switch r.Sex {
+		case Unknown:
+			general = append(general, r)
+		case sex:
+			return r.Bounds, true
+		default:
+			specific = append(specific, r)
+		}
+	}
+	if len(general) > 0 {
+		return general[0].Bounds, true
+	}
+	if sex != Unknown || len(specific) == 0 {
+		return Bounds{}, false
+	}
+
+	widest := specific[0].Bounds
+	for _, r := range specific[1:] {
+		if widest.Low != nil && (r.Low == nil || *r.Low < *widest.Low) {
+			widest.Low = r.Low
+		}
+		if widest.High != nil && (r.High == nil || *r.High > *widest.High) {
+			widest.High = r.High
+		}
+	}
+	return widest, true
+}
+
+// Lookup finds a catalogued analyte by code, ignoring case.
+func Lookup(code string) (Analyte, bool) {
+	a, ok := catalog[strings.ToLower(strings.TrimSpace(code))]
+	return a, ok
+}
+
+// Catalog lists the catalogued analytes by code.
+func Catalog() []Analyte {
+	out := make([]Analyte, 0, len(catalog))
+	for _, a := range catalog {
+		out = append(out, a)
+	}
+	slices.SortFunc(out, func(a, b Analyte) int { return strings.Compare(a.Code, b.Code) })
+	return out
+}
+
+// Flag classifies value against a reference range and critical limits,
+// critical first. It returns "" when there is no reference range.
+func Flag(value float64, ref, critical Bounds) models.LabFlag {
+	switch {
+	case critical.Low != nil && value < *critical.Low:
+		return models.LabCriticalLow
+	case critical.High != nil && value > *critical.High:
+		return models.LabCriticalHigh
+	case ref.empty():
+		return ""
+	case ref.Low != nil && value < *ref.Low:
+		return models.LabLow
+	case ref.High != nil && value > *ref.High:
+		return models.LabHigh
+	}
+	return models.LabNormal
+}
+
+// Interpret completes a reported result for a patient: it converts a
+// catalogued analyte to the catalog unit, fills in the name and the
+// reference range the patient's sex and age call for unless the report
+// gave one, and sets the flag. unit is the unit the value was reported in.
+func Interpret(result *models.LabResult, unit string, patient models.PatientProfile) error {
+	result.Analyte = strings.ToLower(strings.TrimSpace(result.Analyte))
+	if result.Analyte == "" {
+		return apperrors.New(apperrors.CodeValidation, "analyte is required")
+	}
+	result.Name = strings.TrimSpace(result.Name)
+	reported := Bounds{Low: result.RefLow, High: result.RefHigh}
+	if reported.Low != nil && reported.High != nil && *reported.Low > *reported.High {
+		return apperrors.New(apperrors.CodeValidation, "ref_low must not be above ref_high")
+	}
+
+	analyte, ok := Lookup(result.Analyte)
+	if !ok {
+		unit = strings.TrimSpace(unit)
+		if unit == "" {
+			return apperrors.New(apperrors.CodeValidation, fmt.Sprintf("unit is required for %s, which is not in the catalog", result.Analyte))
+		}
+		if result.Name == "" {
+			result.Name = result.Analyte
+		}
+		result.Unit = unit
+		result.Flag = Flag(result.Value, reported, Bounds{})
+		return nil
+	}
+
+	factor, ok := analyte.factor(unit)
+	if !ok {
+		units := []string{analyte.Unit}
+		for name := range analyte.Units {
+			units = append(units, name)
+		}
+		slices.Sort(units[1:])
+		return apperrors.New(apperrors.CodeValidation, fmt.Sprintf("unit for %s must be one of: %s", analyte.Code, strings.Join(units, ", ")))
+	}
+
+	result.Value = round(result.Value * factor)
+	result.Unit = analyte.Unit
+	if result.Name == "" {
+		result.Name = analyte.Name
+	}
+
+	ref := reported.scaled(factor)
+	if ref.empty() {
+		ref, _ = analyte.ReferenceRange(ParseSex(patient.Gender), AgeAt(patient.DateOfBirth, result.CollectedAt))
+	}
+	result.RefLow, result.RefHigh = ref.Low, ref.High
+	result.Flag = Flag(result.Value, ref, analyte.Critical)
+	return nil
+}
+
+func ptr(v float64) *float64 { return &v }
+
+func round(v float64) float64 { return math.Round(v*10000) / 10000 }
+
+// Direction describes how an analyte moved between its last two results.
+type Direction string
+
+const (
+	Rising  Direction = "rising"
+	Falling Direction = "falling"
+	Stable  Direction = "stable"
+)
+
+// StableChange is the relative change between two results below which an
+// analyte counts as stable.
+const StableChange = 0.05
+
+// Trend compares the last two of results, which are ordered oldest first.
+// It returns the change from the previous result and its direction, and
+// false when there are fewer than two results.
+func Trend(results []models.LabResult) (float64, Direction, bool) {
+	if len(results) < 2 {
+		return 0, "", false
+	}
+	prev, last := results[len(results)-2].Value, results[len(results)-1].Value
+	change := round(last - prev)
+	switch {
+	case math.Abs(change) <= math.Abs(prev)*StableChange:
+		return change, Stable, true
+	case change > 0:
+		return change, Rising, true
+	}
+	return change, Falling, true
+}
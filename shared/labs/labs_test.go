@@ -0,0 +1,140 @@
+package labs
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"health-bar/shared/apperrors"
+	"health-bar/shared/models"
+)
+
+func profile(gender string, dob time.Time) models.PatientProfile {
+	return models.PatientProfile{Gender: gender, DateOfBirth: dob}
+}
+
+func TestAgeAt(t *testing.T) {
+	dob := time.Date(1990, 6, 15, 0, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		at   time.Time
+		want int
+	}{
+		{time.Date(2024, 6, 14, 0, 0, 0, 0, time.UTC), 33},
+		{time.Date(2024, 6, 15, 0, 0, 0, 0, time.UTC), 34},
+		{time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC), 0},
+	} {
+		if got := AgeAt(dob, tc.at); got != tc.want {
+			t.Errorf("AgeAt(%s) = %d, want %d", tc.at.Format("2006-01-02"), got, tc.want)
+		}
+	}
+}
+
+func TestReferenceRangeBySexAndAge(t *testing.T) {
+	hb, _ := Lookup("Hemoglobin")
+	for _, tc := range []struct {
+		sex       Sex
+		age       int
+		low, high float64
+	}{
+		{Male, 40, 13.5, 17.5},
+		{Female, 40, 12, 15.5},
+		{Female, 10, 11, 15.5},
+		// Unknown sex gets the widest adult range
+		{Unknown, 40, 12, 17.5},
+	} {
+		ref, ok := hb.ReferenceRange(tc.sex, tc.age)
+		if !ok || *ref.Low != tc.low || *ref.High != tc.high {
+			t.Errorf("range(%q, %d) = %+v, want %v-%v", tc.sex, tc.age, ref, tc.low, tc.high)
+		}
+	}
+	if _, ok := hb.ReferenceRange(Male, 0); ok {
+		t.Error("infants have no hemoglobin range in the catalog")
+	}
+}
+
+func TestInterpret(t *testing.T) {
+	collected := time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+	adult := time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, tc := range []struct {
+		name    string
+		result  models.LabResult
+		unit    string
+		patient models.PatientProfile
+		value   float64
+		flag    models.LabFlag
+	}{
+		{"male range", models.LabResult{Analyte: "hemoglobin", Value: 13}, "", profile("Male", adult), 13, models.LabLow},
+		{"female range", models.LabResult{Analyte: "hemoglobin", Value: 13}, "", profile("female", adult), 13, models.LabNormal},
+		{"converted", models.LabResult{Analyte: "HEMOGLOBIN", Value: 160}, "g/L", profile("m", adult), 16, models.LabNormal},
+		{"critical", models.LabResult{Analyte: "potassium", Value: 7}, "mEq/L", profile("", adult), 7, models.LabCriticalHigh},
+		{"high", models.LabResult{Analyte: "glucose", Value: 7}, "mmol/L", profile("", adult), 126.112, models.LabHigh},
+		// The range on the report wins over the catalog's
+		{"reported range", models.LabResult{Analyte: "glucose", Value: 105, RefLow: ptr(70), RefHigh: ptr(110)}, "", profile("", adult), 105, models.LabNormal},
+		{"uncatalogued", models.LabResult{Analyte: "ferritin", Value: 8, RefLow: ptr(15), RefHigh: ptr(150)}, "ng/mL", profile("", adult), 8, models.LabLow},
+		{"no range", models.LabResult{Analyte: "ferritin", Value: 8}, "ng/mL", profile("", adult), 8, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			res := tc.result
+			res.CollectedAt = collected
+			if err := Interpret(&res, tc.unit, tc.patient); err != nil {
+				t.Fatalf("Interpret: %v", err)
+			}
+			if res.Value != tc.value || res.Flag != tc.flag {
+				t.Fatalf("value %v flag %q, want %v %q", res.Value, res.Flag, tc.value, tc.flag)
+			}
+			if res.Name == "" || res.Unit == "" {
+				t.Fatalf("name %q unit %q", res.Name, res.Unit)
+			}
+		})
+	}
+}
+
+func TestInterpretRejects(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		result models.LabResult
+		unit   string
+	}{
+		{"no analyte", models.LabResult{Value: 1}, ""},
+		{"wrong unit", models.LabResult{Analyte: "glucose", Value: 5}, "g/L"},
+		{"uncatalogued without unit", models.LabResult{Analyte: "ferritin", Value: 5}, ""},
+		{"inverted range", models.LabResult{Analyte: "ferritin", Value: 5, RefLow: ptr(10), RefHigh: ptr(1)}, "ng/mL"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			res := tc.result
+			err := Interpret(&res, tc.unit, models.PatientProfile{})
+			var appErr *apperrors.Error
+			if !errors.As(err, &appErr) || appErr.Code != apperrors.CodeValidation {
+				t.Fatalf("err = %v", err)
+			}
+		})
+	}
+}
+
+func TestTrend(t *testing.T) {
+	series := func(values ...float64) []models.LabResult {
+		out := make([]models.LabResult, len(values))
+		for i, v := range values {
+			out[i].Value = v
+		}
+		return out
+	}
+	for _, tc := range []struct {
+		values    []float64
+		change    float64
+		direction Direction
+	}{
+		{[]float64{5, 100, 110}, 10, Rising},
+		{[]float64{110, 100}, -10, Falling},
+		{[]float64{100, 104}, 4, Stable},
+	} {
+		change, direction, ok := Trend(series(tc.values...))
+		if !ok || change != tc.change || direction != tc.direction {
+			t.Errorf("Trend(%v) = %v %q, want %v %q", tc.values, change, direction, tc.change, tc.direction)
+		}
+	}
+	if _, _, ok := Trend(series(1)); ok {
+		t.Error("one result has no trend")
+	}
+}
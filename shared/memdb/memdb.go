@@ -0,0 +1,602 @@
+// Package memdb is an in-memory stand-in for the shared Postgres schema. It
+// backs the repository fakes used in handler tests and mirrors the
+// constraints the SQL versions rely on: unique keys, foreign keys and
+// ON DELETE CASCADE.
+package memdb
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"health-bar/shared/models"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Table holds the rows of one table keyed by primary key.
+type Table[T any] struct {
+	Rows map[string]T
+}
+
+func (t *Table[T]) snapshot() func() {
+	saved := maps.Clone(t.Rows)
+	return func() { t.Rows = saved }
+}
+
+type snapshotter interface {
+	snapshot() func()
+}
+
+// DB is a set of tables guarded by a single mutex. Fakes hold the lock for
+// the duration of each statement-like operation.
+type DB struct {
+	sync.Mutex
+	txMu sync.Mutex
+
+	Users             *Table[models.User]
+	PatientProfiles   *Table[models.PatientProfile]
+	DoctorProfiles    *Table[models.DoctorProfile]
+	HospitalVisits    *Table[models.HospitalVisit]
+	Prescriptions     *Table[models.Prescription]
+	AccessPermissions *Table[models.DoctorAccessPermission]
+	Allergies         *Table[models.Allergy]
+	Conditions        *Table[models.Condition]
+	Medications       *Table[models.Medication]
+	EmergencyContacts *Table[models.EmergencyContact]
+	// Biometrics is keyed by patient ID.
+	Biometrics    *Table[models.Biometrics]
+	VitalReadings *Table[models.VitalReading]
+
+	SelfAssessments *Table[models.SelfAssessment]
+	HealthScores    *Table[models.HealthScore]
+
+	LabPanels  *Table[models.LabPanel]
+	LabResults *Table[models.LabResult]
+
+	Immunizations *Table[models.Immunization]
+	// ImmunizationReminders is keyed by patient, vaccine, dose and status.
+	ImmunizationReminders *Table[models.ImmunizationReminder]
+
+	EPrescriptions *Table[models.EPrescription]
+
+	MedicationSchedules *Table[models.MedicationSchedule]
+	DoseEvents          *Table[models.DoseEvent]
+
+	// DoctorCalendars is keyed by doctor ID.
+	DoctorCalendars        *Table[models.DoctorCalendar]
+	AvailabilityExceptions *Table[models.AvailabilityException]
+	Appointments           *Table[models.Appointment]
+
+	MessageThreads     *Table[models.MessageThread]
+	Messages           *Table[models.Message]
+	MessageAttachments *Table[models.MessageAttachment]
+
+	Notifications *Table[models.Notification]
+
+	WebhookSubscriptions *Table[models.WebhookSubscription]
+	WebhookDeliveries    *Table[models.WebhookDelivery]
+
+	OutboxEvents *Table[models.OutboxEvent]
+	// EventConsumers is keyed by consumer and topic, EventInbox by
+	// consumer and event ID.
+	EventConsumers *Table[models.EventConsumer]
+	EventInbox     *Table[models.InboxEvent]
+
+	Uploads      *Table[models.Upload]
+	UploadChunks *Table[models.UploadChunk]
+
+	// Now returns the timestamp used for DEFAULT CURRENT_TIMESTAMP columns.
+	Now func() time.Time
+
+	tables   []snapshotter
+	cascades map[string][]func(id string)
+}
+
+func New() *DB {
+	db := &DB{
+		Now:      func() time.Time { return time.Now().UTC() },
+		cascades: map[string][]func(id string){},
+	}
+	db.Users = NewTable[models.User](db)
+	db.PatientProfiles = NewTable[models.PatientProfile](db)
+	db.DoctorProfiles = NewTable[models.DoctorProfile](db)
+	db.HospitalVisits = NewTable[models.HospitalVisit](db)
+	db.Prescriptions = NewTable[models.Prescription](db)
+	db.AccessPermissions = NewTable[models.DoctorAccessPermission](db)
+	db.Allergies = NewTable[models.Allergy](db)
+	db.Conditions = NewTable[models.Condition](db)
+	db.Medications = NewTable[models.Medication](db)
+	db.EmergencyContacts = NewTable[models.EmergencyContact](db)
+	db.Biometrics = NewTable[models.Biometrics](db)
+	db.VitalReadings = NewTable[models.VitalReading](db)
+	db.SelfAssessments = NewTable[models.SelfAssessment](db)
+	db.HealthScores = NewTable[models.HealthScore](db)
+	db.LabPanels = NewTable[models.LabPanel](db)
+	db.LabResults = NewTable[models.LabResult](db)
+	db.Immunizations = NewTable[models.Immunization](db)
+	db.ImmunizationReminders = NewTable[models.ImmunizationReminder](db)
+	db.EPrescriptions = NewTable[models.EPrescription](db)
+	db.MedicationSchedules = NewTable[models.MedicationSchedule](db)
+	db.DoseEvents = NewTable[models.DoseEvent](db)
+	db.DoctorCalendars = NewTable[models.DoctorCalendar](db)
+	db.AvailabilityExceptions = NewTable[models.AvailabilityException](db)
+	db.Appointments = NewTable[models.Appointment](db)
+	db.MessageThreads = NewTable[models.MessageThread](db)
+	db.Messages = NewTable[models.Message](db)
+	db.MessageAttachments = NewTable[models.MessageAttachment](db)
+	db.Notifications = NewTable[models.Notification](db)
+	db.WebhookSubscriptions = NewTable[models.WebhookSubscription](db)
+	db.WebhookDeliveries = NewTable[models.WebhookDelivery](db)
+	db.OutboxEvents = NewTable[models.OutboxEvent](db)
+	db.EventConsumers = NewTable[models.EventConsumer](db)
+	db.EventInbox = NewTable[models.InboxEvent](db)
+	db.Uploads = NewTable[models.Upload](db)
+	db.UploadChunks = NewTable[models.UploadChunk](db)
+
+	// ON DELETE CASCADE relationships from the schema
+	db.OnDelete("users", func(userID string) {
+		for id, p := range db.PatientProfiles.Rows {
+			if p.UserID == userID {
+				db.DeletePatientProfile(id)
+			}
+		}
+		for id, d := range db.DoctorProfiles.Rows {
+			if d.UserID == userID {
+				db.DeleteDoctorProfile(id)
+			}
+		}
+		deleteWhere(db.Notifications, func(n models.Notification) bool { return n.UserID == userID })
+		for id, sub := range db.WebhookSubscriptions.Rows {
+			if sub.UserID == userID {
+				Delete(db, "webhook_subscriptions", db.WebhookSubscriptions, id)
+			}
+		}
+	})
+	db.OnDelete("webhook_subscriptions", func(subscriptionID string) {
+		deleteWhere(db.WebhookDeliveries, func(d models.WebhookDelivery) bool { return d.SubscriptionID == subscriptionID })
+	})
+	db.OnDelete("patient_profiles", func(patientID string) {
+		deleteWhere(db.HospitalVisits, func(v models.HospitalVisit) bool { return v.PatientID == patientID })
+		deleteWhere(db.Prescriptions, func(p models.Prescription) bool { return p.PatientID == patientID })
+		deleteWhere(db.AccessPermissions, func(p models.DoctorAccessPermission) bool { return p.PatientID == patientID })
+		deleteWhere(db.Allergies, func(a models.Allergy) bool { return a.PatientID == patientID })
+		deleteWhere(db.Conditions, func(c models.Condition) bool { return c.PatientID == patientID })
+		deleteWhere(db.Medications, func(m models.Medication) bool { return m.PatientID == patientID })
+		deleteWhere(db.EmergencyContacts, func(c models.EmergencyContact) bool { return c.PatientID == patientID })
+		delete(db.Biometrics.Rows, patientID)
+		deleteWhere(db.VitalReadings, func(v models.VitalReading) bool { return v.PatientID == patientID })
+		deleteWhere(db.SelfAssessments, func(a models.SelfAssessment) bool { return a.PatientID == patientID })
+		deleteWhere(db.HealthScores, func(s models.HealthScore) bool { return s.PatientID == patientID })
+		deleteWhere(db.LabPanels, func(p models.LabPanel) bool { return p.PatientID == patientID })
+		deleteWhere(db.LabResults, func(r models.LabResult) bool { return r.PatientID == patientID })
+		deleteWhere(db.Immunizations, func(i models.Immunization) bool { return i.PatientID == patientID })
+		deleteWhere(db.ImmunizationReminders, func(r models.ImmunizationReminder) bool { return r.PatientID == patientID })
+		deleteWhere(db.EPrescriptions, func(p models.EPrescription) bool { return p.PatientID == patientID })
+		deleteWhere(db.MedicationSchedules, func(s models.MedicationSchedule) bool { return s.PatientID == patientID })
+		deleteWhere(db.DoseEvents, func(e models.DoseEvent) bool { return e.PatientID == patientID })
+		deleteWhere(db.Appointments, func(a models.Appointment) bool { return a.PatientID == patientID })
+		for id, t := range db.MessageThreads.Rows {
+			if t.PatientID == patientID {
+				Delete(db, "message_threads", db.MessageThreads, id)
+			}
+		}
+		for id, u := range db.Uploads.Rows {
+			if u.PatientID == patientID {
+				Delete(db, "uploads", db.Uploads, id)
+			}
+		}
+	})
+	db.OnDelete("uploads", func(uploadID string) {
+		deleteWhere(db.UploadChunks, func(c models.UploadChunk) bool { return c.UploadID == uploadID })
+	})
+	db.OnDelete("prescriptions", func(prescriptionID string) {
+		for id, u := range db.Uploads.Rows {
+			if u.PrescriptionID != nil && *u.PrescriptionID == prescriptionID {
+				Delete(db, "uploads", db.Uploads, id)
+			}
+		}
+	})
+	db.OnDelete("lab_panels", func(panelID string) {
+		deleteWhere(db.LabResults, func(r models.LabResult) bool { return r.PanelID == panelID })
+	})
+	db.OnDelete("message_threads", func(threadID string) {
+		for id, m := range db.Messages.Rows {
+			if m.ThreadID == threadID {
+				Delete(db, "messages", db.Messages, id)
+			}
+		}
+	})
+	db.OnDelete("messages", func(messageID string) {
+		deleteWhere(db.MessageAttachments, func(a models.MessageAttachment) bool { return a.MessageID == messageID })
+	})
+	db.OnDelete("medication_schedules", func(scheduleID string) {
+		deleteWhere(db.DoseEvents, func(e models.DoseEvent) bool { return e.ScheduleID == scheduleID })
+	})
+	// ON DELETE SET NULL
+	db.OnDelete("hospital_visits", func(visitID string) {
+		for id, p := range db.LabPanels.Rows {
+			if p.VisitID != nil && *p.VisitID == visitID {
+				p.VisitID = nil
+				db.LabPanels.Rows[id] = p
+			}
+		}
+		for id, a := range db.Appointments.Rows {
+			if a.VisitID != nil && *a.VisitID == visitID {
+				a.VisitID = nil
+				db.Appointments.Rows[id] = a
+			}
+		}
+	})
+	db.OnDelete("prescriptions", func(documentID string) {
+		for id, p := range db.LabPanels.Rows {
+			if p.DocumentID != nil && *p.DocumentID == documentID {
+				p.DocumentID = nil
+				db.LabPanels.Rows[id] = p
+			}
+		}
+		for id, p := range db.EPrescriptions.Rows {
+			if p.DocumentID != nil && *p.DocumentID == documentID {
+				p.DocumentID = nil
+				db.EPrescriptions.Rows[id] = p
+			}
+		}
+	})
+	db.OnDelete("doctor_profiles", func(doctorID string) {
+		deleteWhere(db.AccessPermissions, func(p models.DoctorAccessPermission) bool { return p.DoctorID == doctorID })
+		delete(db.DoctorCalendars.Rows, doctorID)
+		deleteWhere(db.AvailabilityExceptions, func(e models.AvailabilityException) bool { return e.DoctorID == doctorID })
+		deleteWhere(db.Appointments, func(a models.Appointment) bool { return a.DoctorID == doctorID })
+		for id, t := range db.MessageThreads.Rows {
+			if t.DoctorID == doctorID {
+				Delete(db, "message_threads", db.MessageThreads, id)
+			}
+		}
+		for id, p := range db.EPrescriptions.Rows {
+			if p.DoctorID != nil && *p.DoctorID == doctorID {
+				p.DoctorID = nil
+				db.EPrescriptions.Rows[id] = p
+			}
+		}
+	})
+
+	return db
+}
+
+// NewTable registers an additional table so it takes part in transaction
+// rollback. Fakes for newer subsystems use it to add their own tables.
+func NewTable[T any](db *DB) *Table[T] {
+	t := &Table[T]{Rows: map[string]T{}}
+	db.tables = append(db.tables, t)
+	return t
+}
+
+// OnDelete registers a cascade that runs, with the lock held, whenever a row
+// of the named table is deleted through Delete.
+func (db *DB) OnDelete(table string, fn func(id string)) {
+	db.cascades[table] = append(db.cascades[table], fn)
+}
+
+// Delete removes a row and runs the cascades registered for its table. The
+// caller must hold the lock.
+func Delete[T any](db *DB, name string, t *Table[T], id string) bool {
+	if _, ok := t.Rows[id]; !ok {
+		return false
+	}
+	delete(t.Rows, id)
+	for _, fn := range db.cascades[name] {
+		fn(id)
+	}
+	return true
+}
+
+// DeleteUser deletes a user and everything that references it.
+func (db *DB) DeleteUser(id string) bool {
+	return Delete(db, "users", db.Users, id)
+}
+
+// DeletePatientProfile deletes a patient profile and its dependent rows.
+func (db *DB) DeletePatientProfile(id string) bool {
+	return Delete(db, "patient_profiles", db.PatientProfiles, id)
+}
+
+// DeleteDoctorProfile deletes a doctor profile and its dependent rows.
+func (db *DB) DeleteDoctorProfile(id string) bool {
+	return Delete(db, "doctor_profiles", db.DoctorProfiles, id)
+}
+
+func deleteWhere[T any](t *Table[T], match func(T) bool) {
+	for id, row := range t.Rows {
+		if match(row) {
+			delete(t.Rows, id)
+		}
+	}
+}
+
+type txKey struct{}
+
+// WithTx gives fn all-or-nothing semantics: transactions are serialized and
+// every registered table is restored if fn returns an error.
+func (db *DB) WithTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	if ctx.Value(txKey{}) != nil {
+		return fn(ctx)
+	}
+
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	db.Lock()
+	restores := make([]func(), len(db.tables))
+	for i, t := range db.tables {
+		restores[i] = t.snapshot()
+	}
+	db.Unlock()
+
+	if err := fn(context.WithValue(ctx, txKey{}, true)); err != nil {
+		db.Lock()
+		for _, restore := range restores {
+			restore()
+		}
+		db.Unlock()
+		return err
+	}
+	return nil
+}
+
+// UniqueViolation builds the error Postgres returns for a duplicate key.
+func UniqueViolation(constraint string) error {
+	return &pgconn.PgError{
+		Severity:       "ERROR",
+		Code:           "23505",
+		Message:        fmt.Sprintf("duplicate key value violates unique constraint %q", constraint),
+		ConstraintName: constraint,
+	}
+}
+
+// ForeignKeyViolation builds the error Postgres returns for a missing
+// referenced row.
+func ForeignKeyViolation(table, constraint string) error {
+	return &pgconn.PgError{
+		Severity:       "ERROR",
+		Code:           "23503",
+		Message:        fmt.Sprintf("insert or update on table %q violates foreign key constraint %q", table, constraint),
+		TableName:      table,
+		ConstraintName: constraint,
+	}
+}
+
+// ExclusionViolation builds the error Postgres returns when a row conflicts
+// with another under an exclusion constraint.
+func ExclusionViolation(table, constraint string) error {
+	return &pgconn.PgError{
+		Severity:       "ERROR",
+		Code:           "23P01",
+		Message:        fmt.Sprintf("conflicting key value violates exclusion constraint %q", constraint),
+		TableName:      table,
+		ConstraintName: constraint,
+	}
+}
+
+// AddUser seeds a user row and returns it.
+func (db *DB) AddUser(email string, role models.UserRole) models.User {
+	db.Lock()
+	defer db.Unlock()
+
+	now := db.Now()
+	user := models.User{ID: uuid.New().String(), Email: email, Role: role, CreatedAt: now, UpdatedAt: now}
+	db.Users.Rows[user.ID] = user
+	return user
+}
+
+// AddPatient seeds a patient user with a profile and returns the profile.
+func (db *DB) AddPatient(email, fullName string) models.PatientProfile {
+	user := db.AddUser(email, models.RolePatient)
+
+	db.Lock()
+	defer db.Unlock()
+
+	now := db.Now()
+	profile := models.PatientProfile{
+		ID:          uuid.New().String(),
+		UserID:      user.ID,
+		FullName:    fullName,
+		DateOfBirth: time.Date(1990, 1, 1, 0, 0, 0, 0, time.UTC),
+		CreatedAt:   now,
+		UpdatedAt:   now,
+		Version:     1,
+	}
+	db.PatientProfiles.Rows[profile.ID] = profile
+	return profile
+}
+
+// AddDoctor seeds a doctor user with a profile and returns the profile.
+func (db *DB) AddDoctor(email, fullName string) models.DoctorProfile {
+	user := db.AddUser(email, models.RoleDoctor)
+
+	db.Lock()
+	defer db.Unlock()
+
+	now := db.Now()
+	profile := models.DoctorProfile{ID: uuid.New().String(), UserID: user.ID, FullName: fullName, CreatedAt: now, UpdatedAt: now, Version: 1}
+	db.DoctorProfiles.Rows[profile.ID] = profile
+	return profile
+}
+
+// AddVisit seeds a hospital visit and returns it.
+func (db *DB) AddVisit(patientID string, date time.Time, reason string) models.HospitalVisit {
+	db.Lock()
+	defer db.Unlock()
+
+	now := db.Now()
+	visit := models.HospitalVisit{
+		ID:           uuid.New().String(),
+		PatientID:    patientID,
+		HospitalName: "General",
+		VisitDate:    date,
+		Reason:       reason,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+		Version:      1,
+	}
+	db.HospitalVisits.Rows[visit.ID] = visit
+	return visit
+}
+
+// AddWebhookSubscription seeds a user's subscription to eventTypes and
+// returns it.
+func (db *DB) AddWebhookSubscription(userID, url string, eventTypes ...string) models.WebhookSubscription {
+	db.Lock()
+	defer db.Unlock()
+
+	sub := models.WebhookSubscription{
+		ID:         uuid.New().String(),
+		UserID:     userID,
+		URL:        url,
+		Secret:     "whsec_test",
+		EventTypes: eventTypes,
+		CreatedAt:  db.Now(),
+	}
+	db.WebhookSubscriptions.Rows[sub.ID] = sub
+	return sub
+}
+
+// Grant seeds an active access permission.
+func (db *DB) Grant(patientID, doctorID string) {
+	db.Lock()
+	defer db.Unlock()
+
+	db.UpsertPermission(patientID, doctorID)
+}
+
+// UpsertPermission mirrors GrantAccess' INSERT ... ON CONFLICT DO UPDATE. The
+// caller must hold the lock.
+func (db *DB) UpsertPermission(patientID, doctorID string) error {
+	if _, ok := db.PatientProfiles.Rows[patientID]; !ok {
+		return ForeignKeyViolation("doctor_access_permissions", "doctor_access_permissions_patient_id_fkey")
+	}
+	if _, ok := db.DoctorProfiles.Rows[doctorID]; !ok {
+		return ForeignKeyViolation("doctor_access_permissions", "doctor_access_permissions_doctor_id_fkey")
+	}
+
+	now := db.Now()
+	for id, p := range db.AccessPermissions.Rows {
+		if p.PatientID == patientID && p.DoctorID == doctorID {
+			p.IsActive, p.RevokedAt, p.GrantedAt = true, nil, now
+			db.AccessPermissions.Rows[id] = p
+			return nil
+		}
+	}
+
+	p := models.DoctorAccessPermission{ID: uuid.New().String(), PatientID: patientID, DoctorID: doctorID, GrantedAt: now, IsActive: true}
+	db.AccessPermissions.Rows[p.ID] = p
+	return nil
+}
+
+// Permission returns the permission row for a patient/doctor pair. The caller
+// must hold the lock.
+func (db *DB) Permission(patientID, doctorID string) (models.DoctorAccessPermission, bool) {
+	for _, p := range db.AccessPermissions.Rows {
+		if p.PatientID == patientID && p.DoctorID == doctorID {
+			return p, true
+		}
+	}
+	return models.DoctorAccessPermission{}, false
+}
+
+// PatientByUserID finds a patient profile by user ID. The caller must hold
+// the lock.
+func (db *DB) PatientByUserID(userID string) (models.PatientProfile, bool) {
+	for _, p := range db.PatientProfiles.Rows {
+		if p.UserID == userID {
+			return p, true
+		}
+	}
+	return models.PatientProfile{}, false
+}
+
+// DoctorByUserID finds a doctor profile by user ID. The caller must hold the
+// lock.
+func (db *DB) DoctorByUserID(userID string) (models.DoctorProfile, bool) {
+	for _, d := range db.DoctorProfiles.Rows {
+		if d.UserID == userID {
+			return d, true
+		}
+	}
+	return models.DoctorProfile{}, false
+}
+
+// DoctorHasAccess mirrors the CheckDoctorAccess join on doctor_profiles. The
+// caller must hold the lock.
+func (db *DB) DoctorHasAccess(doctorUserID, patientID string) bool {
+	doctor, ok := db.DoctorByUserID(doctorUserID)
+	if !ok {
+		return false
+	}
+	p, ok := db.Permission(patientID, doctor.ID)
+	return ok && p.IsActive
+}
+
+// DoctorUserIDsWithAccess lists the user IDs of the doctors a patient has
+// granted active access, sorted. The caller must hold the lock.
+func (db *DB) DoctorUserIDsWithAccess(patientID string) []string {
+	var userIDs []string
+	for _, p := range db.AccessPermissions.Rows {
+		if p.PatientID != patientID || !p.IsActive {
+			continue
+		}
+		if doctor, ok := db.DoctorProfiles.Rows[p.DoctorID]; ok {
+			userIDs = append(userIDs, doctor.UserID)
+		}
+	}
+	slices.Sort(userIDs)
+	return userIDs
+}
+
+// ClinicalRecord collects a patient's clinical rows in the order the SQL
+// repositories return them. The caller must hold the lock.
+func (db *DB) ClinicalRecord(patientID string) models.ClinicalRecord {
+	record := models.ClinicalRecord{
+		Allergies:         rowsWhere(db.Allergies, func(a models.Allergy) bool { return a.PatientID == patientID }),
+		Conditions:        rowsWhere(db.Conditions, func(c models.Condition) bool { return c.PatientID == patientID }),
+		Medications:       rowsWhere(db.Medications, func(m models.Medication) bool { return m.PatientID == patientID }),
+		EmergencyContacts: rowsWhere(db.EmergencyContacts, func(c models.EmergencyContact) bool { return c.PatientID == patientID }),
+	}
+	slices.SortFunc(record.Allergies, func(a, b models.Allergy) int {
+		return strings.Compare(strings.ToLower(a.Substance), strings.ToLower(b.Substance))
+	})
+	slices.SortFunc(record.Conditions, func(a, b models.Condition) int {
+		return cmp.Or(a.CreatedAt.Compare(b.CreatedAt), strings.Compare(a.ID, b.ID))
+	})
+	slices.SortFunc(record.Medications, func(a, b models.Medication) int {
+		return cmp.Or(strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name)), strings.Compare(a.ID, b.ID))
+	})
+	slices.SortFunc(record.EmergencyContacts, func(a, b models.EmergencyContact) int {
+		if a.IsPrimary != b.IsPrimary {
+			if a.IsPrimary {
+				return -1
+			}
+			return 1
+		}
+		return cmp.Or(a.CreatedAt.Compare(b.CreatedAt), strings.Compare(a.ID, b.ID))
+	})
+	if biometrics, ok := db.Biometrics.Rows[patientID]; ok {
+		record.Biometrics = &biometrics
+	}
+	return record
+}
+
+func rowsWhere[T any](t *Table[T], match func(T) bool) []T {
+	rows := []T{}
+	for _, row := range t.Rows {
+		if match(row) {
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
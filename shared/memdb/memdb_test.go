@@ -0,0 +1,50 @@
+package memdb
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestWithTxRollsBackOnError(t *testing.T) {
+	db := New()
+	kept := db.AddPatient("kept@test.com", "Kept")
+
+	boom := errors.New("boom")
+	err := db.WithTx(context.Background(), func(ctx context.Context) error {
+		db.AddPatient("discarded@test.com", "Discarded")
+
+		db.Lock()
+		db.DeletePatientProfile(kept.ID)
+		db.Unlock()
+		return boom
+	})
+	if err != boom {
+		t.Fatalf("WithTx error = %v, want %v", err, boom)
+	}
+
+	if len(db.PatientProfiles.Rows) != 1 || len(db.Users.Rows) != 1 {
+		t.Fatalf("rollback left %d profiles and %d users", len(db.PatientProfiles.Rows), len(db.Users.Rows))
+	}
+	if _, ok := db.PatientProfiles.Rows[kept.ID]; !ok {
+		t.Fatalf("deleted profile was not restored")
+	}
+}
+
+func TestDeleteCascades(t *testing.T) {
+	db := New()
+	patient := db.AddPatient("p@test.com", "Pat")
+	doctor := db.AddDoctor("d@test.com", "Dr Who")
+	db.Grant(patient.ID, doctor.ID)
+
+	db.Lock()
+	db.DeleteUser(doctor.UserID)
+	db.Unlock()
+
+	if len(db.DoctorProfiles.Rows) != 0 || len(db.AccessPermissions.Rows) != 0 {
+		t.Fatalf("deleting the doctor user did not cascade")
+	}
+	if len(db.PatientProfiles.Rows) != 1 {
+		t.Fatalf("patient profile removed by unrelated cascade")
+	}
+}
@@ -1,6 +1,7 @@
 package middleware
 
 import (
+    "health-bar/shared/apperrors"
     "health-bar/shared/utils"
     "net/http"
     "strings"
@@ -10,22 +11,45 @@ func AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
     return func(w http.ResponseWriter, r *http.Request) {
         authHeader := r.Header.Get("Authorization")
         if authHeader == "" {
-            http.Error(w, "Authorization header required", http.StatusUnauthorized)
+            utils.SendError(w, http.StatusUnauthorized, "Authorization header required")
             return
         }
 
         tokenString := strings.Replace(authHeader, "Bearer ", "", 1)
-        claims, err := utils.ValidateToken(tokenString)
-        if err != nil {
-            http.Error(w, "Invalid token", http.StatusUnauthorized)
+        authenticate(w, r, tokenString, next)
+    }
+}
+
+// StreamAuthMiddleware is AuthMiddleware for streaming endpoints. Browsers
+// cannot set headers on EventSource and WebSocket connections, so the token
+// may also come in the access_token query parameter.
+func StreamAuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if r.Header.Get("Authorization") != "" {
+            AuthMiddleware(next)(w, r)
             return
         }
 
-        // Add claims to request context
-        r.Header.Set("X-User-ID", claims.UserID)
-        r.Header.Set("X-User-Email", claims.Email)
-        r.Header.Set("X-User-Role", claims.Role)
+        tokenString := r.URL.Query().Get("access_token")
+        if tokenString == "" {
+            utils.SendError(w, http.StatusUnauthorized, "Authorization header or access_token required")
+            return
+        }
+        authenticate(w, r, tokenString, next)
+    }
+}
 
-        next(w, r)
+func authenticate(w http.ResponseWriter, r *http.Request, tokenString string, next http.HandlerFunc) {
+    claims, err := utils.ValidateToken(tokenString)
+    if err != nil {
+        utils.SendErrorCode(w, apperrors.CodeInvalidToken, "Invalid token")
+        return
     }
-}
\ No newline at end of file
+
+    // Add claims to request context
+    r.Header.Set("X-User-ID", claims.UserID)
+    r.Header.Set("X-User-Email", claims.Email)
+    r.Header.Set("X-User-Role", claims.Role)
+
+    next(w, r)
+}
@@ -0,0 +1,80 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// DoseTimes are the local times of day, "HH:MM", a medication is taken at.
+// They are stored as a JSONB array.
+type DoseTimes []string
+
+func (t DoseTimes) Value() (driver.Value, error) {
+	if t == nil {
+		t = DoseTimes{}
+	}
+	return json.Marshal(t)
+}
+
+func (t *DoseTimes) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case []byte:
+		return json.Unmarshal(v, t)
+	case string:
+		return json.Unmarshal([]byte(v), t)
+	case nil:
+		*t = nil
+		return nil
+	}
+	return fmt.Errorf("cannot scan %T into DoseTimes", src)
+}
+
+// MedicationSchedule is when a patient takes a medication: at Times in
+// Timezone every day from StartDate through EndDate, or indefinitely when
+// EndDate is nil. PrescriptionID optionally links an issued prescription.
+type MedicationSchedule struct {
+	ID             string     `json:"id" db:"id"`
+	PatientID      string     `json:"patient_id" db:"patient_id"`
+	PrescriptionID *string    `json:"prescription_id,omitempty" db:"prescription_id"`
+	Medication     string     `json:"medication" db:"medication"`
+	Dose           string     `json:"dose" db:"dose"`
+	Times          DoseTimes  `json:"times" db:"times"`
+	Timezone       string     `json:"timezone" db:"timezone"`
+	StartDate      time.Time  `json:"start_date" db:"start_date"`
+	EndDate        *time.Time `json:"end_date,omitempty" db:"end_date"`
+	Notes          string     `json:"notes" db:"notes"`
+	CreatedAt      time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at" db:"updated_at"`
+}
+
+type DoseStatus string
+
+const (
+	DoseTaken   DoseStatus = "taken"
+	DoseLate    DoseStatus = "late"
+	DoseSkipped DoseStatus = "skipped"
+)
+
+func (s DoseStatus) Valid() bool {
+	switch s {
+	case DoseTaken, DoseLate, DoseSkipped:
+		return true
+	}
+	return false
+}
+
+// DoseEvent records what happened to one scheduled dose. ScheduledFor is the
+// dose's time in UTC; TakenAt is nil for skipped doses.
+type DoseEvent struct {
+	ID           string     `json:"id" db:"id"`
+	ScheduleID   string     `json:"schedule_id" db:"schedule_id"`
+	PatientID    string     `json:"patient_id" db:"patient_id"`
+	ScheduledFor time.Time  `json:"scheduled_for" db:"scheduled_for"`
+	Status       DoseStatus `json:"status" db:"status"`
+	TakenAt      *time.Time `json:"taken_at,omitempty" db:"taken_at"`
+	Note         string     `json:"note" db:"note"`
+	CreatedAt    time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at" db:"updated_at"`
+}
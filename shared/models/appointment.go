@@ -0,0 +1,95 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// AvailabilityWindow is a weekly block of bookable time, Start to End local
+// to the calendar's timezone. Weekday 0 is Sunday.
+type AvailabilityWindow struct {
+	Weekday int    `json:"weekday"`
+	Start   string `json:"start"`
+	End     string `json:"end"`
+}
+
+// AvailabilityWindows are stored as a JSONB array.
+type AvailabilityWindows []AvailabilityWindow
+
+func (w AvailabilityWindows) Value() (driver.Value, error) {
+	if w == nil {
+		w = AvailabilityWindows{}
+	}
+	return json.Marshal(w)
+}
+
+func (w *AvailabilityWindows) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case []byte:
+		return json.Unmarshal(v, w)
+	case string:
+		return json.Unmarshal([]byte(v), w)
+	case nil:
+		*w = nil
+		return nil
+	}
+	return fmt.Errorf("cannot scan %T into AvailabilityWindows", src)
+}
+
+// DoctorCalendar is when a doctor can be booked: the Weekly windows cut
+// into slots of SlotMinutes. CreateVisits adds a hospital visit to the
+// patient's timeline when an appointment is completed, at Location.
+type DoctorCalendar struct {
+	DoctorID     string              `json:"doctor_id" db:"doctor_id"`
+	Timezone     string              `json:"timezone" db:"timezone"`
+	SlotMinutes  int                 `json:"slot_minutes" db:"slot_minutes"`
+	Location     string              `json:"location" db:"location"`
+	CreateVisits bool                `json:"create_visits" db:"create_visits"`
+	Weekly       AvailabilityWindows `json:"weekly" db:"weekly"`
+	CreatedAt    time.Time           `json:"created_at" db:"created_at"`
+	UpdatedAt    time.Time           `json:"updated_at" db:"updated_at"`
+}
+
+// AvailabilityException overrides the weekly windows on one date. Without
+// Start and End it covers the whole day. Available exceptions add time,
+// the others take it away.
+type AvailabilityException struct {
+	ID        string    `json:"id" db:"id"`
+	DoctorID  string    `json:"doctor_id" db:"doctor_id"`
+	Date      time.Time `json:"date" db:"date"`
+	Start     *string   `json:"start,omitempty" db:"start_time"`
+	End       *string   `json:"end,omitempty" db:"end_time"`
+	Available bool      `json:"available" db:"available"`
+	Reason    string    `json:"reason" db:"reason"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+type AppointmentStatus string
+
+const (
+	AppointmentBooked    AppointmentStatus = "booked"
+	AppointmentCancelled AppointmentStatus = "cancelled"
+	AppointmentCompleted AppointmentStatus = "completed"
+)
+
+// Appointment is a booked slot. VisitID links the hospital visit created
+// when it was completed.
+type Appointment struct {
+	ID           string            `json:"id" db:"id"`
+	DoctorID     string            `json:"doctor_id" db:"doctor_id"`
+	PatientID    string            `json:"patient_id" db:"patient_id"`
+	StartsAt     time.Time         `json:"starts_at" db:"starts_at"`
+	EndsAt       time.Time         `json:"ends_at" db:"ends_at"`
+	Status       AppointmentStatus `json:"status" db:"status"`
+	Reason       string            `json:"reason" db:"reason"`
+	Notes        string            `json:"notes" db:"notes"`
+	VisitID      *string           `json:"visit_id,omitempty" db:"visit_id"`
+	CancelledBy  string            `json:"cancelled_by,omitempty" db:"cancelled_by"`
+	CancelReason string            `json:"cancel_reason,omitempty" db:"cancel_reason"`
+	CancelledAt  *time.Time        `json:"cancelled_at,omitempty" db:"cancelled_at"`
+	CompletedAt  *time.Time        `json:"completed_at,omitempty" db:"completed_at"`
+	CreatedAt    time.Time         `json:"created_at" db:"created_at"`
+	UpdatedAt    time.Time         `json:"updated_at" db:"updated_at"`
+}
@@ -0,0 +1,121 @@
+package models
+
+import "time"
+
+type AllergySeverity string
+
+const (
+	SeverityMild            AllergySeverity = "mild"
+	SeverityModerate        AllergySeverity = "moderate"
+	SeveritySevere          AllergySeverity = "severe"
+	SeverityLifeThreatening AllergySeverity = "life_threatening"
+)
+
+func (s AllergySeverity) Valid() bool {
+	switch s {
+	case SeverityMild, SeverityModerate, SeveritySevere, SeverityLifeThreatening:
+		return true
+	}
+	return false
+}
+
+type Allergy struct {
+	ID        string          `json:"id" db:"id"`
+	PatientID string          `json:"patient_id" db:"patient_id"`
+	Substance string          `json:"substance" db:"substance"`
+	Reaction  string          `json:"reaction" db:"reaction"`
+	Severity  AllergySeverity `json:"severity" db:"severity"`
+	CreatedAt time.Time       `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at" db:"updated_at"`
+}
+
+type ConditionStatus string
+
+const (
+	ConditionActive   ConditionStatus = "active"
+	ConditionManaged  ConditionStatus = "managed"
+	ConditionResolved ConditionStatus = "resolved"
+)
+
+func (s ConditionStatus) Valid() bool {
+	switch s {
+	case ConditionActive, ConditionManaged, ConditionResolved:
+		return true
+	}
+	return false
+}
+
+type Condition struct {
+	ID          string          `json:"id" db:"id"`
+	PatientID   string          `json:"patient_id" db:"patient_id"`
+	Name        string          `json:"name" db:"name"`
+	Status      ConditionStatus `json:"status" db:"status"`
+	DiagnosedOn *time.Time      `json:"diagnosed_on,omitempty" db:"diagnosed_on"`
+	Notes       string          `json:"notes" db:"notes"`
+	CreatedAt   time.Time       `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at" db:"updated_at"`
+}
+
+type Medication struct {
+	ID        string     `json:"id" db:"id"`
+	PatientID string     `json:"patient_id" db:"patient_id"`
+	Name      string     `json:"name" db:"name"`
+	Dosage    string     `json:"dosage" db:"dosage"`
+	Frequency string     `json:"frequency" db:"frequency"`
+	StartedOn *time.Time `json:"started_on,omitempty" db:"started_on"`
+	Notes     string     `json:"notes" db:"notes"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at" db:"updated_at"`
+}
+
+type EmergencyContact struct {
+	ID           string    `json:"id" db:"id"`
+	PatientID    string    `json:"patient_id" db:"patient_id"`
+	Name         string    `json:"name" db:"name"`
+	Relationship string    `json:"relationship" db:"relationship"`
+	Phone        string    `json:"phone" db:"phone"`
+	Email        string    `json:"email" db:"email"`
+	IsPrimary    bool      `json:"is_primary" db:"is_primary"`
+	CreatedAt    time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at" db:"updated_at"`
+}
+
+type BloodType string
+
+var BloodTypes = []BloodType{"A+", "A-", "B+", "B-", "AB+", "AB-", "O+", "O-"}
+
+func (b BloodType) Valid() bool {
+	for _, t := range BloodTypes {
+		if b == t {
+			return true
+		}
+	}
+	return false
+}
+
+// Biometrics holds the single-valued clinical facts of a patient. Every
+// field is optional; a patient has at most one row.
+type Biometrics struct {
+	PatientID string    `json:"patient_id" db:"patient_id"`
+	BloodType BloodType `json:"blood_type,omitempty" db:"blood_type"`
+	HeightCM  *float64  `json:"height_cm,omitempty" db:"height_cm"`
+	WeightKG  *float64  `json:"weight_kg,omitempty" db:"weight_kg"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// ClinicalRecord is everything a treating doctor needs besides the
+// demographics.
+type ClinicalRecord struct {
+	Allergies         []Allergy          `json:"allergies"`
+	Conditions        []Condition        `json:"conditions"`
+	Medications       []Medication       `json:"medications"`
+	EmergencyContacts []EmergencyContact `json:"emergency_contacts"`
+	Biometrics        *Biometrics        `json:"biometrics"`
+}
+
+// PatientChart is a patient profile with its clinical record, as doctors
+// with access see it.
+type PatientChart struct {
+	PatientProfile
+	ClinicalRecord
+}
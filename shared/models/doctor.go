@@ -11,4 +11,5 @@ type DoctorProfile struct {
 	Phone          string    `json:"phone" db:"phone"`
 	CreatedAt      time.Time `json:"created_at" db:"created_at"`
 	UpdatedAt      time.Time `json:"updated_at" db:"updated_at"`
+	Version        int       `json:"version" db:"version"`
 }
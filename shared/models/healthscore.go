@@ -0,0 +1,66 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// SelfAssessment is a patient's periodic rating of how they feel. Every
+// rating runs from 1 to 5; higher is better except for Pain, where 1 means
+// no pain.
+type SelfAssessment struct {
+	ID            string    `json:"id" db:"id"`
+	PatientID     string    `json:"patient_id" db:"patient_id"`
+	GeneralHealth int       `json:"general_health" db:"general_health"`
+	Energy        int       `json:"energy" db:"energy"`
+	Sleep         int       `json:"sleep" db:"sleep"`
+	Mood          int       `json:"mood" db:"mood"`
+	Pain          int       `json:"pain" db:"pain"`
+	Notes         string    `json:"notes" db:"notes"`
+	CreatedAt     time.Time `json:"created_at" db:"created_at"`
+}
+
+// ScoreContribution is what one scoring rule added to a health score.
+type ScoreContribution struct {
+	Rule      string  `json:"rule"`
+	Label     string  `json:"label"`
+	Points    float64 `json:"points"`
+	MaxPoints float64 `json:"max_points"`
+	Detail    string  `json:"detail"`
+}
+
+// ScoreContributions is stored as a JSONB array.
+type ScoreContributions []ScoreContribution
+
+func (c ScoreContributions) Value() (driver.Value, error) {
+	if c == nil {
+		c = ScoreContributions{}
+	}
+	return json.Marshal(c)
+}
+
+func (c *ScoreContributions) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case []byte:
+		return json.Unmarshal(v, c)
+	case string:
+		return json.Unmarshal([]byte(v), c)
+	case nil:
+		*c = nil
+		return nil
+	}
+	return fmt.Errorf("cannot scan %T into ScoreContributions", src)
+}
+
+// HealthScore is a patient's 0–100 health bar as computed by one version of
+// the scoring rules, with the contribution of each rule.
+type HealthScore struct {
+	ID            string             `json:"id,omitempty" db:"id"`
+	PatientID     string             `json:"patient_id" db:"patient_id"`
+	Score         int                `json:"score" db:"score"`
+	RulesVersion  string             `json:"rules_version" db:"rules_version"`
+	Contributions ScoreContributions `json:"contributions" db:"contributions"`
+	ComputedAt    time.Time          `json:"computed_at" db:"computed_at"`
+}
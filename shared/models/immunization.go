@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+// Immunization is one vaccine dose a patient received. Vaccine is the code
+// the immunization schedule knows it by; doses of vaccines outside the
+// schedule are recorded but not tracked.
+type Immunization struct {
+	ID             string    `json:"id" db:"id"`
+	PatientID      string    `json:"patient_id" db:"patient_id"`
+	Vaccine        string    `json:"vaccine" db:"vaccine"`
+	VaccineName    string    `json:"vaccine_name" db:"vaccine_name"`
+	DoseNumber     int       `json:"dose_number" db:"dose_number"`
+	LotNumber      string    `json:"lot_number" db:"lot_number"`
+	AdministeredOn time.Time `json:"administered_on" db:"administered_on"`
+	Facility       string    `json:"facility" db:"facility"`
+	Notes          string    `json:"notes" db:"notes"`
+	CreatedAt      time.Time `json:"created_at" db:"created_at"`
+}
+
+// ImmunizationReminder records that a patient was reminded about a dose,
+// once when it fell due and once when it became overdue.
+type ImmunizationReminder struct {
+	PatientID  string    `json:"patient_id" db:"patient_id"`
+	Vaccine    string    `json:"vaccine" db:"vaccine"`
+	DoseNumber int       `json:"dose_number" db:"dose_number"`
+	Status     string    `json:"status" db:"status"`
+	SentAt     time.Time `json:"sent_at" db:"sent_at"`
+}
@@ -0,0 +1,55 @@
+package models
+
+import "time"
+
+// LabFlag marks a result against its reference range. It is empty when no
+// range is known for the result.
+type LabFlag string
+
+const (
+	LabNormal       LabFlag = "normal"
+	LabLow          LabFlag = "low"
+	LabHigh         LabFlag = "high"
+	LabCriticalLow  LabFlag = "critical_low"
+	LabCriticalHigh LabFlag = "critical_high"
+)
+
+// Abnormal reports whether the flag is anything but normal or unknown.
+func (f LabFlag) Abnormal() bool {
+	return f != "" && f != LabNormal
+}
+
+// LabPanel is one set of lab results collected together, e.g. a complete
+// blood count. It may point at the visit it was ordered on and at the
+// uploaded report it was transcribed from.
+type LabPanel struct {
+	ID          string      `json:"id" db:"id"`
+	PatientID   string      `json:"patient_id" db:"patient_id"`
+	VisitID     *string     `json:"visit_id,omitempty" db:"visit_id"`
+	DocumentID  *string     `json:"document_id,omitempty" db:"document_id"`
+	Name        string      `json:"name" db:"name"`
+	LabName     string      `json:"lab_name" db:"lab_name"`
+	CollectedAt time.Time   `json:"collected_at" db:"collected_at"`
+	Notes       string      `json:"notes" db:"notes"`
+	CreatedAt   time.Time   `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time   `json:"updated_at" db:"updated_at"`
+	Results     []LabResult `json:"results" db:"-"`
+}
+
+// LabResult is the measured value of one analyte. Results of catalogued
+// analytes are stored in the catalog's unit; RefLow and RefHigh are the
+// range the result was flagged against.
+type LabResult struct {
+	ID          string    `json:"id" db:"id"`
+	PanelID     string    `json:"panel_id" db:"panel_id"`
+	PatientID   string    `json:"patient_id" db:"patient_id"`
+	Analyte     string    `json:"analyte" db:"analyte"`
+	Name        string    `json:"name" db:"name"`
+	Value       float64   `json:"value" db:"value"`
+	Unit        string    `json:"unit" db:"unit"`
+	RefLow      *float64  `json:"ref_low,omitempty" db:"ref_low"`
+	RefHigh     *float64  `json:"ref_high,omitempty" db:"ref_high"`
+	Flag        LabFlag   `json:"flag" db:"flag"`
+	CollectedAt time.Time `json:"collected_at" db:"collected_at"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+}
@@ -0,0 +1,47 @@
+package models
+
+import "time"
+
+// MessageThread is the conversation between one patient and one doctor.
+type MessageThread struct {
+	ID        string `json:"id" db:"id"`
+	PatientID string `json:"patient_id" db:"patient_id"`
+	DoctorID  string `json:"doctor_id" db:"doctor_id"`
+	// FrozenAt is when the patient revoked the doctor's access. A frozen
+	// thread takes no new messages.
+	FrozenAt      *time.Time `json:"frozen_at,omitempty" db:"frozen_at"`
+	LastMessageAt *time.Time `json:"last_message_at,omitempty" db:"last_message_at"`
+	CreatedAt     time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at" db:"updated_at"`
+	// Unread counts the other party's messages the reader has not read.
+	Unread int `json:"unread" db:"unread"`
+}
+
+// Frozen reports whether the thread no longer takes messages.
+func (t MessageThread) Frozen() bool {
+	return t.FrozenAt != nil
+}
+
+// Message is one message in a thread. ReadAt is set when the other party
+// marks it read.
+type Message struct {
+	ID          string              `json:"id" db:"id"`
+	ThreadID    string              `json:"thread_id" db:"thread_id"`
+	SenderRole  UserRole            `json:"sender_role" db:"sender_role"`
+	Body        string              `json:"body" db:"body"`
+	ReadAt      *time.Time          `json:"read_at,omitempty" db:"read_at"`
+	CreatedAt   time.Time           `json:"created_at" db:"created_at"`
+	Attachments []MessageAttachment `json:"attachments" db:"-"`
+}
+
+// MessageAttachment is a file sent with a message, stored alongside uploaded
+// prescriptions.
+type MessageAttachment struct {
+	ID        string    `json:"id" db:"id"`
+	MessageID string    `json:"message_id" db:"message_id"`
+	FileName  string    `json:"file_name" db:"file_name"`
+	FileType  string    `json:"file_type" db:"file_type"`
+	FileSize  int64     `json:"file_size" db:"file_size"`
+	FilePath  string    `json:"-" db:"file_path"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
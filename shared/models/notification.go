@@ -0,0 +1,45 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// NotificationData carries the structured details of a notification, such
+// as the IDs a client needs to link to. It is stored as a JSONB object.
+type NotificationData map[string]string
+
+func (d NotificationData) Value() (driver.Value, error) {
+	if d == nil {
+		d = NotificationData{}
+	}
+	return json.Marshal(d)
+}
+
+func (d *NotificationData) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case []byte:
+		return json.Unmarshal(v, d)
+	case string:
+		return json.Unmarshal([]byte(v), d)
+	case nil:
+		*d = nil
+		return nil
+	}
+	return fmt.Errorf("cannot scan %T into NotificationData", src)
+}
+
+// Notification is an in-app notification for one user. Kind is one of the
+// kinds in the notify catalog.
+type Notification struct {
+	ID        string           `json:"id" db:"id"`
+	UserID    string           `json:"user_id" db:"user_id"`
+	Kind      string           `json:"kind" db:"kind"`
+	Subject   string           `json:"subject" db:"subject"`
+	Body      string           `json:"body" db:"body"`
+	Data      NotificationData `json:"data" db:"data"`
+	ReadAt    *time.Time       `json:"read_at,omitempty" db:"read_at"`
+	CreatedAt time.Time        `json:"created_at" db:"created_at"`
+}
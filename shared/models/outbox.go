@@ -0,0 +1,72 @@
+package models
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"time"
+)
+
+// RawJSON is a JSON document stored as JSONB and embedded as is when the
+// row holding it is encoded.
+type RawJSON []byte
+
+func (p RawJSON) MarshalJSON() ([]byte, error) {
+	if len(p) == 0 {
+		return []byte("null"), nil
+	}
+	return p, nil
+}
+
+func (p *RawJSON) UnmarshalJSON(data []byte) error {
+	*p = append((*p)[:0], data...)
+	return nil
+}
+
+func (p RawJSON) Value() (driver.Value, error) {
+	return string(p), nil
+}
+
+func (p *RawJSON) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case []byte:
+		*p = append(RawJSON(nil), v...)
+		return nil
+	case string:
+		*p = RawJSON(v)
+		return nil
+	}
+	return fmt.Errorf("cannot scan %T into RawJSON", src)
+}
+
+// OutboxEvent is an event waiting in the outbox to be published on Topic.
+// Payload is the encoded event.
+type OutboxEvent struct {
+	ID            string    `json:"id" db:"id"`
+	Topic         string    `json:"topic" db:"topic"`
+	Payload       RawJSON   `json:"payload" db:"payload"`
+	Attempts      int       `json:"attempts" db:"attempts"`
+	NextAttemptAt time.Time `json:"next_attempt_at" db:"next_attempt_at"`
+	LastError     string    `json:"last_error" db:"last_error"`
+	CreatedAt     time.Time `json:"created_at" db:"created_at"`
+}
+
+// EventConsumer is a service that consumes the events of Topic. The relay
+// copies each event into the inbox of every consumer of its topic.
+type EventConsumer struct {
+	Consumer  string    `json:"consumer" db:"consumer"`
+	Topic     string    `json:"topic" db:"topic"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// InboxEvent is an event waiting in a consumer's inbox to be handled.
+// Payload is the encoded event.
+type InboxEvent struct {
+	Consumer      string    `json:"consumer" db:"consumer"`
+	EventID       string    `json:"event_id" db:"event_id"`
+	Topic         string    `json:"topic" db:"topic"`
+	Payload       RawJSON   `json:"payload" db:"payload"`
+	Attempts      int       `json:"attempts" db:"attempts"`
+	NextAttemptAt time.Time `json:"next_attempt_at" db:"next_attempt_at"`
+	LastError     string    `json:"last_error" db:"last_error"`
+	CreatedAt     time.Time `json:"created_at" db:"created_at"`
+}
@@ -12,4 +12,5 @@ type PatientProfile struct {
 	Address     string    `json:"address" db:"address"`
 	CreatedAt   time.Time `json:"created_at" db:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
+	Version     int       `json:"version" db:"version"`
 }
@@ -12,3 +12,41 @@ type Prescription struct {
 	UploadDate time.Time `json:"upload_date" db:"upload_date"`
 	CreatedAt  time.Time `json:"created_at" db:"created_at"`
 }
+
+// PrescriptionStatus is the state of an issued prescription.
+type PrescriptionStatus string
+
+const (
+	PrescriptionActive    PrescriptionStatus = "active"
+	PrescriptionCancelled PrescriptionStatus = "cancelled"
+)
+
+// EPrescription is a structured prescription a doctor issued, as opposed to
+// an uploaded Prescription scan. DocumentID optionally attaches such a scan.
+// PrescriberName is copied from the doctor's profile when issued so the
+// prescription stays readable after the profile is deleted.
+type EPrescription struct {
+	ID             string             `json:"id" db:"id"`
+	PatientID      string             `json:"patient_id" db:"patient_id"`
+	DoctorID       *string            `json:"doctor_id" db:"doctor_id"`
+	PrescriberName string             `json:"prescriber_name" db:"prescriber_name"`
+	DocumentID     *string            `json:"document_id,omitempty" db:"document_id"`
+	Drug           string             `json:"drug" db:"drug"`
+	Strength       string             `json:"strength" db:"strength"`
+	Route          string             `json:"route" db:"route"`
+	Frequency      string             `json:"frequency" db:"frequency"`
+	DurationDays   *int               `json:"duration_days,omitempty" db:"duration_days"`
+	Refills        int                `json:"refills" db:"refills"`
+	Instructions   string             `json:"instructions" db:"instructions"`
+	Status         PrescriptionStatus `json:"status" db:"status"`
+	IssuedAt       time.Time          `json:"issued_at" db:"issued_at"`
+	CancelledAt    *time.Time         `json:"cancelled_at,omitempty" db:"cancelled_at"`
+	CancelReason   string             `json:"cancel_reason,omitempty" db:"cancel_reason"`
+	// InteractionsVersion is the interaction dataset the prescription was
+	// checked against; OverrideReason why it was issued despite a
+	// high-severity warning.
+	InteractionsVersion string    `json:"interactions_version" db:"interactions_version"`
+	OverrideReason      string    `json:"override_reason,omitempty" db:"override_reason"`
+	CreatedAt           time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt           time.Time `json:"updated_at" db:"updated_at"`
+}
@@ -0,0 +1,33 @@
+package models
+
+import "time"
+
+// Upload is a resumable upload of a prescription file. Content arrives in
+// chunks, each stored as a blob until the last one lands and the file is
+// assembled; PrescriptionID is then set to the prescription it became.
+type Upload struct {
+	ID             string    `json:"id" db:"id"`
+	PatientID      string    `json:"patient_id" db:"patient_id"`
+	FileName       string    `json:"file_name" db:"file_name"`
+	FileType       string    `json:"file_type" db:"file_type"`
+	Length         int64     `json:"length" db:"upload_length"`
+	Offset         int64     `json:"offset" db:"upload_offset"`
+	PrescriptionID *string   `json:"prescription_id,omitempty" db:"prescription_id"`
+	ExpiresAt      time.Time `json:"expires_at" db:"expires_at"`
+	CreatedAt      time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// Complete reports whether every byte of the upload has arrived.
+func (u Upload) Complete() bool {
+	return u.Offset == u.Length
+}
+
+// UploadChunk is one stored part of an Upload, starting at Offset.
+type UploadChunk struct {
+	ID       string `json:"id" db:"id"`
+	UploadID string `json:"upload_id" db:"upload_id"`
+	Offset   int64  `json:"offset" db:"chunk_offset"`
+	Size     int64  `json:"size" db:"size"`
+	BlobKey  string `json:"-" db:"blob_key"`
+}
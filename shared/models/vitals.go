@@ -0,0 +1,57 @@
+package models
+
+import "time"
+
+// VitalKind is a type of vital sign measurement.
+type VitalKind string
+
+const (
+	VitalBloodPressure VitalKind = "blood_pressure"
+	VitalHeartRate     VitalKind = "heart_rate"
+	VitalGlucose       VitalKind = "glucose"
+	VitalWeight        VitalKind = "weight"
+	VitalTemperature   VitalKind = "temperature"
+	VitalSpO2          VitalKind = "spo2"
+)
+
+// VitalKinds lists every kind in display order.
+var VitalKinds = []VitalKind{
+	VitalBloodPressure, VitalHeartRate, VitalGlucose, VitalWeight, VitalTemperature, VitalSpO2,
+}
+
+func (k VitalKind) Valid() bool {
+	switch k {
+	case VitalBloodPressure, VitalHeartRate, VitalGlucose, VitalWeight, VitalTemperature, VitalSpO2:
+		return true
+	}
+	return false
+}
+
+// VitalReading is one measurement. Value is stored in the kind's canonical
+// unit; for blood pressure it is the systolic and Diastolic the diastolic
+// pressure.
+type VitalReading struct {
+	ID         string    `json:"id" db:"id"`
+	PatientID  string    `json:"patient_id" db:"patient_id"`
+	Kind       VitalKind `json:"kind" db:"kind"`
+	Value      float64   `json:"value" db:"value"`
+	Diastolic  *float64  `json:"diastolic,omitempty" db:"diastolic"`
+	Unit       string    `json:"unit" db:"unit"`
+	MeasuredAt time.Time `json:"measured_at" db:"measured_at"`
+	Source     string    `json:"source" db:"source"`
+	Notes      string    `json:"notes" db:"notes"`
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+}
+
+// VitalBucket summarizes the readings of one kind within a day or week,
+// starting at Start (UTC).
+type VitalBucket struct {
+	Start        time.Time `json:"start" db:"start"`
+	Count        int       `json:"count" db:"count"`
+	Min          float64   `json:"min" db:"min"`
+	Max          float64   `json:"max" db:"max"`
+	Avg          float64   `json:"avg" db:"avg"`
+	DiastolicMin *float64  `json:"diastolic_min,omitempty" db:"diastolic_min"`
+	DiastolicMax *float64  `json:"diastolic_max,omitempty" db:"diastolic_max"`
+	DiastolicAvg *float64  `json:"diastolic_avg,omitempty" db:"diastolic_avg"`
+}
@@ -0,0 +1,73 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// WebhookEventTypes are the event types a subscription receives. They are
+// stored as a JSONB array.
+type WebhookEventTypes []string
+
+func (t WebhookEventTypes) Value() (driver.Value, error) {
+	if t == nil {
+		t = WebhookEventTypes{}
+	}
+	return json.Marshal(t)
+}
+
+func (t *WebhookEventTypes) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case []byte:
+		return json.Unmarshal(v, t)
+	case string:
+		return json.Unmarshal([]byte(v), t)
+	case nil:
+		*t = nil
+		return nil
+	}
+	return fmt.Errorf("cannot scan %T into WebhookEventTypes", src)
+}
+
+// WebhookPayload is the JSON body a delivery sends.
+type WebhookPayload = RawJSON
+
+// WebhookSubscription sends its owner's events of EventTypes to URL. Secret
+// signs every delivery; it is only shown when the subscription is created.
+type WebhookSubscription struct {
+	ID         string            `json:"id" db:"id"`
+	UserID     string            `json:"user_id" db:"user_id"`
+	URL        string            `json:"url" db:"url"`
+	Secret     string            `json:"secret,omitempty" db:"secret"`
+	EventTypes WebhookEventTypes `json:"event_types" db:"event_types"`
+	CreatedAt  time.Time         `json:"created_at" db:"created_at"`
+}
+
+// Webhook delivery statuses. A pending delivery is sent at NextAttemptAt;
+// one that keeps failing is dead-lettered until it is replayed.
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryDead      = "dead"
+)
+
+// WebhookDelivery is one event sent to one subscription, with the outcome of
+// its latest attempt. A replay is a new delivery of the same event whose
+// ReplayOf is the delivery it repeats.
+type WebhookDelivery struct {
+	ID             string         `json:"id" db:"id"`
+	SubscriptionID string         `json:"subscription_id" db:"subscription_id"`
+	EventID        string         `json:"event_id" db:"event_id"`
+	EventType      string         `json:"event_type" db:"event_type"`
+	Payload        WebhookPayload `json:"payload" db:"payload"`
+	Status         string         `json:"status" db:"status"`
+	Attempts       int            `json:"attempts" db:"attempts"`
+	NextAttemptAt  time.Time      `json:"next_attempt_at" db:"next_attempt_at"`
+	LastAttemptAt  *time.Time     `json:"last_attempt_at,omitempty" db:"last_attempt_at"`
+	LastStatusCode *int           `json:"last_status_code,omitempty" db:"last_status_code"`
+	LastError      string         `json:"last_error,omitempty" db:"last_error"`
+	ReplayOf       *string        `json:"replay_of,omitempty" db:"replay_of"`
+	CreatedAt      time.Time      `json:"created_at" db:"created_at"`
+}
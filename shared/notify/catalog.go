@@ -0,0 +1,102 @@
+package notify
+
+import "fmt"
+
+// The kinds of message services send. Clients switch on them, so they are
+// part of the API: add new kinds rather than changing existing ones.
+const (
+	KindAccessGranted = "access_granted"
+	KindAccessRevoked = "access_revoked"
+	KindRecordViewed  = "record_viewed"
+	KindUploadCreated = "upload_created"
+	KindVisitCreated  = "visit_created"
+	KindVisitUpdated  = "visit_updated"
+	KindVisitDeleted  = "visit_deleted"
+
+	KindImmunizationDue     = "immunization_due"
+	KindImmunizationOverdue = "immunization_overdue"
+)
+
+// Catalog describes every kind, keyed by kind.
+var Catalog = map[string]string{
+	KindAccessGranted:       "A patient gave the doctor access to their record",
+	KindAccessRevoked:       "A patient withdrew the doctor's access to their record",
+	KindRecordViewed:        "A doctor opened the patient's record",
+	KindUploadCreated:       "A patient the doctor has access to uploaded a document",
+	KindVisitCreated:        "A hospital visit was added to a patient's timeline",
+	KindVisitUpdated:        "A hospital visit on a patient's timeline was changed",
+	KindVisitDeleted:        "A hospital visit was removed from a patient's timeline",
+	KindImmunizationDue:     "An immunization dose is coming due",
+	KindImmunizationOverdue: "An immunization dose is overdue",
+}
+
+// Known reports whether kind is in the catalog.
+func Known(kind string) bool {
+	_, ok := Catalog[kind]
+	return ok
+}
+
+// AccessGranted tells a doctor that a patient shared their record.
+func AccessGranted(doctorUserID, patientID, patientName string) Message {
+	return Message{
+		UserID:  doctorUserID,
+		Kind:    KindAccessGranted,
+		Subject: patientName + " gave you access to their record",
+		Data:    map[string]string{"patient_id": patientID},
+	}
+}
+
+// AccessRevoked tells a doctor that a patient withdrew access.
+func AccessRevoked(doctorUserID, patientID, patientName string) Message {
+	return Message{
+		UserID:  doctorUserID,
+		Kind:    KindAccessRevoked,
+		Subject: patientName + " withdrew your access to their record",
+		Data:    map[string]string{"patient_id": patientID},
+	}
+}
+
+// RecordViewed tells a patient that a doctor opened their record.
+func RecordViewed(patientUserID, doctorID, doctorName string) Message {
+	return Message{
+		UserID:  patientUserID,
+		Kind:    KindRecordViewed,
+		Subject: doctorName + " viewed your record",
+		Data:    map[string]string{"doctor_id": doctorID},
+	}
+}
+
+// UploadCreated tells a doctor that a patient uploaded a document.
+func UploadCreated(doctorUserID, patientID, patientName, documentID, fileName string) Message {
+	return Message{
+		UserID:  doctorUserID,
+		Kind:    KindUploadCreated,
+		Subject: patientName + " uploaded " + fileName,
+		Data:    map[string]string{"patient_id": patientID, "document_id": documentID},
+	}
+}
+
+// VisitCreated tells userID that a visit was added to a patient's timeline.
+func VisitCreated(userID, patientID, visitID, hospitalName string) Message {
+	return visitMessage(userID, KindVisitCreated, "added", patientID, visitID, hospitalName)
+}
+
+// VisitUpdated tells userID that a visit on a patient's timeline changed.
+func VisitUpdated(userID, patientID, visitID, hospitalName string) Message {
+	return visitMessage(userID, KindVisitUpdated, "updated", patientID, visitID, hospitalName)
+}
+
+// VisitDeleted tells userID that a visit was removed from a patient's
+// timeline.
+func VisitDeleted(userID, patientID, visitID, hospitalName string) Message {
+	return visitMessage(userID, KindVisitDeleted, "removed", patientID, visitID, hospitalName)
+}
+
+func visitMessage(userID, kind, verb, patientID, visitID, hospitalName string) Message {
+	return Message{
+		UserID:  userID,
+		Kind:    kind,
+		Subject: fmt.Sprintf("A visit to %s was %s", hospitalName, verb),
+		Data:    map[string]string{"patient_id": patientID, "visit_id": visitID},
+	}
+}
@@ -0,0 +1,84 @@
+// Package notify delivers messages to users. Services depend on the
+// Notifier interface so the delivery channel can be swapped without
+// touching the code that decides what to send.
+package notify
+
+import (
+	"context"
+	"log"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Message is one notification for one user.
+type Message struct {
+	UserID string
+	// Kind identifies the sort of message, e.g. "immunization_due".
+	Kind    string
+	Subject string
+	Body    string
+	// Data carries structured details for channels that can use them.
+	Data map[string]string
+}
+
+// Notifier delivers messages.
+type Notifier interface {
+	Notify(ctx context.Context, msg Message) error
+}
+
+// LogNotifier writes messages to a log instead of delivering them. It is
+// the default until a real channel is configured.
+type LogNotifier struct {
+	// Logger defaults to the standard logger.
+	Logger *log.Logger
+}
+
+func (n LogNotifier) Notify(ctx context.Context, msg Message) error {
+	logf := log.Printf
+	if n.Logger != nil {
+		logf = n.Logger.Printf
+	}
+
+	var data strings.Builder
+	for _, k := range slices.Sorted(maps.Keys(msg.Data)) {
+		data.WriteString(" " + k + "=" + msg.Data[k])
+	}
+	logf("notify: %s to user %s: %s%s", msg.Kind, msg.UserID, msg.Subject, data.String())
+	return nil
+}
+
+// Recorder keeps messages instead of delivering them. Tests use it to check
+// what was sent.
+type Recorder struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func (r *Recorder) Notify(ctx context.Context, msg Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.messages = append(r.messages, msg)
+	return nil
+}
+
+// Messages returns the messages recorded so far.
+func (r *Recorder) Messages() []Message {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return slices.Clone(r.messages)
+}
+
+// Send delivers every message, logging failures instead of returning them.
+// Producers call it once their change is committed: a notification that
+// cannot be stored must not undo the change it reports.
+func Send(ctx context.Context, n Notifier, msgs ...Message) {
+	for _, msg := range msgs {
+		if err := n.Notify(ctx, msg); err != nil {
+			log.Printf("notify: failed to send %s to user %s: %v", msg.Kind, msg.UserID, err)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+
+	"health-bar/shared/database"
+	"health-bar/shared/models"
+)
+
+// Channel is the Postgres channel notifications are announced on.
+const Channel = "notifications"
+
+// Announcement is the payload published on Channel. NotificationID is empty
+// when only the user's read state changed.
+type Announcement struct {
+	UserID         string `json:"user_id"`
+	NotificationID string `json:"notification_id,omitempty"`
+}
+
+// Payload encodes a for pg_notify.
+func (a Announcement) Payload() string {
+	b, _ := json.Marshal(a)
+	return string(b)
+}
+
+// PostgresNotifier stores messages in the notifications table, which every
+// service shares, and announces them on Channel so the notification service
+// can push them to connected clients. Inside a transaction the announcement
+// is only delivered on commit.
+type PostgresNotifier struct {
+	db *sqlx.DB
+}
+
+func NewPostgresNotifier(db *sqlx.DB) *PostgresNotifier {
+	return &PostgresNotifier{db: db}
+}
+
+func (n *PostgresNotifier) Notify(ctx context.Context, msg Message) error {
+	ctx, cancel := database.WithTimeout(ctx)
+	defer cancel()
+
+	id := uuid.New().String()
+	query := `
+		WITH inserted AS (
+			INSERT INTO notifications (id, user_id, kind, subject, body, data)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			RETURNING id
+		)
+		SELECT pg_notify($7, $8) FROM inserted
+	`
+	announcement := Announcement{UserID: msg.UserID, NotificationID: id}
+	_, err := database.Conn(ctx, n.db).ExecContext(ctx, query,
+		id, msg.UserID, msg.Kind, msg.Subject, msg.Body, models.NotificationData(msg.Data),
+		Channel, announcement.Payload(),
+	)
+	return err
+}
@@ -0,0 +1,262 @@
+// Package pagination implements keyset pagination for list endpoints.
+//
+// A list endpoint declares a Spec: the sort orders it allows and the
+// filters it understands. Parse turns query parameters into Params; the
+// Postgres repositories turn Params into SQL with Query, the in-memory ones
+// use Apply. Either way the page comes back with an opaque cursor that
+// points just past its last row, so pages stay stable while rows are added.
+package pagination
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"health-bar/shared/apperrors"
+)
+
+const (
+	DefaultLimit = 20
+	MaxLimit     = 100
+)
+
+// Sort is an order a list can be requested in. Rows are always ordered by
+// the sort column and then by id, which makes every position unique.
+type Sort[T any] struct {
+	// Name is the public name used in the sort query parameter.
+	Name string
+	// Column is the SQL expression ordered by.
+	Column string
+	// Cast is the SQL type the cursor value is cast to.
+	Cast string
+	// Value renders a row's sort value. Values of one Sort must order the
+	// same way as strings as they do in SQL; see Date, Timestamp and Int.
+	Value func(T) string
+}
+
+// Spec describes what a list endpoint accepts.
+type Spec[T any] struct {
+	Sorts []Sort[T]
+	// Default is the sort used when none is requested, e.g. "-visit_date".
+	Default string
+	// Filters are the accepted filter parameters besides from and to.
+	Filters []string
+	// DateRange enables the from and to parameters.
+	DateRange bool
+	// ID returns a row's unique id, the tie-breaker of every sort.
+	ID func(T) string
+	// IDColumn is the SQL expression for the id, "id" when empty.
+	IDColumn string
+}
+
+// Params is a parsed page request.
+type Params[T any] struct {
+	Limit int
+	Sort  Sort[T]
+	Desc  bool
+	// After is the position the page starts after, nil for the first page.
+	After *Cursor
+	// From and To bound the endpoint's date column, inclusive. Zero means
+	// unbounded.
+	From, To time.Time
+	Filters  map[string]string
+
+	spec *Spec[T]
+}
+
+// Cursor is a position in a sorted list. It is handed to clients encoded
+// and opaque.
+type Cursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    string `json:"i"`
+}
+
+// Encode returns the opaque form of c.
+func (c Cursor) Encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// DecodeCursor parses a cursor produced by Encode.
+func DecodeCursor(s string) (*Cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	var c Cursor
+	if err := json.Unmarshal(data, &c); err != nil || c.Sort == "" || c.ID == "" {
+		return nil, errInvalidCursor
+	}
+	return &c, nil
+}
+
+var errInvalidCursor = apperrors.New(apperrors.CodeValidation, "Invalid cursor")
+
+// Parse reads limit, sort, cursor, from, to and the spec's filters from q.
+// Invalid input yields a validation *apperrors.Error.
+func Parse[T any](q url.Values, spec *Spec[T]) (Params[T], error) {
+	p := Params[T]{Limit: DefaultLimit, Filters: map[string]string{}, spec: spec}
+
+	if raw := q.Get("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > MaxLimit {
+			return p, apperrors.New(apperrors.CodeValidation, fmt.Sprintf("limit must be between 1 and %d", MaxLimit))
+		}
+		p.Limit = limit
+	}
+
+	key := q.Get("sort")
+	if key == "" {
+		key = spec.Default
+	}
+	name := strings.TrimPrefix(key, "-")
+	found := false
+	for _, s := range spec.Sorts {
+		if s.Name == name {
+			p.Sort, found = s, true
+			break
+		}
+	}
+	if !found {
+		return p, apperrors.New(apperrors.CodeValidation, "sort must be one of: "+spec.sortNames())
+	}
+	p.Desc = strings.HasPrefix(key, "-")
+
+	if raw := q.Get("cursor"); raw != "" {
+		cursor, err := DecodeCursor(raw)
+		if err != nil {
+			return p, err
+		}
+		if cursor.Sort != p.SortKey() {
+			return p, apperrors.New(apperrors.CodeValidation, "cursor was issued for a different sort")
+		}
+		p.After = cursor
+	}
+
+	if spec.DateRange {
+		var err error
+		if p.From, err = parseDate(q.Get("from")); err != nil {
+			return p, apperrors.New(apperrors.CodeValidation, "Invalid from date. Use YYYY-MM-DD")
+		}
+		if p.To, err = parseDate(q.Get("to")); err != nil {
+			return p, apperrors.New(apperrors.CodeValidation, "Invalid to date. Use YYYY-MM-DD")
+		}
+		if !p.From.IsZero() && !p.To.IsZero() && p.To.Before(p.From) {
+			return p, apperrors.New(apperrors.CodeValidation, "from must not be after to")
+		}
+	}
+
+	for _, name := range spec.Filters {
+		if value := strings.TrimSpace(q.Get(name)); value != "" {
+			p.Filters[name] = value
+		}
+	}
+	return p, nil
+}
+
+func parseDate(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse("2006-01-02", s)
+}
+
+func (s *Spec[T]) sortNames() string {
+	names := make([]string, len(s.Sorts))
+	for i, sort := range s.Sorts {
+		names[i] = sort.Name
+	}
+	return strings.Join(names, ", ")
+}
+
+// SortKey is the sort in query parameter form, e.g. "-visit_date".
+func (p Params[T]) SortKey() string {
+	if p.Desc {
+		return "-" + p.Sort.Name
+	}
+	return p.Sort.Name
+}
+
+// ToEnd returns To extended to the end of its day, for comparing against
+// timestamp columns. It is zero when To is.
+func (p Params[T]) ToEnd() time.Time {
+	if p.To.IsZero() {
+		return p.To
+	}
+	return p.To.AddDate(0, 0, 1).Add(-time.Microsecond)
+}
+
+// InRange reports whether t lies within From and To.
+func (p Params[T]) InRange(t time.Time) bool {
+	if !p.From.IsZero() && t.Before(p.From) {
+		return false
+	}
+	if end := p.ToEnd(); !end.IsZero() && t.After(end) {
+		return false
+	}
+	return true
+}
+
+// Page trims rows, fetched with a limit of p.Limit+1, to the page size and
+// returns the cursor of the next page, or "" on the last page.
+func Page[T any](rows []T, p Params[T]) ([]T, string) {
+	if len(rows) <= p.Limit {
+		return rows, ""
+	}
+	rows = rows[:p.Limit]
+	last := rows[len(rows)-1]
+	return rows, Cursor{Sort: p.SortKey(), Value: p.Sort.Value(last), ID: p.spec.ID(last)}.Encode()
+}
+
+// Apply sorts, seeks and pages rows in memory the way Query does in SQL.
+// Filtering is left to the caller.
+func Apply[T any](rows []T, p Params[T]) ([]T, string) {
+	key := func(row T) (string, string) { return p.Sort.Value(row), p.spec.ID(row) }
+	less := func(a, b T) bool {
+		av, aid := key(a)
+		bv, bid := key(b)
+		if av != bv {
+			return (av < bv) != p.Desc
+		}
+		return (aid < bid) != p.Desc
+	}
+	sort.Slice(rows, func(i, j int) bool { return less(rows[i], rows[j]) })
+
+	if p.After != nil {
+		start := len(rows)
+		for i, row := range rows {
+			v, id := key(row)
+			after := v > p.After.Value || (v == p.After.Value && id > p.After.ID)
+			if p.Desc {
+				after = v < p.After.Value || (v == p.After.Value && id < p.After.ID)
+			}
+			if after {
+				start = i
+				break
+			}
+		}
+		rows = rows[start:]
+	}
+
+	if len(rows) > p.Limit+1 {
+		rows = rows[:p.Limit+1]
+	}
+	return Page(rows, p)
+}
+
+// Date renders a DATE sort value.
+func Date(t time.Time) string { return t.Format("2006-01-02") }
+
+// Timestamp renders a TIMESTAMP sort value at the microsecond precision
+// Postgres stores.
+func Timestamp(t time.Time) string { return t.UTC().Format("2006-01-02T15:04:05.000000") }
+
+// Int renders a non-negative integer sort value, zero-padded so it orders
+// correctly as a string.
+func Int(n int64) string { return fmt.Sprintf("%020d", n) }
@@ -0,0 +1,112 @@
+package pagination
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"health-bar/shared/apperrors"
+)
+
+type row struct {
+	ID   string
+	Name string
+	Size int64
+}
+
+var spec = &Spec[row]{
+	Sorts: []Sort[row]{
+		{Name: "name", Column: "name", Cast: "text", Value: func(r row) string { return r.Name }},
+		{Name: "size", Column: "size", Cast: "bigint", Value: func(r row) string { return Int(r.Size) }},
+	},
+	Default: "name",
+	Filters: []string{"kind"},
+	ID:      func(r row) string { return r.ID },
+}
+
+func parse(t *testing.T, query string) Params[row] {
+	t.Helper()
+
+	q, _ := url.ParseQuery(query)
+	p, err := Parse(q, spec)
+	if err != nil {
+		t.Fatalf("Parse(%q): %v", query, err)
+	}
+	return p
+}
+
+func TestParseRejectsBadInput(t *testing.T) {
+	for _, query := range []string{
+		"limit=0",
+		"limit=101",
+		"limit=ten",
+		"sort=password_hash",
+		"cursor=!!!",
+		"cursor=" + Cursor{Sort: "-size", Value: "x", ID: "1"}.Encode(),
+	} {
+		q, _ := url.ParseQuery(query)
+		_, err := Parse(q, spec)
+		if apperrors.CodeOf(err) != apperrors.CodeValidation {
+			t.Errorf("Parse(%q) error = %v, want validation error", query, err)
+		}
+	}
+}
+
+func TestApplyWalksAllPages(t *testing.T) {
+	rows := []row{
+		{"a", "Cara", 30}, {"b", "Abe", 10}, {"c", "Bea", 20},
+		{"d", "Abe", 10}, {"e", "Dan", 5},
+	}
+
+	for _, tt := range []struct {
+		sort string
+		want string
+	}{
+		{"name", "bdcae"},
+		{"-name", "eacdb"},
+		{"size", "ebdca"},
+		{"-size", "acdbe"},
+	} {
+		var got strings.Builder
+		query := "limit=2&sort=" + tt.sort
+		for pages := 0; ; pages++ {
+			if pages > len(rows) {
+				t.Fatalf("sort %s: cursor never ran out", tt.sort)
+			}
+			page, next := Apply(append([]row(nil), rows...), parse(t, query))
+			for _, r := range page {
+				got.WriteString(r.ID)
+			}
+			if next == "" {
+				break
+			}
+			query = "limit=2&sort=" + tt.sort + "&cursor=" + next
+		}
+		if got.String() != tt.want {
+			t.Errorf("sort %s: order = %s, want %s", tt.sort, got.String(), tt.want)
+		}
+	}
+}
+
+func TestClauses(t *testing.T) {
+	p := parse(t, "sort=-size&limit=5")
+	p.After = &Cursor{Sort: "-size", Value: Int(10), ID: "0c6e1f1e-0000-0000-0000-000000000000"}
+
+	q := &Query{}
+	q.Where("owner_id = " + q.Arg("owner"))
+	sql := p.Clauses(q)
+
+	want := "\nWHERE owner_id = $1 AND (size, id) < ($2::bigint, $3::uuid)\nORDER BY size DESC, id DESC\nLIMIT $4"
+	if sql != want {
+		t.Fatalf("Clauses =%q, want %q", sql, want)
+	}
+	if args := q.Args(); len(args) != 4 || args[3] != 6 {
+		t.Fatalf("args = %v", args)
+	}
+}
+
+func TestContainsEscapesWildcards(t *testing.T) {
+	if got := Contains(`50%_off\`); got != `%50\%\_off\\%` {
+		t.Fatalf("Contains = %q", got)
+	}
+}
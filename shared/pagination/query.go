@@ -0,0 +1,63 @@
+package pagination
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Query accumulates the WHERE conditions and arguments of a list query.
+type Query struct {
+	conds []string
+	args  []interface{}
+}
+
+// Arg adds an argument and returns its placeholder.
+func (q *Query) Arg(v interface{}) string {
+	q.args = append(q.args, v)
+	return "$" + strconv.Itoa(len(q.args))
+}
+
+// Where adds a condition. Conditions are joined with AND.
+func (q *Query) Where(cond string) {
+	q.conds = append(q.conds, cond)
+}
+
+// Args returns the arguments in placeholder order.
+func (q *Query) Args() []interface{} {
+	return q.args
+}
+
+// Clauses returns the WHERE, ORDER BY and LIMIT clauses for p on top of the
+// conditions already in q. It seeks past p.After and fetches one extra row
+// so Page can tell whether another page follows.
+func (p Params[T]) Clauses(q *Query) string {
+	idColumn := p.spec.IDColumn
+	if idColumn == "" {
+		idColumn = "id"
+	}
+
+	direction, cmp := "ASC", ">"
+	if p.Desc {
+		direction, cmp = "DESC", "<"
+	}
+
+	if p.After != nil {
+		q.Where("(" + p.Sort.Column + ", " + idColumn + ") " + cmp +
+			" (" + q.Arg(p.After.Value) + "::" + p.Sort.Cast + ", " + q.Arg(p.After.ID) + "::uuid)")
+	}
+
+	var sql strings.Builder
+	if len(q.conds) > 0 {
+		sql.WriteString("\nWHERE ")
+		sql.WriteString(strings.Join(q.conds, " AND "))
+	}
+	sql.WriteString("\nORDER BY " + p.Sort.Column + " " + direction + ", " + idColumn + " " + direction)
+	sql.WriteString("\nLIMIT " + q.Arg(p.Limit+1))
+	return sql.String()
+}
+
+// Contains returns an ILIKE pattern matching s anywhere, with the LIKE
+// wildcards in s escaped.
+func Contains(s string) string {
+	return "%" + strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s) + "%"
+}
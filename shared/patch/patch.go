@@ -0,0 +1,99 @@
+// Package patch applies JSON Merge Patch (RFC 7396) documents.
+//
+// Handlers render the current record as an editable document, a struct
+// holding exactly the fields clients may change, merge the patch into it and
+// validate the result as they would a full update.
+package patch
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+
+	"health-bar/shared/apperrors"
+)
+
+// ContentType is the media type of merge patch documents.
+const ContentType = "application/merge-patch+json"
+
+// MaxSize bounds the size of a patch document.
+const MaxSize = 1 << 20
+
+// Read returns the merge patch in r's body. Both ContentType and plain
+// application/json are accepted.
+func Read(r *http.Request) ([]byte, error) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || (mediaType != ContentType && mediaType != "application/json") {
+		return nil, apperrors.New(apperrors.CodeUnsupportedMedia, "Content-Type must be "+ContentType)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, MaxSize+1))
+	if err != nil {
+		return nil, apperrors.Wrap(err, apperrors.CodeInvalidRequest, "Invalid request body")
+	}
+	if len(body) > MaxSize {
+		return nil, apperrors.New(apperrors.CodeInvalidRequest, "Patch document too large")
+	}
+	return body, nil
+}
+
+// Apply merges patch into doc, a pointer to a struct. Members set to null
+// reset the field to its zero value; members that doc does not have are
+// rejected, so clients cannot touch fields they are not allowed to edit.
+func Apply(doc interface{}, patch []byte) error {
+	var changes interface{}
+	if err := json.Unmarshal(patch, &changes); err != nil {
+		return apperrors.Wrap(err, apperrors.CodeInvalidRequest, "Invalid request body")
+	}
+	if _, ok := changes.(map[string]interface{}); !ok {
+		return apperrors.New(apperrors.CodeValidation, "Patch must be a JSON object")
+	}
+
+	current, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	var target interface{}
+	if err := json.Unmarshal(current, &target); err != nil {
+		return err
+	}
+
+	merged, err := json.Marshal(Merge(target, changes))
+	if err != nil {
+		return err
+	}
+
+	value := reflect.ValueOf(doc).Elem()
+	value.Set(reflect.Zero(value.Type()))
+
+	decoder := json.NewDecoder(bytes.NewReader(merged))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(doc); err != nil {
+		return apperrors.Wrap(err, apperrors.CodeValidation, "Invalid patch: "+err.Error())
+	}
+	return nil
+}
+
+// Merge is the MergePatch function of RFC 7396 over decoded JSON values.
+func Merge(target, patch interface{}) interface{} {
+	changes, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	object, ok := target.(map[string]interface{})
+	if !ok {
+		object = map[string]interface{}{}
+	}
+	for name, value := range changes {
+		if value == nil {
+			delete(object, name)
+		} else {
+			object[name] = Merge(object[name], value)
+		}
+	}
+	return object
+}
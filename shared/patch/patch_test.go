@@ -0,0 +1,77 @@
+package patch
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"health-bar/shared/apperrors"
+)
+
+type doc struct {
+	Name  string `json:"name"`
+	Phone string `json:"phone"`
+	Tags  []int  `json:"tags"`
+}
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name  string
+		patch string
+		want  doc
+		code  apperrors.Code
+	}{
+		{name: "keeps absent members", patch: `{"phone":"555"}`, want: doc{Name: "Ann", Phone: "555", Tags: []int{1}}},
+		{name: "null clears", patch: `{"phone":null}`, want: doc{Name: "Ann", Tags: []int{1}}},
+		{name: "arrays are replaced", patch: `{"tags":[2,3]}`, want: doc{Name: "Ann", Phone: "123", Tags: []int{2, 3}}},
+		{name: "empty patch", patch: `{}`, want: doc{Name: "Ann", Phone: "123", Tags: []int{1}}},
+		{name: "unknown member", patch: `{"role":"admin"}`, code: apperrors.CodeValidation},
+		{name: "wrong type", patch: `{"name":7}`, code: apperrors.CodeValidation},
+		{name: "not an object", patch: `["name"]`, code: apperrors.CodeValidation},
+		{name: "malformed", patch: `{"name"`, code: apperrors.CodeInvalidRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := doc{Name: "Ann", Phone: "123", Tags: []int{1}}
+			err := Apply(&d, []byte(tt.patch))
+			if tt.code != "" {
+				if code := apperrors.CodeOf(err); code != tt.code {
+					t.Fatalf("Apply(%s) code = %q, want %q (err %v)", tt.patch, code, tt.code, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Apply(%s): %v", tt.patch, err)
+			}
+			if !reflect.DeepEqual(d, tt.want) {
+				t.Fatalf("Apply(%s) = %+v, want %+v", tt.patch, d, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeNested(t *testing.T) {
+	target := map[string]interface{}{"a": map[string]interface{}{"b": "c", "d": "e"}}
+	patch := map[string]interface{}{"a": map[string]interface{}{"b": nil, "f": "g"}}
+	want := map[string]interface{}{"a": map[string]interface{}{"d": "e", "f": "g"}}
+	if got := Merge(target, patch); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Merge = %v, want %v", got, want)
+	}
+}
+
+func TestRead(t *testing.T) {
+	for contentType, code := range map[string]apperrors.Code{
+		ContentType:                       "",
+		"application/json; charset=utf-8": "",
+		"text/plain":                      apperrors.CodeUnsupportedMedia,
+		"":                                apperrors.CodeUnsupportedMedia,
+	} {
+		req := httptest.NewRequest("PATCH", "/", strings.NewReader(`{"name":"x"}`))
+		req.Header.Set("Content-Type", contentType)
+		_, err := Read(req)
+		if got := apperrors.CodeOf(err); err != nil && got != code || err == nil && code != "" {
+			t.Errorf("Read with %q: err = %v, want code %q", contentType, err, code)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"time"
+
+	"health-bar/shared/envelope"
+)
+
+// sealedContentType is what encrypted blobs are stored as, whatever they
+// hold.
+const sealedContentType = "application/octet-stream"
+
+// Encrypted is a BlobStore that encrypts blobs before handing them to
+// another store, in the envelope format, and decrypts them on Get. Each
+// blob's content is bound to its key, so blobs cannot be swapped under each
+// other's names.
+//
+// Stat and List report the sizes of the stored, encrypted blobs; Get
+// reports the size of the content. Presign is not supported: a URL to the
+// underlying store would serve ciphertext.
+type Encrypted struct {
+	store         BlobStore
+	keys          envelope.KeyProvider
+	readPlaintext bool
+}
+
+// NewEncrypted returns a store keeping blobs in store, encrypted with data
+// keys that keys wraps.
+func NewEncrypted(store BlobStore, keys envelope.KeyProvider) *Encrypted {
+	return &Encrypted{store: store, keys: keys}
+}
+
+// ReadPlaintext sets whether Get returns blobs stored before encryption was
+// enabled as they are, until EncryptPlaintext has run over them. Otherwise
+// Get fails for them with envelope.ErrNotEncrypted.
+func (e *Encrypted) ReadPlaintext(allow bool) {
+	e.readPlaintext = allow
+}
+
+func (e *Encrypted) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	if !ValidKey(key) {
+		return ErrInvalidKey
+	}
+	sealed, err := envelope.Encrypt(ctx, r, e.keys, []byte(key))
+	if err != nil {
+		return err
+	}
+	// A reader of the wrong size seals to the wrong size too, which the
+	// store rejects
+	if size >= 0 {
+		size = sealed.Header.SealedSize(size)
+	}
+	return e.store.Put(ctx, key, sealed, size, sealedContentType)
+}
+
+func (e *Encrypted) Get(ctx context.Context, key string) (io.ReadCloser, Info, error) {
+	body, info, err := e.store.Get(ctx, key)
+	if err != nil {
+		return nil, Info{}, err
+	}
+	src := bufio.NewReader(body)
+	if prefix, _ := src.Peek(len(envelope.Magic)); !envelope.IsSealed(prefix) {
+		if e.readPlaintext {
+			return readCloser{src, body}, info, nil
+		}
+		body.Close()
+		return nil, Info{}, envelope.ErrNotEncrypted
+	}
+
+	content, err := envelope.Decrypt(ctx, src, e.keys, []byte(key))
+	if err != nil {
+		body.Close()
+		return nil, Info{}, err
+	}
+	info.Size = content.Header.PlaintextSize(info.Size)
+	return readCloser{content, body}, info, nil
+}
+
+func (e *Encrypted) Stat(ctx context.Context, key string) (Info, error) {
+	return e.store.Stat(ctx, key)
+}
+
+func (e *Encrypted) Delete(ctx context.Context, key string) error {
+	return e.store.Delete(ctx, key)
+}
+
+func (e *Encrypted) List(ctx context.Context, prefix string) ([]Info, error) {
+	return e.store.List(ctx, prefix)
+}
+
+func (e *Encrypted) Presign(ctx context.Context, key string, expires time.Duration) (string, error) {
+	return "", ErrPresignUnsupported
+}
+
+// Rewrap wraps the data key of the blob under key with the current master
+// key, rewriting only its header. It reports whether the blob needed it,
+// and fails with envelope.ErrNotEncrypted for a plaintext blob.
+func (e *Encrypted) Rewrap(ctx context.Context, key string) (bool, error) {
+	current, err := e.keys.Current(ctx)
+	if err != nil {
+		return false, err
+	}
+	body, info, err := e.store.Get(ctx, key)
+	if err != nil {
+		return false, err
+	}
+	defer body.Close()
+
+	header, err := envelope.ReadHeader(body)
+	if err != nil {
+		return false, err
+	}
+	if header.KeyID == current {
+		return false, nil
+	}
+	oldSize := header.Size()
+	if err := header.Rewrap(ctx, e.keys); err != nil {
+		return false, err
+	}
+	// The chunks are copied as they are
+	rewrapped := io.MultiReader(bytes.NewReader(header.Bytes()), body)
+	return true, e.store.Put(ctx, key, rewrapped, info.Size-oldSize+header.Size(), sealedContentType)
+}
+
+// EncryptPlaintext encrypts the blob under key if it was stored before
+// encryption was enabled. It reports whether the blob needed it.
+func (e *Encrypted) EncryptPlaintext(ctx context.Context, key string) (bool, error) {
+	body, info, err := e.store.Get(ctx, key)
+	if err != nil {
+		return false, err
+	}
+	defer body.Close()
+
+	src := bufio.NewReader(body)
+	if prefix, _ := src.Peek(len(envelope.Magic)); envelope.IsSealed(prefix) {
+		return false, nil
+	}
+	return true, e.Put(ctx, key, src, info.Size, "")
+}
+
+// readCloser reads from one reader and closes another.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
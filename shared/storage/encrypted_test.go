@@ -0,0 +1,153 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"health-bar/shared/envelope"
+)
+
+func newKeyring(t *testing.T, entries ...string) *envelope.Keyring {
+	t.Helper()
+	keys, err := envelope.ParseKeyring(strings.Join(entries, ","))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+func readBlob(t *testing.T, store BlobStore, key string) (string, Info, error) {
+	t.Helper()
+	body, info, err := store.Get(context.Background(), key)
+	if err != nil {
+		return "", info, err
+	}
+	defer body.Close()
+	data, err := io.ReadAll(body)
+	return string(data), info, err
+}
+
+func TestEncrypted(t *testing.T) {
+	ctx := context.Background()
+	k1, _ := envelope.GenerateKey("k1")
+	raw, _ := NewFilesystem(t.TempDir())
+	store := NewEncrypted(raw, newKeyring(t, k1))
+
+	content := strings.Repeat("%PDF-1.4 scan ", 10000)
+	if err := store.Put(ctx, "p1_a.pdf", strings.NewReader(content), int64(len(content)), "application/pdf"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put(ctx, "p1_b.png", strings.NewReader("png"), -1, "image/png"); err != nil {
+		t.Fatal(err)
+	}
+
+	stored, _, _ := readBlob(t, raw, "p1_a.pdf")
+	if strings.Contains(stored, "%PDF") || !strings.HasPrefix(stored, envelope.Magic) {
+		t.Fatalf("stored blob is not encrypted: %q...", stored[:20])
+	}
+	got, info, err := readBlob(t, store, "p1_a.pdf")
+	if err != nil || got != content || info.Size != int64(len(content)) {
+		t.Fatalf("Get = %d bytes, %+v, %v", len(got), info, err)
+	}
+	if got, _, _ := readBlob(t, store, "p1_b.png"); got != "png" {
+		t.Fatalf("Get of a blob of unknown size = %q", got)
+	}
+
+	for _, tt := range []struct {
+		content string
+		size    int64
+	}{{"abc", 4}, {"abcde", 4}} {
+		err := store.Put(ctx, "p3_x.pdf", strings.NewReader(tt.content), tt.size, "")
+		if !errors.Is(err, ErrSizeMismatch) {
+			t.Errorf("Put of %q as %d bytes = %v", tt.content, tt.size, err)
+		}
+	}
+
+	// A blob moved under another name does not open
+	sealed, _, _ := readBlob(t, raw, "p1_b.png")
+	raw.Put(ctx, "p2_c.png", strings.NewReader(sealed), -1, "")
+	if _, _, err := readBlob(t, store, "p2_c.png"); err != envelope.ErrCorrupt {
+		t.Fatalf("Get of a moved blob = %v", err)
+	}
+
+	if _, err := store.Presign(ctx, "p1_a.pdf", time.Minute); err != ErrPresignUnsupported {
+		t.Fatalf("Presign = %v", err)
+	}
+	if list, _ := store.List(ctx, "p1_"); infoKeys(list) != "p1_a.pdf,p1_b.png" {
+		t.Fatalf("List = %s", infoKeys(list))
+	}
+	if err := store.Delete(ctx, "p1_b.png"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Stat(ctx, "p1_b.png"); err != ErrNotFound {
+		t.Fatalf("Stat after Delete = %v", err)
+	}
+}
+
+func TestEncryptedPlaintextMigration(t *testing.T) {
+	ctx := context.Background()
+	k1, _ := envelope.GenerateKey("k1")
+	_, raw := newS3Server(t)
+	raw.Put(ctx, "p1_old.pdf", strings.NewReader("%PDF-1.4 old"), -1, "application/pdf")
+	store := NewEncrypted(raw, newKeyring(t, k1))
+
+	if _, _, err := readBlob(t, store, "p1_old.pdf"); err != envelope.ErrNotEncrypted {
+		t.Fatalf("Get of a plaintext blob = %v", err)
+	}
+	store.ReadPlaintext(true)
+	if got, _, err := readBlob(t, store, "p1_old.pdf"); err != nil || got != "%PDF-1.4 old" {
+		t.Fatalf("Get of a plaintext blob while migrating = %q, %v", got, err)
+	}
+
+	if changed, err := store.EncryptPlaintext(ctx, "p1_old.pdf"); err != nil || !changed {
+		t.Fatalf("EncryptPlaintext = %v, %v", changed, err)
+	}
+	if changed, err := store.EncryptPlaintext(ctx, "p1_old.pdf"); err != nil || changed {
+		t.Fatalf("EncryptPlaintext of an encrypted blob = %v, %v", changed, err)
+	}
+	store.ReadPlaintext(false)
+	if got, _, err := readBlob(t, store, "p1_old.pdf"); err != nil || got != "%PDF-1.4 old" {
+		t.Fatalf("Get after encrypting = %q, %v", got, err)
+	}
+}
+
+func TestEncryptedRewrap(t *testing.T) {
+	ctx := context.Background()
+	k1, _ := envelope.GenerateKey("k1")
+	k2, _ := envelope.GenerateKey("k2")
+	raw, _ := NewFilesystem(t.TempDir())
+	content := strings.Repeat("x", 200000)
+	NewEncrypted(raw, newKeyring(t, k1)).Put(ctx, "p1_a.pdf", strings.NewReader(content), -1, "")
+	before, _, _ := readBlob(t, raw, "p1_a.pdf")
+
+	rotated := NewEncrypted(raw, newKeyring(t, k2, k1))
+	if changed, err := rotated.Rewrap(ctx, "p1_a.pdf"); err != nil || !changed {
+		t.Fatalf("Rewrap = %v, %v", changed, err)
+	}
+	if changed, err := rotated.Rewrap(ctx, "p1_a.pdf"); err != nil || changed {
+		t.Fatalf("Rewrap under the current key = %v, %v", changed, err)
+	}
+
+	after, _, _ := readBlob(t, raw, "p1_a.pdf")
+	oldHeader, _ := envelope.ReadHeader(strings.NewReader(before))
+	newHeader, _ := envelope.ReadHeader(strings.NewReader(after))
+	if newHeader.KeyID != "k2" || before[oldHeader.Size():] != after[newHeader.Size():] {
+		t.Fatalf("rewrapped under %s, content kept: %v", newHeader.KeyID, before[oldHeader.Size():] == after[newHeader.Size():])
+	}
+
+	// k1 can be retired
+	retired := NewEncrypted(raw, newKeyring(t, k2))
+	if got, _, err := readBlob(t, retired, "p1_a.pdf"); err != nil || got != content {
+		t.Fatalf("Get with k1 retired = %d bytes, %v", len(got), err)
+	}
+
+	raw.Put(ctx, "p1_plain.pdf", bytes.NewReader([]byte("%PDF")), -1, "")
+	if _, err := rotated.Rewrap(ctx, "p1_plain.pdf"); err != envelope.ErrNotEncrypted {
+		t.Fatalf("Rewrap of a plaintext blob = %v", err)
+	}
+}
@@ -0,0 +1,230 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"health-bar/shared/apperrors"
+	"health-bar/shared/utils"
+)
+
+// Filesystem keeps blobs as files under a directory, one per key. Put
+// writes to a hidden temporary file and renames it into place.
+//
+// Presigned URLs need SignURLs: they point at wherever the Filesystem is
+// mounted as an http.Handler, which serves the blob to anyone holding a
+// valid, unexpired URL.
+type Filesystem struct {
+	dir     string
+	baseURL string
+	secret  []byte
+	now     func() time.Time
+}
+
+// NewFilesystem returns a store in dir, creating it if needed.
+func NewFilesystem(dir string) (*Filesystem, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Filesystem{dir: dir, now: time.Now}, nil
+}
+
+// Dir is the directory blobs are kept in.
+func (f *Filesystem) Dir() string { return f.dir }
+
+// SignURLs lets Presign hand out URLs to baseURL, signed with secret. f must
+// be served at baseURL.
+func (f *Filesystem) SignURLs(baseURL string, secret []byte) {
+	f.baseURL, f.secret = baseURL, secret
+}
+
+func (f *Filesystem) path(key string) (string, error) {
+	if !ValidKey(key) {
+		return "", ErrInvalidKey
+	}
+	return filepath.Join(f.dir, filepath.FromSlash(key)), nil
+}
+
+func (f *Filesystem) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	path, err := f.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	written, err := io.Copy(tmp, r)
+	if err == nil && size >= 0 && written != size {
+		err = ErrSizeMismatch
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+func (f *Filesystem) Get(ctx context.Context, key string) (io.ReadCloser, Info, error) {
+	file, info, err := f.open(key)
+	if err != nil {
+		return nil, Info{}, err
+	}
+	return file, info, nil
+}
+
+func (f *Filesystem) open(key string) (*os.File, Info, error) {
+	path, err := f.path(key)
+	if err != nil {
+		return nil, Info{}, err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, Info{}, notFound(err)
+	}
+	stat, err := file.Stat()
+	if err != nil || !stat.Mode().IsRegular() {
+		file.Close()
+		return nil, Info{}, ErrNotFound
+	}
+	return file, Info{Key: key, Size: stat.Size(), ModTime: stat.ModTime()}, nil
+}
+
+func (f *Filesystem) Stat(ctx context.Context, key string) (Info, error) {
+	path, err := f.path(key)
+	if err != nil {
+		return Info{}, err
+	}
+	stat, err := os.Stat(path)
+	if err != nil {
+		return Info{}, notFound(err)
+	}
+	if !stat.Mode().IsRegular() {
+		return Info{}, ErrNotFound
+	}
+	return Info{Key: key, Size: stat.Size(), ModTime: stat.ModTime()}, nil
+}
+
+func (f *Filesystem) Delete(ctx context.Context, key string) error {
+	path, err := f.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (f *Filesystem) List(ctx context.Context, prefix string) ([]Info, error) {
+	blobs := []Info{}
+	err := filepath.WalkDir(f.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		// Temporary files of unfinished Puts are hidden
+		if strings.HasPrefix(d.Name(), ".") && path != f.dir {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, _ := filepath.Rel(f.dir, path)
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) || !ValidKey(key) {
+			return nil
+		}
+		stat, err := d.Info()
+		if err != nil {
+			// Deleted while listing
+			return nil
+		}
+		blobs = append(blobs, Info{Key: key, Size: stat.Size(), ModTime: stat.ModTime()})
+		return ctx.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(blobs, func(a, b Info) int { return strings.Compare(a.Key, b.Key) })
+	return blobs, nil
+}
+
+func (f *Filesystem) Presign(ctx context.Context, key string, expires time.Duration) (string, error) {
+	if f.secret == nil {
+		return "", ErrPresignUnsupported
+	}
+	if !ValidKey(key) {
+		return "", ErrInvalidKey
+	}
+	until := strconv.FormatInt(f.now().Add(expires).Unix(), 10)
+	query := url.Values{"key": {key}, "expires": {until}, "signature": {f.sign(key, until)}}
+	return f.baseURL + "?" + query.Encode(), nil
+}
+
+func (f *Filesystem) sign(key, expires string) string {
+	mac := hmac.New(sha256.New, f.secret)
+	mac.Write([]byte(key + "\n" + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ServeHTTP serves the blob a presigned URL names, with range requests.
+func (f *Filesystem) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if f.secret == nil {
+		utils.SendErrorCode(w, apperrors.CodeNotFound, "Not found")
+		return
+	}
+	query := r.URL.Query()
+	key, expires := query.Get("key"), query.Get("expires")
+	until, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || !hmac.Equal([]byte(query.Get("signature")), []byte(f.sign(key, expires))) {
+		utils.SendErrorCode(w, apperrors.CodeForbidden, "Invalid signature")
+		return
+	}
+	if f.now().Unix() > until {
+		utils.SendErrorCode(w, apperrors.CodeForbidden, "The link has expired")
+		return
+	}
+
+	file, info, err := f.open(key)
+	if err != nil {
+		utils.SendErrorCode(w, apperrors.CodeNotFound, "File not found")
+		return
+	}
+	defer file.Close()
+	http.ServeContent(w, r, path.Base(key), info.ModTime, file)
+}
+
+func notFound(err error) error {
+	if os.IsNotExist(err) {
+		return ErrNotFound
+	}
+	return err
+}
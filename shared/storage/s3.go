@@ -0,0 +1,322 @@
+package storage
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxPresign is the longest a presigned S3 URL can be valid.
+const maxPresign = 7 * 24 * time.Hour
+
+// S3Config locates a bucket on S3 or an S3-compatible service.
+type S3Config struct {
+	// Endpoint is the service's base URL, such as https://s3.amazonaws.com
+	// or http://minio:9000.
+	Endpoint string
+	// Region defaults to us-east-1, which MinIO uses too.
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	// VirtualHosted addresses the bucket as a subdomain of the endpoint
+	// instead of the first path segment.
+	VirtualHosted bool
+	// Client sends the requests; http.DefaultClient when nil.
+	Client *http.Client
+}
+
+// S3 keeps blobs as objects in a bucket. It speaks the S3 REST API with
+// Signature Version 4 and needs nothing else from the service.
+type S3 struct {
+	endpoint      *url.URL
+	bucket        string
+	virtualHosted bool
+	signer        signer
+	client        *http.Client
+	now           func() time.Time
+}
+
+// S3Error is an error response from the service.
+type S3Error struct {
+	Status  int
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+func (e *S3Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("storage: S3 responded %d", e.Status)
+	}
+	return fmt.Sprintf("storage: S3 responded %d %s: %s", e.Status, e.Code, e.Message)
+}
+
+func NewS3(config S3Config) (*S3, error) {
+	endpoint, err := url.Parse(config.Endpoint)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return nil, fmt.Errorf("storage: invalid S3 endpoint %q", config.Endpoint)
+	}
+	if config.Bucket == "" || strings.Contains(config.Bucket, "/") {
+		return nil, fmt.Errorf("storage: invalid S3 bucket %q", config.Bucket)
+	}
+	if config.AccessKey == "" || config.SecretKey == "" {
+		return nil, errors.New("storage: S3 credentials are missing")
+	}
+	if config.Region == "" {
+		config.Region = "us-east-1"
+	}
+	if config.Client == nil {
+		config.Client = http.DefaultClient
+	}
+	return &S3{
+		endpoint:      endpoint,
+		bucket:        config.Bucket,
+		virtualHosted: config.VirtualHosted,
+		signer:        signer{accessKey: config.AccessKey, secretKey: config.SecretKey, region: config.Region, service: "s3"},
+		client:        config.Client,
+		now:           time.Now,
+	}, nil
+}
+
+// url addresses key in the bucket, or the bucket itself when key is empty.
+func (s *S3) url(key string) *url.URL {
+	u := *s.endpoint
+	base := strings.TrimSuffix(u.Path, "/")
+	if s.virtualHosted {
+		u.Host = s.bucket + "." + u.Host
+		u.Path = base + "/" + key
+	} else {
+		u.Path = base + "/" + s.bucket + "/" + key
+	}
+	u.RawPath, u.RawQuery = "", ""
+	return &u
+}
+
+// do sends a signed request and returns the response when it succeeded.
+func (s *S3) do(ctx context.Context, method string, u *url.URL, body io.Reader, size int64, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	payload := emptyPayload
+	if body != nil {
+		payload = unsignedPayload
+		// A body of unknown length would be sent chunked
+		req.ContentLength = size
+		req.Body = io.NopCloser(body)
+		if size == 0 {
+			req.Body = http.NoBody
+		}
+	}
+	s.signer.sign(req, payload, s.now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	failure := &S3Error{Status: resp.StatusCode}
+	xml.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(failure)
+	if resp.StatusCode == http.StatusNotFound && (failure.Code == "" || failure.Code == "NoSuchKey") {
+		return nil, ErrNotFound
+	}
+	return nil, failure
+}
+
+func (s *S3) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	if !ValidKey(key) {
+		return ErrInvalidKey
+	}
+	// A PUT needs its length up front
+	if size < 0 {
+		spooled, err := os.CreateTemp("", "blob-*")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(spooled.Name())
+		defer spooled.Close()
+		if size, err = io.Copy(spooled, r); err != nil {
+			return err
+		}
+		if _, err := spooled.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		r = spooled
+	}
+
+	header := http.Header{}
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	body := &sizedReader{r: r, remaining: size}
+	if size == 0 {
+		// An empty PUT does not read its body
+		if _, err := body.Read(nil); err != io.EOF {
+			return err
+		}
+	}
+	resp, err := s.do(ctx, http.MethodPut, s.url(key), body, size, header)
+	if body.err != nil {
+		// The service may have stored the first size bytes of a longer body
+		if err == nil {
+			resp.Body.Close()
+		}
+		s.Delete(ctx, key)
+		return body.err
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *S3) Get(ctx context.Context, key string) (io.ReadCloser, Info, error) {
+	if !ValidKey(key) {
+		return nil, Info{}, ErrInvalidKey
+	}
+	resp, err := s.do(ctx, http.MethodGet, s.url(key), nil, 0, nil)
+	if err != nil {
+		return nil, Info{}, err
+	}
+	return resp.Body, objectInfo(key, resp), nil
+}
+
+func (s *S3) Stat(ctx context.Context, key string) (Info, error) {
+	if !ValidKey(key) {
+		return Info{}, ErrInvalidKey
+	}
+	resp, err := s.do(ctx, http.MethodHead, s.url(key), nil, 0, nil)
+	if err != nil {
+		return Info{}, err
+	}
+	resp.Body.Close()
+	return objectInfo(key, resp), nil
+}
+
+func objectInfo(key string, resp *http.Response) Info {
+	info := Info{Key: key, Size: resp.ContentLength}
+	if size, err := strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64); err == nil {
+		info.Size = size
+	}
+	info.ModTime, _ = http.ParseTime(resp.Header.Get("Last-Modified"))
+	return info
+}
+
+func (s *S3) Delete(ctx context.Context, key string) error {
+	if !ValidKey(key) {
+		return ErrInvalidKey
+	}
+	resp, err := s.do(ctx, http.MethodDelete, s.url(key), nil, 0, nil)
+	if err == ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// listResult is a page of ListObjectsV2.
+type listResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func (s *S3) List(ctx context.Context, prefix string) ([]Info, error) {
+	blobs := []Info{}
+	token := ""
+	for {
+		u := s.url("")
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		u.RawQuery = query.Encode()
+
+		resp, err := s.do(ctx, http.MethodGet, u, nil, 0, nil)
+		if err != nil {
+			return nil, err
+		}
+		var page listResult
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("storage: decode object list: %w", err)
+		}
+
+		for _, object := range page.Contents {
+			blobs = append(blobs, Info{Key: object.Key, Size: object.Size, ModTime: object.LastModified})
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return blobs, nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+func (s *S3) Presign(ctx context.Context, key string, expires time.Duration) (string, error) {
+	if !ValidKey(key) {
+		return "", ErrInvalidKey
+	}
+	if expires <= 0 || expires > maxPresign {
+		return "", fmt.Errorf("storage: presigned URLs last up to %s", maxPresign)
+	}
+	return s.signer.presign(http.MethodGet, s.url(key), expires, s.now()).String(), nil
+}
+
+// sizedReader reads exactly remaining bytes from r and records
+// ErrSizeMismatch if r holds fewer or more.
+type sizedReader struct {
+	r         io.Reader
+	remaining int64
+	err       error
+}
+
+func (s *sizedReader) Read(p []byte) (int, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+	if s.remaining <= 0 {
+		var probe [1]byte
+		if n, _ := io.ReadFull(s.r, probe[:]); n > 0 {
+			s.err = ErrSizeMismatch
+			return 0, s.err
+		}
+		return 0, io.EOF
+	}
+	if int64(len(p)) > s.remaining {
+		p = p[:s.remaining]
+	}
+	n, err := s.r.Read(p)
+	s.remaining -= int64(n)
+	if err == io.EOF && s.remaining > 0 {
+		s.err = ErrSizeMismatch
+		return n, s.err
+	}
+	if err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
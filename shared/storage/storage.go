@@ -0,0 +1,129 @@
+// Package storage keeps uploaded files in a blob store. Services depend on
+// the BlobStore interface; Filesystem keeps blobs in a local directory and
+// S3 in a bucket of any S3-compatible service, such as MinIO. Open picks
+// one from a URL, so a deployment chooses its backend with configuration.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned for a key with no blob.
+	ErrNotFound = errors.New("storage: blob not found")
+	// ErrInvalidKey is returned for a key the store does not accept.
+	ErrInvalidKey = errors.New("storage: invalid key")
+	// ErrPresignUnsupported is returned by Presign when the store cannot
+	// hand out URLs, such as a Filesystem without a signing key.
+	ErrPresignUnsupported = errors.New("storage: presigned URLs are not supported")
+	// ErrSizeMismatch is returned by Put when the reader does not hold the
+	// number of bytes it was announced with.
+	ErrSizeMismatch = errors.New("storage: size mismatch")
+)
+
+// BlobStore keeps blobs under keys. Keys are slash-separated paths of
+// letters, digits, '-', '_' and '.', without empty, "." or ".." segments and
+// not starting with '.'.
+type BlobStore interface {
+	// Put stores size bytes read from r under key, replacing any blob there.
+	// A negative size means unknown. Readers never see a partial blob, but
+	// a failed Put may leave no blob under key.
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Get opens the blob under key. The caller closes it.
+	Get(ctx context.Context, key string) (io.ReadCloser, Info, error)
+	Stat(ctx context.Context, key string) (Info, error)
+	// Delete removes the blob under key. A missing blob is not an error.
+	Delete(ctx context.Context, key string) error
+	// List returns the blobs whose keys start with prefix, ordered by key.
+	List(ctx context.Context, prefix string) ([]Info, error)
+	// Presign returns a URL anyone can download the blob from until expires
+	// has passed.
+	Presign(ctx context.Context, key string, expires time.Duration) (string, error)
+}
+
+// Info describes a stored blob.
+type Info struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// ValidKey reports whether key can be stored.
+func ValidKey(key string) bool {
+	if key == "" || key[0] == '.' || len(key) > 1024 {
+		return false
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return false
+		}
+		for _, c := range segment {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Open returns the store rawURL names:
+//
+//	file:///app/uploads       a Filesystem in /app/uploads; a bare path works too
+//	s3://key:secret@host/bucket?region=us-east-1&insecure=true&virtual_hosted=true
+//
+// S3 credentials missing from the URL are read from AWS_ACCESS_KEY_ID and
+// AWS_SECRET_ACCESS_KEY. Buckets are addressed path-style, as MinIO expects,
+// unless virtual_hosted is set; insecure uses plain HTTP.
+func Open(rawURL string) (BlobStore, error) {
+	if !strings.Contains(rawURL, "://") {
+		return NewFilesystem(rawURL)
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("storage: invalid URL: %w", err)
+	}
+
+	switch u.Scheme {
+	case "file":
+		if u.Host != "" && u.Host != "localhost" {
+			return nil, fmt.Errorf("storage: file URL %q names a host", rawURL)
+		}
+		return NewFilesystem(u.Path)
+
+	case "s3":
+		config := S3Config{
+			Endpoint:      "https://" + u.Host,
+			Bucket:        strings.Trim(u.Path, "/"),
+			Region:        u.Query().Get("region"),
+			AccessKey:     os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretKey:     os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			VirtualHosted: u.Query().Get("virtual_hosted") == "true",
+		}
+		if u.Query().Get("insecure") == "true" {
+			config.Endpoint = "http://" + u.Host
+		}
+		if u.User != nil {
+			config.AccessKey = u.User.Username()
+			config.SecretKey, _ = u.User.Password()
+		}
+		return NewS3(config)
+	}
+	return nil, fmt.Errorf("storage: unsupported URL scheme %q", u.Scheme)
+}
+
+// Redact returns rawURL without the secret in it, for logs.
+func Redact(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.User == nil {
+		return rawURL
+	}
+	u.User = url.User(u.User.Username())
+	return u.String()
+}
@@ -0,0 +1,274 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidKey(t *testing.T) {
+	valid := []string{"p1_scan.pdf", "uploads/2024/a-b_c.png", "x"}
+	invalid := []string{"", ".hidden", "../etc/passwd", "a/../b", "a//b", "a/", "/abs", "a b", `a\b`, "a/./b", strings.Repeat("x", 1025)}
+	for _, key := range valid {
+		if !ValidKey(key) {
+			t.Errorf("ValidKey(%q) = false", key)
+		}
+	}
+	for _, key := range invalid {
+		if ValidKey(key) {
+			t.Errorf("ValidKey(%q) = true", key)
+		}
+	}
+}
+
+// testBlobStore checks the behaviour every BlobStore shares.
+func testBlobStore(t *testing.T, store BlobStore) {
+	t.Helper()
+	ctx := context.Background()
+
+	if err := store.Put(ctx, "p1_a.pdf", strings.NewReader("%PDF-1.4 a"), 10, "application/pdf"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if err := store.Put(ctx, "p1_b.png", strings.NewReader("png"), -1, ""); err != nil {
+		t.Fatalf("Put of unknown size: %v", err)
+	}
+	if err := store.Put(ctx, "p2_c.pdf", strings.NewReader(""), 0, ""); err != nil {
+		t.Fatalf("Put of an empty blob: %v", err)
+	}
+	if err := store.Put(ctx, "dir/p1_d.pdf", strings.NewReader("nested"), 6, ""); err != nil {
+		t.Fatalf("Put under a directory: %v", err)
+	}
+
+	// Replacing keeps the new content only
+	if err := store.Put(ctx, "p1_a.pdf", strings.NewReader("%PDF-1.4 ab"), 11, "application/pdf"); err != nil {
+		t.Fatalf("Put over a blob: %v", err)
+	}
+	body, info, err := store.Get(ctx, "p1_a.pdf")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	data, _ := io.ReadAll(body)
+	body.Close()
+	if string(data) != "%PDF-1.4 ab" || info.Size != 11 || info.Key != "p1_a.pdf" {
+		t.Fatalf("Get = %q, %+v", data, info)
+	}
+
+	info, err = store.Stat(ctx, "p1_b.png")
+	if err != nil || info.Size != 3 || info.ModTime.IsZero() {
+		t.Fatalf("Stat = %+v, %v", info, err)
+	}
+
+	for _, tt := range []struct {
+		name    string
+		content string
+		size    int64
+	}{
+		{"short", "abc", 4},
+		{"long", "abcde", 4},
+		{"long for empty", "a", 0},
+	} {
+		err := store.Put(ctx, "p3_"+tt.name[:1]+".pdf", strings.NewReader(tt.content), tt.size, "")
+		if !errors.Is(err, ErrSizeMismatch) {
+			t.Errorf("Put of a %s reader = %v, want ErrSizeMismatch", tt.name, err)
+		}
+	}
+
+	list, err := store.List(ctx, "p1_")
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if keys := infoKeys(list); keys != "p1_a.pdf,p1_b.png" {
+		t.Fatalf("List(p1_) = %s", keys)
+	}
+	all, _ := store.List(ctx, "")
+	if keys := infoKeys(all); keys != "dir/p1_d.pdf,p1_a.pdf,p1_b.png,p2_c.pdf" {
+		t.Fatalf("List() = %s", keys)
+	}
+
+	if err := store.Delete(ctx, "p1_a.pdf"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if err := store.Delete(ctx, "p1_a.pdf"); err != nil {
+		t.Fatalf("Delete of a missing blob: %v", err)
+	}
+	if _, _, err := store.Get(ctx, "p1_a.pdf"); err != ErrNotFound {
+		t.Fatalf("Get after Delete = %v, want ErrNotFound", err)
+	}
+	if _, err := store.Stat(ctx, "p1_a.pdf"); err != ErrNotFound {
+		t.Fatalf("Stat after Delete = %v, want ErrNotFound", err)
+	}
+
+	if err := store.Put(ctx, "../escape", strings.NewReader("x"), 1, ""); err != ErrInvalidKey {
+		t.Fatalf("Put of an invalid key = %v", err)
+	}
+	if _, _, err := store.Get(ctx, "../escape"); err != ErrInvalidKey {
+		t.Fatalf("Get of an invalid key = %v", err)
+	}
+}
+
+func infoKeys(list []Info) string {
+	keys := make([]string, len(list))
+	for i, info := range list {
+		keys[i] = info.Key
+	}
+	return strings.Join(keys, ",")
+}
+
+func TestFilesystem(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFilesystem(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testBlobStore(t, store)
+
+	leftovers, _ := filepath.Glob(filepath.Join(dir, ".*.tmp"))
+	if len(leftovers) != 0 {
+		t.Fatalf("temporary files left behind: %v", leftovers)
+	}
+	// Unfinished Puts are not listed
+	os.WriteFile(filepath.Join(dir, ".p2_x.pdf.123.tmp"), []byte("x"), 0644)
+	if list, _ := store.List(context.Background(), "p2_"); infoKeys(list) != "p2_c.pdf" {
+		t.Fatalf("List = %s", infoKeys(list))
+	}
+}
+
+func TestFilesystemPresign(t *testing.T) {
+	store, _ := NewFilesystem(t.TempDir())
+	ctx := context.Background()
+	store.Put(ctx, "p1_a.pdf", strings.NewReader("%PDF-1.4 hello"), -1, "")
+
+	if _, err := store.Presign(ctx, "p1_a.pdf", time.Minute); err != ErrPresignUnsupported {
+		t.Fatalf("Presign without a key = %v", err)
+	}
+
+	server := httptest.NewServer(store)
+	defer server.Close()
+	store.SignURLs(server.URL+"/files", []byte("secret"))
+	now := time.Now()
+	store.now = func() time.Time { return now }
+
+	link, err := store.Presign(ctx, "p1_a.pdf", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func(target string, header http.Header) (*http.Response, string) {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, target, nil)
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp, string(body)
+	}
+
+	if resp, body := get(link, nil); resp.StatusCode != http.StatusOK || body != "%PDF-1.4 hello" {
+		t.Fatalf("GET = %d %q", resp.StatusCode, body)
+	}
+	if resp, body := get(link, http.Header{"Range": {"bytes=9-"}}); resp.StatusCode != http.StatusPartialContent || body != "hello" {
+		t.Fatalf("ranged GET = %d %q", resp.StatusCode, body)
+	}
+	if resp, _ := get(strings.Replace(link, "p1_a.pdf", "p1_b.pdf", 1), nil); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("GET of another key = %d", resp.StatusCode)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if resp, _ := get(link, nil); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("GET after expiry = %d", resp.StatusCode)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	dir := t.TempDir()
+	for _, rawURL := range []string{dir, "file://" + dir} {
+		store, err := Open(rawURL)
+		if fs, ok := store.(*Filesystem); err != nil || !ok || fs.Dir() != dir {
+			t.Fatalf("Open(%q) = %T, %v", rawURL, store, err)
+		}
+	}
+
+	store, err := Open("s3://key:secret@minio:9000/prescriptions?insecure=true&region=eu-west-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s3 := store.(*S3)
+	if got := s3.url("p1_a.pdf").String(); got != "http://minio:9000/prescriptions/p1_a.pdf" {
+		t.Fatalf("object URL = %s", got)
+	}
+	if s3.signer.accessKey != "key" || s3.signer.secretKey != "secret" || s3.signer.region != "eu-west-1" {
+		t.Fatalf("signer = %+v", s3.signer)
+	}
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+	for _, rawURL := range []string{"s3://minio/bucket", "s3://key:secret@minio", "ftp://host/dir", "file://remote/dir"} {
+		if _, err := Open(rawURL); err == nil {
+			t.Errorf("Open(%q) succeeded", rawURL)
+		}
+	}
+
+	if got := Redact("s3://key:secret@minio/bucket"); got != "s3://key@minio/bucket" {
+		t.Fatalf("Redact = %s", got)
+	}
+}
+
+func TestSizedReader(t *testing.T) {
+	var out bytes.Buffer
+	r := &sizedReader{r: strings.NewReader("abcd"), remaining: 4}
+	if _, err := io.Copy(&out, r); err != nil || out.String() != "abcd" {
+		t.Fatalf("copy = %q, %v", out.String(), err)
+	}
+}
+
+func TestCopy(t *testing.T) {
+	ctx := context.Background()
+	from, _ := NewFilesystem(t.TempDir())
+	_, to := newS3Server(t)
+	from.Put(ctx, "p1_a.pdf", strings.NewReader("%PDF-1.4 a"), -1, "")
+	from.Put(ctx, "p1_b.png", strings.NewReader("png"), -1, "")
+	from.Put(ctx, "p2_c.pdf", strings.NewReader("%PDF-1.4 c"), -1, "")
+
+	stats, err := Copy(ctx, from, to, CopyOptions{Prefix: "p1_", DryRun: true})
+	if err != nil || stats.Copied != 2 {
+		t.Fatalf("dry run = %+v, %v", stats, err)
+	}
+	if list, _ := to.List(ctx, ""); len(list) != 0 {
+		t.Fatalf("dry run copied %s", infoKeys(list))
+	}
+
+	// A blob already there with the same size is skipped, one with another
+	// size is replaced
+	to.Put(ctx, "p1_a.pdf", strings.NewReader("%PDF-1.4 x"), -1, "")
+	to.Put(ctx, "p1_b.png", strings.NewReader("stale"), -1, "")
+	stats, err = Copy(ctx, from, to, CopyOptions{})
+	if err != nil || stats.Copied != 2 || stats.Skipped != 1 || stats.Bytes != 13 {
+		t.Fatalf("copy = %+v, %v", stats, err)
+	}
+	if list, _ := to.List(ctx, ""); infoKeys(list) != "p1_a.pdf,p1_b.png,p2_c.pdf" {
+		t.Fatalf("copied %s", infoKeys(list))
+	}
+	body, _, _ := to.Get(ctx, "p1_b.png")
+	data, _ := io.ReadAll(body)
+	body.Close()
+	if string(data) != "png" {
+		t.Fatalf("p1_b.png = %q", data)
+	}
+
+	stats, _ = Copy(ctx, from, to, CopyOptions{Overwrite: true})
+	if stats.Copied != 3 || stats.Skipped != 0 {
+		t.Fatalf("overwrite = %+v", stats)
+	}
+}
@@ -0,0 +1,91 @@
+// Package testutil holds helpers shared by the handler test suites.
+package testutil
+
+import (
+	"bytes"
+	"encoding/json"
+	"health-bar/shared/apperrors"
+	"health-bar/shared/utils"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// NewRequest builds a request as AuthMiddleware would hand it to a handler:
+// the caller identity travels in the X-User-ID and X-User-Role headers. body
+// may be nil, a string, []byte or any value to encode as JSON.
+func NewRequest(t *testing.T, method, target string, body interface{}, userID, role string) *http.Request {
+	t.Helper()
+
+	var reader io.Reader
+	switch b := body.(type) {
+	case nil:
+	case string:
+		reader = bytes.NewBufferString(b)
+	case []byte:
+		reader = bytes.NewReader(b)
+	default:
+		encoded, err := json.Marshal(b)
+		if err != nil {
+			t.Fatalf("encode request body: %v", err)
+		}
+		reader = bytes.NewReader(encoded)
+	}
+
+	req := httptest.NewRequest(method, target, reader)
+	req.Header.Set("Content-Type", "application/json")
+	if userID != "" {
+		req.Header.Set("X-User-ID", userID)
+	}
+	if role != "" {
+		req.Header.Set("X-User-Role", role)
+	}
+	return req
+}
+
+// Serve runs handler and decodes the standard response envelope.
+func Serve(t *testing.T, handler http.HandlerFunc, req *http.Request) (*httptest.ResponseRecorder, utils.Response) {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+
+	var resp utils.Response
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode response %q: %v", rec.Body.String(), err)
+	}
+	return rec, resp
+}
+
+// DecodeData re-decodes the envelope's data field into dest.
+func DecodeData(t *testing.T, resp utils.Response, dest interface{}) {
+	t.Helper()
+
+	encoded, err := json.Marshal(resp.Data)
+	if err != nil {
+		t.Fatalf("encode data: %v", err)
+	}
+	if err := json.Unmarshal(encoded, dest); err != nil {
+		t.Fatalf("decode data %s: %v", encoded, err)
+	}
+}
+
+// ExpectStatus fails the test when the recorded status differs.
+func ExpectStatus(t *testing.T, rec *httptest.ResponseRecorder, want int) {
+	t.Helper()
+
+	if rec.Code != want {
+		t.Fatalf("status = %d, want %d (body: %s)", rec.Code, want, rec.Body.String())
+	}
+}
+
+// ExpectCode fails the test when the response carries a different error
+// code.
+func ExpectCode(t *testing.T, resp utils.Response, want apperrors.Code) {
+	t.Helper()
+
+	if resp.Code != want {
+		t.Fatalf("error code = %q, want %q (error: %s)", resp.Code, want, resp.Error)
+	}
+}
@@ -0,0 +1,37 @@
+package utils
+
+import (
+	"health-bar/shared/apperrors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ETag is the entity tag for a row version
+func ETag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// SetETag sets the ETag header for a row version
+func SetETag(w http.ResponseWriter, version int) {
+	w.Header().Set("ETag", ETag(version))
+}
+
+// CheckIfMatch evaluates the request's If-Match header against the current
+// row version. A missing header always passes; otherwise one of the listed
+// tags, or "*", must match. Weak tags never match, as RFC 9110 requires.
+func CheckIfMatch(r *http.Request, version int) error {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return nil
+	}
+
+	current := ETag(version)
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || tag == current {
+			return nil
+		}
+	}
+	return apperrors.New(apperrors.CodePreconditionFailed, "The resource has changed; fetch it again and retry")
+}
@@ -2,8 +2,12 @@ package utils
 
 import "golang.org/x/crypto/bcrypt"
 
+// PasswordHashCost is the bcrypt cost used by HashPassword. Tests lower it to
+// keep suites fast.
+var PasswordHashCost = 14
+
 func HashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
+	bytes, err := bcrypt.GenerateFromPassword([]byte(password), PasswordHashCost)
 	return string(bytes), err
 }
 
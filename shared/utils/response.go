@@ -2,14 +2,18 @@ package utils
 
 import (
 	"encoding/json"
+	"health-bar/shared/apperrors"
 	"net/http"
 )
 
 type Response struct {
-	Success bool        `json:"success"`
-	Message string      `json:"message,omitempty"`
-	Data    interface{} `json:"data,omitempty"`
-	Error   string      `json:"error,omitempty"`
+	Success bool           `json:"success"`
+	Message string         `json:"message,omitempty"`
+	Data    interface{}    `json:"data,omitempty"`
+	Error   string         `json:"error,omitempty"`
+	Code    apperrors.Code `json:"code,omitempty"`
+	// NextCursor continues a paginated list; it is empty on the last page.
+	NextCursor string `json:"next_cursor,omitempty"`
 }
 
 func SendJSON(w http.ResponseWriter, status int, data interface{}) {
@@ -26,9 +30,28 @@ func SendSuccess(w http.ResponseWriter, status int, message string, data interfa
 	})
 }
 
-func SendError(w http.ResponseWriter, status int, message string) {
+// SendPage sends one page of a list with the cursor of the next page
+func SendPage(w http.ResponseWriter, status int, message string, data interface{}, nextCursor string) {
 	SendJSON(w, status, Response{
-		Success: false,
-		Error:   message,
+		Success:    true,
+		Message:    message,
+		Data:       data,
+		NextCursor: nextCursor,
 	})
 }
+
+// SendError sends a problem response with the generic code for status
+func SendError(w http.ResponseWriter, status int, message string) {
+	apperrors.Write(w, apperrors.New(apperrors.CodeForStatus(status), message))
+}
+
+// SendErrorCode sends a problem response for a specific error code
+func SendErrorCode(w http.ResponseWriter, code apperrors.Code, message string) {
+	apperrors.Write(w, apperrors.New(code, message))
+}
+
+// SendAppError classifies err and sends it as a problem response. fallback
+// is the message used when err has no more specific classification.
+func SendAppError(w http.ResponseWriter, err error, fallback string) {
+	apperrors.Write(w, apperrors.Classify(err, fallback))
+}
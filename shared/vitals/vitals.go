@@ -0,0 +1,261 @@
+// Package vitals knows the units vital signs are measured in. Readings are
+// stored in one canonical unit per kind; clients may submit and request any
+// unit the kind accepts and Normalize and Convert translate between them.
+package vitals
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"health-bar/shared/apperrors"
+	"health-bar/shared/models"
+)
+
+// Unit is a unit a kind can be expressed in, defined by its conversion to
+// and from the kind's canonical unit.
+type Unit struct {
+	Name string `json:"name"`
+
+	toCanonical   func(float64) float64
+	fromCanonical func(float64) float64
+}
+
+func canonical(name string) Unit {
+	identity := func(v float64) float64 { return v }
+	return Unit{Name: name, toCanonical: identity, fromCanonical: identity}
+}
+
+func scaled(name string, factor float64) Unit {
+	return Unit{
+		Name:          name,
+		toCanonical:   func(v float64) float64 { return v * factor },
+		fromCanonical: func(v float64) float64 { return v / factor },
+	}
+}
+
+// Range is the span of plausible values in the canonical unit. Readings
+// outside it are rejected as entry mistakes rather than stored.
+type Range struct {
+	Min float64 `json:"min"`
+	Max float64 `json:"max"`
+}
+
+func (r Range) contains(v float64) bool { return v >= r.Min && v <= r.Max }
+
+// Spec describes a kind: its units, the first of which is canonical, and
+// plausible values.
+type Spec struct {
+	Kind      models.VitalKind `json:"kind"`
+	Units     []Unit           `json:"units"`
+	Range     Range            `json:"range"`
+	Diastolic *Range           `json:"diastolic_range,omitempty"`
+}
+
+// Canonical is the unit readings of the kind are stored in.
+func (s Spec) Canonical() string { return s.Units[0].Name }
+
+// Unit looks up one of the kind's units; "" names the canonical unit.
+func (s Spec) Unit(name string) (Unit, bool) {
+	if name == "" {
+		return s.Units[0], true
+	}
+	for _, u := range s.Units {
+		if strings.EqualFold(u.Name, name) {
+			return u, true
+		}
+	}
+	return Unit{}, false
+}
+
+func (s Spec) unitNames() string {
+	names := make([]string, len(s.Units))
+	for i, u := range s.Units {
+		names[i] = u.Name
+	}
+	return strings.Join(names, ", ")
+}
+
+// Conversion factors into the canonical unit.
+const (
+	mmHgPerKPa     = 7.50061683
+	mgdLPerMmolL   = 18.016 // glucose, molar mass 180.16 g/mol
+	kgPerPound     = 0.45359237
+	fahrenheitZero = 32.0
+)
+
+var specs = map[models.VitalKind]Spec{
+	models.VitalBloodPressure: {
+		Kind:      models.VitalBloodPressure,
+		Units:     []Unit{canonical("mmHg"), scaled("kPa", mmHgPerKPa)},
+		Range:     Range{Min: 40, Max: 300},
+		Diastolic: &Range{Min: 20, Max: 200},
+	},
+	models.VitalHeartRate: {
+		Kind:  models.VitalHeartRate,
+		Units: []Unit{canonical("bpm")},
+		Range: Range{Min: 20, Max: 300},
+	},
+	models.VitalGlucose: {
+		Kind:  models.VitalGlucose,
+		Units: []Unit{canonical("mg/dL"), scaled("mmol/L", mgdLPerMmolL)},
+		Range: Range{Min: 10, Max: 1500},
+	},
+	models.VitalWeight: {
+		Kind:  models.VitalWeight,
+		Units: []Unit{canonical("kg"), scaled("lb", kgPerPound)},
+		Range: Range{Min: 0.5, Max: 700},
+	},
+	models.VitalTemperature: {
+		Kind: models.VitalTemperature,
+		Units: []Unit{canonical("C"), {
+			Name:          "F",
+			toCanonical:   func(v float64) float64 { return (v - fahrenheitZero) * 5 / 9 },
+			fromCanonical: func(v float64) float64 { return v*9/5 + fahrenheitZero },
+		}},
+		Range: Range{Min: 25, Max: 45},
+	},
+	models.VitalSpO2: {
+		Kind:  models.VitalSpO2,
+		Units: []Unit{canonical("%")},
+		Range: Range{Min: 50, Max: 100},
+	},
+}
+
+// Lookup returns the spec of kind.
+func Lookup(kind models.VitalKind) (Spec, bool) {
+	spec, ok := specs[kind]
+	return spec, ok
+}
+
+// Specs returns the spec of every kind in display order.
+func Specs() []Spec {
+	out := make([]Spec, len(models.VitalKinds))
+	for i, kind := range models.VitalKinds {
+		out[i] = specs[kind]
+	}
+	return out
+}
+
+// ParseKind validates a kind named by a client.
+func ParseKind(name string) (models.VitalKind, error) {
+	kind := models.VitalKind(name)
+	if !kind.Valid() {
+		return "", apperrors.New(apperrors.CodeValidation, "kind must be one of: "+kindNames())
+	}
+	return kind, nil
+}
+
+func kindNames() string {
+	names := make([]string, len(models.VitalKinds))
+	for i, kind := range models.VitalKinds {
+		names[i] = string(kind)
+	}
+	return strings.Join(names, ", ")
+}
+
+// Normalize validates a reading submitted in unit and rewrites its values
+// and unit to the canonical ones.
+func Normalize(reading *models.VitalReading, unit string) error {
+	spec, ok := Lookup(reading.Kind)
+	if !ok {
+		return apperrors.New(apperrors.CodeValidation, "kind must be one of: "+kindNames())
+	}
+	u, ok := spec.Unit(unit)
+	if !ok {
+		return invalid("unit for %s must be one of: %s", spec.Kind, spec.unitNames())
+	}
+
+	value := round(u.toCanonical(reading.Value), 4)
+	if !spec.Range.contains(value) {
+		return invalid("%s must be between %s and %s %s", spec.Kind,
+			format(u.fromCanonical(spec.Range.Min)), format(u.fromCanonical(spec.Range.Max)), u.Name)
+	}
+
+	switch {
+	case spec.Diastolic == nil && reading.Diastolic != nil:
+		return invalid("diastolic only applies to %s", models.VitalBloodPressure)
+	case spec.Diastolic != nil && reading.Diastolic == nil:
+		return invalid("diastolic is required for %s", spec.Kind)
+	case spec.Diastolic != nil:
+		diastolic := round(u.toCanonical(*reading.Diastolic), 4)
+		if !spec.Diastolic.contains(diastolic) {
+			return invalid("diastolic must be between %s and %s %s",
+				format(u.fromCanonical(spec.Diastolic.Min)), format(u.fromCanonical(spec.Diastolic.Max)), u.Name)
+		}
+		if diastolic >= value {
+			return invalid("diastolic must be lower than systolic")
+		}
+		reading.Diastolic = &diastolic
+	}
+
+	reading.Value = value
+	reading.Unit = spec.Canonical()
+	return nil
+}
+
+// Convert rewrites canonical readings into unit, rounded to two decimals.
+// An empty unit leaves them unchanged.
+func Convert(readings []models.VitalReading, unit string) error {
+	if unit == "" {
+		return nil
+	}
+	for i := range readings {
+		r := &readings[i]
+		convert, name, err := converter(r.Kind, unit)
+		if err != nil {
+			return err
+		}
+		r.Value = convert(r.Value)
+		if r.Diastolic != nil {
+			diastolic := convert(*r.Diastolic)
+			r.Diastolic = &diastolic
+		}
+		r.Unit = name
+	}
+	return nil
+}
+
+// ConvertBuckets rewrites canonical summaries of kind into unit.
+func ConvertBuckets(kind models.VitalKind, buckets []models.VitalBucket, unit string) (string, error) {
+	convert, name, err := converter(kind, unit)
+	if err != nil {
+		return "", err
+	}
+	for i := range buckets {
+		b := &buckets[i]
+		b.Min, b.Max, b.Avg = convert(b.Min), convert(b.Max), convert(b.Avg)
+		for _, v := range []**float64{&b.DiastolicMin, &b.DiastolicMax, &b.DiastolicAvg} {
+			if *v != nil {
+				converted := convert(**v)
+				*v = &converted
+			}
+		}
+	}
+	return name, nil
+}
+
+func converter(kind models.VitalKind, unit string) (func(float64) float64, string, error) {
+	spec, ok := Lookup(kind)
+	if !ok {
+		return nil, "", apperrors.New(apperrors.CodeValidation, "kind must be one of: "+kindNames())
+	}
+	u, ok := spec.Unit(unit)
+	if !ok {
+		return nil, "", invalid("unit for %s must be one of: %s", spec.Kind, spec.unitNames())
+	}
+	return func(v float64) float64 { return round(u.fromCanonical(v), 2) }, u.Name, nil
+}
+
+func invalid(format string, args ...interface{}) error {
+	return apperrors.New(apperrors.CodeValidation, fmt.Sprintf(format, args...))
+}
+
+func round(v float64, places int) float64 {
+	scale := math.Pow(10, float64(places))
+	return math.Round(v*scale) / scale
+}
+
+func format(v float64) string {
+	return fmt.Sprintf("%g", round(v, 1))
+}
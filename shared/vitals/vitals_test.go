@@ -0,0 +1,92 @@
+package vitals
+
+import (
+	"math"
+	"testing"
+
+	"health-bar/shared/apperrors"
+	"health-bar/shared/models"
+)
+
+func ptr(v float64) *float64 { return &v }
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name      string
+		reading   models.VitalReading
+		unit      string
+		value     float64
+		diastolic float64
+		wantErr   bool
+	}{
+		{"canonical by default", models.VitalReading{Kind: models.VitalHeartRate, Value: 72}, "", 72, 0, false},
+		{"pounds", models.VitalReading{Kind: models.VitalWeight, Value: 150}, "lb", 68.0389, 0, false},
+		{"unit is case-insensitive", models.VitalReading{Kind: models.VitalGlucose, Value: 5.5}, "MMOL/L", 99.088, 0, false},
+		{"fahrenheit", models.VitalReading{Kind: models.VitalTemperature, Value: 98.6}, "F", 37, 0, false},
+		{"kilopascal", models.VitalReading{Kind: models.VitalBloodPressure, Value: 16, Diastolic: ptr(10.7)}, "kPa", 120.0099, 80.2566, false},
+		{"unknown unit", models.VitalReading{Kind: models.VitalSpO2, Value: 97}, "ppm", 0, 0, true},
+		{"implausible", models.VitalReading{Kind: models.VitalSpO2, Value: 140}, "", 0, 0, true},
+		{"diastolic missing", models.VitalReading{Kind: models.VitalBloodPressure, Value: 120}, "", 0, 0, true},
+		{"diastolic on heart rate", models.VitalReading{Kind: models.VitalHeartRate, Value: 60, Diastolic: ptr(40)}, "", 0, 0, true},
+		{"diastolic above systolic", models.VitalReading{Kind: models.VitalBloodPressure, Value: 80, Diastolic: ptr(120)}, "", 0, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reading := tt.reading
+			err := Normalize(&reading, tt.unit)
+			if tt.wantErr {
+				if apperrors.CodeOf(err) != apperrors.CodeValidation {
+					t.Fatalf("err = %v, want validation error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			spec, _ := Lookup(reading.Kind)
+			if reading.Value != tt.value || reading.Unit != spec.Canonical() {
+				t.Fatalf("got %v %s, want %v %s", reading.Value, reading.Unit, tt.value, spec.Canonical())
+			}
+			if tt.diastolic != 0 && *reading.Diastolic != tt.diastolic {
+				t.Fatalf("diastolic = %v, want %v", *reading.Diastolic, tt.diastolic)
+			}
+		})
+	}
+}
+
+func TestConvertRoundTrips(t *testing.T) {
+	for _, spec := range Specs() {
+		for _, unit := range spec.Units {
+			reading := models.VitalReading{Kind: spec.Kind, Value: (spec.Range.Min + spec.Range.Max) / 2}
+			if spec.Diastolic != nil {
+				reading.Diastolic = ptr(spec.Diastolic.Min + 10)
+			}
+			in := []models.VitalReading{reading}
+			if err := Convert(in, unit.Name); err != nil {
+				t.Fatal(err)
+			}
+			if err := Normalize(&in[0], unit.Name); err != nil {
+				t.Fatalf("%s in %s: %v", spec.Kind, unit.Name, err)
+			}
+			// Converted values are rounded to two decimals
+			if math.Abs(in[0].Value-reading.Value) > reading.Value/1000 {
+				t.Errorf("%s via %s = %v, want %v", spec.Kind, unit.Name, in[0].Value, reading.Value)
+			}
+		}
+	}
+}
+
+func TestConvertBuckets(t *testing.T) {
+	buckets := []models.VitalBucket{{Count: 2, Min: 36.5, Max: 38, Avg: 37.25}}
+	unit, err := ConvertBuckets(models.VitalTemperature, buckets, "f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unit != "F" || buckets[0].Min != 97.7 || buckets[0].Max != 100.4 || buckets[0].Avg != 99.05 {
+		t.Fatalf("unit %s, buckets %+v", unit, buckets)
+	}
+	if _, err := ConvertBuckets(models.VitalTemperature, buckets, "kg"); err == nil {
+		t.Fatal("converted temperature to kg")
+	}
+}
@@ -0,0 +1,111 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrInternalAddress is returned for endpoints that are not on the public
+// internet. Webhooks are sent from inside the deployment, so an endpoint on
+// loopback, a private network or a cloud metadata address would let a
+// subscriber reach services that are not meant to be exposed.
+var ErrInternalAddress = errors.New("webhook endpoint is not a public address")
+
+// blockedPrefixes are ranges that netip does not classify as private but
+// that are not public either.
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),      // "this network"
+	netip.MustParsePrefix("100.64.0.0/10"),  // carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),   // IETF protocol assignments
+	netip.MustParsePrefix("198.18.0.0/15"),  // benchmarking
+	netip.MustParsePrefix("240.0.0.0/4"),    // reserved
+	netip.MustParsePrefix("64:ff9b::/96"),   // NAT64, embeds IPv4
+	netip.MustParsePrefix("64:ff9b:1::/48"), // local-use NAT64
+	netip.MustParsePrefix("2002::/16"),      // 6to4, embeds IPv4
+}
+
+// Public reports whether addr may receive webhooks.
+func Public(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return false
+	}
+	for _, prefix := range blockedPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// Resolver looks up the addresses of a host; *net.Resolver is one.
+type Resolver interface {
+	LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error)
+}
+
+// CheckHost returns ErrInternalAddress unless allow accepts every address
+// host resolves to. Names without a dot, such as localhost or the service
+// names of the deployment, are refused without being looked up.
+func CheckHost(ctx context.Context, resolver Resolver, host string, allow func(netip.Addr) bool) error {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if addr, err := netip.ParseAddr(strings.Trim(host, "[]")); err == nil {
+		if !allow(addr) {
+			return ErrInternalAddress
+		}
+		return nil
+	}
+	if !strings.Contains(host, ".") || strings.HasSuffix(host, ".localhost") || strings.HasSuffix(host, ".internal") {
+		return ErrInternalAddress
+	}
+
+	addrs, err := resolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return fmt.Errorf("resolve %s: %w", host, err)
+	}
+	for _, addr := range addrs {
+		if !allow(addr) {
+			return ErrInternalAddress
+		}
+	}
+	return nil
+}
+
+// NewClient returns the client deliveries are sent with. It only connects
+// to addresses allow accepts, normally Public, checked on the address
+// actually dialed so that a name resolving elsewhere after subscribing is
+// still refused. Redirects are not followed: a delivery goes to the
+// subscribed URL or fails.
+func NewClient(timeout time.Duration, allow func(netip.Addr) bool) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout, Control: func(network, address string, _ syscall.RawConn) error {
+		addrPort, err := netip.ParseAddrPort(address)
+		if err != nil {
+			return err
+		}
+		if !allow(addrPort.Addr()) {
+			return ErrInternalAddress
+		}
+		return nil
+	}}
+	transport := &http.Transport{
+		// A proxy would be dialed instead of the endpoint
+		Proxy:               nil,
+		DialContext:         dialer.DialContext,
+		TLSHandshakeTimeout: timeout,
+		MaxIdleConns:        100,
+		IdleConnTimeout:     90 * time.Second,
+	}
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
@@ -0,0 +1,47 @@
+package webhooks
+
+import (
+	"context"
+	"slices"
+
+	"github.com/google/uuid"
+
+	"health-bar/shared/memdb"
+	"health-bar/shared/models"
+)
+
+// MemoryEmitter mirrors PostgresEmitter on top of memdb. Inside a memdb
+// transaction its deliveries roll back with everything else.
+type MemoryEmitter struct {
+	db *memdb.DB
+}
+
+func NewMemoryEmitter(db *memdb.DB) *MemoryEmitter {
+	return &MemoryEmitter{db: db}
+}
+
+func (e *MemoryEmitter) Emit(ctx context.Context, events ...Event) error {
+	e.db.Lock()
+	defer e.db.Unlock()
+
+	now := e.db.Now()
+	for _, event := range events {
+		for _, sub := range e.db.WebhookSubscriptions.Rows {
+			if sub.UserID != event.UserID || !slices.Contains(sub.EventTypes, event.Type) {
+				continue
+			}
+			d := models.WebhookDelivery{
+				ID:             uuid.New().String(),
+				SubscriptionID: sub.ID,
+				EventID:        event.ID,
+				EventType:      event.Type,
+				Payload:        event.Payload(),
+				Status:         models.DeliveryPending,
+				NextAttemptAt:  now,
+				CreatedAt:      now,
+			}
+			e.db.WebhookDeliveries.Rows[d.ID] = d
+		}
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package webhooks
+
+import (
+	"context"
+
+	"github.com/jmoiron/sqlx"
+
+	"health-bar/shared/database"
+	"health-bar/shared/models"
+)
+
+// PostgresEmitter stores deliveries in the webhook_deliveries table, which
+// every service shares. It joins the caller's transaction.
+type PostgresEmitter struct {
+	db *sqlx.DB
+}
+
+func NewPostgresEmitter(db *sqlx.DB) *PostgresEmitter {
+	return &PostgresEmitter{db: db}
+}
+
+func (e *PostgresEmitter) Emit(ctx context.Context, events ...Event) error {
+	ctx, cancel := database.WithTimeout(ctx)
+	defer cancel()
+
+	query := `
+		INSERT INTO webhook_deliveries (subscription_id, event_id, event_type, payload)
+		SELECT id, $2, $3, $4
+		FROM webhook_subscriptions
+		WHERE user_id = $1 AND event_types @> jsonb_build_array($3::text)
+	`
+	for _, event := range events {
+		_, err := database.Conn(ctx, e.db).ExecContext(ctx, query,
+			event.UserID, event.ID, event.Type, models.WebhookPayload(event.Payload()))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
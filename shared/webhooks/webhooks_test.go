@@ -0,0 +1,111 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"health-bar/shared/memdb"
+	"health-bar/shared/models"
+)
+
+func TestSignVerify(t *testing.T) {
+	body := []byte(`{"id":"e1","type":"visit.created"}`)
+	sent := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	header := Sign("whsec_a", sent, body)
+	if !strings.HasPrefix(header, "t=1709283600,v1=") {
+		t.Fatalf("header = %q", header)
+	}
+
+	tests := []struct {
+		name   string
+		secret string
+		header string
+		body   []byte
+		now    time.Time
+		valid  bool
+	}{
+		{"valid", "whsec_a", header, body, sent.Add(time.Minute), true},
+		{"one of several signatures", "whsec_a", "v1=00ff," + header, body, sent, true},
+		{"wrong secret", "whsec_b", header, body, sent, false},
+		{"tampered body", "whsec_a", header, []byte(`{"id":"e2"}`), sent, false},
+		{"too old", "whsec_a", header, body, sent.Add(10 * time.Minute), false},
+		{"from the future", "whsec_a", header, body, sent.Add(-10 * time.Minute), false},
+		{"no timestamp", "whsec_a", strings.SplitN(header, ",", 2)[1], body, sent, false},
+		{"garbage", "whsec_a", "nonsense", body, sent, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Verify(tt.secret, tt.header, tt.body, 5*time.Minute, tt.now)
+			if (err == nil) != tt.valid {
+				t.Fatalf("Verify = %v, want valid %v", err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestEventPayload(t *testing.T) {
+	event := VisitCreated("u1", "p1", "v1", "General", time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC))
+
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(event.Payload(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded["type"] != EventVisitCreated || decoded["id"] != event.ID || decoded["user_id"] != nil {
+		t.Fatalf("payload = %s", event.Payload())
+	}
+	if data := decoded["data"].(map[string]interface{}); data["visit_date"] != "2024-03-01" {
+		t.Fatalf("data = %v", data)
+	}
+}
+
+func TestMemoryEmitterMatchesSubscriptions(t *testing.T) {
+	db := memdb.New()
+	doctor := db.AddDoctor("d@test.com", "Dr Who")
+	other := db.AddDoctor("o@test.com", "Dr No")
+	visits := db.AddWebhookSubscription(doctor.UserID, "https://a.test/hook", EventVisitCreated)
+	db.AddWebhookSubscription(doctor.UserID, "https://b.test/hook", EventAccessGranted)
+	db.AddWebhookSubscription(other.UserID, "https://c.test/hook", EventVisitCreated)
+
+	event := VisitCreated(doctor.UserID, "p1", "v1", "General", time.Now())
+	if err := NewMemoryEmitter(db).Emit(context.Background(), event); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(db.WebhookDeliveries.Rows) != 1 {
+		t.Fatalf("deliveries = %+v", db.WebhookDeliveries.Rows)
+	}
+	for _, d := range db.WebhookDeliveries.Rows {
+		if d.SubscriptionID != visits.ID || d.EventID != event.ID || d.Status != models.DeliveryPending || string(d.Payload) != string(event.Payload()) {
+			t.Fatalf("delivery = %+v", d)
+		}
+	}
+}
+
+func TestPublic(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"203.0.113.10":    true,
+		"2606:4700::1111": true,
+		"127.0.0.1":       false,
+		"10.1.2.3":        false,
+		"172.18.0.5":      false,
+		"192.168.1.1":     false,
+		"169.254.169.254": false,
+		"100.64.0.1":      false,
+		"0.0.0.0":         false,
+		"::1":             false,
+		"fd00::1":         false,
+		"fe80::1":         false,
+		"::ffff:10.0.0.1": false,
+		"64:ff9b::a00:1":  false,
+		"255.255.255.255": false,
+		"224.0.0.1":       false,
+	} {
+		if got := Public(netip.MustParseAddr(addr)); got != want {
+			t.Errorf("Public(%s) = %v, want %v", addr, got, want)
+		}
+	}
+}
@@ -0,0 +1,861 @@
+package e2e
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"image"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"health-bar/shared/apperrors"
+	"health-bar/shared/idempotency"
+	"health-bar/shared/models"
+	"health-bar/shared/patch"
+	"health-bar/shared/webhooks"
+	"health-bar/tests/harness"
+)
+
+func TestMain(m *testing.M) { os.Exit(harness.Main(m)) }
+
+func TestRegisterLogin(t *testing.T) {
+	h := harness.New(t)
+	actor := h.User().Email("login@test.local").Password("s3cret-pass").Create(t)
+
+	var me models.User
+	actor.Do(http.MethodGet, "/api/auth/me", nil).Expect(t, http.StatusOK).Decode(t, &me)
+	if me.ID != actor.User.ID || me.Email != "login@test.local" {
+		t.Fatalf("me = %+v, want %+v", me, actor.User)
+	}
+
+	resp := h.Client("").Do(http.MethodPost, "/api/auth/register", map[string]string{
+		"email": "login@test.local", "password": "another", "role": "patient",
+	}).Expect(t, http.StatusConflict)
+	if resp.Envelope.Code != apperrors.CodeEmailTaken {
+		t.Fatalf("duplicate register code = %q", resp.Envelope.Code)
+	}
+
+	h.Client("").Do(http.MethodPost, "/api/auth/login", map[string]string{
+		"email": "login@test.local", "password": "wrong",
+	}).Expect(t, http.StatusUnauthorized)
+
+	resp = h.Client("garbage").Do(http.MethodGet, "/api/patients/profile", nil).Expect(t, http.StatusUnauthorized)
+	if resp.Envelope.Code != apperrors.CodeInvalidToken || resp.Header.Get("Content-Type") != apperrors.ProblemContentType {
+		t.Fatalf("invalid token response = %d %s %s", resp.Status, resp.Header.Get("Content-Type"), resp.Body)
+	}
+}
+
+func TestDoctorAccessLifecycle(t *testing.T) {
+	h := harness.New(t)
+
+	patient, profile := h.Patient(t)
+	doctor, doctorProfile := h.Doctor(t)
+
+	visit := h.Visit(patient).Hospital("St. Mary's").On("2024-03-02").Reason("Fever").Notes("Prescribed rest").Create(t)
+	content := []byte("%PDF-1.4\n% amoxicillin 500mg\n%%EOF\n")
+	prescription := h.Prescription(patient).File("amoxicillin.pdf", content).Create(t)
+
+	assertDoctorDenied := func(t *testing.T) {
+		t.Helper()
+
+		doctor.Do(http.MethodGet, "/api/timeline/patient?patient_id="+profile.ID, nil).Expect(t, http.StatusForbidden)
+		doctor.Do(http.MethodGet, "/api/timeline/visit?visit_id="+visit.ID, nil).Expect(t, http.StatusForbidden)
+		doctor.Do(http.MethodGet, "/api/doctors/patients/view?patient_id="+profile.ID, nil).Expect(t, http.StatusForbidden)
+		doctor.Do(http.MethodGet, "/api/prescriptions/patient?patient_id="+profile.ID, nil).Expect(t, http.StatusForbidden)
+		doctor.Do(http.MethodGet, "/api/prescriptions/download?id="+prescription.ID, nil).Expect(t, http.StatusForbidden)
+	}
+
+	t.Run("denied before grant", assertDoctorDenied)
+
+	t.Run("grant", func(t *testing.T) {
+		doctor.Do(http.MethodPost, "/api/patients/permissions/grant", map[string]string{
+			"doctor_id": doctorProfile.ID,
+		}).Expect(t, http.StatusForbidden)
+
+		patient.Do(http.MethodPost, "/api/patients/permissions/grant", map[string]string{
+			"doctor_id": doctorProfile.ID,
+		}).Expect(t, http.StatusOK)
+
+		var patients []models.PatientProfile
+		doctor.Do(http.MethodGet, "/api/doctors/patients", nil).Expect(t, http.StatusOK).Decode(t, &patients)
+		if len(patients) != 1 || patients[0].ID != profile.ID {
+			t.Fatalf("accessible patients = %+v", patients)
+		}
+	})
+
+	t.Run("doctor reads records", func(t *testing.T) {
+		var visits []models.HospitalVisit
+		doctor.Do(http.MethodGet, "/api/timeline/patient?patient_id="+profile.ID, nil).Expect(t, http.StatusOK).Decode(t, &visits)
+		if len(visits) != 1 || visits[0].ID != visit.ID || visits[0].HospitalName != "St. Mary's" {
+			t.Fatalf("timeline = %+v", visits)
+		}
+
+		var viewed models.PatientProfile
+		doctor.Do(http.MethodGet, "/api/doctors/patients/view?patient_id="+profile.ID, nil).Expect(t, http.StatusOK).Decode(t, &viewed)
+		if viewed.FullName != profile.FullName {
+			t.Fatalf("viewed profile = %+v, want %+v", viewed, profile)
+		}
+
+		var prescriptions []models.Prescription
+		doctor.Do(http.MethodGet, "/api/prescriptions/patient?patient_id="+profile.ID, nil).Expect(t, http.StatusOK).Decode(t, &prescriptions)
+		if len(prescriptions) != 1 || prescriptions[0].ID != prescription.ID {
+			t.Fatalf("prescriptions = %+v", prescriptions)
+		}
+
+		download := doctor.Do(http.MethodGet, "/api/prescriptions/download?id="+prescription.ID, nil).Expect(t, http.StatusOK)
+		if !bytes.Equal(download.Body, content) {
+			t.Fatalf("downloaded %q, want %q", download.Body, content)
+		}
+
+		// Read access does not extend to editing the patient's timeline
+		doctor.Do(http.MethodPut, "/api/timeline/visit?visit_id="+visit.ID, map[string]string{
+			"hospital_name": "Elsewhere", "visit_date": "2024-03-02",
+		}).Expect(t, http.StatusForbidden)
+	})
+
+	t.Run("revoke", func(t *testing.T) {
+		patient.Do(http.MethodDelete, "/api/patients/permissions/revoke?doctor_id="+doctorProfile.ID, nil).Expect(t, http.StatusOK)
+
+		var patients []models.PatientProfile
+		doctor.Do(http.MethodGet, "/api/doctors/patients", nil).Expect(t, http.StatusOK).Decode(t, &patients)
+		if len(patients) != 0 {
+			t.Fatalf("accessible patients after revoke = %+v", patients)
+		}
+	})
+
+	t.Run("denied after revoke", assertDoctorDenied)
+}
+
+func TestPatientsAreIsolated(t *testing.T) {
+	h := harness.New(t)
+
+	alice, _ := h.Patient(t)
+	bob, _ := h.Patient(t)
+
+	visit := h.Visit(alice).Create(t)
+	prescription := h.Prescription(alice).Create(t)
+
+	bob.Do(http.MethodGet, "/api/timeline/visit?visit_id="+visit.ID, nil).Expect(t, http.StatusForbidden)
+	bob.Do(http.MethodDelete, "/api/timeline/visit?visit_id="+visit.ID, nil).Expect(t, http.StatusForbidden)
+	bob.Do(http.MethodGet, "/api/prescriptions/download?id="+prescription.ID, nil).Expect(t, http.StatusForbidden)
+	bob.Do(http.MethodDelete, "/api/prescriptions?id="+prescription.ID, nil).Expect(t, http.StatusForbidden)
+
+	var visits []models.HospitalVisit
+	bob.Do(http.MethodGet, "/api/timeline/my", nil).Expect(t, http.StatusOK).Decode(t, &visits)
+	if len(visits) != 0 {
+		t.Fatalf("bob sees alice's visits: %+v", visits)
+	}
+}
+
+func TestListPagination(t *testing.T) {
+	h := harness.New(t)
+	patient, _ := h.Patient(t)
+
+	for _, date := range []string{"2024-01-10", "2024-02-10", "2024-03-10", "2024-04-10", "2024-05-10"} {
+		h.Visit(patient).On(date).Create(t)
+	}
+	for i := 0; i < 3; i++ {
+		h.Prescription(patient).Create(t)
+	}
+	var scan bytes.Buffer
+	png.Encode(&scan, image.NewGray(image.Rect(0, 0, 4, 4)))
+	h.Prescription(patient).File("scan.png", scan.Bytes()).Create(t)
+
+	var seen []string
+	path := "/api/timeline/my?limit=2"
+	for page := 0; ; page++ {
+		if page > 3 {
+			t.Fatalf("timeline cursor never ran out")
+		}
+		resp := patient.Do(http.MethodGet, path, nil).Expect(t, http.StatusOK)
+		var visits []models.HospitalVisit
+		resp.Decode(t, &visits)
+		for _, v := range visits {
+			seen = append(seen, v.VisitDate.Format("2006-01-02"))
+		}
+		if resp.Envelope.NextCursor == "" {
+			break
+		}
+		path = "/api/timeline/my?limit=2&cursor=" + resp.Envelope.NextCursor
+	}
+	want := []string{"2024-05-10", "2024-04-10", "2024-03-10", "2024-02-10", "2024-01-10"}
+	if len(seen) != len(want) {
+		t.Fatalf("visits across pages = %v, want %v", seen, want)
+	}
+	for i := range want {
+		if seen[i] != want[i] {
+			t.Fatalf("visits across pages = %v, want %v", seen, want)
+		}
+	}
+
+	var pdfs []models.Prescription
+	patient.Do(http.MethodGet, "/api/prescriptions/my?file_type=pdf&sort=file_size", nil).Expect(t, http.StatusOK).Decode(t, &pdfs)
+	if len(pdfs) != 3 {
+		t.Fatalf("pdf prescriptions = %+v", pdfs)
+	}
+
+	patient.Do(http.MethodGet, "/api/timeline/my?cursor=bogus", nil).Expect(t, http.StatusBadRequest)
+}
+
+func TestConcurrentEdits(t *testing.T) {
+	h := harness.New(t)
+	patient, _ := h.Patient(t)
+	visit := h.Visit(patient).Hospital("General").Reason("Check-up").Notes("Bring scans").Create(t)
+
+	path := "/api/timeline/visit?visit_id=" + visit.ID
+	etag := patient.Do(http.MethodGet, path, nil).Expect(t, http.StatusOK).Header.Get("ETag")
+	if etag == "" {
+		t.Fatalf("GET visit returned no ETag")
+	}
+
+	// Two clients edit the same version; the second write loses
+	headers := map[string]string{"Content-Type": patch.ContentType, "If-Match": etag}
+	var patched models.HospitalVisit
+	patient.DoWithHeaders(http.MethodPatch, path, map[string]string{"reason": "Follow-up"}, headers).
+		Expect(t, http.StatusOK).Decode(t, &patched)
+	if patched.Reason != "Follow-up" || patched.Notes != "Bring scans" || patched.HospitalName != "General" {
+		t.Fatalf("patched visit = %+v", patched)
+	}
+
+	resp := patient.DoWithHeaders(http.MethodPatch, path, map[string]string{"notes": "Lost"}, headers).
+		Expect(t, http.StatusPreconditionFailed)
+	if resp.Envelope.Code != apperrors.CodePreconditionFailed {
+		t.Fatalf("stale write code = %q", resp.Envelope.Code)
+	}
+
+	profile := patient.Do(http.MethodGet, "/api/patients/profile", nil).Expect(t, http.StatusOK)
+	patient.DoWithHeaders(http.MethodPatch, "/api/patients/profile", map[string]string{"phone": "555-0100"},
+		map[string]string{"Content-Type": patch.ContentType, "If-Match": profile.Header.Get("ETag")}).Expect(t, http.StatusOK)
+}
+
+func TestIdempotentCreates(t *testing.T) {
+	h := harness.New(t)
+	patient, _ := h.Patient(t)
+
+	first := h.Visit(patient).On("2024-06-01").IdempotencyKey("visit-1").Create(t)
+	retry := h.Visit(patient).On("2024-06-01").IdempotencyKey("visit-1").Create(t)
+	if retry.ID != first.ID {
+		t.Fatalf("retried visit created %s, want replay of %s", retry.ID, first.ID)
+	}
+
+	resp := patient.DoWithHeaders(http.MethodPost, "/api/timeline/visits", map[string]string{
+		"hospital_name": "Elsewhere", "visit_date": "2024-06-02", "reason": "Other",
+	}, map[string]string{idempotency.Header: "visit-1"}).Expect(t, http.StatusUnprocessableEntity)
+	if resp.Envelope.Code != apperrors.CodeIdempotencyReused {
+		t.Fatalf("reused key code = %q", resp.Envelope.Code)
+	}
+
+	// Every attempt carries a fresh multipart boundary
+	upload := h.Prescription(patient).File("rx.pdf", []byte("%PDF-1.4\n%%EOF\n")).IdempotencyKey("upload-1").Create(t)
+	again := h.Prescription(patient).File("rx.pdf", []byte("%PDF-1.4\n%%EOF\n")).IdempotencyKey("upload-1").Create(t)
+	if again.ID != upload.ID {
+		t.Fatalf("retried upload created %s, want replay of %s", again.ID, upload.ID)
+	}
+
+	var visits []models.HospitalVisit
+	patient.Do(http.MethodGet, "/api/timeline/my", nil).Expect(t, http.StatusOK).Decode(t, &visits)
+	var prescriptions []models.Prescription
+	patient.Do(http.MethodGet, "/api/prescriptions/my", nil).Expect(t, http.StatusOK).Decode(t, &prescriptions)
+	if len(visits) != 1 || len(prescriptions) != 1 {
+		t.Fatalf("after retries: %d visits, %d prescriptions; want 1 each", len(visits), len(prescriptions))
+	}
+}
+
+func TestClinicalRecordSharedWithDoctor(t *testing.T) {
+	h := harness.New(t)
+	patient, profile := h.Patient(t)
+	doctor, doctorProfile := h.Doctor(t)
+
+	patient.Do(http.MethodPost, "/api/patients/allergies", map[string]string{
+		"substance": "Penicillin", "reaction": "Hives", "severity": "severe",
+	}).Expect(t, http.StatusCreated)
+	patient.Do(http.MethodPost, "/api/patients/conditions", map[string]string{
+		"name": "Asthma", "diagnosed_on": "2010-05-01",
+	}).Expect(t, http.StatusCreated)
+	patient.Do(http.MethodPost, "/api/patients/medications", map[string]string{
+		"name": "Salbutamol", "dosage": "100mcg", "frequency": "as needed",
+	}).Expect(t, http.StatusCreated)
+	patient.Do(http.MethodPost, "/api/patients/emergency-contacts", map[string]interface{}{
+		"name": "Sam", "phone": "555-0100", "is_primary": true,
+	}).Expect(t, http.StatusCreated)
+	patient.Do(http.MethodPut, "/api/patients/biometrics", map[string]interface{}{
+		"blood_type": "O+", "height_cm": 168.5, "weight_kg": 61,
+	}).Expect(t, http.StatusOK)
+
+	doctor.Do(http.MethodGet, "/api/doctors/patients/view?patient_id="+profile.ID, nil).Expect(t, http.StatusForbidden)
+	patient.Do(http.MethodPost, "/api/patients/permissions/grant", map[string]string{"doctor_id": doctorProfile.ID}).Expect(t, http.StatusOK)
+
+	var chart models.PatientChart
+	doctor.Do(http.MethodGet, "/api/doctors/patients/view?patient_id="+profile.ID, nil).Expect(t, http.StatusOK).Decode(t, &chart)
+	if chart.ID != profile.ID || len(chart.Allergies) != 1 || len(chart.Conditions) != 1 ||
+		len(chart.Medications) != 1 || len(chart.EmergencyContacts) != 1 {
+		t.Fatalf("chart = %+v", chart)
+	}
+	if chart.Conditions[0].Status != models.ConditionActive || chart.Conditions[0].DiagnosedOn == nil {
+		t.Fatalf("condition = %+v", chart.Conditions[0])
+	}
+	if chart.Biometrics == nil || chart.Biometrics.BloodType != "O+" || *chart.Biometrics.HeightCM != 168.5 {
+		t.Fatalf("biometrics = %+v", chart.Biometrics)
+	}
+}
+
+func TestVitalsSharedWithDoctor(t *testing.T) {
+	h := harness.New(t)
+	patient, profile := h.Patient(t)
+	doctor, doctorProfile := h.Doctor(t)
+
+	reading := func(kind string, value float64, unit, at string) map[string]interface{} {
+		return map[string]interface{}{"kind": kind, "value": value, "unit": unit, "measured_at": at}
+	}
+	batch := map[string]interface{}{"readings": []map[string]interface{}{
+		reading("weight", 70, "kg", "2024-03-04T07:00:00Z"),
+		reading("weight", 156.5, "lb", "2024-03-05T07:00:00Z"),
+		reading("weight", 71, "kg", "2024-03-11T07:00:00Z"),
+		{"kind": "blood_pressure", "value": 118, "diastolic": 76, "measured_at": "2024-03-04T07:05:00Z"},
+	}}
+	var created struct {
+		Readings []models.VitalReading `json:"readings"`
+		Skipped  int                   `json:"skipped"`
+	}
+	patient.Do(http.MethodPost, "/api/timeline/vitals", batch).Expect(t, http.StatusCreated).Decode(t, &created)
+	if len(created.Readings) != 4 || created.Skipped != 0 {
+		t.Fatalf("created = %+v", created)
+	}
+	patient.Do(http.MethodPost, "/api/timeline/vitals", batch).Expect(t, http.StatusCreated).Decode(t, &created)
+	if len(created.Readings) != 0 || created.Skipped != 4 {
+		t.Fatalf("resent = %+v", created)
+	}
+
+	summary := "/api/timeline/vitals/summary?patient_id=" + profile.ID + "&kind=weight&bucket=week&from=2024-03-01&to=2024-03-31"
+	doctor.Do(http.MethodGet, summary, nil).Expect(t, http.StatusForbidden)
+	patient.Do(http.MethodPost, "/api/patients/permissions/grant", map[string]string{"doctor_id": doctorProfile.ID}).Expect(t, http.StatusOK)
+
+	var weekly struct {
+		Unit    string               `json:"unit"`
+		Buckets []models.VitalBucket `json:"buckets"`
+	}
+	doctor.Do(http.MethodGet, summary, nil).Expect(t, http.StatusOK).Decode(t, &weekly)
+	if len(weekly.Buckets) != 2 || weekly.Buckets[0].Count != 2 || weekly.Buckets[0].Min != 70 || weekly.Buckets[0].Max != 70.99 {
+		t.Fatalf("weekly = %+v", weekly)
+	}
+
+	var readings []models.VitalReading
+	doctor.Do(http.MethodGet, "/api/timeline/vitals/patient?patient_id="+profile.ID+"&kind=blood_pressure", nil).
+		Expect(t, http.StatusOK).Decode(t, &readings)
+	if len(readings) != 1 || readings[0].Value != 118 || *readings[0].Diastolic != 76 {
+		t.Fatalf("readings = %+v", readings)
+	}
+}
+
+func TestHealthScoreHistory(t *testing.T) {
+	h := harness.New(t)
+	patient, _ := h.Patient(t)
+
+	type score struct {
+		Score         int                        `json:"score"`
+		RulesVersion  string                     `json:"rules_version"`
+		Contributions []models.ScoreContribution `json:"contributions"`
+		AssessmentDue bool                       `json:"assessment_due"`
+	}
+	var before score
+	patient.Do(http.MethodGet, "/api/patients/profile/score", nil).Expect(t, http.StatusOK).Decode(t, &before)
+	if before.RulesVersion == "" || len(before.Contributions) == 0 || !before.AssessmentDue {
+		t.Fatalf("before = %+v", before)
+	}
+
+	h.Visit(patient).Reason("Annual check-up").On(time.Now().AddDate(0, -1, 0).Format("2006-01-02")).Create(t)
+	var after struct {
+		HealthScore score `json:"health_score"`
+	}
+	patient.Do(http.MethodPost, "/api/patients/assessments", map[string]int{
+		"general_health": 5, "energy": 4, "sleep": 4, "mood": 4, "pain": 1,
+	}).Expect(t, http.StatusCreated).Decode(t, &after)
+	if after.HealthScore.Score <= before.Score || after.HealthScore.AssessmentDue {
+		t.Fatalf("before %+v, after %+v", before, after.HealthScore)
+	}
+
+	var history []models.HealthScore
+	patient.Do(http.MethodGet, "/api/patients/profile/score/history", nil).Expect(t, http.StatusOK).Decode(t, &history)
+	if len(history) != 2 || history[0].Score != after.HealthScore.Score || history[1].Score != before.Score {
+		t.Fatalf("history = %+v", history)
+	}
+}
+
+func TestLabResultsFlaggedAndTrended(t *testing.T) {
+	h := harness.New(t)
+	patient, profile := h.Patient(t)
+	doctor, doctorProfile := h.Doctor(t)
+	visit := h.Visit(patient).Reason("Blood work").On("2024-02-01").Create(t)
+
+	panel := func(date string, glucose float64) map[string]interface{} {
+		return map[string]interface{}{
+			"name": "Metabolic panel", "collected_at": date, "visit_id": visit.ID,
+			"results": []map[string]interface{}{
+				{"analyte": "glucose", "value": glucose, "unit": "mmol/L"},
+				{"analyte": "sodium", "value": 140},
+			},
+		}
+	}
+	var created models.LabPanel
+	patient.Do(http.MethodPost, "/api/timeline/labs", panel("2024-02-01", 5)).Expect(t, http.StatusCreated).Decode(t, &created)
+	if len(created.Results) != 2 || created.Results[0].Flag != models.LabNormal {
+		t.Fatalf("created = %+v", created)
+	}
+	patient.Do(http.MethodPost, "/api/timeline/labs", panel("2024-05-01", 7.5)).Expect(t, http.StatusCreated)
+
+	var abnormal []models.LabPanel
+	patient.Do(http.MethodGet, "/api/timeline/labs/my?abnormal=true", nil).Expect(t, http.StatusOK).Decode(t, &abnormal)
+	if len(abnormal) != 1 || abnormal[0].Results[0].Flag != models.LabHigh {
+		t.Fatalf("abnormal = %+v", abnormal)
+	}
+
+	trend := "/api/timeline/labs/trend?analyte=glucose&patient_id=" + profile.ID
+	doctor.Do(http.MethodGet, trend, nil).Expect(t, http.StatusForbidden)
+	patient.Do(http.MethodPost, "/api/patients/permissions/grant", map[string]string{"doctor_id": doctorProfile.ID}).Expect(t, http.StatusOK)
+
+	var series struct {
+		Unit      string             `json:"unit"`
+		Results   []models.LabResult `json:"results"`
+		Direction string             `json:"direction"`
+	}
+	doctor.Do(http.MethodGet, trend, nil).Expect(t, http.StatusOK).Decode(t, &series)
+	if len(series.Results) != 2 || series.Unit != "mg/dL" || series.Direction != "rising" {
+		t.Fatalf("trend = %+v", series)
+	}
+}
+
+func TestImmunizationsDue(t *testing.T) {
+	h := harness.New(t)
+	patient, profile := h.Patient(t)
+	doctor, doctorProfile := h.Doctor(t)
+
+	var first, second models.Immunization
+	patient.Do(http.MethodPost, "/api/timeline/immunizations", map[string]string{
+		"vaccine": "tdap", "administered_on": "2010-03-01", "lot_number": "TD-1", "facility": "City Clinic",
+	}).Expect(t, http.StatusCreated).Decode(t, &first)
+	patient.Do(http.MethodPost, "/api/timeline/immunizations", map[string]string{
+		"vaccine": "tdap", "administered_on": "2020-03-01",
+	}).Expect(t, http.StatusCreated).Decode(t, &second)
+	if first.DoseNumber != 1 || second.DoseNumber != 2 {
+		t.Fatalf("doses = %d, %d", first.DoseNumber, second.DoseNumber)
+	}
+	patient.Do(http.MethodPost, "/api/timeline/immunizations", map[string]interface{}{
+		"vaccine": "tdap", "dose_number": 2, "administered_on": "2021-03-01",
+	}).Expect(t, http.StatusConflict)
+
+	due := "/api/timeline/immunizations/due?within=0&patient_id=" + profile.ID
+	doctor.Do(http.MethodGet, due, nil).Expect(t, http.StatusForbidden)
+	patient.Do(http.MethodPost, "/api/patients/permissions/grant", map[string]string{"doctor_id": doctorProfile.ID}).Expect(t, http.StatusOK)
+
+	var resp struct {
+		Doses []struct {
+			Vaccine string `json:"vaccine"`
+		} `json:"doses"`
+	}
+	doctor.Do(http.MethodGet, due, nil).Expect(t, http.StatusOK).Decode(t, &resp)
+	for _, d := range resp.Doses {
+		if d.Vaccine == "tdap" {
+			t.Fatalf("tdap booster is not due until 2030: %+v", resp.Doses)
+		}
+	}
+}
+
+func TestEPrescriptionsIssuedByDoctor(t *testing.T) {
+	h := harness.New(t)
+	patient, profile := h.Patient(t)
+	doctor, doctorProfile := h.Doctor(t)
+	scan := h.Prescription(patient).File("rx.pdf", []byte("%PDF-1.4\n%%EOF\n")).Create(t)
+
+	order := map[string]interface{}{
+		"patient_id": profile.ID, "drug": "Amoxicillin", "strength": "500 mg", "route": "oral",
+		"frequency": "three times daily", "duration_days": 7, "refills": 1, "document_id": scan.ID,
+	}
+	doctor.Do(http.MethodPost, "/api/prescriptions/issue", order).Expect(t, http.StatusForbidden)
+	patient.Do(http.MethodPost, "/api/patients/permissions/grant", map[string]string{"doctor_id": doctorProfile.ID}).Expect(t, http.StatusOK)
+
+	var issued models.EPrescription
+	doctor.Do(http.MethodPost, "/api/prescriptions/issue", order).Expect(t, http.StatusCreated).Decode(t, &issued)
+	if issued.DoctorID == nil || *issued.DoctorID != doctorProfile.ID || issued.Status != models.PrescriptionActive {
+		t.Fatalf("issued = %+v", issued)
+	}
+
+	var mine []models.EPrescription
+	patient.Do(http.MethodGet, "/api/prescriptions/my?kind=issued", nil).Expect(t, http.StatusOK).Decode(t, &mine)
+	if len(mine) != 1 || mine[0].ID != issued.ID || *mine[0].DocumentID != scan.ID {
+		t.Fatalf("patient's issued prescriptions = %+v", mine)
+	}
+
+	doctor.Do(http.MethodPost, "/api/prescriptions/issued/cancel?id="+issued.ID, map[string]string{"reason": "Allergy"}).Expect(t, http.StatusOK)
+	var active []models.EPrescription
+	doctor.Do(http.MethodGet, "/api/prescriptions/issued?status=active&patient_id="+profile.ID, nil).Expect(t, http.StatusOK).Decode(t, &active)
+	if len(active) != 0 {
+		t.Fatalf("active after cancel = %+v", active)
+	}
+}
+
+func TestInteractionOverrideRequired(t *testing.T) {
+	h := harness.New(t)
+	patient, profile := h.Patient(t)
+	doctor, doctorProfile := h.Doctor(t)
+
+	patient.Do(http.MethodPost, "/api/patients/medications", map[string]string{
+		"name": "Warfarin", "dosage": "5mg", "frequency": "daily",
+	}).Expect(t, http.StatusCreated)
+	patient.Do(http.MethodPost, "/api/patients/permissions/grant", map[string]string{"doctor_id": doctorProfile.ID}).Expect(t, http.StatusOK)
+
+	var check struct {
+		Warnings []struct {
+			Severity string `json:"severity"`
+		} `json:"warnings"`
+		RequiresOverride bool `json:"requires_override"`
+	}
+	doctor.Do(http.MethodGet, "/api/prescriptions/interactions?drug=naproxen&patient_id="+profile.ID, nil).Expect(t, http.StatusOK).Decode(t, &check)
+	if !check.RequiresOverride || len(check.Warnings) != 1 || check.Warnings[0].Severity != "high" {
+		t.Fatalf("check = %+v", check)
+	}
+
+	order := map[string]interface{}{
+		"patient_id": profile.ID, "drug": "Naproxen", "strength": "250 mg", "route": "oral", "frequency": "twice daily",
+	}
+	resp := doctor.Do(http.MethodPost, "/api/prescriptions/issue", order).Expect(t, http.StatusConflict)
+	if resp.Envelope.Code != apperrors.CodeOverrideRequired {
+		t.Fatalf("code = %q", resp.Envelope.Code)
+	}
+
+	order["override_reason"] = "Short course with INR monitoring"
+	var issued models.EPrescription
+	doctor.Do(http.MethodPost, "/api/prescriptions/issue", order).Expect(t, http.StatusCreated).Decode(t, &issued)
+	if issued.OverrideReason == "" || issued.InteractionsVersion == "" {
+		t.Fatalf("issued = %+v", issued)
+	}
+}
+
+func TestMedicationAdherence(t *testing.T) {
+	h := harness.New(t)
+	patient, profile := h.Patient(t)
+	doctor, doctorProfile := h.Doctor(t)
+
+	yesterday := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, -1)
+	var schedule models.MedicationSchedule
+	patient.Do(http.MethodPost, "/api/prescriptions/schedules", map[string]interface{}{
+		"medication": "Metformin", "dose": "500 mg", "times": []string{"08:00", "20:00"},
+		"timezone": "UTC", "start_date": yesterday.Format("2006-01-02"),
+	}).Expect(t, http.StatusCreated).Decode(t, &schedule)
+
+	var event models.DoseEvent
+	patient.Do(http.MethodPost, "/api/prescriptions/doses", map[string]interface{}{
+		"schedule_id": schedule.ID, "scheduled_for": yesterday.Add(8 * time.Hour), "status": "taken",
+		"taken_at": yesterday.Add(10 * time.Hour),
+	}).Expect(t, http.StatusOK).Decode(t, &event)
+	if event.Status != models.DoseLate || !event.ScheduledFor.Equal(yesterday.Add(8*time.Hour)) {
+		t.Fatalf("event = %+v", event)
+	}
+	patient.Do(http.MethodPost, "/api/prescriptions/doses", map[string]interface{}{
+		"schedule_id": schedule.ID, "scheduled_for": yesterday.Add(20 * time.Hour), "status": "skipped",
+	}).Expect(t, http.StatusOK)
+
+	target := "/api/prescriptions/adherence?period=day&from=" + yesterday.Format("2006-01-02") + "&to=" + yesterday.Format("2006-01-02") + "&patient_id=" + profile.ID
+	doctor.Do(http.MethodGet, target, nil).Expect(t, http.StatusForbidden)
+	patient.Do(http.MethodPost, "/api/patients/permissions/grant", map[string]string{"doctor_id": doctorProfile.ID}).Expect(t, http.StatusOK)
+
+	var report struct {
+		Overall struct {
+			Due       int      `json:"due"`
+			Late      int      `json:"late"`
+			Skipped   int      `json:"skipped"`
+			Adherence *float64 `json:"adherence_pct"`
+		} `json:"overall"`
+	}
+	doctor.Do(http.MethodGet, target, nil).Expect(t, http.StatusOK).Decode(t, &report)
+	if o := report.Overall; o.Due != 2 || o.Late != 1 || o.Skipped != 1 || o.Adherence == nil || *o.Adherence != 50 {
+		t.Fatalf("overall = %+v", o)
+	}
+}
+
+func TestAppointmentBookingConflicts(t *testing.T) {
+	h := harness.New(t)
+	patient, _ := h.Patient(t)
+	other, _ := h.Patient(t)
+	doctor, doctorProfile := h.Doctor(t)
+
+	var weekly []map[string]interface{}
+	for day := 0; day < 7; day++ {
+		weekly = append(weekly, map[string]interface{}{"weekday": day, "start": "00:00", "end": "24:00"})
+	}
+	doctor.Do(http.MethodPut, "/api/doctors/availability", map[string]interface{}{
+		"timezone": "UTC", "slot_minutes": 30, "weekly": weekly,
+	}).Expect(t, http.StatusOK)
+
+	startsAt := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, 2).Add(9 * time.Hour)
+	booking := map[string]interface{}{"doctor_id": doctorProfile.ID, "starts_at": startsAt}
+	var appointment models.Appointment
+	patient.Do(http.MethodPost, "/api/doctors/appointments", booking).Expect(t, http.StatusCreated).Decode(t, &appointment)
+	if !appointment.EndsAt.Equal(startsAt.Add(30 * time.Minute)) {
+		t.Fatalf("appointment = %+v", appointment)
+	}
+
+	resp := other.Do(http.MethodPost, "/api/doctors/appointments", booking).Expect(t, http.StatusConflict)
+	if resp.Envelope.Code != apperrors.CodeConflict {
+		t.Fatalf("double booking code = %q", resp.Envelope.Code)
+	}
+
+	patient.Do(http.MethodPost, "/api/doctors/appointments/cancel?id="+appointment.ID, nil).Expect(t, http.StatusOK)
+	other.Do(http.MethodPost, "/api/doctors/appointments", booking).Expect(t, http.StatusCreated)
+}
+
+func TestMessagingFrozenOnRevoke(t *testing.T) {
+	h := harness.New(t)
+	patient, _ := h.Patient(t)
+	doctor, doctorProfile := h.Doctor(t)
+
+	patient.Do(http.MethodPost, "/api/messages/threads", map[string]string{"doctor_id": doctorProfile.ID}).Expect(t, http.StatusForbidden)
+	patient.Do(http.MethodPost, "/api/patients/permissions/grant", map[string]string{"doctor_id": doctorProfile.ID}).Expect(t, http.StatusOK)
+
+	var thread models.MessageThread
+	patient.Do(http.MethodPost, "/api/messages/threads", map[string]string{"doctor_id": doctorProfile.ID}).Expect(t, http.StatusOK).Decode(t, &thread)
+	target := "/api/messages?thread_id=" + thread.ID
+	patient.Do(http.MethodPost, target, map[string]string{"body": "Are my results in?"}).Expect(t, http.StatusCreated)
+	doctor.Do(http.MethodPost, target, map[string]string{"body": "Yes, all normal"}).Expect(t, http.StatusCreated)
+
+	var receipt struct {
+		Marked int `json:"marked"`
+	}
+	patient.Do(http.MethodPost, "/api/messages/read?thread_id="+thread.ID, nil).Expect(t, http.StatusOK).Decode(t, &receipt)
+	if receipt.Marked != 1 {
+		t.Fatalf("marked %d messages read, want 1", receipt.Marked)
+	}
+
+	patient.Do(http.MethodDelete, "/api/patients/permissions/revoke?doctor_id="+doctorProfile.ID, nil).Expect(t, http.StatusOK)
+	resp := doctor.Do(http.MethodPost, target, map[string]string{"body": "Follow-up?"}).Expect(t, http.StatusConflict)
+	if resp.Envelope.Code != apperrors.CodeConflict {
+		t.Fatalf("post to frozen thread code = %q", resp.Envelope.Code)
+	}
+
+	var messages []models.Message
+	doctor.Do(http.MethodGet, target, nil).Expect(t, http.StatusOK).Decode(t, &messages)
+	if len(messages) != 2 || messages[0].ReadAt == nil {
+		t.Fatalf("doctor sees %+v", messages)
+	}
+}
+
+func TestNotifications(t *testing.T) {
+	h := harness.New(t)
+	patient, patientProfile := h.Patient(t)
+	doctor, doctorProfile := h.Doctor(t)
+
+	// Browsers cannot set headers on an EventSource, so the stream takes
+	// the token in the query
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, h.Gateway.URL+"/api/notifications/stream?access_token="+patient.Token, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("stream status = %d", resp.StatusCode)
+	}
+	stream := bufio.NewReader(resp.Body)
+	nextEvent := func() (name, data string) {
+		t.Helper()
+		for {
+			line, err := stream.ReadString('\n')
+			if err != nil {
+				t.Fatalf("read stream: %v", err)
+			}
+			line = strings.TrimSuffix(line, "\n")
+			switch {
+			case strings.HasPrefix(line, "event: "):
+				name = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				data = strings.TrimPrefix(line, "data: ")
+			case line == "" && name != "":
+				return name, data
+			}
+		}
+	}
+	if name, data := nextEvent(); name != "unread" || data != `{"unread":0}` {
+		t.Fatalf("first event = %s %s", name, data)
+	}
+
+	patient.Do(http.MethodPost, "/api/patients/permissions/grant", map[string]string{"doctor_id": doctorProfile.ID}).Expect(t, http.StatusOK)
+	var notifications []models.Notification
+	doctor.Do(http.MethodGet, "/api/notifications?kind=access_granted", nil).Expect(t, http.StatusOK).Decode(t, &notifications)
+	if len(notifications) != 1 || notifications[0].Data["patient_id"] != patientProfile.ID {
+		t.Fatalf("doctor notifications = %+v", notifications)
+	}
+
+	doctor.Do(http.MethodGet, "/api/doctors/patients/view?patient_id="+patientProfile.ID, nil).Expect(t, http.StatusOK)
+	if name, data := nextEvent(); name != "notification" || !strings.Contains(data, `"kind":"record_viewed"`) {
+		t.Fatalf("pushed event = %s %s", name, data)
+	}
+	if name, data := nextEvent(); name != "unread" || data != `{"unread":1}` {
+		t.Fatalf("unread after push = %s %s", name, data)
+	}
+
+	patient.Do(http.MethodPost, "/api/notifications/read-all", nil).Expect(t, http.StatusOK)
+	if name, data := nextEvent(); name != "unread" || data != `{"unread":0}` {
+		t.Fatalf("unread after read-all = %s %s", name, data)
+	}
+}
+
+func TestWebhooks(t *testing.T) {
+	h := harness.New(t)
+	patient, patientProfile := h.Patient(t)
+	doctor, doctorProfile := h.Doctor(t)
+
+	type received struct {
+		header http.Header
+		body   []byte
+	}
+	requests := make(chan received, 4)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- received{r.Header, body}
+	}))
+	defer receiver.Close()
+	next := func() received {
+		t.Helper()
+		select {
+		case req := <-requests:
+			return req
+		case <-time.After(10 * time.Second):
+			t.Fatal("no webhook delivered")
+			return received{}
+		}
+	}
+
+	patient.Do(http.MethodPost, "/api/webhooks", map[string]interface{}{
+		"url": receiver.URL, "event_types": []string{webhooks.EventAccessGranted},
+	}).Expect(t, http.StatusForbidden)
+	var sub models.WebhookSubscription
+	doctor.Do(http.MethodPost, "/api/webhooks", map[string]interface{}{
+		"url": receiver.URL, "event_types": []string{webhooks.EventAccessGranted},
+	}).Expect(t, http.StatusCreated).Decode(t, &sub)
+
+	patient.Do(http.MethodPost, "/api/patients/permissions/grant", map[string]string{"doctor_id": doctorProfile.ID}).Expect(t, http.StatusOK)
+	first := next()
+	if err := webhooks.Verify(sub.Secret, first.header.Get(webhooks.HeaderSignature), first.body, time.Minute, time.Now()); err != nil {
+		t.Fatalf("signature: %v", err)
+	}
+	var event webhooks.Event
+	if err := json.Unmarshal(first.body, &event); err != nil || event.Type != webhooks.EventAccessGranted || event.Data["patient_id"] != patientProfile.ID {
+		t.Fatalf("event = %s, %v", first.body, err)
+	}
+
+	// The delivery log catches up once the dispatcher records the attempt
+	var deliveries []models.WebhookDelivery
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(50 * time.Millisecond) {
+		doctor.Do(http.MethodGet, "/api/webhooks/deliveries?status=succeeded", nil).Expect(t, http.StatusOK).Decode(t, &deliveries)
+		if len(deliveries) == 1 || time.Now().After(deadline) {
+			break
+		}
+	}
+	if len(deliveries) != 1 || deliveries[0].ID != first.header.Get(webhooks.HeaderDelivery) {
+		t.Fatalf("deliveries = %+v", deliveries)
+	}
+
+	patient.Do(http.MethodPost, "/api/webhooks/deliveries/replay?id="+deliveries[0].ID, nil).Expect(t, http.StatusNotFound)
+	doctor.Do(http.MethodPost, "/api/webhooks/deliveries/replay?id="+deliveries[0].ID, nil).Expect(t, http.StatusAccepted)
+	replayed := next()
+	if !bytes.Equal(replayed.body, first.body) || replayed.header.Get(webhooks.HeaderDelivery) == deliveries[0].ID {
+		t.Fatalf("replay = %s %s", replayed.header.Get(webhooks.HeaderDelivery), replayed.body)
+	}
+}
+
+func TestPatientDeletionCleansUp(t *testing.T) {
+	h := harness.New(t)
+	patient, patientProfile := h.Patient(t)
+	doctor, doctorProfile := h.Doctor(t)
+
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer receiver.Close()
+	doctor.Do(http.MethodPost, "/api/webhooks", map[string]interface{}{
+		"url": receiver.URL, "event_types": []string{webhooks.EventAccessGranted},
+	}).Expect(t, http.StatusCreated)
+	patient.Do(http.MethodPost, "/api/patients/permissions/grant", map[string]string{"doctor_id": doctorProfile.ID}).Expect(t, http.StatusOK)
+	prescription := h.Prescription(patient).Create(t)
+	file := filepath.Join(h.UploadDir, prescription.FilePath)
+	if _, err := os.Stat(file); err != nil {
+		t.Fatalf("uploaded file: %v", err)
+	}
+
+	patient.Do(http.MethodDelete, "/api/patients/profile", nil).Expect(t, http.StatusOK)
+	patient.Do(http.MethodGet, "/api/patients/profile", nil).Expect(t, http.StatusNotFound)
+
+	// The event reaches the other services once the relay publishes it
+	var deliveries []models.WebhookDelivery
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(50 * time.Millisecond) {
+		doctor.Do(http.MethodGet, "/api/webhooks/deliveries", nil).Expect(t, http.StatusOK).Decode(t, &deliveries)
+		_, err := os.Stat(file)
+		if (len(deliveries) == 0 && os.IsNotExist(err)) || time.Now().After(deadline) {
+			break
+		}
+	}
+	if len(deliveries) != 0 {
+		t.Fatalf("deliveries about patient %s left: %+v", patientProfile.ID, deliveries)
+	}
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Fatalf("uploaded file left: %v", err)
+	}
+
+	var pending int
+	if err := h.DB.Get(&pending, `SELECT COUNT(*) FROM outbox_events`); err != nil || pending != 0 {
+		t.Fatalf("outbox holds %d events, %v", pending, err)
+	}
+}
+
+func TestResumableUpload(t *testing.T) {
+	h := harness.New(t)
+	patient, _ := h.Patient(t)
+	content := "%PDF-1.4\n" + strings.Repeat("% page of an imaging report\n", 2000) + "%%EOF\n"
+	tus := func(headers ...string) map[string]string {
+		m := map[string]string{"Tus-Resumable": "1.0.0", "Content-Type": "application/offset+octet-stream"}
+		for i := 0; i+1 < len(headers); i += 2 {
+			m[headers[i]] = headers[i+1]
+		}
+		return m
+	}
+
+	created := patient.DoWithHeaders(http.MethodPost, "/api/prescriptions/uploads", nil, tus(
+		"Upload-Length", strconv.Itoa(len(content)),
+		"Upload-Metadata", "filename "+base64.StdEncoding.EncodeToString([]byte("report.pdf")),
+	)).Expect(t, http.StatusCreated)
+	location := created.Header.Get("Location")
+
+	half := len(content) / 2
+	patient.DoWithHeaders(http.MethodPatch, location, strings.NewReader(content[:half]), tus("Upload-Offset", "0")).Expect(t, http.StatusNoContent)
+	patient.DoWithHeaders(http.MethodPatch, location, strings.NewReader(content[:half]), tus("Upload-Offset", "0")).Expect(t, http.StatusConflict)
+	resumed := patient.DoWithHeaders(http.MethodHead, location, nil, tus()).Expect(t, http.StatusOK)
+	if resumed.Header.Get("Upload-Offset") != strconv.Itoa(half) {
+		t.Fatalf("Upload-Offset = %s, want %d", resumed.Header.Get("Upload-Offset"), half)
+	}
+
+	sum := sha256.Sum256([]byte(content[half:]))
+	done := patient.DoWithHeaders(http.MethodPatch, location, strings.NewReader(content[half:]), tus(
+		"Upload-Offset", strconv.Itoa(half),
+		"Upload-Checksum", "sha256 "+base64.StdEncoding.EncodeToString(sum[:]),
+	)).Expect(t, http.StatusNoContent)
+
+	download := patient.Do(http.MethodGet, "/api/prescriptions/download?id="+done.Header.Get("Prescription-ID"), nil).Expect(t, http.StatusOK)
+	if string(download.Body) != content {
+		t.Fatalf("downloaded %d bytes, uploaded %d", len(download.Body), len(content))
+	}
+
+	var chunks int
+	if err := h.DB.Get(&chunks, `SELECT COUNT(*) FROM upload_chunks`); err != nil || chunks != 0 {
+		t.Fatalf("%d chunk records left, %v", chunks, err)
+	}
+}
@@ -0,0 +1,246 @@
+package harness
+
+import (
+	"bytes"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"sync/atomic"
+	"testing"
+
+	"health-bar/shared/idempotency"
+	"health-bar/shared/models"
+)
+
+var sequence atomic.Int64
+
+func next() int64 { return sequence.Add(1) }
+
+// Actor is a registered user together with an authenticated client.
+type Actor struct {
+	*Client
+	User models.User
+}
+
+// UserBuilder registers users through the auth service.
+type UserBuilder struct {
+	h        *Harness
+	email    string
+	password string
+	role     models.UserRole
+}
+
+// User starts building a patient user with a unique email.
+func (h *Harness) User() *UserBuilder {
+	return &UserBuilder{
+		h:        h,
+		email:    fmt.Sprintf("user%d@test.local", next()),
+		password: "password123",
+		role:     models.RolePatient,
+	}
+}
+
+func (b *UserBuilder) Patient() *UserBuilder               { b.role = models.RolePatient; return b }
+func (b *UserBuilder) Doctor() *UserBuilder                { b.role = models.RoleDoctor; return b }
+func (b *UserBuilder) Email(email string) *UserBuilder     { b.email = email; return b }
+func (b *UserBuilder) Password(pw string) *UserBuilder     { b.password = pw; return b }
+func (b *UserBuilder) Role(r models.UserRole) *UserBuilder { b.role = r; return b }
+
+// Create registers the user and returns it logged in.
+func (b *UserBuilder) Create(t testing.TB) *Actor {
+	t.Helper()
+
+	resp := b.h.Client("").Do(http.MethodPost, "/api/auth/register", map[string]interface{}{
+		"email": b.email, "password": b.password, "role": b.role,
+	}).Expect(t, http.StatusCreated)
+
+	var auth struct {
+		Token string      `json:"token"`
+		User  models.User `json:"user"`
+	}
+	resp.Decode(t, &auth)
+	return &Actor{Client: b.h.Client(auth.Token), User: auth.User}
+}
+
+// PatientProfileBuilder creates patient profiles through the patient service.
+type PatientProfileBuilder struct {
+	actor *Actor
+	body  map[string]string
+}
+
+// PatientProfile starts building a profile owned by actor.
+func (h *Harness) PatientProfile(actor *Actor) *PatientProfileBuilder {
+	return &PatientProfileBuilder{actor: actor, body: map[string]string{
+		"full_name":     fmt.Sprintf("Patient %d", next()),
+		"date_of_birth": "1990-01-01",
+	}}
+}
+
+func (b *PatientProfileBuilder) Name(name string) *PatientProfileBuilder {
+	b.body["full_name"] = name
+	return b
+}
+func (b *PatientProfileBuilder) BornOn(date string) *PatientProfileBuilder {
+	b.body["date_of_birth"] = date
+	return b
+}
+func (b *PatientProfileBuilder) Gender(g string) *PatientProfileBuilder {
+	b.body["gender"] = g
+	return b
+}
+func (b *PatientProfileBuilder) Phone(p string) *PatientProfileBuilder { b.body["phone"] = p; return b }
+func (b *PatientProfileBuilder) Address(a string) *PatientProfileBuilder {
+	b.body["address"] = a
+	return b
+}
+
+func (b *PatientProfileBuilder) Create(t testing.TB) models.PatientProfile {
+	t.Helper()
+
+	var profile models.PatientProfile
+	b.actor.Do(http.MethodPost, "/api/patients/profile", b.body).Expect(t, http.StatusCreated).Decode(t, &profile)
+	return profile
+}
+
+// DoctorProfileBuilder creates doctor profiles through the doctor service.
+type DoctorProfileBuilder struct {
+	actor *Actor
+	body  map[string]string
+}
+
+// DoctorProfile starts building a profile owned by actor.
+func (h *Harness) DoctorProfile(actor *Actor) *DoctorProfileBuilder {
+	return &DoctorProfileBuilder{actor: actor, body: map[string]string{
+		"full_name":      fmt.Sprintf("Dr %d", next()),
+		"specialization": "General Practice",
+	}}
+}
+
+func (b *DoctorProfileBuilder) Name(name string) *DoctorProfileBuilder {
+	b.body["full_name"] = name
+	return b
+}
+func (b *DoctorProfileBuilder) Specialization(s string) *DoctorProfileBuilder {
+	b.body["specialization"] = s
+	return b
+}
+func (b *DoctorProfileBuilder) License(l string) *DoctorProfileBuilder {
+	b.body["license_number"] = l
+	return b
+}
+
+func (b *DoctorProfileBuilder) Create(t testing.TB) models.DoctorProfile {
+	t.Helper()
+
+	var profile models.DoctorProfile
+	b.actor.Do(http.MethodPost, "/api/doctors/profile", b.body).Expect(t, http.StatusCreated).Decode(t, &profile)
+	return profile
+}
+
+// VisitBuilder records hospital visits through the timeline service.
+type VisitBuilder struct {
+	actor *Actor
+	body  map[string]string
+	key   string
+}
+
+// Visit starts building a visit on actor's timeline.
+func (h *Harness) Visit(actor *Actor) *VisitBuilder {
+	return &VisitBuilder{actor: actor, body: map[string]string{
+		"hospital_name": "General Hospital",
+		"visit_date":    "2024-01-15",
+		"reason":        "Check-up",
+	}}
+}
+
+func (b *VisitBuilder) Hospital(name string) *VisitBuilder { b.body["hospital_name"] = name; return b }
+func (b *VisitBuilder) On(date string) *VisitBuilder       { b.body["visit_date"] = date; return b }
+func (b *VisitBuilder) Reason(r string) *VisitBuilder      { b.body["reason"] = r; return b }
+func (b *VisitBuilder) Notes(n string) *VisitBuilder       { b.body["notes"] = n; return b }
+
+// IdempotencyKey sends the create with an Idempotency-Key header
+func (b *VisitBuilder) IdempotencyKey(k string) *VisitBuilder { b.key = k; return b }
+
+func (b *VisitBuilder) Create(t testing.TB) models.HospitalVisit {
+	t.Helper()
+
+	var visit models.HospitalVisit
+	b.actor.DoWithHeaders(http.MethodPost, "/api/timeline/visits", b.body, idempotencyHeaders(b.key)).
+		Expect(t, http.StatusCreated).Decode(t, &visit)
+	return visit
+}
+
+// PrescriptionBuilder uploads prescription files through the prescription
+// service.
+type PrescriptionBuilder struct {
+	actor    *Actor
+	fileName string
+	content  []byte
+	key      string
+}
+
+// Prescription starts building an upload for actor. The default file is a
+// minimal valid PDF.
+func (h *Harness) Prescription(actor *Actor) *PrescriptionBuilder {
+	return &PrescriptionBuilder{
+		actor:    actor,
+		fileName: fmt.Sprintf("prescription-%d.pdf", next()),
+		content:  []byte("%PDF-1.4\n1 0 obj << /Type /Catalog >> endobj\ntrailer << /Root 1 0 R >>\n%%EOF\n"),
+	}
+}
+
+func (b *PrescriptionBuilder) File(name string, content []byte) *PrescriptionBuilder {
+	b.fileName, b.content = name, content
+	return b
+}
+
+// IdempotencyKey sends the upload with an Idempotency-Key header
+func (b *PrescriptionBuilder) IdempotencyKey(k string) *PrescriptionBuilder {
+	b.key = k
+	return b
+}
+
+func (b *PrescriptionBuilder) Create(t testing.TB) models.Prescription {
+	t.Helper()
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile("file", b.fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(b.content)
+	writer.Close()
+
+	headers := idempotencyHeaders(b.key)
+	headers["Content-Type"] = writer.FormDataContentType()
+
+	var prescription models.Prescription
+	b.actor.DoWithHeaders(http.MethodPost, "/api/prescriptions/upload", &body, headers).
+		Expect(t, http.StatusCreated).Decode(t, &prescription)
+	return prescription
+}
+
+func idempotencyHeaders(key string) map[string]string {
+	headers := map[string]string{}
+	if key != "" {
+		headers[idempotency.Header] = key
+	}
+	return headers
+}
+
+// Patient registers a patient user with a profile.
+func (h *Harness) Patient(t testing.TB) (*Actor, models.PatientProfile) {
+	t.Helper()
+
+	actor := h.User().Patient().Create(t)
+	return actor, h.PatientProfile(actor).Create(t)
+}
+
+// Doctor registers a doctor user with a profile.
+func (h *Harness) Doctor(t testing.TB) (*Actor, models.DoctorProfile) {
+	t.Helper()
+
+	actor := h.User().Doctor().Create(t)
+	return actor, h.DoctorProfile(actor).Create(t)
+}
@@ -0,0 +1,273 @@
+// Package harness boots every service in-process against an ephemeral
+// Postgres database, behind the real gateway, for end-to-end tests.
+package harness
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"health-bar/database/migrations"
+	"health-bar/shared/database/migrate"
+	"health-bar/shared/envelope"
+	"health-bar/shared/events"
+	"health-bar/shared/idempotency"
+	"health-bar/shared/notify"
+	"health-bar/shared/storage"
+	"health-bar/shared/utils"
+	"health-bar/shared/webhooks"
+
+	authhandlers "health-bar/services/auth/handlers"
+	authrepo "health-bar/services/auth/repository"
+	doctorhandlers "health-bar/services/doctor/handlers"
+	doctorrepo "health-bar/services/doctor/repository"
+	gatewayhandlers "health-bar/services/gateway/handlers"
+	"health-bar/services/notification/delivery"
+	notificationhandlers "health-bar/services/notification/handlers"
+	notificationrepo "health-bar/services/notification/repository"
+	patienthandlers "health-bar/services/patient/handlers"
+	patientrepo "health-bar/services/patient/repository"
+	prescriptionhandlers "health-bar/services/prescription/handlers"
+	prescriptionrepo "health-bar/services/prescription/repository"
+	timelinehandlers "health-bar/services/timeline/handlers"
+	timelinerepo "health-bar/services/timeline/repository"
+
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Harness is one isolated deployment: a migrated database, every service
+// on its own httptest server, and the gateway in front of them.
+type Harness struct {
+	DB        *sqlx.DB
+	Gateway   *httptest.Server
+	UploadDir string
+
+	t testing.TB
+}
+
+// New starts a deployment for t. It is torn down when t finishes.
+func New(t testing.TB) *Harness {
+	t.Helper()
+
+	// Registration hashes passwords; the production cost makes suites crawl
+	utils.PasswordHashCost = bcrypt.MinCost
+
+	db := NewDatabase(t)
+
+	all, err := migrate.Load(migrations.FS)
+	if err != nil {
+		t.Fatalf("load migrations: %v", err)
+	}
+	if _, err := migrate.New(db, all).Up(context.Background(), 0); err != nil {
+		t.Fatalf("apply migrations: %v", err)
+	}
+
+	h := &Harness{DB: db, UploadDir: t.TempDir(), t: t}
+	keys := idempotency.NewPostgresStore(db)
+	notifier := notify.NewPostgresNotifier(db)
+	emitter := webhooks.NewPostgresEmitter(db)
+	// The services share one bus, as they share NATS in production
+	bus := events.NewLocalBus()
+	inbox := events.NewPostgresInbox(db)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	auth := h.serve(func(router *mux.Router) {
+		authhandlers.RegisterRoutes(router, authhandlers.NewAuthHandler(authrepo.NewAuthRepository(db)))
+	})
+	patient := h.serve(func(router *mux.Router) {
+		handler := patienthandlers.NewPatientHandler(patientrepo.NewPatientRepository(db))
+		handler.UseNotifier(notifier)
+		handler.UseWebhooks(emitter)
+		outbox := events.NewPostgresOutbox(db)
+		handler.UseOutbox(outbox)
+		go events.NewRelay(outbox, bus).RunEvery(ctx, 100*time.Millisecond)
+		patienthandlers.RegisterRoutes(router, handler)
+	})
+	doctor := h.serve(func(router *mux.Router) {
+		handler := doctorhandlers.NewDoctorHandler(doctorrepo.NewDoctorRepository(db))
+		handler.UseNotifier(notifier)
+		handler.UseWebhooks(emitter)
+		doctorhandlers.RegisterRoutes(router, handler, keys)
+	})
+	timeline := h.serve(func(router *mux.Router) {
+		handler := timelinehandlers.NewTimelineHandler(timelinerepo.NewTimelineRepository(db))
+		handler.UseNotifier(notifier)
+		handler.UseWebhooks(emitter)
+		timelinehandlers.RegisterRoutes(router, handler, keys)
+	})
+	prescription := h.serve(func(router *mux.Router) {
+		dir, err := storage.NewFilesystem(h.UploadDir)
+		if err != nil {
+			t.Fatalf("open upload directory: %v", err)
+		}
+		masterKey, _ := envelope.GenerateKey("test")
+		masterKeys, err := envelope.ParseKeyring(masterKey)
+		if err != nil {
+			t.Fatalf("create encryption keys: %v", err)
+		}
+		files := storage.NewEncrypted(dir, masterKeys)
+		handler := prescriptionhandlers.NewPrescriptionHandler(prescriptionrepo.NewPrescriptionRepository(db), files)
+		handler.UseNotifier(notifier)
+		handler.UseWebhooks(emitter)
+		consumer := events.NewConsumer("prescription-service", inbox, bus)
+		handler.Subscribe(consumer)
+		if err := consumer.Start(ctx); err != nil {
+			t.Fatalf("subscribe prescription service: %v", err)
+		}
+		go consumer.RunEvery(ctx, 100*time.Millisecond)
+		prescriptionhandlers.RegisterRoutes(router, handler, keys)
+	})
+	notification := h.serve(func(router *mux.Router) {
+		repo := notificationrepo.NewNotificationRepository(db)
+		handler := notificationhandlers.NewNotificationHandler(repo)
+		// Test receivers listen on loopback
+		handler.UseWebhookAddresses(allowLoopback)
+		consumer := events.NewConsumer("notification-service", inbox, bus)
+		handler.Subscribe(consumer)
+		if err := consumer.Start(ctx); err != nil {
+			t.Fatalf("subscribe notification service: %v", err)
+		}
+		go consumer.RunEvery(ctx, 100*time.Millisecond)
+		go handler.Relay(ctx, 100*time.Millisecond)
+		dispatcher := delivery.NewDispatcher(repo)
+		dispatcher.UseAddresses(allowLoopback)
+		go dispatcher.RunEvery(ctx, 100*time.Millisecond)
+		notificationhandlers.RegisterRoutes(router, handler)
+	})
+
+	// The gateway runs without its rate limiter so suites can go faster
+	// than 10 requests per second.
+	proxy := gatewayhandlers.NewProxyHandler(gatewayhandlers.ServiceConfig{
+		AuthServiceURL:         auth.URL,
+		PatientServiceURL:      patient.URL,
+		DoctorServiceURL:       doctor.URL,
+		TimelineServiceURL:     timeline.URL,
+		PrescriptionServiceURL: prescription.URL,
+		NotificationServiceURL: notification.URL,
+	})
+	h.Gateway = h.serve(func(router *mux.Router) {
+		gatewayhandlers.RegisterRoutes(router, proxy)
+	})
+
+	return h
+}
+
+// allowLoopback lets webhooks reach the test's own receivers.
+func allowLoopback(addr netip.Addr) bool {
+	return addr.IsLoopback() || webhooks.Public(addr)
+}
+
+func (h *Harness) serve(register func(router *mux.Router)) *httptest.Server {
+	router := mux.NewRouter()
+	register(router)
+	srv := httptest.NewServer(router)
+	h.t.Cleanup(srv.Close)
+	return srv
+}
+
+// Client returns an API client that talks to the gateway, authenticated
+// with token when it is not empty.
+func (h *Harness) Client(token string) *Client {
+	return &Client{BaseURL: h.Gateway.URL, Token: token, t: h.t}
+}
+
+// Client sends requests through the gateway.
+type Client struct {
+	BaseURL string
+	Token   string
+
+	t testing.TB
+}
+
+// Response is a recorded API response.
+type Response struct {
+	Status   int
+	Header   http.Header
+	Body     []byte
+	Envelope utils.Response
+}
+
+// Do sends a request. body may be nil, an io.Reader, or a value to encode as
+// JSON.
+func (c *Client) Do(method, path string, body interface{}) *Response {
+	c.t.Helper()
+	return c.DoWithHeaders(method, path, body, nil)
+}
+
+// DoWithHeaders is Do with extra request headers.
+func (c *Client) DoWithHeaders(method, path string, body interface{}, headers map[string]string) *Response {
+	c.t.Helper()
+
+	var reader io.Reader
+	contentType := "application/json"
+	switch b := body.(type) {
+	case nil:
+	case io.Reader:
+		reader = b
+	default:
+		encoded, err := json.Marshal(b)
+		if err != nil {
+			c.t.Fatalf("encode request body: %v", err)
+		}
+		reader = bytes.NewReader(encoded)
+	}
+
+	req, err := http.NewRequest(method, c.BaseURL+path, reader)
+	if err != nil {
+		c.t.Fatalf("build request: %v", err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		c.t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		c.t.Fatalf("read response: %v", err)
+	}
+
+	recorded := &Response{Status: resp.StatusCode, Header: resp.Header, Body: data}
+	json.Unmarshal(data, &recorded.Envelope)
+	return recorded
+}
+
+// Expect fails the test unless the response has the given status.
+func (r *Response) Expect(t testing.TB, status int) *Response {
+	t.Helper()
+
+	if r.Status != status {
+		t.Fatalf("status = %d, want %d (body: %s)", r.Status, status, r.Body)
+	}
+	return r
+}
+
+// Decode decodes the envelope's data field into dest.
+func (r *Response) Decode(t testing.TB, dest interface{}) {
+	t.Helper()
+
+	encoded, err := json.Marshal(r.Envelope.Data)
+	if err != nil {
+		t.Fatalf("encode data: %v", err)
+	}
+	if err := json.Unmarshal(encoded, dest); err != nil {
+		t.Fatalf("decode data %s: %v", encoded, err)
+	}
+}
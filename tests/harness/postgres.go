@@ -0,0 +1,186 @@
+package harness
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/jmoiron/sqlx"
+)
+
+// DatabaseURLEnv points the harness at an existing Postgres server instead
+// of starting one. Each test still gets its own freshly created database.
+const DatabaseURLEnv = "HEALTHBAR_TEST_DATABASE_URL"
+
+// PostgresBinEnv names a directory containing initdb and pg_ctl. Without it
+// the binaries are looked up on PATH.
+const PostgresBinEnv = "PG_BIN_DIR"
+
+type server struct {
+	config  *pgx.ConnConfig
+	dataDir string
+	pgCtl   string
+	skip    string
+}
+
+var (
+	serverOnce sync.Once
+	shared     *server
+)
+
+// Main wraps testing.M so an ephemeral server started for the package is
+// stopped once every test has run:
+//
+//	func TestMain(m *testing.M) { os.Exit(harness.Main(m)) }
+func Main(m *testing.M) int {
+	code := m.Run()
+	if shared != nil && shared.dataDir != "" {
+		exec.Command(shared.pgCtl, "-D", shared.dataDir, "-m", "immediate", "stop").Run()
+		os.RemoveAll(shared.dataDir)
+	}
+	return code
+}
+
+// NewDatabase creates an empty database for the calling test and drops it
+// when the test finishes. The test is skipped when no Postgres is available.
+func NewDatabase(t testing.TB) *sqlx.DB {
+	t.Helper()
+
+	serverOnce.Do(func() { shared = startServer() })
+	if shared.skip != "" {
+		t.Skip(shared.skip)
+	}
+
+	admin, err := open(shared.config, shared.config.Database)
+	if err != nil {
+		t.Fatalf("connect to postgres: %v", err)
+	}
+	defer admin.Close()
+
+	name := "healthbar_test_" + randomHex(6)
+	if _, err := admin.Exec(`CREATE DATABASE ` + name); err != nil {
+		t.Fatalf("create database: %v", err)
+	}
+
+	db, err := open(shared.config, name)
+	if err != nil {
+		t.Fatalf("connect to %s: %v", name, err)
+	}
+
+	t.Cleanup(func() {
+		db.Close()
+		if admin, err := open(shared.config, shared.config.Database); err == nil {
+			admin.Exec(`DROP DATABASE IF EXISTS ` + name + ` WITH (FORCE)`)
+			admin.Close()
+		}
+	})
+	return db
+}
+
+func open(config *pgx.ConnConfig, database string) (*sqlx.DB, error) {
+	cfg := config.Copy()
+	cfg.Database = database
+
+	db := sqlx.NewDb(stdlib.OpenDB(*cfg), "pgx")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// startServer connects to DatabaseURLEnv when set, otherwise runs initdb and
+// pg_ctl from a local Postgres installation in a temporary directory.
+func startServer() *server {
+	if url := os.Getenv(DatabaseURLEnv); url != "" {
+		config, err := pgx.ParseConfig(url)
+		if err != nil {
+			return &server{skip: fmt.Sprintf("invalid %s: %v", DatabaseURLEnv, err)}
+		}
+		return &server{config: config}
+	}
+
+	initdb, pgCtl := findBinary("initdb"), findBinary("pg_ctl")
+	if initdb == "" || pgCtl == "" {
+		return &server{skip: fmt.Sprintf("postgres not available: set %s or put initdb/pg_ctl on PATH (or in %s)", DatabaseURLEnv, PostgresBinEnv)}
+	}
+	if os.Geteuid() == 0 {
+		return &server{skip: fmt.Sprintf("initdb refuses to run as root; set %s instead", DatabaseURLEnv)}
+	}
+
+	dataDir, err := os.MkdirTemp("", "healthbar-pg-")
+	if err != nil {
+		return &server{skip: err.Error()}
+	}
+
+	out, err := exec.Command(initdb, "-D", dataDir, "-U", "postgres", "-A", "trust", "-E", "UTF8", "--no-sync").CombinedOutput()
+	if err != nil {
+		os.RemoveAll(dataDir)
+		return &server{skip: fmt.Sprintf("initdb failed: %v\n%s", err, out)}
+	}
+
+	port, err := freePort()
+	if err != nil {
+		os.RemoveAll(dataDir)
+		return &server{skip: err.Error()}
+	}
+
+	options := fmt.Sprintf("-p %d -c listen_addresses=127.0.0.1 -c unix_socket_directories='' -c fsync=off -c full_page_writes=off", port)
+	out, err = exec.Command(pgCtl, "-D", dataDir, "-o", options, "-l", filepath.Join(dataDir, "server.log"), "-w", "start").CombinedOutput()
+	if err != nil {
+		os.RemoveAll(dataDir)
+		return &server{skip: fmt.Sprintf("pg_ctl start failed: %v\n%s", err, out)}
+	}
+
+	config, err := pgx.ParseConfig(fmt.Sprintf("host=127.0.0.1 port=%d user=postgres dbname=postgres sslmode=disable", port))
+	if err != nil {
+		return &server{skip: err.Error(), dataDir: dataDir, pgCtl: pgCtl}
+	}
+	return &server{config: config, dataDir: dataDir, pgCtl: pgCtl}
+}
+
+func findBinary(name string) string {
+	if dir := os.Getenv(PostgresBinEnv); dir != "" {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	if path, err := exec.LookPath(name); err == nil {
+		return path
+	}
+
+	// Debian/Ubuntu packages keep the server binaries off PATH
+	matches, _ := filepath.Glob("/usr/lib/postgresql/*/bin/" + name)
+	if len(matches) > 0 {
+		return matches[len(matches)-1]
+	}
+	return ""
+}
+
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}